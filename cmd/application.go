@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/bloodsteel/easynetes/internal/service/agenthub"
+	"github.com/bloodsteel/easynetes/internal/service/approval"
+	"github.com/bloodsteel/easynetes/internal/service/cmdbhook"
+	"github.com/bloodsteel/easynetes/internal/service/cron"
+	"github.com/bloodsteel/easynetes/internal/service/gitlab"
+	"github.com/bloodsteel/easynetes/internal/service/hook"
+	"github.com/bloodsteel/easynetes/internal/service/jenkins"
+	"github.com/bloodsteel/easynetes/internal/service/kube"
+	"github.com/bloodsteel/easynetes/internal/service/metrics"
+	"github.com/bloodsteel/easynetes/internal/service/notify"
+	"github.com/bloodsteel/easynetes/internal/service/pipeline"
+	"github.com/bloodsteel/easynetes/internal/service/queue"
+	"github.com/bloodsteel/easynetes/internal/service/rollout"
+	"github.com/bloodsteel/easynetes/internal/service/stream"
+	"github.com/bloodsteel/easynetes/internal/service/terminal"
+	"github.com/bloodsteel/easynetes/pkg/server"
+)
+
+// runner 表示一个需要在后台一直运行, 直到 ctx 结束才退出的服务
+type runner interface {
+	Run(ctx context.Context) error
+}
+
+// application 包含 http server 以及所有需要在后台运行的服务
+type application struct {
+	server  *server.Server
+	runners []runner
+}
+
+// newApplication is a Wire provider
+func newApplication(
+	srv *server.Server,
+	hub *agenthub.Hub,
+	orchestrator *rollout.Orchestrator,
+	terminals *terminal.Manager,
+	metrics *metrics.Service,
+	registry *kube.Registry,
+	namespaces *kube.Namespaces,
+	workloads *kube.Workloads,
+	nodeSync *kube.NodeSync,
+	events *kube.EventWatcher,
+	access *kube.Access,
+	gitlab *gitlab.Service,
+	jenkins *jenkins.Service,
+	receiver *hook.Receiver,
+	pipelines *pipeline.Service,
+	approvals *approval.Service,
+	notifier *notify.Service,
+	cmdbHooks *cmdbhook.Service,
+	broker *stream.Broker,
+	cronJobs *cron.Service,
+	tasks *queue.Queue,
+) *application {
+	return &application{
+		server: srv,
+		runners: []runner{
+			hub,
+			orchestrator,
+			terminals,
+			metrics,
+			registry,
+			namespaces,
+			workloads,
+			nodeSync,
+			events,
+			access,
+			gitlab,
+			jenkins,
+			receiver,
+			pipelines,
+			approvals,
+			notifier,
+			cmdbHooks,
+			broker,
+			cronJobs,
+			tasks,
+		},
+	}
+}
@@ -1,7 +1,12 @@
 package main
 
-import "fmt"
+import (
+	"github.com/bloodsteel/easynetes/pkg/log"
+)
 
 func main() {
-	fmt.Println("easynetes agent!")
+	EasynetesAgentCmd := EasynetesAgent()
+	if err := EasynetesAgentCmd.Execute(); err != nil {
+		log.Errorf("exectu error: %v", err)
+	}
 }
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/agent"
+	"github.com/bloodsteel/easynetes/internal/agent/proto"
+	"github.com/bloodsteel/easynetes/pkg/config"
+	"github.com/bloodsteel/easynetes/pkg/log"
+	"github.com/bloodsteel/easynetes/pkg/signal"
+	"github.com/spf13/cobra"
+)
+
+var (
+	author         = []byte{10, 110, 98, 119, 104, 103, 64, 98, 108, 111, 111, 100, 115, 116, 101, 101, 108, 10, 10}
+	loggingOptions = log.DefaultOptions()
+	agentCfg       = &config.AgentConfig{}
+	configFile     string
+)
+
+var (
+	// Version 当前运行的版本, agent 自升级时根据版本选择二进制文件
+	Version = "dev"
+	// Branch 当前运行的branch
+	Branch string
+	// Commit 当前运行的commit
+	Commit string
+	// BuildTime 构建时间
+	BuildTime string
+)
+
+// EasynetesAgent 返回 easynetes-agent 命令
+func EasynetesAgent() *cobra.Command {
+	easynetesAgentCmd := &cobra.Command{
+		Use:               "easynetes-agent",
+		Short:             "easynetes host agent.",
+		Long:              "easynetes host agent, keeps a stream connection to easynetes-api and executes its instructions.",
+		SilenceUsage:      true,
+		DisableAutoGenTag: true,
+		Version:           Version,
+		Args:              cobra.ExactArgs(0),
+		Example:           "easynetes-agent run --config /etc/easynetes/agent.yaml",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := log.Configure(loggingOptions); err != nil {
+				return err
+			}
+			return agentCfg.Load(configFile)
+		},
+	}
+	easynetesAgentCmd.AddCommand(agentRunCmd(), agentStatusCmd(), agentEnrollCmd())
+
+	// 禁用 completion 子命令
+	easynetesAgentCmd.CompletionOptions.DisableDefaultCmd = true
+
+	// attach 日志的flag 到 指定的 cmd
+	loggingOptions.AttachCobraFlags(easynetesAgentCmd)
+
+	easynetesAgentCmd.PersistentFlags().StringVarP(&configFile, "config", "c", config.DefaultAgentConfigFile, "Set configuration file path")
+
+	// 添加go原生flag
+	easynetesAgentCmd.PersistentFlags().AddGoFlagSet(flag.CommandLine)
+
+	// 设置命令输出模板
+	versionTemplate := easynetesAgentCmd.VersionTemplate()
+	helpTemplate := easynetesAgentCmd.HelpTemplate()
+	easynetesAgentCmd.SetHelpTemplate(helpTemplate + string(author))
+	easynetesAgentCmd.SetVersionTemplate(versionTemplate + string(author))
+
+	// 兼容旧版本没有子命令的启动参数, 例如 systemd unit 中的 easynetes-agent -c <file>
+	easynetesAgentCmd.SetArgs(agent.RunArgs(os.Args[1:]))
+	return easynetesAgentCmd
+}
+
+func agentRunCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "run",
+		Short: "Connect to easynetes-api and run the agent until terminated.",
+		Args:  cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			log.WithLabels(
+				"git_branch", Branch,
+				"git_commit", Commit,
+				"built_time", BuildTime,
+				"version", Version,
+			).Info("easynetes-agent is starting...")
+
+			// set root ctx & listen os signal
+			ctx := signal.WithContextFunc(context.Background(), func() {
+				log.Info("sync log...")
+				_ = log.Sync()
+			})
+
+			a, err := agent.New(agent.Config{
+				Server:            agentCfg.Server,
+				Token:             agentCfg.Token,
+				HeartbeatInterval: agentCfg.HeartbeatInterval * time.Second,
+				MetricsInterval:   agentCfg.MetricsInterval * time.Second,
+				MetricsBatch:      agentCfg.MetricsBatch,
+				QueueDir:          agentCfg.QueueDir,
+				QueueSize:         agentCfg.QueueSize,
+				StatusSocket:      agentCfg.StatusSocket,
+			}, buildInfo())
+			if err != nil {
+				log.WithLabels("error", err).Fatal("cannot initialize agent")
+			}
+			if err := a.Run(ctx); err != nil {
+				log.WithLabels("error", err).Error("agent terminated")
+			}
+			return nil
+		},
+	}
+}
+
+func agentStatusCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "Show the state of the running agent through its local status socket.",
+		Args:  cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			status, err := agent.QueryStatus(cmd.Context(), agentCfg.StatusSocket)
+			if err != nil {
+				return fmt.Errorf("cannot query agent status from %s: %w", agentCfg.StatusSocket, err)
+			}
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "Version:         %s\n", status.Version)
+			fmt.Fprintf(out, "Instance ID:     %s\n", status.InstanceID)
+			fmt.Fprintf(out, "Server:          %s\n", status.Server)
+			fmt.Fprintf(out, "Connected:       %t\n", status.Connected)
+			fmt.Fprintf(out, "Last connected:  %s\n", formatTime(status.LastConnected))
+			fmt.Fprintf(out, "Last heartbeat:  %s\n", formatTime(status.LastHeartbeat))
+			if status.LastError != "" {
+				fmt.Fprintf(out, "Last error:      %s\n", status.LastError)
+			}
+			fmt.Fprintf(out, "Queue depth:     %d\n", status.QueueDepth)
+			fmt.Fprintf(out, "Queue dropped:   %d\n", status.QueueDropped)
+			return nil
+		},
+	}
+}
+
+func agentEnrollCmd() *cobra.Command {
+	var server, token string
+	cmd := &cobra.Command{
+		Use:     "enroll",
+		Short:   "Verify the apiserver address and token, then save them to the configuration file.",
+		Args:    cobra.ExactArgs(0),
+		Example: "easynetes-agent enroll --server https://easynetes.example.com --token <agent token>",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if server != "" {
+				agentCfg.Server = server
+			}
+			if token != "" {
+				agentCfg.Token = token
+			}
+			ctx, cancel := context.WithTimeout(cmd.Context(), 30*time.Second)
+			defer cancel()
+			if err := agent.Verify(ctx, agentCfg.Server, agentCfg.Token); err != nil {
+				return fmt.Errorf("cannot connect to %s: %w", agentCfg.Server, err)
+			}
+			if err := agentCfg.Save(configFile); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "enrolled with %s, configuration saved to %s\n", agentCfg.Server, configFile)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&server, "server", "", "easynetes apiserver address")
+	cmd.Flags().StringVar(&token, "token", os.Getenv("EASYNETES_AGENT_TOKEN"), "agent token used to connect to apiserver")
+	return cmd
+}
+
+func buildInfo() proto.BuildInfo {
+	return proto.BuildInfo{
+		Version:   Version,
+		Branch:    Branch,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "never"
+	}
+	return fmt.Sprintf("%s (%s ago)", t.Format(time.RFC3339), time.Since(*t).Truncate(time.Second))
+}
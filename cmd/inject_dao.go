@@ -4,7 +4,22 @@ import (
 	"fmt"
 	"net/url"
 
+	"github.com/bloodsteel/easynetes/internal/dao/agent"
+	"github.com/bloodsteel/easynetes/internal/dao/app"
+	"github.com/bloodsteel/easynetes/internal/dao/approval"
+	"github.com/bloodsteel/easynetes/internal/dao/cmdbhook"
+	"github.com/bloodsteel/easynetes/internal/dao/cron"
+	"github.com/bloodsteel/easynetes/internal/dao/gitlab"
+	"github.com/bloodsteel/easynetes/internal/dao/hook"
 	"github.com/bloodsteel/easynetes/internal/dao/host"
+	"github.com/bloodsteel/easynetes/internal/dao/jenkins"
+	"github.com/bloodsteel/easynetes/internal/dao/kubernetes"
+	"github.com/bloodsteel/easynetes/internal/dao/metric"
+	"github.com/bloodsteel/easynetes/internal/dao/notify"
+	"github.com/bloodsteel/easynetes/internal/dao/pipeline"
+	"github.com/bloodsteel/easynetes/internal/dao/rbac"
+	"github.com/bloodsteel/easynetes/internal/dao/task"
+	"github.com/bloodsteel/easynetes/internal/dao/terminal"
 	"github.com/bloodsteel/easynetes/internal/dao/user"
 	"github.com/bloodsteel/easynetes/pkg/config"
 	"github.com/bloodsteel/easynetes/pkg/db"
@@ -17,6 +32,42 @@ var daoSet = wire.NewSet(
 	provideDatabase,
 	user.ProvideUserDao,
 	host.ProvideHostDao,
+	agent.ProvideAgentDao,
+	agent.ProvideAgentBinaryDao,
+	agent.ProvideAgentRolloutDao,
+	agent.ProvideAgentMessageDao,
+	rbac.ProvideGrantDao,
+	terminal.ProvideTerminalSessionDao,
+	metric.ProvideMetricDao,
+	kubernetes.ProvideKubeClusterDao,
+	kubernetes.ProvideKubeNamespaceDao,
+	kubernetes.ProvideKubeQuotaTemplateDao,
+	kubernetes.ProvideKubeWorkloadActionDao,
+	kubernetes.ProvideKubeApplyPlanDao,
+	kubernetes.ProvideKubeEventDao,
+	kubernetes.ProvideKubeAccessGrantDao,
+	app.ProvideApplicationDao,
+	app.ProvideAppEnvironmentDao,
+	app.ProvideAppReleaseDao,
+	gitlab.ProvideGitlabConnectionDao,
+	gitlab.ProvideGitlabProjectDao,
+	jenkins.ProvideJenkinsServerDao,
+	jenkins.ProvideJenkinsJobDao,
+	jenkins.ProvideJenkinsBuildDao,
+	hook.ProvideHookEventDao,
+	pipeline.ProvidePipelineDao,
+	pipeline.ProvidePipelineRunDao,
+	approval.ProvideApprovalRuleDao,
+	approval.ProvideApprovalRequestDao,
+	notify.ProvideNotifyChannelDao,
+	notify.ProvideNotifySubscriptionDao,
+	notify.ProvideNotifyTemplateDao,
+	notify.ProvideNotifyDeliveryDao,
+	cmdbhook.ProvideCMDBHookDao,
+	cmdbhook.ProvideCMDBHookDeliveryDao,
+	cron.ProvideCronJobDao,
+	cron.ProvideCronRunDao,
+	task.ProvideTaskDao,
 )
 
 // provideDatabase is a Wire provider
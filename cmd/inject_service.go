@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/bloodsteel/easynetes/internal/service/agenthub"
+	"github.com/bloodsteel/easynetes/internal/service/approval"
+	"github.com/bloodsteel/easynetes/internal/service/cmdbhook"
+	"github.com/bloodsteel/easynetes/internal/service/cron"
+	"github.com/bloodsteel/easynetes/internal/service/eventbus"
+	"github.com/bloodsteel/easynetes/internal/service/gitlab"
+	"github.com/bloodsteel/easynetes/internal/service/hook"
+	"github.com/bloodsteel/easynetes/internal/service/hostexec"
+	"github.com/bloodsteel/easynetes/internal/service/jenkins"
+	"github.com/bloodsteel/easynetes/internal/service/kube"
+	"github.com/bloodsteel/easynetes/internal/service/metrics"
+	"github.com/bloodsteel/easynetes/internal/service/notify"
+	"github.com/bloodsteel/easynetes/internal/service/pipeline"
+	"github.com/bloodsteel/easynetes/internal/service/queue"
+	"github.com/bloodsteel/easynetes/internal/service/rbac"
+	"github.com/bloodsteel/easynetes/internal/service/release"
+	"github.com/bloodsteel/easynetes/internal/service/rollout"
+	"github.com/bloodsteel/easynetes/internal/service/stream"
+	"github.com/bloodsteel/easynetes/internal/service/terminal"
+	"github.com/bloodsteel/easynetes/pkg/config"
+	"github.com/bloodsteel/easynetes/pkg/encrypt"
+	"github.com/google/wire"
+)
+
+var serviceSet = wire.NewSet(
+	agenthub.ProvideHub,
+	rollout.ProvideOrchestrator,
+	rbac.ProvideAuthorizer,
+	terminal.ProvideManager,
+	metrics.ProvideService,
+	provideEncrypter,
+	kube.ProvideRegistry,
+	kube.ProvideNamespaces,
+	kube.ProvideWorkloads,
+	kube.ProvidePods,
+	kube.ProvideApplier,
+	kube.ProvideNodeSync,
+	kube.ProvideEventWatcher,
+	kube.ProvideAccess,
+	release.ProvideService,
+	gitlab.ProvideService,
+	jenkins.ProvideService,
+	eventbus.ProvideBus,
+	hook.ProvideReceiver,
+	hostexec.ProvideExecutor,
+	pipeline.ProvideService,
+	approval.ProvideService,
+	notify.ProvideService,
+	cmdbhook.ProvideService,
+	stream.ProvideBroker,
+	cron.ProvideService,
+	queue.ProvideQueue,
+	newApplication,
+)
+
+// provideEncrypter is a Wire provider
+// returns an encrypter for credentials stored in database, security.encryption_key is required
+func provideEncrypter(cfg *config.Config) (*encrypt.Encrypter, error) {
+	if cfg.Security.EncryptionKey == "" {
+		return nil, errors.New("security.encryption_key is required to encrypt credentials stored in database")
+	}
+	return encrypt.New(cfg.Security.EncryptionKey)
+}
@@ -73,8 +73,14 @@ func EasynetesAPI() *cobra.Command {
 					"read_timeout", cfg.Server.ReadTimeout,
 					"write_timeout", cfg.Server.WriteTimeout,
 				).Info("starting the http server")
-				return app.ListenAndServe(ctx)
+				return app.server.ListenAndServe(ctx)
 			})
+			for _, r := range app.runners {
+				r := r
+				g.Go(func() error {
+					return r.Run(ctx)
+				})
+			}
 			if err := g.Wait(); err != nil {
 				log.WithLabels("error", err).Error("program terminated")
 			}
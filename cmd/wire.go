@@ -7,15 +7,15 @@ package cmd
 
 import (
 	"github.com/bloodsteel/easynetes/pkg/config"
-	"github.com/bloodsteel/easynetes/pkg/server"
 	"github.com/google/wire"
 )
 
 // InitializeApplication 初始化app, 用来启动http server
-func InitializeApplication(c *config.Config) (*server.Server, error) {
+func InitializeApplication(c *config.Config) (*application, error) {
 	wire.Build(
 		daoSet,
+		serviceSet,
 		serverSet,
 	)
-	return &server.Server{}, nil
+	return &application{}, nil
 }
@@ -7,27 +7,130 @@
 package cmd
 
 import (
+	"github.com/bloodsteel/easynetes/internal/dao/agent"
+	"github.com/bloodsteel/easynetes/internal/dao/app"
+	"github.com/bloodsteel/easynetes/internal/dao/approval"
+	"github.com/bloodsteel/easynetes/internal/dao/cmdbhook"
+	"github.com/bloodsteel/easynetes/internal/dao/cron"
+	"github.com/bloodsteel/easynetes/internal/dao/gitlab"
+	"github.com/bloodsteel/easynetes/internal/dao/hook"
 	"github.com/bloodsteel/easynetes/internal/dao/host"
+	"github.com/bloodsteel/easynetes/internal/dao/jenkins"
+	"github.com/bloodsteel/easynetes/internal/dao/kubernetes"
+	"github.com/bloodsteel/easynetes/internal/dao/metric"
+	"github.com/bloodsteel/easynetes/internal/dao/notify"
+	"github.com/bloodsteel/easynetes/internal/dao/pipeline"
+	"github.com/bloodsteel/easynetes/internal/dao/rbac"
+	"github.com/bloodsteel/easynetes/internal/dao/task"
+	"github.com/bloodsteel/easynetes/internal/dao/terminal"
 	"github.com/bloodsteel/easynetes/internal/dao/user"
 	"github.com/bloodsteel/easynetes/internal/handler/api"
 	"github.com/bloodsteel/easynetes/internal/handler/health"
+	"github.com/bloodsteel/easynetes/internal/service/agenthub"
+	approval2 "github.com/bloodsteel/easynetes/internal/service/approval"
+	cmdbhook2 "github.com/bloodsteel/easynetes/internal/service/cmdbhook"
+	cron2 "github.com/bloodsteel/easynetes/internal/service/cron"
+	"github.com/bloodsteel/easynetes/internal/service/eventbus"
+	gitlab2 "github.com/bloodsteel/easynetes/internal/service/gitlab"
+	hook2 "github.com/bloodsteel/easynetes/internal/service/hook"
+	"github.com/bloodsteel/easynetes/internal/service/hostexec"
+	jenkins2 "github.com/bloodsteel/easynetes/internal/service/jenkins"
+	"github.com/bloodsteel/easynetes/internal/service/kube"
+	"github.com/bloodsteel/easynetes/internal/service/metrics"
+	notify2 "github.com/bloodsteel/easynetes/internal/service/notify"
+	pipeline2 "github.com/bloodsteel/easynetes/internal/service/pipeline"
+	"github.com/bloodsteel/easynetes/internal/service/queue"
+	rbac2 "github.com/bloodsteel/easynetes/internal/service/rbac"
+	"github.com/bloodsteel/easynetes/internal/service/release"
+	"github.com/bloodsteel/easynetes/internal/service/rollout"
+	"github.com/bloodsteel/easynetes/internal/service/stream"
+	terminal2 "github.com/bloodsteel/easynetes/internal/service/terminal"
 	"github.com/bloodsteel/easynetes/pkg/config"
-	"github.com/bloodsteel/easynetes/pkg/server"
 )
 
 // Injectors from wire.go:
 
 // InitializeApplication 初始化app, 用来启动http server
-func InitializeApplication(c *config.Config) (*server.Server, error) {
+func InitializeApplication(c *config.Config) (*application, error) {
 	db, err := provideDatabase(c)
 	if err != nil {
 		return nil, err
 	}
 	userDao := user.ProvideUserDao(db)
-	hostInstanceDao := host.ProvideHostDao(db)
-	apiServer := api.ProvideAPI(userDao, hostInstanceDao)
+	bus := eventbus.ProvideBus()
+	hostInstanceDao := host.ProvideHostDao(db, bus)
+	agentDao := agent.ProvideAgentDao(db, bus)
+	agentBinaryDao := agent.ProvideAgentBinaryDao(db)
+	agentRolloutDao := agent.ProvideAgentRolloutDao(db)
+	agentMessageDao := agent.ProvideAgentMessageDao(db)
+	hub := agenthub.ProvideHub(agentDao, agentMessageDao, c)
+	orchestrator := rollout.ProvideOrchestrator(agentDao, agentBinaryDao, agentRolloutDao, hub, c)
+	grantDao := rbac.ProvideGrantDao(db)
+	authorizer := rbac2.ProvideAuthorizer(grantDao)
+	terminalSessionDao := terminal.ProvideTerminalSessionDao(db)
+	manager := terminal2.ProvideManager(hub, terminalSessionDao, c)
+	metricDao := metric.ProvideMetricDao(db)
+	service := metrics.ProvideService(hub, metricDao, c)
+	kubeClusterDao := kubernetes.ProvideKubeClusterDao(db)
+	encrypter, err := provideEncrypter(c)
+	if err != nil {
+		return nil, err
+	}
+	registry := kube.ProvideRegistry(kubeClusterDao, encrypter, c)
+	kubeNamespaceDao := kubernetes.ProvideKubeNamespaceDao(db)
+	kubeQuotaTemplateDao := kubernetes.ProvideKubeQuotaTemplateDao(db)
+	namespaces := kube.ProvideNamespaces(registry, kubeNamespaceDao, kubeQuotaTemplateDao, c)
+	kubeWorkloadActionDao := kubernetes.ProvideKubeWorkloadActionDao(db)
+	workloads := kube.ProvideWorkloads(registry, kubeWorkloadActionDao, c)
+	pods := kube.ProvidePods(registry)
+	kubeApplyPlanDao := kubernetes.ProvideKubeApplyPlanDao(db)
+	applier := kube.ProvideApplier(registry, kubeApplyPlanDao, authorizer, c)
+	kubeEventDao := kubernetes.ProvideKubeEventDao(db)
+	kubeAccessGrantDao := kubernetes.ProvideKubeAccessGrantDao(db)
+	access := kube.ProvideAccess(registry, kubeClusterDao, kubeAccessGrantDao, c)
+	applicationDao := app.ProvideApplicationDao(db)
+	appEnvironmentDao := app.ProvideAppEnvironmentDao(db)
+	appReleaseDao := app.ProvideAppReleaseDao(db)
+	releaseService := release.ProvideService(applicationDao, appEnvironmentDao, appReleaseDao, authorizer, applier)
+	gitlabConnectionDao := gitlab.ProvideGitlabConnectionDao(db)
+	gitlabProjectDao := gitlab.ProvideGitlabProjectDao(db)
+	gitlabService := gitlab2.ProvideService(gitlabConnectionDao, gitlabProjectDao, encrypter, bus, c)
+	jenkinsServerDao := jenkins.ProvideJenkinsServerDao(db)
+	jenkinsJobDao := jenkins.ProvideJenkinsJobDao(db)
+	jenkinsBuildDao := jenkins.ProvideJenkinsBuildDao(db)
+	jenkinsService := jenkins2.ProvideService(jenkinsServerDao, jenkinsJobDao, jenkinsBuildDao, authorizer, encrypter, bus, c)
+	hookEventDao := hook.ProvideHookEventDao(db)
+	receiver := hook2.ProvideReceiver(hookEventDao, bus, c)
+	pipelineDao := pipeline.ProvidePipelineDao(db)
+	pipelineRunDao := pipeline.ProvidePipelineRunDao(db, bus)
+	approvalRequestDao := approval.ProvideApprovalRequestDao(db)
+	approvalRuleDao := approval.ProvideApprovalRuleDao(db)
+	taskDao := task.ProvideTaskDao(db, bus)
+	executor := hostexec.ProvideExecutor(hub, agentDao, hostInstanceDao)
+	nodeSync := kube.ProvideNodeSync(registry, kubeClusterDao, hostInstanceDao, c)
+	queueQueue := queue.ProvideQueue(taskDao, hostInstanceDao, bus, executor, nodeSync, c)
+	approvalService := approval2.ProvideService(approvalRuleDao, approvalRequestDao, userDao, hostInstanceDao, applicationDao, appEnvironmentDao, appReleaseDao, kubeNamespaceDao, authorizer, bus, namespaces, releaseService, queueQueue, c)
+	pipelineService := pipeline2.ProvideService(pipelineDao, pipelineRunDao, jenkinsBuildDao, userDao, approvalRequestDao, authorizer, bus, jenkinsService, releaseService, approvalService, executor, c)
+	notifyChannelDao := notify.ProvideNotifyChannelDao(db)
+	notifySubscriptionDao := notify.ProvideNotifySubscriptionDao(db)
+	notifyTemplateDao := notify.ProvideNotifyTemplateDao(db)
+	notifyDeliveryDao := notify.ProvideNotifyDeliveryDao(db)
+	notifyService := notify2.ProvideService(notifyChannelDao, notifySubscriptionDao, notifyTemplateDao, notifyDeliveryDao, userDao, bus, encrypter, c)
+	cmdbHookDao := cmdbhook.ProvideCMDBHookDao(db)
+	cmdbHookDeliveryDao := cmdbhook.ProvideCMDBHookDeliveryDao(db)
+	cmdbhookService := cmdbhook2.ProvideService(cmdbHookDao, cmdbHookDeliveryDao, bus, encrypter, c)
+	broker := stream.ProvideBroker(bus, c)
+	cronJobDao := cron.ProvideCronJobDao(db)
+	cronRunDao := cron.ProvideCronRunDao(db, bus)
+	cronService, err := cron2.ProvideService(cronJobDao, cronRunDao, hostInstanceDao, agentDao, kubeClusterDao, notifyDeliveryDao, cmdbHookDeliveryDao, taskDao, bus, executor, nodeSync, c)
+	if err != nil {
+		return nil, err
+	}
+	server := api.ProvideAPI(userDao, hostInstanceDao, agentDao, agentBinaryDao, agentRolloutDao, hub, orchestrator, grantDao, authorizer, terminalSessionDao, manager, service, kubeClusterDao, registry, namespaces, kubeQuotaTemplateDao, workloads, kubeWorkloadActionDao, pods, applier, kubeEventDao, access, kubeAccessGrantDao, applicationDao, appEnvironmentDao, appReleaseDao, releaseService, gitlabConnectionDao, gitlabProjectDao, gitlabService, jenkinsServerDao, jenkinsJobDao, jenkinsBuildDao, jenkinsService, hookEventDao, receiver, pipelineDao, pipelineRunDao, pipelineService, approvalRuleDao, approvalRequestDao, approvalService, notifyChannelDao, notifySubscriptionDao, notifyTemplateDao, notifyDeliveryDao, notifyService, cmdbHookDao, cmdbHookDeliveryDao, cmdbhookService, broker, cronJobDao, cronRunDao, cronService, taskDao, queueQueue, c)
 	healthServer := health.ProvideHealth()
-	handler := ProvideRouter(apiServer, healthServer)
+	handler := ProvideRouter(server, healthServer)
 	serverServer := ProvideServer(handler, c)
-	return serverServer, nil
+	eventWatcher := kube.ProvideEventWatcher(registry, kubeClusterDao, kubeEventDao, c)
+	cmdApplication := newApplication(serverServer, hub, orchestrator, manager, service, registry, namespaces, workloads, nodeSync, eventWatcher, access, gitlabService, jenkinsService, receiver, pipelineService, approvalService, notifyService, cmdbhookService, broker, cronService, queueQueue)
+	return cmdApplication, nil
 }
@@ -0,0 +1,11 @@
+-- agent 可靠消息的去重表, apiserver 处理成功后记录消息ID
+-- Record 依赖 (agent_id, message_id) 唯一索引使用 INSERT IGNORE 去重, Prune 按 create_time 清理过期记录
+CREATE TABLE IF NOT EXISTS `agent_messages` (
+  `id`          BIGINT      NOT NULL AUTO_INCREMENT,
+  `agent_id`    BIGINT      NOT NULL,
+  `message_id`  VARCHAR(64) NOT NULL,
+  `create_time` DATETIME    NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_agent_message` (`agent_id`, `message_id`),
+  KEY `idx_create_time` (`create_time`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
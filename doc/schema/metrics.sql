@@ -0,0 +1,39 @@
+-- 主机监控指标, 每种精度的数据保存在单独的表中
+-- (agent_id, metric, ts) 为主键: metrics_raw 依赖它使用 INSERT IGNORE 丢弃 agent 重发的样本,
+-- metrics_1m 和 metrics_1h 依赖它使用 ON DUPLICATE KEY UPDATE 覆盖重复聚合的时间桶
+-- ts 索引用于按时间范围聚合和清理过期数据
+CREATE TABLE IF NOT EXISTS `metrics_raw` (
+  `agent_id` BIGINT      NOT NULL,
+  `metric`   VARCHAR(64) NOT NULL,
+  `ts`       DATETIME    NOT NULL,
+  `value`    DOUBLE      NOT NULL,
+  `min`      DOUBLE      NOT NULL,
+  `max`      DOUBLE      NOT NULL,
+  `count`    BIGINT      NOT NULL,
+  PRIMARY KEY (`agent_id`, `metric`, `ts`),
+  KEY `idx_ts` (`ts`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `metrics_1m` (
+  `agent_id` BIGINT      NOT NULL,
+  `metric`   VARCHAR(64) NOT NULL,
+  `ts`       DATETIME    NOT NULL,
+  `value`    DOUBLE      NOT NULL,
+  `min`      DOUBLE      NOT NULL,
+  `max`      DOUBLE      NOT NULL,
+  `count`    BIGINT      NOT NULL,
+  PRIMARY KEY (`agent_id`, `metric`, `ts`),
+  KEY `idx_ts` (`ts`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `metrics_1h` (
+  `agent_id` BIGINT      NOT NULL,
+  `metric`   VARCHAR(64) NOT NULL,
+  `ts`       DATETIME    NOT NULL,
+  `value`    DOUBLE      NOT NULL,
+  `min`      DOUBLE      NOT NULL,
+  `max`      DOUBLE      NOT NULL,
+  `count`    BIGINT      NOT NULL,
+  PRIMARY KEY (`agent_id`, `metric`, `ts`),
+  KEY `idx_ts` (`ts`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,365 @@
+// Package agent 实现了运行在主机上的 easynetes-agent
+// agent 主动与 apiserver 建立 websocket stream 连接, 定期上报心跳, 并执行 apiserver 下发的指令
+package agent
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/agent/proto"
+	"github.com/bloodsteel/easynetes/pkg/log"
+	"github.com/gorilla/websocket"
+	"golang.org/x/sync/errgroup"
+)
+
+var logger = log.RegisterScope("agent", "easynetes agent", 0)
+
+// 默认值
+const (
+	defaultHeartbeatInterval = 30 * time.Second
+	defaultQueueDir          = "/var/lib/easynetes-agent/queue"
+	defaultQueueSize         = 10000
+	writeWait                = 10 * time.Second
+	// ackTimeout 等待 apiserver 确认的时间, 超时后重发
+	ackTimeout = 30 * time.Second
+	// 重连的退避时间范围, 连接保持超过 stableSession 后重置
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 2 * time.Minute
+	stableSession     = time.Minute
+)
+
+var (
+	// errNotConnected agent 当前没有连接到 apiserver
+	errNotConnected = errors.New("agent is not connected")
+	// errUnauthorized apiserver 拒绝了 agent 的令牌
+	errUnauthorized = errors.New("agent token rejected by apiserver")
+)
+
+// Config agent 运行时配置
+type Config struct {
+	// Server apiserver 地址, 例如 http://127.0.0.1:8080
+	Server string
+	// Token 连接 apiserver 使用的令牌
+	Token string
+	// HeartbeatInterval 心跳间隔
+	HeartbeatInterval time.Duration
+	// MetricsInterval 指标采集间隔
+	MetricsInterval time.Duration
+	// MetricsBatch 每次上报的采样数量
+	MetricsBatch int
+	// QueueDir 待发送消息的磁盘队列目录
+	QueueDir string
+	// QueueSize 磁盘队列最多保存的消息数量, 超出后丢弃最旧的消息
+	QueueSize int
+	// StatusSocket 本地状态接口的 unix socket 路径, 为空时不启动
+	StatusSocket string
+}
+
+// Agent 与 apiserver 保持 stream 连接并处理下发的消息
+type Agent struct {
+	cfg        Config
+	build      proto.BuildInfo
+	instanceID string
+	hostName   string
+	client     *http.Client
+
+	queue      *queue
+	handlers   map[string]func(context.Context, *proto.Message) error
+	upgrades   chan *proto.Upgrade
+	terminals  *terminals
+	executions *executions
+
+	mu            sync.Mutex
+	ws            *websocket.Conn
+	acks          map[string]chan struct{}
+	lastConnected time.Time
+	lastHeartbeat time.Time
+	lastError     string
+}
+
+// New 创建一个 agent
+func New(cfg Config, build proto.BuildInfo) (*Agent, error) {
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = defaultHeartbeatInterval
+	}
+	if cfg.MetricsInterval <= 0 {
+		cfg.MetricsInterval = defaultMetricsInterval
+	}
+	if cfg.MetricsBatch <= 0 {
+		cfg.MetricsBatch = defaultMetricsBatch
+	}
+	if cfg.QueueDir == "" {
+		cfg.QueueDir = defaultQueueDir
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaultQueueSize
+	}
+	instanceID, err := machineID()
+	if err != nil {
+		return nil, err
+	}
+	q, err := openQueue(cfg.QueueDir, cfg.QueueSize)
+	if err != nil {
+		return nil, err
+	}
+	a := &Agent{
+		cfg:        cfg,
+		build:      build,
+		instanceID: instanceID,
+		hostName:   hostName(),
+		client:     &http.Client{Timeout: 10 * time.Minute},
+		queue:      q,
+		acks:       make(map[string]chan struct{}),
+		upgrades:   make(chan *proto.Upgrade, 1),
+		terminals:  &terminals{items: make(map[string]*terminal)},
+		executions: &executions{items: make(map[string]context.CancelFunc)},
+	}
+	a.handlers = map[string]func(context.Context, *proto.Message) error{
+		proto.TypeUpgrade:    a.handleUpgrade,
+		proto.TypePTYOpen:    a.handlePTYOpen,
+		proto.TypePTYInput:   a.handlePTYInput,
+		proto.TypePTYResize:  a.handlePTYResize,
+		proto.TypePTYClose:   a.handlePTYClose,
+		proto.TypeExec:       a.handleExec,
+		proto.TypeExecCancel: a.handleExecCancel,
+	}
+	return a, nil
+}
+
+// Run 连接 apiserver 并在连接断开后按指数退避重连, 直到 ctx 结束
+func (a *Agent) Run(ctx context.Context) error {
+	go a.upgradeLoop(ctx)
+	go a.metricsLoop(ctx)
+	if a.cfg.StatusSocket != "" {
+		go a.serveStatus(ctx)
+	}
+	b := &backoff{min: minReconnectDelay, max: maxReconnectDelay}
+	for {
+		start := time.Now()
+		err := a.session(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if time.Since(start) > stableSession {
+			b.Reset()
+		}
+		delay := b.Next()
+		a.mu.Lock()
+		a.lastError = err.Error()
+		a.mu.Unlock()
+		logger.WithLabels("server", a.cfg.Server, "delay", delay, "error", err).Warn("disconnected from apiserver, reconnecting")
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// Enqueue 将上报消息写入磁盘队列, 连接 apiserver 后按顺序发送, 直到收到确认才从队列中删除
+func (a *Agent) Enqueue(typ string, payload interface{}) error {
+	msg, err := proto.NewMessage(typ, payload)
+	if err != nil {
+		return err
+	}
+	msg.Reliable = true
+	return a.queue.Push(msg)
+}
+
+// Send 通过 stream 连接向 apiserver 发送一条消息
+func (a *Agent) Send(msg *proto.Message) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.ws == nil {
+		return errNotConnected
+	}
+	_ = a.ws.SetWriteDeadline(time.Now().Add(writeWait))
+	return a.ws.WriteJSON(msg)
+}
+
+// session 建立一次 stream 连接, 直到连接断开或者 ctx 结束
+func (a *Agent) session(ctx context.Context) error {
+	ws, err := dial(ctx, a.cfg.Server, a.cfg.Token)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.ws = ws
+	a.lastConnected = time.Now()
+	a.lastError = ""
+	a.mu.Unlock()
+	logger.WithLabels("server", a.cfg.Server).Info("connected to apiserver")
+	defer func() {
+		a.mu.Lock()
+		a.ws = nil
+		a.mu.Unlock()
+		_ = ws.Close()
+		a.closeTerminals()
+		a.cancelExecutions()
+	}()
+
+	// apiserver 收到第一个心跳后才会处理其他消息, 所以先同步发送一次心跳
+	if err := a.heartbeat(); err != nil {
+		return err
+	}
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		ticker := time.NewTicker(a.cfg.HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				// 关闭连接使 read 返回
+				_ = ws.Close()
+				return ctx.Err()
+			case <-ticker.C:
+			}
+			if err := a.heartbeat(); err != nil {
+				return err
+			}
+		}
+	})
+	g.Go(func() error {
+		return a.flush(ctx)
+	})
+	g.Go(func() error {
+		for {
+			msg := new(proto.Message)
+			if err := ws.ReadJSON(msg); err != nil {
+				return err
+			}
+			if msg.Type == proto.TypeAck {
+				a.handleAck(msg)
+				continue
+			}
+			handler, ok := a.handlers[msg.Type]
+			if !ok {
+				logger.WithLabels("type", msg.Type).Warn("unknown message type")
+				continue
+			}
+			if err := handler(ctx, msg); err != nil {
+				logger.WithLabels("type", msg.Type, "error", err).Error("cannot handle message")
+			}
+		}
+	})
+	return g.Wait()
+}
+
+// flush 按顺序发送磁盘队列中的消息, 每条消息收到确认后才发送下一条
+// 超时未确认的消息会被重发, apiserver 根据消息ID去重
+func (a *Agent) flush(ctx context.Context) error {
+	for {
+		seq, msg, err := a.queue.Peek()
+		if errors.Is(err, errQueueEmpty) {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-a.queue.Notify():
+				continue
+			}
+		}
+		if err != nil {
+			return err
+		}
+		acked := make(chan struct{})
+		a.mu.Lock()
+		a.acks[msg.ID] = acked
+		a.mu.Unlock()
+		err = a.Send(msg)
+		if err == nil {
+			select {
+			case <-acked:
+				a.queue.Remove(seq)
+			case <-time.After(ackTimeout):
+				logger.WithLabels("id", msg.ID, "type", msg.Type).Warn("message not acknowledged, resending")
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+		}
+		a.mu.Lock()
+		delete(a.acks, msg.ID)
+		a.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (a *Agent) handleAck(msg *proto.Message) {
+	in := new(proto.Ack)
+	if err := msg.Decode(in); err != nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if ch, ok := a.acks[in.ID]; ok {
+		close(ch)
+		delete(a.acks, in.ID)
+	}
+}
+
+func (a *Agent) heartbeat() error {
+	msg, err := proto.NewMessage(proto.TypeHeartbeat, &proto.Heartbeat{
+		InstanceID: a.instanceID,
+		HostName:   a.hostName,
+		Build:      a.build,
+		Time:       time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	if err := a.Send(msg); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.lastHeartbeat = time.Now()
+	a.mu.Unlock()
+	return nil
+}
+
+// Verify 校验 apiserver 地址和令牌是否可用, 只建立 stream 连接不发送任何消息
+func Verify(ctx context.Context, server, token string) error {
+	ws, err := dial(ctx, server, token)
+	if err != nil {
+		return err
+	}
+	return ws.Close()
+}
+
+// dial 建立到 apiserver 的 stream 连接
+func dial(ctx context.Context, server, token string) (*websocket.Conn, error) {
+	link, err := endpoint(server, "ws", proto.StreamPath)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+token)
+	ws, resp, err := websocket.DefaultDialer.DialContext(ctx, link, header)
+	if err != nil && resp != nil && resp.StatusCode == http.StatusUnauthorized {
+		return nil, errUnauthorized
+	}
+	return ws, err
+}
+
+// endpoint 根据 apiserver 地址生成完整的 url, scheme 为 ws 时会将 http(s) 转换为 ws(s)
+func endpoint(server, scheme, path string) (string, error) {
+	u, err := url.Parse(strings.TrimSuffix(server, "/"))
+	if err != nil {
+		return "", err
+	}
+	if scheme == "ws" {
+		switch u.Scheme {
+		case "https":
+			u.Scheme = "wss"
+		default:
+			u.Scheme = "ws"
+		}
+	}
+	u.Path += path
+	return u.String(), nil
+}
@@ -0,0 +1,29 @@
+package agent
+
+import (
+	"math/rand"
+	"time"
+)
+
+// backoff 带随机抖动的指数退避, 避免 apiserver 重启后所有 agent 同时重连
+type backoff struct {
+	min, max time.Duration
+	attempt  int
+}
+
+// Next 返回下一次重试前的等待时间, 取值范围为 [d/2, d], d 每次翻倍直到 max
+func (b *backoff) Next() time.Duration {
+	d := b.min << b.attempt
+	if d > b.max || d <= 0 {
+		d = b.max
+	} else {
+		b.attempt++
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// Reset 连接成功后重置退避时间
+func (b *backoff) Reset() {
+	b.attempt = 0
+}
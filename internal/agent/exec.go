@@ -0,0 +1,137 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/agent/proto"
+)
+
+// execReadSize 单条输出消息的大小上限
+const execReadSize = 16 * 1024
+
+// executions 当前 agent 上所有正在执行的脚本, key 为 ExecID
+type executions struct {
+	mu    sync.Mutex
+	items map[string]context.CancelFunc
+}
+
+func (e *executions) add(id string, cancel context.CancelFunc) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.items[id] = cancel
+}
+
+func (e *executions) remove(id string) context.CancelFunc {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	cancel := e.items[id]
+	delete(e.items, id)
+	return cancel
+}
+
+// execWriter 将脚本输出按块发送给 apiserver
+type execWriter struct {
+	agent *Agent
+	id    string
+}
+
+func (w *execWriter) Write(p []byte) (int, error) {
+	for start := 0; start < len(p); start += execReadSize {
+		end := start + execReadSize
+		if end > len(p) {
+			end = len(p)
+		}
+		data := append([]byte(nil), p[start:end]...)
+		msg, err := proto.NewMessage(proto.TypeExecOutput, &proto.ExecOutput{ExecID: w.id, Data: data})
+		if err != nil {
+			return start, err
+		}
+		if err := w.agent.Send(msg); err != nil {
+			return start, err
+		}
+	}
+	return len(p), nil
+}
+
+func (a *Agent) handleExec(_ context.Context, msg *proto.Message) error {
+	in := new(proto.Exec)
+	if err := msg.Decode(in); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if in.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), time.Duration(in.Timeout)*time.Second)
+	}
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", in.Script)
+	cmd.Env = os.Environ()
+	for k, v := range in.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.Dir = "/"
+	out := &execWriter{agent: a, id: in.ExecID}
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Start(); err != nil {
+		cancel()
+		a.sendExecExit(in.ExecID, -1, err)
+		return err
+	}
+	a.executions.add(in.ExecID, cancel)
+	logger.WithLabels("exec_id", in.ExecID).Info("script started")
+
+	go func() {
+		err := cmd.Wait()
+		a.executions.remove(in.ExecID)
+		code := 0
+		var exitErr *exec.ExitError
+		switch {
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			code, err = -1, errors.New("script timed out")
+		case errors.As(err, &exitErr):
+			code, err = exitErr.ExitCode(), nil
+		case err != nil:
+			code = -1
+		}
+		cancel()
+		a.sendExecExit(in.ExecID, code, err)
+		logger.WithLabels("exec_id", in.ExecID, "code", code).Info("script exited")
+	}()
+	return nil
+}
+
+func (a *Agent) handleExecCancel(_ context.Context, msg *proto.Message) error {
+	in := new(proto.ExecCancel)
+	if err := msg.Decode(in); err != nil {
+		return err
+	}
+	if cancel := a.executions.remove(in.ExecID); cancel != nil {
+		cancel()
+	}
+	return nil
+}
+
+// cancelExecutions 与 apiserver 断开连接时终止所有脚本, apiserver 端已经认为执行失败
+func (a *Agent) cancelExecutions() {
+	a.executions.mu.Lock()
+	items := a.executions.items
+	a.executions.items = make(map[string]context.CancelFunc)
+	a.executions.mu.Unlock()
+	for _, cancel := range items {
+		cancel()
+	}
+}
+
+func (a *Agent) sendExecExit(id string, code int, err error) {
+	exit := &proto.ExecExit{ExecID: id, Code: code}
+	if err != nil {
+		exit.Error = err.Error()
+	}
+	if msg, err := proto.NewMessage(proto.TypeExecExit, exit); err == nil {
+		_ = a.Send(msg)
+	}
+}
@@ -0,0 +1,32 @@
+package agent
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+// machineIDPaths systemd 和 dbus 生成的机器唯一标识
+var machineIDPaths = []string{
+	"/etc/machine-id",
+	"/var/lib/dbus/machine-id",
+}
+
+// machineID 返回主机的唯一标识, 作为 agent 的 InstanceID
+func machineID() (string, error) {
+	for _, path := range machineIDPaths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if id := strings.TrimSpace(string(data)); id != "" {
+			return id, nil
+		}
+	}
+	return "", errors.New("cannot read machine id from " + strings.Join(machineIDPaths, ", "))
+}
+
+func hostName() string {
+	name, _ := os.Hostname()
+	return name
+}
@@ -0,0 +1,290 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/agent/proto"
+)
+
+// 指标名称, 与 core 中定义的指标保持一致
+const (
+	metricCPU       = "cpu"        // CPU 使用率, 百分比
+	metricMemory    = "memory"     // 内存使用率, 百分比
+	metricLoad1     = "load1"      // 1 分钟平均负载
+	metricLoad5     = "load5"      // 5 分钟平均负载
+	metricLoad15    = "load15"     // 15 分钟平均负载
+	metricDiskUsed  = "disk_used"  // 根分区使用率, 百分比
+	metricDiskRead  = "disk_read"  // 磁盘读, bytes/s
+	metricDiskWrite = "disk_write" // 磁盘写, bytes/s
+	metricNetRx     = "net_rx"     // 网络接收, bytes/s
+	metricNetTx     = "net_tx"     // 网络发送, bytes/s
+)
+
+// 默认值
+const (
+	defaultMetricsInterval = 15 * time.Second
+	defaultMetricsBatch    = 4
+	// diskSectorSize /proc/diskstats 中的扇区大小固定为 512 字节
+	diskSectorSize = 512
+)
+
+// sampler 从 /proc 中采集主机指标, 速率类指标根据两次采集的差值计算
+type sampler struct {
+	procRoot string
+	rootFS   string
+
+	last     time.Time
+	lastCPU  cpuTimes
+	lastDisk ioCounters
+	lastNet  ioCounters
+}
+
+type cpuTimes struct {
+	idle, total uint64
+}
+
+type ioCounters struct {
+	read, write uint64
+}
+
+func newSampler() *sampler {
+	return &sampler{procRoot: "/proc", rootFS: "/"}
+}
+
+// sample 采集一次所有指标, 第一次采集时没有速率类指标
+func (s *sampler) sample() *proto.MetricSample {
+	now := time.Now()
+	values := make(map[string]float64)
+	elapsed := now.Sub(s.last).Seconds()
+	first := s.last.IsZero()
+
+	if cpu, err := readFile(filepath.Join(s.procRoot, "stat"), parseCPUStat); err == nil {
+		if !first && cpu.total > s.lastCPU.total {
+			busy := float64((cpu.total - s.lastCPU.total) - (cpu.idle - s.lastCPU.idle))
+			values[metricCPU] = 100 * busy / float64(cpu.total-s.lastCPU.total)
+		}
+		s.lastCPU = cpu
+	}
+	if mem, err := readFile(filepath.Join(s.procRoot, "meminfo"), parseMeminfo); err == nil {
+		values[metricMemory] = mem
+	}
+	if load, err := readFile(filepath.Join(s.procRoot, "loadavg"), parseLoadavg); err == nil {
+		values[metricLoad1], values[metricLoad5], values[metricLoad15] = load[0], load[1], load[2]
+	}
+	if used, err := diskUsed(s.rootFS); err == nil {
+		values[metricDiskUsed] = used
+	}
+	if disk, err := readFile(filepath.Join(s.procRoot, "diskstats"), parseDiskstats); err == nil {
+		if !first && elapsed > 0 {
+			values[metricDiskRead] = rate(disk.read, s.lastDisk.read, elapsed)
+			values[metricDiskWrite] = rate(disk.write, s.lastDisk.write, elapsed)
+		}
+		s.lastDisk = disk
+	}
+	if net, err := readFile(filepath.Join(s.procRoot, "net", "dev"), parseNetDev); err == nil {
+		if !first && elapsed > 0 {
+			values[metricNetRx] = rate(net.read, s.lastNet.read, elapsed)
+			values[metricNetTx] = rate(net.write, s.lastNet.write, elapsed)
+		}
+		s.lastNet = net
+	}
+	s.last = now
+	return &proto.MetricSample{Time: now, Values: values}
+}
+
+// metricsLoop 定期采集指标, 每积累一批后写入发送队列
+func (a *Agent) metricsLoop(ctx context.Context) {
+	s := newSampler()
+	s.sample()
+	ticker := time.NewTicker(a.cfg.MetricsInterval)
+	defer ticker.Stop()
+	var pending []*proto.MetricSample
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		pending = append(pending, s.sample())
+		if len(pending) < a.cfg.MetricsBatch {
+			continue
+		}
+		if err := a.Enqueue(proto.TypeMetrics, &proto.MetricsBatch{Samples: pending}); err != nil {
+			logger.WithLabels("error", err).Error("cannot enqueue metrics")
+		}
+		pending = nil
+	}
+}
+
+func readFile[T any](path string, parse func(io.Reader) (T, error)) (T, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	defer f.Close()
+	return parse(f)
+}
+
+// parseCPUStat 解析 /proc/stat 的 cpu 汇总行, iowait 计入空闲时间
+func parseCPUStat(r io.Reader) (cpuTimes, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || fields[0] != "cpu" {
+			continue
+		}
+		var out cpuTimes
+		for i, field := range fields[1:] {
+			v, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return cpuTimes{}, err
+			}
+			// guest 和 guest_nice 已经包含在 user 和 nice 中
+			if i < 8 {
+				out.total += v
+			}
+			// idle 和 iowait
+			if i == 3 || i == 4 {
+				out.idle += v
+			}
+		}
+		return out, nil
+	}
+	return cpuTimes{}, errors.New("cpu line not found in stat")
+}
+
+// parseMeminfo 解析 /proc/meminfo, 返回内存使用率
+func parseMeminfo(r io.Reader) (float64, error) {
+	var total, available float64
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		v, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			total = v
+		case "MemAvailable:":
+			available = v
+		}
+	}
+	if total == 0 {
+		return 0, errors.New("MemTotal not found in meminfo")
+	}
+	return 100 * (total - available) / total, nil
+}
+
+// parseLoadavg 解析 /proc/loadavg
+func parseLoadavg(r io.Reader) ([3]float64, error) {
+	var out [3]float64
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return out, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return out, errors.New("invalid loadavg")
+	}
+	for i := range out {
+		if out[i], err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return out, err
+		}
+	}
+	return out, nil
+}
+
+// parseDiskstats 解析 /proc/diskstats, 汇总所有物理磁盘的读写字节数
+// 分区、loop 和 ram 设备会被跳过, 避免重复统计
+func parseDiskstats(r io.Reader) (ioCounters, error) {
+	var out ioCounters
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || !isPhysicalDisk(fields[2]) {
+			continue
+		}
+		read, err1 := strconv.ParseUint(fields[5], 10, 64)
+		write, err2 := strconv.ParseUint(fields[9], 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		out.read += read * diskSectorSize
+		out.write += write * diskSectorSize
+	}
+	return out, scanner.Err()
+}
+
+func isPhysicalDisk(name string) bool {
+	for _, prefix := range []string{"loop", "ram", "dm-", "sr", "md"} {
+		if strings.HasPrefix(name, prefix) {
+			return false
+		}
+	}
+	// nvme0n1p1 和 sda1 这类分区
+	if strings.HasPrefix(name, "nvme") || strings.HasPrefix(name, "mmcblk") {
+		return !strings.Contains(name, "p")
+	}
+	last := name[len(name)-1]
+	return last < '0' || last > '9'
+}
+
+// parseNetDev 解析 /proc/net/dev, 汇总除 lo 以外所有网卡的收发字节数
+func parseNetDev(r io.Reader) (ioCounters, error) {
+	var out ioCounters
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		name, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || strings.TrimSpace(name) == "lo" {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) < 9 {
+			continue
+		}
+		rx, err1 := strconv.ParseUint(fields[0], 10, 64)
+		tx, err2 := strconv.ParseUint(fields[8], 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		out.read += rx
+		out.write += tx
+	}
+	return out, scanner.Err()
+}
+
+// diskUsed 返回文件系统使用率
+func diskUsed(path string) (float64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	total := float64(st.Blocks) * float64(st.Bsize)
+	free := float64(st.Bavail) * float64(st.Bsize)
+	if total == 0 {
+		return 0, errors.New("empty filesystem")
+	}
+	return 100 * (total - free) / total, nil
+}
+
+// rate 计算计数器的每秒增量, 计数器回绕或者重置时返回 0
+func rate(cur, prev uint64, seconds float64) float64 {
+	if cur < prev {
+		return 0
+	}
+	return float64(cur-prev) / seconds
+}
@@ -0,0 +1,77 @@
+package agent
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseCPUStat(t *testing.T) {
+	stat := `cpu  100 20 30 800 50 0 0 0 0 0
+cpu0 50 10 15 400 25 0 0 0 0 0
+intr 12345
+`
+	got, err := parseCPUStat(strings.NewReader(stat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.total != 1000 || got.idle != 850 {
+		t.Errorf("got %+v, want total 1000 idle 850", got)
+	}
+}
+
+func TestParseMeminfo(t *testing.T) {
+	meminfo := `MemTotal:       16000000 kB
+MemFree:         2000000 kB
+MemAvailable:    4000000 kB
+`
+	got, err := parseMeminfo(strings.NewReader(meminfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(got-75) > 1e-9 {
+		t.Errorf("got %v, want 75", got)
+	}
+}
+
+func TestParseLoadavg(t *testing.T) {
+	got, err := parseLoadavg(strings.NewReader("0.50 1.25 2.00 1/123 4567\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != [3]float64{0.5, 1.25, 2} {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestParseDiskstats(t *testing.T) {
+	diskstats := `   8       0 sda 100 0 10 0 200 0 20 0 0 0 0
+   8       1 sda1 100 0 10 0 200 0 20 0 0 0 0
+ 259       0 nvme0n1 100 0 30 0 200 0 40 0 0 0 0
+ 259       1 nvme0n1p1 100 0 30 0 200 0 40 0 0 0 0
+   7       0 loop0 100 0 1000 0 0 0 0 0 0 0 0
+`
+	got, err := parseDiskstats(strings.NewReader(diskstats))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.read != 40*diskSectorSize || got.write != 60*diskSectorSize {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestParseNetDev(t *testing.T) {
+	netdev := `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:    1000      10    0    0    0     0          0         0     1000      10    0    0    0     0       0          0
+  eth0:    2000      20    0    0    0     0          0         0     3000      30    0    0    0     0       0          0
+  eth1:     500       5    0    0    0     0          0         0      700       7    0    0    0     0       0          0
+`
+	got, err := parseNetDev(strings.NewReader(netdev))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.read != 2500 || got.write != 3700 {
+		t.Errorf("got %+v", got)
+	}
+}
@@ -0,0 +1,183 @@
+// Package proto 定义了 easynetes-agent 与 apiserver 之间 stream 连接上传输的消息
+package proto
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// 消息类型
+const (
+	// TypeHeartbeat agent -> apiserver, 定期上报心跳和构建信息
+	TypeHeartbeat = "heartbeat"
+	// TypeUpgrade apiserver -> agent, 通知 agent 升级(或者回滚)到指定的二进制文件
+	TypeUpgrade = "upgrade"
+	// TypePTYOpen apiserver -> agent, 在主机上分配一个 PTY 并启动 shell
+	TypePTYOpen = "pty.open"
+	// TypePTYInput apiserver -> agent, 用户在终端中的输入
+	TypePTYInput = "pty.input"
+	// TypePTYResize apiserver -> agent, 调整终端窗口大小
+	TypePTYResize = "pty.resize"
+	// TypePTYClose apiserver -> agent, 关闭终端会话
+	TypePTYClose = "pty.close"
+	// TypePTYOutput agent -> apiserver, 终端输出
+	TypePTYOutput = "pty.output"
+	// TypePTYExit agent -> apiserver, shell 进程退出
+	TypePTYExit = "pty.exit"
+	// TypeMetrics agent -> apiserver, 批量上报主机监控指标
+	TypeMetrics = "metrics"
+	// TypeAck apiserver -> agent, 确认已经收到需要确认的消息
+	TypeAck = "ack"
+	// TypeExec apiserver -> agent, 在主机上使用 sh 执行一段脚本
+	TypeExec = "exec"
+	// TypeExecCancel apiserver -> agent, 终止正在执行的脚本
+	TypeExecCancel = "exec.cancel"
+	// TypeExecOutput agent -> apiserver, 脚本的标准输出和标准错误
+	TypeExecOutput = "exec.output"
+	// TypeExecExit agent -> apiserver, 脚本执行结束
+	TypeExecExit = "exec.exit"
+)
+
+// StreamPath agent 连接 apiserver 的 websocket 路径
+const StreamPath = "/api/agents/stream"
+
+type (
+	// Message stream 连接上传输的消息信封
+	// Reliable 为 true 的消息由 agent 持久化并重发直到收到 ack, apiserver 根据 ID 去重
+	Message struct {
+		ID       string          `json:"id"`
+		Type     string          `json:"type"`
+		Reliable bool            `json:"reliable,omitempty"`
+		Payload  json.RawMessage `json:"payload,omitempty"`
+	}
+
+	// BuildInfo agent 的构建信息
+	BuildInfo struct {
+		Version   string `json:"version"`
+		Branch    string `json:"branch"`
+		Commit    string `json:"commit"`
+		BuildTime string `json:"build_time"`
+		GoVersion string `json:"go_version"`
+		OS        string `json:"os"`
+		Arch      string `json:"arch"`
+	}
+
+	// Heartbeat 心跳消息
+	Heartbeat struct {
+		InstanceID string    `json:"instance_id"`
+		HostName   string    `json:"host_name"`
+		Build      BuildInfo `json:"build"`
+		Time       time.Time `json:"time"`
+	}
+
+	// Upgrade 升级消息, agent 通过 BinaryID 下载二进制文件并校验 Checksum(sha256)
+	Upgrade struct {
+		RolloutID int64  `json:"rollout_id"`
+		BinaryID  int64  `json:"binary_id"`
+		Version   string `json:"version"`
+		Checksum  string `json:"checksum"`
+		Size      int64  `json:"size"`
+	}
+)
+
+// PTYOpen 打开终端会话消息
+type PTYOpen struct {
+	SessionID string `json:"session_id"`
+	Cols      uint16 `json:"cols"`
+	Rows      uint16 `json:"rows"`
+}
+
+// PTYData 终端输入或者输出, Data 在 JSON 中以 base64 编码
+type PTYData struct {
+	SessionID string `json:"session_id"`
+	Data      []byte `json:"data"`
+}
+
+// PTYResize 调整终端窗口大小消息
+type PTYResize struct {
+	SessionID string `json:"session_id"`
+	Cols      uint16 `json:"cols"`
+	Rows      uint16 `json:"rows"`
+}
+
+// PTYClose 关闭终端会话消息
+type PTYClose struct {
+	SessionID string `json:"session_id"`
+}
+
+// PTYExit shell 进程退出消息
+type PTYExit struct {
+	SessionID string `json:"session_id"`
+	Code      int    `json:"code"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Exec 执行脚本消息, Timeout 单位为秒, 为0时不限制执行时间
+type Exec struct {
+	ExecID  string            `json:"exec_id"`
+	Script  string            `json:"script"`
+	Env     map[string]string `json:"env,omitempty"`
+	Timeout int64             `json:"timeout"`
+}
+
+// ExecCancel 终止脚本消息
+type ExecCancel struct {
+	ExecID string `json:"exec_id"`
+}
+
+// ExecOutput 脚本输出, Data 在 JSON 中以 base64 编码
+type ExecOutput struct {
+	ExecID string `json:"exec_id"`
+	Data   []byte `json:"data"`
+}
+
+// ExecExit 脚本执行结束消息, 无法启动或者超时时 Code 为 -1
+type ExecExit struct {
+	ExecID string `json:"exec_id"`
+	Code   int    `json:"code"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Ack 确认消息, ID 为被确认消息的ID
+type Ack struct {
+	ID string `json:"id"`
+}
+
+// MetricSample 某一时刻采集的一组指标, key 为指标名称
+type MetricSample struct {
+	Time   time.Time          `json:"time"`
+	Values map[string]float64 `json:"values"`
+}
+
+// MetricsBatch 批量上报的指标
+type MetricsBatch struct {
+	Samples []*MetricSample `json:"samples"`
+}
+
+// NewMessage 使用随机ID创建一个消息
+func NewMessage(typ string, payload interface{}) (*Message, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{
+		ID:      uuid.New().String(),
+		Type:    typ,
+		Payload: data,
+	}, nil
+}
+
+// ErrMalformed 消息的 payload 无法解析, 重发也无法处理
+var ErrMalformed = errors.New("malformed message payload")
+
+// Decode 将消息的 payload 解析到 v, 解析失败时返回的错误包含 ErrMalformed
+func (m *Message) Decode(v interface{}) error {
+	if err := json.Unmarshal(m.Payload, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return nil
+}
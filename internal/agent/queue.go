@@ -0,0 +1,163 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bloodsteel/easynetes/internal/agent/proto"
+)
+
+// queueFileExt 队列中每条消息保存为一个文件, 文件名为递增的序号
+const queueFileExt = ".msg"
+
+// errQueueEmpty 队列中没有消息
+var errQueueEmpty = errors.New("queue is empty")
+
+// queue 有界的磁盘队列, 保存等待发送给 apiserver 的上报消息
+// 队列满时丢弃最旧的消息; agent 重启后会从目录中恢复未发送的消息
+type queue struct {
+	dir  string
+	size int
+
+	mu      sync.Mutex
+	seqs    []uint64
+	next    uint64
+	dropped uint64
+	notify  chan struct{}
+}
+
+// openQueue 打开(或者创建)队列目录并加载其中未发送的消息
+func openQueue(dir string, size int) (*queue, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	q := &queue{dir: dir, size: size, notify: make(chan struct{}, 1)}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, queueFileExt) {
+			// 上次写入到一半的临时文件
+			if strings.HasSuffix(name, ".tmp") {
+				_ = os.Remove(filepath.Join(dir, name))
+			}
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, queueFileExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		q.seqs = append(q.seqs, seq)
+	}
+	sort.Slice(q.seqs, func(i, j int) bool { return q.seqs[i] < q.seqs[j] })
+	if n := len(q.seqs); n > 0 {
+		q.next = q.seqs[n-1] + 1
+	}
+	q.trim()
+	return q, nil
+}
+
+// Push 将消息写入队列, 写入成功后消息在 agent 重启后仍然存在
+func (q *queue) Push(msg *proto.Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	seq := q.next
+	path := q.path(seq)
+	// 先写临时文件再 rename, 避免崩溃时留下不完整的消息
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	q.next++
+	q.seqs = append(q.seqs, seq)
+	q.trim()
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Peek 返回队首的消息以及它的序号, 消息发送成功后需要调用 Remove
+func (q *queue) Peek() (uint64, *proto.Message, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.seqs) > 0 {
+		seq := q.seqs[0]
+		data, err := os.ReadFile(q.path(seq))
+		if err == nil {
+			msg := new(proto.Message)
+			if err = json.Unmarshal(data, msg); err == nil {
+				return seq, msg, nil
+			}
+		}
+		// 损坏的消息无法恢复, 直接丢弃
+		logger.WithLabels("seq", seq, "error", err).Warn("dropping unreadable queued message")
+		q.removeLocked(seq)
+		q.dropped++
+	}
+	return 0, nil, errQueueEmpty
+}
+
+// Remove 从队列中删除指定序号的消息, 消息已经被丢弃时什么也不做
+func (q *queue) Remove(seq uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.removeLocked(seq)
+}
+
+// Len 返回队列中的消息数量
+func (q *queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.seqs)
+}
+
+// Dropped 返回因为队列已满或者消息损坏而丢弃的消息数量
+func (q *queue) Dropped() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dropped
+}
+
+// Notify 有新消息写入时收到通知
+func (q *queue) Notify() <-chan struct{} {
+	return q.notify
+}
+
+// trim 队列超出容量时丢弃最旧的消息
+func (q *queue) trim() {
+	for len(q.seqs) > q.size {
+		q.removeLocked(q.seqs[0])
+		q.dropped++
+	}
+}
+
+func (q *queue) removeLocked(seq uint64) {
+	for i, s := range q.seqs {
+		if s == seq {
+			_ = os.Remove(q.path(seq))
+			q.seqs = append(q.seqs[:i], q.seqs[i+1:]...)
+			return
+		}
+	}
+}
+
+func (q *queue) path(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", seq, queueFileExt))
+}
@@ -0,0 +1,60 @@
+package agent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/agent/proto"
+)
+
+func TestQueue(t *testing.T) {
+	dir := t.TempDir()
+	q, err := openQueue(dir, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for i := 0; i < 5; i++ {
+		msg, _ := proto.NewMessage(proto.TypeMetrics, i)
+		ids = append(ids, msg.ID)
+		if err := q.Push(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if q.Len() != 3 || q.Dropped() != 2 {
+		t.Fatalf("len = %d, dropped = %d; want 3, 2", q.Len(), q.Dropped())
+	}
+
+	// 重新打开后保留未发送的消息, 并且保持顺序
+	q, err = openQueue(dir, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range ids[2:] {
+		seq, msg, err := q.Peek()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.ID != want {
+			t.Fatalf("peek = %s, want %s", msg.ID, want)
+		}
+		q.Remove(seq)
+	}
+	if _, _, err := q.Peek(); err != errQueueEmpty {
+		t.Fatalf("peek on empty queue = %v", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b := &backoff{min: time.Second, max: 8 * time.Second}
+	for i, max := range []time.Duration{1, 2, 4, 8, 8, 8} {
+		max *= time.Second
+		if d := b.Next(); d < max/2 || d > max {
+			t.Fatalf("attempt %d: delay %v out of [%v, %v]", i, d, max/2, max)
+		}
+	}
+	b.Reset()
+	if d := b.Next(); d > time.Second {
+		t.Fatalf("delay after reset = %v", d)
+	}
+}
@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// statusPath 本地状态接口的路径
+const statusPath = "/status"
+
+// Status agent 自身的运行状态
+type Status struct {
+	Version       string     `json:"version"`
+	InstanceID    string     `json:"instance_id"`
+	Server        string     `json:"server"`
+	Connected     bool       `json:"connected"`
+	LastConnected *time.Time `json:"last_connected,omitempty"`
+	LastHeartbeat *time.Time `json:"last_heartbeat,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	QueueDepth    int        `json:"queue_depth"`
+	QueueDropped  uint64     `json:"queue_dropped"`
+}
+
+// Status 返回 agent 当前的运行状态
+func (a *Agent) Status() *Status {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := &Status{
+		Version:       a.build.Version,
+		InstanceID:    a.instanceID,
+		Server:        a.cfg.Server,
+		Connected:     a.ws != nil,
+		LastConnected: timePtr(a.lastConnected),
+		LastHeartbeat: timePtr(a.lastHeartbeat),
+		LastError:     a.lastError,
+		QueueDepth:    a.queue.Len(),
+		QueueDropped:  a.queue.Dropped(),
+	}
+	return out
+}
+
+// serveStatus 在本地 unix socket 上提供状态接口, 只有能访问该 socket 文件的用户可以查询
+func (a *Agent) serveStatus(ctx context.Context) {
+	socket := a.cfg.StatusSocket
+	if err := os.MkdirAll(filepath.Dir(socket), 0o755); err != nil {
+		logger.WithLabels("socket", socket, "error", err).Error("cannot create status socket directory")
+		return
+	}
+	// 清理上次异常退出时遗留的 socket 文件
+	_ = os.Remove(socket)
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		logger.WithLabels("socket", socket, "error", err).Error("cannot listen on status socket")
+		return
+	}
+	_ = os.Chmod(socket, 0o660)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(statusPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(a.Status())
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: writeWait}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+	if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+		logger.WithLabels("socket", socket, "error", err).Error("cannot serve agent status")
+	}
+}
+
+// QueryStatus 通过本地 unix socket 查询正在运行的 agent 的状态
+func QueryStatus(ctx context.Context, socket string) (*Status, error) {
+	client := &http.Client{
+		Timeout: writeWait,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://agent"+statusPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	out := new(Status)
+	return out, json.NewDecoder(resp.Body).Decode(out)
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
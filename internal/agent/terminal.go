@@ -0,0 +1,177 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"sync"
+
+	"github.com/bloodsteel/easynetes/internal/agent/proto"
+	"github.com/creack/pty"
+)
+
+// ptyReadSize 每次从 PTY 读取的最大字节数, 也就是单条输出消息的大小上限
+const ptyReadSize = 16 * 1024
+
+// terminal 主机上一个正在运行的 shell
+type terminal struct {
+	pty *os.File
+	cmd *exec.Cmd
+}
+
+// terminals 当前 agent 上所有打开的终端, key 为 SessionID
+type terminals struct {
+	mu    sync.Mutex
+	items map[string]*terminal
+}
+
+func (t *terminals) get(id string) *terminal {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.items[id]
+}
+
+func (t *terminals) remove(id string) *terminal {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	term := t.items[id]
+	delete(t.items, id)
+	return term
+}
+
+func (a *Agent) handlePTYOpen(_ context.Context, msg *proto.Message) error {
+	in := new(proto.PTYOpen)
+	if err := msg.Decode(in); err != nil {
+		return err
+	}
+	cmd := exec.Command(loginShell(), "-l")
+	cmd.Env = append(os.Environ(), "TERM=xterm-256color")
+	cmd.Dir = "/"
+	if home, err := os.UserHomeDir(); err == nil {
+		cmd.Dir = home
+	}
+	f, err := pty.StartWithSize(cmd, &pty.Winsize{Cols: in.Cols, Rows: in.Rows})
+	if err != nil {
+		a.sendPTYExit(in.SessionID, -1, err)
+		return err
+	}
+	a.terminals.mu.Lock()
+	a.terminals.items[in.SessionID] = &terminal{pty: f, cmd: cmd}
+	a.terminals.mu.Unlock()
+	logger.WithLabels("session_id", in.SessionID).Info("terminal opened")
+
+	go func() {
+		buf := make([]byte, ptyReadSize)
+		for {
+			n, err := f.Read(buf)
+			if n > 0 {
+				data := append([]byte(nil), buf[:n]...)
+				out, _ := proto.NewMessage(proto.TypePTYOutput, &proto.PTYData{SessionID: in.SessionID, Data: data})
+				if err := a.Send(out); err != nil {
+					break
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+		code := 0
+		if err := cmd.Wait(); err != nil {
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				code = exitErr.ExitCode()
+			} else {
+				code = -1
+			}
+		}
+		if term := a.terminals.remove(in.SessionID); term != nil {
+			_ = term.pty.Close()
+		}
+		a.sendPTYExit(in.SessionID, code, nil)
+		logger.WithLabels("session_id", in.SessionID, "code", code).Info("terminal exited")
+	}()
+	return nil
+}
+
+func (a *Agent) handlePTYInput(_ context.Context, msg *proto.Message) error {
+	in := new(proto.PTYData)
+	if err := msg.Decode(in); err != nil {
+		return err
+	}
+	term := a.terminals.get(in.SessionID)
+	if term == nil {
+		return nil
+	}
+	_, err := term.pty.Write(in.Data)
+	return err
+}
+
+func (a *Agent) handlePTYResize(_ context.Context, msg *proto.Message) error {
+	in := new(proto.PTYResize)
+	if err := msg.Decode(in); err != nil {
+		return err
+	}
+	term := a.terminals.get(in.SessionID)
+	if term == nil {
+		return nil
+	}
+	return pty.Setsize(term.pty, &pty.Winsize{Cols: in.Cols, Rows: in.Rows})
+}
+
+func (a *Agent) handlePTYClose(_ context.Context, msg *proto.Message) error {
+	in := new(proto.PTYClose)
+	if err := msg.Decode(in); err != nil {
+		return err
+	}
+	a.closeTerminal(in.SessionID)
+	return nil
+}
+
+// closeTerminal 结束 shell 进程, 输出 goroutine 会在 PTY 关闭后回收进程
+func (a *Agent) closeTerminal(id string) {
+	term := a.terminals.get(id)
+	if term == nil {
+		return
+	}
+	if term.cmd.Process != nil {
+		_ = term.cmd.Process.Kill()
+	}
+	_ = term.pty.Close()
+}
+
+// closeTerminals 与 apiserver 断开连接时关闭所有终端, 对应的会话在 apiserver 端也已经失效
+func (a *Agent) closeTerminals() {
+	a.terminals.mu.Lock()
+	ids := make([]string, 0, len(a.terminals.items))
+	for id := range a.terminals.items {
+		ids = append(ids, id)
+	}
+	a.terminals.mu.Unlock()
+	for _, id := range ids {
+		a.closeTerminal(id)
+	}
+}
+
+func (a *Agent) sendPTYExit(id string, code int, err error) {
+	exit := &proto.PTYExit{SessionID: id, Code: code}
+	if err != nil {
+		exit.Error = err.Error()
+	}
+	if msg, err := proto.NewMessage(proto.TypePTYExit, exit); err == nil {
+		_ = a.Send(msg)
+	}
+}
+
+// loginShell 优先使用 $SHELL, 否则依次尝试 bash 和 sh
+func loginShell() string {
+	if shell := os.Getenv("SHELL"); shell != "" {
+		return shell
+	}
+	for _, shell := range []string{"/bin/bash", "/bin/sh"} {
+		if _, err := os.Stat(shell); err == nil {
+			return shell
+		}
+	}
+	return "sh"
+}
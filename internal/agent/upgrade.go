@@ -0,0 +1,134 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/bloodsteel/easynetes/internal/agent/proto"
+)
+
+// commands easynetes-agent 的子命令, 以及 cobra 内置的 help 和 version
+var commands = map[string]bool{
+	"run": true, "status": true, "enroll": true,
+	"help": true, "-h": true, "--help": true, "-v": true, "--version": true,
+}
+
+// handleUpgrade 只把升级消息放入队列, 下载和替换在 upgradeLoop 中串行执行
+// 队列中只保留最新的一条, 这样回滚消息可以覆盖还没有执行的升级消息
+func (a *Agent) handleUpgrade(_ context.Context, msg *proto.Message) error {
+	in := new(proto.Upgrade)
+	if err := msg.Decode(in); err != nil {
+		return err
+	}
+	for {
+		select {
+		case a.upgrades <- in:
+			return nil
+		default:
+		}
+		select {
+		case <-a.upgrades:
+		default:
+		}
+	}
+}
+
+func (a *Agent) upgradeLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case in := <-a.upgrades:
+			if in.Version == a.build.Version {
+				continue
+			}
+			logger.WithLabels("version", in.Version, "rollout_id", in.RolloutID).Info("upgrading agent")
+			if err := a.upgrade(ctx, in); err != nil {
+				logger.WithLabels("version", in.Version, "error", err).Error("cannot upgrade agent")
+			}
+		}
+	}
+}
+
+// upgrade 下载并校验新的二进制文件, 将当前二进制文件备份为 .prev 后替换, 然后原地重新执行
+func (a *Agent) upgrade(ctx context.Context, in *proto.Upgrade) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	next := exe + ".new"
+	if err := a.download(ctx, in, next); err != nil {
+		_ = os.Remove(next)
+		return err
+	}
+	if err := os.Rename(exe, exe+".prev"); err != nil {
+		return err
+	}
+	if err := os.Rename(next, exe); err != nil {
+		// 恢复原来的二进制文件
+		_ = os.Rename(exe+".prev", exe)
+		return err
+	}
+	a.mu.Lock()
+	if a.ws != nil {
+		_ = a.ws.Close()
+	}
+	a.mu.Unlock()
+	logger.WithLabels("version", in.Version).Info("agent binary replaced, restarting")
+	return syscall.Exec(exe, append([]string{os.Args[0]}, RunArgs(os.Args[1:])...), os.Environ())
+}
+
+// RunArgs 参数中没有子命令时在最前面加上 run
+// 旧版本的 agent 没有子命令, 直接以 easynetes-agent -c <file> 的方式运行, 新版本需要 run 子命令才会启动
+func RunArgs(args []string) []string {
+	for _, arg := range args {
+		if commands[arg] {
+			return args
+		}
+	}
+	return append([]string{"run"}, args...)
+}
+
+func (a *Agent) download(ctx context.Context, in *proto.Upgrade, path string) error {
+	link, err := endpoint(a.cfg.Server, "http", fmt.Sprintf("/api/agents/binaries/%d/download", in.BinaryID))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+a.cfg.Token)
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download agent binary: unexpected status %s", resp.Status)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o755)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, hash), resp.Body); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != in.Checksum {
+		return fmt.Errorf("checksum mismatch: want %s, got %s", in.Checksum, sum)
+	}
+	return file.Close()
+}
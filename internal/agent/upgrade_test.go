@@ -0,0 +1,23 @@
+package agent
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRunArgs(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		want []string
+	}{
+		{nil, []string{"run"}},
+		{[]string{"-c", "/etc/easynetes/agent.yaml"}, []string{"run", "-c", "/etc/easynetes/agent.yaml"}},
+		{[]string{"run", "-c", "/etc/easynetes/agent.yaml"}, []string{"run", "-c", "/etc/easynetes/agent.yaml"}},
+		{[]string{"--config", "a.yaml", "status"}, []string{"--config", "a.yaml", "status"}},
+		{[]string{"--version"}, []string{"--version"}},
+	} {
+		if got := RunArgs(tt.args); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("RunArgs(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package core
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// agent 在线状态
+const (
+	AgentStatusOnline  = "online"
+	AgentStatusOffline = "offline"
+)
+
+// 灰度升级任务的状态
+const (
+	RolloutStatusRunning     = "running"
+	RolloutStatusPaused      = "paused"
+	RolloutStatusSucceeded   = "succeeded"
+	RolloutStatusRollingBack = "rolling_back"
+	RolloutStatusRolledBack  = "rolled_back"
+	RolloutStatusAborted     = "aborted"
+)
+
+// 灰度升级任务中单个主机的状态
+const (
+	RolloutHostPending     = "pending"
+	RolloutHostUpgrading   = "upgrading"
+	RolloutHostUpgraded    = "upgraded"
+	RolloutHostFailed      = "failed"
+	RolloutHostRollingBack = "rolling_back"
+	RolloutHostRolledBack  = "rolled_back"
+)
+
+type (
+	// Agent 部署在主机上的 easynetes-agent 实例, 由心跳数据自动注册和更新
+	Agent struct {
+		ID            int64     `db:"id" json:"id"`
+		InstanceID    string    `db:"instance_id" json:"instance_id"`
+		HostName      string    `db:"host_name" json:"host_name"`
+		Version       string    `db:"version" json:"version"`
+		Branch        string    `db:"branch" json:"branch"`
+		Commit        string    `db:"commit" json:"commit"`
+		BuildTime     string    `db:"build_time" json:"build_time"`
+		GoVersion     string    `db:"go_version" json:"go_version"`
+		OS            string    `db:"os" json:"os"`
+		Arch          string    `db:"arch" json:"arch"`
+		Status        string    `db:"status" json:"status"`
+		LastHeartbeat time.Time `db:"last_heartbeat" json:"last_heartbeat"`
+		CreateTime    time.Time `db:"create_time" json:"create_time"`
+		UpdateTime    time.Time `db:"update_time" json:"update_time"`
+	}
+
+	// AgentBinary 管理员上传的 agent 二进制文件, 文件内容保存在存储目录中, 数据库只保存元数据
+	AgentBinary struct {
+		ID         int64     `db:"id" json:"id"`
+		Version    string    `db:"version" json:"version"`
+		OS         string    `db:"os" json:"os"`
+		Arch       string    `db:"arch" json:"arch"`
+		Checksum   string    `db:"checksum" json:"checksum"`
+		Size       int64     `db:"size" json:"size"`
+		Path       string    `db:"path" json:"-"`
+		Uploader   string    `db:"uploader" json:"uploader"`
+		CreateTime time.Time `db:"create_time" json:"create_time"`
+		Remark     string    `db:"remark" json:"remark"`
+	}
+
+	// RolloutStages 灰度升级的阶段, 每个元素表示该阶段结束时累计升级的主机百分比, 例如 [5, 50, 100]
+	RolloutStages []int
+
+	// AgentRollout agent 灰度升级任务
+	// Owner 是推进任务的 apiserver 副本, LeaseTime 之前其他副本不能推进该任务, 副本每次推进时续期
+	AgentRollout struct {
+		ID            int64         `db:"id" json:"id"`
+		Version       string        `db:"version" json:"version"`
+		Stages        RolloutStages `db:"stages" json:"stages"`
+		CurrentStage  int           `db:"current_stage" json:"current_stage"`
+		Status        string        `db:"status" json:"status"`
+		HealthTimeout int64         `db:"health_timeout" json:"health_timeout"`
+		SoakTime      int64         `db:"soak_time" json:"soak_time"`
+		Creator       string        `db:"creator" json:"creator"`
+		Message       string        `db:"message" json:"message"`
+		Owner         string        `db:"owner" json:"owner"`
+		LeaseTime     *time.Time    `db:"lease_time" json:"lease_time"`
+		CreateTime    time.Time     `db:"create_time" json:"create_time"`
+		UpdateTime    time.Time     `db:"update_time" json:"update_time"`
+	}
+
+	// AgentRolloutHost 灰度升级任务中的单个主机
+	// FromBinaryID 为0时表示没有找到升级前版本的二进制文件, 此时无法自动回滚
+	// SentBinaryID 是最近一次成功下发给 agent 的二进制文件, 同一个二进制文件只下发一次
+	AgentRolloutHost struct {
+		ID           int64      `db:"id" json:"id"`
+		RolloutID    int64      `db:"rollout_id" json:"rollout_id"`
+		AgentID      int64      `db:"agent_id" json:"agent_id"`
+		Stage        int        `db:"stage" json:"stage"`
+		FromVersion  string     `db:"from_version" json:"from_version"`
+		FromBinaryID int64      `db:"from_binary_id" json:"from_binary_id"`
+		ToBinaryID   int64      `db:"to_binary_id" json:"to_binary_id"`
+		SentBinaryID int64      `db:"sent_binary_id" json:"sent_binary_id"`
+		Status       string     `db:"status" json:"status"`
+		UpgradeTime  *time.Time `db:"upgrade_time" json:"upgrade_time"`
+		UpdateTime   time.Time  `db:"update_time" json:"update_time"`
+	}
+
+	// AgentDao 定义了一组从数据库操作 agent 的一系列操作
+	AgentDao interface {
+		// Get 根据ID从数据库中获取 agent
+		Get(context.Context, int64) (*Agent, error)
+		// GetByInstance 根据主机的 InstanceID 获取 agent
+		GetByInstance(context.Context, string) (*Agent, error)
+		// List 从数据库中获取一组 agent
+		List(context.Context, map[string]interface{}) ([]*Agent, error)
+		// Count 统计符合条件的 agent 数量
+		Count(context.Context, map[string]interface{}) (int64, error)
+		// Heartbeat 根据 InstanceID 创建或者更新 agent, 并刷新最后心跳时间, 返回 agent 的ID
+		Heartbeat(context.Context, *Agent) (int64, error)
+		// MarkOffline 将最后心跳时间早于给定时间的 agent 标记为离线
+		MarkOffline(context.Context, time.Time) (int64, error)
+	}
+
+	// AgentBinaryDao 定义了一组从数据库操作 agent 二进制文件元数据的一系列操作
+	AgentBinaryDao interface {
+		// Get 根据ID从数据库中获取二进制文件元数据
+		Get(context.Context, int64) (*AgentBinary, error)
+		// Find 根据版本和平台获取二进制文件元数据
+		Find(ctx context.Context, version, os, arch string) (*AgentBinary, error)
+		// List 从数据库中获取所有二进制文件元数据
+		List(context.Context) ([]*AgentBinary, error)
+		// Create 在数据库中创建一个二进制文件元数据
+		Create(context.Context, *AgentBinary) (int64, error)
+		// Delete 从数据库中删除一个二进制文件元数据
+		Delete(context.Context, int64) error
+	}
+
+	// AgentRolloutDao 定义了一组从数据库操作灰度升级任务的一系列操作
+	AgentRolloutDao interface {
+		// Get 根据ID从数据库中获取灰度升级任务
+		Get(context.Context, int64) (*AgentRollout, error)
+		// List 从数据库中获取一组灰度升级任务
+		List(context.Context, map[string]interface{}) ([]*AgentRollout, error)
+		// Create 创建灰度升级任务以及任务中的所有主机
+		Create(context.Context, *AgentRollout, []*AgentRolloutHost) (int64, error)
+		// Update 更新灰度升级任务的阶段、状态和信息
+		Update(context.Context, *AgentRollout) error
+		// Claim 只有任务由 owner 认领或者租约已经过期时才将其认领给 owner, 租约到 until 为止
+		Claim(ctx context.Context, id int64, owner string, now, until time.Time) (bool, error)
+		// ListHosts 获取灰度升级任务中的所有主机
+		ListHosts(context.Context, int64) ([]*AgentRolloutHost, error)
+		// UpdateHost 更新灰度升级任务中单个主机的状态
+		UpdateHost(context.Context, *AgentRolloutHost) error
+	}
+
+	// AgentMessageDao 记录已经处理过的 agent 消息ID, 用于丢弃 agent 重发的消息
+	AgentMessageDao interface {
+		// Seen 判断消息是否已经处理过
+		Seen(ctx context.Context, agentID int64, messageID string) (bool, error)
+		// Record 在消息处理成功后记录消息ID, 已经记录过时返回 false
+		Record(ctx context.Context, agentID int64, messageID string) (bool, error)
+		// Prune 删除早于给定时间的记录
+		Prune(context.Context, time.Time) (int64, error)
+	}
+)
+
+// ErrInvalidRolloutStages 灰度阶段不合法
+var ErrInvalidRolloutStages = errors.New("rollout stages must be increasing percentages ending with 100")
+
+// Validate 校验灰度阶段: 必须严格递增, 取值范围 (0, 100], 并且最后一个阶段为 100
+func (s RolloutStages) Validate() error {
+	if len(s) == 0 || s[len(s)-1] != 100 {
+		return ErrInvalidRolloutStages
+	}
+	prev := 0
+	for _, p := range s {
+		if p <= prev || p > 100 {
+			return ErrInvalidRolloutStages
+		}
+		prev = p
+	}
+	return nil
+}
+
+// Value 实现 driver.Valuer 接口, 数据库中以逗号分隔的字符串保存
+func (s RolloutStages) Value() (driver.Value, error) {
+	parts := make([]string, 0, len(s))
+	for _, p := range s {
+		parts = append(parts, strconv.Itoa(p))
+	}
+	return strings.Join(parts, ","), nil
+}
+
+// Scan 实现 sql.Scanner 接口
+func (s *RolloutStages) Scan(src interface{}) error {
+	var str string
+	switch v := src.(type) {
+	case []byte:
+		str = string(v)
+	case string:
+		str = v
+	case nil:
+		*s = nil
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into RolloutStages", src)
+	}
+	stages := RolloutStages{}
+	for _, part := range strings.Split(str, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		p, err := strconv.Atoi(part)
+		if err != nil {
+			return err
+		}
+		stages = append(stages, p)
+	}
+	*s = stages
+	return nil
+}
@@ -0,0 +1,169 @@
+package core
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 应用发布的状态
+const (
+	AppReleaseDeploying = "deploying"
+	AppReleaseApplied   = "applied"
+	AppReleaseFailed    = "failed"
+)
+
+// ErrInvalidApplication 应用或者环境的配置不合法
+var ErrInvalidApplication = errors.New("invalid application")
+
+type (
+	// Application 应用, 定义镜像、端口、环境变量、资源和副本数, 绑定到服务树节点
+	Application struct {
+		ID            int64     `db:"id" json:"id"`
+		Name          string    `db:"name" json:"name"`
+		Description   string    `db:"description" json:"description"`
+		ServiceNodeID int64     `db:"service_node_id" json:"service_node_id"`
+		Spec          AppSpec   `db:"spec" json:"spec"`
+		Creator       string    `db:"creator" json:"creator"`
+		CreateTime    time.Time `db:"create_time" json:"create_time"`
+		UpdateTime    time.Time `db:"update_time" json:"update_time"`
+	}
+
+	// AppSpec 应用的部署参数, 作为环境的覆盖配置时零值表示沿用应用的配置
+	AppSpec struct {
+		Image     string       `json:"image"`
+		Replicas  int32        `json:"replicas"`
+		Ports     []AppPort    `json:"ports"`
+		Env       StringMap    `json:"env"`
+		Resources AppResources `json:"resources"`
+		Ingress   *AppIngress  `json:"ingress"`
+	}
+
+	// AppPort 容器端口, 同时作为 Service 的端口
+	AppPort struct {
+		Name     string `json:"name"`
+		Port     int32  `json:"port"`
+		Protocol string `json:"protocol"`
+	}
+
+	// AppResources 容器的资源请求和限制, 例如 {"cpu": "500m", "memory": "1Gi"}
+	AppResources struct {
+		Requests StringMap `json:"requests"`
+		Limits   StringMap `json:"limits"`
+	}
+
+	// AppIngress 应用的访问入口, Port 为空时使用第一个端口
+	AppIngress struct {
+		Host      string `json:"host"`
+		Path      string `json:"path"`
+		Port      int32  `json:"port"`
+		ClassName string `json:"class_name"`
+	}
+
+	// AppEnvironment 应用的部署环境(dev/test/prod), 部署到指定集群的 namespace 中
+	AppEnvironment struct {
+		ID         int64     `db:"id" json:"id"`
+		AppID      int64     `db:"app_id" json:"app_id"`
+		Name       string    `db:"name" json:"name"`
+		ClusterID  int64     `db:"cluster_id" json:"cluster_id"`
+		Namespace  string    `db:"namespace" json:"namespace"`
+		Overrides  AppSpec   `db:"overrides" json:"overrides"`
+		Creator    string    `db:"creator" json:"creator"`
+		CreateTime time.Time `db:"create_time" json:"create_time"`
+		UpdateTime time.Time `db:"update_time" json:"update_time"`
+	}
+
+	// AppRelease 应用在某个环境的一次发布, 记录合并后的部署参数和渲染出的清单
+	// 回滚会使用历史发布的部署参数生成一个新的发布, RollbackFrom 为被回滚到的版本
+	AppRelease struct {
+		ID           int64     `db:"id" json:"id"`
+		AppID        int64     `db:"app_id" json:"app_id"`
+		EnvID        int64     `db:"env_id" json:"env_id"`
+		Revision     int64     `db:"revision" json:"revision"`
+		Image        string    `db:"image" json:"image"`
+		Spec         AppSpec   `db:"spec" json:"spec"`
+		Manifest     string    `db:"manifest" json:"manifest"`
+		Status       string    `db:"status" json:"status"`
+		Message      string    `db:"message" json:"message"`
+		RollbackFrom int64     `db:"rollback_from" json:"rollback_from"`
+		Creator      string    `db:"creator" json:"creator"`
+		CreateTime   time.Time `db:"create_time" json:"create_time"`
+	}
+
+	// ApplicationDao 定义了一组从数据库操作应用的一系列操作
+	ApplicationDao interface {
+		// Get 根据ID从数据库中获取应用
+		Get(context.Context, int64) (*Application, error)
+		// List 从数据库中获取一组应用, 支持按 service_node_id 过滤
+		List(context.Context, map[string]interface{}) ([]*Application, error)
+		// Count 统计符合条件的应用数量
+		Count(context.Context, map[string]interface{}) (int64, error)
+		// Create 在数据库中创建一个应用
+		Create(context.Context, *Application) (int64, error)
+		// Update 更新应用的描述、服务树节点和部署参数
+		Update(context.Context, *Application) error
+		// Delete 从数据库中删除一个应用
+		Delete(context.Context, int64) error
+	}
+
+	// AppEnvironmentDao 定义了一组从数据库操作应用环境的一系列操作
+	AppEnvironmentDao interface {
+		// Get 根据ID从数据库中获取环境
+		Get(context.Context, int64) (*AppEnvironment, error)
+		// List 获取应用的所有环境
+		List(ctx context.Context, appID int64) ([]*AppEnvironment, error)
+		// Create 在数据库中创建一个环境
+		Create(context.Context, *AppEnvironment) (int64, error)
+		// Update 更新环境的集群、namespace 和覆盖配置
+		Update(context.Context, *AppEnvironment) error
+		// Delete 从数据库中删除一个环境
+		Delete(context.Context, int64) error
+	}
+
+	// AppReleaseDao 定义了一组从数据库操作应用发布记录的一系列操作
+	AppReleaseDao interface {
+		// Get 根据ID从数据库中获取发布记录
+		Get(context.Context, int64) (*AppRelease, error)
+		// List 从数据库中获取一组发布记录, 支持按 app_id/env_id/status 过滤, 按版本倒序
+		List(context.Context, map[string]interface{}) ([]*AppRelease, error)
+		// Count 统计符合条件的发布记录数量
+		Count(context.Context, map[string]interface{}) (int64, error)
+		// Create 在数据库中创建一个发布记录, 版本号为环境当前最大版本号加一
+		Create(context.Context, *AppRelease) (int64, error)
+		// UpdateStatus 更新发布渲染出的清单、状态和结果信息
+		UpdateStatus(context.Context, *AppRelease) error
+	}
+)
+
+// Value 实现 driver.Valuer 接口
+func (s AppSpec) Value() (driver.Value, error) {
+	data, err := json.Marshal(s)
+	return string(data), err
+}
+
+// Scan 实现 sql.Scanner 接口
+func (s *AppSpec) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	case nil:
+		*s = AppSpec{}
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into AppSpec", src)
+	}
+	out := AppSpec{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &out); err != nil {
+			return err
+		}
+	}
+	*s = out
+	return nil
+}
@@ -0,0 +1,202 @@
+package core
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 需要审批的操作, 只有匹配到审批规则的操作才需要审批
+const (
+	// ApprovalHostDelete 删除主机
+	ApprovalHostDelete = "host.delete"
+	// ApprovalNamespaceDelete 删除纳管的 namespace 以及集群中的 namespace
+	ApprovalNamespaceDelete = "kube_namespace.delete"
+	// ApprovalAppDeploy 发布应用到环境
+	ApprovalAppDeploy = "app.deploy"
+	// ApprovalAppRollback 回滚应用到历史版本, 与发布使用相同的审批规则
+	ApprovalAppRollback = "app.rollback"
+)
+
+// 审批请求的状态, executing 表示审批已经通过, 正在执行被保护的操作
+const (
+	ApprovalPending   = "pending"
+	ApprovalExecuting = "executing"
+	ApprovalExecuted  = "executed"
+	ApprovalFailed    = "failed"
+	ApprovalRejected  = "rejected"
+	ApprovalExpired   = "expired"
+	ApprovalCanceled  = "canceled"
+)
+
+// 审批请求的审计记录类型
+const (
+	ApprovalEventCreated  = "created"
+	ApprovalEventApproved = "approved"
+	ApprovalEventRejected = "rejected"
+	ApprovalEventComment  = "comment"
+	ApprovalEventCanceled = "canceled"
+	ApprovalEventExpired  = "expired"
+	ApprovalEventExecuted = "executed"
+	ApprovalEventFailed   = "failed"
+)
+
+// ErrInvalidApprovalRule 审批规则不合法
+var ErrInvalidApprovalRule = errors.New("invalid approval rule")
+
+type (
+	// StringList 以 JSON 数组格式保存在数据库中的字符串列表
+	StringList []string
+
+	// ApprovalRule 审批规则, 决定哪些操作需要审批以及由谁审批
+	// ServiceNodeID/EnvID 为0时匹配所有服务树节点/环境; 多个规则匹配时使用范围最小的规则, 环境优先于服务树节点
+	// Required 是需要的同意人数, 即 Approvers 中的 N 个人同意后执行操作; ExpireHours 为0时使用默认值
+	ApprovalRule struct {
+		ID            int64      `db:"id" json:"id"`
+		Name          string     `db:"name" json:"name"`
+		Action        string     `db:"action" json:"action"`
+		ServiceNodeID int64      `db:"service_node_id" json:"service_node_id"`
+		EnvID         int64      `db:"env_id" json:"env_id"`
+		Approvers     StringList `db:"approvers" json:"approvers"`
+		Required      int        `db:"required" json:"required"`
+		ExpireHours   int64      `db:"expire_hours" json:"expire_hours"`
+		Creator       string     `db:"creator" json:"creator"`
+		CreateTime    time.Time  `db:"create_time" json:"create_time"`
+		UpdateTime    time.Time  `db:"update_time" json:"update_time"`
+	}
+
+	// ApprovalRequest 一次需要审批的操作, Payload 是执行操作需要的参数
+	// Approvers/Required 是创建时匹配到的规则的快照, 修改规则不影响已经创建的请求
+	ApprovalRequest struct {
+		ID            int64           `db:"id" json:"id"`
+		RuleID        int64           `db:"rule_id" json:"rule_id"`
+		Action        string          `db:"action" json:"action"`
+		ResourceType  string          `db:"resource_type" json:"resource_type"`
+		ResourceID    string          `db:"resource_id" json:"resource_id"`
+		ServiceNodeID int64           `db:"service_node_id" json:"service_node_id"`
+		EnvID         int64           `db:"env_id" json:"env_id"`
+		Payload       json.RawMessage `db:"payload" json:"payload"`
+		Reason        string          `db:"reason" json:"reason"`
+		Requester     string          `db:"requester" json:"requester"`
+		RequesterID   int64           `db:"requester_id" json:"requester_id"`
+		Approvers     StringList      `db:"approvers" json:"approvers"`
+		Required      int             `db:"required" json:"required"`
+		Status        string          `db:"status" json:"status"`
+		Result        string          `db:"result" json:"result"`
+		ExpireTime    time.Time       `db:"expire_time" json:"expire_time"`
+		CreateTime    time.Time       `db:"create_time" json:"create_time"`
+		UpdateTime    time.Time       `db:"update_time" json:"update_time"`
+		FinishTime    *time.Time      `db:"finish_time" json:"finish_time"`
+	}
+
+	// ApprovalEvent 审批请求的审计记录, 创建、同意、拒绝、评论、取消、过期和执行结果都会记录
+	ApprovalEvent struct {
+		ID         int64     `db:"id" json:"id"`
+		RequestID  int64     `db:"request_id" json:"request_id"`
+		Type       string    `db:"type" json:"type"`
+		Operator   string    `db:"operator" json:"operator"`
+		Comment    string    `db:"comment" json:"comment"`
+		CreateTime time.Time `db:"create_time" json:"create_time"`
+	}
+
+	// ApprovalRuleDao 定义了一组从数据库操作审批规则的一系列操作
+	ApprovalRuleDao interface {
+		// Get 根据ID从数据库中获取审批规则
+		Get(context.Context, int64) (*ApprovalRule, error)
+		// List 从数据库中获取一组审批规则, 支持按 action 过滤, 按ID排序
+		List(context.Context, map[string]interface{}) ([]*ApprovalRule, error)
+		// Create 在数据库中创建一个审批规则
+		Create(context.Context, *ApprovalRule) (int64, error)
+		// Update 更新审批规则
+		Update(context.Context, *ApprovalRule) error
+		// Delete 从数据库中删除一个审批规则
+		Delete(context.Context, int64) error
+	}
+
+	// ApprovalRequestDao 定义了一组从数据库操作审批请求的一系列操作
+	ApprovalRequestDao interface {
+		// Get 根据ID从数据库中获取审批请求
+		Get(context.Context, int64) (*ApprovalRequest, error)
+		// List 从数据库中获取一组审批请求, 支持按 action/status/requester/resource_type/resource_id 过滤
+		List(context.Context, map[string]interface{}) ([]*ApprovalRequest, error)
+		// Count 统计符合条件的审批请求数量
+		Count(context.Context, map[string]interface{}) (int64, error)
+		// ListExpired 获取过期时间早于给定时间的待审批请求
+		ListExpired(context.Context, time.Time) ([]*ApprovalRequest, error)
+		// Create 在数据库中创建一个审批请求
+		Create(context.Context, *ApprovalRequest) (int64, error)
+		// Transition 只有当前状态为 from 时才将状态更新为 to, 返回是否更新成功, 用于保证操作只执行一次
+		Transition(ctx context.Context, id int64, from, to string) (bool, error)
+		// Finish 保存审批请求的最终状态和执行结果
+		Finish(context.Context, *ApprovalRequest) error
+		// AddEvent 添加一条审计记录
+		AddEvent(context.Context, *ApprovalEvent) error
+		// ListEvents 获取审批请求的所有审计记录, 按时间排序
+		ListEvents(context.Context, int64) ([]*ApprovalEvent, error)
+	}
+)
+
+// Contains 判断列表中是否包含 s
+func (l StringList) Contains(s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Value 实现 driver.Valuer 接口
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal(l)
+	return string(data), err
+}
+
+// Scan 实现 sql.Scanner 接口
+func (l *StringList) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	case nil:
+		*l = nil
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into StringList", src)
+	}
+	out := StringList{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &out); err != nil {
+			return err
+		}
+	}
+	*l = out
+	return nil
+}
+
+// Validate 校验审批规则, 返回的错误包含 ErrInvalidApprovalRule
+func (r *ApprovalRule) Validate() error {
+	switch r.Action {
+	case ApprovalHostDelete, ApprovalNamespaceDelete, ApprovalAppDeploy:
+	default:
+		return fmt.Errorf("%w: unknown action %q", ErrInvalidApprovalRule, r.Action)
+	}
+	if r.Name == "" || len(r.Approvers) == 0 {
+		return fmt.Errorf("%w: name and approvers are required", ErrInvalidApprovalRule)
+	}
+	if r.Required < 1 || r.Required > len(r.Approvers) {
+		return fmt.Errorf("%w: required must be between 1 and the number of approvers", ErrInvalidApprovalRule)
+	}
+	if r.ExpireHours < 0 {
+		return fmt.Errorf("%w: expire_hours must not be negative", ErrInvalidApprovalRule)
+	}
+	return nil
+}
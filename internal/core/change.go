@@ -0,0 +1,34 @@
+package core
+
+// 资源变更事件中的资源类型, 主机使用 ResourceHost
+const (
+	ResourceAgent = "agent"
+	// ResourcePipelineRun/ResourceCronRun/ResourceTask 只在创建和状态变化时发布, 任务进度的更新不发布
+	ResourcePipelineRun = "pipeline_run"
+	ResourceCronRun     = "cron_run"
+	ResourceTask        = "task"
+)
+
+// 资源变更的操作, 对应事件总线主题 <resource>.<op> 的后缀
+const (
+	ChangeCreated = "created"
+	ChangeUpdated = "updated"
+	ChangeDeleted = "deleted"
+	// ChangeStatus 资源的状态发生变化, 除了 updated 之外额外发布, 例如 host.status_changed
+	ChangeStatus = "status_changed"
+)
+
+// ResourceChange DAO 写入成功后发布到事件总线的资源变更, 主题为 <Resource>.<Op>, 例如 host.created
+// Data 是变更后的资源, 删除时为删除前的资源; Previous 只在更新时存在, 是更新前的资源
+type ResourceChange struct {
+	Resource string      `json:"resource"`
+	ID       int64       `json:"id"`
+	Op       string      `json:"op"`
+	Data     interface{} `json:"data"`
+	Previous interface{} `json:"previous,omitempty"`
+}
+
+// Topic 返回变更在事件总线中的主题
+func (c *ResourceChange) Topic() string {
+	return c.Resource + "." + c.Op
+}
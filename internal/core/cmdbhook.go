@@ -0,0 +1,89 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// CMDB 变更 webhook 投递的状态, dead 表示重试次数用完后进入死信列表, 只能手动重新投递
+const (
+	CMDBHookPending   = "pending"
+	CMDBHookDelivered = "delivered"
+	CMDBHookDead      = "dead"
+)
+
+// ErrInvalidCMDBHook webhook 订阅的地址或者事件过滤不合法
+var ErrInvalidCMDBHook = errors.New("invalid cmdb webhook")
+
+type (
+	// CMDBHook 外部系统订阅的 CMDB 变更 webhook
+	// Events 是事件过滤, 例如 host.created、host.status_changed, 支持 host.* 这样的前缀和 *; 目前只支持主机的变更
+	// Secret 是加密后的签名密钥, 不会返回给前端
+	CMDBHook struct {
+		ID         int64      `db:"id" json:"id"`
+		Name       string     `db:"name" json:"name"`
+		URL        string     `db:"url" json:"url"`
+		Secret     []byte     `db:"secret" json:"-"`
+		Events     StringList `db:"events" json:"events"`
+		Enabled    bool       `db:"enabled" json:"enabled"`
+		Creator    string     `db:"creator" json:"creator"`
+		CreateTime time.Time  `db:"create_time" json:"create_time"`
+		UpdateTime time.Time  `db:"update_time" json:"update_time"`
+	}
+
+	// CMDBHookDelivery 一次变更事件到一个订阅的投递, Payload 是发送的 JSON
+	// 失败时按指数退避在 NextTime 重试, ResponseCode 是最后一次请求的 HTTP 状态码
+	// 投递前由 Worker 领取, 领取期间 NextTime 是租约的到期时间, 其他实例不会重复投递
+	CMDBHookDelivery struct {
+		ID            int64      `db:"id" json:"id"`
+		HookID        int64      `db:"hook_id" json:"hook_id"`
+		Event         string     `db:"event" json:"event"`
+		Payload       string     `db:"payload" json:"payload"`
+		Status        string     `db:"status" json:"status"`
+		Attempts      int        `db:"attempts" json:"attempts"`
+		ResponseCode  int        `db:"response_code" json:"response_code"`
+		LastError     string     `db:"last_error" json:"last_error"`
+		NextTime      time.Time  `db:"next_time" json:"next_time"`
+		Worker        string     `db:"worker" json:"worker"`
+		CreateTime    time.Time  `db:"create_time" json:"create_time"`
+		UpdateTime    time.Time  `db:"update_time" json:"update_time"`
+		DeliveredTime *time.Time `db:"delivered_time" json:"delivered_time"`
+	}
+
+	// CMDBHookDao 定义了一组从数据库操作 CMDB webhook 订阅的一系列操作
+	CMDBHookDao interface {
+		// Get 根据ID从数据库中获取订阅
+		Get(context.Context, int64) (*CMDBHook, error)
+		// List 获取所有订阅
+		List(context.Context) ([]*CMDBHook, error)
+		// Create 在数据库中创建一个订阅
+		Create(context.Context, *CMDBHook) (int64, error)
+		// Update 更新订阅
+		Update(context.Context, *CMDBHook) error
+		// Delete 删除订阅, 投递记录保留
+		Delete(context.Context, int64) error
+	}
+
+	// CMDBHookDeliveryDao 定义了一组从数据库操作 CMDB webhook 投递记录的一系列操作
+	CMDBHookDeliveryDao interface {
+		// Get 根据ID从数据库中获取投递记录
+		Get(context.Context, int64) (*CMDBHookDelivery, error)
+		// List 获取一组投递记录, 支持按 hook_id/event/status 过滤, 按ID倒序
+		List(context.Context, map[string]interface{}) ([]*CMDBHookDelivery, error)
+		// Count 统计符合条件的投递记录数量
+		Count(context.Context, map[string]interface{}) (int64, error)
+		// ListDue 获取 NextTime 已到的待投递记录, 最多 limit 条
+		ListDue(ctx context.Context, now time.Time, limit int) ([]*CMDBHookDelivery, error)
+		// Create 创建投递记录
+		Create(context.Context, *CMDBHookDelivery) (int64, error)
+		// Claim 领取到期的待投递记录, 将 NextTime 设置为 until 作为租约, 已经被其他实例领取时返回 false
+		Claim(ctx context.Context, id int64, worker string, now, until time.Time) (bool, error)
+		// Update 更新投递的状态、次数、响应和下次投递时间; 只有记录仍然由 Worker 领取时才会成功
+		Update(context.Context, *CMDBHookDelivery) (bool, error)
+		// Requeue 将死信重新放回队列并清空次数, 记录不是死信时返回 false
+		Requeue(context.Context, int64) (bool, error)
+		// Prune 删除创建时间早于给定时间并且已经投递成功的记录, 死信保留到手动处理
+		Prune(context.Context, time.Time) (int64, error)
+	}
+)
@@ -0,0 +1,103 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// 定时任务执行记录的状态, pending 表示手动触发后等待某个副本认领
+const (
+	CronRunPending   = "pending"
+	CronRunRunning   = "running"
+	CronRunSucceeded = "succeeded"
+	CronRunFailed    = "failed"
+)
+
+// 定时任务执行的触发方式
+const (
+	CronTriggerSchedule = "schedule"
+	CronTriggerManual   = "manual"
+)
+
+// ErrInvalidCronJob 定时任务的类型、cron 表达式、时区或者参数不合法
+var ErrInvalidCronJob = errors.New("invalid cron job")
+
+type (
+	// CronJob 按 cron 表达式定期执行的任务, Schedule 为标准的5段表达式或者 @daily 这样的描述符
+	// Timezone 是计算执行时间使用的时区, 为空时使用配置文件中的默认时区; Timeout 为0时使用默认的超时时间
+	// NextTime 是下一次执行的时间, 多个副本通过比较并更新 NextTime 认领同一次执行, 只有一个副本会成功
+	CronJob struct {
+		ID         int64           `db:"id" json:"id"`
+		Name       string          `db:"name" json:"name"`
+		TaskType   string          `db:"task_type" json:"task_type"`
+		Schedule   string          `db:"schedule" json:"schedule"`
+		Timezone   string          `db:"timezone" json:"timezone"`
+		Params     json.RawMessage `db:"params" json:"params"`
+		Timeout    int64           `db:"timeout" json:"timeout"`
+		Paused     bool            `db:"paused" json:"paused"`
+		NextTime   *time.Time      `db:"next_time" json:"next_time"`
+		LastTime   *time.Time      `db:"last_time" json:"last_time"`
+		Creator    string          `db:"creator" json:"creator"`
+		CreateTime time.Time       `db:"create_time" json:"create_time"`
+		UpdateTime time.Time       `db:"update_time" json:"update_time"`
+	}
+
+	// CronRun 定时任务的一次执行, Output 只保留最后一部分输出
+	// Deadline 是执行的超时时间, 超过 Deadline 仍然处于 running 的执行说明所在的副本已经退出, 会被标记为失败
+	CronRun struct {
+		ID         int64      `db:"id" json:"id"`
+		JobID      int64      `db:"job_id" json:"job_id"`
+		TaskType   string     `db:"task_type" json:"task_type"`
+		Trigger    string     `db:"trigger_type" json:"trigger"`
+		Operator   string     `db:"operator" json:"operator"`
+		Status     string     `db:"status" json:"status"`
+		Output     string     `db:"output" json:"output,omitempty"`
+		Error      string     `db:"error" json:"error"`
+		Deadline   *time.Time `db:"deadline" json:"deadline"`
+		StartTime  *time.Time `db:"start_time" json:"start_time"`
+		FinishTime *time.Time `db:"finish_time" json:"finish_time"`
+		CreateTime time.Time  `db:"create_time" json:"create_time"`
+	}
+
+	// CronJobDao 定义了一组从数据库操作定时任务的一系列操作
+	CronJobDao interface {
+		// Get 根据ID从数据库中获取定时任务
+		Get(context.Context, int64) (*CronJob, error)
+		// List 获取一组定时任务, 支持按 task_type/paused 过滤
+		List(context.Context, map[string]interface{}) ([]*CronJob, error)
+		// ListDue 获取没有暂停并且 NextTime 已到的定时任务
+		ListDue(context.Context, time.Time) ([]*CronJob, error)
+		// Create 在数据库中创建一个定时任务
+		Create(context.Context, *CronJob) (int64, error)
+		// Update 更新定时任务的配置、暂停状态和下一次执行时间
+		Update(context.Context, *CronJob) error
+		// Delete 删除定时任务, 执行记录保留
+		Delete(context.Context, int64) error
+		// Claim 只有 NextTime 仍然为 from 时才将其更新为 next 并记录本次执行时间, 返回是否认领成功
+		Claim(ctx context.Context, id int64, from, next time.Time) (bool, error)
+	}
+
+	// CronRunDao 定义了一组从数据库操作定时任务执行记录的一系列操作
+	CronRunDao interface {
+		// Get 根据ID从数据库中获取执行记录
+		Get(context.Context, int64) (*CronRun, error)
+		// List 获取一组执行记录, 支持按 job_id/status/trigger_type 过滤, 按ID倒序, 不包含输出
+		List(context.Context, map[string]interface{}) ([]*CronRun, error)
+		// Count 统计符合条件的执行记录数量
+		Count(context.Context, map[string]interface{}) (int64, error)
+		// ListPending 获取等待认领的手动触发的执行记录
+		ListPending(context.Context) ([]*CronRun, error)
+		// ListStale 获取 Deadline 早于给定时间仍然处于 running 的执行记录
+		ListStale(context.Context, time.Time) ([]*CronRun, error)
+		// Create 创建执行记录
+		Create(context.Context, *CronRun) (int64, error)
+		// Start 只有状态为 pending 时才将执行记录改为 running, 返回是否认领成功
+		Start(context.Context, *CronRun) (bool, error)
+		// Finish 只有状态为 running 时才保存执行结果, 返回是否保存成功
+		Finish(context.Context, *CronRun) (bool, error)
+		// Prune 删除结束时间早于给定时间的执行记录
+		Prune(context.Context, time.Time) (int64, error)
+	}
+)
@@ -0,0 +1,149 @@
+package core
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// GitLab 连接的状态, 由连接测试更新
+const (
+	GitlabUnknown     = "unknown"
+	GitlabConnected   = "connected"
+	GitlabUnreachable = "unreachable"
+)
+
+// 缓存的 GitLab 引用类型
+const (
+	GitlabBranch = "branch"
+	GitlabTag    = "tag"
+)
+
+var (
+	// ErrInvalidGitlabConnection GitLab 连接的地址或者令牌不合法
+	ErrInvalidGitlabConnection = errors.New("invalid gitlab connection")
+	// ErrGitlabConnectionInUse 连接下还有导入的项目, 不能删除
+	ErrGitlabConnectionInUse = errors.New("gitlab connection has imported projects")
+)
+
+type (
+	// GitlabConnection GitLab 连接配置
+	// Token 是加密后的访问令牌, 不会返回给前端
+	GitlabConnection struct {
+		ID            int64      `db:"id" json:"id"`
+		Name          string     `db:"name" json:"name"`
+		BaseURL       string     `db:"base_url" json:"base_url"`
+		Token         []byte     `db:"token" json:"-"`
+		Status        string     `db:"status" json:"status"`
+		Version       string     `db:"version" json:"version"`
+		Username      string     `db:"username" json:"username"`
+		LastError     string     `db:"last_error" json:"last_error"`
+		LastCheckTime *time.Time `db:"last_check_time" json:"last_check_time"`
+		Creator       string     `db:"creator" json:"creator"`
+		CreateTime    time.Time  `db:"create_time" json:"create_time"`
+		UpdateTime    time.Time  `db:"update_time" json:"update_time"`
+	}
+
+	// GitlabProject 导入的 GitLab 项目, 绑定到服务树节点
+	// Refs 是缓存的分支和标签, 用于发布时选择版本
+	GitlabProject struct {
+		ID            int64      `db:"id" json:"id"`
+		ConnectionID  int64      `db:"connection_id" json:"connection_id"`
+		GitlabID      int64      `db:"gitlab_id" json:"gitlab_id"`
+		Name          string     `db:"name" json:"name"`
+		Path          string     `db:"path" json:"path"`
+		WebURL        string     `db:"web_url" json:"web_url"`
+		HTTPURL       string     `db:"http_url" json:"http_url"`
+		DefaultBranch string     `db:"default_branch" json:"default_branch"`
+		ServiceNodeID int64      `db:"service_node_id" json:"service_node_id"`
+		Refs          GitlabRefs `db:"refs" json:"refs,omitempty"`
+		RefsError     string     `db:"refs_error" json:"refs_error"`
+		RefsSyncTime  *time.Time `db:"refs_sync_time" json:"refs_sync_time"`
+		Creator       string     `db:"creator" json:"creator"`
+		CreateTime    time.Time  `db:"create_time" json:"create_time"`
+		UpdateTime    time.Time  `db:"update_time" json:"update_time"`
+	}
+
+	// GitlabRef 项目的分支或者标签
+	GitlabRef struct {
+		Kind       string    `json:"kind"`
+		Name       string    `json:"name"`
+		CommitSHA  string    `json:"commit_sha"`
+		CommitTime time.Time `json:"commit_time"`
+	}
+
+	// GitlabRefs 以 JSON 格式保存在数据库中的分支和标签
+	GitlabRefs []GitlabRef
+
+	// GitlabConnectionDao 定义了一组从数据库操作 GitLab 连接的一系列操作
+	GitlabConnectionDao interface {
+		// Get 根据ID从数据库中获取连接
+		Get(context.Context, int64) (*GitlabConnection, error)
+		// List 从数据库中获取所有连接
+		List(context.Context) ([]*GitlabConnection, error)
+		// Create 在数据库中创建一个连接
+		Create(context.Context, *GitlabConnection) (int64, error)
+		// Update 更新连接的名称、地址和令牌
+		Update(context.Context, *GitlabConnection) error
+		// UpdateStatus 更新连接测试的结果
+		UpdateStatus(context.Context, *GitlabConnection) error
+		// Delete 从数据库中删除一个连接
+		Delete(context.Context, int64) error
+	}
+
+	// GitlabProjectDao 定义了一组从数据库操作导入的 GitLab 项目的一系列操作
+	GitlabProjectDao interface {
+		// Get 根据ID从数据库中获取项目
+		Get(context.Context, int64) (*GitlabProject, error)
+		// GetByGitlabID 根据连接和 GitLab 中的项目ID获取项目
+		GetByGitlabID(ctx context.Context, connectionID, gitlabID int64) (*GitlabProject, error)
+		// List 从数据库中获取一组项目, 支持按 connection_id/service_node_id 过滤, 列表中不包含 Refs
+		List(context.Context, map[string]interface{}) ([]*GitlabProject, error)
+		// Count 统计符合条件的项目数量
+		Count(context.Context, map[string]interface{}) (int64, error)
+		// Create 在数据库中创建一个项目
+		Create(context.Context, *GitlabProject) (int64, error)
+		// Update 更新项目的基本信息和绑定的服务树节点
+		Update(context.Context, *GitlabProject) error
+		// UpdateRefs 更新缓存的分支和标签
+		UpdateRefs(context.Context, *GitlabProject) error
+		// Delete 从数据库中删除一个项目
+		Delete(context.Context, int64) error
+	}
+)
+
+// Value 实现 driver.Valuer 接口
+func (r GitlabRefs) Value() (driver.Value, error) {
+	if r == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal(r)
+	return string(data), err
+}
+
+// Scan 实现 sql.Scanner 接口
+func (r *GitlabRefs) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	case nil:
+		*r = nil
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into GitlabRefs", src)
+	}
+	out := GitlabRefs{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &out); err != nil {
+			return err
+		}
+	}
+	*r = out
+	return nil
+}
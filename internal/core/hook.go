@@ -0,0 +1,47 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// 接收的事件来源
+const (
+	HookSourceGitlab  = "gitlab"
+	HookSourceJenkins = "jenkins"
+)
+
+// ErrInvalidHookPayload 事件的请求体无法解析
+var ErrInvalidHookPayload = errors.New("invalid hook payload")
+
+type (
+	// HookEvent 从 GitLab 或者 Jenkins 接收的事件, (source, delivery_id) 唯一, 重复投递的事件只保存一次
+	// EventType 对于 GitLab 是 object_kind, 例如 push/tag_push/merge_request; 对于 Jenkins 是 build.<phase>
+	// Project 对于 GitLab 是项目的完整路径, 对于 Jenkins 是任务的完整路径
+	HookEvent struct {
+		ID         int64     `db:"id" json:"id"`
+		Source     string    `db:"source" json:"source"`
+		DeliveryID string    `db:"delivery_id" json:"delivery_id"`
+		EventType  string    `db:"event_type" json:"event_type"`
+		Project    string    `db:"project" json:"project"`
+		Ref        string    `db:"ref" json:"ref"`
+		CommitSHA  string    `db:"commit_sha" json:"commit_sha"`
+		Payload    string    `db:"payload" json:"payload,omitempty"`
+		CreateTime time.Time `db:"create_time" json:"create_time"`
+	}
+
+	// HookEventDao 定义了一组从数据库操作接收的事件的一系列操作
+	HookEventDao interface {
+		// Create 保存事件, 相同来源和投递ID的事件已经存在时返回 false
+		Create(context.Context, *HookEvent) (bool, error)
+		// Get 根据ID从数据库中获取事件, 包含原始请求体
+		Get(context.Context, int64) (*HookEvent, error)
+		// List 从数据库中获取一组事件, 支持按 source/event_type/project 过滤, 列表中不包含原始请求体
+		List(context.Context, map[string]interface{}) ([]*HookEvent, error)
+		// Count 统计符合条件的事件数量
+		Count(context.Context, map[string]interface{}) (int64, error)
+		// Prune 删除早于给定时间的事件
+		Prune(context.Context, time.Time) (int64, error)
+	}
+)
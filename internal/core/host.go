@@ -5,13 +5,20 @@ import (
 	"time"
 )
 
+// 主机状态, 0 表示未知
+const (
+	HostStatusOnline  = 1
+	HostStatusOffline = 2
+)
+
 type (
 	// HostInstance 主机实例(宿主机、云主机、虚拟机)
+	// 从 kubernetes 集群同步的节点 KubeClusterID 不为 0, KubeNodeName 为节点名称
 	HostInstance struct {
 		ID            int64     `db:"id" json:"id"`
 		InstanceID    string    `db:"instance_id" json:"instance_id"`
 		HostName      string    `db:"host_name" json:"host_name"`
-		CPUCores      int8      `db:"cpu_cores" json:"cpu_cores"`
+		CPUCores      int       `db:"cpu_cores" json:"cpu_cores"`
 		CPUSockets    int8      `db:"cpu_sockets" json:"cpu_sockets"`
 		MemSize       int       `db:"mem_size" json:"mem_size"`
 		OSName        string    `db:"os_name" json:"os_name"`
@@ -19,6 +26,8 @@ type (
 		ConnPort      int       `db:"conn_port" json:"conn_port"`
 		HostStatus    int       `db:"host_status" json:"host_status"`
 		HostType      int       `db:"host_type" json:"host_type"`
+		KubeClusterID int64     `db:"kube_cluster_id" json:"kube_cluster_id"`
+		KubeNodeName  string    `db:"kube_node_name" json:"kube_node_name"`
 		CreateTime    time.Time `db:"create_time" json:"create_time"`
 		UpdateTime    time.Time `db:"update_time" json:"update_time"`
 		Remark        string    `db:"remark" json:"remark"`
@@ -27,8 +36,12 @@ type (
 	HostInstanceDao interface {
 		// Get 根据ID从数据库中获取主机实例对象
 		Get(context.Context, int64) (*HostInstance, error)
-		// List 从数据库中获取一组主机实例对象
+		// GetByInstance 根据实例ID从数据库中获取主机实例对象
+		GetByInstance(context.Context, string) (*HostInstance, error)
+		// List 从数据库中获取一组主机实例对象, 支持按 host_status/kube_cluster_id 过滤
 		List(context.Context, map[string]interface{}) ([]*HostInstance, error)
+		// Count 统计符合条件的主机实例数量
+		Count(context.Context, map[string]interface{}) (int64, error)
 		// Create 在数据库中创建一个主机实例对象
 		Create(context.Context, *HostInstance) (int64, error)
 		// Update 更新数据库中已经存在的一个主机实例
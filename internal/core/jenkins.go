@@ -0,0 +1,139 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Jenkins 服务器的状态, 由连接测试更新
+const (
+	JenkinsUnknown     = "unknown"
+	JenkinsConnected   = "connected"
+	JenkinsUnreachable = "unreachable"
+)
+
+// Jenkins 构建的状态, queued/building 为进行中的状态, 其余为 Jenkins 返回的构建结果
+// error 表示排队项丢失或者 Jenkins 返回了无法处理的响应
+const (
+	JenkinsBuildQueued   = "queued"
+	JenkinsBuildBuilding = "building"
+	JenkinsBuildSuccess  = "success"
+	JenkinsBuildFailure  = "failure"
+	JenkinsBuildUnstable = "unstable"
+	JenkinsBuildAborted  = "aborted"
+	JenkinsBuildError    = "error"
+)
+
+var (
+	// ErrInvalidJenkins Jenkins 服务器或者任务的配置不合法
+	ErrInvalidJenkins = errors.New("invalid jenkins server or job")
+	// ErrJenkinsServerInUse 服务器下还有关联的任务, 不能删除
+	ErrJenkinsServerInUse = errors.New("jenkins server has mapped jobs")
+)
+
+type (
+	// JenkinsServer Jenkins 服务器, Token 是加密后的 API Token, 不会返回给前端
+	JenkinsServer struct {
+		ID            int64      `db:"id" json:"id"`
+		Name          string     `db:"name" json:"name"`
+		URL           string     `db:"url" json:"url"`
+		Username      string     `db:"username" json:"username"`
+		Token         []byte     `db:"token" json:"-"`
+		Status        string     `db:"status" json:"status"`
+		Version       string     `db:"version" json:"version"`
+		LastError     string     `db:"last_error" json:"last_error"`
+		LastCheckTime *time.Time `db:"last_check_time" json:"last_check_time"`
+		Creator       string     `db:"creator" json:"creator"`
+		CreateTime    time.Time  `db:"create_time" json:"create_time"`
+		UpdateTime    time.Time  `db:"update_time" json:"update_time"`
+	}
+
+	// JenkinsJob 关联到应用或者服务树节点的 Jenkins 任务
+	// Name 是任务的完整路径, 文件夹中的任务使用 / 分隔, 例如 team/api/build
+	// Parameters 是触发构建时的默认参数, 触发时传入的参数会覆盖同名的默认参数
+	JenkinsJob struct {
+		ID            int64     `db:"id" json:"id"`
+		ServerID      int64     `db:"server_id" json:"server_id"`
+		Name          string    `db:"name" json:"name"`
+		AppID         int64     `db:"app_id" json:"app_id"`
+		ServiceNodeID int64     `db:"service_node_id" json:"service_node_id"`
+		Parameters    StringMap `db:"parameters" json:"parameters"`
+		Creator       string    `db:"creator" json:"creator"`
+		CreateTime    time.Time `db:"create_time" json:"create_time"`
+		UpdateTime    time.Time `db:"update_time" json:"update_time"`
+	}
+
+	// JenkinsBuild 从 easynetes 触发的一次构建
+	// QueueID 是触发后 Jenkins 返回的排队项, 开始构建后得到 Number 和 URL
+	// Duration 单位为毫秒, Console 是控制台日志的末尾部分
+	JenkinsBuild struct {
+		ID         int64      `db:"id" json:"id"`
+		JobID      int64      `db:"job_id" json:"job_id"`
+		Parameters StringMap  `db:"parameters" json:"parameters"`
+		QueueID    int64      `db:"queue_id" json:"queue_id"`
+		Number     int64      `db:"number" json:"number"`
+		URL        string     `db:"url" json:"url"`
+		Status     string     `db:"status" json:"status"`
+		Duration   int64      `db:"duration" json:"duration"`
+		Console    string     `db:"console" json:"console,omitempty"`
+		Message    string     `db:"message" json:"message"`
+		Creator    string     `db:"creator" json:"creator"`
+		CreateTime time.Time  `db:"create_time" json:"create_time"`
+		StartTime  *time.Time `db:"start_time" json:"start_time"`
+		FinishTime *time.Time `db:"finish_time" json:"finish_time"`
+	}
+
+	// JenkinsServerDao 定义了一组从数据库操作 Jenkins 服务器的一系列操作
+	JenkinsServerDao interface {
+		// Get 根据ID从数据库中获取服务器
+		Get(context.Context, int64) (*JenkinsServer, error)
+		// List 从数据库中获取所有服务器
+		List(context.Context) ([]*JenkinsServer, error)
+		// Create 在数据库中创建一个服务器
+		Create(context.Context, *JenkinsServer) (int64, error)
+		// Update 更新服务器的名称、地址和认证信息
+		Update(context.Context, *JenkinsServer) error
+		// UpdateStatus 更新连接测试的结果
+		UpdateStatus(context.Context, *JenkinsServer) error
+		// Delete 从数据库中删除一个服务器
+		Delete(context.Context, int64) error
+	}
+
+	// JenkinsJobDao 定义了一组从数据库操作 Jenkins 任务的一系列操作
+	JenkinsJobDao interface {
+		// Get 根据ID从数据库中获取任务
+		Get(context.Context, int64) (*JenkinsJob, error)
+		// List 从数据库中获取一组任务, 支持按 server_id/app_id/service_node_id 过滤
+		List(context.Context, map[string]interface{}) ([]*JenkinsJob, error)
+		// Count 统计符合条件的任务数量
+		Count(context.Context, map[string]interface{}) (int64, error)
+		// Create 在数据库中创建一个任务
+		Create(context.Context, *JenkinsJob) (int64, error)
+		// Update 更新任务的路径、关联关系和默认参数
+		Update(context.Context, *JenkinsJob) error
+		// Delete 从数据库中删除一个任务
+		Delete(context.Context, int64) error
+	}
+
+	// JenkinsBuildDao 定义了一组从数据库操作 Jenkins 构建记录的一系列操作
+	JenkinsBuildDao interface {
+		// Get 根据ID从数据库中获取构建记录, 包含控制台日志
+		Get(context.Context, int64) (*JenkinsBuild, error)
+		// List 从数据库中获取一组构建记录, 支持按 job_id/status/creator 过滤, 列表中不包含控制台日志
+		List(context.Context, map[string]interface{}) ([]*JenkinsBuild, error)
+		// Count 统计符合条件的构建记录数量
+		Count(context.Context, map[string]interface{}) (int64, error)
+		// ListActive 获取所有排队中和构建中的构建记录
+		ListActive(context.Context) ([]*JenkinsBuild, error)
+		// Create 在数据库中创建一个构建记录
+		Create(context.Context, *JenkinsBuild) (int64, error)
+		// Update 更新构建的编号、状态、耗时和控制台日志
+		Update(context.Context, *JenkinsBuild) error
+	}
+)
+
+// Finished 构建是否已经结束
+func (b *JenkinsBuild) Finished() bool {
+	return b.Status != JenkinsBuildQueued && b.Status != JenkinsBuildBuilding
+}
@@ -0,0 +1,419 @@
+package core
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 集群的认证方式
+const (
+	KubeAuthKubeconfig = "kubeconfig"
+	KubeAuthToken      = "token"
+)
+
+// 集群的连通状态, 由定期的健康检查更新
+const (
+	KubeClusterUnknown     = "unknown"
+	KubeClusterHealthy     = "healthy"
+	KubeClusterUnreachable = "unreachable"
+)
+
+// easynetes 纳管的 namespace 的状态
+const (
+	KubeNamespaceActive  = "active"
+	KubeNamespaceMissing = "missing"
+)
+
+// 支持浏览的工作负载类型, 与 kubernetes API 中的资源名称一致
+const (
+	KubeDeployments  = "deployments"
+	KubeStatefulSets = "statefulsets"
+	KubeDaemonSets   = "daemonsets"
+	KubeJobs         = "jobs"
+)
+
+// 对工作负载执行的操作
+const (
+	KubeActionScale    = "scale"
+	KubeActionRestart  = "restart"
+	KubeActionRollback = "rollback"
+)
+
+// YAML 变更计划的状态, 计划只能在过期之前由创建人或者管理员确认执行一次
+const (
+	KubeApplyPending  = "pending"
+	KubeApplyApplying = "applying"
+	KubeApplyApplied  = "applied"
+	KubeApplyFailed   = "failed"
+)
+
+// 变更计划中单个对象的变更类型
+const (
+	KubeChangeCreate    = "create"
+	KubeChangeUpdate    = "update"
+	KubeChangeUnchanged = "unchanged"
+)
+
+// 授予用户或者用户组的 namespace 角色, 对应集群中内置的同名 ClusterRole
+const (
+	KubeRoleView  = "view"
+	KubeRoleEdit  = "edit"
+	KubeRoleAdmin = "admin"
+)
+
+// 集群授权的对象类型
+const (
+	KubeSubjectUser  = "user"
+	KubeSubjectGroup = "group"
+)
+
+// 集群授权的方式
+// serviceaccount 为用户创建 ServiceAccount 并绑定角色, 用户可以下载短期有效的 kubeconfig;
+// rolebinding 直接将角色绑定到集群认证的 User/Group(例如 OIDC), 用户组只能使用这种方式
+const (
+	KubeAccessServiceAccount = "serviceaccount"
+	KubeAccessRoleBinding    = "rolebinding"
+)
+
+// 集群授权的同步状态
+const (
+	KubeAccessPending = "pending"
+	KubeAccessSynced  = "synced"
+	KubeAccessError   = "error"
+)
+
+var (
+	// ErrInvalidKubeCredential 集群的认证信息不完整或者无法解析
+	ErrInvalidKubeCredential = errors.New("invalid kubernetes credential")
+	// ErrInvalidKubeNamespace namespace 名称或者标签不合法
+	ErrInvalidKubeNamespace = errors.New("invalid kubernetes namespace")
+	// ErrInvalidQuotaTemplate 配额模板中的资源数量无法解析
+	ErrInvalidQuotaTemplate = errors.New("invalid quota template")
+	// ErrUnsupportedWorkload 不支持的工作负载类型, 或者该类型不支持这个操作
+	ErrUnsupportedWorkload = errors.New("unsupported workload kind or action")
+	// ErrInvalidManifest YAML 清单无法解析, 或者包含集群不支持的资源类型
+	ErrInvalidManifest = errors.New("invalid manifest")
+	// ErrInvalidKubeAccess 集群授权的对象、角色或者方式不合法
+	ErrInvalidKubeAccess = errors.New("invalid kubernetes access grant")
+)
+
+type (
+	// KubeCluster 纳管的 kubernetes 集群
+	// Credential 是加密后的 KubeCredential, 不会返回给前端
+	KubeCluster struct {
+		ID            int64      `db:"id" json:"id"`
+		Name          string     `db:"name" json:"name"`
+		Description   string     `db:"description" json:"description"`
+		AuthType      string     `db:"auth_type" json:"auth_type"`
+		Server        string     `db:"server" json:"server"`
+		Credential    []byte     `db:"credential" json:"-"`
+		Status        string     `db:"status" json:"status"`
+		Version       string     `db:"version" json:"version"`
+		NodeCount     int        `db:"node_count" json:"node_count"`
+		LastError     string     `db:"last_error" json:"last_error"`
+		LastProbeTime *time.Time `db:"last_probe_time" json:"last_probe_time"`
+		Creator       string     `db:"creator" json:"creator"`
+		CreateTime    time.Time  `db:"create_time" json:"create_time"`
+		UpdateTime    time.Time  `db:"update_time" json:"update_time"`
+	}
+
+	// KubeCredential 集群的认证信息
+	// AuthType 为 kubeconfig 时使用 Kubeconfig 的当前 context; 为 token 时使用 ServiceAccount 令牌和集群 CA 证书
+	KubeCredential struct {
+		Kubeconfig string `json:"kubeconfig,omitempty"`
+		Token      string `json:"token,omitempty"`
+		CAData     string `json:"ca_data,omitempty"`
+		Insecure   bool   `json:"insecure,omitempty"`
+	}
+
+	// StringMap 以 JSON 格式保存在数据库中的字符串字典, 用于标签和资源数量
+	StringMap map[string]string
+
+	// KubeNamespace easynetes 创建并纳管的 namespace, 绑定到服务树节点或者团队
+	// Labels 是用户指定的标签, 和 easynetes 自动生成的标签一起同步到集群中
+	KubeNamespace struct {
+		ID              int64      `db:"id" json:"id"`
+		ClusterID       int64      `db:"cluster_id" json:"cluster_id"`
+		Name            string     `db:"name" json:"name"`
+		ServiceNodeID   int64      `db:"service_node_id" json:"service_node_id"`
+		Team            string     `db:"team" json:"team"`
+		QuotaTemplateID int64      `db:"quota_template_id" json:"quota_template_id"`
+		Labels          StringMap  `db:"labels" json:"labels"`
+		Status          string     `db:"status" json:"status"`
+		SyncTime        *time.Time `db:"sync_time" json:"sync_time"`
+		Creator         string     `db:"creator" json:"creator"`
+		CreateTime      time.Time  `db:"create_time" json:"create_time"`
+		UpdateTime      time.Time  `db:"update_time" json:"update_time"`
+	}
+
+	// KubeQuotaTemplate 创建 namespace 时应用的 ResourceQuota 和 LimitRange 模板
+	// Hard 对应 ResourceQuota 的 spec.hard; 其余字段对应 LimitRange 中 Container 类型的限制
+	KubeQuotaTemplate struct {
+		ID             int64     `db:"id" json:"id"`
+		Name           string    `db:"name" json:"name"`
+		Description    string    `db:"description" json:"description"`
+		Hard           StringMap `db:"hard" json:"hard"`
+		DefaultLimit   StringMap `db:"default_limit" json:"default_limit"`
+		DefaultRequest StringMap `db:"default_request" json:"default_request"`
+		Max            StringMap `db:"max" json:"max"`
+		Min            StringMap `db:"min" json:"min"`
+		Creator        string    `db:"creator" json:"creator"`
+		CreateTime     time.Time `db:"create_time" json:"create_time"`
+		UpdateTime     time.Time `db:"update_time" json:"update_time"`
+	}
+
+	// KubeWorkloadAction 对工作负载执行操作的记录, Error 为空表示执行成功
+	KubeWorkloadAction struct {
+		ID         int64     `db:"id" json:"id"`
+		ClusterID  int64     `db:"cluster_id" json:"cluster_id"`
+		Namespace  string    `db:"namespace" json:"namespace"`
+		Kind       string    `db:"kind" json:"kind"`
+		Name       string    `db:"name" json:"name"`
+		Action     string    `db:"action" json:"action"`
+		Detail     string    `db:"detail" json:"detail"`
+		Error      string    `db:"error" json:"error"`
+		Operator   string    `db:"operator" json:"operator"`
+		CreateTime time.Time `db:"create_time" json:"create_time"`
+	}
+
+	// KubeApplyPlan 通过 server-side dry-run 生成的 YAML 变更计划, 确认后才会真正执行
+	// Changes 记录了每个对象与集群中现有状态的差异, 执行前会检查集群中的对象是否在这之后被修改过
+	// Manifest 可能包含 Secret 的明文, 不返回给客户端
+	KubeApplyPlan struct {
+		ID         string           `db:"id" json:"id"`
+		ClusterID  int64            `db:"cluster_id" json:"cluster_id"`
+		Manifest   string           `db:"manifest" json:"-"`
+		Changes    KubeApplyChanges `db:"changes" json:"changes"`
+		Status     string           `db:"status" json:"status"`
+		Result     string           `db:"result" json:"result"`
+		UserID     int64            `db:"user_id" json:"user_id"`
+		Creator    string           `db:"creator" json:"creator"`
+		CreateTime time.Time        `db:"create_time" json:"create_time"`
+		ExpireTime time.Time        `db:"expire_time" json:"expire_time"`
+		ApplyTime  *time.Time       `db:"apply_time" json:"apply_time"`
+	}
+
+	// KubeApplyChange 变更计划中的单个对象
+	// LiveDigest 是生成计划时集群中对象(不包括 status 等由服务端维护的字段)的摘要, 为空表示对象不存在
+	KubeApplyChange struct {
+		APIVersion string `json:"api_version"`
+		Kind       string `json:"kind"`
+		Namespace  string `json:"namespace"`
+		Name       string `json:"name"`
+		Action     string `json:"action"`
+		LiveDigest string `json:"live_digest"`
+		Diff       string `json:"diff"`
+	}
+
+	// KubeApplyChanges 以 JSON 格式保存在数据库中的变更列表
+	KubeApplyChanges []*KubeApplyChange
+
+	// KubeEvent 从集群中收集的 Warning 事件, 同一个事件(UID 相同)重复发生时更新 Count 和 LastSeen
+	// Kind/Name 是事件关联的对象
+	KubeEvent struct {
+		ID        int64     `db:"id" json:"id"`
+		ClusterID int64     `db:"cluster_id" json:"cluster_id"`
+		UID       string    `db:"uid" json:"uid"`
+		Namespace string    `db:"namespace" json:"namespace"`
+		Kind      string    `db:"kind" json:"kind"`
+		Name      string    `db:"name" json:"name"`
+		Reason    string    `db:"reason" json:"reason"`
+		Message   string    `db:"message" json:"message"`
+		Source    string    `db:"source" json:"source"`
+		Count     int32     `db:"count" json:"count"`
+		FirstSeen time.Time `db:"first_seen" json:"first_seen"`
+		LastSeen  time.Time `db:"last_seen" json:"last_seen"`
+	}
+
+	// KubeClusterDao 定义了一组从数据库操作 kubernetes 集群的一系列操作
+	KubeClusterDao interface {
+		// Get 根据ID从数据库中获取集群
+		Get(context.Context, int64) (*KubeCluster, error)
+		// List 从数据库中获取一组集群, 支持按 status 过滤
+		List(context.Context, map[string]interface{}) ([]*KubeCluster, error)
+		// Count 统计符合条件的集群数量
+		Count(context.Context, map[string]interface{}) (int64, error)
+		// Create 在数据库中创建一个集群
+		Create(context.Context, *KubeCluster) (int64, error)
+		// Update 更新集群的基本信息和认证信息
+		Update(context.Context, *KubeCluster) error
+		// UpdateStatus 更新集群的健康检查结果
+		UpdateStatus(context.Context, *KubeCluster) error
+		// Delete 从数据库中删除一个集群
+		Delete(context.Context, int64) error
+	}
+
+	// KubeNamespaceDao 定义了一组从数据库操作纳管 namespace 的一系列操作
+	KubeNamespaceDao interface {
+		// Get 根据集群和名称获取 namespace
+		Get(ctx context.Context, clusterID int64, name string) (*KubeNamespace, error)
+		// List 从数据库中获取一组 namespace, 支持按 cluster_id/service_node_id/team 过滤
+		List(context.Context, map[string]interface{}) ([]*KubeNamespace, error)
+		// Create 在数据库中创建一个 namespace
+		Create(context.Context, *KubeNamespace) (int64, error)
+		// Update 更新 namespace 的绑定关系和标签
+		Update(context.Context, *KubeNamespace) error
+		// UpdateStatus 更新 namespace 的同步状态
+		UpdateStatus(context.Context, *KubeNamespace) error
+		// Delete 从数据库中删除一个 namespace
+		Delete(context.Context, int64) error
+	}
+
+	// KubeQuotaTemplateDao 定义了一组从数据库操作配额模板的一系列操作
+	KubeQuotaTemplateDao interface {
+		// Get 根据ID从数据库中获取配额模板
+		Get(context.Context, int64) (*KubeQuotaTemplate, error)
+		// List 从数据库中获取所有配额模板
+		List(context.Context) ([]*KubeQuotaTemplate, error)
+		// Create 在数据库中创建一个配额模板
+		Create(context.Context, *KubeQuotaTemplate) (int64, error)
+		// Update 更新配额模板, 只影响之后创建的 namespace
+		Update(context.Context, *KubeQuotaTemplate) error
+		// Delete 从数据库中删除一个配额模板
+		Delete(context.Context, int64) error
+	}
+
+	// KubeWorkloadActionDao 定义了一组从数据库操作工作负载操作记录的一系列操作
+	KubeWorkloadActionDao interface {
+		// List 从数据库中获取一组操作记录, 支持按 cluster_id/namespace/kind/name/operator 过滤, 按时间倒序
+		List(context.Context, map[string]interface{}) ([]*KubeWorkloadAction, error)
+		// Count 统计符合条件的操作记录数量
+		Count(context.Context, map[string]interface{}) (int64, error)
+		// Create 在数据库中创建一个操作记录
+		Create(context.Context, *KubeWorkloadAction) (int64, error)
+	}
+
+	// KubeEventDao 定义了一组从数据库操作 Warning 事件的一系列操作
+	KubeEventDao interface {
+		// Upsert 保存事件, 已经存在的事件更新次数、消息和最后发生时间
+		Upsert(context.Context, *KubeEvent) error
+		// List 从数据库中获取一组事件, 支持按 cluster_id/namespace/kind/name/reason 和 since/until 过滤, 按最后发生时间倒序
+		List(context.Context, map[string]interface{}) ([]*KubeEvent, error)
+		// Count 统计符合条件的事件数量
+		Count(context.Context, map[string]interface{}) (int64, error)
+		// Prune 删除最后发生时间早于 before 的事件
+		Prune(ctx context.Context, before time.Time) (int64, error)
+	}
+
+	// KubeApplyPlanDao 定义了一组从数据库操作 YAML 变更计划的一系列操作
+	KubeApplyPlanDao interface {
+		// Get 根据ID从数据库中获取变更计划
+		Get(context.Context, string) (*KubeApplyPlan, error)
+		// Create 在数据库中创建一个变更计划
+		Create(context.Context, *KubeApplyPlan) error
+		// Claim 将未过期的 pending 计划标记为 applying, 返回 false 表示计划已经被执行或者已经过期
+		Claim(context.Context, string) (bool, error)
+		// Finish 记录变更计划的执行结果
+		Finish(context.Context, *KubeApplyPlan) error
+	}
+
+	// KubeAccessGrant 将集群 namespace 的角色授予 easynetes 用户或者用户组
+	// 用户使用 serviceaccount 方式时 UserID 为用户ID, SubjectName 为展示用的用户名;
+	// 使用 rolebinding 方式时 SubjectName 为集群认证的用户名或者用户组名
+	KubeAccessGrant struct {
+		ID          int64      `db:"id" json:"id"`
+		ClusterID   int64      `db:"cluster_id" json:"cluster_id"`
+		Namespace   string     `db:"namespace" json:"namespace"`
+		SubjectKind string     `db:"subject_kind" json:"subject_kind"`
+		SubjectName string     `db:"subject_name" json:"subject_name"`
+		UserID      int64      `db:"user_id" json:"user_id"`
+		Role        string     `db:"role" json:"role"`
+		Mode        string     `db:"mode" json:"mode"`
+		Status      string     `db:"status" json:"status"`
+		LastError   string     `db:"last_error" json:"last_error"`
+		SyncTime    *time.Time `db:"sync_time" json:"sync_time"`
+		Creator     string     `db:"creator" json:"creator"`
+		CreateTime  time.Time  `db:"create_time" json:"create_time"`
+	}
+
+	// KubeAccessGrantDao 定义了一组从数据库操作集群授权的一系列操作
+	KubeAccessGrantDao interface {
+		// Get 根据ID从数据库中获取集群授权
+		Get(context.Context, int64) (*KubeAccessGrant, error)
+		// List 从数据库中获取一组集群授权, 支持按 cluster_id/namespace/subject_kind/user_id/mode 过滤
+		List(context.Context, map[string]interface{}) ([]*KubeAccessGrant, error)
+		// Count 统计符合条件的集群授权数量
+		Count(context.Context, map[string]interface{}) (int64, error)
+		// Create 在数据库中创建一个集群授权
+		Create(context.Context, *KubeAccessGrant) (int64, error)
+		// UpdateStatus 更新集群授权的同步状态
+		UpdateStatus(context.Context, *KubeAccessGrant) error
+		// Delete 从数据库中删除一个集群授权
+		Delete(context.Context, int64) error
+	}
+)
+
+// Value 实现 driver.Valuer 接口
+func (c KubeApplyChanges) Value() (driver.Value, error) {
+	if c == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal(c)
+	return string(data), err
+}
+
+// Scan 实现 sql.Scanner 接口
+func (c *KubeApplyChanges) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	case nil:
+		*c = nil
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into KubeApplyChanges", src)
+	}
+	out := KubeApplyChanges{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &out); err != nil {
+			return err
+		}
+	}
+	*c = out
+	return nil
+}
+
+// KubeNamespaceResource 返回 namespace 在授权记录中的资源ID
+func KubeNamespaceResource(clusterID int64, namespace string) string {
+	return fmt.Sprintf("%d/%s", clusterID, namespace)
+}
+
+// Value 实现 driver.Valuer 接口
+func (m StringMap) Value() (driver.Value, error) {
+	if m == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(m)
+	return string(data), err
+}
+
+// Scan 实现 sql.Scanner 接口
+func (m *StringMap) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	case nil:
+		*m = nil
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into StringMap", src)
+	}
+	out := StringMap{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &out); err != nil {
+			return err
+		}
+	}
+	*m = out
+	return nil
+}
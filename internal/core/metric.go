@@ -0,0 +1,79 @@
+package core
+
+import (
+	"context"
+	"time"
+)
+
+// 主机监控指标名称
+const (
+	MetricCPU       = "cpu"
+	MetricMemory    = "memory"
+	MetricLoad1     = "load1"
+	MetricLoad5     = "load5"
+	MetricLoad15    = "load15"
+	MetricDiskUsed  = "disk_used"
+	MetricDiskRead  = "disk_read"
+	MetricDiskWrite = "disk_write"
+	MetricNetRx     = "net_rx"
+	MetricNetTx     = "net_tx"
+)
+
+// 指标数据的存储精度, 原始数据定期降采样为分钟和小时精度
+const (
+	ResolutionRaw    = "raw"
+	ResolutionMinute = "1m"
+	ResolutionHour   = "1h"
+)
+
+type (
+	// MetricPoint 指标数据点, 降采样后 Value 为平均值, Count 为参与聚合的原始数据点数量
+	MetricPoint struct {
+		AgentID int64     `db:"agent_id" json:"-"`
+		Metric  string    `db:"metric" json:"-"`
+		Time    time.Time `db:"ts" json:"time"`
+		Value   float64   `db:"value" json:"value"`
+		Min     float64   `db:"min" json:"min"`
+		Max     float64   `db:"max" json:"max"`
+		Count   int64     `db:"count" json:"-"`
+	}
+
+	// MetricSeries 单个主机单个指标的时间序列
+	MetricSeries struct {
+		HostID     int64          `json:"host_id"`
+		Metric     string         `json:"metric"`
+		Resolution string         `json:"resolution"`
+		Step       int64          `json:"step"`
+		From       time.Time      `json:"from"`
+		To         time.Time      `json:"to"`
+		Points     []*MetricPoint `json:"points"`
+	}
+
+	// MetricDao 定义了一组从数据库操作主机监控指标的一系列操作
+	MetricDao interface {
+		// Insert 写入一组原始数据点
+		Insert(context.Context, []*MetricPoint) error
+		// Query 按照 step 秒对齐聚合 [from, to) 范围内的数据点
+		Query(ctx context.Context, resolution string, agentID int64, metric string, from, to time.Time, step int64) ([]*MetricPoint, error)
+		// Rollup 将 [from, to) 范围内的数据从 src 精度降采样到 dst 精度, 重复执行结果不变
+		Rollup(ctx context.Context, src, dst string, from, to time.Time) error
+		// Prune 删除某个精度中早于给定时间的数据
+		Prune(ctx context.Context, resolution string, before time.Time) (int64, error)
+	}
+)
+
+// Metrics 所有支持的指标名称
+var Metrics = []string{
+	MetricCPU, MetricMemory, MetricLoad1, MetricLoad5, MetricLoad15,
+	MetricDiskUsed, MetricDiskRead, MetricDiskWrite, MetricNetRx, MetricNetTx,
+}
+
+// IsMetric 判断是否为支持的指标名称
+func IsMetric(name string) bool {
+	for _, m := range Metrics {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// 通知渠道的类型
+const (
+	// NotifyEmail 通过 SMTP 发送邮件到订阅用户的邮箱
+	NotifyEmail = "email"
+	// NotifyWebhook 将事件以 JSON 格式 POST 到 URL, 使用 HMAC-SHA256 签名
+	NotifyWebhook = "webhook"
+	// NotifyDingtalk 钉钉群机器人, Secret 为加签密钥
+	NotifyDingtalk = "dingtalk"
+	// NotifyFeishu 飞书群机器人, Secret 为签名校验密钥
+	NotifyFeishu = "feishu"
+	// NotifyWecom 企业微信群机器人
+	NotifyWecom = "wecom"
+)
+
+// 通知发送记录的状态, failed 表示重试次数用完后仍然失败
+const (
+	NotifyPending = "pending"
+	NotifySent    = "sent"
+	NotifyFailed  = "failed"
+)
+
+var (
+	// ErrInvalidNotifyChannel 通知渠道的类型或者地址不合法
+	ErrInvalidNotifyChannel = errors.New("invalid notification channel")
+	// ErrInvalidNotifyTemplate 通知模板无法解析
+	ErrInvalidNotifyTemplate = errors.New("invalid notification template")
+)
+
+type (
+	// NotifyChannel 通知渠道, 邮件渠道使用配置文件中的 SMTP 服务器, 其他渠道发送到 URL
+	// Secret 是加密后的签名密钥, 不会返回给前端
+	NotifyChannel struct {
+		ID         int64     `db:"id" json:"id"`
+		Name       string    `db:"name" json:"name"`
+		Type       string    `db:"type" json:"type"`
+		URL        string    `db:"url" json:"url"`
+		Secret     []byte    `db:"secret" json:"-"`
+		Enabled    bool      `db:"enabled" json:"enabled"`
+		Creator    string    `db:"creator" json:"creator"`
+		CreateTime time.Time `db:"create_time" json:"create_time"`
+		UpdateTime time.Time `db:"update_time" json:"update_time"`
+	}
+
+	// NotifySubscription 用户订阅的事件, Event 是事件总线的主题, 支持 hook.gitlab.* 这样的前缀和 *
+	NotifySubscription struct {
+		ID         int64     `db:"id" json:"id"`
+		UserID     int64     `db:"user_id" json:"user_id"`
+		ChannelID  int64     `db:"channel_id" json:"channel_id"`
+		Event      string    `db:"event" json:"event"`
+		CreateTime time.Time `db:"create_time" json:"create_time"`
+	}
+
+	// NotifyTemplate 事件类型的通知模板, 使用 text/template 语法, 可以引用 .Event .Time .Data
+	// Event 同样支持前缀和 *, 多个模板匹配时使用 Event 最长的模板
+	NotifyTemplate struct {
+		ID         int64     `db:"id" json:"id"`
+		Event      string    `db:"event" json:"event"`
+		Subject    string    `db:"subject" json:"subject"`
+		Body       string    `db:"body" json:"body"`
+		UpdateTime time.Time `db:"update_time" json:"update_time"`
+	}
+
+	// NotifyDelivery 一条通知的发送记录, Payload 是 webhook 渠道发送的 JSON
+	// 发送失败时按指数退避在 NextTime 重试, Attempts 是已经尝试的次数
+	// 发送前由 Worker 领取, 领取期间 NextTime 是租约的到期时间, 其他实例不会重复发送
+	NotifyDelivery struct {
+		ID          int64      `db:"id" json:"id"`
+		Event       string     `db:"event" json:"event"`
+		ChannelID   int64      `db:"channel_id" json:"channel_id"`
+		ChannelType string     `db:"channel_type" json:"channel_type"`
+		UserID      int64      `db:"user_id" json:"user_id"`
+		Recipient   string     `db:"recipient" json:"recipient"`
+		Subject     string     `db:"subject" json:"subject"`
+		Body        string     `db:"body" json:"body"`
+		Payload     string     `db:"payload" json:"payload"`
+		Status      string     `db:"status" json:"status"`
+		Attempts    int        `db:"attempts" json:"attempts"`
+		LastError   string     `db:"last_error" json:"last_error"`
+		NextTime    time.Time  `db:"next_time" json:"next_time"`
+		Worker      string     `db:"worker" json:"worker"`
+		CreateTime  time.Time  `db:"create_time" json:"create_time"`
+		UpdateTime  time.Time  `db:"update_time" json:"update_time"`
+		SentTime    *time.Time `db:"sent_time" json:"sent_time"`
+	}
+
+	// NotifyChannelDao 定义了一组从数据库操作通知渠道的一系列操作
+	NotifyChannelDao interface {
+		// Get 根据ID从数据库中获取通知渠道
+		Get(context.Context, int64) (*NotifyChannel, error)
+		// List 获取所有通知渠道
+		List(context.Context) ([]*NotifyChannel, error)
+		// Create 在数据库中创建一个通知渠道
+		Create(context.Context, *NotifyChannel) (int64, error)
+		// Update 更新通知渠道
+		Update(context.Context, *NotifyChannel) error
+		// Delete 删除通知渠道以及对它的订阅
+		Delete(context.Context, int64) error
+	}
+
+	// NotifySubscriptionDao 定义了一组从数据库操作事件订阅的一系列操作
+	NotifySubscriptionDao interface {
+		// Get 根据ID从数据库中获取订阅
+		Get(context.Context, int64) (*NotifySubscription, error)
+		// List 获取一组订阅, 支持按 user_id/channel_id 过滤
+		List(context.Context, map[string]interface{}) ([]*NotifySubscription, error)
+		// Create 创建订阅, 已经存在相同的订阅时不重复创建
+		Create(context.Context, *NotifySubscription) (int64, error)
+		// Delete 删除订阅
+		Delete(context.Context, int64) error
+	}
+
+	// NotifyTemplateDao 定义了一组从数据库操作通知模板的一系列操作
+	NotifyTemplateDao interface {
+		// List 获取所有通知模板
+		List(context.Context) ([]*NotifyTemplate, error)
+		// Save 创建或者更新事件类型的模板
+		Save(context.Context, *NotifyTemplate) error
+		// Delete 删除事件类型的模板
+		Delete(ctx context.Context, event string) error
+	}
+
+	// NotifyDeliveryDao 定义了一组从数据库操作通知发送记录的一系列操作
+	NotifyDeliveryDao interface {
+		// Get 根据ID从数据库中获取发送记录
+		Get(context.Context, int64) (*NotifyDelivery, error)
+		// List 获取一组发送记录, 支持按 event/status/channel_id/user_id 过滤, 按ID倒序
+		List(context.Context, map[string]interface{}) ([]*NotifyDelivery, error)
+		// Count 统计符合条件的发送记录数量
+		Count(context.Context, map[string]interface{}) (int64, error)
+		// ListDue 获取 NextTime 已到的待发送记录, 最多 limit 条
+		ListDue(ctx context.Context, now time.Time, limit int) ([]*NotifyDelivery, error)
+		// Create 创建发送记录
+		Create(context.Context, *NotifyDelivery) (int64, error)
+		// Claim 领取到期的待发送记录, 将 NextTime 设置为 until 作为租约, 已经被其他实例领取时返回 false
+		Claim(ctx context.Context, id int64, worker string, now, until time.Time) (bool, error)
+		// Update 更新发送状态、次数、错误和下次发送时间; 只有记录仍然由 Worker 领取时才会成功
+		Update(context.Context, *NotifyDelivery) (bool, error)
+		// Prune 删除创建时间早于给定时间并且已经发送或者失败的记录
+		Prune(context.Context, time.Time) (int64, error)
+	}
+)
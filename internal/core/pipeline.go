@@ -0,0 +1,217 @@
+package core
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 流水线阶段的类型
+const (
+	// PipelineStageBuild 触发关联的 Jenkins 任务并等待构建结束
+	PipelineStageBuild = "build"
+	// PipelineStageApproval 等待审批人人工确认
+	PipelineStageApproval = "approval"
+	// PipelineStageDeploy 发布应用到环境所在的集群, 或者在一组主机上执行部署脚本
+	PipelineStageDeploy = "deploy"
+	// PipelineStageVerify 通过 HTTP 探测或者在主机上执行检查脚本验证部署结果
+	PipelineStageVerify = "verify"
+)
+
+// 流水线运行和阶段的状态, waiting 表示等待审批
+const (
+	PipelinePending   = "pending"
+	PipelineRunning   = "running"
+	PipelineWaiting   = "waiting"
+	PipelineSucceeded = "succeeded"
+	PipelineFailed    = "failed"
+	PipelineCanceled  = "canceled"
+)
+
+// ErrInvalidPipeline 流水线的阶段配置不合法
+var ErrInvalidPipeline = errors.New("invalid pipeline")
+
+type (
+	// PipelineStage 流水线中一个阶段的配置, 不同类型的阶段使用不同的字段
+	// Parameters/Image/URL 中的 ${KEY} 在运行时替换为运行参数中的同名参数, 脚本通过环境变量读取运行参数
+	// build: JobID, Parameters; approval: Approvers, 为空时只有管理员可以审批
+	// deploy: EnvID, Image 发布应用到环境; 或者 HostIDs, Script 在主机上依次执行脚本
+	// verify: URL, ExpectStatus 探测直到返回期望的状态码; 或者 HostIDs, Script 在主机上执行检查脚本
+	// Timeout 单位为秒, 为0时使用默认值
+	PipelineStage struct {
+		Name         string    `json:"name"`
+		Type         string    `json:"type"`
+		JobID        int64     `json:"job_id,omitempty"`
+		Parameters   StringMap `json:"parameters,omitempty"`
+		Approvers    []string  `json:"approvers,omitempty"`
+		EnvID        int64     `json:"env_id,omitempty"`
+		Image        string    `json:"image,omitempty"`
+		HostIDs      []int64   `json:"host_ids,omitempty"`
+		Script       string    `json:"script,omitempty"`
+		URL          string    `json:"url,omitempty"`
+		ExpectStatus int       `json:"expect_status,omitempty"`
+		Timeout      int64     `json:"timeout,omitempty"`
+	}
+
+	// PipelineStages 以 JSON 格式保存在数据库中的阶段配置
+	PipelineStages []PipelineStage
+
+	// Pipeline 绑定到应用的流水线, 阶段按顺序执行
+	Pipeline struct {
+		ID            int64          `db:"id" json:"id"`
+		Name          string         `db:"name" json:"name"`
+		AppID         int64          `db:"app_id" json:"app_id"`
+		ServiceNodeID int64          `db:"service_node_id" json:"service_node_id"`
+		Stages        PipelineStages `db:"stages" json:"stages"`
+		Creator       string         `db:"creator" json:"creator"`
+		CreateTime    time.Time      `db:"create_time" json:"create_time"`
+		UpdateTime    time.Time      `db:"update_time" json:"update_time"`
+	}
+
+	// PipelineRun 流水线的一次运行, Stages 是启动时的阶段配置快照, 修改流水线不影响进行中的运行
+	// Current 是当前阶段的下标, 部署和验证阶段以 CreatorID 对应用户的权限执行
+	PipelineRun struct {
+		ID         int64          `db:"id" json:"id"`
+		PipelineID int64          `db:"pipeline_id" json:"pipeline_id"`
+		AppID      int64          `db:"app_id" json:"app_id"`
+		Stages     PipelineStages `db:"stages" json:"stages"`
+		Parameters StringMap      `db:"parameters" json:"parameters"`
+		Status     string         `db:"status" json:"status"`
+		Current    int            `db:"current" json:"current"`
+		Message    string         `db:"message" json:"message"`
+		Creator    string         `db:"creator" json:"creator"`
+		CreatorID  int64          `db:"creator_id" json:"creator_id"`
+		CreateTime time.Time      `db:"create_time" json:"create_time"`
+		UpdateTime time.Time      `db:"update_time" json:"update_time"`
+		FinishTime *time.Time     `db:"finish_time" json:"finish_time"`
+	}
+
+	// PipelineStageRun 运行中单个阶段的状态, 每次重试 Attempt 加一, 日志保留之前每一次的输出
+	// Ref 是阶段产生的外部记录: 构建阶段为 Jenkins 构建记录ID, 发布到环境时为发布记录ID, 发布等待审批时为审批请求ID
+	// Owner 是推进阶段的 apiserver 副本, LeaseTime 之前其他副本不能推进该阶段, 副本执行阶段期间定期续期
+	PipelineStageRun struct {
+		ID         int64      `db:"id" json:"id"`
+		RunID      int64      `db:"run_id" json:"run_id"`
+		Stage      int        `db:"stage" json:"stage"`
+		Name       string     `db:"name" json:"name"`
+		Type       string     `db:"type" json:"type"`
+		Status     string     `db:"status" json:"status"`
+		Attempt    int        `db:"attempt" json:"attempt"`
+		Ref        int64      `db:"ref" json:"ref"`
+		Operator   string     `db:"operator" json:"operator"`
+		Message    string     `db:"message" json:"message"`
+		Log        string     `db:"log" json:"log,omitempty"`
+		Owner      string     `db:"owner" json:"owner"`
+		LeaseTime  *time.Time `db:"lease_time" json:"lease_time"`
+		StartTime  *time.Time `db:"start_time" json:"start_time"`
+		FinishTime *time.Time `db:"finish_time" json:"finish_time"`
+		UpdateTime time.Time  `db:"update_time" json:"update_time"`
+	}
+
+	// PipelineDao 定义了一组从数据库操作流水线的一系列操作
+	PipelineDao interface {
+		// Get 根据ID从数据库中获取流水线
+		Get(context.Context, int64) (*Pipeline, error)
+		// List 从数据库中获取一组流水线, 支持按 app_id/service_node_id 过滤
+		List(context.Context, map[string]interface{}) ([]*Pipeline, error)
+		// Count 统计符合条件的流水线数量
+		Count(context.Context, map[string]interface{}) (int64, error)
+		// Create 在数据库中创建一个流水线
+		Create(context.Context, *Pipeline) (int64, error)
+		// Update 更新流水线的名称、关联关系和阶段配置
+		Update(context.Context, *Pipeline) error
+		// Delete 从数据库中删除一个流水线, 历史运行记录保留
+		Delete(context.Context, int64) error
+	}
+
+	// PipelineRunDao 定义了一组从数据库操作流水线运行记录的一系列操作
+	PipelineRunDao interface {
+		// Get 根据ID从数据库中获取运行记录
+		Get(context.Context, int64) (*PipelineRun, error)
+		// List 从数据库中获取一组运行记录, 支持按 pipeline_id/app_id/status/creator 过滤
+		List(context.Context, map[string]interface{}) ([]*PipelineRun, error)
+		// Count 统计符合条件的运行记录数量
+		Count(context.Context, map[string]interface{}) (int64, error)
+		// ListActive 获取所有进行中和等待审批的运行记录
+		ListActive(context.Context) ([]*PipelineRun, error)
+		// Create 创建运行记录以及每个阶段的状态
+		Create(context.Context, *PipelineRun, []*PipelineStageRun) (int64, error)
+		// Update 更新运行的状态、当前阶段和信息
+		Update(context.Context, *PipelineRun) error
+		// ListStages 获取运行中所有阶段的状态, 不包含日志
+		ListStages(context.Context, int64) ([]*PipelineStageRun, error)
+		// GetStage 获取运行中单个阶段的状态, 包含日志
+		GetStage(ctx context.Context, runID int64, stage int) (*PipelineStageRun, error)
+		// UpdateStage 更新阶段的状态、次数和信息, 日志只能通过 AppendLog 追加
+		UpdateStage(context.Context, *PipelineStageRun) error
+		// ClaimStage 只有阶段仍然为 status 并且由 owner 认领或者租约已经过期时才将其认领给 owner, 租约到 until 为止
+		// 返回是否认领成功, owner 续期时同样调用
+		ClaimStage(ctx context.Context, id int64, status, owner string, now, until time.Time) (bool, error)
+		// TransitionStage 与 UpdateStage 相同, 但只有阶段仍然为 from 并且由 Owner 认领时才会更新, 返回是否更新成功
+		TransitionStage(ctx context.Context, stage *PipelineStageRun, from string) (bool, error)
+		// AppendLog 在阶段日志的末尾追加内容
+		AppendLog(ctx context.Context, id int64, data string) error
+	}
+)
+
+// Validate 校验阶段配置, 返回的错误包含 ErrInvalidPipeline
+func (s PipelineStages) Validate() error {
+	if len(s) == 0 {
+		return fmt.Errorf("%w: no stages", ErrInvalidPipeline)
+	}
+	for i, stage := range s {
+		var ok bool
+		switch stage.Type {
+		case PipelineStageBuild:
+			ok = stage.JobID > 0
+		case PipelineStageApproval:
+			ok = true
+		case PipelineStageDeploy:
+			ok = stage.EnvID > 0 || (len(stage.HostIDs) > 0 && stage.Script != "")
+		case PipelineStageVerify:
+			ok = stage.URL != "" || (len(stage.HostIDs) > 0 && stage.Script != "")
+		default:
+			return fmt.Errorf("%w: stage %d has unknown type %q", ErrInvalidPipeline, i, stage.Type)
+		}
+		if !ok || stage.Name == "" || stage.Timeout < 0 {
+			return fmt.Errorf("%w: stage %d (%s) is incomplete", ErrInvalidPipeline, i, stage.Type)
+		}
+	}
+	return nil
+}
+
+// Value 实现 driver.Valuer 接口
+func (s PipelineStages) Value() (driver.Value, error) {
+	if s == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal(s)
+	return string(data), err
+}
+
+// Scan 实现 sql.Scanner 接口
+func (s *PipelineStages) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	case nil:
+		*s = nil
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into PipelineStages", src)
+	}
+	out := PipelineStages{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &out); err != nil {
+			return err
+		}
+	}
+	*s = out
+	return nil
+}
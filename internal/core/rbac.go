@@ -0,0 +1,72 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// 授权的资源类型
+const (
+	ResourceHost = "host"
+	// ResourceKubeNamespace 资源ID的格式为 <集群ID>/<namespace>, 参考 KubeNamespaceResource
+	ResourceKubeNamespace = "kube_namespace"
+	// ResourceApplication 资源ID为应用ID
+	ResourceApplication = "application"
+	// ResourceServiceNode 资源ID为服务树节点ID
+	ResourceServiceNode = "service_node"
+)
+
+// 授权的操作
+const (
+	ActionTerminal = "terminal"
+	// ActionCommand 通过 agent 在主机上执行脚本, 例如流水线的部署和验证阶段
+	ActionCommand = "command"
+	// ActionKubeOperate 对 namespace 中的工作负载执行扩缩容、重启和回滚
+	ActionKubeOperate = "operate"
+	// ActionKubeLogs 查看 namespace 中 Pod 的日志
+	ActionKubeLogs = "logs"
+	// ActionKubeExec 在 namespace 中 Pod 的容器里执行命令
+	ActionKubeExec = "exec"
+	// ActionKubeApply 通过 YAML 变更计划修改 namespace 中的对象
+	ActionKubeApply = "apply"
+	// ActionBuild 触发应用或者服务树节点关联的 Jenkins 任务的构建
+	ActionBuild = "build"
+)
+
+// GrantAll 表示授权该类型下的所有资源
+const GrantAll = "*"
+
+// ErrForbidden 用户没有对资源执行该操作的权限
+var ErrForbidden = errors.New("forbidden")
+
+type (
+	// Grant 授权记录, 表示允许用户对某个资源执行某个操作
+	// ResourceID 为 GrantAll 时表示该类型下的所有资源
+	Grant struct {
+		ID         int64     `db:"id" json:"id"`
+		UserID     int64     `db:"user_id" json:"user_id"`
+		Resource   string    `db:"resource" json:"resource"`
+		ResourceID string    `db:"resource_id" json:"resource_id"`
+		Action     string    `db:"action" json:"action"`
+		Creator    string    `db:"creator" json:"creator"`
+		CreateTime time.Time `db:"create_time" json:"create_time"`
+	}
+
+	// GrantDao 定义了一组从数据库操作授权记录的一系列操作
+	GrantDao interface {
+		// List 从数据库中获取一组授权记录, 支持按 user_id/resource/resource_id 过滤
+		List(context.Context, map[string]interface{}) ([]*Grant, error)
+		// Create 在数据库中创建一个授权记录
+		Create(context.Context, *Grant) (int64, error)
+		// Delete 从数据库中删除一个授权记录
+		Delete(context.Context, int64) error
+		// Exists 判断用户是否拥有对资源(或者该类型的所有资源)执行某个操作的授权
+		Exists(ctx context.Context, userID int64, resource, resourceID, action string) (bool, error)
+	}
+
+	// Authorizer 判断用户是否可以对资源执行某个操作, 没有权限时返回 ErrForbidden
+	Authorizer interface {
+		Authorize(ctx context.Context, user *User, resource, resourceID, action string) error
+	}
+)
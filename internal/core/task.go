@@ -0,0 +1,67 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// 后台任务的状态, 失败后等待重试的任务回到 pending, VisibleTime 是下一次可以被认领的时间
+const (
+	TaskPending   = "pending"
+	TaskRunning   = "running"
+	TaskSucceeded = "succeeded"
+	TaskFailed    = "failed"
+)
+
+// ErrInvalidTask 后台任务的类型或者参数不合法, 这类错误不会重试
+var ErrInvalidTask = errors.New("invalid task")
+
+type (
+	// Task 在后台执行的耗时操作, 例如集群同步、批量导入和文件分发, Priority 越大越先执行
+	// 认领任务时将 VisibleTime 设置为可见性超时之后的时间, 执行过程中定期续期; 副本退出后任务超时, 由其他副本重新认领
+	// Progress 是 0-100 的进度, Message 是当前的进展; Result 是执行结果, 失败时也可能包含部分结果
+	Task struct {
+		ID          int64           `db:"id" json:"id"`
+		Type        string          `db:"task_type" json:"type"`
+		Payload     json.RawMessage `db:"payload" json:"payload,omitempty"`
+		Priority    int             `db:"priority" json:"priority"`
+		Status      string          `db:"status" json:"status"`
+		Attempts    int             `db:"attempts" json:"attempts"`
+		MaxAttempts int             `db:"max_attempts" json:"max_attempts"`
+		Progress    int             `db:"progress" json:"progress"`
+		Message     string          `db:"message" json:"message"`
+		Result      json.RawMessage `db:"result" json:"result,omitempty"`
+		Error       string          `db:"error" json:"error"`
+		Worker      string          `db:"worker" json:"worker"`
+		Creator     string          `db:"creator" json:"creator"`
+		VisibleTime time.Time       `db:"visible_time" json:"visible_time"`
+		StartTime   *time.Time      `db:"start_time" json:"start_time"`
+		FinishTime  *time.Time      `db:"finish_time" json:"finish_time"`
+		CreateTime  time.Time       `db:"create_time" json:"create_time"`
+		UpdateTime  time.Time       `db:"update_time" json:"update_time"`
+	}
+
+	// TaskDao 定义了一组从数据库操作后台任务的一系列操作
+	TaskDao interface {
+		// Get 根据ID从数据库中获取后台任务
+		Get(context.Context, int64) (*Task, error)
+		// List 获取一组后台任务, 支持按 task_type/status/creator 过滤, 按ID倒序, 不包含参数和结果
+		List(context.Context, map[string]interface{}) ([]*Task, error)
+		// Count 统计符合条件的后台任务数量
+		Count(context.Context, map[string]interface{}) (int64, error)
+		// ListClaimable 按优先级获取最多 limit 个可以认领的任务: VisibleTime 已到的 pending 任务和可见性超时的 running 任务
+		ListClaimable(ctx context.Context, now time.Time, limit int) ([]*Task, error)
+		// Create 创建后台任务
+		Create(context.Context, *Task) (int64, error)
+		// Claim 只有任务仍然可以认领时才将其改为 running, 执行次数加一, 并将 VisibleTime 设置为 until, 返回是否认领成功
+		Claim(ctx context.Context, id int64, worker string, now, until time.Time) (bool, error)
+		// Touch 保存进度并续期 VisibleTime, 只有任务仍然由 Worker 执行时才会成功
+		Touch(context.Context, *Task) (bool, error)
+		// Finish 保存执行结果, 等待重试时状态为 pending; 只有任务仍然由 Worker 执行时才会成功
+		Finish(context.Context, *Task) (bool, error)
+		// Prune 删除结束时间早于给定时间的任务
+		Prune(context.Context, time.Time) (int64, error)
+	}
+)
@@ -0,0 +1,45 @@
+package core
+
+import (
+	"context"
+	"time"
+)
+
+// 终端会话状态
+const (
+	TerminalStatusActive = "active"
+	TerminalStatusClosed = "closed"
+)
+
+type (
+	// TerminalSession 通过 agent 建立的主机 web 终端会话, 会话内容以 asciicast v2 格式录制
+	TerminalSession struct {
+		ID          string     `db:"id" json:"id"`
+		HostID      int64      `db:"host_id" json:"host_id"`
+		AgentID     int64      `db:"agent_id" json:"agent_id"`
+		UserID      int64      `db:"user_id" json:"user_id"`
+		UserName    string     `db:"user_name" json:"user_name"`
+		Cols        uint16     `db:"cols" json:"cols"`
+		Rows        uint16     `db:"rows" json:"rows"`
+		Status      string     `db:"status" json:"status"`
+		CloseReason string     `db:"close_reason" json:"close_reason"`
+		ExitCode    int        `db:"exit_code" json:"exit_code"`
+		Recording   string     `db:"recording" json:"-"`
+		StartTime   time.Time  `db:"start_time" json:"start_time"`
+		EndTime     *time.Time `db:"end_time" json:"end_time"`
+	}
+
+	// TerminalSessionDao 定义了一组从数据库操作终端会话的一系列操作
+	TerminalSessionDao interface {
+		// Get 根据ID从数据库中获取终端会话
+		Get(context.Context, string) (*TerminalSession, error)
+		// List 从数据库中获取一组终端会话, 支持按 host_id/user_id 过滤
+		List(context.Context, map[string]interface{}) ([]*TerminalSession, error)
+		// Count 统计符合条件的终端会话数量
+		Count(context.Context, map[string]interface{}) (int64, error)
+		// Create 在数据库中创建一个终端会话
+		Create(context.Context, *TerminalSession) error
+		// Update 更新终端会话的状态和结束信息
+		Update(context.Context, *TerminalSession) error
+	}
+)
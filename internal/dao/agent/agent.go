@@ -0,0 +1,187 @@
+package agent
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/eventbus"
+	"github.com/jmoiron/sqlx"
+)
+
+// ProvideAgentDao 返回的 DAO 在 agent 注册、状态或者版本变化后将 agent.created/updated/status_changed 发布到事件总线
+// 只刷新心跳时间的心跳不发布事件
+func ProvideAgentDao(db *sqlx.DB, bus *eventbus.Bus) core.AgentDao {
+	return &agentDao{db: db, bus: bus}
+}
+
+type agentDao struct {
+	db  *sqlx.DB
+	bus *eventbus.Bus
+}
+
+var _ core.AgentDao = &agentDao{}
+
+const agentColumns = `id, instance_id, host_name, version, branch, commit, build_time, go_version,
+	os, arch, status, last_heartbeat, create_time, update_time`
+
+func (agent *agentDao) Get(ctx context.Context, in int64) (*core.Agent, error) {
+	out := new(core.Agent)
+	err := agent.db.GetContext(ctx, out, "SELECT "+agentColumns+" FROM agents WHERE id = ?", in)
+	return out, err
+}
+
+func (agent *agentDao) GetByInstance(ctx context.Context, in string) (*core.Agent, error) {
+	out := new(core.Agent)
+	err := agent.db.GetContext(ctx, out, "SELECT "+agentColumns+" FROM agents WHERE instance_id = ?", in)
+	return out, err
+}
+
+func (agent *agentDao) List(ctx context.Context, in map[string]interface{}) ([]*core.Agent, error) {
+	where, args := agentFilter(in)
+	query := "SELECT " + agentColumns + " FROM agents" + where + " ORDER BY id"
+	if limit, ok := in["limit"]; ok {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, in["offset"])
+	}
+	out := []*core.Agent{}
+	err := agent.db.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+func (agent *agentDao) Count(ctx context.Context, in map[string]interface{}) (int64, error) {
+	where, args := agentFilter(in)
+	var count int64
+	err := agent.db.GetContext(ctx, &count, "SELECT COUNT(*) FROM agents"+where, args...)
+	return count, err
+}
+
+func (agent *agentDao) Heartbeat(ctx context.Context, in *core.Agent) (int64, error) {
+	now := time.Now()
+	in.Status = core.AgentStatusOnline
+	in.LastHeartbeat = now
+	in.CreateTime = now
+	in.UpdateTime = now
+	tx, err := agent.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	previous := new(core.Agent)
+	err = tx.GetContext(ctx, previous, "SELECT "+agentColumns+" FROM agents WHERE instance_id = ? FOR UPDATE", in.InstanceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		previous, err = nil, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	// id = LAST_INSERT_ID(id) 使得更新已有记录时也能拿到该记录的ID
+	result, err := tx.NamedExecContext(ctx, `INSERT INTO agents
+	(instance_id, host_name, version, branch, commit, build_time, go_version, os, arch, status, last_heartbeat, create_time, update_time)
+	VALUES
+	(:instance_id, :host_name, :version, :branch, :commit, :build_time, :go_version, :os, :arch, :status, :last_heartbeat, :create_time, :update_time)
+	ON DUPLICATE KEY UPDATE
+	id = LAST_INSERT_ID(id), host_name = VALUES(host_name), version = VALUES(version), branch = VALUES(branch),
+	commit = VALUES(commit), build_time = VALUES(build_time), go_version = VALUES(go_version), os = VALUES(os),
+	arch = VALUES(arch), status = VALUES(status), last_heartbeat = VALUES(last_heartbeat), update_time = VALUES(update_time)`, in)
+	if err != nil {
+		return 0, err
+	}
+	in.ID, err = result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	switch {
+	case previous == nil:
+		agent.publish(&core.ResourceChange{Resource: core.ResourceAgent, ID: in.ID, Op: core.ChangeCreated, Data: in})
+	case previous.Status != in.Status || previous.Version != in.Version || previous.HostName != in.HostName:
+		in.CreateTime = previous.CreateTime
+		agent.changed(previous, in)
+	}
+	return in.ID, nil
+}
+
+func (agent *agentDao) MarkOffline(ctx context.Context, in time.Time) (int64, error) {
+	tx, err := agent.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	stale := []*core.Agent{}
+	err = tx.SelectContext(ctx, &stale, "SELECT "+agentColumns+
+		" FROM agents WHERE status = ? AND last_heartbeat < ? FOR UPDATE", core.AgentStatusOnline, in)
+	if err != nil || len(stale) == 0 {
+		return 0, err
+	}
+	ids := make([]int64, 0, len(stale))
+	for _, item := range stale {
+		ids = append(ids, item.ID)
+	}
+	now := time.Now()
+	query, args, err := sqlx.In("UPDATE agents SET status = ?, update_time = ? WHERE id IN (?)", core.AgentStatusOffline, now, ids)
+	if err != nil {
+		return 0, err
+	}
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	for _, previous := range stale {
+		current := *previous
+		current.Status = core.AgentStatusOffline
+		current.UpdateTime = now
+		agent.changed(previous, &current)
+	}
+	return n, nil
+}
+
+// changed 发布 agent.updated, 状态变化时额外发布 agent.status_changed
+func (agent *agentDao) changed(previous, current *core.Agent) {
+	change := &core.ResourceChange{Resource: core.ResourceAgent, ID: current.ID, Op: core.ChangeUpdated, Data: current, Previous: previous}
+	agent.publish(change)
+	if previous.Status != current.Status {
+		status := *change
+		status.Op = core.ChangeStatus
+		agent.publish(&status)
+	}
+}
+
+// publish 发布一份 agent 的副本, 订阅者异步处理事件, 避免与调用方后续的修改竞争
+func (agent *agentDao) publish(change *core.ResourceChange) {
+	if data, ok := change.Data.(*core.Agent); ok {
+		copied := *data
+		change.Data = &copied
+	}
+	agent.bus.Publish(change.Topic(), change)
+}
+
+// agentFilter 根据查询参数生成 WHERE 子句
+func agentFilter(in map[string]interface{}) (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	for _, key := range []string{"status", "version", "os", "arch"} {
+		if v, ok := in[key]; ok && v != "" {
+			conds = append(conds, key+" = ?")
+			args = append(args, v)
+		}
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
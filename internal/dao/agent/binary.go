@@ -0,0 +1,60 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideAgentBinaryDao(db *sqlx.DB) core.AgentBinaryDao {
+	return &binaryDao{db: db}
+}
+
+type binaryDao struct {
+	db *sqlx.DB
+}
+
+var _ core.AgentBinaryDao = &binaryDao{}
+
+const binaryColumns = "id, version, os, arch, checksum, size, path, uploader, create_time, remark"
+
+func (binary *binaryDao) Get(ctx context.Context, in int64) (*core.AgentBinary, error) {
+	out := new(core.AgentBinary)
+	err := binary.db.GetContext(ctx, out, "SELECT "+binaryColumns+" FROM agent_binaries WHERE id = ?", in)
+	return out, err
+}
+
+func (binary *binaryDao) Find(ctx context.Context, version, os, arch string) (*core.AgentBinary, error) {
+	out := new(core.AgentBinary)
+	err := binary.db.GetContext(ctx, out,
+		"SELECT "+binaryColumns+" FROM agent_binaries WHERE version = ? AND os = ? AND arch = ?",
+		version, os, arch,
+	)
+	return out, err
+}
+
+func (binary *binaryDao) List(ctx context.Context) ([]*core.AgentBinary, error) {
+	out := []*core.AgentBinary{}
+	err := binary.db.SelectContext(ctx, &out, "SELECT "+binaryColumns+" FROM agent_binaries ORDER BY id DESC")
+	return out, err
+}
+
+func (binary *binaryDao) Create(ctx context.Context, in *core.AgentBinary) (int64, error) {
+	in.CreateTime = time.Now()
+	result, err := binary.db.NamedExecContext(ctx, `INSERT INTO agent_binaries
+	(version, os, arch, checksum, size, path, uploader, create_time, remark)
+	VALUES
+	(:version, :os, :arch, :checksum, :size, :path, :uploader, :create_time, :remark)`, in)
+	if err != nil {
+		return 0, err
+	}
+	in.ID, err = result.LastInsertId()
+	return in.ID, err
+}
+
+func (binary *binaryDao) Delete(ctx context.Context, in int64) error {
+	_, err := binary.db.ExecContext(ctx, "DELETE FROM agent_binaries WHERE id = ?", in)
+	return err
+}
@@ -0,0 +1,47 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideAgentMessageDao(db *sqlx.DB) core.AgentMessageDao {
+	return &messageDao{db: db}
+}
+
+type messageDao struct {
+	db *sqlx.DB
+}
+
+var _ core.AgentMessageDao = &messageDao{}
+
+func (message *messageDao) Seen(ctx context.Context, agentID int64, messageID string) (bool, error) {
+	var count int64
+	err := message.db.GetContext(ctx, &count,
+		"SELECT COUNT(*) FROM agent_messages WHERE agent_id = ? AND message_id = ?", agentID, messageID)
+	return count > 0, err
+}
+
+func (message *messageDao) Record(ctx context.Context, agentID int64, messageID string) (bool, error) {
+	// (agent_id, message_id) 为唯一索引(见 doc/schema/agent_messages.sql), 重复的消息不会插入新记录
+	result, err := message.db.ExecContext(ctx,
+		"INSERT IGNORE INTO agent_messages (agent_id, message_id, create_time) VALUES (?, ?, ?)",
+		agentID, messageID, time.Now(),
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (message *messageDao) Prune(ctx context.Context, in time.Time) (int64, error) {
+	result, err := message.db.ExecContext(ctx, "DELETE FROM agent_messages WHERE create_time < ?", in)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideAgentRolloutDao(db *sqlx.DB) core.AgentRolloutDao {
+	return &rolloutDao{db: db}
+}
+
+type rolloutDao struct {
+	db *sqlx.DB
+}
+
+var _ core.AgentRolloutDao = &rolloutDao{}
+
+const (
+	rolloutColumns = `id, version, stages, current_stage, status, health_timeout, soak_time,
+	creator, message, owner, lease_time, create_time, update_time`
+	rolloutHostColumns = `id, rollout_id, agent_id, stage, from_version, from_binary_id, to_binary_id,
+	sent_binary_id, status, upgrade_time, update_time`
+)
+
+func (rollout *rolloutDao) Get(ctx context.Context, in int64) (*core.AgentRollout, error) {
+	out := new(core.AgentRollout)
+	err := rollout.db.GetContext(ctx, out, "SELECT "+rolloutColumns+" FROM agent_rollouts WHERE id = ?", in)
+	return out, err
+}
+
+func (rollout *rolloutDao) List(ctx context.Context, in map[string]interface{}) ([]*core.AgentRollout, error) {
+	query := "SELECT " + rolloutColumns + " FROM agent_rollouts"
+	var args []interface{}
+	if status, ok := in["status"]; ok && status != "" {
+		query += " WHERE status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY id DESC"
+	out := []*core.AgentRollout{}
+	err := rollout.db.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+func (rollout *rolloutDao) Create(ctx context.Context, in *core.AgentRollout, hosts []*core.AgentRolloutHost) (int64, error) {
+	now := time.Now()
+	in.CreateTime = now
+	in.UpdateTime = now
+	tx, err := rollout.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.NamedExecContext(ctx, `INSERT INTO agent_rollouts
+	(version, stages, current_stage, status, health_timeout, soak_time, creator, message, create_time, update_time)
+	VALUES
+	(:version, :stages, :current_stage, :status, :health_timeout, :soak_time, :creator, :message, :create_time, :update_time)`, in)
+	if err != nil {
+		return 0, err
+	}
+	if in.ID, err = result.LastInsertId(); err != nil {
+		return 0, err
+	}
+	for _, host := range hosts {
+		host.RolloutID = in.ID
+		host.UpdateTime = now
+		result, err := tx.NamedExecContext(ctx, `INSERT INTO agent_rollout_hosts
+		(rollout_id, agent_id, stage, from_version, from_binary_id, to_binary_id, status, upgrade_time, update_time)
+		VALUES
+		(:rollout_id, :agent_id, :stage, :from_version, :from_binary_id, :to_binary_id, :status, :upgrade_time, :update_time)`, host)
+		if err != nil {
+			return 0, err
+		}
+		if host.ID, err = result.LastInsertId(); err != nil {
+			return 0, err
+		}
+	}
+	return in.ID, tx.Commit()
+}
+
+func (rollout *rolloutDao) Update(ctx context.Context, in *core.AgentRollout) error {
+	in.UpdateTime = time.Now()
+	_, err := rollout.db.NamedExecContext(ctx, `UPDATE agent_rollouts SET
+	current_stage = :current_stage, status = :status, message = :message, update_time = :update_time
+	WHERE id = :id`, in)
+	return err
+}
+
+func (rollout *rolloutDao) Claim(ctx context.Context, id int64, owner string, now, until time.Time) (bool, error) {
+	result, err := rollout.db.ExecContext(ctx, `UPDATE agent_rollouts SET owner = ?, lease_time = ?
+	WHERE id = ? AND (owner = ? OR lease_time IS NULL OR lease_time < ?)`,
+		owner, until, id, owner, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (rollout *rolloutDao) ListHosts(ctx context.Context, in int64) ([]*core.AgentRolloutHost, error) {
+	out := []*core.AgentRolloutHost{}
+	err := rollout.db.SelectContext(ctx, &out,
+		"SELECT "+rolloutHostColumns+" FROM agent_rollout_hosts WHERE rollout_id = ? ORDER BY stage, id", in,
+	)
+	return out, err
+}
+
+func (rollout *rolloutDao) UpdateHost(ctx context.Context, in *core.AgentRolloutHost) error {
+	in.UpdateTime = time.Now()
+	_, err := rollout.db.NamedExecContext(ctx, `UPDATE agent_rollout_hosts SET
+	sent_binary_id = :sent_binary_id, status = :status, upgrade_time = :upgrade_time, update_time = :update_time
+	WHERE id = :id`, in)
+	return err
+}
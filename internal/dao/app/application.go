@@ -0,0 +1,92 @@
+package app
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideApplicationDao(db *sqlx.DB) core.ApplicationDao {
+	return &applicationDao{db: db}
+}
+
+type applicationDao struct {
+	db *sqlx.DB
+}
+
+var _ core.ApplicationDao = &applicationDao{}
+
+const applicationColumns = "id, name, description, service_node_id, spec, creator, create_time, update_time"
+
+func (app *applicationDao) Get(ctx context.Context, id int64) (*core.Application, error) {
+	out := new(core.Application)
+	err := app.db.GetContext(ctx, out, "SELECT "+applicationColumns+" FROM applications WHERE id = ?", id)
+	return out, err
+}
+
+func (app *applicationDao) List(ctx context.Context, in map[string]interface{}) ([]*core.Application, error) {
+	where, args := applicationFilter(in)
+	query := "SELECT " + applicationColumns + " FROM applications" + where + " ORDER BY id DESC"
+	if limit, ok := in["limit"]; ok {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, in["offset"])
+	}
+	out := []*core.Application{}
+	err := app.db.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+func (app *applicationDao) Count(ctx context.Context, in map[string]interface{}) (int64, error) {
+	where, args := applicationFilter(in)
+	var count int64
+	err := app.db.GetContext(ctx, &count, "SELECT COUNT(*) FROM applications"+where, args...)
+	return count, err
+}
+
+func (app *applicationDao) Create(ctx context.Context, in *core.Application) (int64, error) {
+	now := time.Now()
+	in.CreateTime = now
+	in.UpdateTime = now
+	result, err := app.db.NamedExecContext(ctx, `INSERT INTO applications
+	(name, description, service_node_id, spec, creator, create_time, update_time)
+	VALUES
+	(:name, :description, :service_node_id, :spec, :creator, :create_time, :update_time)`, in)
+	if err != nil {
+		return 0, err
+	}
+	in.ID, err = result.LastInsertId()
+	return in.ID, err
+}
+
+func (app *applicationDao) Update(ctx context.Context, in *core.Application) error {
+	in.UpdateTime = time.Now()
+	_, err := app.db.NamedExecContext(ctx, `UPDATE applications SET
+	description = :description, service_node_id = :service_node_id, spec = :spec, update_time = :update_time
+	WHERE id = :id`, in)
+	return err
+}
+
+func (app *applicationDao) Delete(ctx context.Context, id int64) error {
+	_, err := app.db.ExecContext(ctx, "DELETE FROM applications WHERE id = ?", id)
+	return err
+}
+
+func applicationFilter(in map[string]interface{}) (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	for _, key := range []string{"name", "service_node_id", "creator"} {
+		if v, ok := in[key]; ok && v != "" && v != int64(0) {
+			conds = append(conds, key+" = ?")
+			args = append(args, v)
+		}
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
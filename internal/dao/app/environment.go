@@ -0,0 +1,63 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideAppEnvironmentDao(db *sqlx.DB) core.AppEnvironmentDao {
+	return &environmentDao{db: db}
+}
+
+type environmentDao struct {
+	db *sqlx.DB
+}
+
+var _ core.AppEnvironmentDao = &environmentDao{}
+
+const environmentColumns = "id, app_id, name, cluster_id, namespace, overrides, creator, create_time, update_time"
+
+func (env *environmentDao) Get(ctx context.Context, id int64) (*core.AppEnvironment, error) {
+	out := new(core.AppEnvironment)
+	err := env.db.GetContext(ctx, out, "SELECT "+environmentColumns+" FROM app_environments WHERE id = ?", id)
+	return out, err
+}
+
+func (env *environmentDao) List(ctx context.Context, appID int64) ([]*core.AppEnvironment, error) {
+	out := []*core.AppEnvironment{}
+	err := env.db.SelectContext(ctx, &out,
+		"SELECT "+environmentColumns+" FROM app_environments WHERE app_id = ? ORDER BY id", appID)
+	return out, err
+}
+
+// Create app_environments 在 (app_id, name) 上有唯一索引
+func (env *environmentDao) Create(ctx context.Context, in *core.AppEnvironment) (int64, error) {
+	now := time.Now()
+	in.CreateTime = now
+	in.UpdateTime = now
+	result, err := env.db.NamedExecContext(ctx, `INSERT INTO app_environments
+	(app_id, name, cluster_id, namespace, overrides, creator, create_time, update_time)
+	VALUES
+	(:app_id, :name, :cluster_id, :namespace, :overrides, :creator, :create_time, :update_time)`, in)
+	if err != nil {
+		return 0, err
+	}
+	in.ID, err = result.LastInsertId()
+	return in.ID, err
+}
+
+func (env *environmentDao) Update(ctx context.Context, in *core.AppEnvironment) error {
+	in.UpdateTime = time.Now()
+	_, err := env.db.NamedExecContext(ctx, `UPDATE app_environments SET
+	cluster_id = :cluster_id, namespace = :namespace, overrides = :overrides, update_time = :update_time
+	WHERE id = :id`, in)
+	return err
+}
+
+func (env *environmentDao) Delete(ctx context.Context, id int64) error {
+	_, err := env.db.ExecContext(ctx, "DELETE FROM app_environments WHERE id = ?", id)
+	return err
+}
@@ -0,0 +1,99 @@
+package app
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideAppReleaseDao(db *sqlx.DB) core.AppReleaseDao {
+	return &releaseDao{db: db}
+}
+
+type releaseDao struct {
+	db *sqlx.DB
+}
+
+var _ core.AppReleaseDao = &releaseDao{}
+
+const releaseColumns = `id, app_id, env_id, revision, image, spec, manifest, status, message, rollback_from,
+	creator, create_time`
+
+func (release *releaseDao) Get(ctx context.Context, id int64) (*core.AppRelease, error) {
+	out := new(core.AppRelease)
+	err := release.db.GetContext(ctx, out, "SELECT "+releaseColumns+" FROM app_releases WHERE id = ?", id)
+	return out, err
+}
+
+// List 列表中不返回清单内容, 需要时通过 Get 获取
+func (release *releaseDao) List(ctx context.Context, in map[string]interface{}) ([]*core.AppRelease, error) {
+	where, args := releaseFilter(in)
+	query := `SELECT id, app_id, env_id, revision, image, spec, '' AS manifest, status, message, rollback_from,
+	creator, create_time FROM app_releases` + where + " ORDER BY env_id, revision DESC"
+	if limit, ok := in["limit"]; ok {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, in["offset"])
+	}
+	out := []*core.AppRelease{}
+	err := release.db.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+func (release *releaseDao) Count(ctx context.Context, in map[string]interface{}) (int64, error) {
+	where, args := releaseFilter(in)
+	var count int64
+	err := release.db.GetContext(ctx, &count, "SELECT COUNT(*) FROM app_releases"+where, args...)
+	return count, err
+}
+
+// Create 在同一个事务中分配版本号, app_releases 在 (env_id, revision) 上有唯一索引,
+// 并发发布同一个环境时后提交的一方会因为唯一索引冲突而失败
+func (release *releaseDao) Create(ctx context.Context, in *core.AppRelease) (int64, error) {
+	tx, err := release.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if err := tx.GetContext(ctx, &in.Revision,
+		"SELECT COALESCE(MAX(revision), 0) + 1 FROM app_releases WHERE env_id = ?", in.EnvID); err != nil {
+		return 0, err
+	}
+	in.CreateTime = time.Now()
+	result, err := tx.NamedExecContext(ctx, `INSERT INTO app_releases
+	(app_id, env_id, revision, image, spec, manifest, status, message, rollback_from, creator, create_time)
+	VALUES
+	(:app_id, :env_id, :revision, :image, :spec, :manifest, :status, :message, :rollback_from, :creator, :create_time)`, in)
+	if err != nil {
+		return 0, err
+	}
+	if in.ID, err = result.LastInsertId(); err != nil {
+		return 0, err
+	}
+	return in.ID, tx.Commit()
+}
+
+func (release *releaseDao) UpdateStatus(ctx context.Context, in *core.AppRelease) error {
+	_, err := release.db.NamedExecContext(ctx,
+		"UPDATE app_releases SET manifest = :manifest, status = :status, message = :message WHERE id = :id", in)
+	return err
+}
+
+func releaseFilter(in map[string]interface{}) (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	for _, key := range []string{"app_id", "env_id", "status"} {
+		if v, ok := in[key]; ok && v != "" && v != int64(0) {
+			conds = append(conds, key+" = ?")
+			args = append(args, v)
+		}
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
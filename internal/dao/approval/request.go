@@ -0,0 +1,129 @@
+package approval
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideApprovalRequestDao(db *sqlx.DB) core.ApprovalRequestDao {
+	return &requestDao{db: db}
+}
+
+type requestDao struct {
+	db *sqlx.DB
+}
+
+var _ core.ApprovalRequestDao = &requestDao{}
+
+const (
+	requestColumns = `id, rule_id, action, resource_type, resource_id, service_node_id, env_id, payload, reason,
+	requester, requester_id, approvers, required, status, result, expire_time, create_time, update_time, finish_time`
+	eventColumns = "id, request_id, type, operator, comment, create_time"
+)
+
+func (r *requestDao) Get(ctx context.Context, id int64) (*core.ApprovalRequest, error) {
+	out := new(core.ApprovalRequest)
+	err := r.db.GetContext(ctx, out, "SELECT "+requestColumns+" FROM approval_requests WHERE id = ?", id)
+	return out, err
+}
+
+func (r *requestDao) List(ctx context.Context, in map[string]interface{}) ([]*core.ApprovalRequest, error) {
+	where, args := requestFilter(in)
+	query := "SELECT " + requestColumns + " FROM approval_requests" + where + " ORDER BY id DESC"
+	if limit, ok := in["limit"]; ok {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, in["offset"])
+	}
+	out := []*core.ApprovalRequest{}
+	err := r.db.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+func (r *requestDao) Count(ctx context.Context, in map[string]interface{}) (int64, error) {
+	where, args := requestFilter(in)
+	var count int64
+	err := r.db.GetContext(ctx, &count, "SELECT COUNT(*) FROM approval_requests"+where, args...)
+	return count, err
+}
+
+func (r *requestDao) ListExpired(ctx context.Context, now time.Time) ([]*core.ApprovalRequest, error) {
+	out := []*core.ApprovalRequest{}
+	err := r.db.SelectContext(ctx, &out, "SELECT "+requestColumns+" FROM approval_requests WHERE status = ? AND expire_time < ?",
+		core.ApprovalPending, now)
+	return out, err
+}
+
+func (r *requestDao) Create(ctx context.Context, in *core.ApprovalRequest) (int64, error) {
+	now := time.Now()
+	in.CreateTime = now
+	in.UpdateTime = now
+	result, err := r.db.NamedExecContext(ctx, `INSERT INTO approval_requests
+	(rule_id, action, resource_type, resource_id, service_node_id, env_id, payload, reason, requester, requester_id,
+	approvers, required, status, result, expire_time, create_time, update_time, finish_time)
+	VALUES
+	(:rule_id, :action, :resource_type, :resource_id, :service_node_id, :env_id, :payload, :reason, :requester, :requester_id,
+	:approvers, :required, :status, :result, :expire_time, :create_time, :update_time, :finish_time)`, in)
+	if err != nil {
+		return 0, err
+	}
+	in.ID, err = result.LastInsertId()
+	return in.ID, err
+}
+
+func (r *requestDao) Transition(ctx context.Context, id int64, from, to string) (bool, error) {
+	result, err := r.db.ExecContext(ctx, "UPDATE approval_requests SET status = ?, update_time = ? WHERE id = ? AND status = ?",
+		to, time.Now(), id, from)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (r *requestDao) Finish(ctx context.Context, in *core.ApprovalRequest) error {
+	in.UpdateTime = time.Now()
+	_, err := r.db.NamedExecContext(ctx, `UPDATE approval_requests SET
+	status = :status, result = :result, update_time = :update_time, finish_time = :finish_time
+	WHERE id = :id`, in)
+	return err
+}
+
+func (r *requestDao) AddEvent(ctx context.Context, in *core.ApprovalEvent) error {
+	in.CreateTime = time.Now()
+	result, err := r.db.NamedExecContext(ctx, `INSERT INTO approval_events
+	(request_id, type, operator, comment, create_time)
+	VALUES
+	(:request_id, :type, :operator, :comment, :create_time)`, in)
+	if err != nil {
+		return err
+	}
+	in.ID, err = result.LastInsertId()
+	return err
+}
+
+func (r *requestDao) ListEvents(ctx context.Context, requestID int64) ([]*core.ApprovalEvent, error) {
+	out := []*core.ApprovalEvent{}
+	err := r.db.SelectContext(ctx, &out, "SELECT "+eventColumns+" FROM approval_events WHERE request_id = ? ORDER BY id", requestID)
+	return out, err
+}
+
+func requestFilter(in map[string]interface{}) (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	for _, key := range []string{"action", "status", "requester", "resource_type", "resource_id"} {
+		if v, ok := in[key]; ok && v != "" {
+			conds = append(conds, key+" = ?")
+			args = append(args, v)
+		}
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
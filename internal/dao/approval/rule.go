@@ -0,0 +1,69 @@
+package approval
+
+import (
+	"context"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideApprovalRuleDao(db *sqlx.DB) core.ApprovalRuleDao {
+	return &ruleDao{db: db}
+}
+
+type ruleDao struct {
+	db *sqlx.DB
+}
+
+var _ core.ApprovalRuleDao = &ruleDao{}
+
+const ruleColumns = "id, name, action, service_node_id, env_id, approvers, required, expire_hours, creator, create_time, update_time"
+
+func (r *ruleDao) Get(ctx context.Context, id int64) (*core.ApprovalRule, error) {
+	out := new(core.ApprovalRule)
+	err := r.db.GetContext(ctx, out, "SELECT "+ruleColumns+" FROM approval_rules WHERE id = ?", id)
+	return out, err
+}
+
+func (r *ruleDao) List(ctx context.Context, in map[string]interface{}) ([]*core.ApprovalRule, error) {
+	query := "SELECT " + ruleColumns + " FROM approval_rules"
+	var args []interface{}
+	if action, ok := in["action"]; ok && action != "" {
+		query += " WHERE action = ?"
+		args = append(args, action)
+	}
+	query += " ORDER BY id"
+	out := []*core.ApprovalRule{}
+	err := r.db.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+func (r *ruleDao) Create(ctx context.Context, in *core.ApprovalRule) (int64, error) {
+	now := time.Now()
+	in.CreateTime = now
+	in.UpdateTime = now
+	result, err := r.db.NamedExecContext(ctx, `INSERT INTO approval_rules
+	(name, action, service_node_id, env_id, approvers, required, expire_hours, creator, create_time, update_time)
+	VALUES
+	(:name, :action, :service_node_id, :env_id, :approvers, :required, :expire_hours, :creator, :create_time, :update_time)`, in)
+	if err != nil {
+		return 0, err
+	}
+	in.ID, err = result.LastInsertId()
+	return in.ID, err
+}
+
+func (r *ruleDao) Update(ctx context.Context, in *core.ApprovalRule) error {
+	in.UpdateTime = time.Now()
+	_, err := r.db.NamedExecContext(ctx, `UPDATE approval_rules SET
+	name = :name, action = :action, service_node_id = :service_node_id, env_id = :env_id, approvers = :approvers,
+	required = :required, expire_hours = :expire_hours, update_time = :update_time
+	WHERE id = :id`, in)
+	return err
+}
+
+func (r *ruleDao) Delete(ctx context.Context, id int64) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM approval_rules WHERE id = ?", id)
+	return err
+}
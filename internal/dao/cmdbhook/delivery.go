@@ -0,0 +1,132 @@
+package cmdbhook
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideCMDBHookDeliveryDao(db *sqlx.DB) core.CMDBHookDeliveryDao {
+	return &deliveryDao{db: db}
+}
+
+type deliveryDao struct {
+	db *sqlx.DB
+}
+
+var _ core.CMDBHookDeliveryDao = &deliveryDao{}
+
+const deliveryColumns = `id, hook_id, event, payload, status, attempts, response_code, last_error, next_time,
+	worker, create_time, update_time, delivered_time`
+
+func (d *deliveryDao) Get(ctx context.Context, id int64) (*core.CMDBHookDelivery, error) {
+	out := new(core.CMDBHookDelivery)
+	err := d.db.GetContext(ctx, out, "SELECT "+deliveryColumns+" FROM cmdb_hook_deliveries WHERE id = ?", id)
+	return out, err
+}
+
+func (d *deliveryDao) List(ctx context.Context, in map[string]interface{}) ([]*core.CMDBHookDelivery, error) {
+	where, args := deliveryFilter(in)
+	query := "SELECT " + deliveryColumns + " FROM cmdb_hook_deliveries" + where + " ORDER BY id DESC"
+	if limit, ok := in["limit"]; ok {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, in["offset"])
+	}
+	out := []*core.CMDBHookDelivery{}
+	err := d.db.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+func (d *deliveryDao) Count(ctx context.Context, in map[string]interface{}) (int64, error) {
+	where, args := deliveryFilter(in)
+	var count int64
+	err := d.db.GetContext(ctx, &count, "SELECT COUNT(*) FROM cmdb_hook_deliveries"+where, args...)
+	return count, err
+}
+
+func (d *deliveryDao) ListDue(ctx context.Context, now time.Time, limit int) ([]*core.CMDBHookDelivery, error) {
+	out := []*core.CMDBHookDelivery{}
+	err := d.db.SelectContext(ctx, &out, "SELECT "+deliveryColumns+
+		" FROM cmdb_hook_deliveries WHERE status = ? AND next_time <= ? ORDER BY next_time LIMIT ?", core.CMDBHookPending, now, limit)
+	return out, err
+}
+
+func (d *deliveryDao) Create(ctx context.Context, in *core.CMDBHookDelivery) (int64, error) {
+	now := time.Now()
+	in.CreateTime = now
+	in.UpdateTime = now
+	result, err := d.db.NamedExecContext(ctx, `INSERT INTO cmdb_hook_deliveries
+	(hook_id, event, payload, status, attempts, response_code, last_error, next_time,
+	worker, create_time, update_time, delivered_time)
+	VALUES
+	(:hook_id, :event, :payload, :status, :attempts, :response_code, :last_error, :next_time,
+	:worker, :create_time, :update_time, :delivered_time)`, in)
+	if err != nil {
+		return 0, err
+	}
+	in.ID, err = result.LastInsertId()
+	return in.ID, err
+}
+
+func (d *deliveryDao) Claim(ctx context.Context, id int64, worker string, now, until time.Time) (bool, error) {
+	result, err := d.db.ExecContext(ctx, `UPDATE cmdb_hook_deliveries SET worker = ?, next_time = ?, update_time = ?
+	WHERE id = ? AND status = ? AND next_time <= ?`, worker, until, now, id, core.CMDBHookPending, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (d *deliveryDao) Update(ctx context.Context, in *core.CMDBHookDelivery) (bool, error) {
+	in.UpdateTime = time.Now()
+	result, err := d.db.NamedExecContext(ctx, `UPDATE cmdb_hook_deliveries SET
+	status = :status, attempts = :attempts, response_code = :response_code, last_error = :last_error,
+	next_time = :next_time, update_time = :update_time, delivered_time = :delivered_time
+	WHERE id = :id AND worker = :worker`, in)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (d *deliveryDao) Requeue(ctx context.Context, id int64) (bool, error) {
+	now := time.Now()
+	result, err := d.db.ExecContext(ctx, `UPDATE cmdb_hook_deliveries SET status = ?, attempts = 0, next_time = ?, update_time = ?
+	WHERE id = ? AND status = ?`, core.CMDBHookPending, now, now, id, core.CMDBHookDead)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (d *deliveryDao) Prune(ctx context.Context, before time.Time) (int64, error) {
+	result, err := d.db.ExecContext(ctx, "DELETE FROM cmdb_hook_deliveries WHERE status = ? AND create_time < ?",
+		core.CMDBHookDelivered, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func deliveryFilter(in map[string]interface{}) (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	for _, key := range []string{"hook_id", "event", "status"} {
+		if v, ok := in[key]; ok && v != "" && v != int64(0) {
+			conds = append(conds, key+" = ?")
+			args = append(args, v)
+		}
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
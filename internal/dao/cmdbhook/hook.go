@@ -0,0 +1,61 @@
+package cmdbhook
+
+import (
+	"context"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideCMDBHookDao(db *sqlx.DB) core.CMDBHookDao {
+	return &hookDao{db: db}
+}
+
+type hookDao struct {
+	db *sqlx.DB
+}
+
+var _ core.CMDBHookDao = &hookDao{}
+
+const hookColumns = "id, name, url, secret, events, enabled, creator, create_time, update_time"
+
+func (h *hookDao) Get(ctx context.Context, id int64) (*core.CMDBHook, error) {
+	out := new(core.CMDBHook)
+	err := h.db.GetContext(ctx, out, "SELECT "+hookColumns+" FROM cmdb_hooks WHERE id = ?", id)
+	return out, err
+}
+
+func (h *hookDao) List(ctx context.Context) ([]*core.CMDBHook, error) {
+	out := []*core.CMDBHook{}
+	err := h.db.SelectContext(ctx, &out, "SELECT "+hookColumns+" FROM cmdb_hooks ORDER BY id")
+	return out, err
+}
+
+func (h *hookDao) Create(ctx context.Context, in *core.CMDBHook) (int64, error) {
+	now := time.Now()
+	in.CreateTime = now
+	in.UpdateTime = now
+	result, err := h.db.NamedExecContext(ctx, `INSERT INTO cmdb_hooks
+	(name, url, secret, events, enabled, creator, create_time, update_time)
+	VALUES
+	(:name, :url, :secret, :events, :enabled, :creator, :create_time, :update_time)`, in)
+	if err != nil {
+		return 0, err
+	}
+	in.ID, err = result.LastInsertId()
+	return in.ID, err
+}
+
+func (h *hookDao) Update(ctx context.Context, in *core.CMDBHook) error {
+	in.UpdateTime = time.Now()
+	_, err := h.db.NamedExecContext(ctx, `UPDATE cmdb_hooks SET
+	name = :name, url = :url, secret = :secret, events = :events, enabled = :enabled, update_time = :update_time
+	WHERE id = :id`, in)
+	return err
+}
+
+func (h *hookDao) Delete(ctx context.Context, id int64) error {
+	_, err := h.db.ExecContext(ctx, "DELETE FROM cmdb_hooks WHERE id = ?", id)
+	return err
+}
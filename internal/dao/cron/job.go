@@ -0,0 +1,98 @@
+package cron
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideCronJobDao(db *sqlx.DB) core.CronJobDao {
+	return &jobDao{db: db}
+}
+
+type jobDao struct {
+	db *sqlx.DB
+}
+
+var _ core.CronJobDao = &jobDao{}
+
+const jobColumns = `id, name, task_type, schedule, timezone, params, timeout, paused, next_time, last_time,
+	creator, create_time, update_time`
+
+func (j *jobDao) Get(ctx context.Context, id int64) (*core.CronJob, error) {
+	out := new(core.CronJob)
+	err := j.db.GetContext(ctx, out, "SELECT "+jobColumns+" FROM cron_jobs WHERE id = ?", id)
+	return out, err
+}
+
+func (j *jobDao) List(ctx context.Context, in map[string]interface{}) ([]*core.CronJob, error) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	if v, ok := in["task_type"]; ok && v != "" {
+		conds = append(conds, "task_type = ?")
+		args = append(args, v)
+	}
+	if v, ok := in["paused"].(bool); ok {
+		conds = append(conds, "paused = ?")
+		args = append(args, v)
+	}
+	query := "SELECT " + jobColumns + " FROM cron_jobs"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	out := []*core.CronJob{}
+	err := j.db.SelectContext(ctx, &out, query+" ORDER BY id", args...)
+	return out, err
+}
+
+func (j *jobDao) ListDue(ctx context.Context, now time.Time) ([]*core.CronJob, error) {
+	out := []*core.CronJob{}
+	err := j.db.SelectContext(ctx, &out, "SELECT "+jobColumns+
+		" FROM cron_jobs WHERE paused = 0 AND next_time <= ? ORDER BY next_time", now)
+	return out, err
+}
+
+func (j *jobDao) Create(ctx context.Context, in *core.CronJob) (int64, error) {
+	now := time.Now()
+	in.CreateTime = now
+	in.UpdateTime = now
+	result, err := j.db.NamedExecContext(ctx, `INSERT INTO cron_jobs
+	(name, task_type, schedule, timezone, params, timeout, paused, next_time, last_time, creator, create_time, update_time)
+	VALUES
+	(:name, :task_type, :schedule, :timezone, :params, :timeout, :paused, :next_time, :last_time, :creator, :create_time, :update_time)`, in)
+	if err != nil {
+		return 0, err
+	}
+	in.ID, err = result.LastInsertId()
+	return in.ID, err
+}
+
+func (j *jobDao) Update(ctx context.Context, in *core.CronJob) error {
+	in.UpdateTime = time.Now()
+	_, err := j.db.NamedExecContext(ctx, `UPDATE cron_jobs SET
+	name = :name, task_type = :task_type, schedule = :schedule, timezone = :timezone, params = :params,
+	timeout = :timeout, paused = :paused, next_time = :next_time, update_time = :update_time
+	WHERE id = :id`, in)
+	return err
+}
+
+func (j *jobDao) Delete(ctx context.Context, id int64) error {
+	_, err := j.db.ExecContext(ctx, "DELETE FROM cron_jobs WHERE id = ?", id)
+	return err
+}
+
+func (j *jobDao) Claim(ctx context.Context, id int64, from, next time.Time) (bool, error) {
+	// 多个副本同时认领时只有一个副本的 UPDATE 能匹配到原来的 next_time
+	result, err := j.db.ExecContext(ctx, "UPDATE cron_jobs SET next_time = ?, last_time = ? WHERE id = ? AND next_time = ? AND paused = 0",
+		next, time.Now(), id, from)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,145 @@
+package cron
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/eventbus"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideCronRunDao(db *sqlx.DB, bus *eventbus.Bus) core.CronRunDao {
+	return &runDao{db: db, bus: bus}
+}
+
+type runDao struct {
+	db  *sqlx.DB
+	bus *eventbus.Bus
+}
+
+var _ core.CronRunDao = &runDao{}
+
+const (
+	// runColumns 列表中不返回输出, 单个执行记录才返回
+	runColumns = `id, job_id, task_type, trigger_type, operator, status, error, deadline, start_time, finish_time, create_time`
+	runDetail  = runColumns + ", output"
+)
+
+func (r *runDao) Get(ctx context.Context, id int64) (*core.CronRun, error) {
+	out := new(core.CronRun)
+	err := r.db.GetContext(ctx, out, "SELECT "+runDetail+" FROM cron_runs WHERE id = ?", id)
+	return out, err
+}
+
+func (r *runDao) List(ctx context.Context, in map[string]interface{}) ([]*core.CronRun, error) {
+	where, args := runFilter(in)
+	query := "SELECT " + runColumns + " FROM cron_runs" + where + " ORDER BY id DESC"
+	if limit, ok := in["limit"]; ok {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, in["offset"])
+	}
+	out := []*core.CronRun{}
+	err := r.db.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+func (r *runDao) Count(ctx context.Context, in map[string]interface{}) (int64, error) {
+	where, args := runFilter(in)
+	var count int64
+	err := r.db.GetContext(ctx, &count, "SELECT COUNT(*) FROM cron_runs"+where, args...)
+	return count, err
+}
+
+func (r *runDao) ListPending(ctx context.Context) ([]*core.CronRun, error) {
+	out := []*core.CronRun{}
+	err := r.db.SelectContext(ctx, &out, "SELECT "+runColumns+" FROM cron_runs WHERE status = ? ORDER BY id",
+		core.CronRunPending)
+	return out, err
+}
+
+func (r *runDao) ListStale(ctx context.Context, before time.Time) ([]*core.CronRun, error) {
+	out := []*core.CronRun{}
+	err := r.db.SelectContext(ctx, &out, "SELECT "+runColumns+" FROM cron_runs WHERE status = ? AND deadline < ?",
+		core.CronRunRunning, before)
+	return out, err
+}
+
+func (r *runDao) Create(ctx context.Context, in *core.CronRun) (int64, error) {
+	in.CreateTime = time.Now()
+	result, err := r.db.NamedExecContext(ctx, `INSERT INTO cron_runs
+	(job_id, task_type, trigger_type, operator, status, output, error, deadline, start_time, finish_time, create_time)
+	VALUES
+	(:job_id, :task_type, :trigger_type, :operator, :status, :output, :error, :deadline, :start_time, :finish_time, :create_time)`, in)
+	if err != nil {
+		return 0, err
+	}
+	if in.ID, err = result.LastInsertId(); err != nil {
+		return 0, err
+	}
+	r.publish(&core.ResourceChange{Resource: core.ResourceCronRun, ID: in.ID, Op: core.ChangeCreated, Data: in})
+	return in.ID, nil
+}
+
+func (r *runDao) Start(ctx context.Context, in *core.CronRun) (bool, error) {
+	result, err := r.db.ExecContext(ctx, "UPDATE cron_runs SET status = ?, deadline = ?, start_time = ? WHERE id = ? AND status = ?",
+		core.CronRunRunning, in.Deadline, in.StartTime, in.ID, core.CronRunPending)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err == nil && n > 0 {
+		in.Status = core.CronRunRunning
+		r.publish(&core.ResourceChange{Resource: core.ResourceCronRun, ID: in.ID, Op: core.ChangeStatus, Data: in})
+	}
+	return n > 0, err
+}
+
+func (r *runDao) Finish(ctx context.Context, in *core.CronRun) (bool, error) {
+	result, err := r.db.ExecContext(ctx, "UPDATE cron_runs SET status = ?, output = ?, error = ?, finish_time = ? WHERE id = ? AND status = ?",
+		in.Status, in.Output, in.Error, in.FinishTime, in.ID, core.CronRunRunning)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err == nil && n > 0 {
+		r.publish(&core.ResourceChange{Resource: core.ResourceCronRun, ID: in.ID, Op: core.ChangeStatus, Data: in})
+	}
+	return n > 0, err
+}
+
+func (r *runDao) Prune(ctx context.Context, before time.Time) (int64, error) {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM cron_runs WHERE finish_time < ?", before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// publish 发布一份执行记录的副本, 不包含输出, 订阅者异步处理事件, 避免与调用方后续的修改竞争
+func (r *runDao) publish(change *core.ResourceChange) {
+	if data, ok := change.Data.(*core.CronRun); ok {
+		copied := *data
+		copied.Output = ""
+		change.Data = &copied
+	}
+	r.bus.Publish(change.Topic(), change)
+}
+
+func runFilter(in map[string]interface{}) (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	for _, key := range []string{"job_id", "status", "trigger_type"} {
+		if v, ok := in[key]; ok && v != "" && v != int64(0) {
+			conds = append(conds, key+" = ?")
+			args = append(args, v)
+		}
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
@@ -0,0 +1,68 @@
+package gitlab
+
+import (
+	"context"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideGitlabConnectionDao(db *sqlx.DB) core.GitlabConnectionDao {
+	return &connectionDao{db: db}
+}
+
+type connectionDao struct {
+	db *sqlx.DB
+}
+
+var _ core.GitlabConnectionDao = &connectionDao{}
+
+const connectionColumns = "id, name, base_url, token, status, version, username, last_error, last_check_time, creator, create_time, update_time"
+
+func (c *connectionDao) Get(ctx context.Context, id int64) (*core.GitlabConnection, error) {
+	out := new(core.GitlabConnection)
+	err := c.db.GetContext(ctx, out, "SELECT "+connectionColumns+" FROM gitlab_connections WHERE id = ?", id)
+	return out, err
+}
+
+func (c *connectionDao) List(ctx context.Context) ([]*core.GitlabConnection, error) {
+	out := []*core.GitlabConnection{}
+	err := c.db.SelectContext(ctx, &out, "SELECT "+connectionColumns+" FROM gitlab_connections ORDER BY id")
+	return out, err
+}
+
+func (c *connectionDao) Create(ctx context.Context, in *core.GitlabConnection) (int64, error) {
+	now := time.Now()
+	in.CreateTime = now
+	in.UpdateTime = now
+	result, err := c.db.NamedExecContext(ctx, `INSERT INTO gitlab_connections
+	(name, base_url, token, status, version, username, last_error, last_check_time, creator, create_time, update_time)
+	VALUES
+	(:name, :base_url, :token, :status, :version, :username, :last_error, :last_check_time, :creator, :create_time, :update_time)`, in)
+	if err != nil {
+		return 0, err
+	}
+	in.ID, err = result.LastInsertId()
+	return in.ID, err
+}
+
+func (c *connectionDao) Update(ctx context.Context, in *core.GitlabConnection) error {
+	in.UpdateTime = time.Now()
+	_, err := c.db.NamedExecContext(ctx, `UPDATE gitlab_connections SET
+	name = :name, base_url = :base_url, token = :token, update_time = :update_time
+	WHERE id = :id`, in)
+	return err
+}
+
+func (c *connectionDao) UpdateStatus(ctx context.Context, in *core.GitlabConnection) error {
+	_, err := c.db.NamedExecContext(ctx, `UPDATE gitlab_connections SET
+	status = :status, version = :version, username = :username, last_error = :last_error, last_check_time = :last_check_time
+	WHERE id = :id`, in)
+	return err
+}
+
+func (c *connectionDao) Delete(ctx context.Context, id int64) error {
+	_, err := c.db.ExecContext(ctx, "DELETE FROM gitlab_connections WHERE id = ?", id)
+	return err
+}
@@ -0,0 +1,111 @@
+package gitlab
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideGitlabProjectDao(db *sqlx.DB) core.GitlabProjectDao {
+	return &projectDao{db: db}
+}
+
+type projectDao struct {
+	db *sqlx.DB
+}
+
+var _ core.GitlabProjectDao = &projectDao{}
+
+const projectColumns = "id, connection_id, gitlab_id, name, path, web_url, http_url, default_branch, service_node_id, refs_error, refs_sync_time, creator, create_time, update_time"
+
+func (p *projectDao) Get(ctx context.Context, id int64) (*core.GitlabProject, error) {
+	out := new(core.GitlabProject)
+	err := p.db.GetContext(ctx, out, "SELECT "+projectColumns+", refs FROM gitlab_projects WHERE id = ?", id)
+	return out, err
+}
+
+func (p *projectDao) GetByGitlabID(ctx context.Context, connectionID, gitlabID int64) (*core.GitlabProject, error) {
+	out := new(core.GitlabProject)
+	err := p.db.GetContext(ctx, out, "SELECT "+projectColumns+", refs FROM gitlab_projects WHERE connection_id = ? AND gitlab_id = ?",
+		connectionID, gitlabID)
+	return out, err
+}
+
+func (p *projectDao) List(ctx context.Context, in map[string]interface{}) ([]*core.GitlabProject, error) {
+	where, args := projectFilter(in)
+	query := "SELECT " + projectColumns + " FROM gitlab_projects" + where + " ORDER BY path"
+	if limit, ok := in["limit"]; ok {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, in["offset"])
+	}
+	out := []*core.GitlabProject{}
+	err := p.db.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+func (p *projectDao) Count(ctx context.Context, in map[string]interface{}) (int64, error) {
+	where, args := projectFilter(in)
+	var count int64
+	err := p.db.GetContext(ctx, &count, "SELECT COUNT(*) FROM gitlab_projects"+where, args...)
+	return count, err
+}
+
+func (p *projectDao) Create(ctx context.Context, in *core.GitlabProject) (int64, error) {
+	now := time.Now()
+	in.CreateTime = now
+	in.UpdateTime = now
+	result, err := p.db.NamedExecContext(ctx, `INSERT INTO gitlab_projects
+	(connection_id, gitlab_id, name, path, web_url, http_url, default_branch, service_node_id, refs, refs_error, refs_sync_time, creator, create_time, update_time)
+	VALUES
+	(:connection_id, :gitlab_id, :name, :path, :web_url, :http_url, :default_branch, :service_node_id, :refs, :refs_error, :refs_sync_time, :creator, :create_time, :update_time)`, in)
+	if err != nil {
+		return 0, err
+	}
+	in.ID, err = result.LastInsertId()
+	return in.ID, err
+}
+
+func (p *projectDao) Update(ctx context.Context, in *core.GitlabProject) error {
+	in.UpdateTime = time.Now()
+	_, err := p.db.NamedExecContext(ctx, `UPDATE gitlab_projects SET
+	name = :name, path = :path, web_url = :web_url, http_url = :http_url, default_branch = :default_branch,
+	service_node_id = :service_node_id, update_time = :update_time
+	WHERE id = :id`, in)
+	return err
+}
+
+func (p *projectDao) UpdateRefs(ctx context.Context, in *core.GitlabProject) error {
+	_, err := p.db.NamedExecContext(ctx, `UPDATE gitlab_projects SET
+	refs = :refs, refs_error = :refs_error, refs_sync_time = :refs_sync_time
+	WHERE id = :id`, in)
+	return err
+}
+
+func (p *projectDao) Delete(ctx context.Context, id int64) error {
+	_, err := p.db.ExecContext(ctx, "DELETE FROM gitlab_projects WHERE id = ?", id)
+	return err
+}
+
+func projectFilter(in map[string]interface{}) (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	for _, key := range []string{"connection_id", "service_node_id"} {
+		if v, ok := in[key]; ok && v != "" && v != int64(0) {
+			conds = append(conds, key+" = ?")
+			args = append(args, v)
+		}
+	}
+	if v, ok := in["path"]; ok && v != "" {
+		conds = append(conds, "path LIKE ?")
+		args = append(args, "%"+v.(string)+"%")
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
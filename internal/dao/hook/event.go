@@ -0,0 +1,91 @@
+package hook
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideHookEventDao(db *sqlx.DB) core.HookEventDao {
+	return &eventDao{db: db}
+}
+
+type eventDao struct {
+	db *sqlx.DB
+}
+
+var _ core.HookEventDao = &eventDao{}
+
+// eventColumns 不包含 payload, 只有 Get 返回原始请求体
+const eventColumns = "id, source, delivery_id, event_type, project, ref, commit_sha, create_time"
+
+func (e *eventDao) Create(ctx context.Context, in *core.HookEvent) (bool, error) {
+	in.CreateTime = time.Now()
+	// (source, delivery_id) 为唯一索引, 重复投递的事件不会插入新记录
+	result, err := e.db.NamedExecContext(ctx, `INSERT IGNORE INTO hook_events
+	(source, delivery_id, event_type, project, ref, commit_sha, payload, create_time)
+	VALUES
+	(:source, :delivery_id, :event_type, :project, :ref, :commit_sha, :payload, :create_time)`, in)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	in.ID, err = result.LastInsertId()
+	return true, err
+}
+
+func (e *eventDao) Get(ctx context.Context, id int64) (*core.HookEvent, error) {
+	out := new(core.HookEvent)
+	err := e.db.GetContext(ctx, out, "SELECT "+eventColumns+", payload FROM hook_events WHERE id = ?", id)
+	return out, err
+}
+
+func (e *eventDao) List(ctx context.Context, in map[string]interface{}) ([]*core.HookEvent, error) {
+	where, args := eventFilter(in)
+	query := "SELECT " + eventColumns + " FROM hook_events" + where + " ORDER BY id DESC"
+	if limit, ok := in["limit"]; ok {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, in["offset"])
+	}
+	out := []*core.HookEvent{}
+	err := e.db.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+func (e *eventDao) Count(ctx context.Context, in map[string]interface{}) (int64, error) {
+	where, args := eventFilter(in)
+	var count int64
+	err := e.db.GetContext(ctx, &count, "SELECT COUNT(*) FROM hook_events"+where, args...)
+	return count, err
+}
+
+func (e *eventDao) Prune(ctx context.Context, in time.Time) (int64, error) {
+	result, err := e.db.ExecContext(ctx, "DELETE FROM hook_events WHERE create_time < ?", in)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func eventFilter(in map[string]interface{}) (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	for _, key := range []string{"source", "event_type", "project"} {
+		if v, ok := in[key]; ok && v != "" {
+			conds = append(conds, key+" = ?")
+			args = append(args, v)
+		}
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
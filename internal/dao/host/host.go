@@ -2,37 +2,158 @@ package host
 
 import (
 	"context"
+	"strings"
+	"time"
 
 	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/eventbus"
 	"github.com/jmoiron/sqlx"
 )
 
-func ProvideHostDao(db *sqlx.DB) core.HostInstanceDao {
-	return &hostDao{db: db}
+// ProvideHostDao 返回的 DAO 在写入成功后将 host.created/updated/deleted/status_changed 发布到事件总线
+func ProvideHostDao(db *sqlx.DB, bus *eventbus.Bus) core.HostInstanceDao {
+	return &hostDao{db: db, bus: bus}
 }
 
 type hostDao struct {
-	db *sqlx.DB
+	db  *sqlx.DB
+	bus *eventbus.Bus
 }
 
 var _ core.HostInstanceDao = &hostDao{}
 
+const hostColumns = `id, instance_id, host_name, cpu_cores, cpu_sockets, mem_size, os_name, kernel_version,
+	conn_port, host_status, host_type, kube_cluster_id, kube_node_name, create_time, update_time, remark`
+
 func (host *hostDao) Get(ctx context.Context, in int64) (*core.HostInstance, error) {
-	return nil, nil
+	out := new(core.HostInstance)
+	err := host.db.GetContext(ctx, out, "SELECT "+hostColumns+" FROM host_instances WHERE id = ?", in)
+	return out, err
+}
+
+func (host *hostDao) GetByInstance(ctx context.Context, in string) (*core.HostInstance, error) {
+	out := new(core.HostInstance)
+	err := host.db.GetContext(ctx, out, "SELECT "+hostColumns+" FROM host_instances WHERE instance_id = ?", in)
+	return out, err
 }
 
 func (host *hostDao) List(ctx context.Context, in map[string]interface{}) ([]*core.HostInstance, error) {
-	return nil, nil
+	where, args := hostFilter(in)
+	query := "SELECT " + hostColumns + " FROM host_instances" + where + " ORDER BY id"
+	if limit, ok := in["limit"]; ok {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, in["offset"])
+	}
+	out := []*core.HostInstance{}
+	err := host.db.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+func (host *hostDao) Count(ctx context.Context, in map[string]interface{}) (int64, error) {
+	where, args := hostFilter(in)
+	var count int64
+	err := host.db.GetContext(ctx, &count, "SELECT COUNT(*) FROM host_instances"+where, args...)
+	return count, err
 }
 
 func (host *hostDao) Create(ctx context.Context, in *core.HostInstance) (int64, error) {
-	return 1, nil
+	now := time.Now()
+	in.CreateTime = now
+	in.UpdateTime = now
+	result, err := host.db.NamedExecContext(ctx, `INSERT INTO host_instances
+	(instance_id, host_name, cpu_cores, cpu_sockets, mem_size, os_name, kernel_version, conn_port,
+	host_status, host_type, kube_cluster_id, kube_node_name, create_time, update_time, remark)
+	VALUES
+	(:instance_id, :host_name, :cpu_cores, :cpu_sockets, :mem_size, :os_name, :kernel_version, :conn_port,
+	:host_status, :host_type, :kube_cluster_id, :kube_node_name, :create_time, :update_time, :remark)`, in)
+	if err != nil {
+		return 0, err
+	}
+	in.ID, err = result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	host.publish(&core.ResourceChange{Resource: core.ResourceHost, ID: in.ID, Op: core.ChangeCreated, Data: in})
+	return in.ID, nil
 }
 
 func (host *hostDao) Update(ctx context.Context, in *core.HostInstance) (*core.HostInstance, error) {
-	return nil, nil
+	in.UpdateTime = time.Now()
+	tx, err := host.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	previous := new(core.HostInstance)
+	err = tx.GetContext(ctx, previous, "SELECT "+hostColumns+" FROM host_instances WHERE id = ? FOR UPDATE", in.ID)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.NamedExecContext(ctx, `UPDATE host_instances SET
+	instance_id = :instance_id, host_name = :host_name, cpu_cores = :cpu_cores, cpu_sockets = :cpu_sockets,
+	mem_size = :mem_size, os_name = :os_name, kernel_version = :kernel_version, conn_port = :conn_port,
+	host_status = :host_status, host_type = :host_type, kube_cluster_id = :kube_cluster_id,
+	kube_node_name = :kube_node_name, update_time = :update_time, remark = :remark
+	WHERE id = :id`, in)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	change := &core.ResourceChange{Resource: core.ResourceHost, ID: in.ID, Op: core.ChangeUpdated, Data: in, Previous: previous}
+	host.publish(change)
+	if previous.HostStatus != in.HostStatus {
+		status := *change
+		status.Op = core.ChangeStatus
+		host.publish(&status)
+	}
+	return in, nil
 }
 
 func (host *hostDao) Delete(ctx context.Context, in int64) error {
+	tx, err := host.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	previous := new(core.HostInstance)
+	err = tx.GetContext(ctx, previous, "SELECT "+hostColumns+" FROM host_instances WHERE id = ? FOR UPDATE", in)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM host_instances WHERE id = ?", in); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	host.publish(&core.ResourceChange{Resource: core.ResourceHost, ID: in, Op: core.ChangeDeleted, Data: previous})
 	return nil
 }
+
+// publish 发布一份主机的副本, 订阅者异步处理事件, 避免与调用方后续的修改竞争
+func (host *hostDao) publish(change *core.ResourceChange) {
+	if data, ok := change.Data.(*core.HostInstance); ok {
+		copied := *data
+		change.Data = &copied
+	}
+	host.bus.Publish(change.Topic(), change)
+}
+
+func hostFilter(in map[string]interface{}) (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	for _, key := range []string{"host_status", "kube_cluster_id"} {
+		if v, ok := in[key]; ok && v != "" && v != 0 && v != int64(0) {
+			conds = append(conds, key+" = ?")
+			args = append(args, v)
+		}
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
@@ -0,0 +1,93 @@
+package jenkins
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideJenkinsBuildDao(db *sqlx.DB) core.JenkinsBuildDao {
+	return &buildDao{db: db}
+}
+
+type buildDao struct {
+	db *sqlx.DB
+}
+
+var _ core.JenkinsBuildDao = &buildDao{}
+
+// buildColumns 不包含 console, 只有 Get 返回控制台日志
+const buildColumns = "id, job_id, parameters, queue_id, number, url, status, duration, message, creator, create_time, start_time, finish_time"
+
+func (b *buildDao) Get(ctx context.Context, id int64) (*core.JenkinsBuild, error) {
+	out := new(core.JenkinsBuild)
+	err := b.db.GetContext(ctx, out, "SELECT "+buildColumns+", console FROM jenkins_builds WHERE id = ?", id)
+	return out, err
+}
+
+func (b *buildDao) List(ctx context.Context, in map[string]interface{}) ([]*core.JenkinsBuild, error) {
+	where, args := buildFilter(in)
+	query := "SELECT " + buildColumns + " FROM jenkins_builds" + where + " ORDER BY id DESC"
+	if limit, ok := in["limit"]; ok {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, in["offset"])
+	}
+	out := []*core.JenkinsBuild{}
+	err := b.db.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+func (b *buildDao) Count(ctx context.Context, in map[string]interface{}) (int64, error) {
+	where, args := buildFilter(in)
+	var count int64
+	err := b.db.GetContext(ctx, &count, "SELECT COUNT(*) FROM jenkins_builds"+where, args...)
+	return count, err
+}
+
+func (b *buildDao) ListActive(ctx context.Context) ([]*core.JenkinsBuild, error) {
+	out := []*core.JenkinsBuild{}
+	err := b.db.SelectContext(ctx, &out, "SELECT "+buildColumns+" FROM jenkins_builds WHERE status IN (?, ?) ORDER BY id",
+		core.JenkinsBuildQueued, core.JenkinsBuildBuilding)
+	return out, err
+}
+
+func (b *buildDao) Create(ctx context.Context, in *core.JenkinsBuild) (int64, error) {
+	in.CreateTime = time.Now()
+	result, err := b.db.NamedExecContext(ctx, `INSERT INTO jenkins_builds
+	(job_id, parameters, queue_id, number, url, status, duration, console, message, creator, create_time, start_time, finish_time)
+	VALUES
+	(:job_id, :parameters, :queue_id, :number, :url, :status, :duration, :console, :message, :creator, :create_time, :start_time, :finish_time)`, in)
+	if err != nil {
+		return 0, err
+	}
+	in.ID, err = result.LastInsertId()
+	return in.ID, err
+}
+
+func (b *buildDao) Update(ctx context.Context, in *core.JenkinsBuild) error {
+	_, err := b.db.NamedExecContext(ctx, `UPDATE jenkins_builds SET
+	queue_id = :queue_id, number = :number, url = :url, status = :status, duration = :duration,
+	console = :console, message = :message, start_time = :start_time, finish_time = :finish_time
+	WHERE id = :id`, in)
+	return err
+}
+
+func buildFilter(in map[string]interface{}) (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	for _, key := range []string{"job_id", "status", "creator"} {
+		if v, ok := in[key]; ok && v != "" && v != int64(0) {
+			conds = append(conds, key+" = ?")
+			args = append(args, v)
+		}
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
@@ -0,0 +1,92 @@
+package jenkins
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideJenkinsJobDao(db *sqlx.DB) core.JenkinsJobDao {
+	return &jobDao{db: db}
+}
+
+type jobDao struct {
+	db *sqlx.DB
+}
+
+var _ core.JenkinsJobDao = &jobDao{}
+
+const jobColumns = "id, server_id, name, app_id, service_node_id, parameters, creator, create_time, update_time"
+
+func (j *jobDao) Get(ctx context.Context, id int64) (*core.JenkinsJob, error) {
+	out := new(core.JenkinsJob)
+	err := j.db.GetContext(ctx, out, "SELECT "+jobColumns+" FROM jenkins_jobs WHERE id = ?", id)
+	return out, err
+}
+
+func (j *jobDao) List(ctx context.Context, in map[string]interface{}) ([]*core.JenkinsJob, error) {
+	where, args := jobFilter(in)
+	query := "SELECT " + jobColumns + " FROM jenkins_jobs" + where + " ORDER BY name"
+	if limit, ok := in["limit"]; ok {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, in["offset"])
+	}
+	out := []*core.JenkinsJob{}
+	err := j.db.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+func (j *jobDao) Count(ctx context.Context, in map[string]interface{}) (int64, error) {
+	where, args := jobFilter(in)
+	var count int64
+	err := j.db.GetContext(ctx, &count, "SELECT COUNT(*) FROM jenkins_jobs"+where, args...)
+	return count, err
+}
+
+func (j *jobDao) Create(ctx context.Context, in *core.JenkinsJob) (int64, error) {
+	now := time.Now()
+	in.CreateTime = now
+	in.UpdateTime = now
+	result, err := j.db.NamedExecContext(ctx, `INSERT INTO jenkins_jobs
+	(server_id, name, app_id, service_node_id, parameters, creator, create_time, update_time)
+	VALUES
+	(:server_id, :name, :app_id, :service_node_id, :parameters, :creator, :create_time, :update_time)`, in)
+	if err != nil {
+		return 0, err
+	}
+	in.ID, err = result.LastInsertId()
+	return in.ID, err
+}
+
+func (j *jobDao) Update(ctx context.Context, in *core.JenkinsJob) error {
+	in.UpdateTime = time.Now()
+	_, err := j.db.NamedExecContext(ctx, `UPDATE jenkins_jobs SET
+	name = :name, app_id = :app_id, service_node_id = :service_node_id, parameters = :parameters, update_time = :update_time
+	WHERE id = :id`, in)
+	return err
+}
+
+func (j *jobDao) Delete(ctx context.Context, id int64) error {
+	_, err := j.db.ExecContext(ctx, "DELETE FROM jenkins_jobs WHERE id = ?", id)
+	return err
+}
+
+func jobFilter(in map[string]interface{}) (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	for _, key := range []string{"server_id", "app_id", "service_node_id"} {
+		if v, ok := in[key]; ok && v != "" && v != int64(0) {
+			conds = append(conds, key+" = ?")
+			args = append(args, v)
+		}
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
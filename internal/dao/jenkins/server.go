@@ -0,0 +1,68 @@
+package jenkins
+
+import (
+	"context"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideJenkinsServerDao(db *sqlx.DB) core.JenkinsServerDao {
+	return &serverDao{db: db}
+}
+
+type serverDao struct {
+	db *sqlx.DB
+}
+
+var _ core.JenkinsServerDao = &serverDao{}
+
+const serverColumns = "id, name, url, username, token, status, version, last_error, last_check_time, creator, create_time, update_time"
+
+func (s *serverDao) Get(ctx context.Context, id int64) (*core.JenkinsServer, error) {
+	out := new(core.JenkinsServer)
+	err := s.db.GetContext(ctx, out, "SELECT "+serverColumns+" FROM jenkins_servers WHERE id = ?", id)
+	return out, err
+}
+
+func (s *serverDao) List(ctx context.Context) ([]*core.JenkinsServer, error) {
+	out := []*core.JenkinsServer{}
+	err := s.db.SelectContext(ctx, &out, "SELECT "+serverColumns+" FROM jenkins_servers ORDER BY id")
+	return out, err
+}
+
+func (s *serverDao) Create(ctx context.Context, in *core.JenkinsServer) (int64, error) {
+	now := time.Now()
+	in.CreateTime = now
+	in.UpdateTime = now
+	result, err := s.db.NamedExecContext(ctx, `INSERT INTO jenkins_servers
+	(name, url, username, token, status, version, last_error, last_check_time, creator, create_time, update_time)
+	VALUES
+	(:name, :url, :username, :token, :status, :version, :last_error, :last_check_time, :creator, :create_time, :update_time)`, in)
+	if err != nil {
+		return 0, err
+	}
+	in.ID, err = result.LastInsertId()
+	return in.ID, err
+}
+
+func (s *serverDao) Update(ctx context.Context, in *core.JenkinsServer) error {
+	in.UpdateTime = time.Now()
+	_, err := s.db.NamedExecContext(ctx, `UPDATE jenkins_servers SET
+	name = :name, url = :url, username = :username, token = :token, update_time = :update_time
+	WHERE id = :id`, in)
+	return err
+}
+
+func (s *serverDao) UpdateStatus(ctx context.Context, in *core.JenkinsServer) error {
+	_, err := s.db.NamedExecContext(ctx, `UPDATE jenkins_servers SET
+	status = :status, version = :version, last_error = :last_error, last_check_time = :last_check_time
+	WHERE id = :id`, in)
+	return err
+}
+
+func (s *serverDao) Delete(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM jenkins_servers WHERE id = ?", id)
+	return err
+}
@@ -0,0 +1,91 @@
+package kubernetes
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideKubeAccessGrantDao(db *sqlx.DB) core.KubeAccessGrantDao {
+	return &accessDao{db: db}
+}
+
+type accessDao struct {
+	db *sqlx.DB
+}
+
+var _ core.KubeAccessGrantDao = &accessDao{}
+
+const accessColumns = `id, cluster_id, namespace, subject_kind, subject_name, user_id, role, mode, status, last_error,
+	sync_time, creator, create_time`
+
+func (access *accessDao) Get(ctx context.Context, id int64) (*core.KubeAccessGrant, error) {
+	out := new(core.KubeAccessGrant)
+	err := access.db.GetContext(ctx, out, "SELECT "+accessColumns+" FROM kube_access_grants WHERE id = ?", id)
+	return out, err
+}
+
+func (access *accessDao) List(ctx context.Context, in map[string]interface{}) ([]*core.KubeAccessGrant, error) {
+	where, args := accessFilter(in)
+	query := "SELECT " + accessColumns + " FROM kube_access_grants" + where + " ORDER BY id DESC"
+	if limit, ok := in["limit"]; ok {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, in["offset"])
+	}
+	out := []*core.KubeAccessGrant{}
+	err := access.db.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+func (access *accessDao) Count(ctx context.Context, in map[string]interface{}) (int64, error) {
+	where, args := accessFilter(in)
+	var count int64
+	err := access.db.GetContext(ctx, &count, "SELECT COUNT(*) FROM kube_access_grants"+where, args...)
+	return count, err
+}
+
+// Create kube_access_grants 在 (cluster_id, namespace, subject_kind, subject_name, user_id, role) 上有唯一索引
+func (access *accessDao) Create(ctx context.Context, in *core.KubeAccessGrant) (int64, error) {
+	in.Status = core.KubeAccessPending
+	in.CreateTime = time.Now()
+	result, err := access.db.NamedExecContext(ctx, `INSERT INTO kube_access_grants
+	(cluster_id, namespace, subject_kind, subject_name, user_id, role, mode, status, last_error, creator, create_time)
+	VALUES
+	(:cluster_id, :namespace, :subject_kind, :subject_name, :user_id, :role, :mode, :status, :last_error, :creator, :create_time)`, in)
+	if err != nil {
+		return 0, err
+	}
+	in.ID, err = result.LastInsertId()
+	return in.ID, err
+}
+
+func (access *accessDao) UpdateStatus(ctx context.Context, in *core.KubeAccessGrant) error {
+	_, err := access.db.NamedExecContext(ctx,
+		"UPDATE kube_access_grants SET status = :status, last_error = :last_error, sync_time = :sync_time WHERE id = :id", in)
+	return err
+}
+
+func (access *accessDao) Delete(ctx context.Context, id int64) error {
+	_, err := access.db.ExecContext(ctx, "DELETE FROM kube_access_grants WHERE id = ?", id)
+	return err
+}
+
+func accessFilter(in map[string]interface{}) (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	for _, key := range []string{"cluster_id", "namespace", "subject_kind", "user_id", "mode"} {
+		if v, ok := in[key]; ok && v != "" && v != int64(0) {
+			conds = append(conds, key+" = ?")
+			args = append(args, v)
+		}
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
@@ -0,0 +1,71 @@
+package kubernetes
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideKubeWorkloadActionDao(db *sqlx.DB) core.KubeWorkloadActionDao {
+	return &actionDao{db: db}
+}
+
+type actionDao struct {
+	db *sqlx.DB
+}
+
+var _ core.KubeWorkloadActionDao = &actionDao{}
+
+const actionColumns = "id, cluster_id, namespace, kind, name, action, detail, error, operator, create_time"
+
+func (action *actionDao) List(ctx context.Context, in map[string]interface{}) ([]*core.KubeWorkloadAction, error) {
+	where, args := actionFilter(in)
+	query := "SELECT " + actionColumns + " FROM kube_workload_actions" + where + " ORDER BY id DESC"
+	if limit, ok := in["limit"]; ok {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, in["offset"])
+	}
+	out := []*core.KubeWorkloadAction{}
+	err := action.db.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+func (action *actionDao) Count(ctx context.Context, in map[string]interface{}) (int64, error) {
+	where, args := actionFilter(in)
+	var count int64
+	err := action.db.GetContext(ctx, &count, "SELECT COUNT(*) FROM kube_workload_actions"+where, args...)
+	return count, err
+}
+
+func (action *actionDao) Create(ctx context.Context, in *core.KubeWorkloadAction) (int64, error) {
+	in.CreateTime = time.Now()
+	result, err := action.db.NamedExecContext(ctx, `INSERT INTO kube_workload_actions
+	(cluster_id, namespace, kind, name, action, detail, error, operator, create_time)
+	VALUES
+	(:cluster_id, :namespace, :kind, :name, :action, :detail, :error, :operator, :create_time)`, in)
+	if err != nil {
+		return 0, err
+	}
+	in.ID, err = result.LastInsertId()
+	return in.ID, err
+}
+
+func actionFilter(in map[string]interface{}) (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	for _, key := range []string{"cluster_id", "namespace", "kind", "name", "operator"} {
+		if v, ok := in[key]; ok && v != "" && v != int64(0) {
+			conds = append(conds, key+" = ?")
+			args = append(args, v)
+		}
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
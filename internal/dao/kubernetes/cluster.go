@@ -0,0 +1,92 @@
+package kubernetes
+
+import (
+	"context"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideKubeClusterDao(db *sqlx.DB) core.KubeClusterDao {
+	return &clusterDao{db: db}
+}
+
+type clusterDao struct {
+	db *sqlx.DB
+}
+
+var _ core.KubeClusterDao = &clusterDao{}
+
+const clusterColumns = `id, name, description, auth_type, server, credential, status, version, node_count,
+	last_error, last_probe_time, creator, create_time, update_time`
+
+func (cluster *clusterDao) Get(ctx context.Context, in int64) (*core.KubeCluster, error) {
+	out := new(core.KubeCluster)
+	err := cluster.db.GetContext(ctx, out, "SELECT "+clusterColumns+" FROM kube_clusters WHERE id = ?", in)
+	return out, err
+}
+
+func (cluster *clusterDao) List(ctx context.Context, in map[string]interface{}) ([]*core.KubeCluster, error) {
+	where, args := clusterFilter(in)
+	query := "SELECT " + clusterColumns + " FROM kube_clusters" + where + " ORDER BY id"
+	if limit, ok := in["limit"]; ok {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, in["offset"])
+	}
+	out := []*core.KubeCluster{}
+	err := cluster.db.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+func (cluster *clusterDao) Count(ctx context.Context, in map[string]interface{}) (int64, error) {
+	where, args := clusterFilter(in)
+	var count int64
+	err := cluster.db.GetContext(ctx, &count, "SELECT COUNT(*) FROM kube_clusters"+where, args...)
+	return count, err
+}
+
+func (cluster *clusterDao) Create(ctx context.Context, in *core.KubeCluster) (int64, error) {
+	now := time.Now()
+	in.Status = core.KubeClusterUnknown
+	in.CreateTime = now
+	in.UpdateTime = now
+	result, err := cluster.db.NamedExecContext(ctx, `INSERT INTO kube_clusters
+	(name, description, auth_type, server, credential, status, version, node_count, last_error, creator, create_time, update_time)
+	VALUES
+	(:name, :description, :auth_type, :server, :credential, :status, :version, :node_count, :last_error, :creator, :create_time, :update_time)`, in)
+	if err != nil {
+		return 0, err
+	}
+	in.ID, err = result.LastInsertId()
+	return in.ID, err
+}
+
+func (cluster *clusterDao) Update(ctx context.Context, in *core.KubeCluster) error {
+	in.UpdateTime = time.Now()
+	_, err := cluster.db.NamedExecContext(ctx, `UPDATE kube_clusters SET
+	name = :name, description = :description, auth_type = :auth_type, server = :server,
+	credential = :credential, update_time = :update_time
+	WHERE id = :id`, in)
+	return err
+}
+
+func (cluster *clusterDao) UpdateStatus(ctx context.Context, in *core.KubeCluster) error {
+	_, err := cluster.db.NamedExecContext(ctx, `UPDATE kube_clusters SET
+	status = :status, version = :version, node_count = :node_count, last_error = :last_error,
+	last_probe_time = :last_probe_time
+	WHERE id = :id`, in)
+	return err
+}
+
+func (cluster *clusterDao) Delete(ctx context.Context, in int64) error {
+	_, err := cluster.db.ExecContext(ctx, "DELETE FROM kube_clusters WHERE id = ?", in)
+	return err
+}
+
+func clusterFilter(in map[string]interface{}) (string, []interface{}) {
+	if v, ok := in["status"]; ok && v != "" {
+		return " WHERE status = ?", []interface{}{v}
+	}
+	return "", nil
+}
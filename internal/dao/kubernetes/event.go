@@ -0,0 +1,86 @@
+package kubernetes
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideKubeEventDao(db *sqlx.DB) core.KubeEventDao {
+	return &eventDao{db: db}
+}
+
+type eventDao struct {
+	db *sqlx.DB
+}
+
+var _ core.KubeEventDao = &eventDao{}
+
+const eventColumns = `id, cluster_id, uid, namespace, kind, name, reason, message, source, count,
+	first_seen, last_seen`
+
+// Upsert kube_events 在 (cluster_id, uid) 上有唯一索引
+func (event *eventDao) Upsert(ctx context.Context, in *core.KubeEvent) error {
+	_, err := event.db.NamedExecContext(ctx, `INSERT INTO kube_events
+	(cluster_id, uid, namespace, kind, name, reason, message, source, count, first_seen, last_seen)
+	VALUES
+	(:cluster_id, :uid, :namespace, :kind, :name, :reason, :message, :source, :count, :first_seen, :last_seen)
+	ON DUPLICATE KEY UPDATE
+	message = VALUES(message), count = VALUES(count), last_seen = VALUES(last_seen)`, in)
+	return err
+}
+
+func (event *eventDao) List(ctx context.Context, in map[string]interface{}) ([]*core.KubeEvent, error) {
+	where, args := eventFilter(in)
+	query := "SELECT " + eventColumns + " FROM kube_events" + where + " ORDER BY last_seen DESC, id DESC"
+	if limit, ok := in["limit"]; ok {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, in["offset"])
+	}
+	out := []*core.KubeEvent{}
+	err := event.db.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+func (event *eventDao) Count(ctx context.Context, in map[string]interface{}) (int64, error) {
+	where, args := eventFilter(in)
+	var count int64
+	err := event.db.GetContext(ctx, &count, "SELECT COUNT(*) FROM kube_events"+where, args...)
+	return count, err
+}
+
+func (event *eventDao) Prune(ctx context.Context, before time.Time) (int64, error) {
+	result, err := event.db.ExecContext(ctx, "DELETE FROM kube_events WHERE last_seen < ?", before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func eventFilter(in map[string]interface{}) (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	for _, key := range []string{"cluster_id", "namespace", "kind", "name", "reason"} {
+		if v, ok := in[key]; ok && v != "" && v != int64(0) {
+			conds = append(conds, key+" = ?")
+			args = append(args, v)
+		}
+	}
+	if v, ok := in["since"].(time.Time); ok && !v.IsZero() {
+		conds = append(conds, "last_seen >= ?")
+		args = append(args, v)
+	}
+	if v, ok := in["until"].(time.Time); ok && !v.IsZero() {
+		conds = append(conds, "last_seen <= ?")
+		args = append(args, v)
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
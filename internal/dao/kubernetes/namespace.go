@@ -0,0 +1,86 @@
+package kubernetes
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideKubeNamespaceDao(db *sqlx.DB) core.KubeNamespaceDao {
+	return &namespaceDao{db: db}
+}
+
+type namespaceDao struct {
+	db *sqlx.DB
+}
+
+var _ core.KubeNamespaceDao = &namespaceDao{}
+
+const namespaceColumns = `id, cluster_id, name, service_node_id, team, quota_template_id, labels, status, sync_time,
+	creator, create_time, update_time`
+
+func (namespace *namespaceDao) Get(ctx context.Context, clusterID int64, name string) (*core.KubeNamespace, error) {
+	out := new(core.KubeNamespace)
+	err := namespace.db.GetContext(ctx, out,
+		"SELECT "+namespaceColumns+" FROM kube_namespaces WHERE cluster_id = ? AND name = ?", clusterID, name)
+	return out, err
+}
+
+func (namespace *namespaceDao) List(ctx context.Context, in map[string]interface{}) ([]*core.KubeNamespace, error) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	for _, key := range []string{"cluster_id", "service_node_id", "team"} {
+		if v, ok := in[key]; ok && v != "" && v != int64(0) {
+			conds = append(conds, key+" = ?")
+			args = append(args, v)
+		}
+	}
+	query := "SELECT " + namespaceColumns + " FROM kube_namespaces"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	out := []*core.KubeNamespace{}
+	err := namespace.db.SelectContext(ctx, &out, query+" ORDER BY cluster_id, name", args...)
+	return out, err
+}
+
+func (namespace *namespaceDao) Create(ctx context.Context, in *core.KubeNamespace) (int64, error) {
+	now := time.Now()
+	in.Status = core.KubeNamespaceActive
+	in.SyncTime = &now
+	in.CreateTime = now
+	in.UpdateTime = now
+	result, err := namespace.db.NamedExecContext(ctx, `INSERT INTO kube_namespaces
+	(cluster_id, name, service_node_id, team, quota_template_id, labels, status, sync_time, creator, create_time, update_time)
+	VALUES
+	(:cluster_id, :name, :service_node_id, :team, :quota_template_id, :labels, :status, :sync_time, :creator, :create_time, :update_time)`, in)
+	if err != nil {
+		return 0, err
+	}
+	in.ID, err = result.LastInsertId()
+	return in.ID, err
+}
+
+func (namespace *namespaceDao) Update(ctx context.Context, in *core.KubeNamespace) error {
+	in.UpdateTime = time.Now()
+	_, err := namespace.db.NamedExecContext(ctx, `UPDATE kube_namespaces SET
+	service_node_id = :service_node_id, team = :team, labels = :labels, update_time = :update_time
+	WHERE id = :id`, in)
+	return err
+}
+
+func (namespace *namespaceDao) UpdateStatus(ctx context.Context, in *core.KubeNamespace) error {
+	_, err := namespace.db.NamedExecContext(ctx,
+		"UPDATE kube_namespaces SET status = :status, sync_time = :sync_time WHERE id = :id", in)
+	return err
+}
+
+func (namespace *namespaceDao) Delete(ctx context.Context, in int64) error {
+	_, err := namespace.db.ExecContext(ctx, "DELETE FROM kube_namespaces WHERE id = ?", in)
+	return err
+}
@@ -0,0 +1,57 @@
+package kubernetes
+
+import (
+	"context"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideKubeApplyPlanDao(db *sqlx.DB) core.KubeApplyPlanDao {
+	return &planDao{db: db}
+}
+
+type planDao struct {
+	db *sqlx.DB
+}
+
+var _ core.KubeApplyPlanDao = &planDao{}
+
+const planColumns = `id, cluster_id, manifest, changes, status, result, user_id, creator,
+	create_time, expire_time, apply_time`
+
+func (plan *planDao) Get(ctx context.Context, id string) (*core.KubeApplyPlan, error) {
+	out := new(core.KubeApplyPlan)
+	err := plan.db.GetContext(ctx, out, "SELECT "+planColumns+" FROM kube_apply_plans WHERE id = ?", id)
+	return out, err
+}
+
+func (plan *planDao) Create(ctx context.Context, in *core.KubeApplyPlan) error {
+	in.Status = core.KubeApplyPending
+	in.CreateTime = time.Now()
+	_, err := plan.db.NamedExecContext(ctx, `INSERT INTO kube_apply_plans
+	(id, cluster_id, manifest, changes, status, result, user_id, creator, create_time, expire_time)
+	VALUES
+	(:id, :cluster_id, :manifest, :changes, :status, :result, :user_id, :creator, :create_time, :expire_time)`, in)
+	return err
+}
+
+func (plan *planDao) Claim(ctx context.Context, id string) (bool, error) {
+	result, err := plan.db.ExecContext(ctx,
+		"UPDATE kube_apply_plans SET status = ? WHERE id = ? AND status = ? AND expire_time > ?",
+		core.KubeApplyApplying, id, core.KubeApplyPending, time.Now())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+func (plan *planDao) Finish(ctx context.Context, in *core.KubeApplyPlan) error {
+	now := time.Now()
+	in.ApplyTime = &now
+	_, err := plan.db.NamedExecContext(ctx,
+		"UPDATE kube_apply_plans SET status = :status, result = :result, apply_time = :apply_time WHERE id = :id", in)
+	return err
+}
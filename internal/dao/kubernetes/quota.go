@@ -0,0 +1,63 @@
+package kubernetes
+
+import (
+	"context"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideKubeQuotaTemplateDao(db *sqlx.DB) core.KubeQuotaTemplateDao {
+	return &quotaTemplateDao{db: db}
+}
+
+type quotaTemplateDao struct {
+	db *sqlx.DB
+}
+
+var _ core.KubeQuotaTemplateDao = &quotaTemplateDao{}
+
+const quotaTemplateColumns = `id, name, description, hard, default_limit, default_request, max, min,
+	creator, create_time, update_time`
+
+func (template *quotaTemplateDao) Get(ctx context.Context, in int64) (*core.KubeQuotaTemplate, error) {
+	out := new(core.KubeQuotaTemplate)
+	err := template.db.GetContext(ctx, out, "SELECT "+quotaTemplateColumns+" FROM kube_quota_templates WHERE id = ?", in)
+	return out, err
+}
+
+func (template *quotaTemplateDao) List(ctx context.Context) ([]*core.KubeQuotaTemplate, error) {
+	out := []*core.KubeQuotaTemplate{}
+	err := template.db.SelectContext(ctx, &out, "SELECT "+quotaTemplateColumns+" FROM kube_quota_templates ORDER BY id")
+	return out, err
+}
+
+func (template *quotaTemplateDao) Create(ctx context.Context, in *core.KubeQuotaTemplate) (int64, error) {
+	now := time.Now()
+	in.CreateTime = now
+	in.UpdateTime = now
+	result, err := template.db.NamedExecContext(ctx, `INSERT INTO kube_quota_templates
+	(name, description, hard, default_limit, default_request, max, min, creator, create_time, update_time)
+	VALUES
+	(:name, :description, :hard, :default_limit, :default_request, :max, :min, :creator, :create_time, :update_time)`, in)
+	if err != nil {
+		return 0, err
+	}
+	in.ID, err = result.LastInsertId()
+	return in.ID, err
+}
+
+func (template *quotaTemplateDao) Update(ctx context.Context, in *core.KubeQuotaTemplate) error {
+	in.UpdateTime = time.Now()
+	_, err := template.db.NamedExecContext(ctx, `UPDATE kube_quota_templates SET
+	name = :name, description = :description, hard = :hard, default_limit = :default_limit,
+	default_request = :default_request, max = :max, min = :min, update_time = :update_time
+	WHERE id = :id`, in)
+	return err
+}
+
+func (template *quotaTemplateDao) Delete(ctx context.Context, in int64) error {
+	_, err := template.db.ExecContext(ctx, "DELETE FROM kube_quota_templates WHERE id = ?", in)
+	return err
+}
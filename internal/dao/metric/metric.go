@@ -0,0 +1,107 @@
+package metric
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideMetricDao(db *sqlx.DB) core.MetricDao {
+	return &metricDao{db: db}
+}
+
+type metricDao struct {
+	db *sqlx.DB
+}
+
+var _ core.MetricDao = &metricDao{}
+
+// 每种精度的数据保存在单独的表中
+var tables = map[string]string{
+	core.ResolutionRaw:    "metrics_raw",
+	core.ResolutionMinute: "metrics_1m",
+	core.ResolutionHour:   "metrics_1h",
+}
+
+// 每种精度的时间桶大小, 单位秒
+var buckets = map[string]int64{
+	core.ResolutionMinute: 60,
+	core.ResolutionHour:   3600,
+}
+
+func table(resolution string) (string, error) {
+	name, ok := tables[resolution]
+	if !ok {
+		return "", fmt.Errorf("unknown metric resolution %q", resolution)
+	}
+	return name, nil
+}
+
+func (metric *metricDao) Insert(ctx context.Context, in []*core.MetricPoint) error {
+	if len(in) == 0 {
+		return nil
+	}
+	// (agent_id, metric, ts) 为主键(见 doc/schema/metrics.sql), agent 重发的样本不会插入新记录
+	_, err := metric.db.NamedExecContext(ctx, `INSERT IGNORE INTO metrics_raw
+	(agent_id, metric, ts, value, min, max, count)
+	VALUES
+	(:agent_id, :metric, :ts, :value, :min, :max, :count)`, in)
+	return err
+}
+
+func (metric *metricDao) Query(ctx context.Context, resolution string, agentID int64, name string, from, to time.Time, step int64) ([]*core.MetricPoint, error) {
+	src, err := table(resolution)
+	if err != nil {
+		return nil, err
+	}
+	out := []*core.MetricPoint{}
+	err = metric.db.SelectContext(ctx, &out, `SELECT
+	FROM_UNIXTIME(FLOOR(UNIX_TIMESTAMP(ts) / ?) * ?) AS ts,
+	SUM(value * count) / SUM(count) AS value, MIN(min) AS min, MAX(max) AS max, SUM(count) AS count
+	FROM `+src+`
+	WHERE agent_id = ? AND metric = ? AND ts >= ? AND ts < ?
+	GROUP BY 1 ORDER BY 1`, step, step, agentID, name, from, to)
+	return out, err
+}
+
+func (metric *metricDao) Rollup(ctx context.Context, src, dst string, from, to time.Time) error {
+	srcTable, err := table(src)
+	if err != nil {
+		return err
+	}
+	dstTable, err := table(dst)
+	if err != nil {
+		return err
+	}
+	bucket, ok := buckets[dst]
+	if !ok {
+		return fmt.Errorf("cannot rollup into %q", dst)
+	}
+	// 重复聚合同一个时间桶时按主键 (agent_id, metric, ts) 覆盖之前的结果
+	_, err = metric.db.ExecContext(ctx, `INSERT INTO `+dstTable+`
+	(agent_id, metric, ts, value, min, max, count)
+	SELECT agent_id, metric, FROM_UNIXTIME(FLOOR(UNIX_TIMESTAMP(ts) / ?) * ?) AS bucket,
+	SUM(value * count) / SUM(count), MIN(min), MAX(max), SUM(count)
+	FROM `+srcTable+`
+	WHERE ts >= ? AND ts < ?
+	GROUP BY agent_id, metric, bucket
+	ON DUPLICATE KEY UPDATE value = VALUES(value), min = VALUES(min), max = VALUES(max), count = VALUES(count)`,
+		bucket, bucket, from, to,
+	)
+	return err
+}
+
+func (metric *metricDao) Prune(ctx context.Context, resolution string, before time.Time) (int64, error) {
+	name, err := table(resolution)
+	if err != nil {
+		return 0, err
+	}
+	result, err := metric.db.ExecContext(ctx, "DELETE FROM "+name+" WHERE ts < ?", before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,71 @@
+package notify
+
+import (
+	"context"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideNotifyChannelDao(db *sqlx.DB) core.NotifyChannelDao {
+	return &channelDao{db: db}
+}
+
+type channelDao struct {
+	db *sqlx.DB
+}
+
+var _ core.NotifyChannelDao = &channelDao{}
+
+const channelColumns = "id, name, type, url, secret, enabled, creator, create_time, update_time"
+
+func (c *channelDao) Get(ctx context.Context, id int64) (*core.NotifyChannel, error) {
+	out := new(core.NotifyChannel)
+	err := c.db.GetContext(ctx, out, "SELECT "+channelColumns+" FROM notify_channels WHERE id = ?", id)
+	return out, err
+}
+
+func (c *channelDao) List(ctx context.Context) ([]*core.NotifyChannel, error) {
+	out := []*core.NotifyChannel{}
+	err := c.db.SelectContext(ctx, &out, "SELECT "+channelColumns+" FROM notify_channels ORDER BY id")
+	return out, err
+}
+
+func (c *channelDao) Create(ctx context.Context, in *core.NotifyChannel) (int64, error) {
+	now := time.Now()
+	in.CreateTime = now
+	in.UpdateTime = now
+	result, err := c.db.NamedExecContext(ctx, `INSERT INTO notify_channels
+	(name, type, url, secret, enabled, creator, create_time, update_time)
+	VALUES
+	(:name, :type, :url, :secret, :enabled, :creator, :create_time, :update_time)`, in)
+	if err != nil {
+		return 0, err
+	}
+	in.ID, err = result.LastInsertId()
+	return in.ID, err
+}
+
+func (c *channelDao) Update(ctx context.Context, in *core.NotifyChannel) error {
+	in.UpdateTime = time.Now()
+	_, err := c.db.NamedExecContext(ctx, `UPDATE notify_channels SET
+	name = :name, type = :type, url = :url, secret = :secret, enabled = :enabled, update_time = :update_time
+	WHERE id = :id`, in)
+	return err
+}
+
+func (c *channelDao) Delete(ctx context.Context, id int64) error {
+	tx, err := c.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "DELETE FROM notify_subscriptions WHERE channel_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM notify_channels WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,121 @@
+package notify
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideNotifyDeliveryDao(db *sqlx.DB) core.NotifyDeliveryDao {
+	return &deliveryDao{db: db}
+}
+
+type deliveryDao struct {
+	db *sqlx.DB
+}
+
+var _ core.NotifyDeliveryDao = &deliveryDao{}
+
+const deliveryColumns = `id, event, channel_id, channel_type, user_id, recipient, subject, body, payload, status,
+	attempts, last_error, next_time, worker, create_time, update_time, sent_time`
+
+func (d *deliveryDao) Get(ctx context.Context, id int64) (*core.NotifyDelivery, error) {
+	out := new(core.NotifyDelivery)
+	err := d.db.GetContext(ctx, out, "SELECT "+deliveryColumns+" FROM notify_deliveries WHERE id = ?", id)
+	return out, err
+}
+
+func (d *deliveryDao) List(ctx context.Context, in map[string]interface{}) ([]*core.NotifyDelivery, error) {
+	where, args := deliveryFilter(in)
+	query := "SELECT " + deliveryColumns + " FROM notify_deliveries" + where + " ORDER BY id DESC"
+	if limit, ok := in["limit"]; ok {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, in["offset"])
+	}
+	out := []*core.NotifyDelivery{}
+	err := d.db.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+func (d *deliveryDao) Count(ctx context.Context, in map[string]interface{}) (int64, error) {
+	where, args := deliveryFilter(in)
+	var count int64
+	err := d.db.GetContext(ctx, &count, "SELECT COUNT(*) FROM notify_deliveries"+where, args...)
+	return count, err
+}
+
+func (d *deliveryDao) ListDue(ctx context.Context, now time.Time, limit int) ([]*core.NotifyDelivery, error) {
+	out := []*core.NotifyDelivery{}
+	err := d.db.SelectContext(ctx, &out, "SELECT "+deliveryColumns+
+		" FROM notify_deliveries WHERE status = ? AND next_time <= ? ORDER BY next_time LIMIT ?", core.NotifyPending, now, limit)
+	return out, err
+}
+
+func (d *deliveryDao) Create(ctx context.Context, in *core.NotifyDelivery) (int64, error) {
+	now := time.Now()
+	in.CreateTime = now
+	in.UpdateTime = now
+	result, err := d.db.NamedExecContext(ctx, `INSERT INTO notify_deliveries
+	(event, channel_id, channel_type, user_id, recipient, subject, body, payload, status,
+	attempts, last_error, next_time, worker, create_time, update_time, sent_time)
+	VALUES
+	(:event, :channel_id, :channel_type, :user_id, :recipient, :subject, :body, :payload, :status,
+	:attempts, :last_error, :next_time, :worker, :create_time, :update_time, :sent_time)`, in)
+	if err != nil {
+		return 0, err
+	}
+	in.ID, err = result.LastInsertId()
+	return in.ID, err
+}
+
+func (d *deliveryDao) Claim(ctx context.Context, id int64, worker string, now, until time.Time) (bool, error) {
+	result, err := d.db.ExecContext(ctx, `UPDATE notify_deliveries SET worker = ?, next_time = ?, update_time = ?
+	WHERE id = ? AND status = ? AND next_time <= ?`, worker, until, now, id, core.NotifyPending, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (d *deliveryDao) Update(ctx context.Context, in *core.NotifyDelivery) (bool, error) {
+	in.UpdateTime = time.Now()
+	result, err := d.db.NamedExecContext(ctx, `UPDATE notify_deliveries SET
+	status = :status, attempts = :attempts, last_error = :last_error, next_time = :next_time,
+	update_time = :update_time, sent_time = :sent_time
+	WHERE id = :id AND worker = :worker`, in)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (d *deliveryDao) Prune(ctx context.Context, before time.Time) (int64, error) {
+	result, err := d.db.ExecContext(ctx, "DELETE FROM notify_deliveries WHERE status <> ? AND create_time < ?",
+		core.NotifyPending, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func deliveryFilter(in map[string]interface{}) (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	for _, key := range []string{"event", "status", "channel_id", "user_id"} {
+		if v, ok := in[key]; ok && v != "" && v != int64(0) {
+			conds = append(conds, key+" = ?")
+			args = append(args, v)
+		}
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
@@ -0,0 +1,76 @@
+package notify
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideNotifySubscriptionDao(db *sqlx.DB) core.NotifySubscriptionDao {
+	return &subscriptionDao{db: db}
+}
+
+type subscriptionDao struct {
+	db *sqlx.DB
+}
+
+var _ core.NotifySubscriptionDao = &subscriptionDao{}
+
+const subscriptionColumns = "id, user_id, channel_id, event, create_time"
+
+func (s *subscriptionDao) Get(ctx context.Context, id int64) (*core.NotifySubscription, error) {
+	out := new(core.NotifySubscription)
+	err := s.db.GetContext(ctx, out, "SELECT "+subscriptionColumns+" FROM notify_subscriptions WHERE id = ?", id)
+	return out, err
+}
+
+func (s *subscriptionDao) List(ctx context.Context, in map[string]interface{}) ([]*core.NotifySubscription, error) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	for _, key := range []string{"user_id", "channel_id"} {
+		if v, ok := in[key]; ok && v != int64(0) {
+			conds = append(conds, key+" = ?")
+			args = append(args, v)
+		}
+	}
+	query := "SELECT " + subscriptionColumns + " FROM notify_subscriptions"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	out := []*core.NotifySubscription{}
+	err := s.db.SelectContext(ctx, &out, query+" ORDER BY id", args...)
+	return out, err
+}
+
+func (s *subscriptionDao) Create(ctx context.Context, in *core.NotifySubscription) (int64, error) {
+	in.CreateTime = time.Now()
+	// (user_id, channel_id, event) 为唯一索引, 重复订阅时返回已有的记录
+	result, err := s.db.NamedExecContext(ctx, `INSERT IGNORE INTO notify_subscriptions
+	(user_id, channel_id, event, create_time)
+	VALUES
+	(:user_id, :channel_id, :event, :create_time)`, in)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		in.ID, err = result.LastInsertId()
+		return in.ID, err
+	}
+	err = s.db.GetContext(ctx, in, "SELECT "+subscriptionColumns+
+		" FROM notify_subscriptions WHERE user_id = ? AND channel_id = ? AND event = ?", in.UserID, in.ChannelID, in.Event)
+	return in.ID, err
+}
+
+func (s *subscriptionDao) Delete(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM notify_subscriptions WHERE id = ?", id)
+	return err
+}
@@ -0,0 +1,41 @@
+package notify
+
+import (
+	"context"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideNotifyTemplateDao(db *sqlx.DB) core.NotifyTemplateDao {
+	return &templateDao{db: db}
+}
+
+type templateDao struct {
+	db *sqlx.DB
+}
+
+var _ core.NotifyTemplateDao = &templateDao{}
+
+func (t *templateDao) List(ctx context.Context) ([]*core.NotifyTemplate, error) {
+	out := []*core.NotifyTemplate{}
+	err := t.db.SelectContext(ctx, &out, "SELECT id, event, subject, body, update_time FROM notify_templates ORDER BY event")
+	return out, err
+}
+
+func (t *templateDao) Save(ctx context.Context, in *core.NotifyTemplate) error {
+	in.UpdateTime = time.Now()
+	// event 为唯一索引
+	_, err := t.db.NamedExecContext(ctx, `INSERT INTO notify_templates
+	(event, subject, body, update_time)
+	VALUES
+	(:event, :subject, :body, :update_time)
+	ON DUPLICATE KEY UPDATE subject = VALUES(subject), body = VALUES(body), update_time = VALUES(update_time)`, in)
+	return err
+}
+
+func (t *templateDao) Delete(ctx context.Context, event string) error {
+	_, err := t.db.ExecContext(ctx, "DELETE FROM notify_templates WHERE event = ?", event)
+	return err
+}
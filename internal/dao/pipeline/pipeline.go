@@ -0,0 +1,92 @@
+package pipeline
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvidePipelineDao(db *sqlx.DB) core.PipelineDao {
+	return &pipelineDao{db: db}
+}
+
+type pipelineDao struct {
+	db *sqlx.DB
+}
+
+var _ core.PipelineDao = &pipelineDao{}
+
+const pipelineColumns = "id, name, app_id, service_node_id, stages, creator, create_time, update_time"
+
+func (p *pipelineDao) Get(ctx context.Context, id int64) (*core.Pipeline, error) {
+	out := new(core.Pipeline)
+	err := p.db.GetContext(ctx, out, "SELECT "+pipelineColumns+" FROM pipelines WHERE id = ?", id)
+	return out, err
+}
+
+func (p *pipelineDao) List(ctx context.Context, in map[string]interface{}) ([]*core.Pipeline, error) {
+	where, args := pipelineFilter(in)
+	query := "SELECT " + pipelineColumns + " FROM pipelines" + where + " ORDER BY name"
+	if limit, ok := in["limit"]; ok {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, in["offset"])
+	}
+	out := []*core.Pipeline{}
+	err := p.db.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+func (p *pipelineDao) Count(ctx context.Context, in map[string]interface{}) (int64, error) {
+	where, args := pipelineFilter(in)
+	var count int64
+	err := p.db.GetContext(ctx, &count, "SELECT COUNT(*) FROM pipelines"+where, args...)
+	return count, err
+}
+
+func (p *pipelineDao) Create(ctx context.Context, in *core.Pipeline) (int64, error) {
+	now := time.Now()
+	in.CreateTime = now
+	in.UpdateTime = now
+	result, err := p.db.NamedExecContext(ctx, `INSERT INTO pipelines
+	(name, app_id, service_node_id, stages, creator, create_time, update_time)
+	VALUES
+	(:name, :app_id, :service_node_id, :stages, :creator, :create_time, :update_time)`, in)
+	if err != nil {
+		return 0, err
+	}
+	in.ID, err = result.LastInsertId()
+	return in.ID, err
+}
+
+func (p *pipelineDao) Update(ctx context.Context, in *core.Pipeline) error {
+	in.UpdateTime = time.Now()
+	_, err := p.db.NamedExecContext(ctx, `UPDATE pipelines SET
+	name = :name, app_id = :app_id, service_node_id = :service_node_id, stages = :stages, update_time = :update_time
+	WHERE id = :id`, in)
+	return err
+}
+
+func (p *pipelineDao) Delete(ctx context.Context, id int64) error {
+	_, err := p.db.ExecContext(ctx, "DELETE FROM pipelines WHERE id = ?", id)
+	return err
+}
+
+func pipelineFilter(in map[string]interface{}) (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	for _, key := range []string{"app_id", "service_node_id"} {
+		if v, ok := in[key]; ok && v != "" && v != int64(0) {
+			conds = append(conds, key+" = ?")
+			args = append(args, v)
+		}
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
@@ -0,0 +1,215 @@
+package pipeline
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/eventbus"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvidePipelineRunDao(db *sqlx.DB, bus *eventbus.Bus) core.PipelineRunDao {
+	return &runDao{db: db, bus: bus}
+}
+
+type runDao struct {
+	db  *sqlx.DB
+	bus *eventbus.Bus
+}
+
+var _ core.PipelineRunDao = &runDao{}
+
+const (
+	runColumns = `id, pipeline_id, app_id, stages, parameters, status, current, message,
+	creator, creator_id, create_time, update_time, finish_time`
+	// stageColumns 不包含 log, 只有 GetStage 返回日志
+	stageColumns = `id, run_id, stage, name, type, status, attempt, ref, operator, message,
+	owner, lease_time, start_time, finish_time, update_time`
+)
+
+func (r *runDao) Get(ctx context.Context, id int64) (*core.PipelineRun, error) {
+	out := new(core.PipelineRun)
+	err := r.db.GetContext(ctx, out, "SELECT "+runColumns+" FROM pipeline_runs WHERE id = ?", id)
+	return out, err
+}
+
+func (r *runDao) List(ctx context.Context, in map[string]interface{}) ([]*core.PipelineRun, error) {
+	where, args := runFilter(in)
+	query := "SELECT " + runColumns + " FROM pipeline_runs" + where + " ORDER BY id DESC"
+	if limit, ok := in["limit"]; ok {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, in["offset"])
+	}
+	out := []*core.PipelineRun{}
+	err := r.db.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+func (r *runDao) Count(ctx context.Context, in map[string]interface{}) (int64, error) {
+	where, args := runFilter(in)
+	var count int64
+	err := r.db.GetContext(ctx, &count, "SELECT COUNT(*) FROM pipeline_runs"+where, args...)
+	return count, err
+}
+
+func (r *runDao) ListActive(ctx context.Context) ([]*core.PipelineRun, error) {
+	out := []*core.PipelineRun{}
+	err := r.db.SelectContext(ctx, &out, "SELECT "+runColumns+" FROM pipeline_runs WHERE status IN (?, ?) ORDER BY id",
+		core.PipelineRunning, core.PipelineWaiting)
+	return out, err
+}
+
+func (r *runDao) Create(ctx context.Context, in *core.PipelineRun, stages []*core.PipelineStageRun) (int64, error) {
+	now := time.Now()
+	in.CreateTime = now
+	in.UpdateTime = now
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.NamedExecContext(ctx, `INSERT INTO pipeline_runs
+	(pipeline_id, app_id, stages, parameters, status, current, message, creator, creator_id, create_time, update_time, finish_time)
+	VALUES
+	(:pipeline_id, :app_id, :stages, :parameters, :status, :current, :message, :creator, :creator_id, :create_time, :update_time, :finish_time)`, in)
+	if err != nil {
+		return 0, err
+	}
+	if in.ID, err = result.LastInsertId(); err != nil {
+		return 0, err
+	}
+	for _, stage := range stages {
+		stage.RunID = in.ID
+		stage.UpdateTime = now
+		result, err := tx.NamedExecContext(ctx, `INSERT INTO pipeline_stage_runs
+		(run_id, stage, name, type, status, attempt, ref, operator, message, log, owner, lease_time,
+		start_time, finish_time, update_time)
+		VALUES
+		(:run_id, :stage, :name, :type, :status, :attempt, :ref, :operator, :message, :log, :owner, :lease_time,
+		:start_time, :finish_time, :update_time)`, stage)
+		if err != nil {
+			return 0, err
+		}
+		if stage.ID, err = result.LastInsertId(); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	r.publish(&core.ResourceChange{Resource: core.ResourcePipelineRun, ID: in.ID, Op: core.ChangeCreated, Data: in})
+	return in.ID, nil
+}
+
+func (r *runDao) Update(ctx context.Context, in *core.PipelineRun) error {
+	in.UpdateTime = time.Now()
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	previous := new(core.PipelineRun)
+	err = tx.GetContext(ctx, previous, "SELECT "+runColumns+" FROM pipeline_runs WHERE id = ? FOR UPDATE", in.ID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.NamedExecContext(ctx, `UPDATE pipeline_runs SET
+	status = :status, current = :current, message = :message, update_time = :update_time, finish_time = :finish_time
+	WHERE id = :id`, in)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if previous.Status != in.Status {
+		r.publish(&core.ResourceChange{Resource: core.ResourcePipelineRun, ID: in.ID, Op: core.ChangeStatus,
+			Data: in, Previous: previous})
+	}
+	return nil
+}
+
+func (r *runDao) ListStages(ctx context.Context, runID int64) ([]*core.PipelineStageRun, error) {
+	out := []*core.PipelineStageRun{}
+	err := r.db.SelectContext(ctx, &out, "SELECT "+stageColumns+" FROM pipeline_stage_runs WHERE run_id = ? ORDER BY stage", runID)
+	return out, err
+}
+
+func (r *runDao) GetStage(ctx context.Context, runID int64, stage int) (*core.PipelineStageRun, error) {
+	out := new(core.PipelineStageRun)
+	err := r.db.GetContext(ctx, out, "SELECT "+stageColumns+", log FROM pipeline_stage_runs WHERE run_id = ? AND stage = ?",
+		runID, stage)
+	return out, err
+}
+
+// updateStage 更新阶段除日志以外的字段, TransitionStage 在此基础上附加条件
+const updateStage = `UPDATE pipeline_stage_runs SET
+	status = :status, attempt = :attempt, ref = :ref, operator = :operator, message = :message,
+	owner = :owner, lease_time = :lease_time, start_time = :start_time, finish_time = :finish_time,
+	update_time = :update_time
+	WHERE id = :id`
+
+func (r *runDao) UpdateStage(ctx context.Context, in *core.PipelineStageRun) error {
+	in.UpdateTime = time.Now()
+	_, err := r.db.NamedExecContext(ctx, updateStage, in)
+	return err
+}
+
+func (r *runDao) ClaimStage(ctx context.Context, id int64, status, owner string, now, until time.Time) (bool, error) {
+	result, err := r.db.ExecContext(ctx, `UPDATE pipeline_stage_runs SET owner = ?, lease_time = ?, update_time = ?
+	WHERE id = ? AND status = ? AND (owner = ? OR lease_time IS NULL OR lease_time < ?)`,
+		owner, until, now, id, status, owner, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (r *runDao) TransitionStage(ctx context.Context, in *core.PipelineStageRun, from string) (bool, error) {
+	in.UpdateTime = time.Now()
+	result, err := r.db.NamedExecContext(ctx, updateStage+" AND status = :from AND owner = :owner", struct {
+		*core.PipelineStageRun
+		From string `db:"from"`
+	}{in, from})
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (r *runDao) AppendLog(ctx context.Context, id int64, data string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE pipeline_stage_runs SET log = CONCAT(log, ?), update_time = ? WHERE id = ?",
+		data, time.Now(), id)
+	return err
+}
+
+// publish 发布一份运行记录的副本, 订阅者异步处理事件, 避免与调用方后续的修改竞争
+func (r *runDao) publish(change *core.ResourceChange) {
+	if data, ok := change.Data.(*core.PipelineRun); ok {
+		copied := *data
+		change.Data = &copied
+	}
+	r.bus.Publish(change.Topic(), change)
+}
+
+func runFilter(in map[string]interface{}) (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	for _, key := range []string{"pipeline_id", "app_id", "status", "creator"} {
+		if v, ok := in[key]; ok && v != "" && v != int64(0) {
+			conds = append(conds, key+" = ?")
+			args = append(args, v)
+		}
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
@@ -0,0 +1,69 @@
+package rbac
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideGrantDao(db *sqlx.DB) core.GrantDao {
+	return &grantDao{db: db}
+}
+
+type grantDao struct {
+	db *sqlx.DB
+}
+
+var _ core.GrantDao = &grantDao{}
+
+const grantColumns = "id, user_id, resource, resource_id, action, creator, create_time"
+
+func (grant *grantDao) List(ctx context.Context, in map[string]interface{}) ([]*core.Grant, error) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	for _, key := range []string{"user_id", "resource", "resource_id", "action"} {
+		if v, ok := in[key]; ok && v != "" && v != int64(0) {
+			conds = append(conds, key+" = ?")
+			args = append(args, v)
+		}
+	}
+	query := "SELECT " + grantColumns + " FROM grants"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	out := []*core.Grant{}
+	err := grant.db.SelectContext(ctx, &out, query+" ORDER BY id", args...)
+	return out, err
+}
+
+func (grant *grantDao) Create(ctx context.Context, in *core.Grant) (int64, error) {
+	in.CreateTime = time.Now()
+	result, err := grant.db.NamedExecContext(ctx, `INSERT INTO grants
+	(user_id, resource, resource_id, action, creator, create_time)
+	VALUES
+	(:user_id, :resource, :resource_id, :action, :creator, :create_time)`, in)
+	if err != nil {
+		return 0, err
+	}
+	in.ID, err = result.LastInsertId()
+	return in.ID, err
+}
+
+func (grant *grantDao) Delete(ctx context.Context, in int64) error {
+	_, err := grant.db.ExecContext(ctx, "DELETE FROM grants WHERE id = ?", in)
+	return err
+}
+
+func (grant *grantDao) Exists(ctx context.Context, userID int64, resource, resourceID, action string) (bool, error) {
+	var count int64
+	err := grant.db.GetContext(ctx, &count,
+		"SELECT COUNT(*) FROM grants WHERE user_id = ? AND resource = ? AND resource_id IN (?, ?) AND action = ?",
+		userID, resource, resourceID, core.GrantAll, action,
+	)
+	return count > 0, err
+}
@@ -0,0 +1,186 @@
+package task
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/eventbus"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideTaskDao(db *sqlx.DB, bus *eventbus.Bus) core.TaskDao {
+	return &taskDao{db: db, bus: bus}
+}
+
+type taskDao struct {
+	db  *sqlx.DB
+	bus *eventbus.Bus
+}
+
+var _ core.TaskDao = &taskDao{}
+
+const (
+	// taskColumns 列表中不返回参数和结果, 单个任务才返回
+	taskColumns = `id, task_type, priority, status, attempts, max_attempts, progress, message, error, worker, creator,
+	visible_time, start_time, finish_time, create_time, update_time`
+	taskDetail = taskColumns + ", payload, result"
+)
+
+func (t *taskDao) Get(ctx context.Context, id int64) (*core.Task, error) {
+	out := new(core.Task)
+	err := t.db.GetContext(ctx, out, "SELECT "+taskDetail+" FROM tasks WHERE id = ?", id)
+	return out, err
+}
+
+func (t *taskDao) List(ctx context.Context, in map[string]interface{}) ([]*core.Task, error) {
+	where, args := taskFilter(in)
+	query := "SELECT " + taskColumns + " FROM tasks" + where + " ORDER BY id DESC"
+	if limit, ok := in["limit"]; ok {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, in["offset"])
+	}
+	out := []*core.Task{}
+	err := t.db.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+func (t *taskDao) Count(ctx context.Context, in map[string]interface{}) (int64, error) {
+	where, args := taskFilter(in)
+	var count int64
+	err := t.db.GetContext(ctx, &count, "SELECT COUNT(*) FROM tasks"+where, args...)
+	return count, err
+}
+
+func (t *taskDao) ListClaimable(ctx context.Context, now time.Time, limit int) ([]*core.Task, error) {
+	out := []*core.Task{}
+	err := t.db.SelectContext(ctx, &out, "SELECT "+taskColumns+
+		" FROM tasks WHERE status IN (?, ?) AND visible_time <= ? ORDER BY priority DESC, id LIMIT ?",
+		core.TaskPending, core.TaskRunning, now, limit)
+	return out, err
+}
+
+func (t *taskDao) Create(ctx context.Context, in *core.Task) (int64, error) {
+	now := time.Now()
+	in.CreateTime = now
+	in.UpdateTime = now
+	result, err := t.db.NamedExecContext(ctx, `INSERT INTO tasks
+	(task_type, payload, priority, status, attempts, max_attempts, progress, message, result, error, worker, creator,
+	visible_time, start_time, finish_time, create_time, update_time)
+	VALUES
+	(:task_type, :payload, :priority, :status, :attempts, :max_attempts, :progress, :message, :result, :error, :worker, :creator,
+	:visible_time, :start_time, :finish_time, :create_time, :update_time)`, in)
+	if err != nil {
+		return 0, err
+	}
+	if in.ID, err = result.LastInsertId(); err != nil {
+		return 0, err
+	}
+	t.publish(&core.ResourceChange{Resource: core.ResourceTask, ID: in.ID, Op: core.ChangeCreated, Data: in})
+	return in.ID, nil
+}
+
+func (t *taskDao) Claim(ctx context.Context, id int64, worker string, now, until time.Time) (bool, error) {
+	tx, err := t.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	previous := new(core.Task)
+	err = tx.GetContext(ctx, previous, "SELECT "+taskColumns+" FROM tasks WHERE id = ? FOR UPDATE", id)
+	if err != nil {
+		return false, err
+	}
+	result, err := tx.ExecContext(ctx, `UPDATE tasks SET status = ?, worker = ?, attempts = attempts + 1,
+	visible_time = ?, start_time = COALESCE(start_time, ?), update_time = ?
+	WHERE id = ? AND status IN (?, ?) AND visible_time <= ?`,
+		core.TaskRunning, worker, until, now, now, id, core.TaskPending, core.TaskRunning, now)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	current := *previous
+	current.Status, current.Worker, current.Attempts = core.TaskRunning, worker, previous.Attempts+1
+	current.VisibleTime, current.UpdateTime = until, now
+	if current.StartTime == nil {
+		current.StartTime = &now
+	}
+	// 执行超时后被其他副本重新领取时状态仍然是 running, 只发布 updated
+	op := core.ChangeStatus
+	if previous.Status == core.TaskRunning {
+		op = core.ChangeUpdated
+	}
+	t.publish(&core.ResourceChange{Resource: core.ResourceTask, ID: id, Op: op, Data: &current, Previous: previous})
+	return true, nil
+}
+
+func (t *taskDao) Touch(ctx context.Context, in *core.Task) (bool, error) {
+	in.UpdateTime = time.Now()
+	result, err := t.db.ExecContext(ctx, `UPDATE tasks SET progress = ?, message = ?, visible_time = ?, update_time = ?
+	WHERE id = ? AND worker = ? AND status = ?`,
+		in.Progress, in.Message, in.VisibleTime, in.UpdateTime, in.ID, in.Worker, core.TaskRunning)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (t *taskDao) Finish(ctx context.Context, in *core.Task) (bool, error) {
+	in.UpdateTime = time.Now()
+	result, err := t.db.ExecContext(ctx, `UPDATE tasks SET status = ?, attempts = ?, progress = ?, message = ?, result = ?,
+	error = ?, visible_time = ?, finish_time = ?, update_time = ?
+	WHERE id = ? AND worker = ? AND status = ?`,
+		in.Status, in.Attempts, in.Progress, in.Message, in.Result, in.Error, in.VisibleTime, in.FinishTime, in.UpdateTime,
+		in.ID, in.Worker, core.TaskRunning)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err == nil && n > 0 {
+		t.publish(&core.ResourceChange{Resource: core.ResourceTask, ID: in.ID, Op: core.ChangeStatus, Data: in})
+	}
+	return n > 0, err
+}
+
+func (t *taskDao) Prune(ctx context.Context, before time.Time) (int64, error) {
+	result, err := t.db.ExecContext(ctx, "DELETE FROM tasks WHERE status IN (?, ?) AND finish_time < ?",
+		core.TaskSucceeded, core.TaskFailed, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// publish 发布一份任务的副本, 不包含参数和结果, 订阅者异步处理事件, 避免与调用方后续的修改竞争
+func (t *taskDao) publish(change *core.ResourceChange) {
+	if data, ok := change.Data.(*core.Task); ok {
+		copied := *data
+		copied.Payload, copied.Result = nil, nil
+		change.Data = &copied
+	}
+	t.bus.Publish(change.Topic(), change)
+}
+
+func taskFilter(in map[string]interface{}) (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	for _, key := range []string{"task_type", "status", "creator"} {
+		if v, ok := in[key]; ok && v != "" {
+			conds = append(conds, key+" = ?")
+			args = append(args, v)
+		}
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
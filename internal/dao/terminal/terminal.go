@@ -0,0 +1,79 @@
+package terminal
+
+import (
+	"context"
+	"strings"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/jmoiron/sqlx"
+)
+
+func ProvideTerminalSessionDao(db *sqlx.DB) core.TerminalSessionDao {
+	return &sessionDao{db: db}
+}
+
+type sessionDao struct {
+	db *sqlx.DB
+}
+
+var _ core.TerminalSessionDao = &sessionDao{}
+
+const sessionColumns = `id, host_id, agent_id, user_id, user_name, cols, rows, status, close_reason,
+	exit_code, recording, start_time, end_time`
+
+func (session *sessionDao) Get(ctx context.Context, in string) (*core.TerminalSession, error) {
+	out := new(core.TerminalSession)
+	err := session.db.GetContext(ctx, out, "SELECT "+sessionColumns+" FROM terminal_sessions WHERE id = ?", in)
+	return out, err
+}
+
+func (session *sessionDao) List(ctx context.Context, in map[string]interface{}) ([]*core.TerminalSession, error) {
+	where, args := sessionFilter(in)
+	query := "SELECT " + sessionColumns + " FROM terminal_sessions" + where + " ORDER BY start_time DESC"
+	if limit, ok := in["limit"]; ok {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, in["offset"])
+	}
+	out := []*core.TerminalSession{}
+	err := session.db.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+func (session *sessionDao) Count(ctx context.Context, in map[string]interface{}) (int64, error) {
+	where, args := sessionFilter(in)
+	var count int64
+	err := session.db.GetContext(ctx, &count, "SELECT COUNT(*) FROM terminal_sessions"+where, args...)
+	return count, err
+}
+
+func (session *sessionDao) Create(ctx context.Context, in *core.TerminalSession) error {
+	_, err := session.db.NamedExecContext(ctx, `INSERT INTO terminal_sessions
+	(id, host_id, agent_id, user_id, user_name, cols, rows, status, close_reason, exit_code, recording, start_time, end_time)
+	VALUES
+	(:id, :host_id, :agent_id, :user_id, :user_name, :cols, :rows, :status, :close_reason, :exit_code, :recording, :start_time, :end_time)`, in)
+	return err
+}
+
+func (session *sessionDao) Update(ctx context.Context, in *core.TerminalSession) error {
+	_, err := session.db.NamedExecContext(ctx, `UPDATE terminal_sessions SET
+	status = :status, close_reason = :close_reason, exit_code = :exit_code, end_time = :end_time
+	WHERE id = :id`, in)
+	return err
+}
+
+func sessionFilter(in map[string]interface{}) (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	for _, key := range []string{"host_id", "user_id", "status"} {
+		if v, ok := in[key]; ok && v != "" && v != int64(0) {
+			conds = append(conds, key+" = ?")
+			args = append(args, v)
+		}
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
@@ -0,0 +1,50 @@
+package acl
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	"github.com/bloodsteel/easynetes/internal/utils"
+)
+
+// AuthorizeUser 要求请求必须由已登录的用户发起
+func AuthorizeUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if _, ok := middleware.GetUserFromCtx(request.Context()); !ok {
+			utils.RenderFail(writer, request, utils.SCodeUnauthenticateWithToken)
+			return
+		}
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// AuthorizeAdmin 要求请求必须由管理员发起
+func AuthorizeAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		user, ok := middleware.GetUserFromCtx(request.Context())
+		switch {
+		case !ok:
+			utils.RenderFail(writer, request, utils.SCodeUnauthenticateWithToken)
+		case !user.IsAdmin:
+			utils.RenderFail(writer, request, utils.SCodeForbidden)
+		default:
+			next.ServeHTTP(writer, request)
+		}
+	})
+}
+
+// AuthorizeAgent 返回一个中间件, 要求请求必须携带 agent 令牌
+func AuthorizeAgent(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			got, _ := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+			if token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				utils.RenderFail(writer, request, utils.SCodeUnauthenticateWithAgentToken)
+				return
+			}
+			next.ServeHTTP(writer, request)
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	"github.com/bloodsteel/easynetes/internal/service/agenthub"
+	"github.com/bloodsteel/easynetes/internal/utils"
+	"github.com/bloodsteel/easynetes/pkg/log"
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+}
+
+// ListAgents 返回 agent 版本清单, 支持按 status/version/os/arch 过滤
+func ListAgents(agentDao core.AgentDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		limit, offset, _ := middleware.GetPaginateFromCtx(ctx)
+		query := request.URL.Query()
+		params := map[string]interface{}{
+			"status":  query.Get("status"),
+			"version": query.Get("version"),
+			"os":      query.Get("os"),
+			"arch":    query.Get("arch"),
+		}
+		count, err := agentDao.Count(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		params["limit"] = limit
+		params["offset"] = offset
+		agents, err := agentDao.List(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		writer.Header().Set("Count", strconv.FormatInt(count, 10))
+		utils.RenderSuccess(writer, request, agents)
+	}
+}
+
+// GetAgent 返回单个 agent
+func GetAgent(agentDao core.AgentDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		agentID, err := strconv.ParseInt(chi.URLParam(request, "agentID"), 10, 64)
+		if err != nil {
+			utils.RenderFail(writer, request, utils.SCodeBadRequestWithPathParamErr)
+			return
+		}
+		agent, err := agentDao.Get(request.Context(), agentID)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, agent)
+	}
+}
+
+// HandleStream 将 agent 的请求升级为 websocket 连接, 交给 hub 处理
+func HandleStream(hub *agenthub.Hub) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ws, err := upgrader.Upgrade(writer, request, nil)
+		if err != nil {
+			// Upgrade 失败时已经向客户端返回了错误
+			log.WithLabels("error", err).Warn("cannot upgrade agent stream")
+			return
+		}
+		if err := hub.Serve(request.Context(), ws); err != nil {
+			log.WithLabels("remote_addr", request.RemoteAddr, "error", err).Debug("agent stream closed")
+		}
+	}
+}
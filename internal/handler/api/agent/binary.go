@@ -0,0 +1,159 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	"github.com/bloodsteel/easynetes/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxBinarySize 上传的 agent 二进制文件大小上限
+const maxBinarySize = 256 << 20
+
+// 版本号和平台只允许出现在文件名中的安全字符
+var safeName = regexp.MustCompile(`^[a-zA-Z0-9][-._a-zA-Z0-9]*$`)
+
+// ListBinaries 返回所有已经上传的 agent 二进制文件
+func ListBinaries(binaryDao core.AgentBinaryDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		binaries, err := binaryDao.List(request.Context())
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, binaries)
+	}
+}
+
+// UploadBinary 上传一个 agent 二进制文件, multipart 表单字段: version, os, arch, remark, file
+func UploadBinary(binaryDao core.AgentBinaryDao, dir string) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		request.Body = http.MaxBytesReader(writer, request.Body, maxBinarySize)
+		file, _, err := request.FormFile("file")
+		if err != nil {
+			utils.RenderFail(writer, request, utils.SCodeBadRequestWithAgentBinary)
+			return
+		}
+		defer file.Close()
+
+		binary := &core.AgentBinary{
+			Version: request.FormValue("version"),
+			OS:      request.FormValue("os"),
+			Arch:    request.FormValue("arch"),
+			Remark:  request.FormValue("remark"),
+		}
+		if !safeName.MatchString(binary.Version) || !safeName.MatchString(binary.OS) || !safeName.MatchString(binary.Arch) {
+			utils.RenderFail(writer, request, utils.SCodeBadRequestWithAgentBinary)
+			return
+		}
+		if user, ok := middleware.GetUserFromCtx(ctx); ok {
+			binary.Uploader = user.UserName
+		}
+		binary.Path = filepath.Join(dir, binary.Version, fmt.Sprintf("easynetes-agent-%s-%s", binary.OS, binary.Arch))
+		binary.Size, binary.Checksum, err = saveBinary(file, binary.Path)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithStorage, err)
+			return
+		}
+		if _, err := binaryDao.Create(ctx, binary); err != nil {
+			_ = os.Remove(binary.Path)
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, binary)
+	}
+}
+
+// DownloadBinary 下载 agent 二进制文件, 供 agent 自升级使用
+func DownloadBinary(binaryDao core.AgentBinaryDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		binaryID, err := strconv.ParseInt(chi.URLParam(request, "binaryID"), 10, 64)
+		if err != nil {
+			utils.RenderFail(writer, request, utils.SCodeBadRequestWithPathParamErr)
+			return
+		}
+		binary, err := binaryDao.Get(request.Context(), binaryID)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		file, err := os.Open(binary.Path)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithStorage, err)
+			return
+		}
+		defer file.Close()
+		stat, err := file.Stat()
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithStorage, err)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/octet-stream")
+		writer.Header().Set("X-Checksum-Sha256", binary.Checksum)
+		http.ServeContent(writer, request, filepath.Base(binary.Path), stat.ModTime(), file)
+	}
+}
+
+// DeleteBinary 删除一个 agent 二进制文件
+func DeleteBinary(binaryDao core.AgentBinaryDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		binaryID, err := strconv.ParseInt(chi.URLParam(request, "binaryID"), 10, 64)
+		if err != nil {
+			utils.RenderFail(writer, request, utils.SCodeBadRequestWithPathParamErr)
+			return
+		}
+		binary, err := binaryDao.Get(ctx, binaryID)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		if err := binaryDao.Delete(ctx, binaryID); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		if err := os.Remove(binary.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithStorage, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, nil)
+	}
+}
+
+// saveBinary 将上传的文件写入 path, 返回文件大小和 sha256
+func saveBinary(src io.Reader, path string) (int64, string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return 0, "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return 0, "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), src)
+	if err != nil {
+		return 0, "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	"github.com/bloodsteel/easynetes/internal/service/rollout"
+	"github.com/bloodsteel/easynetes/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+type rolloutDetail struct {
+	*core.AgentRollout
+	Hosts []*core.AgentRolloutHost `json:"hosts"`
+}
+
+// ListRollouts 返回所有灰度升级任务
+func ListRollouts(rolloutDao core.AgentRolloutDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		rollouts, err := rolloutDao.List(request.Context(), map[string]interface{}{
+			"status": request.URL.Query().Get("status"),
+		})
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, rollouts)
+	}
+}
+
+// CreateRollout 创建一个灰度升级任务
+// 请求体: {"version": "v1.2.0", "stages": [5, 50, 100], "health_timeout": 300, "soak_time": 300}
+func CreateRollout(orchestrator *rollout.Orchestrator) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		in := new(core.AgentRollout)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		if user, ok := middleware.GetUserFromCtx(ctx); ok {
+			in.Creator = user.UserName
+		}
+		out, err := orchestrator.Create(ctx, in)
+		if err != nil {
+			renderRolloutError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, out)
+	}
+}
+
+// GetRollout 返回单个灰度升级任务以及其中所有主机的状态
+func GetRollout(rolloutDao core.AgentRolloutDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		rolloutID, err := strconv.ParseInt(chi.URLParam(request, "rolloutID"), 10, 64)
+		if err != nil {
+			utils.RenderFail(writer, request, utils.SCodeBadRequestWithPathParamErr)
+			return
+		}
+		out, err := rolloutDao.Get(ctx, rolloutID)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		hosts, err := rolloutDao.ListHosts(ctx, rolloutID)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, &rolloutDetail{AgentRollout: out, Hosts: hosts})
+	}
+}
+
+// HandleRolloutAction 对灰度升级任务执行 pause/resume/rollback/abort 操作
+func HandleRolloutAction(orchestrator *rollout.Orchestrator) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		rolloutID, err := strconv.ParseInt(chi.URLParam(request, "rolloutID"), 10, 64)
+		if err != nil {
+			utils.RenderFail(writer, request, utils.SCodeBadRequestWithPathParamErr)
+			return
+		}
+		var out *core.AgentRollout
+		switch chi.URLParam(request, "action") {
+		case "pause":
+			out, err = orchestrator.Pause(ctx, rolloutID)
+		case "resume":
+			out, err = orchestrator.Resume(ctx, rolloutID)
+		case "rollback":
+			out, err = orchestrator.Rollback(ctx, rolloutID)
+		case "abort":
+			out, err = orchestrator.Abort(ctx, rolloutID)
+		default:
+			utils.RenderFail(writer, request, utils.SCodeBadRequestWithPathParamErr)
+			return
+		}
+		if err != nil {
+			renderRolloutError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, out)
+	}
+}
+
+func renderRolloutError(writer http.ResponseWriter, request *http.Request, err error) {
+	switch {
+	case errors.Is(err, core.ErrInvalidRolloutStages):
+		utils.RenderFail(writer, request, utils.SCodeBadRequestWithRolloutStages)
+	case errors.Is(err, rollout.ErrRolloutInProgress), errors.Is(err, rollout.ErrInvalidTransition):
+		utils.RenderFail(writer, request, utils.SCodeBadRequestWithRolloutConflict)
+	case errors.Is(err, rollout.ErrNoUpgradableAgent):
+		utils.RenderFail(writer, request, utils.SCodeBadRequestWithNoUpgradableAgent)
+	default:
+		utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+	}
+}
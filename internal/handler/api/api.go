@@ -4,9 +4,43 @@ import (
 	"net/http"
 
 	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/handler/api/acl"
+	"github.com/bloodsteel/easynetes/internal/handler/api/agent"
+	"github.com/bloodsteel/easynetes/internal/handler/api/app"
+	"github.com/bloodsteel/easynetes/internal/handler/api/approval"
+	"github.com/bloodsteel/easynetes/internal/handler/api/auth"
+	"github.com/bloodsteel/easynetes/internal/handler/api/cmdbhook"
+	"github.com/bloodsteel/easynetes/internal/handler/api/cron"
+	"github.com/bloodsteel/easynetes/internal/handler/api/events"
+	"github.com/bloodsteel/easynetes/internal/handler/api/gitlab"
+	"github.com/bloodsteel/easynetes/internal/handler/api/hook"
 	"github.com/bloodsteel/easynetes/internal/handler/api/host"
+	"github.com/bloodsteel/easynetes/internal/handler/api/jenkins"
+	"github.com/bloodsteel/easynetes/internal/handler/api/k8s"
+	"github.com/bloodsteel/easynetes/internal/handler/api/notify"
+	"github.com/bloodsteel/easynetes/internal/handler/api/pipeline"
+	"github.com/bloodsteel/easynetes/internal/handler/api/rbac"
+	"github.com/bloodsteel/easynetes/internal/handler/api/task"
+	"github.com/bloodsteel/easynetes/internal/handler/api/terminal"
 	"github.com/bloodsteel/easynetes/internal/handler/api/user"
 	"github.com/bloodsteel/easynetes/internal/middleware"
+	"github.com/bloodsteel/easynetes/internal/service/agenthub"
+	approvalsvc "github.com/bloodsteel/easynetes/internal/service/approval"
+	cmdbhooksvc "github.com/bloodsteel/easynetes/internal/service/cmdbhook"
+	cronsvc "github.com/bloodsteel/easynetes/internal/service/cron"
+	gitlabsvc "github.com/bloodsteel/easynetes/internal/service/gitlab"
+	hooksvc "github.com/bloodsteel/easynetes/internal/service/hook"
+	jenkinssvc "github.com/bloodsteel/easynetes/internal/service/jenkins"
+	"github.com/bloodsteel/easynetes/internal/service/kube"
+	"github.com/bloodsteel/easynetes/internal/service/metrics"
+	notifysvc "github.com/bloodsteel/easynetes/internal/service/notify"
+	pipelinesvc "github.com/bloodsteel/easynetes/internal/service/pipeline"
+	"github.com/bloodsteel/easynetes/internal/service/queue"
+	"github.com/bloodsteel/easynetes/internal/service/release"
+	"github.com/bloodsteel/easynetes/internal/service/rollout"
+	"github.com/bloodsteel/easynetes/internal/service/stream"
+	terminalsvc "github.com/bloodsteel/easynetes/internal/service/terminal"
+	"github.com/bloodsteel/easynetes/pkg/config"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -16,17 +50,182 @@ import (
 func ProvideAPI(
 	userDao core.UserDao,
 	hostDao core.HostInstanceDao,
+	agentDao core.AgentDao,
+	binaryDao core.AgentBinaryDao,
+	rolloutDao core.AgentRolloutDao,
+	hub *agenthub.Hub,
+	orchestrator *rollout.Orchestrator,
+	grantDao core.GrantDao,
+	authorizer core.Authorizer,
+	sessionDao core.TerminalSessionDao,
+	terminals *terminalsvc.Manager,
+	metrics *metrics.Service,
+	clusterDao core.KubeClusterDao,
+	registry *kube.Registry,
+	namespaces *kube.Namespaces,
+	templateDao core.KubeQuotaTemplateDao,
+	workloads *kube.Workloads,
+	actionDao core.KubeWorkloadActionDao,
+	pods *kube.Pods,
+	applier *kube.Applier,
+	eventDao core.KubeEventDao,
+	access *kube.Access,
+	accessDao core.KubeAccessGrantDao,
+	appDao core.ApplicationDao,
+	envDao core.AppEnvironmentDao,
+	releaseDao core.AppReleaseDao,
+	releases *release.Service,
+	connectionDao core.GitlabConnectionDao,
+	projectDao core.GitlabProjectDao,
+	gitlab *gitlabsvc.Service,
+	jenkinsServerDao core.JenkinsServerDao,
+	jenkinsJobDao core.JenkinsJobDao,
+	jenkinsBuildDao core.JenkinsBuildDao,
+	jenkins *jenkinssvc.Service,
+	hookEventDao core.HookEventDao,
+	receiver *hooksvc.Receiver,
+	pipelineDao core.PipelineDao,
+	pipelineRunDao core.PipelineRunDao,
+	pipelines *pipelinesvc.Service,
+	approvalRuleDao core.ApprovalRuleDao,
+	approvalRequestDao core.ApprovalRequestDao,
+	approvals *approvalsvc.Service,
+	notifyChannelDao core.NotifyChannelDao,
+	notifySubscriptionDao core.NotifySubscriptionDao,
+	notifyTemplateDao core.NotifyTemplateDao,
+	notifyDeliveryDao core.NotifyDeliveryDao,
+	notifier *notifysvc.Service,
+	cmdbHookDao core.CMDBHookDao,
+	cmdbHookDeliveryDao core.CMDBHookDeliveryDao,
+	cmdbHooks *cmdbhooksvc.Service,
+	broker *stream.Broker,
+	cronJobDao core.CronJobDao,
+	cronRunDao core.CronRunDao,
+	cronJobs *cronsvc.Service,
+	taskDao core.TaskDao,
+	tasks *queue.Queue,
+	cfg *config.Config,
 ) *Server {
 	return &Server{
-		userDao: userDao,
-		hostDao: hostDao,
+		userDao:               userDao,
+		hostDao:               hostDao,
+		agentDao:              agentDao,
+		binaryDao:             binaryDao,
+		rolloutDao:            rolloutDao,
+		hub:                   hub,
+		orchestrator:          orchestrator,
+		grantDao:              grantDao,
+		authorizer:            authorizer,
+		sessionDao:            sessionDao,
+		terminals:             terminals,
+		metrics:               metrics,
+		clusterDao:            clusterDao,
+		registry:              registry,
+		namespaces:            namespaces,
+		templateDao:           templateDao,
+		workloads:             workloads,
+		actionDao:             actionDao,
+		pods:                  pods,
+		applier:               applier,
+		eventDao:              eventDao,
+		access:                access,
+		accessDao:             accessDao,
+		appDao:                appDao,
+		envDao:                envDao,
+		releaseDao:            releaseDao,
+		releases:              releases,
+		connectionDao:         connectionDao,
+		projectDao:            projectDao,
+		gitlab:                gitlab,
+		jenkinsServerDao:      jenkinsServerDao,
+		jenkinsJobDao:         jenkinsJobDao,
+		jenkinsBuildDao:       jenkinsBuildDao,
+		jenkins:               jenkins,
+		hookEventDao:          hookEventDao,
+		receiver:              receiver,
+		pipelineDao:           pipelineDao,
+		pipelineRunDao:        pipelineRunDao,
+		pipelines:             pipelines,
+		approvalRuleDao:       approvalRuleDao,
+		approvalRequestDao:    approvalRequestDao,
+		approvals:             approvals,
+		notifyChannelDao:      notifyChannelDao,
+		notifySubscriptionDao: notifySubscriptionDao,
+		notifyTemplateDao:     notifyTemplateDao,
+		notifyDeliveryDao:     notifyDeliveryDao,
+		notifier:              notifier,
+		cmdbHookDao:           cmdbHookDao,
+		cmdbHookDeliveryDao:   cmdbHookDeliveryDao,
+		cmdbHooks:             cmdbHooks,
+		broker:                broker,
+		cronJobDao:            cronJobDao,
+		cronRunDao:            cronRunDao,
+		cronJobs:              cronJobs,
+		taskDao:               taskDao,
+		tasks:                 tasks,
+		cfg:                   cfg,
 	}
 }
 
 // Server payload
 type Server struct {
-	userDao core.UserDao
-	hostDao core.HostInstanceDao
+	userDao               core.UserDao
+	hostDao               core.HostInstanceDao
+	agentDao              core.AgentDao
+	binaryDao             core.AgentBinaryDao
+	rolloutDao            core.AgentRolloutDao
+	hub                   *agenthub.Hub
+	orchestrator          *rollout.Orchestrator
+	grantDao              core.GrantDao
+	authorizer            core.Authorizer
+	sessionDao            core.TerminalSessionDao
+	terminals             *terminalsvc.Manager
+	metrics               *metrics.Service
+	clusterDao            core.KubeClusterDao
+	registry              *kube.Registry
+	namespaces            *kube.Namespaces
+	templateDao           core.KubeQuotaTemplateDao
+	workloads             *kube.Workloads
+	actionDao             core.KubeWorkloadActionDao
+	pods                  *kube.Pods
+	applier               *kube.Applier
+	eventDao              core.KubeEventDao
+	access                *kube.Access
+	accessDao             core.KubeAccessGrantDao
+	appDao                core.ApplicationDao
+	envDao                core.AppEnvironmentDao
+	releaseDao            core.AppReleaseDao
+	releases              *release.Service
+	connectionDao         core.GitlabConnectionDao
+	projectDao            core.GitlabProjectDao
+	gitlab                *gitlabsvc.Service
+	jenkinsServerDao      core.JenkinsServerDao
+	jenkinsJobDao         core.JenkinsJobDao
+	jenkinsBuildDao       core.JenkinsBuildDao
+	jenkins               *jenkinssvc.Service
+	hookEventDao          core.HookEventDao
+	receiver              *hooksvc.Receiver
+	pipelineDao           core.PipelineDao
+	pipelineRunDao        core.PipelineRunDao
+	pipelines             *pipelinesvc.Service
+	approvalRuleDao       core.ApprovalRuleDao
+	approvalRequestDao    core.ApprovalRequestDao
+	approvals             *approvalsvc.Service
+	notifyChannelDao      core.NotifyChannelDao
+	notifySubscriptionDao core.NotifySubscriptionDao
+	notifyTemplateDao     core.NotifyTemplateDao
+	notifyDeliveryDao     core.NotifyDeliveryDao
+	notifier              *notifysvc.Service
+	cmdbHookDao           core.CMDBHookDao
+	cmdbHookDeliveryDao   core.CMDBHookDeliveryDao
+	cmdbHooks             *cmdbhooksvc.Service
+	broker                *stream.Broker
+	cronJobDao            core.CronJobDao
+	cronRunDao            core.CronRunDao
+	cronJobs              *cronsvc.Service
+	taskDao               core.TaskDao
+	tasks                 *queue.Queue
+	cfg                   *config.Config
 }
 
 // Handler http router for api
@@ -34,6 +233,18 @@ func (s Server) Handler() http.Handler {
 	router := chi.NewRouter()
 	router.Use(middleware.Logger)
 
+	// agent 使用共享令牌而不是 JWT 认证, 注册在用户认证中间件之外
+	router.With(acl.AuthorizeAgent(s.cfg.Agent.Token)).Get("/agents/stream", agent.HandleStream(s.hub))
+	router.With(acl.AuthorizeAgent(s.cfg.Agent.Token)).Get("/agents/binaries/{binaryID}/download", agent.DownloadBinary(s.binaryDao))
+
+	router.Group(s.routes)
+	return router
+}
+
+// routes 注册用户通过 JWT 认证访问的路由
+func (s Server) routes(router chi.Router) {
+	router.Use(auth.HandleAuthentication(s.userDao, s.cfg.Security.SecretKey))
+
 	// 用户管理相关的APIs
 	router.Route("/users", func(r chi.Router) {
 		r.With(middleware.Paginate).Get("/", user.ListUsers(s.userDao))
@@ -53,16 +264,309 @@ func (s Server) Handler() http.Handler {
 		r.Route("/host", func(r chi.Router) {
 			r.With(middleware.Paginate).Get("/", host.ListHosts(s.hostDao))
 			r.Post("/", host.CreateHost(s.hostDao))
+			// 批量导入主机和分发文件在后台任务中执行
+			r.With(acl.AuthorizeAdmin).Post("/import", host.ImportHosts(s.tasks))
+			r.With(acl.AuthorizeAdmin).Post("/files", host.PushFile(s.tasks))
 
 			r.Route("/{hostID}", func(r chi.Router) {
 				r.Get("/", host.HandlerHost(s.hostDao))
 				r.Put("/", host.HandlerHost(s.hostDao))
-				r.Delete("/", host.HandlerHost(s.hostDao))
+				r.With(acl.AuthorizeAdmin).Delete("/", host.DeleteHost(s.hostDao, s.approvals))
+				// 通过 agent 打开主机的 web 终端
+				r.With(acl.AuthorizeUser).Get("/terminal", host.HandleTerminal(s.hostDao, s.agentDao, s.authorizer, s.terminals))
+				r.With(acl.AuthorizeUser).Get("/metrics", host.GetMetrics(s.hostDao, s.agentDao, s.metrics))
 			})
 		})
 		// 可用区数据路由
 		r.Route("/azone", func(r chi.Router) {})
+		// 外部系统订阅资产变更的 webhook, 只有管理员可以管理
+		r.Route("/webhooks", func(r chi.Router) {
+			r.Use(acl.AuthorizeAdmin)
+			r.Get("/", cmdbhook.ListHooks(s.cmdbHookDao))
+			r.Post("/", cmdbhook.CreateHook(s.cmdbHooks))
+			r.With(middleware.Paginate).Get("/deliveries", cmdbhook.ListDeliveries(s.cmdbHookDeliveryDao))
+			r.Get("/deliveries/{deliveryID}", cmdbhook.GetDelivery(s.cmdbHookDeliveryDao))
+			r.Post("/deliveries/{deliveryID}/redeliver", cmdbhook.Redeliver(s.cmdbHooks))
+			r.Get("/{hookID}", cmdbhook.GetHook(s.cmdbHookDao))
+			r.Put("/{hookID}", cmdbhook.UpdateHook(s.cmdbHooks))
+			r.Delete("/{hookID}", cmdbhook.DeleteHook(s.cmdbHookDao))
+		})
 	})
 
-	return router
+	// agent 相关的APIs
+	router.Route("/agents", func(r chi.Router) {
+		r.With(acl.AuthorizeUser, middleware.Paginate).Get("/", agent.ListAgents(s.agentDao))
+		r.With(acl.AuthorizeUser).Get("/{agentID}", agent.GetAgent(s.agentDao))
+
+		// agent 二进制文件路由
+		r.Route("/binaries", func(r chi.Router) {
+			r.With(acl.AuthorizeUser).Get("/", agent.ListBinaries(s.binaryDao))
+			r.With(acl.AuthorizeAdmin).Post("/", agent.UploadBinary(s.binaryDao, s.cfg.Agent.BinaryPath))
+			r.With(acl.AuthorizeAdmin).Delete("/{binaryID}", agent.DeleteBinary(s.binaryDao))
+		})
+
+		// 灰度升级任务路由
+		r.Route("/rollouts", func(r chi.Router) {
+			r.With(acl.AuthorizeUser).Get("/", agent.ListRollouts(s.rolloutDao))
+			r.With(acl.AuthorizeAdmin).Post("/", agent.CreateRollout(s.orchestrator))
+			r.With(acl.AuthorizeUser).Get("/{rolloutID}", agent.GetRollout(s.rolloutDao))
+			r.With(acl.AuthorizeAdmin).Post("/{rolloutID}/{action}", agent.HandleRolloutAction(s.orchestrator))
+		})
+	})
+
+	// web 终端会话和录像回放
+	router.Route("/terminal/sessions", func(r chi.Router) {
+		r.Use(acl.AuthorizeUser)
+		r.With(middleware.Paginate).Get("/", terminal.ListSessions(s.sessionDao))
+		r.Get("/{sessionID}", terminal.GetSession(s.sessionDao))
+		r.Get("/{sessionID}/recording", terminal.GetRecording(s.sessionDao))
+	})
+
+	// kubernetes 集群管理
+	router.Route("/k8s/clusters", func(r chi.Router) {
+		r.Use(acl.AuthorizeUser)
+		r.With(middleware.Paginate).Get("/", k8s.ListClusters(s.clusterDao))
+		r.With(acl.AuthorizeAdmin).Post("/", k8s.CreateCluster(s.registry))
+
+		r.Route("/{clusterID}", func(r chi.Router) {
+			r.Get("/", k8s.GetCluster(s.clusterDao))
+			r.With(acl.AuthorizeAdmin).Put("/", k8s.UpdateCluster(s.registry))
+			r.With(acl.AuthorizeAdmin).Delete("/", k8s.DeleteCluster(s.registry))
+			r.With(acl.AuthorizeAdmin).Post("/probe", k8s.ProbeCluster(s.clusterDao, s.registry))
+			r.With(acl.AuthorizeAdmin).Post("/sync-nodes", k8s.SyncNodes(s.clusterDao, s.tasks))
+
+			// namespace 管理
+			r.Route("/namespaces", func(r chi.Router) {
+				r.Get("/", k8s.ListNamespaces(s.namespaces))
+				r.With(acl.AuthorizeAdmin).Post("/", k8s.CreateNamespace(s.namespaces))
+				r.With(acl.AuthorizeAdmin).Put("/{namespace}", k8s.UpdateNamespace(s.namespaces))
+				r.With(acl.AuthorizeAdmin).Delete("/{namespace}", k8s.DeleteNamespace(s.namespaces, s.approvals))
+
+				// Pod 日志和终端, 需要 namespace 的 logs/exec 授权
+				r.Get("/{namespace}/pods/{pod}/logs", k8s.StreamPodLogs(s.pods, s.authorizer))
+				r.Get("/{namespace}/pods/{pod}/exec", k8s.ExecPod(s.pods, s.authorizer))
+
+				// 下载当前用户在 namespace 中的短期 kubeconfig
+				r.Get("/{namespace}/kubeconfig", k8s.DownloadKubeconfig(s.access))
+			})
+
+			// 工作负载浏览和操作, 操作需要 namespace 的 operate 授权
+			r.Route("/workloads/{kind}", func(r chi.Router) {
+				r.Get("/", k8s.ListWorkloads(s.workloads))
+				r.Route("/{namespace}/{name}", func(r chi.Router) {
+					r.Get("/", k8s.GetWorkload(s.workloads))
+					r.Post("/scale", k8s.ScaleWorkload(s.workloads, s.authorizer))
+					r.Post("/restart", k8s.RestartWorkload(s.workloads, s.authorizer))
+					r.Post("/rollback", k8s.RollbackWorkload(s.workloads, s.authorizer))
+				})
+			})
+			r.With(middleware.Paginate).Get("/workload-actions", k8s.ListWorkloadActions(s.actionDao))
+
+			// 将 namespace 的角色授予用户或者用户组, 同步为集群中的 RoleBinding
+			r.Route("/access-grants", func(r chi.Router) {
+				r.Use(acl.AuthorizeAdmin)
+				r.With(middleware.Paginate).Get("/", k8s.ListAccessGrants(s.accessDao))
+				r.Post("/", k8s.CreateAccessGrant(s.access))
+				r.Delete("/{grantID}", k8s.RevokeAccessGrant(s.access))
+			})
+
+			// YAML 变更计划, 先 dry-run 生成 diff, 确认后再执行
+			r.Post("/apply", k8s.PlanApply(s.applier))
+			r.Get("/apply/{planID}", k8s.GetApplyPlan(s.applier))
+			r.Post("/apply/{planID}", k8s.ConfirmApply(s.applier))
+		})
+	})
+
+	// namespace 配额模板
+	router.Route("/k8s/quota-templates", func(r chi.Router) {
+		r.Use(acl.AuthorizeUser)
+		r.Get("/", k8s.ListQuotaTemplates(s.templateDao))
+		r.With(acl.AuthorizeAdmin).Post("/", k8s.CreateQuotaTemplate(s.templateDao))
+		r.With(acl.AuthorizeAdmin).Put("/{templateID}", k8s.UpdateQuotaTemplate(s.templateDao))
+		r.With(acl.AuthorizeAdmin).Delete("/{templateID}", k8s.DeleteQuotaTemplate(s.templateDao))
+	})
+
+	// 集群容量报告, 支持导出 CSV
+	router.With(acl.AuthorizeUser).Get("/k8s/capacity", k8s.GetCapacity(s.clusterDao, s.workloads))
+
+	// 集群 Warning 事件历史
+	router.With(acl.AuthorizeUser, middleware.Paginate).Get("/k8s/events", k8s.ListEvents(s.eventDao))
+
+	// 应用定义、环境和发布, 发布和回滚需要环境所在 namespace 的 apply 授权
+	router.Route("/apps", func(r chi.Router) {
+		r.Use(acl.AuthorizeUser)
+		r.With(middleware.Paginate).Get("/", app.ListApplications(s.appDao))
+		r.With(acl.AuthorizeAdmin).Post("/", app.CreateApplication(s.appDao))
+
+		r.Route("/{appID}", func(r chi.Router) {
+			r.Get("/", app.GetApplication(s.appDao))
+			r.With(acl.AuthorizeAdmin).Put("/", app.UpdateApplication(s.appDao))
+			r.With(acl.AuthorizeAdmin).Delete("/", app.DeleteApplication(s.appDao, s.envDao))
+
+			r.Route("/envs", func(r chi.Router) {
+				r.Get("/", app.ListEnvironments(s.envDao))
+				r.With(acl.AuthorizeAdmin).Post("/", app.CreateEnvironment(s.appDao, s.envDao))
+				r.With(acl.AuthorizeAdmin).Put("/{envID}", app.UpdateEnvironment(s.envDao))
+				r.With(acl.AuthorizeAdmin).Delete("/{envID}", app.DeleteEnvironment(s.envDao))
+				r.Get("/{envID}/preview", app.PreviewRelease(s.releases))
+				r.Post("/{envID}/releases", app.DeployRelease(s.releases, s.approvals))
+			})
+
+			r.With(middleware.Paginate).Get("/releases", app.ListReleases(s.releaseDao))
+			r.Get("/releases/{releaseID}", app.GetRelease(s.releaseDao))
+			r.Post("/releases/{releaseID}/rollback", app.RollbackRelease(s.releases, s.approvals))
+		})
+	})
+
+	// GitLab 连接和导入的项目
+	router.Route("/gitlab", func(r chi.Router) {
+		r.Use(acl.AuthorizeUser)
+		r.Route("/connections", func(r chi.Router) {
+			r.Get("/", gitlab.ListConnections(s.connectionDao))
+			r.With(acl.AuthorizeAdmin).Post("/", gitlab.CreateConnection(s.gitlab))
+			r.With(acl.AuthorizeAdmin).Put("/{connectionID}", gitlab.UpdateConnection(s.gitlab))
+			r.With(acl.AuthorizeAdmin).Delete("/{connectionID}", gitlab.DeleteConnection(s.gitlab))
+			r.With(acl.AuthorizeAdmin).Post("/{connectionID}/test", gitlab.TestConnection(s.connectionDao, s.gitlab))
+			r.With(acl.AuthorizeAdmin).Get("/{connectionID}/remote-projects", gitlab.SearchRemoteProjects(s.gitlab))
+		})
+		r.Route("/projects", func(r chi.Router) {
+			r.With(middleware.Paginate).Get("/", gitlab.ListProjects(s.projectDao))
+			r.With(acl.AuthorizeAdmin).Post("/", gitlab.ImportProject(s.gitlab))
+			r.Get("/{projectID}", gitlab.GetProject(s.projectDao))
+			r.With(acl.AuthorizeAdmin).Put("/{projectID}", gitlab.LinkProject(s.gitlab))
+			r.With(acl.AuthorizeAdmin).Delete("/{projectID}", gitlab.DeleteProject(s.projectDao))
+			r.Get("/{projectID}/refs", gitlab.ListRefs(s.projectDao))
+			r.Post("/{projectID}/refs/sync", gitlab.SyncRefs(s.gitlab))
+		})
+	})
+
+	// Jenkins 服务器、关联的任务和构建记录
+	router.Route("/jenkins", func(r chi.Router) {
+		r.Use(acl.AuthorizeUser)
+		r.Route("/servers", func(r chi.Router) {
+			r.Get("/", jenkins.ListServers(s.jenkinsServerDao))
+			r.With(acl.AuthorizeAdmin).Post("/", jenkins.CreateServer(s.jenkins))
+			r.With(acl.AuthorizeAdmin).Put("/{serverID}", jenkins.UpdateServer(s.jenkins))
+			r.With(acl.AuthorizeAdmin).Delete("/{serverID}", jenkins.DeleteServer(s.jenkins))
+			r.With(acl.AuthorizeAdmin).Post("/{serverID}/test", jenkins.TestServer(s.jenkinsServerDao, s.jenkins))
+		})
+		r.Route("/jobs", func(r chi.Router) {
+			r.With(middleware.Paginate).Get("/", jenkins.ListJobs(s.jenkinsJobDao))
+			r.With(acl.AuthorizeAdmin).Post("/", jenkins.CreateJob(s.jenkins))
+			r.Get("/{jobID}", jenkins.GetJob(s.jenkinsJobDao))
+			r.With(acl.AuthorizeAdmin).Put("/{jobID}", jenkins.UpdateJob(s.jenkins))
+			r.With(acl.AuthorizeAdmin).Delete("/{jobID}", jenkins.DeleteJob(s.jenkinsJobDao))
+			r.Post("/{jobID}/builds", jenkins.TriggerBuild(s.jenkins))
+		})
+		r.With(middleware.Paginate).Get("/builds", jenkins.ListBuilds(s.jenkinsBuildDao))
+		r.Get("/builds/{buildID}", jenkins.GetBuild(s.jenkinsBuildDao))
+	})
+
+	// GitLab 和 Jenkins 推送的事件, 接收接口使用共享令牌认证
+	router.Route("/hooks", func(r chi.Router) {
+		r.Post("/gitlab", hook.ReceiveGitlab(s.receiver, s.cfg.Webhook.GitlabToken))
+		r.Post("/jenkins", hook.ReceiveJenkins(s.receiver, s.cfg.Webhook.JenkinsToken))
+		r.With(acl.AuthorizeAdmin, middleware.Paginate).Get("/events", hook.ListEvents(s.hookEventDao))
+		r.With(acl.AuthorizeAdmin).Get("/events/{eventID}", hook.GetEvent(s.hookEventDao))
+	})
+
+	// 流水线以及运行记录, 流水线的审批人由配置决定, 所以只有管理员可以修改流水线
+	router.Route("/pipelines", func(r chi.Router) {
+		r.Use(acl.AuthorizeUser)
+		r.With(middleware.Paginate).Get("/", pipeline.ListPipelines(s.pipelineDao))
+		r.With(acl.AuthorizeAdmin).Post("/", pipeline.CreatePipeline(s.pipelines))
+		r.Get("/{pipelineID}", pipeline.GetPipeline(s.pipelineDao))
+		r.With(acl.AuthorizeAdmin).Put("/{pipelineID}", pipeline.UpdatePipeline(s.pipelines))
+		r.With(acl.AuthorizeAdmin).Delete("/{pipelineID}", pipeline.DeletePipeline(s.pipelineDao))
+		r.Post("/{pipelineID}/runs", pipeline.StartRun(s.pipelines))
+		r.Route("/runs", func(r chi.Router) {
+			r.With(middleware.Paginate).Get("/", pipeline.ListRuns(s.pipelineRunDao))
+			r.Get("/{runID}", pipeline.GetRun(s.pipelineRunDao))
+			r.Get("/{runID}/stages/{stage}", pipeline.GetStage(s.pipelineRunDao))
+			r.Post("/{runID}/cancel", pipeline.CancelRun(s.pipelines))
+			r.Post("/{runID}/retry", pipeline.RetryRun(s.pipelines))
+			r.Post("/{runID}/approve", pipeline.ApproveRun(s.pipelines, true))
+			r.Post("/{runID}/reject", pipeline.ApproveRun(s.pipelines, false))
+		})
+	})
+
+	// 审批规则和审批请求, 删除主机、删除 namespace 和发布应用匹配到规则时需要审批
+	router.Route("/approvals", func(r chi.Router) {
+		r.Use(acl.AuthorizeUser)
+		r.Route("/rules", func(r chi.Router) {
+			r.Get("/", approval.ListRules(s.approvalRuleDao))
+			r.With(acl.AuthorizeAdmin).Post("/", approval.CreateRule(s.approvals))
+			r.Get("/{ruleID}", approval.GetRule(s.approvalRuleDao))
+			r.With(acl.AuthorizeAdmin).Put("/{ruleID}", approval.UpdateRule(s.approvals))
+			r.With(acl.AuthorizeAdmin).Delete("/{ruleID}", approval.DeleteRule(s.approvalRuleDao))
+		})
+		r.Route("/requests", func(r chi.Router) {
+			r.With(middleware.Paginate).Get("/", approval.ListRequests(s.approvalRequestDao))
+			r.Get("/{requestID}", approval.GetRequest(s.approvalRequestDao))
+			r.Post("/{requestID}/approve", approval.VoteRequest(s.approvals, true))
+			r.Post("/{requestID}/reject", approval.VoteRequest(s.approvals, false))
+			r.Post("/{requestID}/comments", approval.CommentRequest(s.approvals))
+			r.Post("/{requestID}/cancel", approval.CancelRequest(s.approvals))
+		})
+	})
+
+	// 通知渠道、模板、用户的订阅和发送历史, 渠道和模板只有管理员可以修改
+	router.Route("/notifications", func(r chi.Router) {
+		r.Use(acl.AuthorizeUser)
+		r.Route("/channels", func(r chi.Router) {
+			r.Get("/", notify.ListChannels(s.notifyChannelDao))
+			r.With(acl.AuthorizeAdmin).Post("/", notify.CreateChannel(s.notifier))
+			r.With(acl.AuthorizeAdmin).Put("/{channelID}", notify.UpdateChannel(s.notifier))
+			r.With(acl.AuthorizeAdmin).Delete("/{channelID}", notify.DeleteChannel(s.notifyChannelDao))
+			r.With(acl.AuthorizeAdmin).Post("/{channelID}/test", notify.TestChannel(s.notifier))
+		})
+		r.Route("/templates", func(r chi.Router) {
+			r.Get("/", notify.ListTemplates(s.notifyTemplateDao))
+			r.With(acl.AuthorizeAdmin).Put("/{event}", notify.SaveTemplate(s.notifier))
+			r.With(acl.AuthorizeAdmin).Delete("/{event}", notify.DeleteTemplate(s.notifyTemplateDao))
+		})
+		r.Route("/subscriptions", func(r chi.Router) {
+			r.Get("/", notify.ListSubscriptions(s.notifySubscriptionDao))
+			r.Post("/", notify.CreateSubscription(s.notifier))
+			r.Delete("/{subscriptionID}", notify.DeleteSubscription(s.notifySubscriptionDao))
+		})
+		r.With(middleware.Paginate).Get("/deliveries", notify.ListDeliveries(s.notifyDeliveryDao))
+		r.Get("/deliveries/{deliveryID}", notify.GetDelivery(s.notifyDeliveryDao))
+	})
+
+	// 定时任务、手动触发和执行历史, 只有管理员可以管理
+	router.Route("/cron", func(r chi.Router) {
+		r.Use(acl.AuthorizeAdmin)
+		r.Get("/tasks", cron.ListTasks(s.cronJobs))
+		r.Route("/jobs", func(r chi.Router) {
+			r.Get("/", cron.ListJobs(s.cronJobDao))
+			r.Post("/", cron.CreateJob(s.cronJobs))
+			r.Get("/{jobID}", cron.GetJob(s.cronJobDao))
+			r.Put("/{jobID}", cron.UpdateJob(s.cronJobs))
+			r.Delete("/{jobID}", cron.DeleteJob(s.cronJobDao))
+			r.Post("/{jobID}/pause", cron.PauseJob(s.cronJobs))
+			r.Post("/{jobID}/resume", cron.ResumeJob(s.cronJobs))
+			r.Post("/{jobID}/trigger", cron.TriggerJob(s.cronJobs))
+		})
+		r.With(middleware.Paginate).Get("/runs", cron.ListRuns(s.cronRunDao))
+		r.Get("/runs/{runID}", cron.GetRun(s.cronRunDao))
+	})
+
+	// 耗时操作的后台任务, 查询进度、结果或者错误
+	router.Route("/tasks", func(r chi.Router) {
+		r.Use(acl.AuthorizeUser)
+		r.With(middleware.Paginate).Get("/", task.ListTasks(s.taskDao))
+		r.Get("/{taskID}", task.GetTask(s.taskDao))
+	})
+
+	// 资源变更的 SSE 推送, 前端通过 access_token 查询参数认证
+	router.With(acl.AuthorizeUser).Get("/events/stream", events.Stream(s.broker))
+
+	// 授权管理
+	router.Route("/rbac/grants", func(r chi.Router) {
+		r.Use(acl.AuthorizeAdmin)
+		r.Get("/", rbac.ListGrants(s.grantDao))
+		r.Post("/", rbac.CreateGrant(s.grantDao))
+		r.Delete("/{grantID}", rbac.DeleteGrant(s.grantDao))
+	})
 }
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/pkg/config"
+)
+
+type fakeBinaryDao struct {
+	core.AgentBinaryDao
+	binary *core.AgentBinary
+}
+
+func (f *fakeBinaryDao) Get(_ context.Context, id int64) (*core.AgentBinary, error) {
+	if id != f.binary.ID {
+		return nil, sql.ErrNoRows
+	}
+	return f.binary, nil
+}
+
+type fakeUserDao struct {
+	core.UserDao
+}
+
+func (fakeUserDao) Get(context.Context, int64) (*core.User, error) { return nil, sql.ErrNoRows }
+
+// TestAgentRoutes agent 的令牌不是 JWT, 经过完整的路由时不能被用户认证中间件拒绝
+func TestAgentRoutes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "easynetes-agent")
+	if err := os.WriteFile(path, []byte("binary"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{}
+	cfg.SetDefault()
+	cfg.Agent.Token = "agent-token"
+	cfg.Security.SecretKey = "secret"
+	s := Server{
+		userDao:   fakeUserDao{},
+		binaryDao: &fakeBinaryDao{binary: &core.AgentBinary{ID: 3, Path: path, Checksum: "sum"}},
+		cfg:       cfg,
+	}
+	handler := s.Handler()
+	do := func(target, token string) *httptest.ResponseRecorder {
+		method := http.MethodGet
+		if m, rest, ok := strings.Cut(target, " "); ok {
+			method, target = m, rest
+		}
+		request := httptest.NewRequest(method, target, nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	out := do("/agents/binaries/3/download", "agent-token")
+	body, _ := io.ReadAll(out.Body)
+	if out.Code != http.StatusOK || string(body) != "binary" || out.Header().Get("X-Checksum-Sha256") != "sum" {
+		t.Fatalf("download = %d %s", out.Code, body)
+	}
+	if out := do("/agents/binaries/3/download", "wrong"); out.Code != http.StatusUnauthorized {
+		t.Errorf("download with wrong token = %d", out.Code)
+	}
+	// 没有 websocket 握手时 Upgrade 返回 400, 说明请求已经通过了令牌检查
+	if out := do("/agents/stream", "agent-token"); out.Code != http.StatusBadRequest {
+		t.Errorf("stream = %d, want 400 from the websocket upgrade", out.Code)
+	}
+	if out := do("/agents/stream", "wrong"); out.Code != http.StatusUnauthorized {
+		t.Errorf("stream with wrong token = %d", out.Code)
+	}
+	// 其他的 agent 接口仍然需要用户登录
+	for _, target := range []string{"/agents/", "DELETE /agents/binaries/3"} {
+		if out := do(target, "agent-token"); out.Code != http.StatusUnauthorized {
+			t.Errorf("%s with agent token = %d", target, out.Code)
+		}
+	}
+}
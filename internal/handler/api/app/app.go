@@ -0,0 +1,165 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	"github.com/bloodsteel/easynetes/internal/service/release"
+	"github.com/bloodsteel/easynetes/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// ListApplications 返回应用列表, 支持按 service_node_id 和 name 过滤
+func ListApplications(appDao core.ApplicationDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		limit, offset, _ := middleware.GetPaginateFromCtx(ctx)
+		query := request.URL.Query()
+		serviceNodeID, _ := strconv.ParseInt(query.Get("service_node_id"), 10, 64)
+		params := map[string]interface{}{
+			"service_node_id": serviceNodeID,
+			"name":            query.Get("name"),
+		}
+		count, err := appDao.Count(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		params["limit"] = limit
+		params["offset"] = offset
+		apps, err := appDao.List(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		writer.Header().Set("Count", strconv.FormatInt(count, 10))
+		utils.RenderSuccess(writer, request, apps)
+	}
+}
+
+// GetApplication 返回单个应用
+func GetApplication(appDao core.ApplicationDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		appID, ok := idParam(writer, request, "appID")
+		if !ok {
+			return
+		}
+		app, err := appDao.Get(request.Context(), appID)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, app)
+	}
+}
+
+// CreateApplication 创建一个应用
+// 请求体: {"name", "description", "service_node_id", "spec": {"image", "replicas", "ports", "env", "resources", "ingress"}}
+func CreateApplication(appDao core.ApplicationDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		in := new(core.Application)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		if err := release.Validate(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeBadRequestWithApplication, err)
+			return
+		}
+		if user, ok := middleware.GetUserFromCtx(ctx); ok {
+			in.Creator = user.UserName
+		}
+		if _, err := appDao.Create(ctx, in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, in)
+	}
+}
+
+// UpdateApplication 更新应用的描述、服务树节点和部署参数, 应用名称决定了集群中的对象名称, 不允许修改
+// 新的配置在下一次发布时生效
+func UpdateApplication(appDao core.ApplicationDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		appID, ok := idParam(writer, request, "appID")
+		if !ok {
+			return
+		}
+		app, err := appDao.Get(ctx, appID)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		in := new(core.Application)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		app.Description = in.Description
+		app.ServiceNodeID = in.ServiceNodeID
+		app.Spec = in.Spec
+		if err := release.Validate(app); err != nil {
+			utils.RenderError(writer, request, utils.SCodeBadRequestWithApplication, err)
+			return
+		}
+		if err := appDao.Update(ctx, app); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, app)
+	}
+}
+
+// DeleteApplication 删除应用, 应用下还有环境时不允许删除; 集群中已经发布的对象不会被删除
+func DeleteApplication(appDao core.ApplicationDao, envDao core.AppEnvironmentDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		appID, ok := idParam(writer, request, "appID")
+		if !ok {
+			return
+		}
+		envs, err := envDao.List(ctx, appID)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		if len(envs) > 0 {
+			utils.RenderFail(writer, request, utils.SCodeBadRequestWithApplication)
+			return
+		}
+		if err := appDao.Delete(ctx, appID); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, nil)
+	}
+}
+
+func idParam(writer http.ResponseWriter, request *http.Request, name string) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(request, name), 10, 64)
+	if err != nil {
+		utils.RenderFail(writer, request, utils.SCodeBadRequestWithPathParamErr)
+		return 0, false
+	}
+	return id, true
+}
+
+// renderReleaseError 将应用发布相关的错误转换为对应的状态码
+func renderReleaseError(writer http.ResponseWriter, request *http.Request, err error) {
+	switch {
+	case errors.Is(err, core.ErrInvalidApplication):
+		utils.RenderError(writer, request, utils.SCodeBadRequestWithApplication, err)
+	case errors.Is(err, release.ErrRollbackTarget):
+		utils.RenderError(writer, request, utils.SCodeBadRequestWithRollback, err)
+	case errors.Is(err, core.ErrForbidden):
+		utils.RenderFail(writer, request, utils.SCodeForbidden)
+	default:
+		utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+	}
+}
@@ -0,0 +1,145 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	"github.com/bloodsteel/easynetes/internal/service/release"
+	"github.com/bloodsteel/easynetes/internal/utils"
+)
+
+// ListEnvironments 返回应用的所有环境
+func ListEnvironments(envDao core.AppEnvironmentDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		appID, ok := idParam(writer, request, "appID")
+		if !ok {
+			return
+		}
+		envs, err := envDao.List(request.Context(), appID)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, envs)
+	}
+}
+
+// CreateEnvironment 为应用创建一个环境
+// 请求体: {"name": "prod", "cluster_id", "namespace", "overrides": {"image", "replicas", "env", ...}}
+func CreateEnvironment(appDao core.ApplicationDao, envDao core.AppEnvironmentDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		appID, ok := idParam(writer, request, "appID")
+		if !ok {
+			return
+		}
+		if _, err := appDao.Get(ctx, appID); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		in := new(core.AppEnvironment)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		in.AppID = appID
+		if !validateEnvironment(writer, request, envDao, in) {
+			return
+		}
+		if user, ok := middleware.GetUserFromCtx(ctx); ok {
+			in.Creator = user.UserName
+		}
+		if _, err := envDao.Create(ctx, in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, in)
+	}
+}
+
+// UpdateEnvironment 更新环境的集群、namespace 和覆盖配置, 新的配置在下一次发布时生效
+// 修改集群或者 namespace 不会删除原来位置上已经发布的对象
+func UpdateEnvironment(envDao core.AppEnvironmentDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		env, ok := loadEnvironment(writer, request, envDao)
+		if !ok {
+			return
+		}
+		in := new(core.AppEnvironment)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		env.ClusterID = in.ClusterID
+		env.Namespace = in.Namespace
+		env.Overrides = in.Overrides
+		if !validateEnvironment(writer, request, envDao, env) {
+			return
+		}
+		if err := envDao.Update(ctx, env); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, env)
+	}
+}
+
+// DeleteEnvironment 删除应用的环境, 集群中已经发布的对象不会被删除
+func DeleteEnvironment(envDao core.AppEnvironmentDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		env, ok := loadEnvironment(writer, request, envDao)
+		if !ok {
+			return
+		}
+		if err := envDao.Delete(request.Context(), env.ID); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, nil)
+	}
+}
+
+// loadEnvironment 获取路径中的环境, 环境不属于路径中的应用时返回 404
+func loadEnvironment(writer http.ResponseWriter, request *http.Request, envDao core.AppEnvironmentDao) (*core.AppEnvironment, bool) {
+	appID, ok := idParam(writer, request, "appID")
+	if !ok {
+		return nil, false
+	}
+	envID, ok := idParam(writer, request, "envID")
+	if !ok {
+		return nil, false
+	}
+	env, err := envDao.Get(request.Context(), envID)
+	if err != nil {
+		utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+		return nil, false
+	}
+	if env.AppID != appID {
+		utils.RenderFail(writer, request, utils.SCodeNotFoundWithDao)
+		return nil, false
+	}
+	return env, true
+}
+
+// validateEnvironment 校验环境的配置, 同一个应用的两个环境不能部署到同一个 namespace, 否则会互相覆盖
+func validateEnvironment(writer http.ResponseWriter, request *http.Request, envDao core.AppEnvironmentDao, env *core.AppEnvironment) bool {
+	if err := release.ValidateEnvironment(env); err != nil {
+		utils.RenderError(writer, request, utils.SCodeBadRequestWithApplication, err)
+		return false
+	}
+	envs, err := envDao.List(request.Context(), env.AppID)
+	if err != nil {
+		utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+		return false
+	}
+	for _, other := range envs {
+		if other.ID != env.ID && other.ClusterID == env.ClusterID && other.Namespace == env.Namespace {
+			utils.RenderFail(writer, request, utils.SCodeBadRequestWithApplication)
+			return false
+		}
+	}
+	return true
+}
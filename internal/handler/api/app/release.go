@@ -0,0 +1,176 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	"github.com/bloodsteel/easynetes/internal/service/approval"
+	"github.com/bloodsteel/easynetes/internal/service/release"
+	"github.com/bloodsteel/easynetes/internal/utils"
+)
+
+// PreviewRelease 渲染应用在环境中的清单但不发布, 查询参数 image 可以覆盖镜像
+func PreviewRelease(releases *release.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		appID, ok := idParam(writer, request, "appID")
+		if !ok {
+			return
+		}
+		envID, ok := idParam(writer, request, "envID")
+		if !ok {
+			return
+		}
+		out, err := releases.Preview(request.Context(), appID, envID, request.URL.Query().Get("image"))
+		if err != nil {
+			renderReleaseError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, out)
+	}
+}
+
+// DeployRelease 将应用发布到环境中, 需要环境所在 namespace 的 apply 授权
+// 请求体: {"image", "reason"}, image 为空时使用应用和环境中配置的镜像; 提交失败时返回状态为 failed 的发布记录
+// 匹配到审批规则时返回202和审批请求, 审批通过后以申请人的身份发布, reason 是申请发布的原因
+func DeployRelease(releases *release.Service, approvals *approval.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		appID, ok := idParam(writer, request, "appID")
+		if !ok {
+			return
+		}
+		envID, ok := idParam(writer, request, "envID")
+		if !ok {
+			return
+		}
+		var in struct {
+			Image  string `json:"image"`
+			Reason string `json:"reason"`
+		}
+		if request.ContentLength != 0 {
+			if err := json.NewDecoder(request.Body).Decode(&in); err != nil {
+				utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+				return
+			}
+		}
+		user, _ := middleware.GetUserFromCtx(ctx)
+		req, err := approvals.Submit(ctx, core.ApprovalAppDeploy,
+			approval.AppDeployPayload{AppID: appID, EnvID: envID, Image: in.Image}, in.Reason, user)
+		if err != nil {
+			renderReleaseError(writer, request, err)
+			return
+		}
+		if req != nil {
+			utils.RenderAccepted(writer, request, req)
+			return
+		}
+		out, err := releases.Deploy(ctx, appID, envID, in.Image, user)
+		if err != nil {
+			renderReleaseError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, out)
+	}
+}
+
+// RollbackRelease 使用历史发布的部署参数重新发布, 生成一个新的版本, 请求体: {"reason"}
+// 回滚使用发布的审批规则, 匹配到审批规则时返回202和审批请求, 审批通过后以申请人的身份回滚
+func RollbackRelease(releases *release.Service, approvals *approval.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		appID, ok := idParam(writer, request, "appID")
+		if !ok {
+			return
+		}
+		releaseID, ok := idParam(writer, request, "releaseID")
+		if !ok {
+			return
+		}
+		var in struct {
+			Reason string `json:"reason"`
+		}
+		if request.ContentLength != 0 {
+			if err := json.NewDecoder(request.Body).Decode(&in); err != nil {
+				utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+				return
+			}
+		}
+		user, _ := middleware.GetUserFromCtx(ctx)
+		req, err := approvals.Submit(ctx, core.ApprovalAppRollback,
+			approval.AppRollbackPayload{AppID: appID, ReleaseID: releaseID}, in.Reason, user)
+		if err != nil {
+			renderReleaseError(writer, request, err)
+			return
+		}
+		if req != nil {
+			utils.RenderAccepted(writer, request, req)
+			return
+		}
+		out, err := releases.Rollback(ctx, appID, releaseID, user)
+		if err != nil {
+			renderReleaseError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, out)
+	}
+}
+
+// ListReleases 返回应用的发布历史, 支持按 env_id 和 status 过滤, 列表中不包含清单内容
+func ListReleases(releaseDao core.AppReleaseDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		appID, ok := idParam(writer, request, "appID")
+		if !ok {
+			return
+		}
+		limit, offset, _ := middleware.GetPaginateFromCtx(ctx)
+		query := request.URL.Query()
+		envID, _ := strconv.ParseInt(query.Get("env_id"), 10, 64)
+		params := map[string]interface{}{
+			"app_id": appID,
+			"env_id": envID,
+			"status": query.Get("status"),
+		}
+		count, err := releaseDao.Count(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		params["limit"] = limit
+		params["offset"] = offset
+		out, err := releaseDao.List(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		writer.Header().Set("Count", strconv.FormatInt(count, 10))
+		utils.RenderSuccess(writer, request, out)
+	}
+}
+
+// GetRelease 返回单个发布记录, 包含渲染出的清单
+func GetRelease(releaseDao core.AppReleaseDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		appID, ok := idParam(writer, request, "appID")
+		if !ok {
+			return
+		}
+		releaseID, ok := idParam(writer, request, "releaseID")
+		if !ok {
+			return
+		}
+		out, err := releaseDao.Get(request.Context(), releaseID)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		if out.AppID != appID {
+			utils.RenderFail(writer, request, utils.SCodeNotFoundWithDao)
+			return
+		}
+		utils.RenderSuccess(writer, request, out)
+	}
+}
@@ -0,0 +1,151 @@
+package approval
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	approvalsvc "github.com/bloodsteel/easynetes/internal/service/approval"
+	"github.com/bloodsteel/easynetes/internal/utils"
+)
+
+// requestDetail 审批请求以及审计记录
+type requestDetail struct {
+	*core.ApprovalRequest
+	Events []*core.ApprovalEvent `json:"events"`
+}
+
+// ListRequests 返回审批请求, 支持按 action/status/requester/resource_type/resource_id 过滤
+func ListRequests(requestDao core.ApprovalRequestDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		limit, offset, _ := middleware.GetPaginateFromCtx(ctx)
+		query := request.URL.Query()
+		params := map[string]interface{}{}
+		for _, key := range []string{"action", "status", "requester", "resource_type", "resource_id"} {
+			params[key] = query.Get(key)
+		}
+		count, err := requestDao.Count(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		params["limit"] = limit
+		params["offset"] = offset
+		requests, err := requestDao.List(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		writer.Header().Set("Count", strconv.FormatInt(count, 10))
+		utils.RenderSuccess(writer, request, requests)
+	}
+}
+
+// GetRequest 返回审批请求以及所有的审计记录
+func GetRequest(requestDao core.ApprovalRequestDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		requestID, ok := idParam(writer, request, "requestID")
+		if !ok {
+			return
+		}
+		req, err := requestDao.Get(ctx, requestID)
+		if err != nil {
+			renderApprovalError(writer, request, err)
+			return
+		}
+		events, err := requestDao.ListEvents(ctx, requestID)
+		if err != nil {
+			renderApprovalError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, &requestDetail{ApprovalRequest: req, Events: events})
+	}
+}
+
+// VoteRequest 同意或者拒绝审批请求, 请求体: {"comment": "..."}, 可以为空
+// 同意的人数达到要求时创建执行操作的后台任务并返回该任务, 执行结果保存在审批请求中; 否则返回更新后的请求
+func VoteRequest(approvals *approvalsvc.Service, approved bool) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		requestID, ok := idParam(writer, request, "requestID")
+		if !ok {
+			return
+		}
+		comment, ok := bindComment(writer, request)
+		if !ok {
+			return
+		}
+		user, _ := middleware.GetUserFromCtx(ctx)
+		req, task, err := approvals.Vote(ctx, requestID, user, approved, comment)
+		if err != nil {
+			renderApprovalError(writer, request, err)
+			return
+		}
+		if task != nil {
+			utils.RenderAccepted(writer, request, task)
+			return
+		}
+		utils.RenderSuccess(writer, request, req)
+	}
+}
+
+// CommentRequest 在审批请求上添加评论, 请求体: {"comment": "..."}
+func CommentRequest(approvals *approvalsvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		requestID, ok := idParam(writer, request, "requestID")
+		if !ok {
+			return
+		}
+		comment, ok := bindComment(writer, request)
+		if !ok {
+			return
+		}
+		if comment == "" {
+			utils.RenderFail(writer, request, utils.SCodeBadRequestWithValueEmpty)
+			return
+		}
+		user, _ := middleware.GetUserFromCtx(ctx)
+		if err := approvals.Comment(ctx, requestID, user, comment); err != nil {
+			renderApprovalError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, nil)
+	}
+}
+
+// CancelRequest 取消待审批的请求, 只有申请人和管理员可以取消
+func CancelRequest(approvals *approvalsvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		requestID, ok := idParam(writer, request, "requestID")
+		if !ok {
+			return
+		}
+		user, _ := middleware.GetUserFromCtx(ctx)
+		req, err := approvals.Cancel(ctx, requestID, user)
+		if err != nil {
+			renderApprovalError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, req)
+	}
+}
+
+// bindComment 解析请求体中的评论, 请求体可以为空, 解析失败时已经返回了错误
+func bindComment(writer http.ResponseWriter, request *http.Request) (string, bool) {
+	in := new(struct {
+		Comment string `json:"comment"`
+	})
+	if request.ContentLength != 0 {
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return "", false
+		}
+	}
+	return in.Comment, true
+}
@@ -0,0 +1,129 @@
+package approval
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	approvalsvc "github.com/bloodsteel/easynetes/internal/service/approval"
+	"github.com/bloodsteel/easynetes/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// ListRules 返回审批规则, 支持按 action 过滤
+func ListRules(ruleDao core.ApprovalRuleDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		params := map[string]interface{}{"action": request.URL.Query().Get("action")}
+		rules, err := ruleDao.List(request.Context(), params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, rules)
+	}
+}
+
+// GetRule 返回单个审批规则
+func GetRule(ruleDao core.ApprovalRuleDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ruleID, ok := idParam(writer, request, "ruleID")
+		if !ok {
+			return
+		}
+		rule, err := ruleDao.Get(request.Context(), ruleID)
+		if err != nil {
+			renderApprovalError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, rule)
+	}
+}
+
+// CreateRule 创建审批规则
+// 请求体: {"name", "action", "service_node_id", "env_id", "approvers": ["lead", "ops"], "required": 1, "expire_hours"}
+func CreateRule(approvals *approvalsvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		in := new(core.ApprovalRule)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		in.ID = 0
+		if user, ok := middleware.GetUserFromCtx(ctx); ok {
+			in.Creator = user.UserName
+		}
+		out, err := approvals.SaveRule(ctx, in)
+		if err != nil {
+			renderApprovalError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, out)
+	}
+}
+
+// UpdateRule 更新审批规则, 已经创建的审批请求不受影响
+func UpdateRule(approvals *approvalsvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ruleID, ok := idParam(writer, request, "ruleID")
+		if !ok {
+			return
+		}
+		in := new(core.ApprovalRule)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		in.ID = ruleID
+		out, err := approvals.SaveRule(request.Context(), in)
+		if err != nil {
+			renderApprovalError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, out)
+	}
+}
+
+// DeleteRule 删除审批规则, 已经创建的审批请求不受影响
+func DeleteRule(ruleDao core.ApprovalRuleDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ruleID, ok := idParam(writer, request, "ruleID")
+		if !ok {
+			return
+		}
+		if err := ruleDao.Delete(request.Context(), ruleID); err != nil {
+			renderApprovalError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, nil)
+	}
+}
+
+// idParam 解析路径参数中的ID, 解析失败时已经返回了错误
+func idParam(writer http.ResponseWriter, request *http.Request, name string) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(request, name), 10, 64)
+	if err != nil {
+		utils.RenderFail(writer, request, utils.SCodeBadRequestWithPathParamErr)
+		return 0, false
+	}
+	return id, true
+}
+
+// renderApprovalError 将审批相关的错误转换为对应的状态码
+func renderApprovalError(writer http.ResponseWriter, request *http.Request, err error) {
+	switch {
+	case errors.Is(err, core.ErrInvalidApprovalRule):
+		utils.RenderError(writer, request, utils.SCodeBadRequestWithApprovalRule, err)
+	case errors.Is(err, approvalsvc.ErrClosed):
+		utils.RenderFail(writer, request, utils.SCodeBadRequestWithApprovalClosed)
+	case errors.Is(err, approvalsvc.ErrInvalidPayload):
+		utils.RenderError(writer, request, utils.SCodeBadRequestWithApprovalPayload, err)
+	case errors.Is(err, core.ErrForbidden):
+		utils.RenderFail(writer, request, utils.SCodeForbidden)
+	default:
+		utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+	}
+}
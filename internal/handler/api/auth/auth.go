@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	"github.com/bloodsteel/easynetes/internal/utils"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// HandleAuthentication 返回一个中间件, 从 Authorization Header 中解析 JWT 并将对应的用户存入 request ctx
+// 没有携带 Token 的请求按匿名请求处理, 是否允许匿名访问由 acl 中间件决定
+func HandleAuthentication(userDao core.UserDao, secret string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			raw := bearerToken(request)
+			if raw == "" {
+				next.ServeHTTP(writer, request)
+				return
+			}
+			claims := new(jwt.RegisteredClaims)
+			_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+				return []byte(secret), nil
+			}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+			if err != nil {
+				utils.RenderFail(writer, request, utils.SCodeUnauthenticateWithToken)
+				return
+			}
+			userID, err := strconv.ParseInt(claims.Subject, 10, 64)
+			if err != nil {
+				utils.RenderFail(writer, request, utils.SCodeUnauthenticateNotFoundClaims)
+				return
+			}
+			user, err := userDao.Get(request.Context(), userID)
+			if err != nil || user == nil {
+				utils.RenderFail(writer, request, utils.SCodeUnauthenticateWithToken)
+				return
+			}
+			ctx := middleware.WithUser(request.Context(), user)
+			next.ServeHTTP(writer, request.WithContext(ctx))
+		})
+	}
+}
+
+// bearerToken 从 Authorization Header 或者 access_token 查询参数中获取 Token
+// websocket 和 SSE 请求无法自定义 Header, 只能通过查询参数传递
+func bearerToken(request *http.Request) string {
+	header := request.Header.Get("Authorization")
+	if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return request.URL.Query().Get("access_token")
+}
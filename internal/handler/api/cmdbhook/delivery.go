@@ -0,0 +1,75 @@
+package cmdbhook
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	cmdbhooksvc "github.com/bloodsteel/easynetes/internal/service/cmdbhook"
+	"github.com/bloodsteel/easynetes/internal/utils"
+)
+
+// ListDeliveries 返回投递记录, 支持按 hook_id/event/status 过滤, status=dead 即死信列表
+func ListDeliveries(deliveryDao core.CMDBHookDeliveryDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		limit, offset, _ := middleware.GetPaginateFromCtx(ctx)
+		query := request.URL.Query()
+		params := map[string]interface{}{"event": query.Get("event"), "status": query.Get("status")}
+		if v := query.Get("hook_id"); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				utils.RenderFail(writer, request, utils.SCodeBadRequestWithQueryParamErr)
+				return
+			}
+			params["hook_id"] = id
+		}
+		count, err := deliveryDao.Count(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		params["limit"] = limit
+		params["offset"] = offset
+		deliveries, err := deliveryDao.List(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		writer.Header().Set("Count", strconv.FormatInt(count, 10))
+		utils.RenderSuccess(writer, request, deliveries)
+	}
+}
+
+// GetDelivery 返回单条投递记录, 包含发送的 payload
+func GetDelivery(deliveryDao core.CMDBHookDeliveryDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		deliveryID, ok := idParam(writer, request, "deliveryID")
+		if !ok {
+			return
+		}
+		delivery, err := deliveryDao.Get(request.Context(), deliveryID)
+		if err != nil {
+			renderHookError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, delivery)
+	}
+}
+
+// Redeliver 重新投递死信列表中的事件, 立即投递一次, 失败时按正常的重试策略继续
+func Redeliver(hooks *cmdbhooksvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		deliveryID, ok := idParam(writer, request, "deliveryID")
+		if !ok {
+			return
+		}
+		delivery, err := hooks.Redeliver(request.Context(), deliveryID)
+		if err != nil {
+			renderHookError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, delivery)
+	}
+}
@@ -0,0 +1,129 @@
+package cmdbhook
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	cmdbhooksvc "github.com/bloodsteel/easynetes/internal/service/cmdbhook"
+	"github.com/bloodsteel/easynetes/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// hookRequest 创建和更新订阅的请求体, secret 是签名密钥, 更新时为空表示不修改
+type hookRequest struct {
+	core.CMDBHook
+	Secret string `json:"secret"`
+}
+
+// ListHooks 返回所有 CMDB 变更的 webhook 订阅
+func ListHooks(hookDao core.CMDBHookDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		hooks, err := hookDao.List(request.Context())
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, hooks)
+	}
+}
+
+// GetHook 返回单个订阅
+func GetHook(hookDao core.CMDBHookDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		hookID, ok := idParam(writer, request, "hookID")
+		if !ok {
+			return
+		}
+		hook, err := hookDao.Get(request.Context(), hookID)
+		if err != nil {
+			renderHookError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, hook)
+	}
+}
+
+// CreateHook 创建订阅, 请求体: {"name", "url", "secret", "events": ["host.created", "host.status_changed"], "enabled"}
+func CreateHook(hooks *cmdbhooksvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		in := new(hookRequest)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		in.ID = 0
+		if user, ok := middleware.GetUserFromCtx(ctx); ok {
+			in.Creator = user.UserName
+		}
+		out, err := hooks.Create(ctx, &in.CMDBHook, in.Secret)
+		if err != nil {
+			renderHookError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, out)
+	}
+}
+
+// UpdateHook 更新订阅, secret 为空时保留原有的密钥
+func UpdateHook(hooks *cmdbhooksvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		hookID, ok := idParam(writer, request, "hookID")
+		if !ok {
+			return
+		}
+		in := new(hookRequest)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		in.ID = hookID
+		out, err := hooks.Update(request.Context(), &in.CMDBHook, in.Secret)
+		if err != nil {
+			renderHookError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, out)
+	}
+}
+
+// DeleteHook 删除订阅, 投递记录保留, 未投递的事件不再投递
+func DeleteHook(hookDao core.CMDBHookDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		hookID, ok := idParam(writer, request, "hookID")
+		if !ok {
+			return
+		}
+		if err := hookDao.Delete(request.Context(), hookID); err != nil {
+			renderHookError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, nil)
+	}
+}
+
+// idParam 解析路径参数中的ID, 解析失败时已经返回了错误
+func idParam(writer http.ResponseWriter, request *http.Request, name string) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(request, name), 10, 64)
+	if err != nil {
+		utils.RenderFail(writer, request, utils.SCodeBadRequestWithPathParamErr)
+		return 0, false
+	}
+	return id, true
+}
+
+// renderHookError 将 webhook 相关的错误转换为对应的状态码
+func renderHookError(writer http.ResponseWriter, request *http.Request, err error) {
+	switch {
+	case errors.Is(err, core.ErrInvalidCMDBHook):
+		utils.RenderError(writer, request, utils.SCodeBadRequestWithCMDBHook, err)
+	case errors.Is(err, cmdbhooksvc.ErrNotDead):
+		utils.RenderFail(writer, request, utils.SCodeBadRequestWithDeliveryNotDead)
+	default:
+		utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+	}
+}
@@ -0,0 +1,188 @@
+package cron
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	cronsvc "github.com/bloodsteel/easynetes/internal/service/cron"
+	"github.com/bloodsteel/easynetes/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// ListTasks 返回所有可用的任务类型
+func ListTasks(jobs *cronsvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		utils.RenderSuccess(writer, request, jobs.Tasks())
+	}
+}
+
+// ListJobs 返回定时任务列表, 支持按 task_type/paused 过滤
+func ListJobs(jobDao core.CronJobDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		query := request.URL.Query()
+		params := map[string]interface{}{"task_type": query.Get("task_type")}
+		if v := query.Get("paused"); v != "" {
+			paused, err := strconv.ParseBool(v)
+			if err != nil {
+				utils.RenderFail(writer, request, utils.SCodeBadRequestWithQueryParamErr)
+				return
+			}
+			params["paused"] = paused
+		}
+		jobs, err := jobDao.List(request.Context(), params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, jobs)
+	}
+}
+
+// GetJob 返回单个定时任务
+func GetJob(jobDao core.CronJobDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		jobID, ok := idParam(writer, request, "jobID")
+		if !ok {
+			return
+		}
+		job, err := jobDao.Get(request.Context(), jobID)
+		if err != nil {
+			renderCronError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, job)
+	}
+}
+
+// CreateJob 创建定时任务, 请求体: {"name", "task_type", "schedule": "0 2 * * *", "timezone": "Asia/Shanghai", "params", "timeout"}
+func CreateJob(jobs *cronsvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		in := new(core.CronJob)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		in.ID = 0
+		if user, ok := middleware.GetUserFromCtx(ctx); ok {
+			in.Creator = user.UserName
+		}
+		out, err := jobs.Save(ctx, in)
+		if err != nil {
+			renderCronError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, out)
+	}
+}
+
+// UpdateJob 更新定时任务, 暂停状态通过 pause/resume 修改
+func UpdateJob(jobs *cronsvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		jobID, ok := idParam(writer, request, "jobID")
+		if !ok {
+			return
+		}
+		in := new(core.CronJob)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		in.ID = jobID
+		out, err := jobs.Save(request.Context(), in)
+		if err != nil {
+			renderCronError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, out)
+	}
+}
+
+// DeleteJob 删除定时任务, 执行记录保留, 未开始的手动执行不再执行
+func DeleteJob(jobDao core.CronJobDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		jobID, ok := idParam(writer, request, "jobID")
+		if !ok {
+			return
+		}
+		if err := jobDao.Delete(request.Context(), jobID); err != nil {
+			renderCronError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, nil)
+	}
+}
+
+// PauseJob 暂停定时任务
+func PauseJob(jobs *cronsvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		jobID, ok := idParam(writer, request, "jobID")
+		if !ok {
+			return
+		}
+		job, err := jobs.Pause(request.Context(), jobID)
+		if err != nil {
+			renderCronError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, job)
+	}
+}
+
+// ResumeJob 恢复定时任务
+func ResumeJob(jobs *cronsvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		jobID, ok := idParam(writer, request, "jobID")
+		if !ok {
+			return
+		}
+		job, err := jobs.Resume(request.Context(), jobID)
+		if err != nil {
+			renderCronError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, job)
+	}
+}
+
+// TriggerJob 手动触发一次执行, 返回 pending 状态的执行记录
+func TriggerJob(jobs *cronsvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		jobID, ok := idParam(writer, request, "jobID")
+		if !ok {
+			return
+		}
+		user, _ := middleware.GetUserFromCtx(ctx)
+		run, err := jobs.Trigger(ctx, jobID, user)
+		if err != nil {
+			renderCronError(writer, request, err)
+			return
+		}
+		utils.RenderAccepted(writer, request, run)
+	}
+}
+
+// idParam 解析路径参数中的ID, 解析失败时已经返回了错误
+func idParam(writer http.ResponseWriter, request *http.Request, name string) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(request, name), 10, 64)
+	if err != nil {
+		utils.RenderFail(writer, request, utils.SCodeBadRequestWithPathParamErr)
+		return 0, false
+	}
+	return id, true
+}
+
+// renderCronError 将定时任务相关的错误转换为对应的状态码
+func renderCronError(writer http.ResponseWriter, request *http.Request, err error) {
+	switch {
+	case errors.Is(err, core.ErrInvalidCronJob):
+		utils.RenderError(writer, request, utils.SCodeBadRequestWithCronJob, err)
+	default:
+		utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+	}
+}
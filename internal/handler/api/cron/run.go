@@ -0,0 +1,58 @@
+package cron
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	"github.com/bloodsteel/easynetes/internal/utils"
+)
+
+// ListRuns 返回执行历史, 支持按 job_id/status/trigger 过滤
+func ListRuns(runDao core.CronRunDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		limit, offset, _ := middleware.GetPaginateFromCtx(ctx)
+		query := request.URL.Query()
+		params := map[string]interface{}{"status": query.Get("status"), "trigger_type": query.Get("trigger")}
+		if v := query.Get("job_id"); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				utils.RenderFail(writer, request, utils.SCodeBadRequestWithQueryParamErr)
+				return
+			}
+			params["job_id"] = id
+		}
+		count, err := runDao.Count(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		params["limit"] = limit
+		params["offset"] = offset
+		runs, err := runDao.List(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		writer.Header().Set("Count", strconv.FormatInt(count, 10))
+		utils.RenderSuccess(writer, request, runs)
+	}
+}
+
+// GetRun 返回单条执行记录, 包含任务输出
+func GetRun(runDao core.CronRunDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		runID, ok := idParam(writer, request, "runID")
+		if !ok {
+			return
+		}
+		run, err := runDao.Get(request.Context(), runID)
+		if err != nil {
+			renderCronError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, run)
+	}
+}
@@ -0,0 +1,90 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/service/stream"
+	"github.com/bloodsteel/easynetes/internal/utils"
+)
+
+const (
+	// pingInterval 没有变更时发送 SSE 注释保持连接
+	pingInterval = 15 * time.Second
+	// retryInterval 客户端断线后重连的等待时间, 单位为毫秒
+	retryInterval = 3000
+)
+
+// Stream 以 SSE 推送资源的创建、更新和删除, 事件类型为 created/updated/deleted/status_changed
+// 查询参数 resource 可以重复, 取值为资源类型(host)或者资源类型和ID(host:12), 为空时订阅所有资源
+// 资源类型: host, agent, pipeline_run, cron_run, task
+// 重连时通过 Last-Event-ID 请求头或者 last_event_id 查询参数补发错过的变更, 无法补发时先发送 reset 事件
+func Stream(broker *stream.Broker) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		query := request.URL.Query()
+		var filter stream.Filter
+		for _, item := range query["resource"] {
+			for _, v := range strings.Split(item, ",") {
+				if v = strings.TrimSpace(v); v != "" {
+					filter = append(filter, v)
+				}
+			}
+		}
+		lastID := request.Header.Get("Last-Event-ID")
+		if lastID == "" {
+			lastID = query.Get("last_event_id")
+		}
+		flusher, ok := writer.(http.Flusher)
+		if !ok {
+			utils.RenderFail(writer, request, utils.SCodeUnknow)
+			return
+		}
+		// 长连接不受 server 的 WriteTimeout 限制, 连接由客户端或者服务退出时关闭
+		_ = http.NewResponseController(writer).SetWriteDeadline(time.Time{})
+
+		client, backlog, reset := broker.Subscribe(filter, lastID)
+		defer client.Close()
+
+		writer.Header().Set("Content-Type", "text/event-stream")
+		writer.Header().Set("Cache-Control", "no-cache")
+		writer.Header().Set("X-Accel-Buffering", "no")
+		writer.WriteHeader(http.StatusOK)
+		fmt.Fprintf(writer, "retry: %d\n\n", retryInterval)
+		if reset {
+			fmt.Fprint(writer, "event: reset\ndata: \n\n")
+		}
+		for _, event := range backlog {
+			writeEvent(writer, event)
+		}
+		flusher.Flush()
+
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-client.C:
+				if !ok {
+					return
+				}
+				writeEvent(writer, event)
+			case <-ticker.C:
+				fmt.Fprint(writer, ": ping\n\n")
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(writer http.ResponseWriter, event *stream.Event) {
+	data, err := json.Marshal(event.Change)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(writer, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Change.Op, data)
+}
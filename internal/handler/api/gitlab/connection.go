@@ -0,0 +1,159 @@
+package gitlab
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	gitlabsvc "github.com/bloodsteel/easynetes/internal/service/gitlab"
+	"github.com/bloodsteel/easynetes/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// connectionRequest 创建和更新连接的请求体, 更新时 token 为空表示保留原有的令牌
+type connectionRequest struct {
+	Name    string `json:"name"`
+	BaseURL string `json:"base_url"`
+	Token   string `json:"token"`
+}
+
+// ListConnections 返回所有 GitLab 连接, 不包含令牌
+func ListConnections(connectionDao core.GitlabConnectionDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		connections, err := connectionDao.List(request.Context())
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, connections)
+	}
+}
+
+// CreateConnection 创建一个连接并立即测试, 请求体: {"name", "base_url", "token"}
+func CreateConnection(gitlab *gitlabsvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		in := new(connectionRequest)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		conn := &core.GitlabConnection{Name: in.Name, BaseURL: in.BaseURL}
+		if user, ok := middleware.GetUserFromCtx(ctx); ok {
+			conn.Creator = user.UserName
+		}
+		out, err := gitlab.CreateConnection(ctx, conn, in.Token)
+		if err != nil {
+			renderGitlabError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, out)
+	}
+}
+
+// UpdateConnection 更新连接的名称、地址或者令牌并重新测试
+func UpdateConnection(gitlab *gitlabsvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		connectionID, ok := idParam(writer, request, "connectionID")
+		if !ok {
+			return
+		}
+		in := new(connectionRequest)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		conn := &core.GitlabConnection{ID: connectionID, Name: in.Name, BaseURL: in.BaseURL}
+		out, err := gitlab.UpdateConnection(request.Context(), conn, in.Token)
+		if err != nil {
+			renderGitlabError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, out)
+	}
+}
+
+// DeleteConnection 删除一个没有导入项目的连接
+func DeleteConnection(gitlab *gitlabsvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		connectionID, ok := idParam(writer, request, "connectionID")
+		if !ok {
+			return
+		}
+		if err := gitlab.DeleteConnection(request.Context(), connectionID); err != nil {
+			renderGitlabError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, nil)
+	}
+}
+
+// TestConnection 立即测试一次连接, 测试结果保存在连接的 status/last_error 中
+func TestConnection(connectionDao core.GitlabConnectionDao, gitlab *gitlabsvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		connectionID, ok := idParam(writer, request, "connectionID")
+		if !ok {
+			return
+		}
+		conn, err := connectionDao.Get(ctx, connectionID)
+		if err != nil {
+			renderGitlabError(writer, request, err)
+			return
+		}
+		gitlab.Test(ctx, conn)
+		utils.RenderSuccess(writer, request, conn)
+	}
+}
+
+// SearchRemoteProjects 搜索连接中可以导入的项目, 查询参数: search, page; 下一页的页码在 Next-Page 头中, 没有下一页时为 0
+func SearchRemoteProjects(gitlab *gitlabsvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		connectionID, ok := idParam(writer, request, "connectionID")
+		if !ok {
+			return
+		}
+		query := request.URL.Query()
+		page := 1
+		if v := query.Get("page"); v != "" {
+			var err error
+			if page, err = strconv.Atoi(v); err != nil {
+				utils.RenderFail(writer, request, utils.SCodeBadRequestWithQueryParamErr)
+				return
+			}
+		}
+		projects, next, err := gitlab.SearchProjects(request.Context(), connectionID, query.Get("search"), page)
+		if err != nil {
+			renderGitlabError(writer, request, err)
+			return
+		}
+		writer.Header().Set("Next-Page", strconv.Itoa(next))
+		utils.RenderSuccess(writer, request, projects)
+	}
+}
+
+// idParam 解析路径中的ID, 解析失败时已经返回了错误
+func idParam(writer http.ResponseWriter, request *http.Request, name string) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(request, name), 10, 64)
+	if err != nil {
+		utils.RenderFail(writer, request, utils.SCodeBadRequestWithPathParamErr)
+		return 0, false
+	}
+	return id, true
+}
+
+// renderGitlabError 将 GitLab 相关的错误转换为对应的状态码
+func renderGitlabError(writer http.ResponseWriter, request *http.Request, err error) {
+	var apiErr *gitlabsvc.APIError
+	switch {
+	case errors.Is(err, core.ErrInvalidGitlabConnection), errors.Is(err, core.ErrGitlabConnectionInUse):
+		utils.RenderError(writer, request, utils.SCodeBadRequestWithGitlab, err)
+	case errors.As(err, &apiErr):
+		utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithGitlab, err)
+	default:
+		utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+	}
+}
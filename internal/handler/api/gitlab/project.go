@@ -0,0 +1,169 @@
+package gitlab
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	gitlabsvc "github.com/bloodsteel/easynetes/internal/service/gitlab"
+	"github.com/bloodsteel/easynetes/internal/utils"
+)
+
+// importRequest 导入项目的请求体
+type importRequest struct {
+	ConnectionID  int64 `json:"connection_id"`
+	GitlabID      int64 `json:"gitlab_id"`
+	ServiceNodeID int64 `json:"service_node_id"`
+}
+
+// ListProjects 返回导入的项目列表, 支持按 connection_id/service_node_id/path 过滤, 不包含分支和标签
+func ListProjects(projectDao core.GitlabProjectDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		limit, offset, _ := middleware.GetPaginateFromCtx(ctx)
+		query := request.URL.Query()
+		params := map[string]interface{}{"path": query.Get("path")}
+		for _, key := range []string{"connection_id", "service_node_id"} {
+			if v := query.Get(key); v != "" {
+				id, err := strconv.ParseInt(v, 10, 64)
+				if err != nil {
+					utils.RenderFail(writer, request, utils.SCodeBadRequestWithQueryParamErr)
+					return
+				}
+				params[key] = id
+			}
+		}
+		count, err := projectDao.Count(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		params["limit"] = limit
+		params["offset"] = offset
+		projects, err := projectDao.List(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		writer.Header().Set("Count", strconv.FormatInt(count, 10))
+		utils.RenderSuccess(writer, request, projects)
+	}
+}
+
+// GetProject 返回单个项目, 包含缓存的分支和标签
+func GetProject(projectDao core.GitlabProjectDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		projectID, ok := idParam(writer, request, "projectID")
+		if !ok {
+			return
+		}
+		project, err := projectDao.Get(request.Context(), projectID)
+		if err != nil {
+			renderGitlabError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, project)
+	}
+}
+
+// ImportProject 导入项目并绑定到服务树节点, 请求体: {"connection_id", "gitlab_id", "service_node_id"}
+func ImportProject(gitlab *gitlabsvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		in := new(importRequest)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		if in.ConnectionID == 0 || in.GitlabID == 0 {
+			utils.RenderFail(writer, request, utils.SCodeBadRequestWithGitlab)
+			return
+		}
+		var creator string
+		if user, ok := middleware.GetUserFromCtx(ctx); ok {
+			creator = user.UserName
+		}
+		project, err := gitlab.Import(ctx, in.ConnectionID, in.GitlabID, in.ServiceNodeID, creator)
+		if err != nil {
+			renderGitlabError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, project)
+	}
+}
+
+// LinkProject 修改项目绑定的服务树节点, 请求体: {"service_node_id"}
+func LinkProject(gitlab *gitlabsvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		projectID, ok := idParam(writer, request, "projectID")
+		if !ok {
+			return
+		}
+		in := new(importRequest)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		project, err := gitlab.Link(request.Context(), projectID, in.ServiceNodeID)
+		if err != nil {
+			renderGitlabError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, project)
+	}
+}
+
+// DeleteProject 删除导入的项目, 不会修改 GitLab 中的项目
+func DeleteProject(projectDao core.GitlabProjectDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		projectID, ok := idParam(writer, request, "projectID")
+		if !ok {
+			return
+		}
+		if err := projectDao.Delete(request.Context(), projectID); err != nil {
+			renderGitlabError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, nil)
+	}
+}
+
+// ListRefs 返回缓存的分支和标签, 用于发布时选择版本, 查询参数: kind=branch|tag, prefix
+func ListRefs(projectDao core.GitlabProjectDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		projectID, ok := idParam(writer, request, "projectID")
+		if !ok {
+			return
+		}
+		query := request.URL.Query()
+		kind := query.Get("kind")
+		if kind != "" && kind != core.GitlabBranch && kind != core.GitlabTag {
+			utils.RenderFail(writer, request, utils.SCodeBadRequestWithQueryParamErr)
+			return
+		}
+		project, err := projectDao.Get(request.Context(), projectID)
+		if err != nil {
+			renderGitlabError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, gitlabsvc.FilterRefs(project.Refs, kind, query.Get("prefix")))
+	}
+}
+
+// SyncRefs 立即同步项目的分支和标签, 同步失败的原因记录在 refs_error 中
+func SyncRefs(gitlab *gitlabsvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		projectID, ok := idParam(writer, request, "projectID")
+		if !ok {
+			return
+		}
+		project, err := gitlab.SyncRefs(request.Context(), projectID)
+		if err != nil {
+			renderGitlabError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, project)
+	}
+}
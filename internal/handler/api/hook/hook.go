@@ -0,0 +1,115 @@
+package hook
+
+import (
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	hooksvc "github.com/bloodsteel/easynetes/internal/service/hook"
+	"github.com/bloodsteel/easynetes/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxPayloadSize 事件请求体的最大字节数, GitLab 的 push 事件最多包含 20 个提交
+const maxPayloadSize = 4 << 20
+
+// receipt 接收事件的响应, 重复投递的事件 duplicate 为 true, 不会再次发布
+type receipt struct {
+	ID        int64 `json:"id,omitempty"`
+	Duplicate bool  `json:"duplicate"`
+}
+
+// ReceiveGitlab 接收 GitLab 的 webhook, 使用 X-Gitlab-Token 头校验令牌
+func ReceiveGitlab(receiver *hooksvc.Receiver, token string) http.HandlerFunc {
+	return receive(receiver, token, hooksvc.ParseGitlab, func(request *http.Request) string {
+		return request.Header.Get("X-Gitlab-Token")
+	})
+}
+
+// ReceiveJenkins 接收 Jenkins Notification 插件的通知, 使用 X-Jenkins-Token 头或者 token 查询参数校验令牌
+func ReceiveJenkins(receiver *hooksvc.Receiver, token string) http.HandlerFunc {
+	return receive(receiver, token, hooksvc.ParseJenkins, func(request *http.Request) string {
+		if got := request.Header.Get("X-Jenkins-Token"); got != "" {
+			return got
+		}
+		return request.URL.Query().Get("token")
+	})
+}
+
+func receive(
+	receiver *hooksvc.Receiver,
+	token string,
+	parse func(http.Header, []byte) (*core.HookEvent, error),
+	credential func(*http.Request) string,
+) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if token == "" || subtle.ConstantTimeCompare([]byte(credential(request)), []byte(token)) != 1 {
+			utils.RenderFail(writer, request, utils.SCodeUnauthenticateWithHookToken)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(writer, request.Body, maxPayloadSize))
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeBadRequestWithHookPayload, err)
+			return
+		}
+		event, err := parse(request.Header, body)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeBadRequestWithHookPayload, err)
+			return
+		}
+		created, err := receiver.Receive(request.Context(), event)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, &receipt{ID: event.ID, Duplicate: !created})
+	}
+}
+
+// ListEvents 返回接收的事件, 支持按 source/event_type/project 过滤, 列表中不包含原始请求体
+func ListEvents(eventDao core.HookEventDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		limit, offset, _ := middleware.GetPaginateFromCtx(ctx)
+		query := request.URL.Query()
+		params := map[string]interface{}{
+			"source":     query.Get("source"),
+			"event_type": query.Get("event_type"),
+			"project":    query.Get("project"),
+		}
+		count, err := eventDao.Count(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		params["limit"] = limit
+		params["offset"] = offset
+		events, err := eventDao.List(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		writer.Header().Set("Count", strconv.FormatInt(count, 10))
+		utils.RenderSuccess(writer, request, events)
+	}
+}
+
+// GetEvent 返回单个事件, 包含原始请求体
+func GetEvent(eventDao core.HookEventDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		eventID, err := strconv.ParseInt(chi.URLParam(request, "eventID"), 10, 64)
+		if err != nil {
+			utils.RenderFail(writer, request, utils.SCodeBadRequestWithPathParamErr)
+			return
+		}
+		event, err := eventDao.Get(request.Context(), eventID)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, event)
+	}
+}
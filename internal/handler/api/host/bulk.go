@@ -0,0 +1,65 @@
+package host
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	"github.com/bloodsteel/easynetes/internal/service/queue"
+	"github.com/bloodsteel/easynetes/internal/utils"
+)
+
+// importRequest 批量导入主机的请求体, priority 是后台任务的优先级
+type importRequest struct {
+	queue.HostImportPayload
+	Priority int `json:"priority"`
+}
+
+// pushRequest 分发文件的请求体, priority 是后台任务的优先级
+type pushRequest struct {
+	queue.FilePushPayload
+	Priority int `json:"priority"`
+}
+
+// ImportHosts 创建后台任务批量导入主机, 实例ID已经存在的主机跳过, 返回202和任务
+// 请求体: {"hosts": [{"instance_id", "host_name", ...}], "priority"}
+func ImportHosts(tasks *queue.Queue) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		in := new(importRequest)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		enqueue(writer, request, tasks, queue.TaskHostImport, in.HostImportPayload, in.Priority)
+	}
+}
+
+// PushFile 创建后台任务通过 agent 将文件写入一组主机, 返回202和任务
+// 请求体: {"host_ids", "kube_cluster_id", "path": "/etc/app.conf", "content": "<base64>", "mode": "0644", "priority"}
+func PushFile(tasks *queue.Queue) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		in := new(pushRequest)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		enqueue(writer, request, tasks, queue.TaskFilePush, in.FilePushPayload, in.Priority)
+	}
+}
+
+func enqueue(writer http.ResponseWriter, request *http.Request, tasks *queue.Queue,
+	taskType string, payload interface{}, priority int) {
+	ctx := request.Context()
+	user, _ := middleware.GetUserFromCtx(ctx)
+	task, err := tasks.Enqueue(ctx, taskType, payload, priority, user.UserName)
+	switch {
+	case errors.Is(err, core.ErrInvalidTask):
+		utils.RenderError(writer, request, utils.SCodeBadRequestWithTask, err)
+	case err != nil:
+		utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+	default:
+		utils.RenderAccepted(writer, request, task)
+	}
+}
@@ -2,12 +2,75 @@ package host
 
 import (
 	"net/http"
+	"strconv"
 
 	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	"github.com/bloodsteel/easynetes/internal/service/approval"
+	"github.com/bloodsteel/easynetes/internal/utils"
+	"github.com/go-chi/chi/v5"
 )
 
+// ListHosts 返回主机列表, 支持按 host_status/kube_cluster_id 过滤
 func ListHosts(hostDao core.HostInstanceDao) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		limit, offset, _ := middleware.GetPaginateFromCtx(ctx)
+		query := request.URL.Query()
+		params := map[string]interface{}{}
+		for _, key := range []string{"host_status", "kube_cluster_id"} {
+			if v := query.Get(key); v != "" {
+				n, err := strconv.ParseInt(v, 10, 64)
+				if err != nil {
+					utils.RenderFail(writer, request, utils.SCodeBadRequestWithQueryParamErr)
+					return
+				}
+				params[key] = n
+			}
+		}
+		count, err := hostDao.Count(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		params["limit"] = limit
+		params["offset"] = offset
+		hosts, err := hostDao.List(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		writer.Header().Set("Count", strconv.FormatInt(count, 10))
+		utils.RenderSuccess(writer, request, hosts)
+	}
+}
+
+// DeleteHost 删除主机, 匹配到审批规则时返回202和审批请求, 审批通过后才删除
+// 查询参数 reason 是申请删除的原因, 记录在审批请求中
+func DeleteHost(hostDao core.HostInstanceDao, approvals *approval.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		hostID, err := strconv.ParseInt(chi.URLParam(request, "hostID"), 10, 64)
+		if err != nil {
+			utils.RenderFail(writer, request, utils.SCodeBadRequestWithPathParamErr)
+			return
+		}
+		user, _ := middleware.GetUserFromCtx(ctx)
+		req, err := approvals.Submit(ctx, core.ApprovalHostDelete, approval.HostDeletePayload{HostID: hostID},
+			request.URL.Query().Get("reason"), user)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		if req != nil {
+			utils.RenderAccepted(writer, request, req)
+			return
+		}
+		if err := hostDao.Delete(ctx, hostID); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, nil)
 	}
 }
 
@@ -0,0 +1,89 @@
+package host
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/metrics"
+	"github.com/bloodsteel/easynetes/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// defaultMetricsRange 未指定 from 时默认查询最近一小时的数据
+const defaultMetricsRange = time.Hour
+
+// GetMetrics 查询主机的监控指标
+// 查询参数: metric 指标名称; from/to 时间范围, unix 秒或者 RFC3339 格式; step 聚合间隔(秒), 不指定时自动选择
+func GetMetrics(hostDao core.HostInstanceDao, agentDao core.AgentDao, service *metrics.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		hostID, err := strconv.ParseInt(chi.URLParam(request, "hostID"), 10, 64)
+		if err != nil {
+			utils.RenderFail(writer, request, utils.SCodeBadRequestWithPathParamErr)
+			return
+		}
+		query := request.URL.Query()
+		metric := query.Get("metric")
+		if !core.IsMetric(metric) {
+			utils.RenderFail(writer, request, utils.SCodeBadRequestWithMetricQuery)
+			return
+		}
+		now := time.Now()
+		to, err := parseTime(query.Get("to"), now)
+		if err != nil {
+			utils.RenderFail(writer, request, utils.SCodeBadRequestWithMetricQuery)
+			return
+		}
+		from, err := parseTime(query.Get("from"), to.Add(-defaultMetricsRange))
+		if err != nil || !from.Before(to) {
+			utils.RenderFail(writer, request, utils.SCodeBadRequestWithMetricQuery)
+			return
+		}
+		var step int64
+		if v := query.Get("step"); v != "" {
+			if step, err = strconv.ParseInt(v, 10, 64); err != nil || step < 0 {
+				utils.RenderFail(writer, request, utils.SCodeBadRequestWithMetricQuery)
+				return
+			}
+		}
+
+		host, err := hostDao.Get(ctx, hostID)
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.RenderFail(writer, request, utils.SCodeNotFoundWithDao)
+			return
+		} else if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		agent, err := agentDao.GetByInstance(ctx, host.InstanceID)
+		if err == sql.ErrNoRows {
+			utils.RenderFail(writer, request, utils.SCodeNotFoundWithDao)
+			return
+		} else if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		series, err := service.Query(ctx, agent.ID, metric, from, to, step)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		series.HostID = hostID
+		utils.RenderSuccess(writer, request, series)
+	}
+}
+
+// parseTime 解析 unix 秒或者 RFC3339 格式的时间, 为空时返回 def
+func parseTime(v string, def time.Time) (time.Time, error) {
+	if v == "" {
+		return def, nil
+	}
+	if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
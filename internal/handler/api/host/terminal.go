@@ -0,0 +1,144 @@
+package host
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	"github.com/bloodsteel/easynetes/internal/service/agenthub"
+	"github.com/bloodsteel/easynetes/internal/service/terminal"
+	"github.com/bloodsteel/easynetes/internal/utils"
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+)
+
+const writeWait = 10 * time.Second
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+}
+
+// terminalMessage 浏览器与 apiserver 之间的终端控制消息
+// 浏览器发送 input/resize, apiserver 在会话结束时发送 exit; 终端输出直接以 binary 消息发送
+type terminalMessage struct {
+	Type   string `json:"type"`
+	Data   string `json:"data,omitempty"`
+	Cols   uint16 `json:"cols,omitempty"`
+	Rows   uint16 `json:"rows,omitempty"`
+	Code   int    `json:"code,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// HandleTerminal 通过主机上的 agent 打开一个 web 终端, 查询参数 cols/rows 指定初始窗口大小
+func HandleTerminal(
+	hostDao core.HostInstanceDao,
+	agentDao core.AgentDao,
+	authorizer core.Authorizer,
+	manager *terminal.Manager,
+) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		hostID, err := strconv.ParseInt(chi.URLParam(request, "hostID"), 10, 64)
+		if err != nil {
+			utils.RenderFail(writer, request, utils.SCodeBadRequestWithPathParamErr)
+			return
+		}
+		user, _ := middleware.GetUserFromCtx(ctx)
+		if err := authorizer.Authorize(ctx, user, core.ResourceHost, strconv.FormatInt(hostID, 10), core.ActionTerminal); err != nil {
+			if errors.Is(err, core.ErrForbidden) {
+				utils.RenderFail(writer, request, utils.SCodeForbidden)
+			} else {
+				utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			}
+			return
+		}
+		host, err := hostDao.Get(ctx, hostID)
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.RenderFail(writer, request, utils.SCodeNotFoundWithDao)
+			return
+		} else if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		agent, err := agentDao.GetByInstance(ctx, host.InstanceID)
+		if err == sql.ErrNoRows {
+			utils.RenderFail(writer, request, utils.SCodeBadRequestWithAgentOffline)
+			return
+		} else if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		cols, rows := windowSize(request)
+
+		ws, err := upgrader.Upgrade(writer, request, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		session, err := manager.Open(ctx, hostID, agent, user, cols, rows)
+		if err != nil {
+			reason := err.Error()
+			if errors.Is(err, agenthub.ErrAgentOffline) {
+				reason = utils.Msg[utils.SCodeBadRequestWithAgentOffline]
+			}
+			_ = ws.WriteJSON(&terminalMessage{Type: "exit", Code: -1, Reason: reason})
+			return
+		}
+
+		go pumpOutput(ws, session)
+		for {
+			in := new(terminalMessage)
+			if err := ws.ReadJSON(in); err != nil {
+				session.Close(terminal.ReasonClientClosed)
+				return
+			}
+			switch in.Type {
+			case "input":
+				err = session.Input([]byte(in.Data))
+			case "resize":
+				if in.Cols > 0 && in.Rows > 0 {
+					err = session.Resize(in.Cols, in.Rows)
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// pumpOutput 将终端输出写给浏览器, 会话结束后发送 exit 消息并关闭连接
+func pumpOutput(ws *websocket.Conn, session *terminal.Session) {
+	defer ws.Close()
+	for {
+		select {
+		case data := <-session.Output():
+			_ = ws.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := ws.WriteMessage(websocket.BinaryMessage, data); err != nil {
+				session.Close(terminal.ReasonClientClosed)
+				return
+			}
+		case <-session.Done():
+			_ = ws.SetWriteDeadline(time.Now().Add(writeWait))
+			_ = ws.WriteJSON(&terminalMessage{Type: "exit", Code: session.ExitCode, Reason: session.CloseReason})
+			return
+		}
+	}
+}
+
+func windowSize(request *http.Request) (uint16, uint16) {
+	cols, err := strconv.ParseUint(request.URL.Query().Get("cols"), 10, 16)
+	if err != nil || cols == 0 {
+		cols = 80
+	}
+	rows, err := strconv.ParseUint(request.URL.Query().Get("rows"), 10, 16)
+	if err != nil || rows == 0 {
+		rows = 24
+	}
+	return uint16(cols), uint16(rows)
+}
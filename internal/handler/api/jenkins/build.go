@@ -0,0 +1,83 @@
+package jenkins
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	jenkinssvc "github.com/bloodsteel/easynetes/internal/service/jenkins"
+	"github.com/bloodsteel/easynetes/internal/utils"
+)
+
+// TriggerBuild 触发任务的构建, 请求体: {"parameters": {"BRANCH": "main"}}, 传入的参数覆盖任务的默认参数
+// 需要任务所属应用或者服务树节点的 build 授权
+func TriggerBuild(jenkins *jenkinssvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		jobID, ok := idParam(writer, request, "jobID")
+		if !ok {
+			return
+		}
+		in := new(struct {
+			Parameters map[string]string `json:"parameters"`
+		})
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		user, _ := middleware.GetUserFromCtx(ctx)
+		build, err := jenkins.Trigger(ctx, jobID, in.Parameters, user)
+		if err != nil {
+			renderJenkinsError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, build)
+	}
+}
+
+// ListBuilds 返回构建记录, 支持按 job_id/status/creator 过滤, 列表中不包含控制台日志
+func ListBuilds(buildDao core.JenkinsBuildDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		limit, offset, _ := middleware.GetPaginateFromCtx(ctx)
+		params, ok := idQuery(writer, request, "job_id")
+		if !ok {
+			return
+		}
+		query := request.URL.Query()
+		params["status"] = query.Get("status")
+		params["creator"] = query.Get("creator")
+		count, err := buildDao.Count(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		params["limit"] = limit
+		params["offset"] = offset
+		builds, err := buildDao.List(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		writer.Header().Set("Count", strconv.FormatInt(count, 10))
+		utils.RenderSuccess(writer, request, builds)
+	}
+}
+
+// GetBuild 返回单个构建记录, 包含控制台日志的末尾部分
+func GetBuild(buildDao core.JenkinsBuildDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		buildID, ok := idParam(writer, request, "buildID")
+		if !ok {
+			return
+		}
+		build, err := buildDao.Get(request.Context(), buildID)
+		if err != nil {
+			renderJenkinsError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, build)
+	}
+}
@@ -0,0 +1,130 @@
+package jenkins
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	jenkinssvc "github.com/bloodsteel/easynetes/internal/service/jenkins"
+	"github.com/bloodsteel/easynetes/internal/utils"
+)
+
+// ListJobs 返回关联的任务列表, 支持按 server_id/app_id/service_node_id 过滤
+func ListJobs(jobDao core.JenkinsJobDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		limit, offset, _ := middleware.GetPaginateFromCtx(ctx)
+		params, ok := idQuery(writer, request, "server_id", "app_id", "service_node_id")
+		if !ok {
+			return
+		}
+		count, err := jobDao.Count(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		params["limit"] = limit
+		params["offset"] = offset
+		jobs, err := jobDao.List(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		writer.Header().Set("Count", strconv.FormatInt(count, 10))
+		utils.RenderSuccess(writer, request, jobs)
+	}
+}
+
+// GetJob 返回单个任务
+func GetJob(jobDao core.JenkinsJobDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		jobID, ok := idParam(writer, request, "jobID")
+		if !ok {
+			return
+		}
+		job, err := jobDao.Get(request.Context(), jobID)
+		if err != nil {
+			renderJenkinsError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, job)
+	}
+}
+
+// CreateJob 关联一个任务, 请求体: {"server_id", "name", "app_id", "service_node_id", "parameters"}
+func CreateJob(jenkins *jenkinssvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		in := new(core.JenkinsJob)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		in.ID = 0
+		if user, ok := middleware.GetUserFromCtx(ctx); ok {
+			in.Creator = user.UserName
+		}
+		out, err := jenkins.SaveJob(ctx, in)
+		if err != nil {
+			renderJenkinsError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, out)
+	}
+}
+
+// UpdateJob 更新任务的路径、关联的应用或服务树节点和默认参数
+func UpdateJob(jenkins *jenkinssvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		jobID, ok := idParam(writer, request, "jobID")
+		if !ok {
+			return
+		}
+		in := new(core.JenkinsJob)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		in.ID = jobID
+		out, err := jenkins.SaveJob(request.Context(), in)
+		if err != nil {
+			renderJenkinsError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, out)
+	}
+}
+
+// DeleteJob 删除任务的关联, 不会修改 Jenkins 中的任务
+func DeleteJob(jobDao core.JenkinsJobDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		jobID, ok := idParam(writer, request, "jobID")
+		if !ok {
+			return
+		}
+		if err := jobDao.Delete(request.Context(), jobID); err != nil {
+			renderJenkinsError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, nil)
+	}
+}
+
+// idQuery 将查询参数中的ID解析为过滤条件, 解析失败时已经返回了错误
+func idQuery(writer http.ResponseWriter, request *http.Request, keys ...string) (map[string]interface{}, bool) {
+	params := map[string]interface{}{}
+	query := request.URL.Query()
+	for _, key := range keys {
+		if v := query.Get(key); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				utils.RenderFail(writer, request, utils.SCodeBadRequestWithQueryParamErr)
+				return nil, false
+			}
+			params[key] = id
+		}
+	}
+	return params, true
+}
@@ -0,0 +1,141 @@
+package jenkins
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	jenkinssvc "github.com/bloodsteel/easynetes/internal/service/jenkins"
+	"github.com/bloodsteel/easynetes/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// serverRequest 创建和更新服务器的请求体, 更新时 token 为空表示保留原有的 API Token
+type serverRequest struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	Username string `json:"username"`
+	Token    string `json:"token"`
+}
+
+func (in *serverRequest) server() *core.JenkinsServer {
+	return &core.JenkinsServer{Name: in.Name, URL: in.URL, Username: in.Username}
+}
+
+// ListServers 返回所有 Jenkins 服务器, 不包含 API Token
+func ListServers(serverDao core.JenkinsServerDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		servers, err := serverDao.List(request.Context())
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, servers)
+	}
+}
+
+// CreateServer 注册一个服务器并立即测试, 请求体: {"name", "url", "username", "token"}
+func CreateServer(jenkins *jenkinssvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		in := new(serverRequest)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		server := in.server()
+		if user, ok := middleware.GetUserFromCtx(ctx); ok {
+			server.Creator = user.UserName
+		}
+		out, err := jenkins.CreateServer(ctx, server, in.Token)
+		if err != nil {
+			renderJenkinsError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, out)
+	}
+}
+
+// UpdateServer 更新服务器的名称、地址或者认证信息并重新测试
+func UpdateServer(jenkins *jenkinssvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		serverID, ok := idParam(writer, request, "serverID")
+		if !ok {
+			return
+		}
+		in := new(serverRequest)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		server := in.server()
+		server.ID = serverID
+		out, err := jenkins.UpdateServer(request.Context(), server, in.Token)
+		if err != nil {
+			renderJenkinsError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, out)
+	}
+}
+
+// DeleteServer 删除一个没有关联任务的服务器
+func DeleteServer(jenkins *jenkinssvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		serverID, ok := idParam(writer, request, "serverID")
+		if !ok {
+			return
+		}
+		if err := jenkins.DeleteServer(request.Context(), serverID); err != nil {
+			renderJenkinsError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, nil)
+	}
+}
+
+// TestServer 立即测试一次服务器, 测试结果保存在服务器的 status/last_error 中
+func TestServer(serverDao core.JenkinsServerDao, jenkins *jenkinssvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		serverID, ok := idParam(writer, request, "serverID")
+		if !ok {
+			return
+		}
+		server, err := serverDao.Get(ctx, serverID)
+		if err != nil {
+			renderJenkinsError(writer, request, err)
+			return
+		}
+		jenkins.Test(ctx, server)
+		utils.RenderSuccess(writer, request, server)
+	}
+}
+
+// idParam 解析路径中的ID, 解析失败时已经返回了错误
+func idParam(writer http.ResponseWriter, request *http.Request, name string) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(request, name), 10, 64)
+	if err != nil {
+		utils.RenderFail(writer, request, utils.SCodeBadRequestWithPathParamErr)
+		return 0, false
+	}
+	return id, true
+}
+
+// renderJenkinsError 将 Jenkins 相关的错误转换为对应的状态码
+func renderJenkinsError(writer http.ResponseWriter, request *http.Request, err error) {
+	var apiErr *jenkinssvc.APIError
+	switch {
+	case errors.Is(err, core.ErrInvalidJenkins), errors.Is(err, core.ErrJenkinsServerInUse):
+		utils.RenderError(writer, request, utils.SCodeBadRequestWithJenkins, err)
+	case errors.As(err, &apiErr):
+		utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithJenkins, err)
+	case errors.Is(err, core.ErrForbidden):
+		utils.RenderFail(writer, request, utils.SCodeForbidden)
+	default:
+		utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+	}
+}
@@ -0,0 +1,113 @@
+package k8s
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	"github.com/bloodsteel/easynetes/internal/service/kube"
+	"github.com/bloodsteel/easynetes/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// ListAccessGrants 返回集群中的授权, 支持按 namespace、subject_kind 和 user_id 过滤
+func ListAccessGrants(grantDao core.KubeAccessGrantDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		clusterID, ok := clusterIDParam(writer, request)
+		if !ok {
+			return
+		}
+		limit, offset, _ := middleware.GetPaginateFromCtx(ctx)
+		query := request.URL.Query()
+		userID, _ := strconv.ParseInt(query.Get("user_id"), 10, 64)
+		params := map[string]interface{}{
+			"cluster_id":   clusterID,
+			"namespace":    query.Get("namespace"),
+			"subject_kind": query.Get("subject_kind"),
+			"user_id":      userID,
+		}
+		count, err := grantDao.Count(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		params["limit"] = limit
+		params["offset"] = offset
+		grants, err := grantDao.List(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		writer.Header().Set("Count", strconv.FormatInt(count, 10))
+		utils.RenderSuccess(writer, request, grants)
+	}
+}
+
+// CreateAccessGrant 将 namespace 的 view/edit/admin 角色授予用户或者用户组, 并在集群中创建对应的对象
+// 请求体: {"namespace", "subject_kind": "user|group", "subject_name", "user_id", "role", "mode": "serviceaccount|rolebinding"}
+func CreateAccessGrant(access *kube.Access) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		clusterID, ok := clusterIDParam(writer, request)
+		if !ok {
+			return
+		}
+		in := new(core.KubeAccessGrant)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		in.ClusterID = clusterID
+		if user, ok := middleware.GetUserFromCtx(ctx); ok {
+			in.Creator = user.UserName
+		}
+		out, err := access.Grant(ctx, in)
+		if err != nil {
+			renderKubeError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, out)
+	}
+}
+
+// RevokeAccessGrant 撤销授权, 删除集群中对应的 RoleBinding
+func RevokeAccessGrant(access *kube.Access) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		grantID, err := strconv.ParseInt(chi.URLParam(request, "grantID"), 10, 64)
+		if err != nil {
+			utils.RenderFail(writer, request, utils.SCodeBadRequestWithPathParamErr)
+			return
+		}
+		if err := access.Revoke(request.Context(), grantID); err != nil {
+			renderKubeError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, nil)
+	}
+}
+
+// DownloadKubeconfig 下载当前用户在 namespace 中的短期 kubeconfig, 需要 serviceaccount 方式的授权
+func DownloadKubeconfig(access *kube.Access) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		clusterID, ok := clusterIDParam(writer, request)
+		if !ok {
+			return
+		}
+		namespace := chi.URLParam(request, "namespace")
+		user, _ := middleware.GetUserFromCtx(ctx)
+		out, err := access.Kubeconfig(ctx, clusterID, namespace, user)
+		if err != nil {
+			renderKubeError(writer, request, err)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/yaml")
+		writer.Header().Set("Content-Disposition", `attachment; filename="kubeconfig-`+namespace+`.yaml"`)
+		writer.Header().Set("Expires", out.ExpireTime.UTC().Format(http.TimeFormat))
+		writer.Header().Set("Cache-Control", "no-store")
+		_, _ = writer.Write(out.Data)
+	}
+}
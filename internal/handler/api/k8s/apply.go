@@ -0,0 +1,84 @@
+package k8s
+
+import (
+	"database/sql"
+	"io"
+	"net/http"
+
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	"github.com/bloodsteel/easynetes/internal/service/kube"
+	"github.com/bloodsteel/easynetes/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxManifestSize YAML 清单的最大长度
+const maxManifestSize = 2 << 20
+
+// PlanApply 对请求体中的多文档 YAML 执行 server-side dry-run, 返回每个对象的 diff 和变更计划ID
+func PlanApply(applier *kube.Applier) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		clusterID, ok := clusterIDParam(writer, request)
+		if !ok {
+			return
+		}
+		manifest, err := io.ReadAll(http.MaxBytesReader(writer, request.Body, maxManifestSize))
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeBadRequestWithManifest, err)
+			return
+		}
+		user, _ := middleware.GetUserFromCtx(ctx)
+		plan, err := applier.Plan(ctx, clusterID, manifest, user)
+		if err != nil {
+			renderKubeError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, plan)
+	}
+}
+
+// GetApplyPlan 返回变更计划和执行结果, 只有计划的创建人或者管理员可以查看
+func GetApplyPlan(applier *kube.Applier) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		clusterID, ok := clusterIDParam(writer, request)
+		if !ok {
+			return
+		}
+		user, _ := middleware.GetUserFromCtx(ctx)
+		plan, err := applier.Get(ctx, chi.URLParam(request, "planID"), user)
+		if err == nil && plan.ClusterID != clusterID {
+			err = sql.ErrNoRows
+		}
+		if err != nil {
+			renderKubeError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, plan)
+	}
+}
+
+// ConfirmApply 确认变更计划, 使用 server-side apply(field manager easynetes) 执行
+func ConfirmApply(applier *kube.Applier) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		clusterID, ok := clusterIDParam(writer, request)
+		if !ok {
+			return
+		}
+		planID := chi.URLParam(request, "planID")
+		user, _ := middleware.GetUserFromCtx(ctx)
+		plan, err := applier.Get(ctx, planID, user)
+		if err == nil && plan.ClusterID != clusterID {
+			err = sql.ErrNoRows
+		}
+		if err == nil {
+			plan, err = applier.Apply(ctx, planID, user)
+		}
+		if err != nil {
+			renderKubeError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, plan)
+	}
+}
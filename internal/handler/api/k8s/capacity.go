@@ -0,0 +1,52 @@
+package k8s
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/kube"
+	"github.com/bloodsteel/easynetes/internal/utils"
+	"github.com/bloodsteel/easynetes/pkg/log"
+)
+
+// GetCapacity 返回集群按节点池、节点和 namespace 统计的资源请求、限制和可分配量
+// 查询参数: cluster_id 为空时返回所有集群; pool_label 指定节点池标签; format=csv 时下载 CSV
+func GetCapacity(clusterDao core.KubeClusterDao, workloads *kube.Workloads) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		query := request.URL.Query()
+		var clusters []*core.KubeCluster
+		if v := query.Get("cluster_id"); v != "" {
+			clusterID, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				utils.RenderFail(writer, request, utils.SCodeBadRequestWithQueryParamErr)
+				return
+			}
+			cluster, err := clusterDao.Get(ctx, clusterID)
+			if err != nil {
+				utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+				return
+			}
+			clusters = append(clusters, cluster)
+		} else {
+			var err error
+			if clusters, err = clusterDao.List(ctx, map[string]interface{}{}); err != nil {
+				utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+				return
+			}
+		}
+		reports := workloads.CapacityReports(ctx, clusters, query.Get("pool_label"))
+		if query.Get("format") != "csv" {
+			utils.RenderSuccess(writer, request, reports)
+			return
+		}
+		filename := "capacity-" + time.Now().Format("20060102-150405") + ".csv"
+		writer.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		writer.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		if err := kube.WriteCapacityCSV(writer, reports); err != nil {
+			log.WithLabels("error", err).Warn("cannot write capacity csv")
+		}
+	}
+}
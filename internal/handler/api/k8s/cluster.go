@@ -0,0 +1,198 @@
+package k8s
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	"github.com/bloodsteel/easynetes/internal/service/kube"
+	"github.com/bloodsteel/easynetes/internal/utils"
+	"github.com/go-chi/chi/v5"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// clusterRequest 创建和更新集群的请求体
+// 更新时认证信息(kubeconfig/token)为空表示保留原有的认证信息
+type clusterRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	AuthType    string `json:"auth_type"`
+	Server      string `json:"server"`
+	core.KubeCredential
+}
+
+func (in *clusterRequest) cluster() *core.KubeCluster {
+	return &core.KubeCluster{
+		Name:        in.Name,
+		Description: in.Description,
+		AuthType:    in.AuthType,
+		Server:      in.Server,
+	}
+}
+
+// ListClusters 返回纳管的集群列表, 支持按 status 过滤
+func ListClusters(clusterDao core.KubeClusterDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		limit, offset, _ := middleware.GetPaginateFromCtx(ctx)
+		params := map[string]interface{}{
+			"status": request.URL.Query().Get("status"),
+		}
+		count, err := clusterDao.Count(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		params["limit"] = limit
+		params["offset"] = offset
+		clusters, err := clusterDao.List(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		writer.Header().Set("Count", strconv.FormatInt(count, 10))
+		utils.RenderSuccess(writer, request, clusters)
+	}
+}
+
+// CreateCluster 导入一个集群, 请求体: {"name", "description", "auth_type": "kubeconfig|token", "server", "kubeconfig", "token", "ca_data", "insecure"}
+func CreateCluster(registry *kube.Registry) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		in := new(clusterRequest)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		cluster := in.cluster()
+		if user, ok := middleware.GetUserFromCtx(ctx); ok {
+			cluster.Creator = user.UserName
+		}
+		out, err := registry.Create(ctx, cluster, &in.KubeCredential)
+		if err != nil {
+			renderKubeError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, out)
+	}
+}
+
+// GetCluster 返回单个集群
+func GetCluster(clusterDao core.KubeClusterDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		clusterID, ok := clusterIDParam(writer, request)
+		if !ok {
+			return
+		}
+		cluster, err := clusterDao.Get(request.Context(), clusterID)
+		if err != nil {
+			renderKubeError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, cluster)
+	}
+}
+
+// UpdateCluster 更新集群的基本信息或者认证信息
+func UpdateCluster(registry *kube.Registry) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		clusterID, ok := clusterIDParam(writer, request)
+		if !ok {
+			return
+		}
+		in := new(clusterRequest)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		cluster := in.cluster()
+		cluster.ID = clusterID
+		var cred *core.KubeCredential
+		if in.Kubeconfig != "" || in.Token != "" {
+			cred = &in.KubeCredential
+		}
+		out, err := registry.Update(request.Context(), cluster, cred)
+		if err != nil {
+			renderKubeError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, out)
+	}
+}
+
+// DeleteCluster 删除一个集群, 只删除纳管记录, 不会修改集群本身
+func DeleteCluster(registry *kube.Registry) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		clusterID, ok := clusterIDParam(writer, request)
+		if !ok {
+			return
+		}
+		if err := registry.Delete(request.Context(), clusterID); err != nil {
+			renderKubeError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, nil)
+	}
+}
+
+// ProbeCluster 立即检查一次集群的连通性
+func ProbeCluster(clusterDao core.KubeClusterDao, registry *kube.Registry) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		clusterID, ok := clusterIDParam(writer, request)
+		if !ok {
+			return
+		}
+		cluster, err := clusterDao.Get(ctx, clusterID)
+		if err != nil {
+			renderKubeError(writer, request, err)
+			return
+		}
+		registry.Probe(ctx, cluster)
+		utils.RenderSuccess(writer, request, cluster)
+	}
+}
+
+// clusterIDParam 解析路径中的 clusterID, 解析失败时已经返回了错误
+func clusterIDParam(writer http.ResponseWriter, request *http.Request) (int64, bool) {
+	clusterID, err := strconv.ParseInt(chi.URLParam(request, "clusterID"), 10, 64)
+	if err != nil {
+		utils.RenderFail(writer, request, utils.SCodeBadRequestWithPathParamErr)
+		return 0, false
+	}
+	return clusterID, true
+}
+
+// renderKubeError 将 kubernetes 相关的错误转换为对应的状态码
+func renderKubeError(writer http.ResponseWriter, request *http.Request, err error) {
+	var status apierrors.APIStatus
+	switch {
+	case errors.Is(err, core.ErrInvalidKubeCredential):
+		utils.RenderError(writer, request, utils.SCodeBadRequestWithKubeCredential, err)
+	case errors.Is(err, core.ErrInvalidKubeNamespace), errors.Is(err, kube.ErrNamespaceExists):
+		utils.RenderError(writer, request, utils.SCodeBadRequestWithKubeNamespace, err)
+	case errors.Is(err, core.ErrInvalidQuotaTemplate):
+		utils.RenderError(writer, request, utils.SCodeBadRequestWithQuotaTemplate, err)
+	case errors.Is(err, core.ErrUnsupportedWorkload), errors.Is(err, kube.ErrRevisionNotFound):
+		utils.RenderError(writer, request, utils.SCodeBadRequestWithKubeWorkload, err)
+	case errors.Is(err, core.ErrInvalidManifest):
+		utils.RenderError(writer, request, utils.SCodeBadRequestWithManifest, err)
+	case errors.Is(err, kube.ErrPlanExpired), errors.Is(err, kube.ErrPlanNotPending), errors.Is(err, kube.ErrPlanStale):
+		utils.RenderError(writer, request, utils.SCodeBadRequestWithApplyPlan, err)
+	case errors.Is(err, core.ErrInvalidKubeAccess):
+		utils.RenderError(writer, request, utils.SCodeBadRequestWithKubeAccess, err)
+	case errors.Is(err, core.ErrForbidden), errors.Is(err, kube.ErrNoServiceAccount):
+		utils.RenderFail(writer, request, utils.SCodeForbidden)
+	case errors.Is(err, kube.ErrCacheNotSynced):
+		utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithKubernetes, err)
+	case apierrors.IsNotFound(err):
+		utils.RenderError(writer, request, utils.SCodeNotFoundWithKubeObject, err)
+	case errors.As(err, &status):
+		utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithKubernetes, err)
+	default:
+		utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+	}
+}
@@ -0,0 +1,68 @@
+package k8s
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	"github.com/bloodsteel/easynetes/internal/utils"
+)
+
+// ListEvents 返回保存的 Warning 事件, 按最后发生时间倒序
+// 查询参数: cluster_id, namespace, kind, name, reason, since/until(unix 秒或者 RFC3339)
+func ListEvents(eventDao core.KubeEventDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		limit, offset, _ := middleware.GetPaginateFromCtx(ctx)
+		query := request.URL.Query()
+		params := map[string]interface{}{
+			"namespace": query.Get("namespace"),
+			"kind":      query.Get("kind"),
+			"name":      query.Get("name"),
+			"reason":    query.Get("reason"),
+		}
+		if v := query.Get("cluster_id"); v != "" {
+			clusterID, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				utils.RenderFail(writer, request, utils.SCodeBadRequestWithQueryParamErr)
+				return
+			}
+			params["cluster_id"] = clusterID
+		}
+		for _, key := range []string{"since", "until"} {
+			t, err := parseTime(query.Get(key))
+			if err != nil {
+				utils.RenderFail(writer, request, utils.SCodeBadRequestWithQueryParamErr)
+				return
+			}
+			params[key] = t
+		}
+		count, err := eventDao.Count(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		params["limit"] = limit
+		params["offset"] = offset
+		events, err := eventDao.List(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		writer.Header().Set("Count", strconv.FormatInt(count, 10))
+		utils.RenderSuccess(writer, request, events)
+	}
+}
+
+// parseTime 解析 unix 秒或者 RFC3339 格式的时间, 为空时返回零值
+func parseTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
@@ -0,0 +1,109 @@
+package k8s
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	"github.com/bloodsteel/easynetes/internal/service/approval"
+	"github.com/bloodsteel/easynetes/internal/service/kube"
+	"github.com/bloodsteel/easynetes/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// ListNamespaces 返回集群中所有的 namespace, easynetes 创建的 namespace 带有绑定关系
+func ListNamespaces(namespaces *kube.Namespaces) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		clusterID, ok := clusterIDParam(writer, request)
+		if !ok {
+			return
+		}
+		out, err := namespaces.List(request.Context(), clusterID)
+		if err != nil {
+			renderKubeError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, out)
+	}
+}
+
+// CreateNamespace 在集群中创建 namespace
+// 请求体: {"name", "service_node_id", "team", "quota_template_id", "labels": {}}
+func CreateNamespace(namespaces *kube.Namespaces) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		clusterID, ok := clusterIDParam(writer, request)
+		if !ok {
+			return
+		}
+		in := new(core.KubeNamespace)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		in.ClusterID = clusterID
+		if user, ok := middleware.GetUserFromCtx(ctx); ok {
+			in.Creator = user.UserName
+		}
+		out, err := namespaces.Create(ctx, in)
+		if err != nil {
+			renderKubeError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, out)
+	}
+}
+
+// UpdateNamespace 更新 namespace 绑定的服务树节点、团队和标签
+// 请求体: {"service_node_id", "team", "labels": {}}
+func UpdateNamespace(namespaces *kube.Namespaces) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		clusterID, ok := clusterIDParam(writer, request)
+		if !ok {
+			return
+		}
+		in := new(core.KubeNamespace)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		in.ClusterID = clusterID
+		in.Name = chi.URLParam(request, "namespace")
+		out, err := namespaces.Update(request.Context(), in)
+		if err != nil {
+			renderKubeError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, out)
+	}
+}
+
+// DeleteNamespace 删除 easynetes 创建的 namespace, 集群中的 namespace 会一并删除
+// 匹配到审批规则时返回202和审批请求, 审批通过后才删除; 查询参数 reason 是申请删除的原因
+func DeleteNamespace(namespaces *kube.Namespaces, approvals *approval.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		clusterID, ok := clusterIDParam(writer, request)
+		if !ok {
+			return
+		}
+		name := chi.URLParam(request, "namespace")
+		user, _ := middleware.GetUserFromCtx(ctx)
+		req, err := approvals.Submit(ctx, core.ApprovalNamespaceDelete,
+			approval.NamespaceDeletePayload{ClusterID: clusterID, Namespace: name}, request.URL.Query().Get("reason"), user)
+		if err != nil {
+			renderKubeError(writer, request, err)
+			return
+		}
+		if req != nil {
+			utils.RenderAccepted(writer, request, req)
+			return
+		}
+		if err := namespaces.Delete(ctx, clusterID, name); err != nil {
+			renderKubeError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, nil)
+	}
+}
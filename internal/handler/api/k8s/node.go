@@ -0,0 +1,32 @@
+package k8s
+
+import (
+	"net/http"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	"github.com/bloodsteel/easynetes/internal/service/queue"
+	"github.com/bloodsteel/easynetes/internal/utils"
+)
+
+// SyncNodes 创建后台任务将集群中的节点同步到 CMDB, 返回202和任务, 通过 /tasks/{taskID} 查询新建、更新和标记离线的主机数量
+func SyncNodes(clusterDao core.KubeClusterDao, tasks *queue.Queue) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		clusterID, ok := clusterIDParam(writer, request)
+		if !ok {
+			return
+		}
+		if _, err := clusterDao.Get(ctx, clusterID); err != nil {
+			renderKubeError(writer, request, err)
+			return
+		}
+		user, _ := middleware.GetUserFromCtx(ctx)
+		task, err := tasks.Enqueue(ctx, queue.TaskKubeNodeSync, queue.NodeSyncPayload{ClusterID: clusterID}, 0, user.UserName)
+		if err != nil {
+			renderKubeError(writer, request, err)
+			return
+		}
+		utils.RenderAccepted(writer, request, task)
+	}
+}
@@ -0,0 +1,294 @@
+package k8s
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	"github.com/bloodsteel/easynetes/internal/service/kube"
+	"github.com/bloodsteel/easynetes/internal/utils"
+	"github.com/bloodsteel/easynetes/pkg/log"
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/util/exec"
+)
+
+const (
+	writeWait = 10 * time.Second
+	// logPingInterval 日志没有输出时发送 SSE 注释保持连接
+	logPingInterval = 15 * time.Second
+	// maxLogLine 单行日志的最大长度
+	maxLogLine = 1 << 20
+)
+
+// defaultShell 优先使用 bash, 容器中没有 bash 时使用 sh
+var defaultShell = []string{"/bin/sh", "-c", "export TERM=xterm-256color; [ -x /bin/bash ] && exec /bin/bash || exec /bin/sh"}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+}
+
+// execMessage 浏览器与 apiserver 之间的终端控制消息, 与主机 web 终端的消息格式相同
+// 浏览器发送 input/resize, apiserver 在命令退出时发送 exit; 命令输出直接以 binary 消息发送
+type execMessage struct {
+	Type   string `json:"type"`
+	Data   string `json:"data,omitempty"`
+	Cols   uint16 `json:"cols,omitempty"`
+	Rows   uint16 `json:"rows,omitempty"`
+	Code   int    `json:"code,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// StreamPodLogs 以 SSE 的方式返回 Pod 日志, 每行日志是一个 data 事件, 日志读完后发送 end 事件
+// 查询参数: container, since(例如 10m), tailLines, follow, timestamps
+func StreamPodLogs(pods *kube.Pods, authorizer core.Authorizer) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		clusterID, ok := clusterIDParam(writer, request)
+		if !ok {
+			return
+		}
+		namespace := chi.URLParam(request, "namespace")
+		query := request.URL.Query()
+		opts := kube.LogOptions{
+			Container:  query.Get("container"),
+			Follow:     query.Get("follow") == "true",
+			Timestamps: query.Get("timestamps") == "true",
+		}
+		var err error
+		if v := query.Get("since"); v != "" {
+			if opts.Since, err = time.ParseDuration(v); err != nil {
+				utils.RenderFail(writer, request, utils.SCodeBadRequestWithQueryParamErr)
+				return
+			}
+		}
+		if v := query.Get("tailLines"); v != "" {
+			if opts.TailLines, err = strconv.ParseInt(v, 10, 64); err != nil {
+				utils.RenderFail(writer, request, utils.SCodeBadRequestWithQueryParamErr)
+				return
+			}
+		}
+		flusher, ok := writer.(http.Flusher)
+		if !ok {
+			utils.RenderFail(writer, request, utils.SCodeUnknow)
+			return
+		}
+		if _, err := authorizeNamespace(ctx, authorizer, clusterID, namespace, core.ActionKubeLogs); err != nil {
+			renderKubeError(writer, request, err)
+			return
+		}
+		stream, err := pods.Logs(ctx, clusterID, namespace, chi.URLParam(request, "pod"), opts)
+		if err != nil {
+			renderKubeError(writer, request, err)
+			return
+		}
+		defer stream.Close()
+		// follow 的日志是长连接, 不受 server 的 WriteTimeout 限制, 连接由客户端或者服务退出时关闭
+		_ = http.NewResponseController(writer).SetWriteDeadline(time.Time{})
+
+		writer.Header().Set("Content-Type", "text/event-stream")
+		writer.Header().Set("Cache-Control", "no-cache")
+		writer.Header().Set("X-Accel-Buffering", "no")
+		writer.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		lines := make(chan string)
+		done := make(chan error, 1)
+		go func() {
+			scanner := bufio.NewScanner(stream)
+			scanner.Buffer(make([]byte, 64*1024), maxLogLine)
+			for scanner.Scan() {
+				select {
+				case lines <- scanner.Text():
+				case <-ctx.Done():
+					return
+				}
+			}
+			done <- scanner.Err()
+		}()
+		ticker := time.NewTicker(logPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case line := <-lines:
+				fmt.Fprintf(writer, "data: %s\n\n", line)
+			case <-ticker.C:
+				fmt.Fprint(writer, ": ping\n\n")
+			case err := <-done:
+				if err != nil && ctx.Err() == nil {
+					fmt.Fprintf(writer, "event: error\ndata: %s\n\n", err)
+				}
+				fmt.Fprint(writer, "event: end\ndata: \n\n")
+				flusher.Flush()
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// ExecPod 通过 websocket 在容器中打开一个终端
+// 查询参数: container, command(可以重复, 默认打开 shell), cols/rows 初始窗口大小
+func ExecPod(pods *kube.Pods, authorizer core.Authorizer) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		clusterID, ok := clusterIDParam(writer, request)
+		if !ok {
+			return
+		}
+		namespace, pod := chi.URLParam(request, "namespace"), chi.URLParam(request, "pod")
+		query := request.URL.Query()
+		command := query["command"]
+		if len(command) == 0 {
+			command = defaultShell
+		}
+		operator, err := authorizeNamespace(ctx, authorizer, clusterID, namespace, core.ActionKubeExec)
+		if err != nil {
+			renderKubeError(writer, request, err)
+			return
+		}
+		ws, err := upgrader.Upgrade(writer, request, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		logger := log.WithLabels("cluster_id", clusterID, "namespace", namespace, "pod", pod,
+			"container", query.Get("container"), "operator", operator)
+		logger.Info("pod exec session opened")
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		out := &wsWriter{ws: ws}
+		stdin, stdinWriter := io.Pipe()
+		sizes := &sizeQueue{ch: make(chan remotecommand.TerminalSize, 1), done: ctx.Done()}
+		sizes.push(windowSize(request))
+		go func() {
+			defer cancel()
+			defer stdinWriter.Close()
+			for {
+				in := new(execMessage)
+				if err := ws.ReadJSON(in); err != nil {
+					return
+				}
+				switch in.Type {
+				case "input":
+					if _, err := stdinWriter.Write([]byte(in.Data)); err != nil {
+						return
+					}
+				case "resize":
+					if in.Cols > 0 && in.Rows > 0 {
+						sizes.push(in.Cols, in.Rows)
+					}
+				}
+			}
+		}()
+
+		err = pods.Exec(ctx, clusterID, namespace, pod, kube.ExecOptions{
+			Container: query.Get("container"),
+			Command:   command,
+			TTY:       true,
+			Stdin:     stdin,
+			Stdout:    out,
+			Resize:    sizes,
+		})
+		exit := &execMessage{Type: "exit"}
+		var exitErr exec.CodeExitError
+		switch {
+		case err == nil:
+		case errors.As(err, &exitErr):
+			exit.Code = exitErr.Code
+		default:
+			exit.Code = -1
+			exit.Reason = err.Error()
+		}
+		logger.WithLabels("code", exit.Code, "reason", exit.Reason).Info("pod exec session closed")
+		_ = out.writeJSON(exit)
+	}
+}
+
+// authorizeNamespace 检查当前用户是否可以对 namespace 执行操作, 返回用户名作为操作人
+func authorizeNamespace(ctx context.Context, authorizer core.Authorizer, clusterID int64, namespace, action string) (string, error) {
+	user, _ := middleware.GetUserFromCtx(ctx)
+	resourceID := core.KubeNamespaceResource(clusterID, namespace)
+	if err := authorizer.Authorize(ctx, user, core.ResourceKubeNamespace, resourceID, action); err != nil {
+		return "", err
+	}
+	return user.UserName, nil
+}
+
+// wsWriter 将命令输出以 binary 消息写给浏览器, websocket 不支持并发写, 所以需要加锁
+type wsWriter struct {
+	mu sync.Mutex
+	ws *websocket.Conn
+}
+
+func (w *wsWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_ = w.ws.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := w.ws.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *wsWriter) writeJSON(v interface{}) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_ = w.ws.SetWriteDeadline(time.Now().Add(writeWait))
+	return w.ws.WriteJSON(v)
+}
+
+// sizeQueue 实现 remotecommand.TerminalSizeQueue, 只保留最新的窗口大小
+type sizeQueue struct {
+	ch   chan remotecommand.TerminalSize
+	done <-chan struct{}
+}
+
+func (q *sizeQueue) push(cols, rows uint16) {
+	size := remotecommand.TerminalSize{Width: cols, Height: rows}
+	for {
+		select {
+		case q.ch <- size:
+			return
+		default:
+		}
+		select {
+		case <-q.ch:
+		default:
+		}
+	}
+}
+
+func (q *sizeQueue) Next() *remotecommand.TerminalSize {
+	select {
+	case size := <-q.ch:
+		return &size
+	case <-q.done:
+		return nil
+	}
+}
+
+func windowSize(request *http.Request) (uint16, uint16) {
+	cols, err := strconv.ParseUint(request.URL.Query().Get("cols"), 10, 16)
+	if err != nil || cols == 0 {
+		cols = 80
+	}
+	rows, err := strconv.ParseUint(request.URL.Query().Get("rows"), 10, 16)
+	if err != nil || rows == 0 {
+		rows = 24
+	}
+	return uint16(cols), uint16(rows)
+}
@@ -0,0 +1,100 @@
+package k8s
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	"github.com/bloodsteel/easynetes/internal/service/kube"
+	"github.com/bloodsteel/easynetes/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// ListQuotaTemplates 返回所有配额模板
+func ListQuotaTemplates(templateDao core.KubeQuotaTemplateDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		templates, err := templateDao.List(request.Context())
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, templates)
+	}
+}
+
+// CreateQuotaTemplate 创建一个配额模板
+// 请求体: {"name", "description", "hard": {"requests.cpu": "4"}, "default_limit": {"cpu": "500m"}, "default_request", "max", "min"}
+func CreateQuotaTemplate(templateDao core.KubeQuotaTemplateDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		in := new(core.KubeQuotaTemplate)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		if in.Name == "" {
+			utils.RenderFail(writer, request, utils.SCodeBadRequestWithQuotaTemplate)
+			return
+		}
+		if err := kube.ValidateQuotaTemplate(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeBadRequestWithQuotaTemplate, err)
+			return
+		}
+		if user, ok := middleware.GetUserFromCtx(ctx); ok {
+			in.Creator = user.UserName
+		}
+		if _, err := templateDao.Create(ctx, in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, in)
+	}
+}
+
+// UpdateQuotaTemplate 更新一个配额模板, 已经创建的 namespace 不受影响
+func UpdateQuotaTemplate(templateDao core.KubeQuotaTemplateDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		templateID, err := strconv.ParseInt(chi.URLParam(request, "templateID"), 10, 64)
+		if err != nil {
+			utils.RenderFail(writer, request, utils.SCodeBadRequestWithPathParamErr)
+			return
+		}
+		in := new(core.KubeQuotaTemplate)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		if in.Name == "" {
+			utils.RenderFail(writer, request, utils.SCodeBadRequestWithQuotaTemplate)
+			return
+		}
+		if err := kube.ValidateQuotaTemplate(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeBadRequestWithQuotaTemplate, err)
+			return
+		}
+		in.ID = templateID
+		if err := templateDao.Update(request.Context(), in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, in)
+	}
+}
+
+// DeleteQuotaTemplate 删除一个配额模板
+func DeleteQuotaTemplate(templateDao core.KubeQuotaTemplateDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		templateID, err := strconv.ParseInt(chi.URLParam(request, "templateID"), 10, 64)
+		if err != nil {
+			utils.RenderFail(writer, request, utils.SCodeBadRequestWithPathParamErr)
+			return
+		}
+		if err := templateDao.Delete(request.Context(), templateID); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, nil)
+	}
+}
@@ -0,0 +1,182 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	"github.com/bloodsteel/easynetes/internal/service/kube"
+	"github.com/bloodsteel/easynetes/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// ListWorkloads 从 informer 缓存中返回某一类工作负载, 查询参数 namespace 为空时返回所有 namespace
+func ListWorkloads(workloads *kube.Workloads) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		clusterID, ok := clusterIDParam(writer, request)
+		if !ok {
+			return
+		}
+		out, err := workloads.List(request.Context(), clusterID, chi.URLParam(request, "kind"), request.URL.Query().Get("namespace"))
+		if err != nil {
+			renderKubeError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, out)
+	}
+}
+
+// GetWorkload 返回工作负载的详细信息和 Pod 列表
+func GetWorkload(workloads *kube.Workloads) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ref, ok := workloadRef(writer, request)
+		if !ok {
+			return
+		}
+		out, err := workloads.Get(request.Context(), ref)
+		if err != nil {
+			renderKubeError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, out)
+	}
+}
+
+// ScaleWorkload 修改 Deployment/StatefulSet 的副本数量
+// 请求体: {"replicas"}
+func ScaleWorkload(workloads *kube.Workloads, authorizer core.Authorizer) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		ref, ok := workloadRef(writer, request)
+		if !ok {
+			return
+		}
+		in := new(struct {
+			Replicas *int32 `json:"replicas"`
+		})
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		if in.Replicas == nil {
+			utils.RenderFail(writer, request, utils.SCodeBadRequestWithKubeWorkload)
+			return
+		}
+		operator, err := authorizeWorkload(ctx, authorizer, ref)
+		if err == nil {
+			err = workloads.Scale(ctx, ref, *in.Replicas, operator)
+		}
+		if err != nil {
+			renderKubeError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, nil)
+	}
+}
+
+// RestartWorkload 滚动重启 Deployment/StatefulSet/DaemonSet
+func RestartWorkload(workloads *kube.Workloads, authorizer core.Authorizer) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		ref, ok := workloadRef(writer, request)
+		if !ok {
+			return
+		}
+		operator, err := authorizeWorkload(ctx, authorizer, ref)
+		if err == nil {
+			err = workloads.Restart(ctx, ref, operator)
+		}
+		if err != nil {
+			renderKubeError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, nil)
+	}
+}
+
+// RollbackWorkload 将 Deployment 回滚到某个历史版本, revision 为空或者 0 时回滚到上一个版本
+// 请求体: {"revision"}
+func RollbackWorkload(workloads *kube.Workloads, authorizer core.Authorizer) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		ref, ok := workloadRef(writer, request)
+		if !ok {
+			return
+		}
+		in := new(struct {
+			Revision int64 `json:"revision"`
+		})
+		if request.ContentLength != 0 {
+			if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+				utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+				return
+			}
+		}
+		operator, err := authorizeWorkload(ctx, authorizer, ref)
+		if err != nil {
+			renderKubeError(writer, request, err)
+			return
+		}
+		revision, err := workloads.Rollback(ctx, ref, in.Revision, operator)
+		if err != nil {
+			renderKubeError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, map[string]int64{"revision": revision})
+	}
+}
+
+// ListWorkloadActions 返回集群中对工作负载执行的操作记录, 支持按 namespace/kind/name/operator 过滤
+func ListWorkloadActions(actionDao core.KubeWorkloadActionDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		clusterID, ok := clusterIDParam(writer, request)
+		if !ok {
+			return
+		}
+		limit, offset, _ := middleware.GetPaginateFromCtx(ctx)
+		query := request.URL.Query()
+		params := map[string]interface{}{
+			"cluster_id": clusterID,
+			"namespace":  query.Get("namespace"),
+			"kind":       query.Get("kind"),
+			"name":       query.Get("name"),
+			"operator":   query.Get("operator"),
+		}
+		count, err := actionDao.Count(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		params["limit"] = limit
+		params["offset"] = offset
+		actions, err := actionDao.List(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		writer.Header().Set("Count", strconv.FormatInt(count, 10))
+		utils.RenderSuccess(writer, request, actions)
+	}
+}
+
+func workloadRef(writer http.ResponseWriter, request *http.Request) (kube.WorkloadRef, bool) {
+	clusterID, ok := clusterIDParam(writer, request)
+	if !ok {
+		return kube.WorkloadRef{}, false
+	}
+	return kube.WorkloadRef{
+		ClusterID: clusterID,
+		Kind:      chi.URLParam(request, "kind"),
+		Namespace: chi.URLParam(request, "namespace"),
+		Name:      chi.URLParam(request, "name"),
+	}, true
+}
+
+// authorizeWorkload 检查当前用户是否可以操作 namespace 中的工作负载, 返回用户名作为操作人
+func authorizeWorkload(ctx context.Context, authorizer core.Authorizer, ref kube.WorkloadRef) (string, error) {
+	return authorizeNamespace(ctx, authorizer, ref.ClusterID, ref.Namespace, core.ActionKubeOperate)
+}
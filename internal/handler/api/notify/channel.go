@@ -0,0 +1,133 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	notifysvc "github.com/bloodsteel/easynetes/internal/service/notify"
+	"github.com/bloodsteel/easynetes/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// channelRequest 创建和更新通知渠道的请求体, secret 是签名密钥, 更新时为空表示不修改
+type channelRequest struct {
+	core.NotifyChannel
+	Secret string `json:"secret"`
+}
+
+// ListChannels 返回所有通知渠道, 用户订阅事件时选择渠道
+func ListChannels(channelDao core.NotifyChannelDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		channels, err := channelDao.List(request.Context())
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, channels)
+	}
+}
+
+// CreateChannel 创建通知渠道, 请求体: {"name", "type", "url", "secret", "enabled"}
+func CreateChannel(notifier *notifysvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		in := new(channelRequest)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		in.ID = 0
+		if user, ok := middleware.GetUserFromCtx(ctx); ok {
+			in.Creator = user.UserName
+		}
+		out, err := notifier.CreateChannel(ctx, &in.NotifyChannel, in.Secret)
+		if err != nil {
+			renderNotifyError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, out)
+	}
+}
+
+// UpdateChannel 更新通知渠道, secret 为空时保留原有的密钥
+func UpdateChannel(notifier *notifysvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		channelID, ok := idParam(writer, request, "channelID")
+		if !ok {
+			return
+		}
+		in := new(channelRequest)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		in.ID = channelID
+		out, err := notifier.UpdateChannel(request.Context(), &in.NotifyChannel, in.Secret)
+		if err != nil {
+			renderNotifyError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, out)
+	}
+}
+
+// DeleteChannel 删除通知渠道以及对它的订阅, 发送历史保留
+func DeleteChannel(channelDao core.NotifyChannelDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		channelID, ok := idParam(writer, request, "channelID")
+		if !ok {
+			return
+		}
+		if err := channelDao.Delete(request.Context(), channelID); err != nil {
+			renderNotifyError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, nil)
+	}
+}
+
+// TestChannel 通过渠道给当前用户发送一条测试通知, 返回发送记录
+func TestChannel(notifier *notifysvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		channelID, ok := idParam(writer, request, "channelID")
+		if !ok {
+			return
+		}
+		user, _ := middleware.GetUserFromCtx(ctx)
+		delivery, err := notifier.Test(ctx, channelID, user)
+		if err != nil {
+			renderNotifyError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, delivery)
+	}
+}
+
+// idParam 解析路径参数中的ID, 解析失败时已经返回了错误
+func idParam(writer http.ResponseWriter, request *http.Request, name string) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(request, name), 10, 64)
+	if err != nil {
+		utils.RenderFail(writer, request, utils.SCodeBadRequestWithPathParamErr)
+		return 0, false
+	}
+	return id, true
+}
+
+// renderNotifyError 将通知相关的错误转换为对应的状态码
+func renderNotifyError(writer http.ResponseWriter, request *http.Request, err error) {
+	switch {
+	case errors.Is(err, core.ErrInvalidNotifyChannel):
+		utils.RenderError(writer, request, utils.SCodeBadRequestWithNotifyChannel, err)
+	case errors.Is(err, core.ErrInvalidNotifyTemplate):
+		utils.RenderError(writer, request, utils.SCodeBadRequestWithNotifyTemplate, err)
+	case errors.Is(err, core.ErrForbidden):
+		utils.RenderFail(writer, request, utils.SCodeForbidden)
+	default:
+		utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+	}
+}
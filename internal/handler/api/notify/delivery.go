@@ -0,0 +1,69 @@
+package notify
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	"github.com/bloodsteel/easynetes/internal/utils"
+)
+
+// ListDeliveries 返回通知的发送历史, 支持按 event/status/channel_id/user_id 过滤
+// 普通用户只能查询发送给自己的通知
+func ListDeliveries(deliveryDao core.NotifyDeliveryDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		limit, offset, _ := middleware.GetPaginateFromCtx(ctx)
+		query := request.URL.Query()
+		params := map[string]interface{}{"event": query.Get("event"), "status": query.Get("status")}
+		for _, key := range []string{"channel_id", "user_id"} {
+			if v := query.Get(key); v != "" {
+				id, err := strconv.ParseInt(v, 10, 64)
+				if err != nil {
+					utils.RenderFail(writer, request, utils.SCodeBadRequestWithQueryParamErr)
+					return
+				}
+				params[key] = id
+			}
+		}
+		if user, _ := middleware.GetUserFromCtx(ctx); !user.IsAdmin {
+			params["user_id"] = user.ID
+		}
+		count, err := deliveryDao.Count(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		params["limit"] = limit
+		params["offset"] = offset
+		deliveries, err := deliveryDao.List(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		writer.Header().Set("Count", strconv.FormatInt(count, 10))
+		utils.RenderSuccess(writer, request, deliveries)
+	}
+}
+
+// GetDelivery 返回单条发送记录
+func GetDelivery(deliveryDao core.NotifyDeliveryDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		deliveryID, ok := idParam(writer, request, "deliveryID")
+		if !ok {
+			return
+		}
+		delivery, err := deliveryDao.Get(ctx, deliveryID)
+		if err != nil {
+			renderNotifyError(writer, request, err)
+			return
+		}
+		if user, _ := middleware.GetUserFromCtx(ctx); !user.IsAdmin && user.ID != delivery.UserID {
+			utils.RenderFail(writer, request, utils.SCodeForbidden)
+			return
+		}
+		utils.RenderSuccess(writer, request, delivery)
+	}
+}
@@ -0,0 +1,81 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	notifysvc "github.com/bloodsteel/easynetes/internal/service/notify"
+	"github.com/bloodsteel/easynetes/internal/utils"
+)
+
+// ListSubscriptions 返回当前用户的订阅, 管理员可以通过 user_id 查询其他用户的订阅
+func ListSubscriptions(subscriptionDao core.NotifySubscriptionDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		user, _ := middleware.GetUserFromCtx(ctx)
+		userID := user.ID
+		if v := request.URL.Query().Get("user_id"); v != "" && user.IsAdmin {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				utils.RenderFail(writer, request, utils.SCodeBadRequestWithQueryParamErr)
+				return
+			}
+			userID = id
+		}
+		subs, err := subscriptionDao.List(ctx, map[string]interface{}{"user_id": userID})
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, subs)
+	}
+}
+
+// CreateSubscription 为当前用户订阅事件, 请求体: {"channel_id", "event"}, event 支持 approval.* 这样的前缀和 *
+func CreateSubscription(notifier *notifysvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		in := new(core.NotifySubscription)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		user, _ := middleware.GetUserFromCtx(ctx)
+		in.ID = 0
+		in.UserID = user.ID
+		out, err := notifier.Subscribe(ctx, in)
+		if err != nil {
+			renderNotifyError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, out)
+	}
+}
+
+// DeleteSubscription 取消订阅, 只能取消自己的订阅, 管理员可以取消任何订阅
+func DeleteSubscription(subscriptionDao core.NotifySubscriptionDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		subID, ok := idParam(writer, request, "subscriptionID")
+		if !ok {
+			return
+		}
+		sub, err := subscriptionDao.Get(ctx, subID)
+		if err != nil {
+			renderNotifyError(writer, request, err)
+			return
+		}
+		if user, _ := middleware.GetUserFromCtx(ctx); !user.IsAdmin && user.ID != sub.UserID {
+			utils.RenderFail(writer, request, utils.SCodeForbidden)
+			return
+		}
+		if err := subscriptionDao.Delete(ctx, subID); err != nil {
+			renderNotifyError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, nil)
+	}
+}
@@ -0,0 +1,52 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	notifysvc "github.com/bloodsteel/easynetes/internal/service/notify"
+	"github.com/bloodsteel/easynetes/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// ListTemplates 返回所有通知模板
+func ListTemplates(templateDao core.NotifyTemplateDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		templates, err := templateDao.List(request.Context())
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, templates)
+	}
+}
+
+// SaveTemplate 创建或者更新路径中事件类型的模板, 请求体: {"subject", "body"}
+// 模板使用 text/template 语法, 可以引用 .Event .Time .Data, {{json .Data}} 输出格式化的 JSON
+func SaveTemplate(notifier *notifysvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		in := new(core.NotifyTemplate)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		in.Event = chi.URLParam(request, "event")
+		if err := notifier.SaveTemplate(request.Context(), in); err != nil {
+			renderNotifyError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, in)
+	}
+}
+
+// DeleteTemplate 删除事件类型的模板, 之后使用其他匹配的模板或者默认模板
+func DeleteTemplate(templateDao core.NotifyTemplateDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if err := templateDao.Delete(request.Context(), chi.URLParam(request, "event")); err != nil {
+			renderNotifyError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, nil)
+	}
+}
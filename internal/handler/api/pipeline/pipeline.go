@@ -0,0 +1,155 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	pipelinesvc "github.com/bloodsteel/easynetes/internal/service/pipeline"
+	"github.com/bloodsteel/easynetes/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// ListPipelines 返回流水线列表, 支持按 app_id/service_node_id 过滤
+func ListPipelines(pipelineDao core.PipelineDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		limit, offset, _ := middleware.GetPaginateFromCtx(ctx)
+		params, ok := idQuery(writer, request, "app_id", "service_node_id")
+		if !ok {
+			return
+		}
+		count, err := pipelineDao.Count(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		params["limit"] = limit
+		params["offset"] = offset
+		pipelines, err := pipelineDao.List(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		writer.Header().Set("Count", strconv.FormatInt(count, 10))
+		utils.RenderSuccess(writer, request, pipelines)
+	}
+}
+
+// GetPipeline 返回单个流水线
+func GetPipeline(pipelineDao core.PipelineDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		pipelineID, ok := idParam(writer, request, "pipelineID")
+		if !ok {
+			return
+		}
+		pipeline, err := pipelineDao.Get(request.Context(), pipelineID)
+		if err != nil {
+			renderPipelineError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, pipeline)
+	}
+}
+
+// CreatePipeline 创建流水线, 请求体: {"name", "app_id", "service_node_id", "stages": [...]}
+func CreatePipeline(pipelines *pipelinesvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		in := new(core.Pipeline)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		in.ID = 0
+		if user, ok := middleware.GetUserFromCtx(ctx); ok {
+			in.Creator = user.UserName
+		}
+		out, err := pipelines.Save(ctx, in)
+		if err != nil {
+			renderPipelineError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, out)
+	}
+}
+
+// UpdatePipeline 更新流水线的名称、关联关系和阶段配置, 进行中的运行使用启动时的配置
+func UpdatePipeline(pipelines *pipelinesvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		pipelineID, ok := idParam(writer, request, "pipelineID")
+		if !ok {
+			return
+		}
+		in := new(core.Pipeline)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		in.ID = pipelineID
+		out, err := pipelines.Save(request.Context(), in)
+		if err != nil {
+			renderPipelineError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, out)
+	}
+}
+
+// DeletePipeline 删除流水线, 历史运行记录保留
+func DeletePipeline(pipelineDao core.PipelineDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		pipelineID, ok := idParam(writer, request, "pipelineID")
+		if !ok {
+			return
+		}
+		if err := pipelineDao.Delete(request.Context(), pipelineID); err != nil {
+			renderPipelineError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, nil)
+	}
+}
+
+func idParam(writer http.ResponseWriter, request *http.Request, name string) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(request, name), 10, 64)
+	if err != nil {
+		utils.RenderFail(writer, request, utils.SCodeBadRequestWithPathParamErr)
+		return 0, false
+	}
+	return id, true
+}
+
+// idQuery 将查询参数中的ID解析为过滤条件, 解析失败时已经返回了错误
+func idQuery(writer http.ResponseWriter, request *http.Request, keys ...string) (map[string]interface{}, bool) {
+	params := map[string]interface{}{}
+	query := request.URL.Query()
+	for _, key := range keys {
+		if v := query.Get(key); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				utils.RenderFail(writer, request, utils.SCodeBadRequestWithQueryParamErr)
+				return nil, false
+			}
+			params[key] = id
+		}
+	}
+	return params, true
+}
+
+// renderPipelineError 将流水线相关的错误转换为对应的状态码
+func renderPipelineError(writer http.ResponseWriter, request *http.Request, err error) {
+	switch {
+	case errors.Is(err, core.ErrInvalidPipeline):
+		utils.RenderError(writer, request, utils.SCodeBadRequestWithPipeline, err)
+	case errors.Is(err, pipelinesvc.ErrInvalidTransition):
+		utils.RenderFail(writer, request, utils.SCodeBadRequestWithPipelineConflict)
+	case errors.Is(err, core.ErrForbidden):
+		utils.RenderFail(writer, request, utils.SCodeForbidden)
+	default:
+		utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+	}
+}
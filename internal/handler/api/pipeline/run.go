@@ -0,0 +1,179 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	pipelinesvc "github.com/bloodsteel/easynetes/internal/service/pipeline"
+	"github.com/bloodsteel/easynetes/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// runDetail 运行记录以及每个阶段的状态
+type runDetail struct {
+	*core.PipelineRun
+	StageRuns []*core.PipelineStageRun `json:"stage_runs"`
+}
+
+// StartRun 启动流水线, 请求体: {"parameters": {"VERSION": "v1.2.0"}}
+func StartRun(pipelines *pipelinesvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		pipelineID, ok := idParam(writer, request, "pipelineID")
+		if !ok {
+			return
+		}
+		in := new(struct {
+			Parameters map[string]string `json:"parameters"`
+		})
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		user, _ := middleware.GetUserFromCtx(ctx)
+		run, err := pipelines.Start(ctx, pipelineID, in.Parameters, user)
+		if err != nil {
+			renderPipelineError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, run)
+	}
+}
+
+// ListRuns 返回运行记录, 支持按 pipeline_id/app_id/status/creator 过滤
+func ListRuns(runDao core.PipelineRunDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		limit, offset, _ := middleware.GetPaginateFromCtx(ctx)
+		params, ok := idQuery(writer, request, "pipeline_id", "app_id")
+		if !ok {
+			return
+		}
+		query := request.URL.Query()
+		params["status"] = query.Get("status")
+		params["creator"] = query.Get("creator")
+		count, err := runDao.Count(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		params["limit"] = limit
+		params["offset"] = offset
+		runs, err := runDao.List(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		writer.Header().Set("Count", strconv.FormatInt(count, 10))
+		utils.RenderSuccess(writer, request, runs)
+	}
+}
+
+// GetRun 返回运行记录以及每个阶段的状态, 不包含阶段日志
+func GetRun(runDao core.PipelineRunDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		runID, ok := idParam(writer, request, "runID")
+		if !ok {
+			return
+		}
+		run, err := runDao.Get(ctx, runID)
+		if err != nil {
+			renderPipelineError(writer, request, err)
+			return
+		}
+		stages, err := runDao.ListStages(ctx, runID)
+		if err != nil {
+			renderPipelineError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, &runDetail{PipelineRun: run, StageRuns: stages})
+	}
+}
+
+// GetStage 返回运行中单个阶段的状态和日志, 路径参数 stage 为阶段的下标
+func GetStage(runDao core.PipelineRunDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		runID, ok := idParam(writer, request, "runID")
+		if !ok {
+			return
+		}
+		index, err := strconv.Atoi(chi.URLParam(request, "stage"))
+		if err != nil {
+			utils.RenderFail(writer, request, utils.SCodeBadRequestWithPathParamErr)
+			return
+		}
+		stage, err := runDao.GetStage(request.Context(), runID, index)
+		if err != nil {
+			renderPipelineError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, stage)
+	}
+}
+
+// CancelRun 取消进行中或者等待审批的运行
+func CancelRun(pipelines *pipelinesvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		runID, ok := idParam(writer, request, "runID")
+		if !ok {
+			return
+		}
+		user, _ := middleware.GetUserFromCtx(ctx)
+		run, err := pipelines.Cancel(ctx, runID, user)
+		if err != nil {
+			renderPipelineError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, run)
+	}
+}
+
+// RetryRun 重试失败或者被取消的运行的当前阶段
+func RetryRun(pipelines *pipelinesvc.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		runID, ok := idParam(writer, request, "runID")
+		if !ok {
+			return
+		}
+		user, _ := middleware.GetUserFromCtx(ctx)
+		run, err := pipelines.Retry(ctx, runID, user)
+		if err != nil {
+			renderPipelineError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, run)
+	}
+}
+
+// ApproveRun 通过或者拒绝等待中的审批阶段, 请求体: {"comment": "..."}
+func ApproveRun(pipelines *pipelinesvc.Service, approved bool) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		runID, ok := idParam(writer, request, "runID")
+		if !ok {
+			return
+		}
+		in := new(struct {
+			Comment string `json:"comment"`
+		})
+		if request.ContentLength != 0 {
+			if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+				utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+				return
+			}
+		}
+		user, _ := middleware.GetUserFromCtx(ctx)
+		run, err := pipelines.Approve(ctx, runID, user, approved, in.Comment)
+		if err != nil {
+			renderPipelineError(writer, request, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, run)
+	}
+}
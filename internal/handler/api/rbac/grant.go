@@ -0,0 +1,72 @@
+package rbac
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	"github.com/bloodsteel/easynetes/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// ListGrants 返回授权记录, 支持按 user_id/resource/resource_id 过滤
+func ListGrants(grantDao core.GrantDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		query := request.URL.Query()
+		params := map[string]interface{}{
+			"resource":    query.Get("resource"),
+			"resource_id": query.Get("resource_id"),
+		}
+		if userID, err := strconv.ParseInt(query.Get("user_id"), 10, 64); err == nil {
+			params["user_id"] = userID
+		}
+		grants, err := grantDao.List(request.Context(), params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, grants)
+	}
+}
+
+// CreateGrant 创建一个授权记录
+func CreateGrant(grantDao core.GrantDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		in := new(core.Grant)
+		if err := json.NewDecoder(request.Body).Decode(in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithPayloadBind, err)
+			return
+		}
+		if in.UserID == 0 || in.Resource == "" || in.ResourceID == "" || in.Action == "" {
+			utils.RenderFail(writer, request, utils.SCodeBadRequestWithGrant)
+			return
+		}
+		if user, ok := middleware.GetUserFromCtx(ctx); ok {
+			in.Creator = user.UserName
+		}
+		if _, err := grantDao.Create(ctx, in); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, in)
+	}
+}
+
+// DeleteGrant 删除一个授权记录
+func DeleteGrant(grantDao core.GrantDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		grantID, err := strconv.ParseInt(chi.URLParam(request, "grantID"), 10, 64)
+		if err != nil {
+			utils.RenderFail(writer, request, utils.SCodeBadRequestWithPathParamErr)
+			return
+		}
+		if err := grantDao.Delete(request.Context(), grantID); err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		utils.RenderSuccess(writer, request, nil)
+	}
+}
@@ -0,0 +1,60 @@
+package task
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	"github.com/bloodsteel/easynetes/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// ListTasks 返回后台任务列表, 支持按 type/status 过滤; 普通用户只能看到自己创建的任务
+func ListTasks(taskDao core.TaskDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		limit, offset, _ := middleware.GetPaginateFromCtx(ctx)
+		query := request.URL.Query()
+		params := map[string]interface{}{"task_type": query.Get("type"), "status": query.Get("status")}
+		if user, _ := middleware.GetUserFromCtx(ctx); !user.IsAdmin {
+			params["creator"] = user.UserName
+		}
+		count, err := taskDao.Count(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		params["limit"] = limit
+		params["offset"] = offset
+		tasks, err := taskDao.List(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		writer.Header().Set("Count", strconv.FormatInt(count, 10))
+		utils.RenderSuccess(writer, request, tasks)
+	}
+}
+
+// GetTask 返回单个后台任务的进度、结果或者错误; 普通用户只能查看自己创建的任务
+func GetTask(taskDao core.TaskDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		taskID, err := strconv.ParseInt(chi.URLParam(request, "taskID"), 10, 64)
+		if err != nil {
+			utils.RenderFail(writer, request, utils.SCodeBadRequestWithPathParamErr)
+			return
+		}
+		task, err := taskDao.Get(ctx, taskID)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		if user, _ := middleware.GetUserFromCtx(ctx); !user.IsAdmin && task.Creator != user.UserName {
+			utils.RenderFail(writer, request, utils.SCodeForbidden)
+			return
+		}
+		utils.RenderSuccess(writer, request, task)
+	}
+}
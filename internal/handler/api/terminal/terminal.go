@@ -0,0 +1,102 @@
+package terminal
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/middleware"
+	"github.com/bloodsteel/easynetes/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// ListSessions 返回终端会话列表, 支持按 host_id/user_id/status 过滤; 普通用户只能看到自己的会话
+func ListSessions(sessionDao core.TerminalSessionDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		limit, offset, _ := middleware.GetPaginateFromCtx(ctx)
+		query := request.URL.Query()
+		params := map[string]interface{}{
+			"status": query.Get("status"),
+		}
+		if hostID, err := strconv.ParseInt(query.Get("host_id"), 10, 64); err == nil {
+			params["host_id"] = hostID
+		}
+		if userID, err := strconv.ParseInt(query.Get("user_id"), 10, 64); err == nil {
+			params["user_id"] = userID
+		}
+		if user, _ := middleware.GetUserFromCtx(ctx); !user.IsAdmin {
+			params["user_id"] = user.ID
+		}
+		count, err := sessionDao.Count(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		params["limit"] = limit
+		params["offset"] = offset
+		sessions, err := sessionDao.List(ctx, params)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+			return
+		}
+		writer.Header().Set("Count", strconv.FormatInt(count, 10))
+		utils.RenderSuccess(writer, request, sessions)
+	}
+}
+
+// GetSession 返回单个终端会话
+func GetSession(sessionDao core.TerminalSessionDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		session, ok := findSession(writer, request, sessionDao)
+		if !ok {
+			return
+		}
+		utils.RenderSuccess(writer, request, session)
+	}
+}
+
+// GetRecording 返回终端会话的 asciicast v2 录像, 可以直接交给 asciinema-player 回放
+func GetRecording(sessionDao core.TerminalSessionDao) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		session, ok := findSession(writer, request, sessionDao)
+		if !ok {
+			return
+		}
+		file, err := os.Open(session.Recording)
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithStorage, err)
+			return
+		}
+		defer file.Close()
+		stat, err := file.Stat()
+		if err != nil {
+			utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithStorage, err)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/x-asciicast")
+		http.ServeContent(writer, request, filepath.Base(session.Recording), stat.ModTime(), file)
+	}
+}
+
+// findSession 根据路径参数获取会话, 普通用户只能访问自己的会话
+func findSession(writer http.ResponseWriter, request *http.Request, sessionDao core.TerminalSessionDao) (*core.TerminalSession, bool) {
+	ctx := request.Context()
+	session, err := sessionDao.Get(ctx, chi.URLParam(request, "sessionID"))
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.RenderFail(writer, request, utils.SCodeNotFoundWithDao)
+		return nil, false
+	} else if err != nil {
+		utils.RenderError(writer, request, utils.SCodeInternalServerErrorWithDao, err)
+		return nil, false
+	}
+	if user, _ := middleware.GetUserFromCtx(ctx); !user.IsAdmin && user.ID != session.UserID {
+		utils.RenderFail(writer, request, utils.SCodeForbidden)
+		return nil, false
+	}
+	return session, true
+}
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+)
+
+// UserCtxKey Context keys for current user
+var (
+	UserCtxKey = &contextKey{"User"}
+)
+
+// WithUser 将当前登录的用户存入 ctx
+func WithUser(ctx context.Context, user *core.User) context.Context {
+	return context.WithValue(ctx, UserCtxKey, user)
+}
+
+// GetUserFromCtx 从 http request ctx 中获取当前登录的用户, 方便调用
+func GetUserFromCtx(ctx context.Context) (*core.User, bool) {
+	user, ok := ctx.Value(UserCtxKey).(*core.User)
+	return user, ok && user != nil
+}
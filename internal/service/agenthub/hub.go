@@ -0,0 +1,248 @@
+// Package agenthub 维护 apiserver 与所有 easynetes-agent 之间的 stream 连接
+package agenthub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/agent/proto"
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/pkg/config"
+	"github.com/bloodsteel/easynetes/pkg/log"
+	"github.com/gorilla/websocket"
+)
+
+var logger = log.RegisterScope("agenthub", "agent stream hub", 0)
+
+// ErrAgentOffline agent 当前没有连接到 apiserver
+var ErrAgentOffline = errors.New("agent is not connected")
+
+const writeWait = 10 * time.Second
+
+// messageRetention 已处理消息ID的保留时间, 需要大于 agent 离线后补发消息的时间
+const messageRetention = 7 * 24 * time.Hour
+
+// HandlerFunc 处理 agent 发送的某一类消息
+type HandlerFunc func(ctx context.Context, agentID int64, msg *proto.Message) error
+
+// Hub 管理 agent 的 websocket 连接, 处理心跳并向 agent 下发消息
+type Hub struct {
+	agentDao   core.AgentDao
+	messageDao core.AgentMessageDao
+	timeout    time.Duration
+
+	mu       sync.RWMutex
+	conns    map[int64]*conn
+	handlers map[string]HandlerFunc
+}
+
+type conn struct {
+	mu sync.Mutex
+	ws *websocket.Conn
+}
+
+// send websocket 不支持并发写, 所以需要加锁
+func (c *conn) send(msg *proto.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.ws.WriteJSON(msg)
+}
+
+// ProvideHub is a Wire provider
+func ProvideHub(agentDao core.AgentDao, messageDao core.AgentMessageDao, cfg *config.Config) *Hub {
+	return &Hub{
+		agentDao:   agentDao,
+		messageDao: messageDao,
+		timeout:    cfg.Agent.HeartbeatTimeout * time.Second,
+		conns:      make(map[int64]*conn),
+		handlers:   make(map[string]HandlerFunc),
+	}
+}
+
+// Handle 注册某一类消息的处理函数, 需要在 Run 之前调用
+func (h *Hub) Handle(typ string, fn HandlerFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.handlers[typ] = fn
+}
+
+// Send 向指定的 agent 发送一条消息
+func (h *Hub) Send(agentID int64, msg *proto.Message) error {
+	h.mu.RLock()
+	c, ok := h.conns[agentID]
+	h.mu.RUnlock()
+	if !ok {
+		return ErrAgentOffline
+	}
+	return c.send(msg)
+}
+
+// Connected 判断 agent 当前是否连接到 apiserver
+func (h *Hub) Connected(agentID int64) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	_, ok := h.conns[agentID]
+	return ok
+}
+
+// Serve 处理单个 agent 的 websocket 连接, 直到连接断开才返回
+// agent 在发送第一个心跳之前发送的其他消息都会被丢弃
+func (h *Hub) Serve(ctx context.Context, ws *websocket.Conn) error {
+	c := &conn{ws: ws}
+	var agentID int64
+	defer func() {
+		if agentID != 0 {
+			h.unregister(agentID, c)
+		}
+		_ = ws.Close()
+	}()
+
+	for {
+		_ = ws.SetReadDeadline(time.Now().Add(h.timeout))
+		msg := new(proto.Message)
+		if err := ws.ReadJSON(msg); err != nil {
+			return err
+		}
+		if msg.Type == proto.TypeHeartbeat {
+			id, err := h.heartbeat(ctx, msg)
+			if err != nil {
+				logger.WithLabels("error", err).Error("cannot save agent heartbeat")
+				continue
+			}
+			if id != agentID {
+				if agentID != 0 {
+					h.unregister(agentID, c)
+				}
+				agentID = id
+				h.register(agentID, c)
+			}
+			continue
+		}
+		if agentID == 0 {
+			continue
+		}
+		if msg.Reliable {
+			h.handleReliable(ctx, agentID, c, msg)
+		} else {
+			_ = h.dispatch(ctx, agentID, msg)
+		}
+	}
+}
+
+// handleReliable 处理需要确认的消息, agent 重发的已经处理过的消息只确认不再处理
+// 处理成功后才记录消息ID并确认; 处理或者记录失败时不确认, 由 agent 稍后重发
+// payload 无法解析或者类型未知的消息重发也无法处理, 记录日志后同样确认并丢弃, 避免阻塞 agent 队列中后面的消息
+// 消息的处理函数分别写入各自的表, 无法与记录消息ID放在同一个事务中, 因此处理函数需要能够接受重复的消息
+func (h *Hub) handleReliable(ctx context.Context, agentID int64, c *conn, msg *proto.Message) {
+	seen, err := h.messageDao.Seen(ctx, agentID, msg.ID)
+	if err != nil {
+		logger.WithLabels("agent_id", agentID, "id", msg.ID, "error", err).Error("cannot check agent message")
+		return
+	}
+	if seen {
+		logger.WithLabels("agent_id", agentID, "id", msg.ID, "type", msg.Type).Debug("duplicate agent message")
+	} else {
+		if err := h.dispatch(ctx, agentID, msg); errors.Is(err, proto.ErrMalformed) {
+			logger.WithLabels("agent_id", agentID, "id", msg.ID, "type", msg.Type).Warn("dropping malformed agent message")
+		} else if err != nil {
+			return
+		}
+		if _, err := h.messageDao.Record(ctx, agentID, msg.ID); err != nil {
+			logger.WithLabels("agent_id", agentID, "id", msg.ID, "error", err).Error("cannot record agent message")
+			return
+		}
+	}
+	ack, err := proto.NewMessage(proto.TypeAck, &proto.Ack{ID: msg.ID})
+	if err == nil {
+		err = c.send(ack)
+	}
+	if err != nil {
+		logger.WithLabels("agent_id", agentID, "id", msg.ID, "error", err).Warn("cannot acknowledge agent message")
+	}
+}
+
+// dispatch 调用消息类型对应的处理函数, 返回处理函数的错误; 未知类型的消息无法处理, 记录日志后丢弃
+func (h *Hub) dispatch(ctx context.Context, agentID int64, msg *proto.Message) error {
+	h.mu.RLock()
+	fn, ok := h.handlers[msg.Type]
+	h.mu.RUnlock()
+	if !ok {
+		logger.WithLabels("agent_id", agentID, "type", msg.Type).Warn("unknown agent message type")
+		return nil
+	}
+	err := fn(ctx, agentID, msg)
+	if err != nil {
+		logger.WithLabels("agent_id", agentID, "type", msg.Type, "error", err).Error("cannot handle agent message")
+	}
+	return err
+}
+
+// Run 定期将心跳超时的 agent 标记为离线并清理过期的消息ID, ctx 结束时关闭所有连接
+func (h *Hub) Run(ctx context.Context) error {
+	ticker := time.NewTicker(h.timeout / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			h.mu.Lock()
+			for id, c := range h.conns {
+				_ = c.ws.Close()
+				delete(h.conns, id)
+			}
+			h.mu.Unlock()
+			return nil
+		case <-ticker.C:
+			n, err := h.agentDao.MarkOffline(ctx, time.Now().Add(-h.timeout))
+			if err != nil {
+				logger.WithLabels("error", err).Error("cannot mark agents offline")
+			} else if n > 0 {
+				logger.WithLabels("count", n).Warn("agents marked offline")
+			}
+			if _, err := h.messageDao.Prune(ctx, time.Now().Add(-messageRetention)); err != nil {
+				logger.WithLabels("error", err).Error("cannot prune agent messages")
+			}
+		}
+	}
+}
+
+func (h *Hub) heartbeat(ctx context.Context, msg *proto.Message) (int64, error) {
+	hb := new(proto.Heartbeat)
+	if err := msg.Decode(hb); err != nil {
+		return 0, err
+	}
+	if hb.InstanceID == "" {
+		return 0, errors.New("heartbeat without instance id")
+	}
+	return h.agentDao.Heartbeat(ctx, &core.Agent{
+		InstanceID: hb.InstanceID,
+		HostName:   hb.HostName,
+		Version:    hb.Build.Version,
+		Branch:     hb.Build.Branch,
+		Commit:     hb.Build.Commit,
+		BuildTime:  hb.Build.BuildTime,
+		GoVersion:  hb.Build.GoVersion,
+		OS:         hb.Build.OS,
+		Arch:       hb.Build.Arch,
+	})
+}
+
+func (h *Hub) register(agentID int64, c *conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if old, ok := h.conns[agentID]; ok && old != c {
+		// 同一个 agent 重复连接, 关闭旧连接
+		_ = old.ws.Close()
+	}
+	h.conns[agentID] = c
+}
+
+func (h *Hub) unregister(agentID int64, c *conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.conns[agentID] == c {
+		delete(h.conns, agentID)
+	}
+}
@@ -0,0 +1,110 @@
+package agenthub
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/agent/proto"
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/gorilla/websocket"
+)
+
+type fakeAgentDao struct {
+	core.AgentDao
+}
+
+func (f *fakeAgentDao) Heartbeat(context.Context, *core.Agent) (int64, error) {
+	return 1, nil
+}
+
+type fakeMessageDao struct {
+	core.AgentMessageDao
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func (f *fakeMessageDao) Seen(_ context.Context, _ int64, id string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.seen[id], nil
+}
+
+func (f *fakeMessageDao) Record(_ context.Context, _ int64, id string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.seen[id] {
+		return false, nil
+	}
+	f.seen[id] = true
+	return true, nil
+}
+
+func TestMalformedReliableMessage(t *testing.T) {
+	hub := &Hub{
+		agentDao:   &fakeAgentDao{},
+		messageDao: &fakeMessageDao{seen: map[string]bool{}},
+		timeout:    5 * time.Second,
+		conns:      make(map[int64]*conn),
+		handlers:   make(map[string]HandlerFunc),
+	}
+	handled := make(chan []*proto.MetricSample, 1)
+	hub.Handle(proto.TypeMetrics, func(ctx context.Context, agentID int64, msg *proto.Message) error {
+		in := new(proto.MetricsBatch)
+		if err := msg.Decode(in); err != nil {
+			return err
+		}
+		handled <- in.Samples
+		return nil
+	})
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		ws, err := upgrader.Upgrade(writer, request, nil)
+		if err != nil {
+			return
+		}
+		_ = hub.Serve(context.Background(), ws)
+	}))
+	defer server.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	heartbeat, _ := proto.NewMessage(proto.TypeHeartbeat, &proto.Heartbeat{InstanceID: "i-1"})
+	bad := &proto.Message{ID: "bad", Type: proto.TypeMetrics, Reliable: true, Payload: json.RawMessage(`"samples"`)}
+	good, _ := proto.NewMessage(proto.TypeMetrics, &proto.MetricsBatch{Samples: []*proto.MetricSample{{Time: time.Now()}}})
+	good.Reliable = true
+	for _, msg := range []*proto.Message{heartbeat, bad, good} {
+		if err := ws.WriteJSON(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 无法解析的消息同样需要确认, 否则 agent 会一直重发它而不会发送后面的消息
+	_ = ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for _, want := range []string{bad.ID, good.ID} {
+		msg := new(proto.Message)
+		if err := ws.ReadJSON(msg); err != nil {
+			t.Fatal(err)
+		}
+		ack := new(proto.Ack)
+		if err := msg.Decode(ack); err != nil || msg.Type != proto.TypeAck || ack.ID != want {
+			t.Fatalf("got %s %s, want ack of %s", msg.Type, msg.Payload, want)
+		}
+	}
+	select {
+	case samples := <-handled:
+		if len(samples) != 1 {
+			t.Errorf("handled %d samples, want 1", len(samples))
+		}
+	default:
+		t.Error("message after the malformed one was not handled")
+	}
+}
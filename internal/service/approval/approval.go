@@ -0,0 +1,598 @@
+// Package approval 为删除主机、删除 namespace 和发布应用等操作提供审批
+// 操作匹配到审批规则时先创建审批请求, 审批人中有足够的人同意后由后台任务以申请人的身份执行, 每一步都记录在审计记录中
+// 并以 approval.<type> 为主题发布到事件总线, 例如 approval.created
+package approval
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/eventbus"
+	"github.com/bloodsteel/easynetes/internal/service/kube"
+	"github.com/bloodsteel/easynetes/internal/service/queue"
+	"github.com/bloodsteel/easynetes/internal/service/release"
+	"github.com/bloodsteel/easynetes/pkg/config"
+	"github.com/bloodsteel/easynetes/pkg/log"
+)
+
+var logger = log.RegisterScope("approval", "approval requests", 0)
+
+// resourceAppEnvironment 发布审批的资源类型, 资源ID为环境ID
+const resourceAppEnvironment = "app_environment"
+
+// TaskApprovalExecute 执行审批通过的操作的后台任务类型
+const TaskApprovalExecute = "approval_execute"
+
+var (
+	// ErrClosed 审批请求已经结束, 不能再审批或者取消
+	ErrClosed = errors.New("approval request is closed")
+	// ErrInvalidPayload 操作的参数不合法
+	ErrInvalidPayload = errors.New("invalid approval payload")
+)
+
+type (
+	// HostDeletePayload 删除主机的参数
+	HostDeletePayload struct {
+		HostID int64 `json:"host_id"`
+	}
+
+	// NamespaceDeletePayload 删除 namespace 的参数
+	NamespaceDeletePayload struct {
+		ClusterID int64  `json:"cluster_id"`
+		Namespace string `json:"namespace"`
+	}
+
+	// AppDeployPayload 发布应用的参数
+	AppDeployPayload struct {
+		AppID int64  `json:"app_id"`
+		EnvID int64  `json:"env_id"`
+		Image string `json:"image"`
+	}
+
+	// AppRollbackPayload 回滚应用的参数, ReleaseID 是回滚的目标版本
+	AppRollbackPayload struct {
+		AppID     int64 `json:"app_id"`
+		ReleaseID int64 `json:"release_id"`
+	}
+
+	// ExecutePayload approval_execute 任务的参数
+	ExecutePayload struct {
+		RequestID int64 `json:"request_id"`
+	}
+)
+
+// NamespaceDeleter 删除集群中的 namespace
+type NamespaceDeleter interface {
+	Delete(ctx context.Context, clusterID int64, name string) error
+}
+
+// Releaser 发布和回滚应用
+type Releaser interface {
+	Deploy(ctx context.Context, appID, envID int64, image string, user *core.User) (*core.AppRelease, error)
+	Rollback(ctx context.Context, appID, releaseID int64, user *core.User) (*core.AppRelease, error)
+}
+
+// Enqueuer 创建后台任务
+type Enqueuer interface {
+	Enqueue(ctx context.Context, taskType string, payload interface{}, priority int, creator string) (*core.Task, error)
+}
+
+// Service 管理审批规则和审批请求, 并在审批通过后执行被保护的操作
+type Service struct {
+	rules        core.ApprovalRuleDao
+	requests     core.ApprovalRequestDao
+	users        core.UserDao
+	hosts        core.HostInstanceDao
+	apps         core.ApplicationDao
+	envs         core.AppEnvironmentDao
+	releases     core.AppReleaseDao
+	namespaceDao core.KubeNamespaceDao
+	authorizer   core.Authorizer
+	bus          *eventbus.Bus
+	namespaces   NamespaceDeleter
+	releaser     Releaser
+	tasks        Enqueuer
+	interval     time.Duration
+	expire       time.Duration
+
+	// mu 保证同一时间只处理一次审批, 避免并发审批时重复统计
+	mu sync.Mutex
+}
+
+// ProvideService is a Wire provider
+func ProvideService(
+	rules core.ApprovalRuleDao,
+	requests core.ApprovalRequestDao,
+	users core.UserDao,
+	hosts core.HostInstanceDao,
+	apps core.ApplicationDao,
+	envs core.AppEnvironmentDao,
+	releases core.AppReleaseDao,
+	namespaceDao core.KubeNamespaceDao,
+	authorizer core.Authorizer,
+	bus *eventbus.Bus,
+	namespaces *kube.Namespaces,
+	releaser *release.Service,
+	tasks *queue.Queue,
+	cfg *config.Config,
+) *Service {
+	s := &Service{
+		rules:        rules,
+		requests:     requests,
+		users:        users,
+		hosts:        hosts,
+		apps:         apps,
+		envs:         envs,
+		releases:     releases,
+		namespaceDao: namespaceDao,
+		authorizer:   authorizer,
+		bus:          bus,
+		namespaces:   namespaces,
+		releaser:     releaser,
+		tasks:        tasks,
+		interval:     cfg.Approval.Interval * time.Second,
+		expire:       cfg.Approval.Expire * time.Hour,
+	}
+	tasks.Handle(TaskApprovalExecute, &executeHandler{s: s})
+	return s
+}
+
+// SaveRule 校验后创建(ID 为0时)或者更新审批规则, 更新时创建人不变
+func (s *Service) SaveRule(ctx context.Context, in *core.ApprovalRule) (*core.ApprovalRule, error) {
+	if err := in.Validate(); err != nil {
+		return nil, err
+	}
+	if in.ID == 0 {
+		if _, err := s.rules.Create(ctx, in); err != nil {
+			return nil, err
+		}
+		return in, nil
+	}
+	current, err := s.rules.Get(ctx, in.ID)
+	if err != nil {
+		return nil, err
+	}
+	in.Creator = current.Creator
+	in.CreateTime = current.CreateTime
+	if err := s.rules.Update(ctx, in); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+// Submit 为操作创建审批请求, 没有匹配的审批规则时返回 nil, 调用方直接执行操作
+// payload 是操作的参数, 类型必须与 action 对应
+func (s *Service) Submit(ctx context.Context, action string, payload interface{}, reason string,
+	user *core.User) (*core.ApprovalRequest, error) {
+	req, err := s.resolve(ctx, action, payload, user)
+	if err != nil {
+		return nil, err
+	}
+	rule, err := s.match(ctx, req)
+	if err != nil || rule == nil {
+		return nil, err
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	expire := s.expire
+	if rule.ExpireHours > 0 {
+		expire = time.Duration(rule.ExpireHours) * time.Hour
+	}
+	req.RuleID = rule.ID
+	req.Payload = data
+	req.Reason = reason
+	req.Requester = user.UserName
+	req.RequesterID = user.ID
+	req.Approvers = rule.Approvers
+	req.Required = rule.Required
+	req.Status = core.ApprovalPending
+	req.ExpireTime = time.Now().Add(expire)
+	if _, err := s.requests.Create(ctx, req); err != nil {
+		return nil, err
+	}
+	s.audit(ctx, req, core.ApprovalEventCreated, user.UserName, reason)
+	logger.WithLabels("request_id", req.ID, "action", action, "resource", req.ResourceID,
+		"requester", user.UserName, "rule", rule.Name).Info("approval request created")
+	return req, nil
+}
+
+// resolve 根据操作的参数找到资源以及所在的服务树节点和环境, 并检查申请人的权限
+func (s *Service) resolve(ctx context.Context, action string, payload interface{},
+	user *core.User) (*core.ApprovalRequest, error) {
+	req := &core.ApprovalRequest{Action: action}
+	switch p := payload.(type) {
+	case HostDeletePayload:
+		if action != core.ApprovalHostDelete {
+			break
+		}
+		host, err := s.hosts.Get(ctx, p.HostID)
+		if err != nil {
+			return nil, err
+		}
+		req.ResourceType = core.ResourceHost
+		req.ResourceID = strconv.FormatInt(host.ID, 10)
+		return req, nil
+	case NamespaceDeletePayload:
+		if action != core.ApprovalNamespaceDelete {
+			break
+		}
+		ns, err := s.namespaceDao.Get(ctx, p.ClusterID, p.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		req.ResourceType = core.ResourceKubeNamespace
+		req.ResourceID = core.KubeNamespaceResource(ns.ClusterID, ns.Name)
+		req.ServiceNodeID = ns.ServiceNodeID
+		return req, nil
+	case AppDeployPayload:
+		if action != core.ApprovalAppDeploy {
+			break
+		}
+		return s.resolveRelease(ctx, req, p.AppID, p.EnvID, user)
+	case AppRollbackPayload:
+		if action != core.ApprovalAppRollback {
+			break
+		}
+		target, err := s.releases.Get(ctx, p.ReleaseID)
+		if err != nil {
+			return nil, err
+		}
+		if target.AppID != p.AppID {
+			return nil, sql.ErrNoRows
+		}
+		// 与 release.Service.Rollback 的校验一致, 避免为不能回滚的版本发起审批
+		if target.Status != core.AppReleaseApplied {
+			return nil, release.ErrRollbackTarget
+		}
+		return s.resolveRelease(ctx, req, p.AppID, target.EnvID, user)
+	}
+	return nil, fmt.Errorf("%w: %T for %s", ErrInvalidPayload, payload, action)
+}
+
+// resolveRelease 找到发布或者回滚的环境, 并检查申请人在环境所在 namespace 的发布权限
+func (s *Service) resolveRelease(ctx context.Context, req *core.ApprovalRequest, appID, envID int64,
+	user *core.User) (*core.ApprovalRequest, error) {
+	env, err := s.envs.Get(ctx, envID)
+	if err != nil {
+		return nil, err
+	}
+	app, err := s.apps.Get(ctx, env.AppID)
+	if err != nil {
+		return nil, err
+	}
+	if app.ID != appID {
+		return nil, ErrInvalidPayload
+	}
+	// 没有发布权限的用户不能发起审批, 执行时还会以申请人的身份再检查一次
+	err = s.authorizer.Authorize(ctx, user, core.ResourceKubeNamespace,
+		core.KubeNamespaceResource(env.ClusterID, env.Namespace), core.ActionKubeApply)
+	if err != nil {
+		return nil, err
+	}
+	req.ResourceType = resourceAppEnvironment
+	req.ResourceID = strconv.FormatInt(env.ID, 10)
+	req.ServiceNodeID = app.ServiceNodeID
+	req.EnvID = env.ID
+	return req, nil
+}
+
+// match 返回匹配操作的范围最小的规则, 环境优先于服务树节点, 范围相同时使用ID最小的规则
+// 回滚也是一次发布, 使用发布的审批规则
+func (s *Service) match(ctx context.Context, req *core.ApprovalRequest) (*core.ApprovalRule, error) {
+	action := req.Action
+	if action == core.ApprovalAppRollback {
+		action = core.ApprovalAppDeploy
+	}
+	rules, err := s.rules.List(ctx, map[string]interface{}{"action": action})
+	if err != nil {
+		return nil, err
+	}
+	var (
+		best  *core.ApprovalRule
+		score = -1
+	)
+	for _, rule := range rules {
+		if rule.Action != action {
+			continue
+		}
+		if (rule.EnvID != 0 && rule.EnvID != req.EnvID) ||
+			(rule.ServiceNodeID != 0 && rule.ServiceNodeID != req.ServiceNodeID) {
+			continue
+		}
+		n := 0
+		if rule.EnvID != 0 {
+			n += 2
+		}
+		if rule.ServiceNodeID != 0 {
+			n++
+		}
+		if n > score {
+			best, score = rule, n
+		}
+	}
+	return best, nil
+}
+
+// Vote 同意或者拒绝审批请求, 只有审批人可以审批, 申请人不能审批自己的请求
+// 任意审批人拒绝时请求被拒绝; 同意的人数达到要求后创建以申请人的身份执行操作的后台任务, 返回更新后的请求和该任务
+// 审批记录就是投票本身, 无法保存时审批失败
+func (s *Service) Vote(ctx context.Context, id int64, user *core.User, approved bool,
+	comment string) (*core.ApprovalRequest, *core.Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	req, err := s.requests.Get(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if req.Status != core.ApprovalPending {
+		return nil, nil, ErrClosed
+	}
+	if !req.Approvers.Contains(user.UserName) || user.ID == req.RequesterID {
+		return nil, nil, core.ErrForbidden
+	}
+	if !approved {
+		if err := s.finish(ctx, req, core.ApprovalPending, core.ApprovalRejected, "rejected by "+user.UserName); err != nil {
+			return nil, nil, err
+		}
+		if err := s.audit(ctx, req, core.ApprovalEventRejected, user.UserName, comment); err != nil {
+			return nil, nil, err
+		}
+		return req, nil, nil
+	}
+	if err := s.audit(ctx, req, core.ApprovalEventApproved, user.UserName, comment); err != nil {
+		return nil, nil, err
+	}
+	events, err := s.requests.ListEvents(ctx, req.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if approvals(events) < req.Required {
+		return req, nil, nil
+	}
+	ok, err := s.requests.Transition(ctx, req.ID, core.ApprovalPending, core.ApprovalExecuting)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		return nil, nil, ErrClosed
+	}
+	task, err := s.tasks.Enqueue(ctx, TaskApprovalExecute, ExecutePayload{RequestID: req.ID}, 0, user.UserName)
+	if err != nil {
+		// 退回待审批, 审批人再次同意时重新创建任务
+		if _, rollbackErr := s.requests.Transition(ctx, req.ID, core.ApprovalExecuting, core.ApprovalPending); rollbackErr != nil {
+			logger.WithLabels("request_id", req.ID, "error", rollbackErr).Error("cannot reset approval request")
+		}
+		return nil, nil, err
+	}
+	req.Status = core.ApprovalExecuting
+	return req, task, nil
+}
+
+// Comment 在审批请求上添加评论, 审批人、申请人和管理员都可以评论
+func (s *Service) Comment(ctx context.Context, id int64, user *core.User, comment string) error {
+	req, err := s.requests.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !user.IsAdmin && user.ID != req.RequesterID && !req.Approvers.Contains(user.UserName) {
+		return core.ErrForbidden
+	}
+	return s.requests.AddEvent(ctx, &core.ApprovalEvent{
+		RequestID: id,
+		Type:      core.ApprovalEventComment,
+		Operator:  user.UserName,
+		Comment:   comment,
+	})
+}
+
+// Cancel 取消待审批的请求, 只有申请人和管理员可以取消
+func (s *Service) Cancel(ctx context.Context, id int64, user *core.User) (*core.ApprovalRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	req, err := s.requests.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !user.IsAdmin && user.ID != req.RequesterID {
+		return nil, core.ErrForbidden
+	}
+	if err := s.finish(ctx, req, core.ApprovalPending, core.ApprovalCanceled, "canceled by "+user.UserName); err != nil {
+		return nil, err
+	}
+	s.audit(ctx, req, core.ApprovalEventCanceled, user.UserName, "")
+	return req, nil
+}
+
+// Run 定期将过期的待审批请求标记为已过期
+func (s *Service) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := s.Expire(ctx); err != nil {
+				logger.WithLabels("error", err).Warn("cannot expire approval requests")
+			}
+		}
+	}
+}
+
+// Expire 将过期时间已到的待审批请求标记为已过期
+func (s *Service) Expire(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expired, err := s.requests.ListExpired(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, req := range expired {
+		err := s.finish(ctx, req, core.ApprovalPending, core.ApprovalExpired, "not approved before "+
+			req.ExpireTime.Format(time.RFC3339))
+		if errors.Is(err, ErrClosed) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		s.audit(ctx, req, core.ApprovalEventExpired, "", "")
+		logger.WithLabels("request_id", req.ID, "action", req.Action).Info("approval request expired")
+	}
+	return nil
+}
+
+// executeHandler 执行审批通过的操作的后台任务
+type executeHandler struct {
+	s *Service
+}
+
+func (h *executeHandler) Validate(payload json.RawMessage) error {
+	in := new(ExecutePayload)
+	if err := json.Unmarshal(payload, in); err != nil {
+		return err
+	}
+	if in.RequestID <= 0 {
+		return errors.New("request_id is required")
+	}
+	return nil
+}
+
+// Handle 执行处于 executing 状态的审批请求, 请求已经结束时不再执行
+// 操作有副作用, 执行失败时请求标记为 failed, 任务也不再重试
+func (h *executeHandler) Handle(ctx context.Context, payload json.RawMessage, progress queue.Progress) (interface{}, error) {
+	in := new(ExecutePayload)
+	if err := json.Unmarshal(payload, in); err != nil {
+		return nil, fmt.Errorf("%w: %v", core.ErrInvalidTask, err)
+	}
+	req, err := h.s.requests.Get(ctx, in.RequestID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: approval request %d not found", core.ErrInvalidTask, in.RequestID)
+	} else if err != nil {
+		return nil, err
+	}
+	if req.Status != core.ApprovalExecuting {
+		return nil, fmt.Errorf("%w: approval request %d is %s", core.ErrInvalidTask, req.ID, req.Status)
+	}
+	progress(0, fmt.Sprintf("executing %s of approval request %d", req.Action, req.ID))
+	if err := h.s.execute(ctx, req); err != nil {
+		return req.Result, fmt.Errorf("%w: %v", core.ErrInvalidTask, err)
+	}
+	return req.Result, nil
+}
+
+// execute 以申请人的身份执行被保护的操作并保存结果, 返回操作或者保存结果的错误
+func (s *Service) execute(ctx context.Context, req *core.ApprovalRequest) error {
+	result, runErr := s.run(ctx, req)
+	status, event := core.ApprovalExecuted, core.ApprovalEventExecuted
+	if runErr != nil {
+		status, event, result = core.ApprovalFailed, core.ApprovalEventFailed, runErr.Error()
+	}
+	logger.WithLabels("request_id", req.ID, "action", req.Action, "resource", req.ResourceID,
+		"status", status).Info("approved operation executed")
+	if err := s.finish(ctx, req, core.ApprovalExecuting, status, result); err != nil {
+		return fmt.Errorf("cannot save approval result: %w", err)
+	}
+	_ = s.audit(ctx, req, event, "", result)
+	return runErr
+}
+
+func (s *Service) run(ctx context.Context, req *core.ApprovalRequest) (string, error) {
+	requester, err := s.users.Get(ctx, req.RequesterID)
+	if err != nil {
+		return "", fmt.Errorf("cannot load requester: %w", err)
+	}
+	switch req.Action {
+	case core.ApprovalHostDelete:
+		var p HostDeletePayload
+		if err := json.Unmarshal(req.Payload, &p); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("host %d deleted", p.HostID), s.hosts.Delete(ctx, p.HostID)
+	case core.ApprovalNamespaceDelete:
+		var p NamespaceDeletePayload
+		if err := json.Unmarshal(req.Payload, &p); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("namespace %s deleted", p.Namespace), s.namespaces.Delete(ctx, p.ClusterID, p.Namespace)
+	case core.ApprovalAppDeploy:
+		var p AppDeployPayload
+		if err := json.Unmarshal(req.Payload, &p); err != nil {
+			return "", err
+		}
+		return applied(s.releaser.Deploy(ctx, p.AppID, p.EnvID, p.Image, requester))
+	case core.ApprovalAppRollback:
+		var p AppRollbackPayload
+		if err := json.Unmarshal(req.Payload, &p); err != nil {
+			return "", err
+		}
+		return applied(s.releaser.Rollback(ctx, p.AppID, p.ReleaseID, requester))
+	}
+	return "", fmt.Errorf("%w: unknown action %s", ErrInvalidPayload, req.Action)
+}
+
+// applied 将发布的结果转换为审批请求的执行结果, 发布记录的状态不是 applied 时按失败处理
+func applied(out *core.AppRelease, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	if out.Status != core.AppReleaseApplied {
+		return "", fmt.Errorf("release revision %d %s: %s", out.Revision, out.Status, out.Message)
+	}
+	return fmt.Sprintf("release revision %d applied", out.Revision), nil
+}
+
+// finish 只有当前状态为 from 时才将请求更新为 to 并保存结果, 否则返回 ErrClosed
+func (s *Service) finish(ctx context.Context, req *core.ApprovalRequest, from, to, result string) error {
+	ok, err := s.requests.Transition(ctx, req.ID, from, to)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrClosed
+	}
+	now := time.Now()
+	req.Status = to
+	req.Result = result
+	req.FinishTime = &now
+	return s.requests.Finish(ctx, req)
+}
+
+// audit 添加审计记录并发布 approval.<type> 事件, 返回保存审计记录的错误, 保存失败时不发布事件
+// 审批依赖审计记录统计同意的人数, 其他操作的审计记录保存失败时只记录日志, 不影响操作的结果
+func (s *Service) audit(ctx context.Context, req *core.ApprovalRequest, typ, operator, comment string) error {
+	err := s.requests.AddEvent(ctx, &core.ApprovalEvent{
+		RequestID: req.ID,
+		Type:      typ,
+		Operator:  operator,
+		Comment:   comment,
+	})
+	if err != nil {
+		logger.WithLabels("request_id", req.ID, "type", typ, "error", err).Warn("cannot save approval event")
+		return err
+	}
+	// 订阅者异步处理事件, 发布副本避免与后续的修改竞争
+	published := *req
+	s.bus.Publish("approval."+typ, &published)
+	return nil
+}
+
+// approvals 统计同意的审批人数量, 同一个人多次同意只计算一次
+func approvals(events []*core.ApprovalEvent) int {
+	seen := map[string]bool{}
+	for _, event := range events {
+		if event.Type == core.ApprovalEventApproved {
+			seen[event.Operator] = true
+		}
+	}
+	return len(seen)
+}
@@ -0,0 +1,340 @@
+package approval
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/eventbus"
+)
+
+type fakeRuleDao struct {
+	core.ApprovalRuleDao
+	rules []*core.ApprovalRule
+}
+
+func (f *fakeRuleDao) List(context.Context, map[string]interface{}) ([]*core.ApprovalRule, error) {
+	return f.rules, nil
+}
+
+type fakeRequestDao struct {
+	mu         sync.Mutex
+	requests   map[int64]core.ApprovalRequest
+	events     []*core.ApprovalEvent
+	failEvents bool
+}
+
+func (f *fakeRequestDao) Get(_ context.Context, id int64) (*core.ApprovalRequest, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	req, ok := f.requests[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &req, nil
+}
+
+func (f *fakeRequestDao) List(context.Context, map[string]interface{}) ([]*core.ApprovalRequest, error) {
+	return nil, nil
+}
+
+func (f *fakeRequestDao) Count(context.Context, map[string]interface{}) (int64, error) { return 0, nil }
+
+func (f *fakeRequestDao) ListExpired(_ context.Context, now time.Time) ([]*core.ApprovalRequest, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []*core.ApprovalRequest
+	for _, req := range f.requests {
+		req := req
+		if req.Status == core.ApprovalPending && req.ExpireTime.Before(now) {
+			out = append(out, &req)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeRequestDao) Create(_ context.Context, req *core.ApprovalRequest) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.requests == nil {
+		f.requests = map[int64]core.ApprovalRequest{}
+	}
+	req.ID = int64(len(f.requests) + 1)
+	f.requests[req.ID] = *req
+	return req.ID, nil
+}
+
+func (f *fakeRequestDao) Transition(_ context.Context, id int64, from, to string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	req := f.requests[id]
+	if req.Status != from {
+		return false, nil
+	}
+	req.Status = to
+	f.requests[id] = req
+	return true, nil
+}
+
+func (f *fakeRequestDao) Finish(_ context.Context, req *core.ApprovalRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests[req.ID] = *req
+	return nil
+}
+
+func (f *fakeRequestDao) AddEvent(_ context.Context, event *core.ApprovalEvent) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failEvents {
+		return errors.New("database is down")
+	}
+	f.events = append(f.events, event)
+	return nil
+}
+
+func (f *fakeRequestDao) ListEvents(_ context.Context, id int64) ([]*core.ApprovalEvent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []*core.ApprovalEvent
+	for _, event := range f.events {
+		if event.RequestID == id {
+			out = append(out, event)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeRequestDao) eventTypes(id int64) []string {
+	events, _ := f.ListEvents(context.Background(), id)
+	out := make([]string, 0, len(events))
+	for _, event := range events {
+		out = append(out, event.Type)
+	}
+	return out
+}
+
+type fakeHostDao struct {
+	core.HostInstanceDao
+	deleted []int64
+}
+
+func (f *fakeHostDao) Get(_ context.Context, id int64) (*core.HostInstance, error) {
+	return &core.HostInstance{ID: id}, nil
+}
+
+func (f *fakeHostDao) Delete(_ context.Context, id int64) error {
+	f.deleted = append(f.deleted, id)
+	return nil
+}
+
+type fakeEnvDao struct {
+	core.AppEnvironmentDao
+}
+
+func (fakeEnvDao) Get(_ context.Context, id int64) (*core.AppEnvironment, error) {
+	return &core.AppEnvironment{ID: id, AppID: 5, ClusterID: 1, Namespace: "prod"}, nil
+}
+
+type fakeAppDao struct {
+	core.ApplicationDao
+}
+
+func (fakeAppDao) Get(_ context.Context, id int64) (*core.Application, error) {
+	return &core.Application{ID: id, ServiceNodeID: 30}, nil
+}
+
+type fakeUserDao struct {
+	core.UserDao
+}
+
+func (fakeUserDao) Get(_ context.Context, id int64) (*core.User, error) {
+	return &core.User{ID: id, UserName: "dev"}, nil
+}
+
+type allowAll struct{}
+
+func (allowAll) Authorize(context.Context, *core.User, string, string, string) error { return nil }
+
+type fakeReleaser struct {
+	users []string
+}
+
+func (f *fakeReleaser) Deploy(_ context.Context, appID, envID int64, image string, user *core.User) (*core.AppRelease, error) {
+	f.users = append(f.users, user.UserName)
+	return &core.AppRelease{AppID: appID, EnvID: envID, Image: image, Revision: 3, Status: core.AppReleaseApplied}, nil
+}
+
+func (f *fakeReleaser) Rollback(_ context.Context, appID, _ int64, user *core.User) (*core.AppRelease, error) {
+	f.users = append(f.users, user.UserName)
+	return &core.AppRelease{AppID: appID, Revision: 4, Status: core.AppReleaseApplied}, nil
+}
+
+type fakeEnqueuer struct {
+	tasks []*core.Task
+}
+
+func (f *fakeEnqueuer) Enqueue(_ context.Context, taskType string, payload interface{}, _ int, creator string) (*core.Task, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	task := &core.Task{ID: int64(len(f.tasks) + 1), Type: taskType, Payload: data, Status: core.TaskPending, Creator: creator}
+	f.tasks = append(f.tasks, task)
+	return task, nil
+}
+
+func newTestService(rules ...*core.ApprovalRule) (*Service, *fakeRequestDao, *fakeHostDao, *fakeReleaser) {
+	requests, hosts, releaser := &fakeRequestDao{}, &fakeHostDao{}, &fakeReleaser{}
+	return &Service{
+		rules:      &fakeRuleDao{rules: rules},
+		requests:   requests,
+		users:      fakeUserDao{},
+		hosts:      hosts,
+		apps:       fakeAppDao{},
+		envs:       fakeEnvDao{},
+		authorizer: allowAll{},
+		bus:        eventbus.ProvideBus(),
+		releaser:   releaser,
+		tasks:      &fakeEnqueuer{},
+		expire:     time.Hour,
+	}, requests, hosts, releaser
+}
+
+func TestMatch(t *testing.T) {
+	s, _, _, _ := newTestService(
+		&core.ApprovalRule{ID: 1, Name: "all", Action: core.ApprovalAppDeploy, Approvers: core.StringList{"a"}, Required: 1},
+		&core.ApprovalRule{ID: 2, Name: "node", Action: core.ApprovalAppDeploy, ServiceNodeID: 30, Approvers: core.StringList{"b"}, Required: 1},
+		&core.ApprovalRule{ID: 3, Name: "other env", Action: core.ApprovalAppDeploy, EnvID: 8, Approvers: core.StringList{"c"}, Required: 1},
+		&core.ApprovalRule{ID: 4, Name: "prod", Action: core.ApprovalAppDeploy, EnvID: 7, Approvers: core.StringList{"d"}, Required: 1},
+		&core.ApprovalRule{ID: 5, Name: "hosts", Action: core.ApprovalHostDelete, Approvers: core.StringList{"e"}, Required: 1},
+	)
+	cases := []struct {
+		req  core.ApprovalRequest
+		want int64
+	}{
+		{core.ApprovalRequest{Action: core.ApprovalAppDeploy, ServiceNodeID: 30, EnvID: 7}, 4},
+		{core.ApprovalRequest{Action: core.ApprovalAppDeploy, ServiceNodeID: 30, EnvID: 9}, 2},
+		{core.ApprovalRequest{Action: core.ApprovalAppDeploy, ServiceNodeID: 31, EnvID: 9}, 1},
+		{core.ApprovalRequest{Action: core.ApprovalAppRollback, ServiceNodeID: 30, EnvID: 7}, 4},
+		{core.ApprovalRequest{Action: core.ApprovalHostDelete}, 5},
+		{core.ApprovalRequest{Action: core.ApprovalNamespaceDelete}, 0},
+	}
+	for _, c := range cases {
+		rule, err := s.match(context.Background(), &c.req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got int64
+		if rule != nil {
+			got = rule.ID
+		}
+		if got != c.want {
+			t.Errorf("match(%+v) = rule %d, want %d", c.req, got, c.want)
+		}
+	}
+}
+
+func TestVote(t *testing.T) {
+	ctx := context.Background()
+	s, requests, _, releaser := newTestService(&core.ApprovalRule{ID: 1, Name: "prod", Action: core.ApprovalAppDeploy,
+		Approvers: core.StringList{"dev", "lead", "ops", "sre"}, Required: 2})
+	dev := &core.User{ID: 9, UserName: "dev"}
+	req, err := s.Submit(ctx, core.ApprovalAppDeploy, AppDeployPayload{AppID: 5, EnvID: 7, Image: "api:v2"}, "release v2", dev)
+	if err != nil || req == nil {
+		t.Fatalf("submit = %v, %v", req, err)
+	}
+	if req.EnvID != 7 || req.ServiceNodeID != 30 || req.Status != core.ApprovalPending {
+		t.Fatalf("request = %+v", req)
+	}
+
+	if _, _, err := s.Vote(ctx, req.ID, dev, true, ""); err != core.ErrForbidden {
+		t.Fatalf("self approval: %v", err)
+	}
+	if _, _, err := s.Vote(ctx, req.ID, &core.User{ID: 2, UserName: "guest"}, true, ""); err != core.ErrForbidden {
+		t.Fatalf("approval by non-approver: %v", err)
+	}
+	lead := &core.User{ID: 3, UserName: "lead"}
+	for i := 0; i < 2; i++ {
+		if req, _, err = s.Vote(ctx, req.ID, lead, true, "lgtm"); err != nil || req.Status != core.ApprovalPending {
+			t.Fatalf("first approval = %v, %v", req.Status, err)
+		}
+	}
+	ops := &core.User{ID: 4, UserName: "ops"}
+	requests.failEvents = true
+	if _, _, err := s.Vote(ctx, req.ID, ops, true, ""); err == nil {
+		t.Fatal("vote succeeded without its audit event")
+	}
+	requests.failEvents = false
+	req, task, err := s.Vote(ctx, req.ID, ops, true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 操作由后台任务执行, 审批时不执行
+	if req.Status != core.ApprovalExecuting || task == nil || task.Type != TaskApprovalExecute {
+		t.Fatalf("request = %s, task = %+v, want executing with a task", req.Status, task)
+	}
+	if len(releaser.users) != 0 {
+		t.Fatal("deployed while voting")
+	}
+	handler := &executeHandler{s: s}
+	if _, err := handler.Handle(ctx, task.Payload, func(int, string) {}); err != nil {
+		t.Fatal(err)
+	}
+	if req, _ = requests.Get(ctx, req.ID); req.Status != core.ApprovalExecuted || req.Result != "release revision 3 applied" {
+		t.Fatalf("request = %s %q, want executed", req.Status, req.Result)
+	}
+	if len(releaser.users) != 1 || releaser.users[0] != "dev" {
+		t.Fatalf("deploy users = %v, want requester", releaser.users)
+	}
+	// 任务重新执行时请求已经结束, 不会再次发布
+	if _, err := handler.Handle(ctx, task.Payload, func(int, string) {}); !errors.Is(err, core.ErrInvalidTask) || len(releaser.users) != 1 {
+		t.Fatalf("second execution = %v, deploy users = %v", err, releaser.users)
+	}
+	if _, _, err := s.Vote(ctx, req.ID, &core.User{ID: 5, UserName: "sre"}, true, ""); err != ErrClosed {
+		t.Fatalf("approve closed request: %v", err)
+	}
+	want := []string{"created", "approved", "approved", "approved", "executed"}
+	if got := requests.eventTypes(req.ID); len(got) != len(want) || got[4] != want[4] {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+}
+
+func TestRejectAndExpire(t *testing.T) {
+	ctx := context.Background()
+	s, requests, hosts, _ := newTestService(&core.ApprovalRule{ID: 1, Name: "hosts", Action: core.ApprovalHostDelete,
+		Approvers: core.StringList{"lead"}, Required: 1})
+	dev := &core.User{ID: 9, UserName: "dev"}
+	rejected, _ := s.Submit(ctx, core.ApprovalHostDelete, HostDeletePayload{HostID: 11}, "", dev)
+	expired, _ := s.Submit(ctx, core.ApprovalHostDelete, HostDeletePayload{HostID: 12}, "", dev)
+	if _, err := s.Submit(ctx, core.ApprovalHostDelete, NamespaceDeletePayload{}, "", dev); err == nil {
+		t.Fatal("submit with mismatched payload succeeded")
+	}
+
+	req, _, err := s.Vote(ctx, rejected.ID, &core.User{ID: 3, UserName: "lead"}, false, "still in use")
+	if err != nil || req.Status != core.ApprovalRejected {
+		t.Fatalf("reject = %v, %v", req, err)
+	}
+	requests.mu.Lock()
+	r := requests.requests[expired.ID]
+	r.ExpireTime = time.Now().Add(-time.Minute)
+	requests.requests[expired.ID] = r
+	requests.mu.Unlock()
+	if err := s.Expire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if req, _ = requests.Get(ctx, expired.ID); req.Status != core.ApprovalExpired {
+		t.Fatalf("status = %s, want expired", req.Status)
+	}
+	if _, err := s.Cancel(ctx, expired.ID, dev); err != ErrClosed {
+		t.Fatalf("cancel expired request: %v", err)
+	}
+	if len(hosts.deleted) != 0 {
+		t.Fatalf("hosts deleted without approval: %v", hosts.deleted)
+	}
+}
@@ -0,0 +1,388 @@
+// Package cmdbhook 将 CMDB 资源的变更事件投递到外部系统订阅的 webhook
+// 每个事件先保存为投递记录再异步投递, 使用 HMAC-SHA256 签名, 失败时按指数退避重试, 次数用完后进入死信列表
+package cmdbhook
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/eventbus"
+	"github.com/bloodsteel/easynetes/internal/service/notify"
+	"github.com/bloodsteel/easynetes/pkg/config"
+	"github.com/bloodsteel/easynetes/pkg/encrypt"
+	"github.com/bloodsteel/easynetes/pkg/log"
+)
+
+var logger = log.RegisterScope("cmdbhook", "cmdb change webhooks", 0)
+
+// Topics 可以订阅的 CMDB 资源变更事件
+// 目前只有主机的 DAO 会发布变更, 可用区和服务树节点没有变更事件, 订阅它们的事件过滤会被拒绝
+var Topics = []string{"host.*"}
+
+// ErrNotDead 只有死信列表中的投递可以重新投递
+var ErrNotDead = errors.New("delivery is not dead")
+
+// errPermanent 重试也不会成功的错误, 例如订阅已经被删除或者禁用
+var errPermanent = errors.New("permanent failure")
+
+const (
+	// maxBackoff 两次重试之间的最长间隔
+	maxBackoff = time.Hour
+	// batchSize 每次最多投递的数量
+	batchSize = 100
+)
+
+// Service 管理 CMDB 变更的 webhook 订阅并投递事件
+type Service struct {
+	hooks       core.CMDBHookDao
+	deliveries  core.CMDBHookDeliveryDao
+	bus         *eventbus.Bus
+	encrypter   *encrypt.Encrypter
+	http        *http.Client
+	interval    time.Duration
+	backoff     time.Duration
+	maxAttempts int
+	timeout     time.Duration
+	worker      string
+}
+
+// ProvideService is a Wire provider
+func ProvideService(
+	hooks core.CMDBHookDao,
+	deliveries core.CMDBHookDeliveryDao,
+	bus *eventbus.Bus,
+	encrypter *encrypt.Encrypter,
+	cfg *config.Config,
+) *Service {
+	timeout := cfg.CMDBHook.Timeout * time.Second
+	hostname, _ := os.Hostname()
+	return &Service{
+		hooks:       hooks,
+		deliveries:  deliveries,
+		bus:         bus,
+		encrypter:   encrypter,
+		http:        &http.Client{Timeout: timeout},
+		interval:    cfg.CMDBHook.Interval * time.Second,
+		backoff:     cfg.CMDBHook.Backoff * time.Second,
+		maxAttempts: cfg.CMDBHook.MaxAttempts,
+		timeout:     timeout,
+		worker:      fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+	}
+}
+
+// Create 校验后创建订阅, secret 是签名密钥, 加密后保存
+func (s *Service) Create(ctx context.Context, hook *core.CMDBHook, secret string) (*core.CMDBHook, error) {
+	if err := s.setHook(hook, secret); err != nil {
+		return nil, err
+	}
+	if _, err := s.hooks.Create(ctx, hook); err != nil {
+		return nil, err
+	}
+	return hook, nil
+}
+
+// Update 更新订阅, secret 为空时保留原有的密钥
+func (s *Service) Update(ctx context.Context, hook *core.CMDBHook, secret string) (*core.CMDBHook, error) {
+	old, err := s.hooks.Get(ctx, hook.ID)
+	if err != nil {
+		return nil, err
+	}
+	old.Name = hook.Name
+	old.URL = hook.URL
+	old.Events = hook.Events
+	old.Enabled = hook.Enabled
+	if secret == "" {
+		if secret, err = s.secret(old); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.setHook(old, secret); err != nil {
+		return nil, err
+	}
+	if err := s.hooks.Update(ctx, old); err != nil {
+		return nil, err
+	}
+	return old, nil
+}
+
+// Redeliver 将死信列表中的投递重新放回队列, 立即投递一次并返回结果
+// 放回队列是条件更新, 同时重新投递同一条死信时只有一个请求成功
+func (s *Service) Redeliver(ctx context.Context, id int64) (*core.CMDBHookDelivery, error) {
+	delivery, err := s.deliveries.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	hook, err := s.hook(ctx, delivery.HookID)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := s.deliveries.Requeue(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNotDead
+	}
+	if delivery, err = s.deliveries.Get(ctx, id); err != nil {
+		return nil, err
+	}
+	s.send(ctx, delivery, hook)
+	return delivery, nil
+}
+
+// Run 订阅 CMDB 资源的变更事件, 并定期投递到期的事件
+// 事件总线在缓冲区满时丢弃事件, 被丢弃的事件不会投递, 定期记录丢弃的数量
+func (s *Service) Run(ctx context.Context) error {
+	sub := s.bus.Subscribe("cmdbhook", 256, Topics...)
+	defer sub.Close()
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	var dropped uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event := <-sub.C:
+			if err := s.Dispatch(ctx, event); err != nil {
+				logger.WithLabels("event", event.Topic, "error", err).Warn("cannot dispatch cmdb change")
+			}
+		case <-ticker.C:
+			if n := sub.Dropped(); n > dropped {
+				logger.WithLabels("dropped", n-dropped, "total", n).Error("cmdb changes dropped before deliveries were created")
+				dropped = n
+			}
+			if err := s.Flush(ctx); err != nil {
+				logger.WithLabels("error", err).Warn("cannot deliver cmdb changes")
+			}
+		}
+	}
+}
+
+// Dispatch 为订阅了事件的每个启用的 webhook 创建投递记录
+func (s *Service) Dispatch(ctx context.Context, event eventbus.Event) error {
+	hooks, err := s.hooks.List(ctx)
+	if err != nil {
+		return err
+	}
+	var payload []byte
+	for _, hook := range hooks {
+		if !hook.Enabled || !subscribed(hook, event.Topic) {
+			continue
+		}
+		if payload == nil {
+			payload, err = json.Marshal(map[string]interface{}{
+				"event": event.Topic,
+				"time":  event.Time,
+				"data":  event.Data,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		delivery := &core.CMDBHookDelivery{
+			HookID:   hook.ID,
+			Event:    event.Topic,
+			Payload:  string(payload),
+			Status:   core.CMDBHookPending,
+			NextTime: time.Now(),
+		}
+		if _, err := s.deliveries.Create(ctx, delivery); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush 投递所有到期的事件
+func (s *Service) Flush(ctx context.Context) error {
+	due, err := s.deliveries.ListDue(ctx, time.Now(), batchSize)
+	if err != nil {
+		return err
+	}
+	hooks := map[int64]*core.CMDBHook{}
+	for _, delivery := range due {
+		hook, ok := hooks[delivery.HookID]
+		if !ok {
+			if hook, err = s.hook(ctx, delivery.HookID); err != nil {
+				return err
+			}
+			hooks[delivery.HookID] = hook
+		}
+		s.send(ctx, delivery, hook)
+	}
+	return nil
+}
+
+// send 领取并投递一个事件, 保存结果, 失败时按指数退避安排下一次投递, 次数用完后进入死信列表
+// 多个实例同时投递时只有领取成功的实例投递, 租约在请求超时之后到期, 实例退出后由其他实例重新投递
+func (s *Service) send(ctx context.Context, delivery *core.CMDBHookDelivery, hook *core.CMDBHook) {
+	now := time.Now()
+	ok, err := s.deliveries.Claim(ctx, delivery.ID, s.worker, now, now.Add(2*s.timeout))
+	if err != nil {
+		logger.WithLabels("delivery_id", delivery.ID, "error", err).Warn("cannot claim cmdb webhook delivery")
+		return
+	}
+	if !ok {
+		return
+	}
+	delivery.Worker = s.worker
+	delivery.Attempts++
+	code, err := s.post(ctx, delivery, hook)
+	now = time.Now()
+	delivery.ResponseCode = code
+	switch {
+	case err == nil:
+		delivery.Status = core.CMDBHookDelivered
+		delivery.LastError = ""
+		delivery.DeliveredTime = &now
+	case delivery.Attempts >= s.maxAttempts || errors.Is(err, errPermanent):
+		delivery.Status = core.CMDBHookDead
+		delivery.LastError = err.Error()
+	default:
+		delivery.LastError = err.Error()
+		delivery.NextTime = now.Add(s.retryAfter(delivery.Attempts))
+	}
+	if err != nil {
+		logger.WithLabels("delivery_id", delivery.ID, "event", delivery.Event, "hook_id", delivery.HookID,
+			"attempts", delivery.Attempts, "error", err).Warn("cannot deliver cmdb change")
+	}
+	if ok, err := s.deliveries.Update(ctx, delivery); err != nil {
+		logger.WithLabels("delivery_id", delivery.ID, "error", err).Warn("cannot save cmdb webhook delivery")
+	} else if !ok {
+		logger.WithLabels("delivery_id", delivery.ID).Warn("cmdb webhook delivery was claimed by another worker, result discarded")
+	}
+}
+
+// post 发送签名后的请求, 返回响应的状态码, 非 2xx 的响应视为失败
+func (s *Service) post(ctx context.Context, delivery *core.CMDBHookDelivery, hook *core.CMDBHook) (int, error) {
+	if hook == nil {
+		return 0, fmt.Errorf("%w: webhook has been deleted", errPermanent)
+	}
+	if !hook.Enabled {
+		return 0, fmt.Errorf("%w: webhook is disabled", errPermanent)
+	}
+	secret, err := s.secret(hook)
+	if err != nil {
+		return 0, fmt.Errorf("%w: cannot decrypt secret: %v", errPermanent, err)
+	}
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", errPermanent, err)
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(notify.HeaderEvent, delivery.Event)
+	req.Header.Set(notify.HeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(notify.HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	if secret != "" {
+		req.Header.Set(notify.HeaderSignature, notify.Sign(secret, timestamp, body))
+	}
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// retryAfter 第 n 次投递失败后等待的时间
+func (s *Service) retryAfter(n int) time.Duration {
+	d := s.backoff
+	for i := 1; i < n && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
+
+// hook 获取订阅, 订阅已经被删除时返回 nil
+func (s *Service) hook(ctx context.Context, id int64) (*core.CMDBHook, error) {
+	hook, err := s.hooks.Get(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return hook, err
+}
+
+// setHook 校验订阅的地址和事件过滤后加密保存密钥
+func (s *Service) setHook(hook *core.CMDBHook, secret string) error {
+	if hook.Name == "" {
+		return fmt.Errorf("%w: name is required", core.ErrInvalidCMDBHook)
+	}
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url must be an http(s) address", core.ErrInvalidCMDBHook)
+	}
+	if len(hook.Events) == 0 {
+		return fmt.Errorf("%w: events are required", core.ErrInvalidCMDBHook)
+	}
+	for _, pattern := range hook.Events {
+		if !valid(pattern) {
+			return fmt.Errorf("%w: unknown event %q", core.ErrInvalidCMDBHook, pattern)
+		}
+	}
+	hook.Secret = nil
+	if secret == "" {
+		return nil
+	}
+	hook.Secret, err = s.encrypter.Encrypt([]byte(secret))
+	return err
+}
+
+func (s *Service) secret(hook *core.CMDBHook) (string, error) {
+	if len(hook.Secret) == 0 {
+		return "", nil
+	}
+	data, err := s.encrypter.Decrypt(hook.Secret)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// subscribed 判断订阅的事件过滤是否匹配主题
+func subscribed(hook *core.CMDBHook, topic string) bool {
+	for _, pattern := range hook.Events {
+		if eventbus.Match(pattern, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// valid 判断事件过滤是否只会匹配 CMDB 资源的变更, 例如 host.created、host.* 或者 *
+func valid(pattern string) bool {
+	if pattern == "*" {
+		return true
+	}
+	for _, topic := range Topics {
+		if pattern == topic {
+			return true
+		}
+		resource := topic[:len(topic)-1]
+		for _, op := range []string{core.ChangeCreated, core.ChangeUpdated, core.ChangeDeleted, core.ChangeStatus} {
+			if pattern == resource+op {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,268 @@
+package cmdbhook
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/eventbus"
+	"github.com/bloodsteel/easynetes/internal/service/notify"
+	"github.com/bloodsteel/easynetes/pkg/config"
+	"github.com/bloodsteel/easynetes/pkg/encrypt"
+)
+
+type fakeHookDao struct {
+	core.CMDBHookDao
+	items map[int64]*core.CMDBHook
+}
+
+func (f *fakeHookDao) Get(_ context.Context, id int64) (*core.CMDBHook, error) {
+	hook, ok := f.items[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	out := *hook
+	return &out, nil
+}
+
+func (f *fakeHookDao) List(context.Context) ([]*core.CMDBHook, error) {
+	var out []*core.CMDBHook
+	for id := int64(1); id <= int64(len(f.items)); id++ {
+		out = append(out, f.items[id])
+	}
+	return out, nil
+}
+
+func (f *fakeHookDao) Create(_ context.Context, hook *core.CMDBHook) (int64, error) {
+	hook.ID = int64(len(f.items) + 1)
+	f.items[hook.ID] = hook
+	return hook.ID, nil
+}
+
+type fakeDeliveryDao struct {
+	core.CMDBHookDeliveryDao
+	mu    sync.Mutex
+	items []core.CMDBHookDelivery
+}
+
+func (f *fakeDeliveryDao) Get(_ context.Context, id int64) (*core.CMDBHookDelivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := f.items[id-1]
+	return &out, nil
+}
+
+func (f *fakeDeliveryDao) ListDue(_ context.Context, now time.Time, _ int) ([]*core.CMDBHookDelivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []*core.CMDBHookDelivery
+	for _, item := range f.items {
+		item := item
+		if item.Status == core.CMDBHookPending && !item.NextTime.After(now) {
+			out = append(out, &item)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeDeliveryDao) Create(_ context.Context, delivery *core.CMDBHookDelivery) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delivery.ID = int64(len(f.items) + 1)
+	f.items = append(f.items, *delivery)
+	return delivery.ID, nil
+}
+
+func (f *fakeDeliveryDao) Claim(_ context.Context, id int64, worker string, now, until time.Time) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	item := &f.items[id-1]
+	if item.Status != core.CMDBHookPending || item.NextTime.After(now) {
+		return false, nil
+	}
+	item.Worker, item.NextTime = worker, until
+	return true, nil
+}
+
+func (f *fakeDeliveryDao) Update(_ context.Context, delivery *core.CMDBHookDelivery) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.items[delivery.ID-1].Worker != delivery.Worker {
+		return false, nil
+	}
+	f.items[delivery.ID-1] = *delivery
+	return true, nil
+}
+
+func (f *fakeDeliveryDao) Requeue(_ context.Context, id int64) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	item := &f.items[id-1]
+	if item.Status != core.CMDBHookDead {
+		return false, nil
+	}
+	item.Status, item.Attempts, item.NextTime = core.CMDBHookPending, 0, time.Now()
+	return true, nil
+}
+
+// due 将所有待投递的记录改为立即投递, 跳过退避等待
+func (f *fakeDeliveryDao) due() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.items {
+		f.items[i].NextTime = time.Now()
+	}
+}
+
+func newTestService(t *testing.T) (*Service, *fakeDeliveryDao) {
+	t.Helper()
+	encrypter, err := encrypt.New("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{}
+	cfg.SetDefault()
+	cfg.CMDBHook.Timeout = 2
+	cfg.CMDBHook.MaxAttempts = 2
+	deliveries := &fakeDeliveryDao{}
+	s := ProvideService(&fakeHookDao{items: map[int64]*core.CMDBHook{}}, deliveries, eventbus.ProvideBus(), encrypter, cfg)
+	return s, deliveries
+}
+
+func TestDeliverDeadAndRedeliver(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		fail   = true
+		body   []byte
+		header http.Header
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ = io.ReadAll(r.Body)
+		header = r.Header
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	s, deliveries := newTestService(t)
+	for _, pattern := range []string{"host.moved", "zone.*", "service_node.created"} {
+		if _, err := s.Create(ctx, &core.CMDBHook{Name: "bad", URL: server.URL, Events: core.StringList{pattern}}, ""); err == nil {
+			t.Fatalf("unknown event %s must be rejected", pattern)
+		}
+	}
+	hook, err := s.Create(ctx, &core.CMDBHook{Name: "asset", URL: server.URL, Enabled: true,
+		Events: core.StringList{"host.status_changed"}}, "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create(ctx, &core.CMDBHook{Name: "other", URL: server.URL, Enabled: true,
+		Events: core.StringList{"host.deleted"}}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	change := &core.ResourceChange{Resource: core.ResourceHost, ID: 9, Op: core.ChangeStatus,
+		Data: &core.HostInstance{ID: 9, HostName: "web-1"}}
+	if err := s.Dispatch(ctx, eventbus.Event{Topic: change.Topic(), Time: time.Now(), Data: change}); err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries.items) != 1 || deliveries.items[0].HookID != hook.ID {
+		t.Fatalf("deliveries = %+v, want one for hook %d", deliveries.items, hook.ID)
+	}
+
+	// 两次失败后进入死信列表
+	for i := 0; i < 2; i++ {
+		if err := s.Flush(ctx); err != nil {
+			t.Fatal(err)
+		}
+		deliveries.due()
+	}
+	d := deliveries.items[0]
+	if d.Status != core.CMDBHookDead || d.Attempts != 2 || d.ResponseCode != http.StatusServiceUnavailable {
+		t.Fatalf("after failures: %+v", d)
+	}
+
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+	out, err := s.Redeliver(ctx, d.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Status != core.CMDBHookDelivered || out.Attempts != 1 || out.DeliveredTime == nil {
+		t.Fatalf("after redeliver: %+v", out)
+	}
+	if _, err := s.Redeliver(ctx, d.ID); err != ErrNotDead {
+		t.Fatalf("redeliver delivered: %v", err)
+	}
+	timestamp, _ := strconv.ParseInt(header.Get(notify.HeaderTimestamp), 10, 64)
+	if header.Get(notify.HeaderSignature) != notify.Sign("s3cret", timestamp, body) ||
+		header.Get(notify.HeaderEvent) != "host.status_changed" {
+		t.Fatalf("bad signature headers %v", header)
+	}
+	var payload struct {
+		Event string `json:"event"`
+		Data  struct {
+			ID   int64 `json:"id"`
+			Data struct {
+				HostName string `json:"host_name"`
+			} `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil || payload.Event != "host.status_changed" ||
+		payload.Data.ID != 9 || payload.Data.Data.HostName != "web-1" {
+		t.Fatalf("payload %s: %v", body, err)
+	}
+}
+
+func TestFlushClaim(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	s, deliveries := newTestService(t)
+	if _, err := s.Create(ctx, &core.CMDBHook{Name: "asset", URL: server.URL, Enabled: true,
+		Events: core.StringList{"host.*"}}, ""); err != nil {
+		t.Fatal(err)
+	}
+	change := &core.ResourceChange{Resource: core.ResourceHost, ID: 9, Op: core.ChangeDeleted}
+	if err := s.Dispatch(ctx, eventbus.Event{Topic: change.Topic(), Time: time.Now(), Data: change}); err != nil {
+		t.Fatal(err)
+	}
+	// 两个实例同时投递同一个事件, 只有领取成功的实例投递
+	other := *s
+	other.worker = "other"
+	var wg sync.WaitGroup
+	for _, svc := range []*Service{s, &other} {
+		wg.Add(1)
+		go func(svc *Service) {
+			defer wg.Done()
+			if err := svc.Flush(ctx); err != nil {
+				t.Error(err)
+			}
+		}(svc)
+	}
+	wg.Wait()
+	if d := deliveries.items[0]; requests != 1 || d.Status != core.CMDBHookDelivered || d.Attempts != 1 {
+		t.Fatalf("requests = %d, delivery = %+v", requests, d)
+	}
+}
@@ -0,0 +1,406 @@
+// Package cron 按 cron 表达式在 apiserver 中定期执行内置类型的任务
+// 多个副本都会检查到期的任务, 通过数据库中 next_time 的比较并更新认领每一次执行, 只有一个副本会执行
+package cron
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/eventbus"
+	"github.com/bloodsteel/easynetes/internal/service/hostexec"
+	"github.com/bloodsteel/easynetes/internal/service/kube"
+	"github.com/bloodsteel/easynetes/pkg/config"
+	"github.com/bloodsteel/easynetes/pkg/log"
+)
+
+var logger = log.RegisterScope("cron", "scheduled jobs", 0)
+
+const (
+	// maxOutput 执行记录保留的输出长度
+	maxOutput = 64 << 10
+	// staleGrace 执行超过 Deadline 多久之后认为所在的副本已经退出
+	staleGrace = time.Minute
+)
+
+// Service 管理定时任务, 并在到期时执行
+type Service struct {
+	jobs     core.CronJobDao
+	runs     core.CronRunDao
+	bus      *eventbus.Bus
+	tasks    map[string]Task
+	location *time.Location
+	interval time.Duration
+	timeout  time.Duration
+	kick     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// ProvideService is a Wire provider
+func ProvideService(
+	jobs core.CronJobDao,
+	runs core.CronRunDao,
+	hosts core.HostInstanceDao,
+	agents core.AgentDao,
+	clusters core.KubeClusterDao,
+	notifyDeliveries core.NotifyDeliveryDao,
+	hookDeliveries core.CMDBHookDeliveryDao,
+	tasks core.TaskDao,
+	bus *eventbus.Bus,
+	executor *hostexec.Executor,
+	nodes *kube.NodeSync,
+	cfg *config.Config,
+) (*Service, error) {
+	location, err := time.LoadLocation(cfg.Cron.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("cron timezone: %w", err)
+	}
+	return &Service{
+		jobs: jobs,
+		runs: runs,
+		bus:  bus,
+		tasks: map[string]Task{
+			TaskHostCommand: &hostCommandTask{hosts: hosts, executor: executor},
+			TaskCMDBSync:    &cmdbSyncTask{clusters: clusters, nodes: nodes},
+			TaskCleanup: &cleanupTask{targets: map[string]pruner{
+				"cron_runs":            runs,
+				"notify_deliveries":    notifyDeliveries,
+				"cmdb_hook_deliveries": hookDeliveries,
+				"tasks":                tasks,
+			}},
+			TaskReport: &reportTask{hosts: hosts, agents: agents},
+		},
+		location: location,
+		interval: cfg.Cron.Interval * time.Second,
+		timeout:  cfg.Cron.Timeout * time.Second,
+		kick:     make(chan struct{}, 1),
+	}, nil
+}
+
+// Tasks 返回所有可用的任务类型
+func (s *Service) Tasks() []string {
+	out := make([]string, 0, len(s.tasks))
+	for name := range s.tasks {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Save 校验后创建(ID 为0时)或者更新定时任务, 并根据新的表达式重新计算下一次执行时间
+// 更新时创建人和暂停状态不变, 暂停和恢复使用 Pause/Resume
+func (s *Service) Save(ctx context.Context, in *core.CronJob) (*core.CronJob, error) {
+	if in.Name == "" {
+		return nil, fmt.Errorf("%w: name is required", core.ErrInvalidCronJob)
+	}
+	task, ok := s.tasks[in.TaskType]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown task type %q", core.ErrInvalidCronJob, in.TaskType)
+	}
+	if err := task.Validate(in.Params); err != nil {
+		return nil, fmt.Errorf("%w: %v", core.ErrInvalidCronJob, err)
+	}
+	if in.Timeout < 0 {
+		return nil, fmt.Errorf("%w: timeout must not be negative", core.ErrInvalidCronJob)
+	}
+	if len(in.Params) == 0 {
+		in.Params = []byte("{}")
+	}
+	job := in
+	if in.ID > 0 {
+		current, err := s.jobs.Get(ctx, in.ID)
+		if err != nil {
+			return nil, err
+		}
+		current.Name = in.Name
+		current.TaskType = in.TaskType
+		current.Schedule = in.Schedule
+		current.Timezone = in.Timezone
+		current.Params = in.Params
+		current.Timeout = in.Timeout
+		job = current
+	}
+	next, err := s.next(job, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	job.NextTime = &next
+	if in.ID == 0 {
+		_, err = s.jobs.Create(ctx, job)
+	} else {
+		err = s.jobs.Update(ctx, job)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Pause 暂停定时任务, 正在执行的任务不受影响
+func (s *Service) Pause(ctx context.Context, id int64) (*core.CronJob, error) {
+	job, err := s.jobs.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	job.Paused = true
+	if err := s.jobs.Update(ctx, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Resume 恢复定时任务, 从当前时间重新计算下一次执行时间, 暂停期间错过的执行不会补上
+func (s *Service) Resume(ctx context.Context, id int64) (*core.CronJob, error) {
+	job, err := s.jobs.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	next, err := s.next(job, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	job.Paused = false
+	job.NextTime = &next
+	if err := s.jobs.Update(ctx, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Trigger 手动触发一次执行, 暂停的任务也可以触发; 执行记录先处于 pending, 由某个副本认领后执行
+func (s *Service) Trigger(ctx context.Context, id int64, operator *core.User) (*core.CronRun, error) {
+	job, err := s.jobs.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	run := &core.CronRun{
+		JobID:    job.ID,
+		TaskType: job.TaskType,
+		Trigger:  core.CronTriggerManual,
+		Operator: operator.UserName,
+		Status:   core.CronRunPending,
+	}
+	if _, err := s.runs.Create(ctx, run); err != nil {
+		return nil, err
+	}
+	select {
+	case s.kick <- struct{}{}:
+	default:
+	}
+	return run, nil
+}
+
+// Run 定期检查到期的定时任务和手动触发的执行, ctx 结束时等待进行中的执行退出
+func (s *Service) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	defer s.wg.Wait()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-s.kick:
+		}
+		s.Tick(ctx)
+	}
+}
+
+// Tick 认领并执行所有到期的定时任务和等待中的手动执行, 并将所在副本已经退出的执行标记为失败
+func (s *Service) Tick(ctx context.Context) {
+	now := time.Now()
+	due, err := s.jobs.ListDue(ctx, now)
+	if err != nil {
+		logger.WithLabels("error", err).Error("cannot list due cron jobs")
+	}
+	for _, job := range due {
+		s.schedule(ctx, job, now)
+	}
+
+	pending, err := s.runs.ListPending(ctx)
+	if err != nil {
+		logger.WithLabels("error", err).Error("cannot list pending cron runs")
+	}
+	for _, run := range pending {
+		job, err := s.jobs.Get(ctx, run.JobID)
+		if errors.Is(err, sql.ErrNoRows) {
+			job, err = nil, nil
+		}
+		if err != nil {
+			logger.WithLabels("run_id", run.ID, "error", err).Error("cannot load cron job")
+			continue
+		}
+		started := time.Now()
+		deadline := started.Add(s.jobTimeout(job))
+		run.StartTime, run.Deadline = &started, &deadline
+		ok, err := s.runs.Start(ctx, run)
+		if err != nil || !ok {
+			continue
+		}
+		if job == nil {
+			s.finish(ctx, run, "", fmt.Errorf("cron job %d has been deleted", run.JobID))
+			continue
+		}
+		s.start(ctx, job, run)
+	}
+
+	stale, err := s.runs.ListStale(ctx, now.Add(-staleGrace))
+	if err != nil {
+		logger.WithLabels("error", err).Error("cannot list stale cron runs")
+	}
+	for _, run := range stale {
+		s.finish(ctx, run, "", errors.New("run was interrupted, the replica executing it exited"))
+	}
+}
+
+// schedule 认领一次到期的执行, 上一次执行还没有结束时跳过这一次
+func (s *Service) schedule(ctx context.Context, job *core.CronJob, now time.Time) {
+	next, err := s.next(job, now)
+	if err != nil {
+		logger.WithLabels("job_id", job.ID, "error", err).Error("cannot compute next cron time")
+		return
+	}
+	ok, err := s.jobs.Claim(ctx, job.ID, *job.NextTime, next)
+	if err != nil {
+		logger.WithLabels("job_id", job.ID, "error", err).Error("cannot claim cron job")
+		return
+	}
+	if !ok {
+		return
+	}
+	running, err := s.runs.Count(ctx, map[string]interface{}{"job_id": job.ID, "status": core.CronRunRunning})
+	if err != nil {
+		logger.WithLabels("job_id", job.ID, "error", err).Error("cannot count running cron runs")
+		return
+	}
+	if running > 0 {
+		logger.WithLabels("job_id", job.ID, "job", job.Name).Warn("previous run is still running, skipped")
+		return
+	}
+	deadline := now.Add(s.jobTimeout(job))
+	run := &core.CronRun{
+		JobID:     job.ID,
+		TaskType:  job.TaskType,
+		Trigger:   core.CronTriggerSchedule,
+		Status:    core.CronRunRunning,
+		StartTime: &now,
+		Deadline:  &deadline,
+	}
+	if _, err := s.runs.Create(ctx, run); err != nil {
+		logger.WithLabels("job_id", job.ID, "error", err).Error("cannot create cron run")
+		return
+	}
+	s.start(ctx, job, run)
+}
+
+// start 在后台执行任务, 执行结束后保存输出和结果
+func (s *Service) start(ctx context.Context, job *core.CronJob, run *core.CronRun) {
+	task, ok := s.tasks[job.TaskType]
+	if !ok {
+		s.finish(ctx, run, "", fmt.Errorf("unknown task type %q", job.TaskType))
+		return
+	}
+	logger.WithLabels("job_id", job.ID, "job", job.Name, "run_id", run.ID, "trigger", run.Trigger).Info("cron run started")
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		runCtx, cancel := context.WithDeadline(ctx, *run.Deadline)
+		defer cancel()
+		out := &tailWriter{limit: maxOutput}
+		err := task.Run(runCtx, job.Params, out)
+		if err == nil && runCtx.Err() != nil {
+			err = runCtx.Err()
+		}
+		// 服务退出时 ctx 已经结束, 仍然需要保存执行结果
+		s.finish(context.WithoutCancel(ctx), run, out.String(), err)
+	}()
+}
+
+// finish 保存执行结果并发布 cron.run.<status> 事件, 执行记录已经被其他副本结束时不重复发布
+func (s *Service) finish(ctx context.Context, run *core.CronRun, output string, err error) {
+	now := time.Now()
+	run.Output = output
+	run.FinishTime = &now
+	run.Status = core.CronRunSucceeded
+	run.Error = ""
+	if err != nil {
+		run.Status = core.CronRunFailed
+		run.Error = err.Error()
+	}
+	ok, saveErr := s.runs.Finish(ctx, run)
+	if saveErr != nil {
+		logger.WithLabels("run_id", run.ID, "error", saveErr).Error("cannot save cron run")
+		return
+	}
+	if !ok {
+		return
+	}
+	logger.WithLabels("job_id", run.JobID, "run_id", run.ID, "status", run.Status, "error", run.Error).Info("cron run finished")
+	published := *run
+	s.bus.Publish("cron.run."+run.Status, &published)
+}
+
+// next 在任务的时区中计算 after 之后的执行时间
+func (s *Service) next(job *core.CronJob, after time.Time) (time.Time, error) {
+	schedule, err := Parse(job.Schedule)
+	if err != nil {
+		return time.Time{}, err
+	}
+	location := s.location
+	if job.Timezone != "" {
+		if location, err = time.LoadLocation(job.Timezone); err != nil {
+			return time.Time{}, fmt.Errorf("%w: unknown timezone %q", core.ErrInvalidCronJob, job.Timezone)
+		}
+	}
+	next := schedule.Next(after.In(location))
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("%w: schedule %q never fires", core.ErrInvalidCronJob, job.Schedule)
+	}
+	return next, nil
+}
+
+func (s *Service) jobTimeout(job *core.CronJob) time.Duration {
+	if job != nil && job.Timeout > 0 {
+		return time.Duration(job.Timeout) * time.Second
+	}
+	return s.timeout
+}
+
+// tailWriter 只保留最后写入的 limit 个字节, 可以被多个 goroutine 同时写入
+type tailWriter struct {
+	mu        sync.Mutex
+	limit     int
+	buf       []byte
+	truncated bool
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	if len(w.buf) > w.limit {
+		w.buf = w.buf[len(w.buf)-w.limit:]
+		w.truncated = true
+	}
+	return len(p), nil
+}
+
+func (w *tailWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.truncated {
+		return string(w.buf)
+	}
+	// 从第一个完整的行开始, 避免截断多字节字符
+	out := string(w.buf)
+	if i := strings.IndexByte(out, '\n'); i >= 0 {
+		out = out[i+1:]
+	}
+	return "...\n" + out
+}
@@ -0,0 +1,279 @@
+package cron
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/eventbus"
+)
+
+// fakeStore 模拟多个副本共享的数据库
+type fakeStore struct {
+	mu   sync.Mutex
+	jobs map[int64]core.CronJob
+	runs []core.CronRun
+}
+
+type fakeJobDao struct {
+	core.CronJobDao
+	*fakeStore
+}
+
+func (f fakeJobDao) Get(_ context.Context, id int64) (*core.CronJob, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	job, ok := f.jobs[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &job, nil
+}
+
+func (f fakeJobDao) ListDue(_ context.Context, now time.Time) ([]*core.CronJob, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []*core.CronJob
+	for _, job := range f.jobs {
+		job := job
+		if !job.Paused && !job.NextTime.After(now) {
+			out = append(out, &job)
+		}
+	}
+	return out, nil
+}
+
+func (f fakeJobDao) Create(_ context.Context, job *core.CronJob) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	job.ID = int64(len(f.jobs) + 1)
+	f.jobs[job.ID] = *job
+	return job.ID, nil
+}
+
+func (f fakeJobDao) Update(_ context.Context, job *core.CronJob) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.jobs[job.ID] = *job
+	return nil
+}
+
+func (f fakeJobDao) Claim(_ context.Context, id int64, from, next time.Time) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	job := f.jobs[id]
+	if job.Paused || !job.NextTime.Equal(from) {
+		return false, nil
+	}
+	job.NextTime = &next
+	f.jobs[id] = job
+	return true, nil
+}
+
+type fakeRunDao struct {
+	core.CronRunDao
+	*fakeStore
+}
+
+func (f fakeRunDao) Count(_ context.Context, in map[string]interface{}) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var n int64
+	for _, run := range f.runs {
+		if run.JobID == in["job_id"] && run.Status == in["status"] {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (f fakeRunDao) list(match func(*core.CronRun) bool) []*core.CronRun {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []*core.CronRun
+	for _, run := range f.runs {
+		run := run
+		if match(&run) {
+			out = append(out, &run)
+		}
+	}
+	return out
+}
+
+func (f fakeRunDao) ListPending(context.Context) ([]*core.CronRun, error) {
+	return f.list(func(run *core.CronRun) bool { return run.Status == core.CronRunPending }), nil
+}
+
+func (f fakeRunDao) ListStale(_ context.Context, before time.Time) ([]*core.CronRun, error) {
+	return f.list(func(run *core.CronRun) bool {
+		return run.Status == core.CronRunRunning && run.Deadline.Before(before)
+	}), nil
+}
+
+func (f fakeRunDao) Create(_ context.Context, run *core.CronRun) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	run.ID = int64(len(f.runs) + 1)
+	f.runs = append(f.runs, *run)
+	return run.ID, nil
+}
+
+func (f fakeRunDao) Start(_ context.Context, run *core.CronRun) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.runs[run.ID-1].Status != core.CronRunPending {
+		return false, nil
+	}
+	run.Status = core.CronRunRunning
+	f.runs[run.ID-1] = *run
+	return true, nil
+}
+
+func (f fakeRunDao) Finish(_ context.Context, run *core.CronRun) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.runs[run.ID-1].Status != core.CronRunRunning {
+		return false, nil
+	}
+	f.runs[run.ID-1] = *run
+	return true, nil
+}
+
+// fakeTask 输出参数中的 message, fail 为 true 时失败
+type fakeTask struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (f *fakeTask) Validate(params json.RawMessage) error {
+	return decode(params, new(struct{ Message string }))
+}
+
+func (f *fakeTask) Run(_ context.Context, params json.RawMessage, out io.Writer) error {
+	f.mu.Lock()
+	f.calls++
+	f.mu.Unlock()
+	in := new(struct {
+		Message string `json:"message"`
+		Fail    bool   `json:"fail"`
+	})
+	_ = decode(params, in)
+	fmt.Fprintln(out, in.Message)
+	if in.Fail {
+		return fmt.Errorf("failed on purpose")
+	}
+	return nil
+}
+
+func newTestService(store *fakeStore, task Task, bus *eventbus.Bus) *Service {
+	return &Service{
+		jobs:     fakeJobDao{fakeStore: store},
+		runs:     fakeRunDao{fakeStore: store},
+		bus:      bus,
+		tasks:    map[string]Task{"fake": task},
+		location: time.UTC,
+		interval: time.Second,
+		timeout:  time.Minute,
+		kick:     make(chan struct{}, 1),
+	}
+}
+
+func TestScheduleOnce(t *testing.T) {
+	ctx := context.Background()
+	store := &fakeStore{jobs: map[int64]core.CronJob{}}
+	task := &fakeTask{}
+	bus := eventbus.ProvideBus()
+	sub := bus.Subscribe("test", 4, "cron.run.*")
+	defer sub.Close()
+	// 两个副本共享同一个数据库
+	a, b := newTestService(store, task, bus), newTestService(store, task, bus)
+
+	if _, err := a.Save(ctx, &core.CronJob{Name: "bad", TaskType: "fake", Schedule: "* * *"}); err == nil {
+		t.Fatal("invalid schedule must be rejected")
+	}
+	if _, err := a.Save(ctx, &core.CronJob{Name: "bad", TaskType: "fake", Schedule: "@hourly", Timezone: "Mars/Base"}); err == nil {
+		t.Fatal("unknown timezone must be rejected")
+	}
+	job, err := a.Save(ctx, &core.CronJob{Name: "hello", TaskType: "fake", Schedule: "*/5 * * * *",
+		Timezone: "Asia/Shanghai", Params: json.RawMessage(`{"message":"hi"}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.NextTime == nil || !job.NextTime.After(time.Now()) || job.NextTime.Minute()%5 != 0 {
+		t.Fatalf("next time = %v", job.NextTime)
+	}
+
+	// 让任务到期, 两个副本同时检查, 只执行一次
+	due := time.Now().Add(-time.Minute).Truncate(time.Minute)
+	store.jobs[job.ID] = func() core.CronJob { j := store.jobs[job.ID]; j.NextTime = &due; return j }()
+	a.Tick(ctx)
+	b.Tick(ctx)
+	a.wg.Wait()
+	b.wg.Wait()
+	if task.calls != 1 || len(store.runs) != 1 {
+		t.Fatalf("calls = %d, runs = %d, want 1", task.calls, len(store.runs))
+	}
+	run := store.runs[0]
+	if run.Status != core.CronRunSucceeded || run.Trigger != core.CronTriggerSchedule || run.Output != "hi\n" {
+		t.Fatalf("run = %+v", run)
+	}
+	if next := store.jobs[job.ID].NextTime; !next.After(time.Now()) {
+		t.Fatalf("next time not advanced: %v", next)
+	}
+	if event := <-sub.C; event.Topic != "cron.run.succeeded" {
+		t.Fatalf("unexpected event %s", event.Topic)
+	}
+
+	// 暂停后不再执行, 但可以手动触发
+	if _, err := a.Pause(ctx, job.ID); err != nil {
+		t.Fatal(err)
+	}
+	store.jobs[job.ID] = func() core.CronJob { j := store.jobs[job.ID]; j.NextTime = &due; return j }()
+	manual, err := a.Trigger(ctx, job.ID, &core.User{UserName: "admin"})
+	if err != nil || manual.Status != core.CronRunPending {
+		t.Fatalf("trigger: %+v, %v", manual, err)
+	}
+	b.Tick(ctx)
+	a.Tick(ctx)
+	a.wg.Wait()
+	b.wg.Wait()
+	if task.calls != 2 || len(store.runs) != 2 || store.runs[1].Status != core.CronRunSucceeded ||
+		store.runs[1].Operator != "admin" {
+		t.Fatalf("calls = %d, runs = %+v", task.calls, store.runs)
+	}
+	resumed, err := a.Resume(ctx, job.ID)
+	if err != nil || resumed.Paused || !resumed.NextTime.After(time.Now()) {
+		t.Fatalf("resume: %+v, %v", resumed, err)
+	}
+}
+
+func TestFailedAndStale(t *testing.T) {
+	ctx := context.Background()
+	store := &fakeStore{jobs: map[int64]core.CronJob{}}
+	s := newTestService(store, &fakeTask{}, eventbus.ProvideBus())
+	job, err := s.Save(ctx, &core.CronJob{Name: "fail", TaskType: "fake", Schedule: "@daily",
+		Params: json.RawMessage(`{"message":"boom","fail":true}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 另一个副本执行中退出留下的执行记录
+	past := time.Now().Add(-time.Hour)
+	store.runs = append(store.runs, core.CronRun{ID: 1, JobID: job.ID, Status: core.CronRunRunning, Deadline: &past})
+	if _, err := s.Trigger(ctx, job.ID, &core.User{UserName: "admin"}); err != nil {
+		t.Fatal(err)
+	}
+	s.Tick(ctx)
+	s.wg.Wait()
+	if run := store.runs[0]; run.Status != core.CronRunFailed || run.Error == "" {
+		t.Fatalf("stale run = %+v", run)
+	}
+	if run := store.runs[1]; run.Status != core.CronRunFailed || run.Error != "failed on purpose" || run.Output != "boom\n" {
+		t.Fatalf("failed run = %+v", run)
+	}
+}
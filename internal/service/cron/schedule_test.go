@@ -0,0 +1,48 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip(err)
+	}
+	base := time.Date(2024, 1, 31, 10, 17, 30, 0, time.UTC)
+	cases := []struct {
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"*/15 * * * *", base, time.Date(2024, 1, 31, 10, 30, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", base, time.Date(2024, 2, 1, 9, 0, 0, 0, time.UTC)},
+		{"@monthly", base, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", base, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", base, time.Time{}},
+		// 日期和星期都有限制时满足任意一个即可: 2月1日是星期四, 2月3日是星期六
+		{"0 0 3 * 4", base, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", base, time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)},
+		{"5/20 10 * * *", base, time.Date(2024, 1, 31, 10, 25, 0, 0, time.UTC)},
+		// 在任务的时区中计算: 上海时间 02:00 是 UTC 前一天 18:00
+		{"0 2 * * *", base.In(shanghai), time.Date(2024, 1, 31, 18, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		s, err := Parse(c.spec)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", c.spec, err)
+		}
+		if got := s.Next(c.from); !got.Equal(c.want) {
+			t.Errorf("Next(%q) = %v, want %v", c.spec, got, c.want)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * * * * *", "*/0 * * * *", "5-1 * * * *", "0 0 * foo *"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) should fail", spec)
+		}
+	}
+}
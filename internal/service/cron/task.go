@@ -0,0 +1,279 @@
+package cron
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/kube"
+)
+
+// 内置的定时任务类型
+const (
+	// TaskHostCommand 在一组主机上通过 agent 执行脚本
+	TaskHostCommand = "host_command"
+	// TaskCMDBSync 将 kubernetes 集群的节点同步为 CMDB 中的主机
+	TaskCMDBSync = "cmdb_sync"
+	// TaskCleanup 清理过期的执行记录、通知发送记录、webhook 投递记录和已经结束的后台任务
+	TaskCleanup = "cleanup"
+	// TaskReport 生成主机和 agent 的资产报表, 报表在执行记录的输出中, 可以通过订阅 cron.run.succeeded 事件发送
+	TaskReport = "report"
+)
+
+// defaultRetention 清理任务没有配置天数时保留的天数
+const defaultRetention = 30
+
+// Task 一种定时任务的类型, params 是定时任务的 JSON 参数
+type Task interface {
+	// Validate 保存定时任务时校验参数
+	Validate(params json.RawMessage) error
+	// Run 执行任务, 输出写入 out, 返回错误表示执行失败
+	Run(ctx context.Context, params json.RawMessage, out io.Writer) error
+}
+
+// Executor 在主机上执行脚本
+type Executor interface {
+	Exec(ctx context.Context, hostID int64, script string, env map[string]string,
+		timeout time.Duration, out io.Writer) (int, error)
+}
+
+// NodeSyncer 同步一个集群的节点
+type NodeSyncer interface {
+	Sync(ctx context.Context, clusterID int64) (*kube.NodeSyncResult, error)
+}
+
+// HostCommandParams host_command 任务的参数
+// 主机组由 HostIDs 和 KubeClusterID 共同决定: 指定的主机加上集群中的所有节点
+type HostCommandParams struct {
+	HostIDs       []int64           `json:"host_ids"`
+	KubeClusterID int64             `json:"kube_cluster_id"`
+	Script        string            `json:"script"`
+	Env           map[string]string `json:"env"`
+}
+
+type hostCommandTask struct {
+	hosts    core.HostInstanceDao
+	executor Executor
+}
+
+func (t *hostCommandTask) Validate(params json.RawMessage) error {
+	in := new(HostCommandParams)
+	if err := decode(params, in); err != nil {
+		return err
+	}
+	if in.Script == "" || (len(in.HostIDs) == 0 && in.KubeClusterID == 0) {
+		return errors.New("script and host_ids or kube_cluster_id are required")
+	}
+	return nil
+}
+
+// Run 依次在每台主机上执行脚本, 单台主机失败不影响其他主机, 有主机失败时任务失败
+func (t *hostCommandTask) Run(ctx context.Context, params json.RawMessage, out io.Writer) error {
+	in := new(HostCommandParams)
+	if err := decode(params, in); err != nil {
+		return err
+	}
+	ids := append([]int64(nil), in.HostIDs...)
+	if in.KubeClusterID > 0 {
+		nodes, err := t.hosts.List(ctx, map[string]interface{}{"kube_cluster_id": in.KubeClusterID})
+		if err != nil {
+			return err
+		}
+		for _, node := range nodes {
+			ids = append(ids, node.ID)
+		}
+	}
+	seen := make(map[int64]bool, len(ids))
+	failed, total := 0, 0
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		total++
+		fmt.Fprintf(out, "==> host %d\n", id)
+		var timeout time.Duration
+		if deadline, ok := ctx.Deadline(); ok {
+			timeout = time.Until(deadline)
+		}
+		code, err := t.executor.Exec(ctx, id, in.Script, in.Env, timeout, out)
+		switch {
+		case err != nil:
+			failed++
+			fmt.Fprintf(out, "host %d: %v\n", id, err)
+		case code != 0:
+			failed++
+			fmt.Fprintf(out, "host %d: script exited with code %d\n", id, code)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d hosts failed", failed, total)
+	}
+	return nil
+}
+
+// CMDBSyncParams cmdb_sync 任务的参数, ClusterID 为0时同步所有集群
+type CMDBSyncParams struct {
+	ClusterID int64 `json:"cluster_id"`
+}
+
+type cmdbSyncTask struct {
+	clusters core.KubeClusterDao
+	nodes    NodeSyncer
+}
+
+func (t *cmdbSyncTask) Validate(params json.RawMessage) error {
+	return decode(params, new(CMDBSyncParams))
+}
+
+func (t *cmdbSyncTask) Run(ctx context.Context, params json.RawMessage, out io.Writer) error {
+	in := new(CMDBSyncParams)
+	if err := decode(params, in); err != nil {
+		return err
+	}
+	ids := []int64{in.ClusterID}
+	if in.ClusterID == 0 {
+		clusters, err := t.clusters.List(ctx, map[string]interface{}{})
+		if err != nil {
+			return err
+		}
+		ids = ids[:0]
+		for _, cluster := range clusters {
+			ids = append(ids, cluster.ID)
+		}
+	}
+	var errs []error
+	for _, id := range ids {
+		result, err := t.nodes.Sync(ctx, id)
+		if err != nil {
+			fmt.Fprintf(out, "cluster %d: %v\n", id, err)
+			errs = append(errs, fmt.Errorf("cluster %d: %w", id, err))
+			continue
+		}
+		fmt.Fprintf(out, "cluster %d: created %d, updated %d, offline %d, skipped %d\n",
+			id, result.Created, result.Updated, result.Offline, result.Skipped)
+	}
+	return errors.Join(errs...)
+}
+
+// CleanupParams cleanup 任务的参数, 删除早于 Days 天的记录, 为0时保留 30 天
+type CleanupParams struct {
+	Days int `json:"days"`
+}
+
+// pruner 可以按时间清理记录的 DAO
+type pruner interface {
+	Prune(context.Context, time.Time) (int64, error)
+}
+
+type cleanupTask struct {
+	targets map[string]pruner
+}
+
+func (t *cleanupTask) Validate(params json.RawMessage) error {
+	in := new(CleanupParams)
+	if err := decode(params, in); err != nil {
+		return err
+	}
+	if in.Days < 0 {
+		return errors.New("days must not be negative")
+	}
+	return nil
+}
+
+func (t *cleanupTask) Run(ctx context.Context, params json.RawMessage, out io.Writer) error {
+	in := new(CleanupParams)
+	if err := decode(params, in); err != nil {
+		return err
+	}
+	if in.Days == 0 {
+		in.Days = defaultRetention
+	}
+	before := time.Now().AddDate(0, 0, -in.Days)
+	names := make([]string, 0, len(t.targets))
+	for name := range t.targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var errs []error
+	for _, name := range names {
+		n, err := t.targets[name].Prune(ctx, before)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		fmt.Fprintf(out, "%s: deleted %d records before %s\n", name, n, before.Format(time.RFC3339))
+	}
+	return errors.Join(errs...)
+}
+
+type reportTask struct {
+	hosts  core.HostInstanceDao
+	agents core.AgentDao
+}
+
+func (t *reportTask) Validate(json.RawMessage) error { return nil }
+
+// Run 统计主机的状态和来源以及 agent 的在线状态和版本
+func (t *reportTask) Run(ctx context.Context, _ json.RawMessage, out io.Writer) error {
+	hosts, err := t.hosts.List(ctx, map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+	status := map[string]int{}
+	kubeNodes := 0
+	for _, host := range hosts {
+		switch host.HostStatus {
+		case core.HostStatusOnline:
+			status["online"]++
+		case core.HostStatusOffline:
+			status["offline"]++
+		default:
+			status["unknown"]++
+		}
+		if host.KubeClusterID > 0 {
+			kubeNodes++
+		}
+	}
+	fmt.Fprintf(out, "hosts: %d (online %d, offline %d, unknown %d, kubernetes nodes %d)\n",
+		len(hosts), status["online"], status["offline"], status["unknown"], kubeNodes)
+
+	agents, err := t.agents.List(ctx, map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+	online := 0
+	versions := map[string]int{}
+	for _, agent := range agents {
+		if agent.Status == core.AgentStatusOnline {
+			online++
+		}
+		versions[agent.Version]++
+	}
+	fmt.Fprintf(out, "agents: %d (online %d, offline %d)\n", len(agents), online, len(agents)-online)
+	names := make([]string, 0, len(versions))
+	for version := range versions {
+		names = append(names, version)
+	}
+	sort.Strings(names)
+	for _, version := range names {
+		fmt.Fprintf(out, "  version %s: %d\n", version, versions[version])
+	}
+	return nil
+}
+
+// decode 解析任务参数, 参数为空时使用零值
+func decode(params json.RawMessage, out interface{}) error {
+	if len(params) == 0 || string(params) == "null" {
+		return nil
+	}
+	return json.Unmarshal(params, out)
+}
@@ -0,0 +1,105 @@
+// Package eventbus 是进程内的事件总线, 其他子系统按主题订阅 webhook、发布等事件
+package eventbus
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bloodsteel/easynetes/pkg/log"
+)
+
+var logger = log.RegisterScope("eventbus", "in-process event bus", 0)
+
+// Event 事件总线中传递的事件, Topic 使用 . 分隔, 例如 hook.gitlab.push
+type Event struct {
+	Topic string
+	Time  time.Time
+	Data  interface{}
+}
+
+// Bus 进程内的事件总线, 发布不会阻塞: 订阅者的缓冲区满时丢弃事件
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+// Subscription 一个订阅, 从 C 中读取事件, 不再需要时调用 Close
+type Subscription struct {
+	C        <-chan Event
+	name     string
+	patterns []string
+	ch       chan Event
+	bus      *Bus
+	dropped  atomic.Uint64
+}
+
+// ProvideBus is a Wire provider
+func ProvideBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscribe 订阅匹配 patterns 的事件, name 只用于日志
+// pattern 可以是完整的主题, 以 .* 结尾的前缀(例如 hook.gitlab.*), 或者 * 表示所有事件
+func (b *Bus) Subscribe(name string, buffer int, patterns ...string) *Subscription {
+	ch := make(chan Event, buffer)
+	sub := &Subscription{C: ch, name: name, patterns: patterns, ch: ch, bus: b}
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+// Publish 将事件发送给所有匹配的订阅者
+func (b *Bus) Publish(topic string, data interface{}) {
+	event := Event{Topic: topic, Time: time.Now(), Data: data}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subs {
+		if !sub.match(topic) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			n := sub.dropped.Add(1)
+			logger.WithLabels("subscriber", sub.name, "topic", topic, "dropped", n).Warn("subscriber buffer is full, event dropped")
+		}
+	}
+}
+
+// Close 取消订阅并关闭 C
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	if _, ok := s.bus.subs[s]; ok {
+		delete(s.bus.subs, s)
+		close(s.ch)
+	}
+}
+
+// Dropped 返回订阅以来因为缓冲区满被丢弃的事件数量
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+func (s *Subscription) match(topic string) bool {
+	for _, pattern := range s.patterns {
+		if Match(pattern, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match 判断主题是否匹配 pattern
+func Match(pattern, topic string) bool {
+	if pattern == "*" || pattern == topic {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(topic, prefix)
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package eventbus
+
+import "testing"
+
+func TestBus(t *testing.T) {
+	bus := ProvideBus()
+	gitlab := bus.Subscribe("gitlab", 1, "hook.gitlab.*")
+	all := bus.Subscribe("all", 4, "*")
+	defer all.Close()
+
+	bus.Publish("hook.gitlab.push", 1)
+	bus.Publish("hook.jenkins.build.completed", 2)
+	// gitlab 的缓冲区已满, 这个事件会被丢弃而不是阻塞
+	bus.Publish("hook.gitlab.tag_push", 3)
+
+	if event := <-gitlab.C; event.Topic != "hook.gitlab.push" || event.Data != 1 {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	select {
+	case event := <-gitlab.C:
+		t.Fatalf("unexpected event %+v", event)
+	default:
+	}
+	if gitlab.Dropped() != 1 || all.Dropped() != 0 {
+		t.Fatalf("dropped = %d/%d, want 1/0", gitlab.Dropped(), all.Dropped())
+	}
+	if len(all.C) != 3 {
+		t.Fatalf("expected 3 events for wildcard subscriber, got %d", len(all.C))
+	}
+
+	gitlab.Close()
+	gitlab.Close()
+	bus.Publish("hook.gitlab.push", 4)
+	if _, ok := <-gitlab.C; ok {
+		t.Fatal("closed subscription should not receive events")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pattern, topic string
+		want           bool
+	}{
+		{"*", "hook.gitlab.push", true},
+		{"hook.gitlab.push", "hook.gitlab.push", true},
+		{"hook.gitlab.*", "hook.gitlab.merge_request", true},
+		{"hook.gitlab.*", "hook.jenkins.build.started", false},
+		{"hook.gitlab", "hook.gitlab.push", false},
+	}
+	for _, c := range cases {
+		if got := Match(c.pattern, c.topic); got != c.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", c.pattern, c.topic, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// perPage 分页接口每页的数量, GitLab 允许的最大值为 100
+const perPage = 100
+
+// Client 访问 GitLab REST API v4 的最小客户端, 只包含导入项目和选择版本需要的接口
+type Client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+// NewClient 创建客户端, baseURL 为 GitLab 的访问地址, 例如 https://gitlab.example.com
+func NewClient(baseURL, token string, httpClient *http.Client) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/") + "/api/v4",
+		token:   token,
+		http:    httpClient,
+	}
+}
+
+// APIError GitLab 返回的非 2xx 响应
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("gitlab: %d %s", e.StatusCode, e.Message)
+}
+
+// User 令牌所属的用户
+type User struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+	Name     string `json:"name"`
+}
+
+// Version GitLab 的版本信息
+type Version struct {
+	Version  string `json:"version"`
+	Revision string `json:"revision"`
+}
+
+// Project GitLab 中的项目
+type Project struct {
+	ID                int64  `json:"id"`
+	Name              string `json:"name"`
+	PathWithNamespace string `json:"path_with_namespace"`
+	WebURL            string `json:"web_url"`
+	HTTPURLToRepo     string `json:"http_url_to_repo"`
+	DefaultBranch     string `json:"default_branch"`
+}
+
+// Ref 分支或者标签, 两个接口返回的结构中 name 和 commit 是一致的
+type Ref struct {
+	Name   string `json:"name"`
+	Commit struct {
+		ID            string    `json:"id"`
+		CommittedDate time.Time `json:"committed_date"`
+	} `json:"commit"`
+}
+
+// CurrentUser 获取令牌所属的用户, 用于检查令牌是否有效
+func (c *Client) CurrentUser(ctx context.Context) (*User, error) {
+	out := new(User)
+	_, err := c.get(ctx, "/user", nil, out)
+	return out, err
+}
+
+// Version 获取 GitLab 的版本
+func (c *Client) Version(ctx context.Context) (*Version, error) {
+	out := new(Version)
+	_, err := c.get(ctx, "/version", nil, out)
+	return out, err
+}
+
+// Projects 分页搜索令牌所属用户有权限的项目, 返回下一页的页码, 没有下一页时为 0
+func (c *Client) Projects(ctx context.Context, search string, page int) ([]*Project, int, error) {
+	query := url.Values{}
+	query.Set("membership", "true")
+	query.Set("simple", "true")
+	query.Set("order_by", "path")
+	query.Set("sort", "asc")
+	query.Set("page", strconv.Itoa(page))
+	query.Set("per_page", strconv.Itoa(perPage))
+	if search != "" {
+		query.Set("search", search)
+	}
+	out := []*Project{}
+	header, err := c.get(ctx, "/projects", query, &out)
+	if err != nil {
+		return nil, 0, err
+	}
+	next, _ := strconv.Atoi(header.Get("X-Next-Page"))
+	return out, next, nil
+}
+
+// Project 根据ID获取项目
+func (c *Client) Project(ctx context.Context, id int64) (*Project, error) {
+	out := new(Project)
+	_, err := c.get(ctx, "/projects/"+strconv.FormatInt(id, 10), nil, out)
+	return out, err
+}
+
+// Branches 获取项目的所有分支
+func (c *Client) Branches(ctx context.Context, projectID int64) ([]*Ref, error) {
+	return c.refs(ctx, projectID, "branches")
+}
+
+// Tags 获取项目的所有标签
+func (c *Client) Tags(ctx context.Context, projectID int64) ([]*Ref, error) {
+	return c.refs(ctx, projectID, "tags")
+}
+
+// refs 按 X-Next-Page 依次获取所有分页
+func (c *Client) refs(ctx context.Context, projectID int64, kind string) ([]*Ref, error) {
+	path := "/projects/" + strconv.FormatInt(projectID, 10) + "/repository/" + kind
+	var out []*Ref
+	for page := 1; page > 0; {
+		query := url.Values{}
+		query.Set("page", strconv.Itoa(page))
+		query.Set("per_page", strconv.Itoa(perPage))
+		items := []*Ref{}
+		header, err := c.get(ctx, path, query, &items)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, items...)
+		page, _ = strconv.Atoi(header.Get("X-Next-Page"))
+	}
+	return out, nil
+}
+
+func (c *Client) get(ctx context.Context, path string, query url.Values, out interface{}) (http.Header, error) {
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("PRIVATE-TOKEN", c.token)
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &APIError{StatusCode: resp.StatusCode, Message: errorMessage(resp.Body)}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return nil, fmt.Errorf("gitlab: decode %s: %w", path, err)
+	}
+	return resp.Header, nil
+}
+
+// errorMessage 提取 GitLab 错误响应中的 message 或者 error 字段
+func errorMessage(body io.Reader) string {
+	data, _ := io.ReadAll(io.LimitReader(body, 4096))
+	var payload struct {
+		Message interface{} `json:"message"`
+		Error   string      `json:"error"`
+	}
+	if json.Unmarshal(data, &payload) == nil {
+		if payload.Message != nil {
+			return fmt.Sprint(payload.Message)
+		}
+		if payload.Error != "" {
+			return payload.Error
+		}
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,378 @@
+// Package gitlab 管理 GitLab 连接, 导入项目并绑定到服务树节点, 定期缓存项目的分支和标签用于发布时选择版本
+package gitlab
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/eventbus"
+	"github.com/bloodsteel/easynetes/pkg/config"
+	"github.com/bloodsteel/easynetes/pkg/encrypt"
+	"github.com/bloodsteel/easynetes/pkg/log"
+)
+
+var logger = log.RegisterScope("gitlab", "gitlab integration", 0)
+
+// Service 管理 GitLab 连接和导入的项目
+type Service struct {
+	connections core.GitlabConnectionDao
+	projects    core.GitlabProjectDao
+	encrypter   *encrypt.Encrypter
+	bus         *eventbus.Bus
+	interval    time.Duration
+	// http 访问 GitLab 使用的客户端, 测试时替换为 httptest 的客户端
+	http *http.Client
+}
+
+// ProvideService is a Wire provider
+func ProvideService(
+	connections core.GitlabConnectionDao,
+	projects core.GitlabProjectDao,
+	encrypter *encrypt.Encrypter,
+	bus *eventbus.Bus,
+	cfg *config.Config,
+) *Service {
+	return &Service{
+		connections: connections,
+		projects:    projects,
+		encrypter:   encrypter,
+		bus:         bus,
+		interval:    cfg.Gitlab.RefreshInterval * time.Second,
+		http:        &http.Client{Timeout: cfg.Gitlab.Timeout * time.Second},
+	}
+}
+
+// CreateConnection 加密令牌后保存连接, 然后立即进行一次连接测试
+func (s *Service) CreateConnection(ctx context.Context, conn *core.GitlabConnection, token string) (*core.GitlabConnection, error) {
+	if token == "" {
+		return nil, core.ErrInvalidGitlabConnection
+	}
+	if err := s.setConnection(conn, token); err != nil {
+		return nil, err
+	}
+	conn.Status = core.GitlabUnknown
+	if _, err := s.connections.Create(ctx, conn); err != nil {
+		return nil, err
+	}
+	s.Test(ctx, conn)
+	return conn, nil
+}
+
+// UpdateConnection 更新连接的名称和地址, token 为空时保留原有的令牌
+func (s *Service) UpdateConnection(ctx context.Context, conn *core.GitlabConnection, token string) (*core.GitlabConnection, error) {
+	old, err := s.connections.Get(ctx, conn.ID)
+	if err != nil {
+		return nil, err
+	}
+	old.Name = conn.Name
+	old.BaseURL = conn.BaseURL
+	if token == "" {
+		token, err = s.token(old)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := s.setConnection(old, token); err != nil {
+		return nil, err
+	}
+	if err := s.connections.Update(ctx, old); err != nil {
+		return nil, err
+	}
+	s.Test(ctx, old)
+	return old, nil
+}
+
+// DeleteConnection 删除连接, 连接下还有导入的项目时拒绝删除
+func (s *Service) DeleteConnection(ctx context.Context, id int64) error {
+	count, err := s.projects.Count(ctx, map[string]interface{}{"connection_id": id})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return core.ErrGitlabConnectionInUse
+	}
+	return s.connections.Delete(ctx, id)
+}
+
+// Test 使用令牌获取当前用户和 GitLab 版本, 记录连接测试的结果
+func (s *Service) Test(ctx context.Context, conn *core.GitlabConnection) {
+	now := time.Now()
+	conn.LastCheckTime = &now
+	if err := s.test(ctx, conn); err != nil {
+		conn.Status = core.GitlabUnreachable
+		conn.LastError = err.Error()
+	} else {
+		conn.Status = core.GitlabConnected
+		conn.LastError = ""
+	}
+	if err := s.connections.UpdateStatus(ctx, conn); err != nil {
+		logger.WithLabels("connection", conn.Name, "error", err).Error("cannot save gitlab connection status")
+	}
+}
+
+func (s *Service) test(ctx context.Context, conn *core.GitlabConnection) error {
+	client, err := s.clientFor(conn)
+	if err != nil {
+		return err
+	}
+	user, err := client.CurrentUser(ctx)
+	if err != nil {
+		return err
+	}
+	conn.Username = user.Username
+	version, err := client.Version(ctx)
+	if err != nil {
+		return err
+	}
+	conn.Version = version.Version
+	return nil
+}
+
+// Client 返回连接的 GitLab 客户端
+func (s *Service) Client(ctx context.Context, connectionID int64) (*Client, error) {
+	conn, err := s.connections.Get(ctx, connectionID)
+	if err != nil {
+		return nil, err
+	}
+	return s.clientFor(conn)
+}
+
+// SearchProjects 分页搜索连接中可以导入的项目, 返回下一页的页码
+func (s *Service) SearchProjects(ctx context.Context, connectionID int64, search string, page int) ([]*Project, int, error) {
+	client, err := s.Client(ctx, connectionID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if page < 1 {
+		page = 1
+	}
+	return client.Projects(ctx, search, page)
+}
+
+// Import 导入项目并绑定到服务树节点, 已经导入过的项目只更新基本信息和绑定关系
+// 导入后立即同步一次分支和标签, 同步失败记录在 RefsError 中, 不影响导入结果
+func (s *Service) Import(ctx context.Context, connectionID, gitlabID, serviceNodeID int64, creator string) (*core.GitlabProject, error) {
+	client, err := s.Client(ctx, connectionID)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := client.Project(ctx, gitlabID)
+	if err != nil {
+		return nil, err
+	}
+	project, err := s.projects.GetByGitlabID(ctx, connectionID, gitlabID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		project = &core.GitlabProject{ConnectionID: connectionID, GitlabID: gitlabID, Creator: creator}
+		setProject(project, remote, serviceNodeID)
+		if _, err := s.projects.Create(ctx, project); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	default:
+		setProject(project, remote, serviceNodeID)
+		if err := s.projects.Update(ctx, project); err != nil {
+			return nil, err
+		}
+	}
+	s.syncRefs(ctx, client, project)
+	return project, nil
+}
+
+// Link 修改项目绑定的服务树节点
+func (s *Service) Link(ctx context.Context, id, serviceNodeID int64) (*core.GitlabProject, error) {
+	project, err := s.projects.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	project.ServiceNodeID = serviceNodeID
+	if err := s.projects.Update(ctx, project); err != nil {
+		return nil, err
+	}
+	return project, nil
+}
+
+// SyncRefs 立即同步项目的分支和标签
+func (s *Service) SyncRefs(ctx context.Context, id int64) (*core.GitlabProject, error) {
+	project, err := s.projects.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	client, err := s.Client(ctx, project.ConnectionID)
+	if err != nil {
+		return nil, err
+	}
+	s.syncRefs(ctx, client, project)
+	return project, nil
+}
+
+// Run 定期刷新所有导入项目的分支和标签缓存, 收到 push/tag_push 事件时立即刷新对应的项目
+func (s *Service) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	sub := s.bus.Subscribe("gitlab", 64, "hook.gitlab.push", "hook.gitlab.tag_push")
+	defer sub.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			s.RefreshAll(ctx)
+		case event := <-sub.C:
+			if hook, ok := event.Data.(*core.HookEvent); ok {
+				s.refreshPath(ctx, hook.Project)
+			}
+		}
+	}
+}
+
+// refreshPath 同步所有连接中路径为 path 的项目的分支和标签
+func (s *Service) refreshPath(ctx context.Context, path string) {
+	projects, err := s.projects.List(ctx, map[string]interface{}{"path": path})
+	if err != nil {
+		logger.WithLabels("project", path, "error", err).Error("cannot list gitlab projects")
+		return
+	}
+	for _, project := range projects {
+		if project.Path != path {
+			continue
+		}
+		if _, err := s.SyncRefs(ctx, project.ID); err != nil {
+			logger.WithLabels("project", path, "error", err).Warn("cannot sync gitlab refs")
+		}
+	}
+}
+
+// RefreshAll 依次同步所有导入项目的分支和标签, 同一个连接只创建一次客户端
+func (s *Service) RefreshAll(ctx context.Context) {
+	projects, err := s.projects.List(ctx, map[string]interface{}{})
+	if err != nil {
+		logger.WithLabels("error", err).Error("cannot list gitlab projects")
+		return
+	}
+	clients := make(map[int64]*Client)
+	for _, project := range projects {
+		client, ok := clients[project.ConnectionID]
+		if !ok {
+			client, err = s.Client(ctx, project.ConnectionID)
+			if err != nil {
+				logger.WithLabels("project", project.Path, "error", err).Error("cannot create gitlab client")
+				continue
+			}
+			clients[project.ConnectionID] = client
+		}
+		s.syncRefs(ctx, client, project)
+	}
+}
+
+// syncRefs 获取项目的分支和标签并保存, 失败时保留上一次的缓存
+func (s *Service) syncRefs(ctx context.Context, client *Client, project *core.GitlabProject) {
+	refs, err := fetchRefs(ctx, client, project.GitlabID)
+	now := time.Now()
+	project.RefsSyncTime = &now
+	if err != nil {
+		project.RefsError = err.Error()
+		logger.WithLabels("project", project.Path, "error", err).Warn("cannot sync gitlab refs")
+		// UpdateRefs 会覆盖 refs 字段, 需要先加载上一次的缓存
+		if project.Refs == nil {
+			if old, err := s.projects.Get(ctx, project.ID); err == nil {
+				project.Refs = old.Refs
+			}
+		}
+	} else {
+		project.Refs = refs
+		project.RefsError = ""
+	}
+	if err := s.projects.UpdateRefs(ctx, project); err != nil {
+		logger.WithLabels("project", project.Path, "error", err).Error("cannot save gitlab refs")
+	}
+}
+
+// fetchRefs 获取项目所有的分支和标签, 分支在前, 各自按提交时间倒序
+func fetchRefs(ctx context.Context, client *Client, projectID int64) (core.GitlabRefs, error) {
+	branches, err := client.Branches(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := client.Tags(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	refs := make(core.GitlabRefs, 0, len(branches)+len(tags))
+	for _, kind := range []struct {
+		name  string
+		items []*Ref
+	}{{core.GitlabBranch, branches}, {core.GitlabTag, tags}} {
+		start := len(refs)
+		for _, item := range kind.items {
+			refs = append(refs, core.GitlabRef{
+				Kind:       kind.name,
+				Name:       item.Name,
+				CommitSHA:  item.Commit.ID,
+				CommitTime: item.Commit.CommittedDate,
+			})
+		}
+		group := refs[start:]
+		sort.SliceStable(group, func(i, j int) bool { return group[i].CommitTime.After(group[j].CommitTime) })
+	}
+	return refs, nil
+}
+
+// FilterRefs 按类型和名称前缀过滤缓存的分支和标签, kind 为空时不按类型过滤
+func FilterRefs(refs core.GitlabRefs, kind, prefix string) core.GitlabRefs {
+	out := core.GitlabRefs{}
+	for _, ref := range refs {
+		if kind != "" && ref.Kind != kind {
+			continue
+		}
+		if !strings.HasPrefix(ref.Name, prefix) {
+			continue
+		}
+		out = append(out, ref)
+	}
+	return out
+}
+
+func setProject(project *core.GitlabProject, remote *Project, serviceNodeID int64) {
+	project.Name = remote.Name
+	project.Path = remote.PathWithNamespace
+	project.WebURL = remote.WebURL
+	project.HTTPURL = remote.HTTPURLToRepo
+	project.DefaultBranch = remote.DefaultBranch
+	project.ServiceNodeID = serviceNodeID
+}
+
+// setConnection 校验地址后加密保存令牌
+func (s *Service) setConnection(conn *core.GitlabConnection, token string) error {
+	u, err := url.Parse(conn.BaseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || conn.Name == "" {
+		return core.ErrInvalidGitlabConnection
+	}
+	conn.BaseURL = strings.TrimRight(conn.BaseURL, "/")
+	conn.Token, err = s.encrypter.Encrypt([]byte(token))
+	return err
+}
+
+func (s *Service) token(conn *core.GitlabConnection) (string, error) {
+	data, err := s.encrypter.Decrypt(conn.Token)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (s *Service) clientFor(conn *core.GitlabConnection) (*Client, error) {
+	token, err := s.token(conn)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn.BaseURL, token, s.http), nil
+}
@@ -0,0 +1,267 @@
+package gitlab
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/pkg/encrypt"
+)
+
+// fakeConnectionDao 内存中的 GitlabConnectionDao
+type fakeConnectionDao struct {
+	items map[int64]*core.GitlabConnection
+}
+
+func (f *fakeConnectionDao) Get(_ context.Context, id int64) (*core.GitlabConnection, error) {
+	c, ok := f.items[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	out := *c
+	return &out, nil
+}
+
+func (f *fakeConnectionDao) List(context.Context) ([]*core.GitlabConnection, error) {
+	var out []*core.GitlabConnection
+	for _, c := range f.items {
+		copied := *c
+		out = append(out, &copied)
+	}
+	return out, nil
+}
+
+func (f *fakeConnectionDao) Create(_ context.Context, c *core.GitlabConnection) (int64, error) {
+	c.ID = int64(len(f.items) + 1)
+	copied := *c
+	f.items[c.ID] = &copied
+	return c.ID, nil
+}
+
+func (f *fakeConnectionDao) Update(_ context.Context, c *core.GitlabConnection) error {
+	copied := *c
+	f.items[c.ID] = &copied
+	return nil
+}
+
+func (f *fakeConnectionDao) UpdateStatus(ctx context.Context, c *core.GitlabConnection) error {
+	return f.Update(ctx, c)
+}
+
+func (f *fakeConnectionDao) Delete(_ context.Context, id int64) error {
+	delete(f.items, id)
+	return nil
+}
+
+// fakeProjectDao 内存中的 GitlabProjectDao
+type fakeProjectDao struct {
+	core.GitlabProjectDao
+	items map[int64]*core.GitlabProject
+}
+
+func (f *fakeProjectDao) Get(_ context.Context, id int64) (*core.GitlabProject, error) {
+	p, ok := f.items[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	out := *p
+	return &out, nil
+}
+
+func (f *fakeProjectDao) GetByGitlabID(ctx context.Context, connectionID, gitlabID int64) (*core.GitlabProject, error) {
+	for _, p := range f.items {
+		if p.ConnectionID == connectionID && p.GitlabID == gitlabID {
+			return f.Get(ctx, p.ID)
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (f *fakeProjectDao) List(context.Context, map[string]interface{}) ([]*core.GitlabProject, error) {
+	var out []*core.GitlabProject
+	for _, p := range f.items {
+		copied := *p
+		copied.Refs = nil
+		out = append(out, &copied)
+	}
+	return out, nil
+}
+
+func (f *fakeProjectDao) Count(_ context.Context, in map[string]interface{}) (int64, error) {
+	var count int64
+	for _, p := range f.items {
+		if p.ConnectionID == in["connection_id"] {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (f *fakeProjectDao) Create(_ context.Context, p *core.GitlabProject) (int64, error) {
+	p.ID = int64(len(f.items) + 1)
+	copied := *p
+	f.items[p.ID] = &copied
+	return p.ID, nil
+}
+
+func (f *fakeProjectDao) Update(_ context.Context, p *core.GitlabProject) error {
+	old := f.items[p.ID]
+	copied := *p
+	copied.Refs = old.Refs
+	f.items[p.ID] = &copied
+	return nil
+}
+
+func (f *fakeProjectDao) UpdateRefs(_ context.Context, p *core.GitlabProject) error {
+	old := f.items[p.ID]
+	old.Refs, old.RefsError, old.RefsSyncTime = p.Refs, p.RefsError, p.RefsSyncTime
+	return nil
+}
+
+// fakeGitlab 模拟 GitLab REST API, 分支接口每页只返回一条以覆盖分页逻辑
+func fakeGitlab(t *testing.T, token string, failRefs *bool) *httptest.Server {
+	commit := func(sha string, at time.Time) map[string]interface{} {
+		return map[string]interface{}{"id": sha, "committed_date": at}
+	}
+	base := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	branches := []map[string]interface{}{
+		{"name": "main", "commit": commit("a1", base)},
+		{"name": "develop", "commit": commit("b2", base.Add(time.Hour))},
+	}
+	tags := []map[string]interface{}{
+		{"name": "v1.0.0", "commit": commit("c3", base)},
+	}
+	project := map[string]interface{}{
+		"id": 42, "name": "api", "path_with_namespace": "team/api",
+		"web_url": "https://gitlab.example.com/team/api", "http_url_to_repo": "https://gitlab.example.com/team/api.git",
+		"default_branch": "main",
+	}
+	reply := func(w http.ResponseWriter, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(v)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v4/user", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, map[string]interface{}{"id": 1, "username": "deployer"})
+	})
+	mux.HandleFunc("/api/v4/version", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, map[string]interface{}{"version": "16.11.0"})
+	})
+	mux.HandleFunc("/api/v4/projects", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("membership") != "true" || r.URL.Query().Get("search") != "api" {
+			t.Errorf("unexpected project query %s", r.URL.RawQuery)
+		}
+		reply(w, []interface{}{project})
+	})
+	mux.HandleFunc("/api/v4/projects/42", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, project)
+	})
+	mux.HandleFunc("/api/v4/projects/42/repository/branches", func(w http.ResponseWriter, r *http.Request) {
+		if *failRefs {
+			w.WriteHeader(http.StatusInternalServerError)
+			reply(w, map[string]string{"message": "boom"})
+			return
+		}
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page < len(branches) {
+			w.Header().Set("X-Next-Page", strconv.Itoa(page+1))
+		}
+		reply(w, branches[page-1:page])
+	})
+	mux.HandleFunc("/api/v4/projects/42/repository/tags", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, tags)
+	})
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("PRIVATE-TOKEN") != token {
+			w.WriteHeader(http.StatusUnauthorized)
+			reply(w, map[string]string{"message": "401 Unauthorized"})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+}
+
+func TestImportProject(t *testing.T) {
+	ctx := context.Background()
+	failRefs := false
+	server := fakeGitlab(t, "glpat-secret", &failRefs)
+	defer server.Close()
+
+	encrypter, err := encrypt.New("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	connections := &fakeConnectionDao{items: map[int64]*core.GitlabConnection{}}
+	projects := &fakeProjectDao{items: map[int64]*core.GitlabProject{}}
+	s := &Service{connections: connections, projects: projects, encrypter: encrypter, http: server.Client()}
+
+	if _, err := s.CreateConnection(ctx, &core.GitlabConnection{Name: "bad", BaseURL: "ftp://gitlab"}, "x"); err != core.ErrInvalidGitlabConnection {
+		t.Fatalf("expected invalid connection, got %v", err)
+	}
+	bad, err := s.CreateConnection(ctx, &core.GitlabConnection{Name: "wrong", BaseURL: server.URL}, "wrong-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bad.Status != core.GitlabUnreachable || bad.LastError == "" {
+		t.Fatalf("expected unreachable with wrong token, got %s %q", bad.Status, bad.LastError)
+	}
+	conn, err := s.CreateConnection(ctx, &core.GitlabConnection{Name: "gitlab", BaseURL: server.URL + "/"}, "glpat-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.Status != core.GitlabConnected || conn.Username != "deployer" || conn.Version != "16.11.0" {
+		t.Fatalf("unexpected connection status %+v", conn)
+	}
+	if string(connections.items[conn.ID].Token) == "glpat-secret" {
+		t.Fatal("token must be stored encrypted")
+	}
+
+	// 更新时不提供令牌则保留原有的令牌
+	conn.Name = "gitlab-prod"
+	if conn, err = s.UpdateConnection(ctx, conn, ""); err != nil || conn.Status != core.GitlabConnected {
+		t.Fatalf("update keeps token: %v %s", err, conn.Status)
+	}
+
+	remote, next, err := s.SearchProjects(ctx, conn.ID, "api", 1)
+	if err != nil || len(remote) != 1 || next != 0 || remote[0].PathWithNamespace != "team/api" {
+		t.Fatalf("unexpected search result %v %d %v", remote, next, err)
+	}
+
+	project, err := s.Import(ctx, conn.ID, 42, 7, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if project.Path != "team/api" || project.ServiceNodeID != 7 || project.DefaultBranch != "main" {
+		t.Fatalf("unexpected project %+v", project)
+	}
+	refs := projects.items[project.ID].Refs
+	if len(refs) != 3 || refs[0].Name != "develop" || refs[1].Name != "main" || refs[2].Kind != core.GitlabTag {
+		t.Fatalf("unexpected refs %+v", refs)
+	}
+	if got := FilterRefs(refs, core.GitlabBranch, "ma"); len(got) != 1 || got[0].CommitSHA != "a1" {
+		t.Fatalf("unexpected filtered refs %+v", got)
+	}
+
+	// 重复导入只更新绑定关系
+	if again, err := s.Import(ctx, conn.ID, 42, 9, "admin"); err != nil || again.ID != project.ID || len(projects.items) != 1 {
+		t.Fatalf("re-import should update the existing project: %v", err)
+	}
+
+	// 同步失败时保留上一次的缓存
+	failRefs = true
+	s.RefreshAll(ctx)
+	stored := projects.items[project.ID]
+	if stored.RefsError == "" || len(stored.Refs) != 3 || stored.ServiceNodeID != 9 {
+		t.Fatalf("failed sync should keep cached refs: %+v", stored)
+	}
+
+	if err := s.DeleteConnection(ctx, conn.ID); err != core.ErrGitlabConnectionInUse {
+		t.Fatalf("expected connection in use, got %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+// Package hook 接收 GitLab 和 Jenkins 推送的事件, 去重后保存并发布到事件总线
+package hook
+
+import (
+	"context"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/eventbus"
+	"github.com/bloodsteel/easynetes/pkg/config"
+	"github.com/bloodsteel/easynetes/pkg/log"
+)
+
+var logger = log.RegisterScope("hook", "inbound webhook receiver", 0)
+
+// pruneInterval 清理过期事件的间隔
+const pruneInterval = time.Hour
+
+// Topic 返回事件在事件总线中的主题, 例如 hook.gitlab.push、hook.jenkins.build.completed
+func Topic(event *core.HookEvent) string {
+	return "hook." + event.Source + "." + event.EventType
+}
+
+// Receiver 保存接收的事件并发布到事件总线, 订阅者收到的 Data 为 *core.HookEvent
+type Receiver struct {
+	events    core.HookEventDao
+	bus       *eventbus.Bus
+	retention time.Duration
+}
+
+// ProvideReceiver is a Wire provider
+func ProvideReceiver(events core.HookEventDao, bus *eventbus.Bus, cfg *config.Config) *Receiver {
+	return &Receiver{
+		events:    events,
+		bus:       bus,
+		retention: cfg.Webhook.Retention * time.Hour,
+	}
+}
+
+// Receive 保存事件, 只有第一次投递的事件会发布到事件总线; 重复投递时返回 false
+func (r *Receiver) Receive(ctx context.Context, event *core.HookEvent) (bool, error) {
+	created, err := r.events.Create(ctx, event)
+	if err != nil || !created {
+		return false, err
+	}
+	r.bus.Publish(Topic(event), event)
+	return true, nil
+}
+
+// Run 定期删除超过保留时间的事件
+func (r *Receiver) Run(ctx context.Context) error {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			n, err := r.events.Prune(ctx, time.Now().Add(-r.retention))
+			if err != nil {
+				logger.WithLabels("error", err).Error("cannot prune hook events")
+			} else if n > 0 {
+				logger.WithLabels("count", n).Info("pruned hook events")
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package hook
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/eventbus"
+)
+
+// fakeEventDao 内存中的 HookEventDao
+type fakeEventDao struct {
+	core.HookEventDao
+	items []*core.HookEvent
+}
+
+func (f *fakeEventDao) Create(_ context.Context, e *core.HookEvent) (bool, error) {
+	for _, item := range f.items {
+		if item.Source == e.Source && item.DeliveryID == e.DeliveryID {
+			return false, nil
+		}
+	}
+	e.ID = int64(len(f.items) + 1)
+	f.items = append(f.items, e)
+	return true, nil
+}
+
+const gitlabPush = `{
+  "object_kind": "push",
+  "ref": "refs/heads/main",
+  "before": "95790bf891e76fee5e1747ab589903a6a1f80f22",
+  "after": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+  "checkout_sha": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+  "project": {"id": 15, "path_with_namespace": "team/api"}
+}`
+
+const gitlabMergeRequest = `{
+  "object_kind": "merge_request",
+  "project": {"id": 15, "path_with_namespace": "team/api"},
+  "object_attributes": {"source_branch": "feature/login", "target_branch": "main", "last_commit": {"id": "abc123"}}
+}`
+
+const jenkinsCompleted = `{
+  "name": "api",
+  "url": "job/team/job/api/",
+  "build": {"number": 42, "phase": "COMPLETED", "status": "SUCCESS", "scm": {"branch": "origin/main", "commit": "da15608"}}
+}`
+
+func TestParse(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-Gitlab-Event-UUID", "uuid-1")
+	push, err := ParseGitlab(header, []byte(gitlabPush))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if push.EventType != "push" || push.Project != "team/api" || push.Ref != "refs/heads/main" ||
+		push.CommitSHA != "da1560886d4f094c3e6c9ef40349f7d38b5d27d7" || push.DeliveryID != "uuid-1" {
+		t.Fatalf("unexpected push event %+v", push)
+	}
+
+	mr, err := ParseGitlab(http.Header{}, []byte(gitlabMergeRequest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mr.Ref != "feature/login" || mr.CommitSHA != "abc123" || len(mr.DeliveryID) != 64 {
+		t.Fatalf("unexpected merge request event %+v", mr)
+	}
+
+	build, err := ParseJenkins(http.Header{}, []byte(jenkinsCompleted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if build.EventType != "build.completed" || build.Project != "team/api" || build.DeliveryID != "team/api#42:build.completed" {
+		t.Fatalf("unexpected jenkins event %+v", build)
+	}
+
+	if _, err := ParseGitlab(http.Header{}, []byte(`{"foo": 1}`)); err != core.ErrInvalidHookPayload {
+		t.Fatalf("expected invalid payload, got %v", err)
+	}
+}
+
+func TestReceiveOnce(t *testing.T) {
+	ctx := context.Background()
+	bus := eventbus.ProvideBus()
+	sub := bus.Subscribe("test", 4, "hook.gitlab.*")
+	defer sub.Close()
+	r := &Receiver{events: &fakeEventDao{}, bus: bus, retention: time.Hour}
+
+	for i := 0; i < 2; i++ {
+		event, err := ParseGitlab(http.Header{"Idempotency-Key": {"delivery-1"}}, []byte(gitlabPush))
+		if err != nil {
+			t.Fatal(err)
+		}
+		created, err := r.Receive(ctx, event)
+		if err != nil || created != (i == 0) {
+			t.Fatalf("delivery %d: created=%v err=%v", i, created, err)
+		}
+	}
+	if len(sub.C) != 1 {
+		t.Fatalf("duplicate delivery should be published once, got %d", len(sub.C))
+	}
+	event := <-sub.C
+	if event.Topic != "hook.gitlab.push" || event.Data.(*core.HookEvent).Project != "team/api" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+}
@@ -0,0 +1,132 @@
+package hook
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+)
+
+// gitlabPayload GitLab 事件中用到的字段, push/tag_push/merge_request/pipeline 的结构各不相同
+type gitlabPayload struct {
+	ObjectKind  string `json:"object_kind"`
+	Ref         string `json:"ref"`
+	CheckoutSHA string `json:"checkout_sha"`
+	After       string `json:"after"`
+	Project     struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+	} `json:"project"`
+	ObjectAttributes struct {
+		Ref          string `json:"ref"`
+		SHA          string `json:"sha"`
+		SourceBranch string `json:"source_branch"`
+		LastCommit   struct {
+			ID string `json:"id"`
+		} `json:"last_commit"`
+	} `json:"object_attributes"`
+}
+
+// ParseGitlab 解析 GitLab 的 webhook 请求
+// 投递ID 依次使用 Idempotency-Key、X-Gitlab-Event-UUID 头, 都没有时使用请求体的 sha256
+func ParseGitlab(header http.Header, body []byte) (*core.HookEvent, error) {
+	payload := new(gitlabPayload)
+	if err := json.Unmarshal(body, payload); err != nil || payload.ObjectKind == "" {
+		return nil, core.ErrInvalidHookPayload
+	}
+	event := &core.HookEvent{
+		Source:     core.HookSourceGitlab,
+		DeliveryID: deliveryID(header, body, "Idempotency-Key", "X-Gitlab-Event-UUID"),
+		EventType:  payload.ObjectKind,
+		Project:    payload.Project.PathWithNamespace,
+		Payload:    string(body),
+	}
+	switch payload.ObjectKind {
+	case "push", "tag_push":
+		event.Ref = payload.Ref
+		event.CommitSHA = payload.CheckoutSHA
+		if event.CommitSHA == "" {
+			event.CommitSHA = payload.After
+		}
+	case "merge_request":
+		event.Ref = payload.ObjectAttributes.SourceBranch
+		event.CommitSHA = payload.ObjectAttributes.LastCommit.ID
+	default:
+		event.Ref = payload.ObjectAttributes.Ref
+		event.CommitSHA = payload.ObjectAttributes.SHA
+	}
+	return event, nil
+}
+
+// jenkinsPayload Jenkins Notification 插件发送的 JSON 结构
+type jenkinsPayload struct {
+	Name  string `json:"name"`
+	URL   string `json:"url"`
+	Build struct {
+		Number int64  `json:"number"`
+		Phase  string `json:"phase"`
+		Status string `json:"status"`
+		SCM    struct {
+			Branch string `json:"branch"`
+			Commit string `json:"commit"`
+		} `json:"scm"`
+	} `json:"build"`
+}
+
+// ParseJenkins 解析 Jenkins Notification 插件的请求, 事件类型为 build.<phase>, 例如 build.completed
+// 投递ID 使用 X-Delivery-ID 头, 没有时使用 任务#编号:阶段, 同一个构建的同一个阶段只处理一次
+func ParseJenkins(header http.Header, body []byte) (*core.HookEvent, error) {
+	payload := new(jenkinsPayload)
+	if err := json.Unmarshal(body, payload); err != nil || payload.Name == "" || payload.Build.Phase == "" {
+		return nil, core.ErrInvalidHookPayload
+	}
+	job := jobName(payload.URL)
+	if job == "" {
+		job = payload.Name
+	}
+	event := &core.HookEvent{
+		Source:     core.HookSourceJenkins,
+		DeliveryID: header.Get("X-Delivery-ID"),
+		EventType:  "build." + strings.ToLower(payload.Build.Phase),
+		Project:    job,
+		Ref:        payload.Build.SCM.Branch,
+		CommitSHA:  payload.Build.SCM.Commit,
+		Payload:    string(body),
+	}
+	if event.DeliveryID == "" {
+		event.DeliveryID = fmt.Sprintf("%s#%s:%s", job, strconv.FormatInt(payload.Build.Number, 10), event.EventType)
+	}
+	return event, nil
+}
+
+// jobName 将任务的相对地址转换为完整路径, 例如 job/team/job/api/ 转换为 team/api
+func jobName(path string) string {
+	var parts []string
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 0; i+1 < len(segments); i += 2 {
+		if segments[i] != "job" {
+			return ""
+		}
+		name, err := url.PathUnescape(segments[i+1])
+		if err != nil {
+			return ""
+		}
+		parts = append(parts, name)
+	}
+	return strings.Join(parts, "/")
+}
+
+func deliveryID(header http.Header, body []byte, keys ...string) string {
+	for _, key := range keys {
+		if v := header.Get(key); v != "" {
+			return v
+		}
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,172 @@
+// Package hostexec 通过 agent stream 在主机上执行脚本, 并将输出转发给调用方
+package hostexec
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/agent/proto"
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/agenthub"
+	"github.com/bloodsteel/easynetes/pkg/log"
+	"github.com/google/uuid"
+)
+
+var logger = log.RegisterScope("hostexec", "remote script execution", 0)
+
+const (
+	// offlineCheck 检查 agent 是否断开连接的间隔, agent 断开时会终止所有脚本
+	offlineCheck = 5 * time.Second
+	// exitGrace 超时后等待 agent 上报退出的时间
+	exitGrace = 10 * time.Second
+)
+
+// Executor 在主机上执行脚本
+type Executor struct {
+	hub    *agenthub.Hub
+	agents core.AgentDao
+	hosts  core.HostInstanceDao
+
+	mu     sync.Mutex
+	active map[string]*execution
+}
+
+// execution 一次进行中的脚本执行
+type execution struct {
+	agentID int64
+	out     io.Writer
+	exit    chan *proto.ExecExit
+}
+
+// ProvideExecutor is a Wire provider
+func ProvideExecutor(hub *agenthub.Hub, agents core.AgentDao, hosts core.HostInstanceDao) *Executor {
+	e := &Executor{
+		hub:    hub,
+		agents: agents,
+		hosts:  hosts,
+		active: make(map[string]*execution),
+	}
+	hub.Handle(proto.TypeExecOutput, e.handleOutput)
+	hub.Handle(proto.TypeExecExit, e.handleExit)
+	return e
+}
+
+// Exec 在主机上执行脚本, 输出写入 out, 返回脚本的退出码
+// timeout 大于0时由 agent 限制执行时间; ctx 结束时通知 agent 终止脚本
+// 主机没有 agent 时返回 sql.ErrNoRows, agent 不在线或者执行过程中断开时返回 agenthub.ErrAgentOffline
+func (e *Executor) Exec(ctx context.Context, hostID int64, script string, env map[string]string,
+	timeout time.Duration, out io.Writer) (int, error) {
+	host, err := e.hosts.Get(ctx, hostID)
+	if err != nil {
+		return -1, err
+	}
+	agent, err := e.agents.GetByInstance(ctx, host.InstanceID)
+	if err != nil {
+		return -1, err
+	}
+	if !e.hub.Connected(agent.ID) {
+		return -1, agenthub.ErrAgentOffline
+	}
+
+	id := uuid.New().String()
+	exec := &execution{agentID: agent.ID, out: out, exit: make(chan *proto.ExecExit, 1)}
+	e.mu.Lock()
+	e.active[id] = exec
+	e.mu.Unlock()
+	defer func() {
+		e.mu.Lock()
+		delete(e.active, id)
+		e.mu.Unlock()
+	}()
+
+	msg, err := proto.NewMessage(proto.TypeExec, &proto.Exec{
+		ExecID:  id,
+		Script:  script,
+		Env:     env,
+		Timeout: int64(timeout / time.Second),
+	})
+	if err != nil {
+		return -1, err
+	}
+	if err := e.hub.Send(agent.ID, msg); err != nil {
+		return -1, err
+	}
+	logger.WithLabels("exec_id", id, "host_id", hostID, "agent_id", agent.ID).Info("script sent to agent")
+
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout + exitGrace)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	ticker := time.NewTicker(offlineCheck)
+	defer ticker.Stop()
+	for {
+		select {
+		case exit := <-exec.exit:
+			if exit.Error != "" {
+				return exit.Code, errors.New(exit.Error)
+			}
+			return exit.Code, nil
+		case <-ctx.Done():
+			e.cancel(agent.ID, id)
+			return -1, ctx.Err()
+		case <-deadline:
+			e.cancel(agent.ID, id)
+			return -1, errors.New("agent did not report script exit before timeout")
+		case <-ticker.C:
+			if !e.hub.Connected(agent.ID) {
+				return -1, agenthub.ErrAgentOffline
+			}
+		}
+	}
+}
+
+func (e *Executor) cancel(agentID int64, id string) {
+	msg, err := proto.NewMessage(proto.TypeExecCancel, &proto.ExecCancel{ExecID: id})
+	if err != nil {
+		return
+	}
+	if err := e.hub.Send(agentID, msg); err != nil {
+		logger.WithLabels("exec_id", id, "error", err).Debug("cannot cancel script")
+	}
+}
+
+func (e *Executor) handleOutput(ctx context.Context, agentID int64, msg *proto.Message) error {
+	in := new(proto.ExecOutput)
+	if err := msg.Decode(in); err != nil {
+		return err
+	}
+	if exec := e.lookup(agentID, in.ExecID); exec != nil {
+		_, _ = exec.out.Write(in.Data)
+	}
+	return nil
+}
+
+func (e *Executor) handleExit(ctx context.Context, agentID int64, msg *proto.Message) error {
+	in := new(proto.ExecExit)
+	if err := msg.Decode(in); err != nil {
+		return err
+	}
+	if exec := e.lookup(agentID, in.ExecID); exec != nil {
+		select {
+		case exec.exit <- in:
+		default:
+		}
+	}
+	return nil
+}
+
+// lookup 查找属于该 agent 的执行, 防止 agent 伪造其他主机的输出
+func (e *Executor) lookup(agentID int64, id string) *execution {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	exec, ok := e.active[id]
+	if !ok || exec.agentID != agentID {
+		return nil
+	}
+	return exec
+}
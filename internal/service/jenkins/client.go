@@ -0,0 +1,233 @@
+package jenkins
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// consoleLimit 保存的控制台日志末尾部分的最大字节数
+const consoleLimit = 64 * 1024
+
+// Client 访问 Jenkins 远程 API 的最小客户端, 使用用户名和 API Token 进行 Basic 认证
+type Client struct {
+	baseURL  string
+	username string
+	token    string
+	http     *http.Client
+}
+
+// NewClient 创建客户端, baseURL 为 Jenkins 的访问地址, 例如 https://jenkins.example.com
+func NewClient(baseURL, username, token string, httpClient *http.Client) *Client {
+	return &Client{
+		baseURL:  strings.TrimRight(baseURL, "/"),
+		username: username,
+		token:    token,
+		http:     httpClient,
+	}
+}
+
+// APIError Jenkins 返回的非 2xx 响应
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("jenkins: %d %s", e.StatusCode, e.Message)
+}
+
+// QueueItem 排队项, 开始构建后 Executable 不为空
+type QueueItem struct {
+	ID         int64  `json:"id"`
+	Why        string `json:"why"`
+	Cancelled  bool   `json:"cancelled"`
+	Executable *struct {
+		Number int64  `json:"number"`
+		URL    string `json:"url"`
+	} `json:"executable"`
+}
+
+// Build 构建的状态, 构建结束前 Result 为空, Duration 单位为毫秒
+type Build struct {
+	Number    int64  `json:"number"`
+	URL       string `json:"url"`
+	Building  bool   `json:"building"`
+	Result    string `json:"result"`
+	Duration  int64  `json:"duration"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// Version 获取 Jenkins 的版本, 同时用于检查认证信息是否有效
+func (c *Client) Version(ctx context.Context) (string, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/api/json?tree=mode", nil, "")
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("X-Jenkins"), nil
+}
+
+// Build 触发任务的构建, 返回排队项的ID; 参数为空时使用 build 接口, 否则使用 buildWithParameters
+func (c *Client) Build(ctx context.Context, job string, params map[string]string) (int64, error) {
+	path, body := jobPath(job)+"/build", url.Values{}
+	if len(params) > 0 {
+		path = jobPath(job) + "/buildWithParameters"
+		for k, v := range params {
+			body.Set(k, v)
+		}
+	}
+	resp, err := c.do(ctx, http.MethodPost, path, strings.NewReader(body.Encode()), "application/x-www-form-urlencoded")
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	// Location: https://jenkins.example.com/queue/item/123/
+	location := strings.TrimRight(resp.Header.Get("Location"), "/")
+	id, err := strconv.ParseInt(location[strings.LastIndex(location, "/")+1:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("jenkins: unexpected queue location %q", location)
+	}
+	return id, nil
+}
+
+// QueueItem 获取排队项的状态, Jenkins 只在构建开始后保留排队项几分钟
+func (c *Client) QueueItem(ctx context.Context, id int64) (*QueueItem, error) {
+	out := new(QueueItem)
+	err := c.getJSON(ctx, "/queue/item/"+strconv.FormatInt(id, 10)+"/api/json", out)
+	return out, err
+}
+
+// GetBuild 获取构建的状态
+func (c *Client) GetBuild(ctx context.Context, job string, number int64) (*Build, error) {
+	out := new(Build)
+	err := c.getJSON(ctx, jobPath(job)+"/"+strconv.FormatInt(number, 10)+"/api/json", out)
+	return out, err
+}
+
+// Console 获取构建控制台日志的末尾部分, 日志超过 consoleLimit 时截断开头
+func (c *Client) Console(ctx context.Context, job string, number int64) (string, error) {
+	resp, err := c.do(ctx, http.MethodGet, jobPath(job)+"/"+strconv.FormatInt(number, 10)+"/consoleText", nil, "")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	tail := &tailWriter{limit: consoleLimit}
+	if _, err := io.Copy(tail, resp.Body); err != nil {
+		return "", err
+	}
+	return tail.String(), nil
+}
+
+func (c *Client) getJSON(ctx context.Context, path string, out interface{}) error {
+	resp, err := c.do(ctx, http.MethodGet, path, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("jenkins: decode %s: %w", path, err)
+	}
+	return nil
+}
+
+// do 发送请求, POST 请求会先获取 CSRF crumb, 没有开启 CSRF 保护时忽略
+func (c *Client) do(ctx context.Context, method, path string, body io.Reader, contentType string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.username, c.token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if method == http.MethodPost {
+		field, crumb, err := c.crumb(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if field != "" {
+			req.Header.Set(field, crumb)
+		}
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+	}
+	return resp, nil
+}
+
+func (c *Client) crumb(ctx context.Context) (string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/crumbIssuer/api/json", nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.SetBasicAuth(c.username, c.token)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", "", &APIError{StatusCode: resp.StatusCode, Message: "cannot get crumb"}
+	}
+	var out struct {
+		Crumb             string `json:"crumb"`
+		CrumbRequestField string `json:"crumbRequestField"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", "", err
+	}
+	return out.CrumbRequestField, out.Crumb, nil
+}
+
+// jobPath 将任务的完整路径转换为 URL 路径, 例如 team/api 转换为 /job/team/job/api
+func jobPath(name string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(strings.Trim(name, "/"), "/") {
+		b.WriteString("/job/")
+		b.WriteString(url.PathEscape(part))
+	}
+	return b.String()
+}
+
+// tailWriter 只保留最后写入的 limit 个字节
+type tailWriter struct {
+	limit     int
+	buf       []byte
+	truncated bool
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	if len(w.buf) > w.limit {
+		w.buf = w.buf[len(w.buf)-w.limit:]
+		w.truncated = true
+	}
+	return len(p), nil
+}
+
+func (w *tailWriter) String() string {
+	if !w.truncated {
+		return string(w.buf)
+	}
+	// 从第一个完整的行开始, 避免截断多字节字符
+	out := w.buf
+	if i := strings.IndexByte(string(out), '\n'); i >= 0 {
+		out = out[i+1:]
+	}
+	return "...\n" + string(out)
+}
@@ -0,0 +1,389 @@
+// Package jenkins 管理 Jenkins 服务器和关联到应用的任务, 触发带参数的构建并轮询排队项和构建直到结束
+package jenkins
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/eventbus"
+	"github.com/bloodsteel/easynetes/pkg/config"
+	"github.com/bloodsteel/easynetes/pkg/encrypt"
+	"github.com/bloodsteel/easynetes/pkg/log"
+)
+
+var logger = log.RegisterScope("jenkins", "jenkins integration", 0)
+
+// Service 管理 Jenkins 服务器、任务和构建记录
+// 构建的排队项和编号保存在数据库中, 重启后继续轮询没有结束的构建
+type Service struct {
+	servers    core.JenkinsServerDao
+	jobs       core.JenkinsJobDao
+	builds     core.JenkinsBuildDao
+	authorizer core.Authorizer
+	encrypter  *encrypt.Encrypter
+	bus        *eventbus.Bus
+	interval   time.Duration
+	// http 访问 Jenkins 使用的客户端, 测试时替换为 httptest 的客户端
+	http *http.Client
+}
+
+// ProvideService is a Wire provider
+func ProvideService(
+	servers core.JenkinsServerDao,
+	jobs core.JenkinsJobDao,
+	builds core.JenkinsBuildDao,
+	authorizer core.Authorizer,
+	encrypter *encrypt.Encrypter,
+	bus *eventbus.Bus,
+	cfg *config.Config,
+) *Service {
+	return &Service{
+		servers:    servers,
+		jobs:       jobs,
+		builds:     builds,
+		authorizer: authorizer,
+		encrypter:  encrypter,
+		bus:        bus,
+		interval:   cfg.Jenkins.PollInterval * time.Second,
+		http:       &http.Client{Timeout: cfg.Jenkins.Timeout * time.Second},
+	}
+}
+
+// CreateServer 加密 API Token 后保存服务器, 然后立即进行一次连接测试
+func (s *Service) CreateServer(ctx context.Context, server *core.JenkinsServer, token string) (*core.JenkinsServer, error) {
+	if token == "" {
+		return nil, core.ErrInvalidJenkins
+	}
+	if err := s.setServer(server, token); err != nil {
+		return nil, err
+	}
+	server.Status = core.JenkinsUnknown
+	if _, err := s.servers.Create(ctx, server); err != nil {
+		return nil, err
+	}
+	s.Test(ctx, server)
+	return server, nil
+}
+
+// UpdateServer 更新服务器的名称、地址和用户, token 为空时保留原有的 API Token
+func (s *Service) UpdateServer(ctx context.Context, server *core.JenkinsServer, token string) (*core.JenkinsServer, error) {
+	old, err := s.servers.Get(ctx, server.ID)
+	if err != nil {
+		return nil, err
+	}
+	old.Name = server.Name
+	old.URL = server.URL
+	old.Username = server.Username
+	if token == "" {
+		token, err = s.token(old)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := s.setServer(old, token); err != nil {
+		return nil, err
+	}
+	if err := s.servers.Update(ctx, old); err != nil {
+		return nil, err
+	}
+	s.Test(ctx, old)
+	return old, nil
+}
+
+// DeleteServer 删除服务器, 服务器下还有关联的任务时拒绝删除
+func (s *Service) DeleteServer(ctx context.Context, id int64) error {
+	count, err := s.jobs.Count(ctx, map[string]interface{}{"server_id": id})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return core.ErrJenkinsServerInUse
+	}
+	return s.servers.Delete(ctx, id)
+}
+
+// Test 获取 Jenkins 的版本以检查地址和认证信息, 记录连接测试的结果
+func (s *Service) Test(ctx context.Context, server *core.JenkinsServer) {
+	now := time.Now()
+	server.LastCheckTime = &now
+	version, err := s.test(ctx, server)
+	if err != nil {
+		server.Status = core.JenkinsUnreachable
+		server.LastError = err.Error()
+	} else {
+		server.Status = core.JenkinsConnected
+		server.Version = version
+		server.LastError = ""
+	}
+	if err := s.servers.UpdateStatus(ctx, server); err != nil {
+		logger.WithLabels("server", server.Name, "error", err).Error("cannot save jenkins server status")
+	}
+}
+
+func (s *Service) test(ctx context.Context, server *core.JenkinsServer) (string, error) {
+	client, err := s.clientFor(server)
+	if err != nil {
+		return "", err
+	}
+	return client.Version(ctx)
+}
+
+// SaveJob 校验服务器存在后创建或者更新任务, ID 为 0 时创建
+func (s *Service) SaveJob(ctx context.Context, job *core.JenkinsJob) (*core.JenkinsJob, error) {
+	job.Name = strings.Trim(job.Name, "/")
+	if job.Name == "" || job.ServerID == 0 {
+		return nil, core.ErrInvalidJenkins
+	}
+	if _, err := s.servers.Get(ctx, job.ServerID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, core.ErrInvalidJenkins
+		}
+		return nil, err
+	}
+	if job.ID == 0 {
+		_, err := s.jobs.Create(ctx, job)
+		return job, err
+	}
+	old, err := s.jobs.Get(ctx, job.ID)
+	if err != nil {
+		return nil, err
+	}
+	// 任务不能移动到其他服务器, 否则历史构建的编号会对应到错误的任务
+	if old.ServerID != job.ServerID {
+		return nil, core.ErrInvalidJenkins
+	}
+	return job, s.jobs.Update(ctx, job)
+}
+
+// Trigger 使用任务的默认参数和传入的参数以 user 的身份触发构建, 返回排队中的构建记录
+// user 需要拥有任务所属应用或者服务树节点的构建授权
+func (s *Service) Trigger(ctx context.Context, jobID int64, params map[string]string, user *core.User) (*core.JenkinsBuild, error) {
+	job, err := s.jobs.Get(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, job, user); err != nil {
+		return nil, err
+	}
+	client, err := s.Client(ctx, job.ServerID)
+	if err != nil {
+		return nil, err
+	}
+	merged := core.StringMap{}
+	for k, v := range job.Parameters {
+		merged[k] = v
+	}
+	for k, v := range params {
+		merged[k] = v
+	}
+	queueID, err := client.Build(ctx, job.Name, merged)
+	if err != nil {
+		return nil, err
+	}
+	build := &core.JenkinsBuild{
+		JobID:      job.ID,
+		Parameters: merged,
+		QueueID:    queueID,
+		Status:     core.JenkinsBuildQueued,
+		Creator:    user.UserName,
+	}
+	if _, err := s.builds.Create(ctx, build); err != nil {
+		return nil, err
+	}
+	return build, nil
+}
+
+// authorize 检查用户是否拥有任务所属应用或者服务树节点的构建授权, 没有关联应用和服务树节点的任务只有管理员可以构建
+func (s *Service) authorize(ctx context.Context, job *core.JenkinsJob, user *core.User) error {
+	if user == nil {
+		return core.ErrForbidden
+	}
+	if user.IsAdmin {
+		return nil
+	}
+	err := core.ErrForbidden
+	if job.AppID > 0 {
+		err = s.authorizer.Authorize(ctx, user, core.ResourceApplication, strconv.FormatInt(job.AppID, 10), core.ActionBuild)
+	}
+	if errors.Is(err, core.ErrForbidden) && job.ServiceNodeID > 0 {
+		err = s.authorizer.Authorize(ctx, user, core.ResourceServiceNode,
+			strconv.FormatInt(job.ServiceNodeID, 10), core.ActionBuild)
+	}
+	return err
+}
+
+// Client 返回服务器的 Jenkins 客户端
+func (s *Service) Client(ctx context.Context, serverID int64) (*Client, error) {
+	server, err := s.servers.Get(ctx, serverID)
+	if err != nil {
+		return nil, err
+	}
+	return s.clientFor(server)
+}
+
+// Run 定期轮询排队中和构建中的构建, 收到 Jenkins 的构建通知时立即轮询一次
+func (s *Service) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	sub := s.bus.Subscribe("jenkins", 64, "hook.jenkins.*")
+	defer sub.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			s.PollAll(ctx)
+		case <-sub.C:
+			s.PollAll(ctx)
+		}
+	}
+}
+
+// PollAll 依次更新所有没有结束的构建, 任务或者服务器已经被删除的构建标记为 error
+func (s *Service) PollAll(ctx context.Context) {
+	builds, err := s.builds.ListActive(ctx)
+	if err != nil {
+		logger.WithLabels("error", err).Error("cannot list active jenkins builds")
+		return
+	}
+	jobs := make(map[int64]*core.JenkinsJob)
+	clients := make(map[int64]*Client)
+	for _, build := range builds {
+		job, ok := jobs[build.JobID]
+		if !ok {
+			if job, err = s.jobs.Get(ctx, build.JobID); err != nil {
+				s.fail(ctx, build, err)
+				continue
+			}
+			jobs[build.JobID] = job
+		}
+		client, ok := clients[job.ServerID]
+		if !ok {
+			if client, err = s.Client(ctx, job.ServerID); err != nil {
+				s.fail(ctx, build, err)
+				continue
+			}
+			clients[job.ServerID] = client
+		}
+		if err := s.poll(ctx, client, job, build); err != nil {
+			logger.WithLabels("job", job.Name, "build", build.ID, "error", err).Warn("cannot poll jenkins build")
+		}
+	}
+}
+
+// poll 推进一次构建的状态: 排队项开始构建后记录编号, 构建结束后记录结果、耗时和控制台日志
+func (s *Service) poll(ctx context.Context, client *Client, job *core.JenkinsJob, build *core.JenkinsBuild) error {
+	if build.Status == core.JenkinsBuildQueued {
+		item, err := client.QueueItem(ctx, build.QueueID)
+		var apiErr *APIError
+		switch {
+		case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound:
+			// 排队项已经被 Jenkins 清理, 无法再找到对应的构建
+			return s.finish(ctx, build, core.JenkinsBuildError, "queue item not found")
+		case err != nil:
+			return err
+		case item.Cancelled:
+			return s.finish(ctx, build, core.JenkinsBuildAborted, "cancelled in queue")
+		case item.Executable == nil:
+			if item.Why != build.Message {
+				build.Message = item.Why
+				return s.builds.Update(ctx, build)
+			}
+			return nil
+		}
+		now := time.Now()
+		build.Number = item.Executable.Number
+		build.URL = item.Executable.URL
+		build.Status = core.JenkinsBuildBuilding
+		build.Message = ""
+		build.StartTime = &now
+		if err := s.builds.Update(ctx, build); err != nil {
+			return err
+		}
+	}
+
+	remote, err := client.GetBuild(ctx, job.Name, build.Number)
+	if err != nil {
+		return err
+	}
+	if remote.Building || remote.Result == "" {
+		return nil
+	}
+	build.Duration = remote.Duration
+	if remote.Timestamp > 0 {
+		start := time.UnixMilli(remote.Timestamp)
+		build.StartTime = &start
+	}
+	build.Console, err = client.Console(ctx, job.Name, build.Number)
+	if err != nil {
+		logger.WithLabels("job", job.Name, "build", build.Number, "error", err).Warn("cannot get jenkins console")
+	}
+	return s.finish(ctx, build, buildStatus(remote.Result), "")
+}
+
+func (s *Service) finish(ctx context.Context, build *core.JenkinsBuild, status, message string) error {
+	now := time.Now()
+	build.Status = status
+	build.Message = message
+	build.FinishTime = &now
+	return s.builds.Update(ctx, build)
+}
+
+func (s *Service) fail(ctx context.Context, build *core.JenkinsBuild, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		err = s.finish(ctx, build, core.JenkinsBuildError, "jenkins job or server deleted")
+	}
+	if err != nil {
+		logger.WithLabels("build", build.ID, "error", err).Warn("cannot poll jenkins build")
+	}
+}
+
+// buildStatus 将 Jenkins 的构建结果转换为构建状态, NOT_BUILT 按 aborted 处理
+func buildStatus(result string) string {
+	switch result {
+	case "SUCCESS":
+		return core.JenkinsBuildSuccess
+	case "FAILURE":
+		return core.JenkinsBuildFailure
+	case "UNSTABLE":
+		return core.JenkinsBuildUnstable
+	case "ABORTED", "NOT_BUILT":
+		return core.JenkinsBuildAborted
+	default:
+		return core.JenkinsBuildError
+	}
+}
+
+// setServer 校验地址和用户后加密保存 API Token
+func (s *Service) setServer(server *core.JenkinsServer, token string) error {
+	u, err := url.Parse(server.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || server.Name == "" || server.Username == "" {
+		return core.ErrInvalidJenkins
+	}
+	server.URL = strings.TrimRight(server.URL, "/")
+	server.Token, err = s.encrypter.Encrypt([]byte(token))
+	return err
+}
+
+func (s *Service) token(server *core.JenkinsServer) (string, error) {
+	data, err := s.encrypter.Decrypt(server.Token)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (s *Service) clientFor(server *core.JenkinsServer) (*Client, error) {
+	token, err := s.token(server)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(server.URL, server.Username, token, s.http), nil
+}
@@ -0,0 +1,251 @@
+package jenkins
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/pkg/encrypt"
+)
+
+// fakeServerDao 内存中的 JenkinsServerDao
+type fakeServerDao struct {
+	core.JenkinsServerDao
+	items map[int64]*core.JenkinsServer
+}
+
+func (f *fakeServerDao) Get(_ context.Context, id int64) (*core.JenkinsServer, error) {
+	s, ok := f.items[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	out := *s
+	return &out, nil
+}
+
+func (f *fakeServerDao) Create(_ context.Context, s *core.JenkinsServer) (int64, error) {
+	s.ID = int64(len(f.items) + 1)
+	copied := *s
+	f.items[s.ID] = &copied
+	return s.ID, nil
+}
+
+func (f *fakeServerDao) UpdateStatus(_ context.Context, s *core.JenkinsServer) error {
+	copied := *s
+	f.items[s.ID] = &copied
+	return nil
+}
+
+// fakeJobDao 内存中的 JenkinsJobDao
+type fakeJobDao struct {
+	core.JenkinsJobDao
+	items map[int64]*core.JenkinsJob
+}
+
+func (f *fakeJobDao) Get(_ context.Context, id int64) (*core.JenkinsJob, error) {
+	j, ok := f.items[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	out := *j
+	return &out, nil
+}
+
+func (f *fakeJobDao) Create(_ context.Context, j *core.JenkinsJob) (int64, error) {
+	j.ID = int64(len(f.items) + 1)
+	copied := *j
+	f.items[j.ID] = &copied
+	return j.ID, nil
+}
+
+// fakeBuildDao 内存中的 JenkinsBuildDao
+type fakeBuildDao struct {
+	core.JenkinsBuildDao
+	items map[int64]*core.JenkinsBuild
+}
+
+func (f *fakeBuildDao) ListActive(context.Context) ([]*core.JenkinsBuild, error) {
+	var out []*core.JenkinsBuild
+	for _, b := range f.items {
+		if !b.Finished() {
+			copied := *b
+			out = append(out, &copied)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeBuildDao) Create(_ context.Context, b *core.JenkinsBuild) (int64, error) {
+	b.ID = int64(len(f.items) + 1)
+	copied := *b
+	f.items[b.ID] = &copied
+	return b.ID, nil
+}
+
+func (f *fakeBuildDao) Update(_ context.Context, b *core.JenkinsBuild) error {
+	copied := *b
+	f.items[b.ID] = &copied
+	return nil
+}
+
+// fakeJenkins 模拟 Jenkins 远程 API, 排队项和构建在每次查询后推进一步
+// fakeAuthorizer 只允许 grants 中的 resource/resourceID/action
+type fakeAuthorizer struct {
+	grants map[string]bool
+}
+
+func (f fakeAuthorizer) Authorize(_ context.Context, user *core.User, resource, resourceID, action string) error {
+	if user.IsAdmin || f.grants[resource+"/"+resourceID+"/"+action] {
+		return nil
+	}
+	return core.ErrForbidden
+}
+
+type fakeJenkins struct {
+	t          *testing.T
+	queuePolls int
+	buildPolls int
+	params     map[string]string
+}
+
+func (f *fakeJenkins) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if user, pass, ok := r.BasicAuth(); !ok || user != "ci" || pass != "api-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	reply := func(v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(v)
+	}
+	switch {
+	case r.URL.Path == "/api/json":
+		w.Header().Set("X-Jenkins", "2.440.3")
+		reply(map[string]string{"mode": "NORMAL"})
+	case r.URL.Path == "/crumbIssuer/api/json":
+		reply(map[string]string{"crumb": "c0ffee", "crumbRequestField": "Jenkins-Crumb"})
+	case r.Method == http.MethodPost && r.URL.Path == "/job/team/job/api/buildWithParameters":
+		if r.Header.Get("Jenkins-Crumb") != "c0ffee" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_ = r.ParseForm()
+		f.params = map[string]string{}
+		for k := range r.PostForm {
+			f.params[k] = r.PostForm.Get(k)
+		}
+		w.Header().Set("Location", "http://jenkins.internal/queue/item/17/")
+		w.WriteHeader(http.StatusCreated)
+	case r.URL.Path == "/queue/item/17/api/json":
+		f.queuePolls++
+		if f.queuePolls == 1 {
+			reply(map[string]interface{}{"id": 17, "why": "Waiting for next available executor"})
+			return
+		}
+		reply(map[string]interface{}{"id": 17, "executable": map[string]interface{}{
+			"number": 5, "url": "http://jenkins.internal/job/team/job/api/5/",
+		}})
+	case r.URL.Path == "/job/team/job/api/5/api/json":
+		f.buildPolls++
+		if f.buildPolls == 1 {
+			reply(map[string]interface{}{"number": 5, "building": true, "result": nil})
+			return
+		}
+		reply(map[string]interface{}{"number": 5, "building": false, "result": "SUCCESS", "duration": 93000, "timestamp": 1714521600000})
+	case r.URL.Path == "/job/team/job/api/5/consoleText":
+		_, _ = w.Write([]byte(strings.Repeat("compiling\n", consoleLimit/10+10) + "Finished: SUCCESS\n"))
+	default:
+		f.t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestTriggerAndPoll(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeJenkins{t: t}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	encrypter, err := encrypt.New("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	servers := &fakeServerDao{items: map[int64]*core.JenkinsServer{}}
+	jobs := &fakeJobDao{items: map[int64]*core.JenkinsJob{}}
+	builds := &fakeBuildDao{items: map[int64]*core.JenkinsBuild{}}
+	authorizer := fakeAuthorizer{grants: map[string]bool{"service_node/8/build": true}}
+	s := &Service{servers: servers, jobs: jobs, builds: builds, authorizer: authorizer, encrypter: encrypter, http: server.Client()}
+
+	srv, err := s.CreateServer(ctx, &core.JenkinsServer{Name: "ci", URL: server.URL, Username: "ci"}, "api-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.Status != core.JenkinsConnected || srv.Version != "2.440.3" {
+		t.Fatalf("unexpected server status %+v", srv)
+	}
+	if _, err := s.SaveJob(ctx, &core.JenkinsJob{ServerID: 99, Name: "team/api"}); err != core.ErrInvalidJenkins {
+		t.Fatalf("expected invalid job for unknown server, got %v", err)
+	}
+	job, err := s.SaveJob(ctx, &core.JenkinsJob{
+		ServerID: srv.ID, Name: "/team/api/", AppID: 3, ServiceNodeID: 8,
+		Parameters: core.StringMap{"BRANCH": "main", "DEPLOY": "false"},
+	})
+	if err != nil || job.Name != "team/api" {
+		t.Fatalf("unexpected job %+v %v", job, err)
+	}
+
+	// 没有应用或者服务树节点授权的用户不能触发构建
+	other, err := s.SaveJob(ctx, &core.JenkinsJob{ServerID: srv.ID, Name: "team/web", AppID: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Trigger(ctx, other.ID, nil, &core.User{ID: 2, UserName: "alice"}); err != core.ErrForbidden {
+		t.Fatalf("trigger without grant: %v", err)
+	}
+	build, err := s.Trigger(ctx, job.ID, map[string]string{"BRANCH": "release/1.2"}, &core.User{ID: 2, UserName: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if build.QueueID != 17 || build.Status != core.JenkinsBuildQueued {
+		t.Fatalf("unexpected build %+v", build)
+	}
+	if fake.params["BRANCH"] != "release/1.2" || fake.params["DEPLOY"] != "false" {
+		t.Fatalf("unexpected build parameters %v", fake.params)
+	}
+
+	// 第一次轮询: 仍在排队
+	s.PollAll(ctx)
+	if got := builds.items[build.ID]; got.Status != core.JenkinsBuildQueued || got.Message == "" {
+		t.Fatalf("expected queued with reason, got %+v", got)
+	}
+	// 第二次轮询: 开始构建
+	s.PollAll(ctx)
+	if got := builds.items[build.ID]; got.Status != core.JenkinsBuildBuilding || got.Number != 5 {
+		t.Fatalf("expected building #5, got %+v", got)
+	}
+	// 第三次轮询: 构建结束
+	s.PollAll(ctx)
+	got := builds.items[build.ID]
+	if got.Status != core.JenkinsBuildSuccess || got.Duration != 93000 || got.FinishTime == nil {
+		t.Fatalf("expected success, got %+v", got)
+	}
+	if len(got.Console) > consoleLimit+4 || !strings.HasSuffix(got.Console, "Finished: SUCCESS\n") || !strings.HasPrefix(got.Console, "...\n") {
+		t.Fatalf("console should be truncated to the tail, got %d bytes", len(got.Console))
+	}
+
+	// 已经结束的构建不再轮询
+	s.PollAll(ctx)
+	if fake.buildPolls != 2 {
+		t.Fatalf("finished build should not be polled again, got %d polls", fake.buildPolls)
+	}
+}
+
+func TestJobPath(t *testing.T) {
+	if got := jobPath("team/my api"); got != "/job/team/job/my%20api" {
+		t.Fatalf("unexpected job path %s", got)
+	}
+}
@@ -0,0 +1,389 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/pkg/config"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// 集群授权创建的对象上的标签
+const (
+	LabelAccessGrant = "easynetes.io/access-grant"
+	LabelAccessUser  = "easynetes.io/access-user"
+)
+
+// minTokenTTL TokenRequest 允许的最短有效期
+const minTokenTTL = 10 * time.Minute
+
+// ErrNoServiceAccount 用户在 namespace 中没有 serviceaccount 方式的授权, 无法生成 kubeconfig
+var ErrNoServiceAccount = errors.New("no service account access grant in the namespace")
+
+// Access 将 easynetes 的授权同步为集群中的 RoleBinding 和 ServiceAccount, 并定期修正被手工修改或者删除的对象
+type Access struct {
+	registry *Registry
+	clusters core.KubeClusterDao
+	grants   core.KubeAccessGrantDao
+	interval time.Duration
+	ttl      time.Duration
+}
+
+// Kubeconfig 用户下载的 kubeconfig
+type Kubeconfig struct {
+	Data       []byte
+	ExpireTime time.Time
+}
+
+// ProvideAccess is a Wire provider
+func ProvideAccess(registry *Registry, clusters core.KubeClusterDao, grants core.KubeAccessGrantDao, cfg *config.Config) *Access {
+	return &Access{
+		registry: registry,
+		clusters: clusters,
+		grants:   grants,
+		interval: cfg.Kubernetes.SyncInterval * time.Second,
+		ttl:      cfg.Kubernetes.KubeconfigTTL * time.Second,
+	}
+}
+
+// Grant 保存授权并立即同步到集群, 同步失败时授权仍然保留, 由定期的同步重试
+func (a *Access) Grant(ctx context.Context, in *core.KubeAccessGrant) (*core.KubeAccessGrant, error) {
+	if err := validateAccess(in); err != nil {
+		return nil, err
+	}
+	client, err := a.registry.Client(ctx, in.ClusterID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := a.grants.Create(ctx, in); err != nil {
+		return nil, err
+	}
+	a.apply(ctx, client, in)
+	return in, nil
+}
+
+// Revoke 删除授权对应的 RoleBinding, 用户在 namespace 中没有其他授权时一并删除 ServiceAccount
+func (a *Access) Revoke(ctx context.Context, id int64) error {
+	grant, err := a.grants.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	client, err := a.registry.Client(ctx, grant.ClusterID)
+	if err != nil {
+		return err
+	}
+	err = client.RbacV1().RoleBindings(grant.Namespace).Delete(ctx, bindingName(grant), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	if err := a.grants.Delete(ctx, id); err != nil {
+		return err
+	}
+	if grant.Mode != core.KubeAccessServiceAccount {
+		return nil
+	}
+	remaining, err := a.grants.Count(ctx, map[string]interface{}{
+		"cluster_id": grant.ClusterID,
+		"namespace":  grant.Namespace,
+		"user_id":    grant.UserID,
+		"mode":       core.KubeAccessServiceAccount,
+	})
+	if err != nil || remaining > 0 {
+		return err
+	}
+	err = client.CoreV1().ServiceAccounts(grant.Namespace).Delete(ctx, serviceAccountName(grant.UserID), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// Kubeconfig 为用户在 namespace 中的 ServiceAccount 申请短期令牌, 生成只包含该 namespace 的 kubeconfig
+func (a *Access) Kubeconfig(ctx context.Context, clusterID int64, namespace string, user *core.User) (*Kubeconfig, error) {
+	if user == nil {
+		return nil, core.ErrForbidden
+	}
+	count, err := a.grants.Count(ctx, map[string]interface{}{
+		"cluster_id": clusterID,
+		"namespace":  namespace,
+		"user_id":    user.ID,
+		"mode":       core.KubeAccessServiceAccount,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrNoServiceAccount
+	}
+	cluster, err := a.clusters.Get(ctx, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	client, err := a.registry.Client(ctx, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	restConfig, err := a.registry.RESTConfig(ctx, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	ttl := a.ttl
+	if ttl < minTokenTTL {
+		ttl = minTokenTTL
+	}
+	seconds := int64(ttl / time.Second)
+	token, err := client.CoreV1().ServiceAccounts(namespace).CreateToken(ctx, serviceAccountName(user.ID),
+		&authenticationv1.TokenRequest{Spec: authenticationv1.TokenRequestSpec{ExpirationSeconds: &seconds}},
+		metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	name := cluster.Name + "-" + namespace
+	kubeconfig := clientcmdapi.NewConfig()
+	kubeconfig.Clusters[cluster.Name] = &clientcmdapi.Cluster{
+		Server:                   restConfig.Host,
+		CertificateAuthorityData: restConfig.CAData,
+		InsecureSkipTLSVerify:    restConfig.Insecure,
+	}
+	kubeconfig.AuthInfos[user.UserName] = &clientcmdapi.AuthInfo{Token: token.Status.Token}
+	kubeconfig.Contexts[name] = &clientcmdapi.Context{Cluster: cluster.Name, AuthInfo: user.UserName, Namespace: namespace}
+	kubeconfig.CurrentContext = name
+	data, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	logger.WithLabels("cluster", cluster.Name, "namespace", namespace, "user", user.UserName).Info("kubeconfig issued")
+	return &Kubeconfig{Data: data, ExpireTime: token.Status.ExpirationTimestamp.Time}, nil
+}
+
+// Run 定期修正所有集群中的授权对象, 直到 ctx 结束
+func (a *Access) Run(ctx context.Context) error {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			a.ReconcileAll(ctx)
+		}
+	}
+}
+
+// ReconcileAll 依次修正所有集群中的授权对象, 单个集群失败不影响其他集群
+func (a *Access) ReconcileAll(ctx context.Context) {
+	clusters, err := a.clusters.List(ctx, map[string]interface{}{})
+	if err != nil {
+		logger.WithLabels("error", err).Error("cannot list clusters")
+		return
+	}
+	for _, cluster := range clusters {
+		if err := a.Reconcile(ctx, cluster.ID); err != nil {
+			logger.WithLabels("cluster", cluster.Name, "error", err).Warn("cannot reconcile access grants")
+		}
+	}
+}
+
+// Reconcile 重新创建缺失或者被修改的 RoleBinding 和 ServiceAccount, 删除已经没有授权的对象
+func (a *Access) Reconcile(ctx context.Context, clusterID int64) error {
+	client, err := a.registry.Client(ctx, clusterID)
+	if err != nil {
+		return err
+	}
+	grants, err := a.grants.List(ctx, map[string]interface{}{"cluster_id": clusterID})
+	if err != nil {
+		return err
+	}
+	bindings := make(map[string]bool, len(grants))
+	accounts := make(map[string]bool)
+	for _, grant := range grants {
+		a.apply(ctx, client, grant)
+		bindings[grant.Namespace+"/"+bindingName(grant)] = true
+		if grant.Mode == core.KubeAccessServiceAccount {
+			accounts[grant.Namespace+"/"+serviceAccountName(grant.UserID)] = true
+		}
+	}
+
+	existing, err := client.RbacV1().RoleBindings(metav1.NamespaceAll).List(ctx, metav1.ListOptions{LabelSelector: LabelAccessGrant})
+	if err != nil {
+		return err
+	}
+	for _, binding := range existing.Items {
+		if bindings[binding.Namespace+"/"+binding.Name] {
+			continue
+		}
+		err := client.RbacV1().RoleBindings(binding.Namespace).Delete(ctx, binding.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		logger.WithLabels("cluster_id", clusterID, "namespace", binding.Namespace, "name", binding.Name).Info("orphan role binding deleted")
+	}
+	sas, err := client.CoreV1().ServiceAccounts(metav1.NamespaceAll).List(ctx, metav1.ListOptions{LabelSelector: LabelAccessUser})
+	if err != nil {
+		return err
+	}
+	for _, sa := range sas.Items {
+		if accounts[sa.Namespace+"/"+sa.Name] {
+			continue
+		}
+		err := client.CoreV1().ServiceAccounts(sa.Namespace).Delete(ctx, sa.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		logger.WithLabels("cluster_id", clusterID, "namespace", sa.Namespace, "name", sa.Name).Info("orphan service account deleted")
+	}
+	return nil
+}
+
+// apply 同步单个授权并保存同步状态, 状态没有变化时不写数据库
+func (a *Access) apply(ctx context.Context, client kubernetes.Interface, grant *core.KubeAccessGrant) {
+	status, lastError := core.KubeAccessSynced, ""
+	if err := ensureAccess(ctx, client, grant); err != nil {
+		status, lastError = core.KubeAccessError, err.Error()
+		logger.WithLabels("grant", grant.ID, "namespace", grant.Namespace, "error", err).Warn("cannot sync access grant")
+	}
+	if grant.Status == status && grant.LastError == lastError && grant.SyncTime != nil {
+		return
+	}
+	now := time.Now()
+	grant.Status, grant.LastError, grant.SyncTime = status, lastError, &now
+	if err := a.grants.UpdateStatus(ctx, grant); err != nil {
+		logger.WithLabels("grant", grant.ID, "error", err).Error("cannot save access grant status")
+	}
+}
+
+// ensureAccess 确保授权对应的 ServiceAccount 和 RoleBinding 存在且与授权一致
+// RoleBinding 的 roleRef 不能修改, 角色不一致时删除后重新创建
+func ensureAccess(ctx context.Context, client kubernetes.Interface, grant *core.KubeAccessGrant) error {
+	if grant.Mode == core.KubeAccessServiceAccount {
+		sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+			Name:      serviceAccountName(grant.UserID),
+			Namespace: grant.Namespace,
+			Labels: map[string]string{
+				LabelManaged:    "true",
+				LabelAccessUser: strconv.FormatInt(grant.UserID, 10),
+			},
+		}}
+		_, err := client.CoreV1().ServiceAccounts(grant.Namespace).Create(ctx, sa, metav1.CreateOptions{})
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			return err
+		}
+	}
+	desired := roleBinding(grant)
+	bindings := client.RbacV1().RoleBindings(grant.Namespace)
+	live, err := bindings.Get(ctx, desired.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = bindings.Create(ctx, desired, metav1.CreateOptions{})
+		return err
+	} else if err != nil {
+		return err
+	}
+	if live.RoleRef != desired.RoleRef {
+		if err := bindings.Delete(ctx, desired.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		_, err = bindings.Create(ctx, desired, metav1.CreateOptions{})
+		return err
+	}
+	if reflect.DeepEqual(live.Subjects, desired.Subjects) && live.Labels[LabelAccessGrant] == desired.Labels[LabelAccessGrant] {
+		return nil
+	}
+	live.Subjects = desired.Subjects
+	if live.Labels == nil {
+		live.Labels = make(map[string]string)
+	}
+	for k, v := range desired.Labels {
+		live.Labels[k] = v
+	}
+	_, err = bindings.Update(ctx, live, metav1.UpdateOptions{})
+	return err
+}
+
+// roleBinding 返回授权对应的 RoleBinding, 角色引用集群内置的同名 ClusterRole
+func roleBinding(grant *core.KubeAccessGrant) *rbacv1.RoleBinding {
+	var subject rbacv1.Subject
+	switch {
+	case grant.Mode == core.KubeAccessServiceAccount:
+		subject = rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: serviceAccountName(grant.UserID), Namespace: grant.Namespace}
+	case grant.SubjectKind == core.KubeSubjectGroup:
+		subject = rbacv1.Subject{Kind: rbacv1.GroupKind, APIGroup: rbacv1.GroupName, Name: grant.SubjectName}
+	default:
+		subject = rbacv1.Subject{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: grant.SubjectName}
+	}
+	return &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      bindingName(grant),
+			Namespace: grant.Namespace,
+			Labels: map[string]string{
+				LabelManaged:     "true",
+				LabelAccessGrant: strconv.FormatInt(grant.ID, 10),
+			},
+		},
+		RoleRef:  rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: grant.Role},
+		Subjects: []rbacv1.Subject{subject},
+	}
+}
+
+func bindingName(grant *core.KubeAccessGrant) string {
+	return fmt.Sprintf("easynetes-grant-%d", grant.ID)
+}
+
+func serviceAccountName(userID int64) string {
+	return fmt.Sprintf("easynetes-user-%d", userID)
+}
+
+// validateAccess 校验授权, 并为用户默认使用 serviceaccount 方式
+func validateAccess(grant *core.KubeAccessGrant) error {
+	if errs := validation.IsDNS1123Label(grant.Namespace); len(errs) > 0 {
+		return fmt.Errorf("%w: namespace: %s", core.ErrInvalidKubeAccess, strings.Join(errs, "; "))
+	}
+	switch grant.Role {
+	case core.KubeRoleView, core.KubeRoleEdit, core.KubeRoleAdmin:
+	default:
+		return fmt.Errorf("%w: unsupported role %q", core.ErrInvalidKubeAccess, grant.Role)
+	}
+	switch grant.SubjectKind {
+	case core.KubeSubjectUser:
+		if grant.Mode == "" {
+			grant.Mode = core.KubeAccessServiceAccount
+		}
+		if grant.UserID == 0 {
+			return fmt.Errorf("%w: user_id is required", core.ErrInvalidKubeAccess)
+		}
+	case core.KubeSubjectGroup:
+		if grant.Mode == "" {
+			grant.Mode = core.KubeAccessRoleBinding
+		}
+		if grant.Mode != core.KubeAccessRoleBinding {
+			return fmt.Errorf("%w: groups can only be bound by role binding", core.ErrInvalidKubeAccess)
+		}
+		grant.UserID = 0
+	default:
+		return fmt.Errorf("%w: unsupported subject kind %q", core.ErrInvalidKubeAccess, grant.SubjectKind)
+	}
+	switch grant.Mode {
+	case core.KubeAccessServiceAccount:
+	case core.KubeAccessRoleBinding:
+		if grant.SubjectName == "" {
+			return fmt.Errorf("%w: subject_name is required for role binding", core.ErrInvalidKubeAccess)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported mode %q", core.ErrInvalidKubeAccess, grant.Mode)
+	}
+	return nil
+}
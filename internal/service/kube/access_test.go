@@ -0,0 +1,184 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// fakeAccessDao 内存中的 KubeAccessGrantDao
+type fakeAccessDao struct {
+	core.KubeAccessGrantDao
+	items map[int64]*core.KubeAccessGrant
+	next  int64
+}
+
+func (f *fakeAccessDao) Get(_ context.Context, id int64) (*core.KubeAccessGrant, error) {
+	grant, ok := f.items[id]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	out := *grant
+	return &out, nil
+}
+
+func (f *fakeAccessDao) match(grant *core.KubeAccessGrant, in map[string]interface{}) bool {
+	return (in["cluster_id"] == nil || in["cluster_id"] == grant.ClusterID) &&
+		(in["namespace"] == nil || in["namespace"] == grant.Namespace) &&
+		(in["user_id"] == nil || in["user_id"] == grant.UserID) &&
+		(in["mode"] == nil || in["mode"] == grant.Mode)
+}
+
+func (f *fakeAccessDao) List(_ context.Context, in map[string]interface{}) ([]*core.KubeAccessGrant, error) {
+	var out []*core.KubeAccessGrant
+	for _, grant := range f.items {
+		if f.match(grant, in) {
+			copied := *grant
+			out = append(out, &copied)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeAccessDao) Count(ctx context.Context, in map[string]interface{}) (int64, error) {
+	out, _ := f.List(ctx, in)
+	return int64(len(out)), nil
+}
+
+func (f *fakeAccessDao) Create(_ context.Context, in *core.KubeAccessGrant) (int64, error) {
+	f.next++
+	in.ID = f.next
+	in.Status = core.KubeAccessPending
+	out := *in
+	f.items[in.ID] = &out
+	return in.ID, nil
+}
+
+func (f *fakeAccessDao) UpdateStatus(_ context.Context, in *core.KubeAccessGrant) error {
+	out := *in
+	f.items[in.ID] = &out
+	return nil
+}
+
+func (f *fakeAccessDao) Delete(_ context.Context, id int64) error {
+	delete(f.items, id)
+	return nil
+}
+
+func TestAccess(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "token" {
+			return false, nil, nil
+		}
+		request := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenRequest)
+		request.Status = authenticationv1.TokenRequestStatus{
+			Token:               "short-lived",
+			ExpirationTimestamp: metav1.NewTime(time.Now().Add(time.Duration(*request.Spec.ExpirationSeconds) * time.Second)),
+		}
+		return true, request, nil
+	})
+	r, clusters := newTestRegistry(t, client)
+	cluster, err := r.Create(ctx,
+		&core.KubeCluster{Name: "test", AuthType: core.KubeAuthKubeconfig},
+		&core.KubeCredential{Kubeconfig: testKubeconfig},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grants := &fakeAccessDao{items: make(map[int64]*core.KubeAccessGrant)}
+	a := &Access{registry: r, clusters: clusters, grants: grants, ttl: time.Hour}
+
+	if _, err := a.Grant(ctx, &core.KubeAccessGrant{ClusterID: cluster.ID, Namespace: "payment",
+		SubjectKind: core.KubeSubjectGroup, Role: core.KubeRoleEdit, Mode: core.KubeAccessServiceAccount}); !errors.Is(err, core.ErrInvalidKubeAccess) {
+		t.Errorf("group service account grant error = %v", err)
+	}
+	userGrant, err := a.Grant(ctx, &core.KubeAccessGrant{ClusterID: cluster.ID, Namespace: "payment",
+		SubjectKind: core.KubeSubjectUser, UserID: 7, SubjectName: "alice", Role: core.KubeRoleEdit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	groupGrant, err := a.Grant(ctx, &core.KubeAccessGrant{ClusterID: cluster.ID, Namespace: "payment",
+		SubjectKind: core.KubeSubjectGroup, SubjectName: "sre", Role: core.KubeRoleView})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userGrant.Mode != core.KubeAccessServiceAccount || grants.items[userGrant.ID].Status != core.KubeAccessSynced {
+		t.Errorf("user grant = %+v", grants.items[userGrant.ID])
+	}
+	binding, err := client.RbacV1().RoleBindings("payment").Get(ctx, bindingName(userGrant), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if binding.RoleRef.Name != "edit" || binding.Subjects[0].Kind != rbacv1.ServiceAccountKind ||
+		binding.Subjects[0].Name != serviceAccountName(7) {
+		t.Errorf("binding = %+v", binding)
+	}
+	binding, _ = client.RbacV1().RoleBindings("payment").Get(ctx, bindingName(groupGrant), metav1.GetOptions{})
+	if binding.Subjects[0].Kind != rbacv1.GroupKind || binding.Subjects[0].Name != "sre" {
+		t.Errorf("group binding = %+v", binding.Subjects)
+	}
+
+	kubeconfig, err := a.Kubeconfig(ctx, cluster.ID, "payment", &core.User{ID: 7, UserName: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := clientcmd.Load(kubeconfig.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.AuthInfos["alice"].Token != "short-lived" || loaded.Contexts[loaded.CurrentContext].Namespace != "payment" ||
+		loaded.Clusters["test"].Server != "https://10.0.0.1:6443" || time.Until(kubeconfig.ExpireTime) > time.Hour {
+		t.Errorf("kubeconfig = %s", kubeconfig.Data)
+	}
+	if _, err := a.Kubeconfig(ctx, cluster.ID, "payment", &core.User{ID: 8}); !errors.Is(err, ErrNoServiceAccount) {
+		t.Errorf("kubeconfig without grant error = %v", err)
+	}
+
+	// 手工删除和修改的对象被修正, 不属于任何授权的对象被删除
+	_ = client.RbacV1().RoleBindings("payment").Delete(ctx, bindingName(userGrant), metav1.DeleteOptions{})
+	binding.RoleRef.Name = "admin"
+	_ = client.RbacV1().RoleBindings("payment").Delete(ctx, binding.Name, metav1.DeleteOptions{})
+	binding.ResourceVersion = ""
+	_, _ = client.RbacV1().RoleBindings("payment").Create(ctx, binding, metav1.CreateOptions{})
+	orphan := roleBinding(&core.KubeAccessGrant{ID: 99, Namespace: "payment", Role: core.KubeRoleAdmin, SubjectName: "mallory"})
+	_, _ = client.RbacV1().RoleBindings("payment").Create(ctx, orphan, metav1.CreateOptions{})
+	if err := a.Reconcile(ctx, cluster.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.RbacV1().RoleBindings("payment").Get(ctx, bindingName(userGrant), metav1.GetOptions{}); err != nil {
+		t.Errorf("deleted binding not recreated: %v", err)
+	}
+	binding, _ = client.RbacV1().RoleBindings("payment").Get(ctx, bindingName(groupGrant), metav1.GetOptions{})
+	if binding.RoleRef.Name != "view" {
+		t.Errorf("drifted role = %s, want view", binding.RoleRef.Name)
+	}
+	if _, err := client.RbacV1().RoleBindings("payment").Get(ctx, orphan.Name, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("orphan binding error = %v, want not found", err)
+	}
+
+	if err := a.Revoke(ctx, userGrant.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.RbacV1().RoleBindings("payment").Get(ctx, bindingName(userGrant), metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("revoked binding error = %v", err)
+	}
+	if _, err := client.CoreV1().ServiceAccounts("payment").Get(ctx, serviceAccountName(7), metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("service account error = %v, want deleted with the last grant", err)
+	}
+	if len(grants.items) != 1 || grants.items[groupGrant.ID] == nil {
+		t.Errorf("grants = %v", grants.items)
+	}
+}
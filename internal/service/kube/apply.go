@@ -0,0 +1,439 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/pkg/config"
+	"github.com/google/uuid"
+	"github.com/pmezard/go-difflib/difflib"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	yamlutil "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"sigs.k8s.io/yaml"
+)
+
+// FieldManager easynetes 执行 server-side apply 时使用的 field manager
+const FieldManager = "easynetes"
+
+var (
+	// ErrPlanExpired 变更计划已经过期, 需要重新生成
+	ErrPlanExpired = errors.New("apply plan expired")
+	// ErrPlanNotPending 变更计划已经被执行过
+	ErrPlanNotPending = errors.New("apply plan is not pending")
+	// ErrPlanStale 生成计划之后集群中的对象被修改过, 需要重新生成
+	ErrPlanStale = errors.New("live objects changed since the plan was created")
+)
+
+// applyOrder 先创建 namespace 和 CRD, 其他对象才能创建
+var applyOrder = map[string]int{
+	"Namespace":                0,
+	"CustomResourceDefinition": 1,
+}
+
+// Applier 通过 server-side dry-run 生成 YAML 变更计划, 确认后使用 server-side apply 执行
+type Applier struct {
+	registry   *Registry
+	plans      core.KubeApplyPlanDao
+	authorizer core.Authorizer
+	ttl        time.Duration
+	// newDynamic 根据 rest.Config 创建 dynamic client, 测试时替换为 fake client
+	newDynamic func(*rest.Config) (dynamic.Interface, error)
+}
+
+// applyTarget 清单中的一个对象, 以及对应的 dynamic client
+type applyTarget struct {
+	obj        *unstructured.Unstructured
+	resource   dynamic.ResourceInterface
+	namespaced bool
+}
+
+// ProvideApplier is a Wire provider
+func ProvideApplier(registry *Registry, plans core.KubeApplyPlanDao, authorizer core.Authorizer, cfg *config.Config) *Applier {
+	return &Applier{
+		registry:   registry,
+		plans:      plans,
+		authorizer: authorizer,
+		ttl:        cfg.Kubernetes.PlanTTL * time.Second,
+		newDynamic: func(c *rest.Config) (dynamic.Interface, error) {
+			return dynamic.NewForConfig(c)
+		},
+	}
+}
+
+// Get 返回变更计划, 只有计划的创建人或者管理员可以查看
+func (a *Applier) Get(ctx context.Context, id string, user *core.User) (*core.KubeApplyPlan, error) {
+	plan, err := a.plans.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || plan.UserID != user.ID && !user.IsAdmin {
+		return nil, core.ErrForbidden
+	}
+	return plan, nil
+}
+
+// Plan 对清单中的每个对象执行 server-side dry-run, 与集群中的现有对象比较后保存为变更计划
+// 清单同时创建 namespace 时, namespace 中的对象无法 dry-run, 直接按清单的内容计划为创建
+func (a *Applier) Plan(ctx context.Context, clusterID int64, manifest []byte, user *core.User) (*core.KubeApplyPlan, error) {
+	targets, err := a.prepare(ctx, clusterID, manifest, user)
+	if err != nil {
+		return nil, err
+	}
+	plan := &core.KubeApplyPlan{
+		ID:         uuid.New().String(),
+		ClusterID:  clusterID,
+		Manifest:   string(manifest),
+		UserID:     user.ID,
+		Creator:    user.UserName,
+		ExpireTime: time.Now().Add(a.ttl),
+	}
+	created := make(map[string]bool)
+	for _, t := range targets {
+		var live, planned *unstructured.Unstructured
+		if t.namespaced && created[t.obj.GetNamespace()] {
+			planned = t.obj
+		} else {
+			if live, err = t.get(ctx); err != nil {
+				return nil, err
+			}
+			if planned, err = t.apply(ctx, true); err != nil {
+				return nil, err
+			}
+		}
+		if live == nil && t.obj.GetAPIVersion() == "v1" && t.obj.GetKind() == "Namespace" {
+			created[t.obj.GetName()] = true
+		}
+		change := &core.KubeApplyChange{
+			APIVersion: t.obj.GetAPIVersion(),
+			Kind:       t.obj.GetKind(),
+			Namespace:  t.obj.GetNamespace(),
+			Name:       t.obj.GetName(),
+			Action:     core.KubeChangeCreate,
+		}
+		var from string
+		if live != nil {
+			from = render(live)
+			change.LiveDigest = digest(from)
+			change.Action = core.KubeChangeUpdate
+		}
+		to := render(planned)
+		if from == to {
+			change.Action = core.KubeChangeUnchanged
+		} else {
+			change.Diff = diff(from, to)
+		}
+		plan.Changes = append(plan.Changes, change)
+	}
+	if err := a.plans.Create(ctx, plan); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// Apply 确认并执行变更计划, 只有计划的创建人或者管理员可以确认
+// 单个对象执行失败不会中断其他对象, 失败信息记录在计划的 Result 中
+func (a *Applier) Apply(ctx context.Context, id string, user *core.User) (*core.KubeApplyPlan, error) {
+	plan, err := a.plans.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || plan.UserID != user.ID && !user.IsAdmin {
+		return nil, core.ErrForbidden
+	}
+	if plan.Status != core.KubeApplyPending {
+		return nil, ErrPlanNotPending
+	}
+	if time.Now().After(plan.ExpireTime) {
+		return nil, ErrPlanExpired
+	}
+	targets, err := a.prepare(ctx, plan.ClusterID, []byte(plan.Manifest), user)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) != len(plan.Changes) {
+		return nil, ErrPlanStale
+	}
+	for i, t := range targets {
+		live, err := t.get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var current string
+		if live != nil {
+			current = digest(render(live))
+		}
+		if current != plan.Changes[i].LiveDigest {
+			return nil, fmt.Errorf("%w: %s", ErrPlanStale, objectKey(t.obj))
+		}
+	}
+	claimed, err := a.plans.Claim(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, ErrPlanNotPending
+	}
+
+	var failures []string
+	for i, t := range targets {
+		if plan.Changes[i].Action == core.KubeChangeUnchanged {
+			continue
+		}
+		if _, err := t.apply(ctx, false); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	plan.Status = core.KubeApplyApplied
+	if len(failures) > 0 {
+		plan.Status = core.KubeApplyFailed
+		plan.Result = strings.Join(failures, "\n")
+	}
+	logger.WithLabels("plan", plan.ID, "cluster_id", plan.ClusterID, "operator", user.UserName,
+		"status", plan.Status).Info("apply plan executed")
+	if err := a.plans.Finish(ctx, plan); err != nil {
+		logger.WithLabels("plan", plan.ID, "error", err).Error("cannot save apply plan result")
+	}
+	return plan, nil
+}
+
+// Deploy 不生成变更计划, 直接使用 server-side apply 提交清单, 用于应用发布这类由平台渲染的清单
+// 权限检查与 Apply 相同, 单个对象失败不会中断其他对象
+func (a *Applier) Deploy(ctx context.Context, clusterID int64, manifest []byte, user *core.User) error {
+	targets, err := a.prepare(ctx, clusterID, manifest, user)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, t := range targets {
+		if _, err := t.apply(ctx, false); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// prepare 解析清单, 找到每个对象对应的 API 资源并检查用户的权限
+// 集群级别的对象只有管理员可以修改, namespace 中的对象需要 namespace 的 apply 授权
+func (a *Applier) prepare(ctx context.Context, clusterID int64, manifest []byte, user *core.User) ([]*applyTarget, error) {
+	objs, err := parseManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+	client, err := a.registry.Client(ctx, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	restConfig, err := a.registry.RESTConfig(ctx, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	dyn, err := a.newDynamic(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	// 部分聚合 API 不可用时仍然可以使用其他的资源
+	groups, err := restmapper.GetAPIGroupResources(client.Discovery())
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return nil, err
+	}
+	mapper := restmapper.NewDiscoveryRESTMapper(groups)
+
+	targets := make([]*applyTarget, 0, len(objs))
+	for _, obj := range objs {
+		gvk := obj.GroupVersionKind()
+		mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if meta.IsNoMatchError(err) && definesKind(objs, gvk.GroupKind()) {
+			return nil, fmt.Errorf("%w: %s is defined by a CustomResourceDefinition in the same manifest, "+
+				"apply the CustomResourceDefinition first", core.ErrInvalidManifest, gvk)
+		} else if meta.IsNoMatchError(err) {
+			return nil, fmt.Errorf("%w: %s is not supported by the cluster", core.ErrInvalidManifest, gvk)
+		} else if err != nil {
+			return nil, err
+		}
+		t := &applyTarget{obj: obj}
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			if obj.GetNamespace() == "" {
+				obj.SetNamespace(metav1.NamespaceDefault)
+			}
+			t.namespaced = true
+			t.resource = dyn.Resource(mapping.Resource).Namespace(obj.GetNamespace())
+			err = a.authorizer.Authorize(ctx, user, core.ResourceKubeNamespace,
+				core.KubeNamespaceResource(clusterID, obj.GetNamespace()), core.ActionKubeApply)
+		} else {
+			obj.SetNamespace("")
+			t.resource = dyn.Resource(mapping.Resource)
+			if user == nil || !user.IsAdmin {
+				err = core.ErrForbidden
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// get 返回集群中的对象, 对象不存在时返回 nil
+func (t *applyTarget) get(ctx context.Context) (*unstructured.Unstructured, error) {
+	live, err := t.resource.Get(ctx, t.obj.GetName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", objectKey(t.obj), err)
+	}
+	return live, nil
+}
+
+// apply 使用 server-side apply 提交对象, 强制接管与其他 field manager 冲突的字段
+func (t *applyTarget) apply(ctx context.Context, dryRun bool) (*unstructured.Unstructured, error) {
+	data, err := json.Marshal(t.obj.Object)
+	if err != nil {
+		return nil, err
+	}
+	force := true
+	opts := metav1.PatchOptions{FieldManager: FieldManager, Force: &force}
+	if dryRun {
+		opts.DryRun = []string{metav1.DryRunAll}
+	}
+	out, err := t.resource.Patch(ctx, t.obj.GetName(), types.ApplyPatchType, data, opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", objectKey(t.obj), err)
+	}
+	return out, nil
+}
+
+// parseManifest 解析多文档的 YAML 清单, 按照 applyOrder 排序
+func parseManifest(data []byte) ([]*unstructured.Unstructured, error) {
+	decoder := yamlutil.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	var out []*unstructured.Unstructured
+	seen := make(map[string]bool)
+	for {
+		doc := map[string]interface{}{}
+		if err := decoder.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%w: %v", core.ErrInvalidManifest, err)
+		}
+		if len(doc) == 0 {
+			continue
+		}
+		obj := &unstructured.Unstructured{Object: doc}
+		if obj.GetAPIVersion() == "" || obj.GetKind() == "" || obj.GetName() == "" {
+			return nil, fmt.Errorf("%w: apiVersion, kind and metadata.name are required", core.ErrInvalidManifest)
+		}
+		if obj.IsList() {
+			return nil, fmt.Errorf("%w: %s is not supported, use multiple documents", core.ErrInvalidManifest, obj.GetKind())
+		}
+		key := objectKey(obj)
+		if seen[key] {
+			return nil, fmt.Errorf("%w: duplicate object %s", core.ErrInvalidManifest, key)
+		}
+		seen[key] = true
+		out = append(out, obj)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%w: no objects found", core.ErrInvalidManifest)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return kindOrder(out[i].GetKind()) < kindOrder(out[j].GetKind())
+	})
+	return out, nil
+}
+
+// definesKind 清单中是否有定义 gk 的 CRD
+func definesKind(objs []*unstructured.Unstructured, gk schema.GroupKind) bool {
+	for _, obj := range objs {
+		if obj.GetKind() != "CustomResourceDefinition" {
+			continue
+		}
+		group, _, _ := unstructured.NestedString(obj.Object, "spec", "group")
+		kind, _, _ := unstructured.NestedString(obj.Object, "spec", "names", "kind")
+		if group == gk.Group && kind == gk.Kind {
+			return true
+		}
+	}
+	return false
+}
+
+func kindOrder(kind string) int {
+	if order, ok := applyOrder[kind]; ok {
+		return order
+	}
+	return len(applyOrder)
+}
+
+func objectKey(obj *unstructured.Unstructured) string {
+	if obj.GetNamespace() == "" {
+		return obj.GetKind() + " " + obj.GetName()
+	}
+	return obj.GetKind() + " " + obj.GetNamespace() + "/" + obj.GetName()
+}
+
+// render 将对象转换为用于比较的 YAML, 去掉由服务端维护的字段, Secret 的内容只保留摘要
+func render(obj *unstructured.Unstructured) string {
+	out := obj.DeepCopy()
+	delete(out.Object, "status")
+	for _, field := range []string{"managedFields", "resourceVersion", "generation", "uid", "creationTimestamp", "selfLink"} {
+		unstructured.RemoveNestedField(out.Object, "metadata", field)
+	}
+	if annotations := out.GetAnnotations(); annotations != nil {
+		delete(annotations, "kubectl.kubernetes.io/last-applied-configuration")
+		if len(annotations) == 0 {
+			annotations = nil
+		}
+		out.SetAnnotations(annotations)
+	}
+	if out.GetAPIVersion() == "v1" && out.GetKind() == "Secret" {
+		for _, field := range []string{"data", "stringData"} {
+			values, ok := out.Object[field].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for k, v := range values {
+				values[k] = fmt.Sprintf("<redacted sha256:%s>", digest(fmt.Sprint(v))[:12])
+			}
+		}
+	}
+	data, err := yaml.Marshal(out.Object)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func diff(from, to string) string {
+	out, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(from),
+		B:        difflib.SplitLines(to),
+		FromFile: "live",
+		ToFile:   "planned",
+		Context:  3,
+	})
+	return out
+}
+
+func digest(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
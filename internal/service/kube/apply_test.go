@@ -0,0 +1,307 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const testManifest = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web
+data:
+  level: debug
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: payment
+`
+
+// fakePlanDao 内存中的 KubeApplyPlanDao
+type fakePlanDao struct {
+	items map[string]*core.KubeApplyPlan
+}
+
+func (f *fakePlanDao) Get(_ context.Context, id string) (*core.KubeApplyPlan, error) {
+	plan, ok := f.items[id]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	out := *plan
+	return &out, nil
+}
+
+func (f *fakePlanDao) Create(_ context.Context, in *core.KubeApplyPlan) error {
+	in.Status = core.KubeApplyPending
+	out := *in
+	f.items[in.ID] = &out
+	return nil
+}
+
+func (f *fakePlanDao) Claim(_ context.Context, id string) (bool, error) {
+	plan := f.items[id]
+	if plan.Status != core.KubeApplyPending {
+		return false, nil
+	}
+	plan.Status = core.KubeApplyApplying
+	return true, nil
+}
+
+func (f *fakePlanDao) Finish(_ context.Context, in *core.KubeApplyPlan) error {
+	out := *in
+	f.items[in.ID] = &out
+	return nil
+}
+
+// allowAll 允许所有操作的 Authorizer
+type allowAll struct{}
+
+func (allowAll) Authorize(context.Context, *core.User, string, string, string) error { return nil }
+
+func TestParseManifest(t *testing.T) {
+	objs, err := parseManifest([]byte(testManifest + "---\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 2 || objs[0].GetKind() != "Namespace" {
+		t.Errorf("objects = %v, want Namespace first", objs)
+	}
+	for _, bad := range []string{
+		"",
+		"kind: ConfigMap\nmetadata:\n  name: web\n",
+		testManifest + "---\n" + testManifest,
+		"apiVersion: v1\nkind: List\nmetadata:\n  name: x\nitems: []\n",
+		"apiVersion: v1\nkind: [",
+	} {
+		if _, err := parseManifest([]byte(bad)); !errors.Is(err, core.ErrInvalidManifest) {
+			t.Errorf("parseManifest(%q) error = %v", bad, err)
+		}
+	}
+}
+
+func TestRenderRedactsSecret(t *testing.T) {
+	secret := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata": map[string]interface{}{
+			"name":            "db",
+			"resourceVersion": "12",
+			"managedFields":   []interface{}{map[string]interface{}{"manager": "kubectl"}},
+		},
+		"data":   map[string]interface{}{"password": "c2VjcmV0"},
+		"status": map[string]interface{}{},
+	}}
+	out := render(secret)
+	if strings.Contains(out, "c2VjcmV0") || !strings.Contains(out, "<redacted sha256:") {
+		t.Errorf("secret data not redacted:\n%s", out)
+	}
+	if strings.Contains(out, "resourceVersion") || strings.Contains(out, "managedFields") || strings.Contains(out, "status") {
+		t.Errorf("server fields not removed:\n%s", out)
+	}
+	if secret.Object["data"].(map[string]interface{})["password"] != "c2VjcmV0" {
+		t.Error("render must not modify the object")
+	}
+}
+
+func TestApplyPlan(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	client.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*metav1.APIResourceList{{
+		GroupVersion: "v1",
+		APIResources: []metav1.APIResource{
+			{Name: "configmaps", Kind: "ConfigMap", Namespaced: true, Verbs: metav1.Verbs{"get", "patch"}},
+			{Name: "namespaces", Kind: "Namespace", Verbs: metav1.Verbs{"get", "patch"}},
+		},
+	}}
+	r, _ := newTestRegistry(t, client)
+	cluster, err := r.Create(ctx,
+		&core.KubeCluster{Name: "test", AuthType: core.KubeAuthKubeconfig},
+		&core.KubeCredential{Kubeconfig: testKubeconfig},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	existing := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": "web", "namespace": "default"},
+		"data":       map[string]interface{}{"level": "info"},
+	}}
+	dyn := fakedynamic.NewSimpleDynamicClient(runtime.NewScheme(), existing)
+	// fake client 不支持 server-side apply, 直接用提交的对象作为结果并且不保存
+	var patches int
+	dyn.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		obj := &unstructured.Unstructured{}
+		if err := json.Unmarshal(action.(k8stesting.PatchAction).GetPatch(), &obj.Object); err != nil {
+			return true, nil, err
+		}
+		patches++
+		return true, obj, nil
+	})
+	a := &Applier{
+		registry:   r,
+		plans:      &fakePlanDao{items: make(map[string]*core.KubeApplyPlan)},
+		authorizer: allowAll{},
+		ttl:        time.Minute,
+		newDynamic: func(*rest.Config) (dynamic.Interface, error) { return dyn, nil },
+	}
+
+	user := &core.User{ID: 1, UserName: "alice"}
+	if _, err := a.Plan(ctx, cluster.ID, []byte(testManifest), user); !errors.Is(err, core.ErrForbidden) {
+		t.Errorf("non-admin creating namespace: err = %v", err)
+	}
+	user.IsAdmin = true
+	plan, err := a.Plan(ctx, cluster.ID, []byte(testManifest), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Changes) != 2 || plan.Changes[0].Action != core.KubeChangeCreate || plan.Changes[1].Action != core.KubeChangeUpdate {
+		t.Fatalf("changes = %+v", plan.Changes)
+	}
+	if !strings.Contains(plan.Changes[1].Diff, "-  level: info") || !strings.Contains(plan.Changes[1].Diff, "+  level: debug") {
+		t.Errorf("diff = %s", plan.Changes[1].Diff)
+	}
+	if patches != 2 {
+		t.Fatalf("dry-run patches = %d, want 2", patches)
+	}
+
+	if _, err := a.Apply(ctx, plan.ID, &core.User{ID: 2, UserName: "bob"}); !errors.Is(err, core.ErrForbidden) {
+		t.Errorf("confirm by other user: err = %v", err)
+	}
+
+	// 生成计划之后集群中的对象被修改, 需要重新生成计划
+	existing.Object["data"] = map[string]interface{}{"level": "warn"}
+	gvr := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	if _, err := dyn.Resource(gvr).Namespace("default").Update(ctx, existing, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Apply(ctx, plan.ID, user); !errors.Is(err, ErrPlanStale) {
+		t.Fatalf("apply stale plan: err = %v", err)
+	}
+
+	plan, err = a.Plan(ctx, cluster.ID, []byte(testManifest), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := patches
+	plan, err = a.Apply(ctx, plan.ID, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Status != core.KubeApplyApplied || patches-before != 2 {
+		t.Errorf("status = %s, applied %d objects", plan.Status, patches-before)
+	}
+	if _, err := a.Apply(ctx, plan.ID, user); !errors.Is(err, ErrPlanNotPending) {
+		t.Errorf("apply twice: err = %v", err)
+	}
+}
+
+func TestApplyPlanSameManifest(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	client.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*metav1.APIResourceList{{
+		GroupVersion: "v1",
+		APIResources: []metav1.APIResource{
+			{Name: "configmaps", Kind: "ConfigMap", Namespaced: true, Verbs: metav1.Verbs{"get", "patch"}},
+			{Name: "namespaces", Kind: "Namespace", Verbs: metav1.Verbs{"get", "patch"}},
+		},
+	}, {
+		GroupVersion: "apiextensions.k8s.io/v1",
+		APIResources: []metav1.APIResource{
+			{Name: "customresourcedefinitions", Kind: "CustomResourceDefinition", Verbs: metav1.Verbs{"get", "patch"}},
+		},
+	}}
+	r, _ := newTestRegistry(t, client)
+	cluster, err := r.Create(ctx,
+		&core.KubeCluster{Name: "test", AuthType: core.KubeAuthKubeconfig},
+		&core.KubeCredential{Kubeconfig: testKubeconfig},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dyn := fakedynamic.NewSimpleDynamicClient(runtime.NewScheme())
+	// namespace 还不存在, namespace 中对象的 dry-run 会失败
+	var patches []string
+	dyn.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() == "payment" {
+			return true, nil, errors.New(`namespaces "payment" not found`)
+		}
+		obj := &unstructured.Unstructured{}
+		if err := json.Unmarshal(action.(k8stesting.PatchAction).GetPatch(), &obj.Object); err != nil {
+			return true, nil, err
+		}
+		patches = append(patches, action.GetResource().Resource)
+		return true, obj, nil
+	})
+	a := &Applier{
+		registry:   r,
+		plans:      &fakePlanDao{items: make(map[string]*core.KubeApplyPlan)},
+		authorizer: allowAll{},
+		ttl:        time.Minute,
+		newDynamic: func(*rest.Config) (dynamic.Interface, error) { return dyn, nil },
+	}
+	admin := &core.User{ID: 1, UserName: "alice", IsAdmin: true}
+
+	// 同一个清单中创建的 namespace 中的对象不 dry-run, 按清单的内容计划为创建
+	manifest := strings.Replace(testManifest, "name: web\n", "name: web\n  namespace: payment\n", 1)
+	plan, err := a.Plan(ctx, cluster.ID, []byte(manifest), admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Changes) != 2 || plan.Changes[1].Action != core.KubeChangeCreate ||
+		!strings.Contains(plan.Changes[1].Diff, "+  level: debug") {
+		t.Fatalf("changes = %+v", plan.Changes)
+	}
+	if len(patches) != 1 || patches[0] != "namespaces" {
+		t.Fatalf("dry-run patches = %v, want only the namespace", patches)
+	}
+
+	// 变更计划只有创建人或者管理员可以查看, 返回的 JSON 不包含清单
+	if _, err := a.Get(ctx, plan.ID, &core.User{ID: 2, UserName: "bob"}); !errors.Is(err, core.ErrForbidden) {
+		t.Errorf("get by other user: err = %v", err)
+	}
+	data, _ := json.Marshal(plan)
+	if strings.Contains(string(data), `"manifest"`) {
+		t.Errorf("plan JSON contains the manifest: %s", data)
+	}
+
+	// CR 的 CRD 在同一个清单中时明确拒绝
+	crd := `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+spec:
+  group: example.com
+  names:
+    kind: Widget
+    plural: widgets
+  scope: Namespaced
+---
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: w
+`
+	if _, err := a.Plan(ctx, cluster.ID, []byte(crd), admin); !errors.Is(err, core.ErrInvalidManifest) ||
+		!strings.Contains(err.Error(), "CustomResourceDefinition in the same manifest") {
+		t.Errorf("plan CR with CRD in the same manifest: err = %v", err)
+	}
+}
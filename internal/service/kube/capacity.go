@@ -0,0 +1,271 @@
+package kube
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// defaultPoolLabels 没有指定节点池标签时按顺序查找的常见节点池标签
+var defaultPoolLabels = []string{
+	"node.kubernetes.io/pool",
+	"eks.amazonaws.com/nodegroup",
+	"cloud.google.com/gke-nodepool",
+	"kubernetes.azure.com/agentpool",
+	"alibabacloud.com/nodepool-id",
+}
+
+// ResourceUsage 一组节点或者 Pod 的资源请求、限制和可分配量
+// CPU 单位为 millicore, 内存单位为字节; namespace 没有可分配量
+type ResourceUsage struct {
+	CPURequests       int64 `json:"cpu_requests"`
+	CPULimits         int64 `json:"cpu_limits"`
+	CPUAllocatable    int64 `json:"cpu_allocatable"`
+	MemoryRequests    int64 `json:"memory_requests"`
+	MemoryLimits      int64 `json:"memory_limits"`
+	MemoryAllocatable int64 `json:"memory_allocatable"`
+	Pods              int64 `json:"pods"`
+	PodsAllocatable   int64 `json:"pods_allocatable"`
+}
+
+// NodeCapacity 单个节点的容量
+type NodeCapacity struct {
+	Name string `json:"name"`
+	Pool string `json:"pool"`
+	ResourceUsage
+}
+
+// PoolCapacity 节点池的容量, 没有节点池标签的节点属于名称为空的节点池
+type PoolCapacity struct {
+	Pool  string `json:"pool"`
+	Nodes int    `json:"nodes"`
+	ResourceUsage
+}
+
+// NamespaceCapacity namespace 中所有 Pod 的资源请求和限制, 包括还没有调度的 Pod
+type NamespaceCapacity struct {
+	Namespace string `json:"namespace"`
+	ResourceUsage
+}
+
+// CapacityReport 集群的容量报告, Total 只统计已经调度到节点上的 Pod
+type CapacityReport struct {
+	ClusterID   int64                `json:"cluster_id"`
+	ClusterName string               `json:"cluster_name"`
+	PoolLabel   string               `json:"pool_label"`
+	Error       string               `json:"error,omitempty"`
+	Total       ResourceUsage        `json:"total"`
+	Pools       []*PoolCapacity      `json:"pools"`
+	Nodes       []*NodeCapacity      `json:"nodes"`
+	Namespaces  []*NamespaceCapacity `json:"namespaces"`
+}
+
+// Capacity 从 informer 缓存中统计集群的容量, 已经结束的 Pod 不计入
+// poolLabel 为空时使用 defaultPoolLabels 中第一个在节点上存在的标签
+func (w *Workloads) Capacity(ctx context.Context, clusterID int64, poolLabel string) (*CapacityReport, error) {
+	c, err := w.cache(ctx, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := c.nodes.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	pods, err := c.pods.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	return capacityReport(clusterID, poolLabel, nodes, pods), nil
+}
+
+// CapacityReports 依次统计多个集群的容量, 单个集群失败时在报告的 Error 中记录原因
+func (w *Workloads) CapacityReports(ctx context.Context, clusters []*core.KubeCluster, poolLabel string) []*CapacityReport {
+	reports := make([]*CapacityReport, 0, len(clusters))
+	for _, cluster := range clusters {
+		report, err := w.Capacity(ctx, cluster.ID, poolLabel)
+		if err != nil {
+			report = &CapacityReport{ClusterID: cluster.ID, PoolLabel: poolLabel, Error: err.Error()}
+		}
+		report.ClusterName = cluster.Name
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+func capacityReport(clusterID int64, poolLabel string, nodes []*corev1.Node, pods []*corev1.Pod) *CapacityReport {
+	if poolLabel == "" {
+		poolLabel = detectPoolLabel(nodes)
+	}
+	report := &CapacityReport{ClusterID: clusterID, PoolLabel: poolLabel}
+	byNode := make(map[string]*NodeCapacity, len(nodes))
+	for _, node := range nodes {
+		n := &NodeCapacity{Name: node.Name, Pool: node.Labels[poolLabel]}
+		allocatable := node.Status.Allocatable
+		n.CPUAllocatable = allocatable.Cpu().MilliValue()
+		n.MemoryAllocatable = allocatable.Memory().Value()
+		n.PodsAllocatable = allocatable.Pods().Value()
+		byNode[node.Name] = n
+		report.Nodes = append(report.Nodes, n)
+	}
+	byNamespace := make(map[string]*NamespaceCapacity)
+	for _, pod := range pods {
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		requests, limits := podRequestsAndLimits(pod)
+		ns, ok := byNamespace[pod.Namespace]
+		if !ok {
+			ns = &NamespaceCapacity{Namespace: pod.Namespace}
+			byNamespace[pod.Namespace] = ns
+			report.Namespaces = append(report.Namespaces, ns)
+		}
+		ns.addPod(requests, limits)
+		if n, ok := byNode[pod.Spec.NodeName]; ok {
+			n.addPod(requests, limits)
+		}
+	}
+
+	byPool := make(map[string]*PoolCapacity)
+	for _, n := range report.Nodes {
+		pool, ok := byPool[n.Pool]
+		if !ok {
+			pool = &PoolCapacity{Pool: n.Pool}
+			byPool[n.Pool] = pool
+			report.Pools = append(report.Pools, pool)
+		}
+		pool.Nodes++
+		pool.add(&n.ResourceUsage)
+		report.Total.add(&n.ResourceUsage)
+	}
+	sort.Slice(report.Nodes, func(i, j int) bool { return report.Nodes[i].Name < report.Nodes[j].Name })
+	sort.Slice(report.Pools, func(i, j int) bool { return report.Pools[i].Pool < report.Pools[j].Pool })
+	sort.Slice(report.Namespaces, func(i, j int) bool { return report.Namespaces[i].Namespace < report.Namespaces[j].Namespace })
+	return report
+}
+
+func (u *ResourceUsage) addPod(requests, limits corev1.ResourceList) {
+	u.CPURequests += requests.Cpu().MilliValue()
+	u.CPULimits += limits.Cpu().MilliValue()
+	u.MemoryRequests += requests.Memory().Value()
+	u.MemoryLimits += limits.Memory().Value()
+	u.Pods++
+}
+
+func (u *ResourceUsage) add(other *ResourceUsage) {
+	u.CPURequests += other.CPURequests
+	u.CPULimits += other.CPULimits
+	u.CPUAllocatable += other.CPUAllocatable
+	u.MemoryRequests += other.MemoryRequests
+	u.MemoryLimits += other.MemoryLimits
+	u.MemoryAllocatable += other.MemoryAllocatable
+	u.Pods += other.Pods
+	u.PodsAllocatable += other.PodsAllocatable
+}
+
+func detectPoolLabel(nodes []*corev1.Node) string {
+	for _, label := range defaultPoolLabels {
+		for _, node := range nodes {
+			if _, ok := node.Labels[label]; ok {
+				return label
+			}
+		}
+	}
+	return ""
+}
+
+// podRequestsAndLimits 与调度器的计算方式一致: 普通容器之和与单个 init 容器取较大值, 再加上 Pod overhead
+func podRequestsAndLimits(pod *corev1.Pod) (corev1.ResourceList, corev1.ResourceList) {
+	requests, limits := corev1.ResourceList{}, corev1.ResourceList{}
+	for _, container := range pod.Spec.Containers {
+		addResources(requests, container.Resources.Requests)
+		addResources(limits, container.Resources.Limits)
+	}
+	for _, container := range pod.Spec.InitContainers {
+		maxResources(requests, container.Resources.Requests)
+		maxResources(limits, container.Resources.Limits)
+	}
+	addResources(requests, pod.Spec.Overhead)
+	// overhead 只加到已经设置了 limit 的资源上, 与 kubectl describe node 一致
+	for name, quantity := range pod.Spec.Overhead {
+		if current, ok := limits[name]; ok {
+			current.Add(quantity)
+			limits[name] = current
+		}
+	}
+	return requests, limits
+}
+
+func addResources(list, add corev1.ResourceList) {
+	for name, quantity := range add {
+		if current, ok := list[name]; ok {
+			current.Add(quantity)
+			list[name] = current
+		} else {
+			list[name] = quantity.DeepCopy()
+		}
+	}
+}
+
+func maxResources(list, other corev1.ResourceList) {
+	for name, quantity := range other {
+		if current, ok := list[name]; !ok || quantity.Cmp(current) > 0 {
+			list[name] = quantity.DeepCopy()
+		}
+	}
+}
+
+// capacityHeader CSV 的表头, CPU 单位为 millicore, 内存单位为字节
+var capacityHeader = []string{
+	"cluster", "scope", "name", "pool",
+	"cpu_requests_m", "cpu_limits_m", "cpu_allocatable_m",
+	"memory_requests_bytes", "memory_limits_bytes", "memory_allocatable_bytes",
+	"pods", "pods_allocatable",
+}
+
+// WriteCapacityCSV 将容量报告按集群、节点池、节点、namespace 逐行写为 CSV, namespace 的可分配量为空
+func WriteCapacityCSV(out io.Writer, reports []*CapacityReport) error {
+	writer := csv.NewWriter(out)
+	if err := writer.Write(capacityHeader); err != nil {
+		return err
+	}
+	for _, report := range reports {
+		if report.Error != "" {
+			continue
+		}
+		rows := [][]string{usageRow(report.ClusterName, "cluster", report.ClusterName, "", &report.Total, true)}
+		for _, pool := range report.Pools {
+			rows = append(rows, usageRow(report.ClusterName, "pool", pool.Pool, pool.Pool, &pool.ResourceUsage, true))
+		}
+		for _, node := range report.Nodes {
+			rows = append(rows, usageRow(report.ClusterName, "node", node.Name, node.Pool, &node.ResourceUsage, true))
+		}
+		for _, ns := range report.Namespaces {
+			rows = append(rows, usageRow(report.ClusterName, "namespace", ns.Namespace, "", &ns.ResourceUsage, false))
+		}
+		if err := writer.WriteAll(rows); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func usageRow(cluster, scope, name, pool string, u *ResourceUsage, allocatable bool) []string {
+	format := func(v int64) string { return strconv.FormatInt(v, 10) }
+	row := []string{
+		cluster, scope, name, pool,
+		format(u.CPURequests), format(u.CPULimits), format(u.CPUAllocatable),
+		format(u.MemoryRequests), format(u.MemoryLimits), format(u.MemoryAllocatable),
+		format(u.Pods), format(u.PodsAllocatable),
+	}
+	if !allocatable {
+		row[6], row[9], row[11] = "", "", ""
+	}
+	return row
+}
@@ -0,0 +1,83 @@
+package kube
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func capacityNode(name, pool string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"eks.amazonaws.com/nodegroup": pool}},
+		Status: corev1.NodeStatus{Allocatable: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("4"),
+			corev1.ResourceMemory: resource.MustParse("8Gi"),
+			corev1.ResourcePods:   resource.MustParse("110"),
+		}},
+	}
+}
+
+func capacityPod(namespace, node string, phase corev1.PodPhase, cpu, memory string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace},
+		Spec: corev1.PodSpec{
+			NodeName: node,
+			Containers: []corev1.Container{{Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu), corev1.ResourceMemory: resource.MustParse(memory)},
+				Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu)},
+			}}},
+		},
+		Status: corev1.PodStatus{Phase: phase},
+	}
+}
+
+func TestCapacityReport(t *testing.T) {
+	nodes := []*corev1.Node{capacityNode("node-2", "general"), capacityNode("node-1", "general"), capacityNode("gpu-1", "gpu")}
+	withInit := capacityPod("payment", "node-1", corev1.PodRunning, "500m", "256Mi")
+	// init 容器的请求大于普通容器之和时按 init 容器计算
+	withInit.Spec.InitContainers = []corev1.Container{{Resources: corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+	}}}
+	withInit.Spec.Overhead = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}
+	pods := []*corev1.Pod{
+		withInit,
+		capacityPod("payment", "node-2", corev1.PodRunning, "250m", "512Mi"),
+		capacityPod("payment", "", corev1.PodPending, "2", "1Gi"),
+		capacityPod("batch", "node-2", corev1.PodSucceeded, "3", "1Gi"),
+	}
+	report := capacityReport(1, "", nodes, pods)
+	if report.PoolLabel != "eks.amazonaws.com/nodegroup" || len(report.Pools) != 2 || report.Pools[0].Pool != "general" ||
+		report.Pools[0].Nodes != 2 {
+		t.Fatalf("pools = %+v", report.Pools)
+	}
+	if report.Nodes[1].Name != "node-1" || report.Nodes[1].CPURequests != 1100 || report.Nodes[1].CPULimits != 600 {
+		t.Errorf("node-1 = %+v", report.Nodes[1])
+	}
+	total := report.Total
+	if total.CPURequests != 1350 || total.CPUAllocatable != 12000 || total.Pods != 2 || total.PodsAllocatable != 330 ||
+		total.MemoryRequests != 768<<20 {
+		t.Errorf("total = %+v", total)
+	}
+	// namespace 包括未调度的 Pod, 不包括已经结束的 Pod
+	if len(report.Namespaces) != 1 || report.Namespaces[0].Pods != 3 || report.Namespaces[0].CPURequests != 3350 {
+		t.Errorf("namespaces = %+v", report.Namespaces)
+	}
+
+	report.ClusterName = "prod"
+	var buf bytes.Buffer
+	if err := WriteCapacityCSV(&buf, []*CapacityReport{report, {ClusterName: "down", Error: "unreachable"}}); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 表头 + 集群 + 2 个节点池 + 3 个节点 + 1 个 namespace
+	if len(rows) != 8 || rows[1][1] != "cluster" || rows[1][4] != "1350" || rows[7][1] != "namespace" || rows[7][6] != "" {
+		t.Errorf("csv rows = %v", rows)
+	}
+}
@@ -0,0 +1,179 @@
+package kube
+
+import (
+	"context"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// eventWriteTimeout 保存单个事件的超时时间
+	eventWriteTimeout = 10 * time.Second
+	// eventPruneInterval 清理过期事件的间隔
+	eventPruneInterval = time.Hour
+)
+
+// EventWatcher 为每个集群启动一个 Warning 事件的 informer, 将事件保存到数据库中
+// 集群的增删和认证信息的变化在下一次检查时生效, 检查间隔与集群健康检查相同
+type EventWatcher struct {
+	registry  *Registry
+	clusters  core.KubeClusterDao
+	events    core.KubeEventDao
+	interval  time.Duration
+	retention time.Duration
+
+	// watches 只在 Run 所在的 goroutine 中访问
+	watches map[int64]*eventWatch
+}
+
+// eventWatch 单个集群的事件 informer, 集群的客户端变化后重新创建
+type eventWatch struct {
+	client kubernetes.Interface
+	stop   chan struct{}
+}
+
+// ProvideEventWatcher is a Wire provider
+func ProvideEventWatcher(registry *Registry, clusters core.KubeClusterDao, events core.KubeEventDao, cfg *config.Config) *EventWatcher {
+	return &EventWatcher{
+		registry:  registry,
+		clusters:  clusters,
+		events:    events,
+		interval:  cfg.Kubernetes.ProbeInterval * time.Second,
+		retention: cfg.Kubernetes.EventRetention * time.Hour,
+		watches:   make(map[int64]*eventWatch),
+	}
+}
+
+// Run 维护所有集群的事件 informer 并定期清理过期的事件, 直到 ctx 结束
+func (w *EventWatcher) Run(ctx context.Context) error {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	prune := time.NewTicker(eventPruneInterval)
+	defer prune.Stop()
+	defer func() {
+		for id, watch := range w.watches {
+			close(watch.stop)
+			delete(w.watches, id)
+		}
+	}()
+	w.reconcile(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			w.reconcile(ctx)
+		case <-prune.C:
+			n, err := w.events.Prune(ctx, time.Now().Add(-w.retention))
+			if err != nil {
+				logger.WithLabels("error", err).Error("cannot prune kubernetes events")
+			} else if n > 0 {
+				logger.WithLabels("count", n).Debug("kubernetes events pruned")
+			}
+		}
+	}
+}
+
+// reconcile 为新增的集群启动 informer, 停止已经删除的集群的 informer
+func (w *EventWatcher) reconcile(ctx context.Context) {
+	clusters, err := w.clusters.List(ctx, map[string]interface{}{})
+	if err != nil {
+		logger.WithLabels("error", err).Error("cannot list clusters")
+		return
+	}
+	active := make(map[int64]bool, len(clusters))
+	for _, cluster := range clusters {
+		active[cluster.ID] = true
+		client, err := w.registry.Client(ctx, cluster.ID)
+		if err != nil {
+			logger.WithLabels("cluster", cluster.Name, "error", err).Warn("cannot create cluster client")
+			continue
+		}
+		if watch, ok := w.watches[cluster.ID]; ok {
+			if watch.client == client {
+				continue
+			}
+			close(watch.stop)
+		}
+		w.watches[cluster.ID] = w.watch(ctx, cluster.ID, client)
+	}
+	for id, watch := range w.watches {
+		if !active[id] {
+			close(watch.stop)
+			delete(w.watches, id)
+		}
+	}
+}
+
+// watch 启动集群的 Warning 事件 informer, informer 首次同步时会保存集群中现有的事件
+func (w *EventWatcher) watch(ctx context.Context, clusterID int64, client kubernetes.Interface) *eventWatch {
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0,
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("type", corev1.EventTypeWarning).String()
+		}),
+	)
+	save := func(obj interface{}) {
+		event, ok := obj.(*corev1.Event)
+		if !ok || event.Type != corev1.EventTypeWarning {
+			return
+		}
+		ctx, cancel := context.WithTimeout(ctx, eventWriteTimeout)
+		defer cancel()
+		if err := w.events.Upsert(ctx, eventRecord(clusterID, event)); err != nil {
+			logger.WithLabels("cluster_id", clusterID, "reason", event.Reason, "error", err).Error("cannot save kubernetes event")
+		}
+	}
+	_, _ = factory.Core().V1().Events().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    save,
+		UpdateFunc: func(_, obj interface{}) { save(obj) },
+	})
+	watch := &eventWatch{client: client, stop: make(chan struct{})}
+	factory.Start(watch.stop)
+	return watch
+}
+
+// eventRecord 将事件转换为数据库记录, 兼容 events.k8s.io 的 series 和旧版本的 count/timestamp 字段
+func eventRecord(clusterID int64, event *corev1.Event) *core.KubeEvent {
+	first := event.FirstTimestamp.Time
+	if first.IsZero() {
+		first = event.EventTime.Time
+	}
+	if first.IsZero() {
+		first = event.CreationTimestamp.Time
+	}
+	last, count := event.LastTimestamp.Time, event.Count
+	if event.Series != nil {
+		last, count = event.Series.LastObservedTime.Time, event.Series.Count
+	}
+	if last.IsZero() {
+		last = first
+	}
+	if count == 0 {
+		count = 1
+	}
+	source := event.Source.Component
+	if source == "" {
+		source = event.ReportingController
+	}
+	return &core.KubeEvent{
+		ClusterID: clusterID,
+		UID:       string(event.UID),
+		Namespace: event.InvolvedObject.Namespace,
+		Kind:      event.InvolvedObject.Kind,
+		Name:      event.InvolvedObject.Name,
+		Reason:    event.Reason,
+		Message:   event.Message,
+		Source:    source,
+		Count:     count,
+		FirstSeen: first,
+		LastSeen:  last,
+	}
+}
@@ -0,0 +1,118 @@
+package kube
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeEventDao 内存中的 KubeEventDao
+type fakeEventDao struct {
+	core.KubeEventDao
+	mu    sync.Mutex
+	items map[string]*core.KubeEvent
+}
+
+func (f *fakeEventDao) Upsert(_ context.Context, in *core.KubeEvent) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.items[in.UID] = in
+	return nil
+}
+
+func (f *fakeEventDao) get(uid string) *core.KubeEvent {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.items[uid]
+}
+
+func TestEventRecord(t *testing.T) {
+	first := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	last := first.Add(time.Hour)
+	event := &corev1.Event{
+		ObjectMeta:          metav1.ObjectMeta{UID: "e1", Namespace: "default"},
+		InvolvedObject:      corev1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "web-1"},
+		Reason:              "BackOff",
+		Type:                corev1.EventTypeWarning,
+		EventTime:           metav1.MicroTime{Time: first},
+		Series:              &corev1.EventSeries{Count: 12, LastObservedTime: metav1.MicroTime{Time: last}},
+		ReportingController: "kubelet",
+	}
+	record := eventRecord(3, event)
+	if record.ClusterID != 3 || record.Count != 12 || !record.FirstSeen.Equal(first) || !record.LastSeen.Equal(last) ||
+		record.Source != "kubelet" || record.Kind != "Pod" || record.Name != "web-1" {
+		t.Errorf("record = %+v", record)
+	}
+
+	// 旧版本的事件只有 firstTimestamp, count 为 0 时按 1 次计算
+	record = eventRecord(3, &corev1.Event{FirstTimestamp: metav1.Time{Time: first}})
+	if record.Count != 1 || !record.LastSeen.Equal(first) {
+		t.Errorf("legacy record = %+v", record)
+	}
+}
+
+func TestEventWatcher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := fake.NewSimpleClientset(
+		&corev1.Event{
+			ObjectMeta: metav1.ObjectMeta{Name: "w", Namespace: "default", UID: "warning"},
+			Type:       corev1.EventTypeWarning,
+			Reason:     "FailedScheduling",
+		},
+		&corev1.Event{
+			ObjectMeta: metav1.ObjectMeta{Name: "n", Namespace: "default", UID: "normal"},
+			Type:       corev1.EventTypeNormal,
+			Reason:     "Scheduled",
+		},
+	)
+	r, clusters := newTestRegistry(t, client)
+	cluster, err := r.Create(ctx,
+		&core.KubeCluster{Name: "test", AuthType: core.KubeAuthKubeconfig},
+		&core.KubeCredential{Kubeconfig: testKubeconfig},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := &fakeEventDao{items: make(map[string]*core.KubeEvent)}
+	w := &EventWatcher{registry: r, clusters: clusters, events: events, watches: make(map[int64]*eventWatch)}
+	w.reconcile(ctx)
+
+	_, err = client.CoreV1().Events("default").Create(ctx, &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{Name: "w2", Namespace: "default", UID: "warning-2"},
+		Type:       corev1.EventTypeWarning,
+		Reason:     "BackOff",
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for events.get("warning") == nil || events.get("warning-2") == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("warning events not saved")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if events.get("normal") != nil {
+		t.Error("normal event should not be saved")
+	}
+
+	// 集群删除后停止 informer
+	delete(clusters.items, cluster.ID)
+	watch := w.watches[cluster.ID]
+	w.reconcile(ctx)
+	if _, ok := w.watches[cluster.ID]; ok {
+		t.Error("watch of deleted cluster not stopped")
+	}
+	select {
+	case <-watch.stop:
+	default:
+		t.Error("stop channel not closed")
+	}
+}
@@ -0,0 +1,379 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+)
+
+// easynetes 写入 namespace 的标签和注解, AnnotationOwned 记录上次由 easynetes 写入的标签
+const (
+	LabelManaged     = "easynetes.io/managed"
+	LabelServiceNode = "easynetes.io/service-node"
+	LabelTeam        = "easynetes.io/team"
+	AnnotationOwned  = "easynetes.io/owned-labels"
+)
+
+const (
+	labelPrefix       = "easynetes.io/"
+	managedLabelValue = "true"
+	quotaName         = "easynetes-quota"
+	limitRangeName    = "easynetes-limits"
+)
+
+// ErrNamespaceExists 集群中已经存在同名的 namespace
+var ErrNamespaceExists = errors.New("namespace already exists in cluster")
+
+// NamespaceInfo 集群中的 namespace, Binding 为空表示不是 easynetes 创建的
+type NamespaceInfo struct {
+	Name       string              `json:"name"`
+	Phase      string              `json:"phase"`
+	Labels     map[string]string   `json:"labels"`
+	CreateTime time.Time           `json:"create_time"`
+	Binding    *core.KubeNamespace `json:"binding"`
+}
+
+// Namespaces 管理集群中由 easynetes 创建的 namespace, 并定期将 easynetes 的标签同步到集群中
+type Namespaces struct {
+	registry   *Registry
+	namespaces core.KubeNamespaceDao
+	templates  core.KubeQuotaTemplateDao
+	interval   time.Duration
+}
+
+// ProvideNamespaces is a Wire provider
+func ProvideNamespaces(registry *Registry, namespaces core.KubeNamespaceDao, templates core.KubeQuotaTemplateDao, cfg *config.Config) *Namespaces {
+	return &Namespaces{
+		registry:   registry,
+		namespaces: namespaces,
+		templates:  templates,
+		interval:   cfg.Kubernetes.SyncInterval * time.Second,
+	}
+}
+
+// List 返回集群中所有的 namespace, 以及 easynetes 中的绑定关系
+func (n *Namespaces) List(ctx context.Context, clusterID int64) ([]*NamespaceInfo, error) {
+	client, err := n.registry.Client(ctx, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	live, err := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	records, err := n.namespaces.List(ctx, map[string]interface{}{"cluster_id": clusterID})
+	if err != nil {
+		return nil, err
+	}
+	bindings := make(map[string]*core.KubeNamespace, len(records))
+	for _, record := range records {
+		bindings[record.Name] = record
+	}
+	out := make([]*NamespaceInfo, 0, len(live.Items))
+	for _, item := range live.Items {
+		out = append(out, &NamespaceInfo{
+			Name:       item.Name,
+			Phase:      string(item.Status.Phase),
+			Labels:     item.Labels,
+			CreateTime: item.CreationTimestamp.Time,
+			Binding:    bindings[item.Name],
+		})
+	}
+	return out, nil
+}
+
+// Create 在集群中创建 namespace, 应用配额模板并保存绑定关系
+func (n *Namespaces) Create(ctx context.Context, ns *core.KubeNamespace) (*core.KubeNamespace, error) {
+	if err := validateNamespace(ns); err != nil {
+		return nil, err
+	}
+	var template *core.KubeQuotaTemplate
+	if ns.QuotaTemplateID != 0 {
+		var err error
+		if template, err = n.templates.Get(ctx, ns.QuotaTemplateID); err != nil {
+			return nil, err
+		}
+		if err := ValidateQuotaTemplate(template); err != nil {
+			return nil, err
+		}
+	}
+	client, err := n.registry.Client(ctx, ns.ClusterID)
+	if err != nil {
+		return nil, err
+	}
+	labels := desiredLabels(ns)
+	obj := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        ns.Name,
+			Labels:      labels,
+			Annotations: map[string]string{AnnotationOwned: ownedKeys(labels)},
+		},
+	}
+	if _, err := client.CoreV1().Namespaces().Create(ctx, obj, metav1.CreateOptions{}); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			return nil, ErrNamespaceExists
+		}
+		return nil, err
+	}
+	if err := n.finishCreate(ctx, client, ns, template); err != nil {
+		// 后续步骤失败时删除刚创建的 namespace, 避免集群中留下没有绑定关系的 namespace, 重试时不会因为已经存在而失败
+		derr := client.CoreV1().Namespaces().Delete(context.WithoutCancel(ctx), ns.Name, metav1.DeleteOptions{})
+		if derr != nil && !apierrors.IsNotFound(derr) {
+			logger.WithLabels("cluster_id", ns.ClusterID, "namespace", ns.Name, "error", derr).
+				Error("cannot delete namespace after failed creation")
+		}
+		return nil, err
+	}
+	return ns, nil
+}
+
+// finishCreate 在新建的 namespace 中创建配额和默认限制, 然后保存绑定关系
+func (n *Namespaces) finishCreate(ctx context.Context, client kubernetes.Interface, ns *core.KubeNamespace,
+	template *core.KubeQuotaTemplate) error {
+	if template != nil {
+		if quota := resourceQuota(template); quota != nil {
+			if _, err := client.CoreV1().ResourceQuotas(ns.Name).Create(ctx, quota, metav1.CreateOptions{}); err != nil {
+				return fmt.Errorf("cannot create resource quota: %w", err)
+			}
+		}
+		if limits := limitRange(template); limits != nil {
+			if _, err := client.CoreV1().LimitRanges(ns.Name).Create(ctx, limits, metav1.CreateOptions{}); err != nil {
+				return fmt.Errorf("cannot create limit range: %w", err)
+			}
+		}
+	}
+	_, err := n.namespaces.Create(ctx, ns)
+	return err
+}
+
+// Update 更新 namespace 的绑定关系和标签, 并立即同步到集群中
+func (n *Namespaces) Update(ctx context.Context, ns *core.KubeNamespace) (*core.KubeNamespace, error) {
+	old, err := n.namespaces.Get(ctx, ns.ClusterID, ns.Name)
+	if err != nil {
+		return nil, err
+	}
+	old.ServiceNodeID = ns.ServiceNodeID
+	old.Team = ns.Team
+	old.Labels = ns.Labels
+	if err := validateNamespace(old); err != nil {
+		return nil, err
+	}
+	if err := n.namespaces.Update(ctx, old); err != nil {
+		return nil, err
+	}
+	if err := n.Reconcile(ctx, old); err != nil {
+		return nil, err
+	}
+	return old, nil
+}
+
+// Delete 删除集群中的 namespace 以及绑定关系, 只能删除 easynetes 创建的 namespace
+func (n *Namespaces) Delete(ctx context.Context, clusterID int64, name string) error {
+	record, err := n.namespaces.Get(ctx, clusterID, name)
+	if err != nil {
+		return err
+	}
+	client, err := n.registry.Client(ctx, clusterID)
+	if err != nil {
+		return err
+	}
+	err = client.CoreV1().Namespaces().Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return n.namespaces.Delete(ctx, record.ID)
+}
+
+// Reconcile 将 easynetes 的标签同步到集群中的 namespace, 并删除不再需要的 easynetes 标签
+func (n *Namespaces) Reconcile(ctx context.Context, ns *core.KubeNamespace) error {
+	client, err := n.registry.Client(ctx, ns.ClusterID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	ns.SyncTime = &now
+	live, err := client.CoreV1().Namespaces().Get(ctx, ns.Name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		ns.Status = core.KubeNamespaceMissing
+		return n.namespaces.UpdateStatus(ctx, ns)
+	case err != nil:
+		return err
+	}
+	if patch := labelPatch(live, desiredLabels(ns)); patch != nil {
+		data, err := json.Marshal(patch)
+		if err != nil {
+			return err
+		}
+		if _, err := client.CoreV1().Namespaces().Patch(ctx, ns.Name, types.MergePatchType, data, metav1.PatchOptions{}); err != nil {
+			return err
+		}
+		logger.WithLabels("cluster_id", ns.ClusterID, "namespace", ns.Name).Info("namespace labels reconciled")
+	}
+	ns.Status = core.KubeNamespaceActive
+	return n.namespaces.UpdateStatus(ctx, ns)
+}
+
+// Run 定期同步所有纳管的 namespace, 直到 ctx 结束
+func (n *Namespaces) Run(ctx context.Context) error {
+	ticker := time.NewTicker(n.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			records, err := n.namespaces.List(ctx, map[string]interface{}{})
+			if err != nil {
+				logger.WithLabels("error", err).Error("cannot list namespaces")
+				continue
+			}
+			for _, ns := range records {
+				if err := n.Reconcile(ctx, ns); err != nil {
+					logger.WithLabels("cluster_id", ns.ClusterID, "namespace", ns.Name, "error", err).Warn("cannot reconcile namespace")
+				}
+			}
+		}
+	}
+}
+
+// desiredLabels 返回 easynetes 期望 namespace 拥有的标签
+func desiredLabels(ns *core.KubeNamespace) map[string]string {
+	out := make(map[string]string, len(ns.Labels)+3)
+	for k, v := range ns.Labels {
+		out[k] = v
+	}
+	out[LabelManaged] = managedLabelValue
+	if ns.ServiceNodeID != 0 {
+		out[LabelServiceNode] = strconv.FormatInt(ns.ServiceNodeID, 10)
+	}
+	if ns.Team != "" {
+		out[LabelTeam] = ns.Team
+	}
+	return out
+}
+
+// labelPatch 生成 merge patch: 设置期望的标签, 删除上次由 easynetes 写入但是不再需要的标签
+// 不是由 easynetes 写入的标签保持不变; 没有变化时返回 nil
+func labelPatch(live *corev1.Namespace, desired map[string]string) map[string]interface{} {
+	labels := map[string]interface{}{}
+	for k, v := range desired {
+		if cur, ok := live.Labels[k]; !ok || cur != v {
+			labels[k] = v
+		}
+	}
+	for _, k := range strings.Split(live.Annotations[AnnotationOwned], ",") {
+		if _, ok := desired[k]; k != "" && !ok {
+			if _, exists := live.Labels[k]; exists {
+				labels[k] = nil
+			}
+		}
+	}
+	owned := ownedKeys(desired)
+	if len(labels) == 0 && live.Annotations[AnnotationOwned] == owned {
+		return nil
+	}
+	metadata := map[string]interface{}{
+		"annotations": map[string]interface{}{AnnotationOwned: owned},
+	}
+	if len(labels) > 0 {
+		metadata["labels"] = labels
+	}
+	return map[string]interface{}{"metadata": metadata}
+}
+
+func ownedKeys(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+func validateNamespace(ns *core.KubeNamespace) error {
+	if errs := validation.IsDNS1123Label(ns.Name); len(errs) > 0 {
+		return fmt.Errorf("%w: %s", core.ErrInvalidKubeNamespace, strings.Join(errs, "; "))
+	}
+	if errs := validation.IsValidLabelValue(ns.Team); len(errs) > 0 {
+		return fmt.Errorf("%w: team: %s", core.ErrInvalidKubeNamespace, strings.Join(errs, "; "))
+	}
+	for k, v := range ns.Labels {
+		if strings.HasPrefix(k, labelPrefix) {
+			return fmt.Errorf("%w: label %s is reserved", core.ErrInvalidKubeNamespace, k)
+		}
+		errs := append(validation.IsQualifiedName(k), validation.IsValidLabelValue(v)...)
+		if len(errs) > 0 {
+			return fmt.Errorf("%w: label %s: %s", core.ErrInvalidKubeNamespace, k, strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+// ValidateQuotaTemplate 校验配额模板中的资源数量
+func ValidateQuotaTemplate(t *core.KubeQuotaTemplate) error {
+	for _, list := range []core.StringMap{t.Hard, t.DefaultLimit, t.DefaultRequest, t.Max, t.Min} {
+		if _, err := resourceList(list); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func resourceList(in core.StringMap) (corev1.ResourceList, error) {
+	if len(in) == 0 {
+		return nil, nil
+	}
+	out := make(corev1.ResourceList, len(in))
+	for name, value := range in {
+		q, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", core.ErrInvalidQuotaTemplate, name, err)
+		}
+		out[corev1.ResourceName(name)] = q
+	}
+	return out, nil
+}
+
+// resourceQuota 根据模板生成 ResourceQuota, 模板中没有配置时返回 nil
+func resourceQuota(t *core.KubeQuotaTemplate) *corev1.ResourceQuota {
+	hard, _ := resourceList(t.Hard)
+	if len(hard) == 0 {
+		return nil
+	}
+	return &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: quotaName, Labels: map[string]string{LabelManaged: managedLabelValue}},
+		Spec:       corev1.ResourceQuotaSpec{Hard: hard},
+	}
+}
+
+// limitRange 根据模板生成 Container 类型的 LimitRange, 模板中没有配置时返回 nil
+func limitRange(t *core.KubeQuotaTemplate) *corev1.LimitRange {
+	item := corev1.LimitRangeItem{Type: corev1.LimitTypeContainer}
+	item.Default, _ = resourceList(t.DefaultLimit)
+	item.DefaultRequest, _ = resourceList(t.DefaultRequest)
+	item.Max, _ = resourceList(t.Max)
+	item.Min, _ = resourceList(t.Min)
+	if len(item.Default)+len(item.DefaultRequest)+len(item.Max)+len(item.Min) == 0 {
+		return nil
+	}
+	return &corev1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{Name: limitRangeName, Labels: map[string]string{LabelManaged: managedLabelValue}},
+		Spec:       corev1.LimitRangeSpec{Limits: []corev1.LimitRangeItem{item}},
+	}
+}
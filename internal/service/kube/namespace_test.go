@@ -0,0 +1,191 @@
+package kube
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// fakeNamespaceDao 内存中的 KubeNamespaceDao
+type fakeNamespaceDao struct {
+	core.KubeNamespaceDao
+	items map[string]*core.KubeNamespace
+}
+
+func (f *fakeNamespaceDao) Get(_ context.Context, _ int64, name string) (*core.KubeNamespace, error) {
+	ns, ok := f.items[name]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	out := *ns
+	return &out, nil
+}
+
+func (f *fakeNamespaceDao) Create(_ context.Context, in *core.KubeNamespace) (int64, error) {
+	in.ID = int64(len(f.items) + 1)
+	out := *in
+	f.items[in.Name] = &out
+	return in.ID, nil
+}
+
+func (f *fakeNamespaceDao) Update(_ context.Context, in *core.KubeNamespace) error {
+	out := *in
+	f.items[in.Name] = &out
+	return nil
+}
+
+func (f *fakeNamespaceDao) UpdateStatus(ctx context.Context, in *core.KubeNamespace) error {
+	return f.Update(ctx, in)
+}
+
+type fakeTemplateDao struct {
+	core.KubeQuotaTemplateDao
+	template *core.KubeQuotaTemplate
+}
+
+func (f *fakeTemplateDao) Get(context.Context, int64) (*core.KubeQuotaTemplate, error) {
+	return f.template, nil
+}
+
+func TestNamespaceLifecycle(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	r, _ := newTestRegistry(t, client)
+	cluster, err := r.Create(ctx,
+		&core.KubeCluster{Name: "test", AuthType: core.KubeAuthKubeconfig},
+		&core.KubeCredential{Kubeconfig: testKubeconfig},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dao := &fakeNamespaceDao{items: make(map[string]*core.KubeNamespace)}
+	n := &Namespaces{
+		registry:   r,
+		namespaces: dao,
+		templates: &fakeTemplateDao{template: &core.KubeQuotaTemplate{
+			Hard:         core.StringMap{"requests.cpu": "4", "requests.memory": "8Gi"},
+			DefaultLimit: core.StringMap{"cpu": "500m"},
+		}},
+	}
+
+	_, err = n.Create(ctx, &core.KubeNamespace{
+		ClusterID:       cluster.ID,
+		Name:            "payment",
+		ServiceNodeID:   12,
+		QuotaTemplateID: 1,
+		Labels:          core.StringMap{"env": "prod"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	live, _ := client.CoreV1().Namespaces().Get(ctx, "payment", metav1.GetOptions{})
+	want := map[string]string{"env": "prod", LabelManaged: "true", LabelServiceNode: "12"}
+	if !reflect.DeepEqual(live.Labels, want) {
+		t.Errorf("labels = %v, want %v", live.Labels, want)
+	}
+	quota, err := client.CoreV1().ResourceQuotas("payment").Get(ctx, quotaName, metav1.GetOptions{})
+	if err != nil || len(quota.Spec.Hard) != 2 {
+		t.Errorf("resource quota = %v, %v", quota, err)
+	}
+	if _, err := client.CoreV1().LimitRanges("payment").Get(ctx, limitRangeName, metav1.GetOptions{}); err != nil {
+		t.Errorf("limit range: %v", err)
+	}
+
+	// 其他人手工添加的标签不受影响, easynetes 不再需要的标签被删除, 被修改的标签被还原
+	live.Labels["owner"] = "alice"
+	live.Labels[LabelManaged] = "false"
+	if _, err := client.CoreV1().Namespaces().Update(ctx, live, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.Update(ctx, &core.KubeNamespace{ClusterID: cluster.ID, Name: "payment", Team: "infra"}); err != nil {
+		t.Fatal(err)
+	}
+	live, _ = client.CoreV1().Namespaces().Get(ctx, "payment", metav1.GetOptions{})
+	want = map[string]string{"owner": "alice", LabelManaged: "true", LabelTeam: "infra"}
+	if !reflect.DeepEqual(live.Labels, want) {
+		t.Errorf("labels after reconcile = %v, want %v", live.Labels, want)
+	}
+
+	// namespace 在集群中被删除后标记为 missing
+	_ = client.CoreV1().Namespaces().Delete(ctx, "payment", metav1.DeleteOptions{})
+	if err := n.Reconcile(ctx, dao.items["payment"]); err != nil {
+		t.Fatal(err)
+	}
+	if dao.items["payment"].Status != core.KubeNamespaceMissing {
+		t.Errorf("status = %s, want missing", dao.items["payment"].Status)
+	}
+}
+
+func TestCreateNamespaceCleanup(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "limitranges", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("admission denied")
+	})
+	r, _ := newTestRegistry(t, client)
+	cluster, err := r.Create(ctx,
+		&core.KubeCluster{Name: "test", AuthType: core.KubeAuthKubeconfig},
+		&core.KubeCredential{Kubeconfig: testKubeconfig},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dao := &fakeNamespaceDao{items: make(map[string]*core.KubeNamespace)}
+	n := &Namespaces{
+		registry:   r,
+		namespaces: dao,
+		templates: &fakeTemplateDao{template: &core.KubeQuotaTemplate{
+			Hard:         core.StringMap{"requests.cpu": "4"},
+			DefaultLimit: core.StringMap{"cpu": "500m"},
+		}},
+	}
+
+	// 创建默认限制失败时删除刚创建的 namespace, 也不保存绑定关系
+	_, err = n.Create(ctx, &core.KubeNamespace{ClusterID: cluster.ID, Name: "payment", QuotaTemplateID: 1})
+	if err == nil {
+		t.Fatal("expected limit range error")
+	}
+	if _, err := client.CoreV1().Namespaces().Get(ctx, "payment", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("namespace still exists after failed creation: %v", err)
+	}
+	if len(dao.items) != 0 {
+		t.Errorf("binding saved after failed creation: %v", dao.items)
+	}
+}
+
+func TestLabelPatchNoop(t *testing.T) {
+	desired := map[string]string{LabelManaged: "true", "env": "prod"}
+	live := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Labels:      map[string]string{LabelManaged: "true", "env": "prod", "owner": "alice"},
+		Annotations: map[string]string{AnnotationOwned: ownedKeys(desired)},
+	}}
+	if patch := labelPatch(live, desired); patch != nil {
+		t.Errorf("labelPatch() = %v, want nil", patch)
+	}
+}
+
+func TestValidateNamespace(t *testing.T) {
+	for _, ns := range []*core.KubeNamespace{
+		{Name: "Payment"},
+		{Name: "payment", Team: "a b"},
+		{Name: "payment", Labels: core.StringMap{"easynetes.io/managed": "false"}},
+		{Name: "payment", Labels: core.StringMap{"env": "prod/1"}},
+	} {
+		if err := validateNamespace(ns); err == nil {
+			t.Errorf("validateNamespace(%+v) = nil", ns)
+		}
+	}
+	if err := ValidateQuotaTemplate(&core.KubeQuotaTemplate{Hard: core.StringMap{"cpu": "four"}}); err == nil {
+		t.Error("ValidateQuotaTemplate() with invalid quantity = nil")
+	}
+}
@@ -0,0 +1,186 @@
+package kube
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NodeSyncResult 一次节点同步的结果
+type NodeSyncResult struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Offline int `json:"offline"`
+	Skipped int `json:"skipped"`
+}
+
+// NodeSync 将集群中的 Node 同步为 CMDB 中的主机, 集群中已经不存在的节点标记为离线
+type NodeSync struct {
+	registry *Registry
+	clusters core.KubeClusterDao
+	hosts    core.HostInstanceDao
+	interval time.Duration
+}
+
+// ProvideNodeSync is a Wire provider
+func ProvideNodeSync(registry *Registry, clusters core.KubeClusterDao, hosts core.HostInstanceDao, cfg *config.Config) *NodeSync {
+	return &NodeSync{
+		registry: registry,
+		clusters: clusters,
+		hosts:    hosts,
+		interval: cfg.Kubernetes.SyncInterval * time.Second,
+	}
+}
+
+// Run 定期同步所有集群的节点, 直到 ctx 结束
+func (s *NodeSync) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			s.SyncAll(ctx)
+		}
+	}
+}
+
+// SyncAll 依次同步所有集群的节点, 单个集群失败不影响其他集群
+func (s *NodeSync) SyncAll(ctx context.Context) {
+	clusters, err := s.clusters.List(ctx, map[string]interface{}{})
+	if err != nil {
+		logger.WithLabels("error", err).Error("cannot list clusters")
+		return
+	}
+	for _, cluster := range clusters {
+		result, err := s.Sync(ctx, cluster.ID)
+		if err != nil {
+			logger.WithLabels("cluster", cluster.Name, "error", err).Warn("cannot sync cluster nodes")
+			continue
+		}
+		if result.Created+result.Updated+result.Offline > 0 {
+			logger.WithLabels("cluster", cluster.Name, "created", result.Created, "updated", result.Updated,
+				"offline", result.Offline).Info("cluster nodes synced")
+		}
+	}
+}
+
+// Sync 同步一个集群的节点
+// 实例ID优先使用 providerID, 没有时使用 machineID; 已经由 agent 注册的同一台主机会关联到集群而不是重复创建
+func (s *NodeSync) Sync(ctx context.Context, clusterID int64) (*NodeSyncResult, error) {
+	client, err := s.registry.Client(ctx, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	existing, err := s.hosts.List(ctx, map[string]interface{}{"kube_cluster_id": clusterID})
+	if err != nil {
+		return nil, err
+	}
+	linked := make(map[string]*core.HostInstance, len(existing))
+	for _, host := range existing {
+		linked[host.InstanceID] = host
+	}
+
+	result := new(NodeSyncResult)
+	seen := make(map[string]bool, len(nodes.Items))
+	for i := range nodes.Items {
+		desired := nodeHost(clusterID, &nodes.Items[i])
+		if desired.InstanceID == "" {
+			result.Skipped++
+			continue
+		}
+		seen[desired.InstanceID] = true
+		current, ok := linked[desired.InstanceID]
+		if !ok {
+			current, err = s.hosts.GetByInstance(ctx, desired.InstanceID)
+			if errors.Is(err, sql.ErrNoRows) {
+				if _, err := s.hosts.Create(ctx, desired); err != nil {
+					return nil, err
+				}
+				result.Created++
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		if mergeNode(current, desired) {
+			if _, err := s.hosts.Update(ctx, current); err != nil {
+				return nil, err
+			}
+			result.Updated++
+		}
+	}
+	for _, host := range existing {
+		if seen[host.InstanceID] || host.HostStatus == core.HostStatusOffline {
+			continue
+		}
+		host.HostStatus = core.HostStatusOffline
+		if _, err := s.hosts.Update(ctx, host); err != nil {
+			return nil, err
+		}
+		result.Offline++
+	}
+	return result, nil
+}
+
+// nodeHost 将 Node 转换为主机, 内存单位为 MB
+func nodeHost(clusterID int64, node *corev1.Node) *core.HostInstance {
+	instanceID := node.Spec.ProviderID
+	if instanceID == "" {
+		instanceID = node.Status.NodeInfo.MachineID
+	}
+	status := core.HostStatusOffline
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady && condition.Status == corev1.ConditionTrue {
+			status = core.HostStatusOnline
+		}
+	}
+	host := &core.HostInstance{
+		InstanceID:    instanceID,
+		HostName:      node.Name,
+		OSName:        node.Status.NodeInfo.OSImage,
+		KernelVersion: node.Status.NodeInfo.KernelVersion,
+		HostStatus:    status,
+		KubeClusterID: clusterID,
+		KubeNodeName:  node.Name,
+	}
+	if cpu, ok := node.Status.Capacity[corev1.ResourceCPU]; ok {
+		host.CPUCores = int(cpu.Value())
+	}
+	if memory, ok := node.Status.Capacity[corev1.ResourceMemory]; ok {
+		host.MemSize = int(memory.Value() >> 20)
+	}
+	return host
+}
+
+// mergeNode 将节点的信息合并到已有的主机中, 保留 CMDB 中维护的其他字段, 返回是否有变化
+func mergeNode(current, desired *core.HostInstance) bool {
+	changed := current.HostName != desired.HostName ||
+		current.CPUCores != desired.CPUCores ||
+		current.MemSize != desired.MemSize ||
+		current.OSName != desired.OSName ||
+		current.KernelVersion != desired.KernelVersion ||
+		current.HostStatus != desired.HostStatus ||
+		current.KubeClusterID != desired.KubeClusterID ||
+		current.KubeNodeName != desired.KubeNodeName
+	current.HostName = desired.HostName
+	current.CPUCores = desired.CPUCores
+	current.MemSize = desired.MemSize
+	current.OSName = desired.OSName
+	current.KernelVersion = desired.KernelVersion
+	current.HostStatus = desired.HostStatus
+	current.KubeClusterID = desired.KubeClusterID
+	current.KubeNodeName = desired.KubeNodeName
+	return changed
+}
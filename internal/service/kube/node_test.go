@@ -0,0 +1,121 @@
+package kube
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeHostDao 内存中的 HostInstanceDao
+type fakeHostDao struct {
+	core.HostInstanceDao
+	items []*core.HostInstance
+}
+
+func (f *fakeHostDao) GetByInstance(_ context.Context, id string) (*core.HostInstance, error) {
+	for _, host := range f.items {
+		if host.InstanceID == id {
+			out := *host
+			return &out, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (f *fakeHostDao) List(_ context.Context, in map[string]interface{}) ([]*core.HostInstance, error) {
+	var out []*core.HostInstance
+	for _, host := range f.items {
+		if host.KubeClusterID == in["kube_cluster_id"] {
+			copied := *host
+			out = append(out, &copied)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeHostDao) Create(_ context.Context, in *core.HostInstance) (int64, error) {
+	in.ID = int64(len(f.items) + 1)
+	out := *in
+	f.items = append(f.items, &out)
+	return in.ID, nil
+}
+
+func (f *fakeHostDao) Update(_ context.Context, in *core.HostInstance) (*core.HostInstance, error) {
+	out := *in
+	f.items[in.ID-1] = &out
+	return in, nil
+}
+
+func testNode(name, providerID, machineID string, ready corev1.ConditionStatus) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       corev1.NodeSpec{ProviderID: providerID},
+		Status: corev1.NodeStatus{
+			Capacity: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("8"),
+				corev1.ResourceMemory: resource.MustParse("16Gi"),
+			},
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: ready}},
+			NodeInfo: corev1.NodeSystemInfo{
+				MachineID:     machineID,
+				OSImage:       "Ubuntu 22.04.4 LTS",
+				KernelVersion: "5.15.0-105-generic",
+			},
+		},
+	}
+}
+
+func TestNodeSync(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset(
+		testNode("node-1", "aws:///us-east-1a/i-0abc", "m-1", corev1.ConditionTrue),
+		testNode("node-2", "", "m-2", corev1.ConditionFalse),
+	)
+	r, clusters := newTestRegistry(t, client)
+	cluster, err := r.Create(ctx,
+		&core.KubeCluster{Name: "test", AuthType: core.KubeAuthKubeconfig},
+		&core.KubeCredential{Kubeconfig: testKubeconfig},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := &fakeHostDao{}
+	// node-2 已经由 agent 注册, node-old 已经从集群中删除
+	_, _ = hosts.Create(ctx, &core.HostInstance{InstanceID: "m-2", HostName: "node-2", ConnPort: 22, Remark: "rack 3"})
+	_, _ = hosts.Create(ctx, &core.HostInstance{InstanceID: "m-old", HostName: "node-old", HostStatus: core.HostStatusOnline, KubeClusterID: cluster.ID})
+	s := &NodeSync{registry: r, clusters: clusters, hosts: hosts}
+
+	result, err := s.Sync(ctx, cluster.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *result != (NodeSyncResult{Created: 1, Updated: 1, Offline: 1}) {
+		t.Errorf("result = %+v", result)
+	}
+	created := hosts.items[2]
+	if created.InstanceID != "aws:///us-east-1a/i-0abc" || created.CPUCores != 8 || created.MemSize != 16384 ||
+		created.HostStatus != core.HostStatusOnline || created.KubeClusterID != cluster.ID || created.OSName == "" {
+		t.Errorf("created host = %+v", created)
+	}
+	linked := hosts.items[0]
+	if linked.KubeClusterID != cluster.ID || linked.HostStatus != core.HostStatusOffline || linked.Remark != "rack 3" || linked.ConnPort != 22 {
+		t.Errorf("linked host = %+v", linked)
+	}
+	if hosts.items[1].HostStatus != core.HostStatusOffline {
+		t.Errorf("missing node status = %d, want offline", hosts.items[1].HostStatus)
+	}
+
+	result, err = s.Sync(ctx, cluster.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *result != (NodeSyncResult{}) {
+		t.Errorf("second sync result = %+v, want no changes", result)
+	}
+}
@@ -0,0 +1,102 @@
+package kube
+
+import (
+	"context"
+	"io"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// LogOptions 读取 Pod 日志的参数, 与 kubectl logs 的参数含义相同
+type LogOptions struct {
+	Container  string
+	Since      time.Duration
+	TailLines  int64
+	Follow     bool
+	Timestamps bool
+}
+
+// ExecOptions 在容器中执行命令的参数, TTY 为 true 时 stderr 会合并到 stdout
+type ExecOptions struct {
+	Container string
+	Command   []string
+	TTY       bool
+	Stdin     io.Reader
+	Stdout    io.Writer
+	Stderr    io.Writer
+	// Resize 返回终端窗口大小的变化, 返回 nil 表示不再有变化
+	Resize remotecommand.TerminalSizeQueue
+}
+
+// Pods 通过 apiserver 代理读取 Pod 日志和在容器中执行命令, 用户不需要拿到集群的认证信息
+type Pods struct {
+	registry *Registry
+}
+
+// ProvidePods is a Wire provider
+func ProvidePods(registry *Registry) *Pods {
+	return &Pods{registry: registry}
+}
+
+// Logs 返回 Pod 日志的数据流, Follow 为 true 时直到 ctx 结束或者容器退出才会读完
+func (p *Pods) Logs(ctx context.Context, clusterID int64, namespace, pod string, opts LogOptions) (io.ReadCloser, error) {
+	client, err := p.registry.Client(ctx, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	options := &corev1.PodLogOptions{
+		Container:  opts.Container,
+		Follow:     opts.Follow,
+		Timestamps: opts.Timestamps,
+	}
+	if opts.Since > 0 {
+		seconds := int64(opts.Since.Seconds())
+		options.SinceSeconds = &seconds
+	}
+	if opts.TailLines > 0 {
+		options.TailLines = &opts.TailLines
+	}
+	return client.CoreV1().Pods(namespace).GetLogs(pod, options).Stream(ctx)
+}
+
+// Exec 在容器中执行命令, 直到命令退出或者 ctx 结束
+func (p *Pods) Exec(ctx context.Context, clusterID int64, namespace, pod string, opts ExecOptions) error {
+	client, err := p.registry.Client(ctx, clusterID)
+	if err != nil {
+		return err
+	}
+	config, err := p.registry.RESTConfig(ctx, clusterID)
+	if err != nil {
+		return err
+	}
+	request := client.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(pod).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: opts.Container,
+			Command:   opts.Command,
+			Stdin:     opts.Stdin != nil,
+			Stdout:    opts.Stdout != nil,
+			Stderr:    opts.Stderr != nil && !opts.TTY,
+			TTY:       opts.TTY,
+		}, scheme.ParameterCodec)
+	executor, err := remotecommand.NewSPDYExecutor(config, "POST", request.URL())
+	if err != nil {
+		return err
+	}
+	streams := remotecommand.StreamOptions{
+		Stdin:             opts.Stdin,
+		Stdout:            opts.Stdout,
+		Tty:               opts.TTY,
+		TerminalSizeQueue: opts.Resize,
+	}
+	if !opts.TTY {
+		streams.Stderr = opts.Stderr
+	}
+	return executor.StreamWithContext(ctx, streams)
+}
@@ -0,0 +1,320 @@
+// Package kube 管理纳管的 kubernetes 集群, 负责保存加密的认证信息、创建客户端并定期检查集群的连通性
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/pkg/config"
+	"github.com/bloodsteel/easynetes/pkg/encrypt"
+	"github.com/bloodsteel/easynetes/pkg/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var logger = log.RegisterScope("kube", "kubernetes cluster registry", 0)
+
+// userAgent 访问集群时使用的 User-Agent
+const userAgent = "easynetes"
+
+// probeConcurrency 同时进行健康检查的集群数量
+const probeConcurrency = 8
+
+// Registry 管理集群的认证信息和客户端
+type Registry struct {
+	clusters  core.KubeClusterDao
+	encrypter *encrypt.Encrypter
+	interval  time.Duration
+	timeout   time.Duration
+	// newClient 根据 rest.Config 创建客户端, 测试时替换为 fake clientset
+	newClient func(*rest.Config) (kubernetes.Interface, error)
+
+	mu      sync.Mutex
+	clients map[int64]*clusterClient
+}
+
+// clusterClient 缓存的集群客户端, 集群信息更新后重新创建
+type clusterClient struct {
+	updateTime time.Time
+	config     *rest.Config
+	client     kubernetes.Interface
+}
+
+// ProvideRegistry is a Wire provider
+func ProvideRegistry(clusters core.KubeClusterDao, encrypter *encrypt.Encrypter, cfg *config.Config) *Registry {
+	return &Registry{
+		clusters:  clusters,
+		encrypter: encrypter,
+		interval:  cfg.Kubernetes.ProbeInterval * time.Second,
+		timeout:   cfg.Kubernetes.ProbeTimeout * time.Second,
+		newClient: func(c *rest.Config) (kubernetes.Interface, error) {
+			return kubernetes.NewForConfig(c)
+		},
+		clients: make(map[int64]*clusterClient),
+	}
+}
+
+// Create 校验并加密认证信息后保存集群, 然后立即进行一次健康检查
+func (r *Registry) Create(ctx context.Context, cluster *core.KubeCluster, cred *core.KubeCredential) (*core.KubeCluster, error) {
+	if err := r.setCredential(cluster, cred); err != nil {
+		return nil, err
+	}
+	if _, err := r.clusters.Create(ctx, cluster); err != nil {
+		return nil, err
+	}
+	r.Probe(ctx, cluster)
+	return cluster, nil
+}
+
+// Update 更新集群的基本信息, cred 为 nil 时保留原有的认证信息
+func (r *Registry) Update(ctx context.Context, cluster *core.KubeCluster, cred *core.KubeCredential) (*core.KubeCluster, error) {
+	old, err := r.clusters.Get(ctx, cluster.ID)
+	if err != nil {
+		return nil, err
+	}
+	old.Name = cluster.Name
+	old.Description = cluster.Description
+	if cred != nil {
+		old.AuthType = cluster.AuthType
+		old.Server = cluster.Server
+		if err := r.setCredential(old, cred); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.clusters.Update(ctx, old); err != nil {
+		return nil, err
+	}
+	r.forget(old.ID)
+	if cred != nil {
+		r.Probe(ctx, old)
+	}
+	return old, nil
+}
+
+// Delete 删除集群并释放客户端
+func (r *Registry) Delete(ctx context.Context, id int64) error {
+	if err := r.clusters.Delete(ctx, id); err != nil {
+		return err
+	}
+	r.forget(id)
+	return nil
+}
+
+// Client 返回集群的客户端
+func (r *Registry) Client(ctx context.Context, id int64) (kubernetes.Interface, error) {
+	c, err := r.cached(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return c.client, nil
+}
+
+// RESTConfig 返回集群的 rest.Config, 用于创建 dynamic client 等其他客户端
+func (r *Registry) RESTConfig(ctx context.Context, id int64) (*rest.Config, error) {
+	c, err := r.cached(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return rest.CopyConfig(c.config), nil
+}
+
+// Probe 检查集群的连通性, 记录服务端版本和节点数量
+func (r *Registry) Probe(ctx context.Context, cluster *core.KubeCluster) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	now := time.Now()
+	cluster.LastProbeTime = &now
+	if err := r.probe(ctx, cluster); err != nil {
+		cluster.Status = core.KubeClusterUnreachable
+		cluster.LastError = err.Error()
+	} else {
+		cluster.Status = core.KubeClusterHealthy
+		cluster.LastError = ""
+	}
+	if err := r.clusters.UpdateStatus(ctx, cluster); err != nil {
+		logger.WithLabels("cluster", cluster.Name, "error", err).Error("cannot save cluster status")
+	}
+}
+
+func (r *Registry) probe(ctx context.Context, cluster *core.KubeCluster) error {
+	c, err := r.clientFor(cluster)
+	if err != nil {
+		return err
+	}
+	// ServerVersion 不支持 ctx, 在单独的 goroutine 中执行以便超时返回
+	type result struct {
+		version string
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		info, err := c.client.Discovery().ServerVersion()
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		done <- result{version: info.GitVersion}
+	}()
+	select {
+	case res := <-done:
+		if res.err != nil {
+			return res.err
+		}
+		cluster.Version = res.version
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	nodes, err := c.client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	cluster.NodeCount = len(nodes.Items)
+	return nil
+}
+
+// Run 定期检查所有集群的连通性, 直到 ctx 结束
+func (r *Registry) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			r.ProbeAll(ctx)
+		}
+	}
+}
+
+// ProbeAll 并发检查所有集群的连通性
+func (r *Registry) ProbeAll(ctx context.Context) {
+	clusters, err := r.clusters.List(ctx, map[string]interface{}{})
+	if err != nil {
+		logger.WithLabels("error", err).Error("cannot list clusters")
+		return
+	}
+	sem := make(chan struct{}, probeConcurrency)
+	var wg sync.WaitGroup
+	for _, cluster := range clusters {
+		cluster := cluster
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			before := cluster.Status
+			r.Probe(ctx, cluster)
+			if cluster.Status != before {
+				logger.WithLabels("cluster", cluster.Name, "status", cluster.Status, "error", cluster.LastError).Warn("cluster status changed")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (r *Registry) cached(ctx context.Context, id int64) (*clusterClient, error) {
+	cluster, err := r.clusters.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return r.clientFor(cluster)
+}
+
+// clientFor 返回缓存的客户端, 集群信息有更新时重新创建
+func (r *Registry) clientFor(cluster *core.KubeCluster) (*clusterClient, error) {
+	r.mu.Lock()
+	c, ok := r.clients[cluster.ID]
+	r.mu.Unlock()
+	if ok && c.updateTime.Equal(cluster.UpdateTime) {
+		return c, nil
+	}
+	cred, err := r.credential(cluster)
+	if err != nil {
+		return nil, err
+	}
+	restConfig, err := restConfigFor(cluster.AuthType, cluster.Server, cred)
+	if err != nil {
+		return nil, err
+	}
+	client, err := r.newClient(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	c = &clusterClient{updateTime: cluster.UpdateTime, config: restConfig, client: client}
+	r.mu.Lock()
+	r.clients[cluster.ID] = c
+	r.mu.Unlock()
+	return c, nil
+}
+
+func (r *Registry) forget(id int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.clients, id)
+}
+
+// setCredential 校验认证信息, 并加密保存到集群中; kubeconfig 方式会从中解析出 apiserver 地址
+func (r *Registry) setCredential(cluster *core.KubeCluster, cred *core.KubeCredential) error {
+	restConfig, err := restConfigFor(cluster.AuthType, cluster.Server, cred)
+	if err != nil {
+		return err
+	}
+	cluster.Server = restConfig.Host
+	data, err := json.Marshal(cred)
+	if err != nil {
+		return err
+	}
+	cluster.Credential, err = r.encrypter.Encrypt(data)
+	return err
+}
+
+func (r *Registry) credential(cluster *core.KubeCluster) (*core.KubeCredential, error) {
+	data, err := r.encrypter.Decrypt(cluster.Credential)
+	if err != nil {
+		return nil, err
+	}
+	cred := new(core.KubeCredential)
+	return cred, json.Unmarshal(data, cred)
+}
+
+// restConfigFor 根据认证方式生成 rest.Config
+func restConfigFor(authType, server string, cred *core.KubeCredential) (*rest.Config, error) {
+	var (
+		out *rest.Config
+		err error
+	)
+	switch authType {
+	case core.KubeAuthKubeconfig:
+		if cred.Kubeconfig == "" {
+			return nil, core.ErrInvalidKubeCredential
+		}
+		out, err = clientcmd.RESTConfigFromKubeConfig([]byte(cred.Kubeconfig))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", core.ErrInvalidKubeCredential, err)
+		}
+	case core.KubeAuthToken:
+		if server == "" || cred.Token == "" {
+			return nil, core.ErrInvalidKubeCredential
+		}
+		out = &rest.Config{
+			Host:        server,
+			BearerToken: cred.Token,
+			TLSClientConfig: rest.TLSClientConfig{
+				CAData:   []byte(cred.CAData),
+				Insecure: cred.Insecure,
+			},
+		}
+	default:
+		return nil, core.ErrInvalidKubeCredential
+	}
+	out.UserAgent = userAgent
+	return out, nil
+}
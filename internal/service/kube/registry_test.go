@@ -0,0 +1,156 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/pkg/encrypt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: https://10.0.0.1:6443
+users:
+- name: admin
+  user:
+    token: secret-token
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: admin
+current-context: test
+`
+
+// fakeClusterDao 内存中的 KubeClusterDao
+type fakeClusterDao struct {
+	core.KubeClusterDao
+	items map[int64]*core.KubeCluster
+}
+
+func (f *fakeClusterDao) Get(_ context.Context, id int64) (*core.KubeCluster, error) {
+	c, ok := f.items[id]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	out := *c
+	return &out, nil
+}
+
+func (f *fakeClusterDao) List(context.Context, map[string]interface{}) ([]*core.KubeCluster, error) {
+	var out []*core.KubeCluster
+	for _, c := range f.items {
+		copied := *c
+		out = append(out, &copied)
+	}
+	return out, nil
+}
+
+func (f *fakeClusterDao) Create(_ context.Context, in *core.KubeCluster) (int64, error) {
+	in.ID = int64(len(f.items) + 1)
+	in.UpdateTime = time.Now()
+	out := *in
+	f.items[in.ID] = &out
+	return in.ID, nil
+}
+
+func (f *fakeClusterDao) UpdateStatus(_ context.Context, in *core.KubeCluster) error {
+	out := *in
+	f.items[in.ID] = &out
+	return nil
+}
+
+func newTestRegistry(t *testing.T, client kubernetes.Interface) (*Registry, *fakeClusterDao) {
+	t.Helper()
+	enc, _ := encrypt.New("test")
+	dao := &fakeClusterDao{items: make(map[int64]*core.KubeCluster)}
+	r := &Registry{
+		clusters:  dao,
+		encrypter: enc,
+		interval:  time.Minute,
+		timeout:   time.Second,
+		clients:   make(map[int64]*clusterClient),
+		newClient: func(*rest.Config) (kubernetes.Interface, error) { return client, nil },
+	}
+	return r, dao
+}
+
+func TestCreateAndProbe(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-2"}},
+	)
+	client.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &version.Info{GitVersion: "v1.29.4"}
+	r, dao := newTestRegistry(t, client)
+
+	cluster, err := r.Create(context.Background(),
+		&core.KubeCluster{Name: "test", AuthType: core.KubeAuthKubeconfig},
+		&core.KubeCredential{Kubeconfig: testKubeconfig},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := dao.items[cluster.ID]
+	if saved.Server != "https://10.0.0.1:6443" {
+		t.Errorf("server = %q", saved.Server)
+	}
+	if string(saved.Credential) == testKubeconfig || len(saved.Credential) == 0 {
+		t.Error("credential is not encrypted")
+	}
+	if saved.Status != core.KubeClusterHealthy || saved.Version != "v1.29.4" || saved.NodeCount != 2 {
+		t.Errorf("status = %s, version = %s, nodes = %d", saved.Status, saved.Version, saved.NodeCount)
+	}
+}
+
+func TestProbeUnreachable(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("list", "nodes", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("connection refused")
+	})
+	r, dao := newTestRegistry(t, client)
+
+	cluster, err := r.Create(context.Background(),
+		&core.KubeCluster{Name: "test", AuthType: core.KubeAuthToken, Server: "https://10.0.0.1:6443"},
+		&core.KubeCredential{Token: "token", Insecure: true},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := dao.items[cluster.ID]
+	if saved.Status != core.KubeClusterUnreachable || saved.LastError == "" {
+		t.Errorf("status = %s, last error = %q", saved.Status, saved.LastError)
+	}
+}
+
+func TestInvalidCredential(t *testing.T) {
+	r, _ := newTestRegistry(t, fake.NewSimpleClientset())
+	for _, c := range []struct {
+		authType string
+		cred     core.KubeCredential
+	}{
+		{core.KubeAuthKubeconfig, core.KubeCredential{}},
+		{core.KubeAuthKubeconfig, core.KubeCredential{Kubeconfig: "not yaml: ["}},
+		{core.KubeAuthToken, core.KubeCredential{Token: "token"}},
+		{"password", core.KubeCredential{Token: "token"}},
+	} {
+		_, err := r.Create(context.Background(), &core.KubeCluster{Name: "test", AuthType: c.authType}, &c.cred)
+		if !errors.Is(err, core.ErrInvalidKubeCredential) {
+			t.Errorf("Create(%s, %+v) = %v, want ErrInvalidKubeCredential", c.authType, c.cred, err)
+		}
+	}
+}
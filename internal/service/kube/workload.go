@@ -0,0 +1,571 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/pkg/config"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// annotationRevision deployment controller 在 Deployment 和 ReplicaSet 上记录的版本号
+	annotationRevision = "deployment.kubernetes.io/revision"
+	// annotationRestartedAt 与 kubectl rollout restart 使用相同的注解
+	annotationRestartedAt = "kubectl.kubernetes.io/restartedAt"
+	// cacheSyncTimeout 等待 informer 缓存首次同步完成的时间
+	cacheSyncTimeout = 30 * time.Second
+)
+
+var (
+	// ErrRevisionNotFound Deployment 没有指定的历史版本
+	ErrRevisionNotFound = errors.New("revision not found")
+	// ErrCacheNotSynced 集群的 informer 缓存还没有同步完成
+	ErrCacheNotSynced = errors.New("workload cache is not synced yet")
+)
+
+// WorkloadRef 指向集群中的一个工作负载
+type WorkloadRef struct {
+	ClusterID int64
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+// WorkloadInfo 工作负载的概要信息
+// Job 的 Desired 为 completions, Ready 为成功的 Pod 数量, Available 为运行中的 Pod 数量
+type WorkloadInfo struct {
+	Kind       string            `json:"kind"`
+	Namespace  string            `json:"namespace"`
+	Name       string            `json:"name"`
+	Desired    int32             `json:"desired"`
+	Ready      int32             `json:"ready"`
+	Updated    int32             `json:"updated"`
+	Available  int32             `json:"available"`
+	Revision   int64             `json:"revision,omitempty"`
+	Images     []string          `json:"images"`
+	Labels     map[string]string `json:"labels"`
+	Selector   string            `json:"selector"`
+	CreateTime time.Time         `json:"create_time"`
+}
+
+// PodInfo 工作负载的 Pod
+type PodInfo struct {
+	Name       string    `json:"name"`
+	Phase      string    `json:"phase"`
+	Ready      string    `json:"ready"`
+	Restarts   int32     `json:"restarts"`
+	NodeName   string    `json:"node_name"`
+	PodIP      string    `json:"pod_ip"`
+	CreateTime time.Time `json:"create_time"`
+}
+
+// RevisionInfo Deployment 的历史版本, 对应一个 ReplicaSet
+type RevisionInfo struct {
+	Revision   int64     `json:"revision"`
+	ReplicaSet string    `json:"replica_set"`
+	Replicas   int32     `json:"replicas"`
+	Images     []string  `json:"images"`
+	CreateTime time.Time `json:"create_time"`
+}
+
+// WorkloadDetail 工作负载的详细信息, Object 是缓存中的完整对象
+type WorkloadDetail struct {
+	*WorkloadInfo
+	Object    interface{}     `json:"object"`
+	Pods      []*PodInfo      `json:"pods"`
+	Revisions []*RevisionInfo `json:"revisions,omitempty"`
+}
+
+// Workloads 从每个集群共享的 informer 缓存中读取工作负载, 并记录对工作负载执行的操作
+// 缓存在第一次访问集群时创建, 闲置超过 idle 后释放
+type Workloads struct {
+	registry *Registry
+	actions  core.KubeWorkloadActionDao
+	idle     time.Duration
+
+	mu     sync.Mutex
+	caches map[int64]*informerCache
+}
+
+// informerCache 单个集群的 informer 缓存, 集群的客户端变化后重新创建
+type informerCache struct {
+	client   kubernetes.Interface
+	stop     chan struct{}
+	synced   chan struct{}
+	lastUsed time.Time
+
+	deployments  appslisters.DeploymentLister
+	statefulSets appslisters.StatefulSetLister
+	daemonSets   appslisters.DaemonSetLister
+	replicaSets  appslisters.ReplicaSetLister
+	jobs         batchlisters.JobLister
+	pods         corelisters.PodLister
+	nodes        corelisters.NodeLister
+}
+
+func newInformerCache(client kubernetes.Interface) *informerCache {
+	factory := informers.NewSharedInformerFactory(client, 0)
+	c := &informerCache{
+		client:       client,
+		stop:         make(chan struct{}),
+		synced:       make(chan struct{}),
+		deployments:  factory.Apps().V1().Deployments().Lister(),
+		statefulSets: factory.Apps().V1().StatefulSets().Lister(),
+		daemonSets:   factory.Apps().V1().DaemonSets().Lister(),
+		replicaSets:  factory.Apps().V1().ReplicaSets().Lister(),
+		jobs:         factory.Batch().V1().Jobs().Lister(),
+		pods:         factory.Core().V1().Pods().Lister(),
+		nodes:        factory.Core().V1().Nodes().Lister(),
+	}
+	factory.Start(c.stop)
+	go func() {
+		factory.WaitForCacheSync(c.stop)
+		close(c.synced)
+	}()
+	return c
+}
+
+func (c *informerCache) close() {
+	close(c.stop)
+}
+
+// ProvideWorkloads is a Wire provider
+func ProvideWorkloads(registry *Registry, actions core.KubeWorkloadActionDao, cfg *config.Config) *Workloads {
+	return &Workloads{
+		registry: registry,
+		actions:  actions,
+		idle:     cfg.Kubernetes.CacheIdle * time.Second,
+		caches:   make(map[int64]*informerCache),
+	}
+}
+
+// List 返回某一类工作负载, namespace 为空时返回所有 namespace 中的工作负载
+func (w *Workloads) List(ctx context.Context, clusterID int64, kind, namespace string) ([]*WorkloadInfo, error) {
+	c, err := w.cache(ctx, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	var out []*WorkloadInfo
+	switch kind {
+	case core.KubeDeployments:
+		items, err := c.deployments.Deployments(namespace).List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			out = append(out, deploymentInfo(item))
+		}
+	case core.KubeStatefulSets:
+		items, err := c.statefulSets.StatefulSets(namespace).List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			out = append(out, statefulSetInfo(item))
+		}
+	case core.KubeDaemonSets:
+		items, err := c.daemonSets.DaemonSets(namespace).List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			out = append(out, daemonSetInfo(item))
+		}
+	case core.KubeJobs:
+		items, err := c.jobs.Jobs(namespace).List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			out = append(out, jobInfo(item))
+		}
+	default:
+		return nil, core.ErrUnsupportedWorkload
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Namespace != out[j].Namespace {
+			return out[i].Namespace < out[j].Namespace
+		}
+		return out[i].Name < out[j].Name
+	})
+	if out == nil {
+		out = []*WorkloadInfo{}
+	}
+	return out, nil
+}
+
+// Get 返回工作负载的详细信息、Pod 列表, Deployment 还会返回历史版本
+func (w *Workloads) Get(ctx context.Context, ref WorkloadRef) (*WorkloadDetail, error) {
+	c, err := w.cache(ctx, ref.ClusterID)
+	if err != nil {
+		return nil, err
+	}
+	out := new(WorkloadDetail)
+	var selector *metav1.LabelSelector
+	switch ref.Kind {
+	case core.KubeDeployments:
+		item, err := c.deployments.Deployments(ref.Namespace).Get(ref.Name)
+		if err != nil {
+			return nil, err
+		}
+		out.WorkloadInfo, out.Object, selector = deploymentInfo(item), item, item.Spec.Selector
+		replicaSets, err := c.replicaSets.ReplicaSets(ref.Namespace).List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		for _, rs := range ownedReplicaSets(item, replicaSets) {
+			out.Revisions = append(out.Revisions, &RevisionInfo{
+				Revision:   revisionOf(rs),
+				ReplicaSet: rs.Name,
+				Replicas:   rs.Status.Replicas,
+				Images:     images(&rs.Spec.Template.Spec),
+				CreateTime: rs.CreationTimestamp.Time,
+			})
+		}
+	case core.KubeStatefulSets:
+		item, err := c.statefulSets.StatefulSets(ref.Namespace).Get(ref.Name)
+		if err != nil {
+			return nil, err
+		}
+		out.WorkloadInfo, out.Object, selector = statefulSetInfo(item), item, item.Spec.Selector
+	case core.KubeDaemonSets:
+		item, err := c.daemonSets.DaemonSets(ref.Namespace).Get(ref.Name)
+		if err != nil {
+			return nil, err
+		}
+		out.WorkloadInfo, out.Object, selector = daemonSetInfo(item), item, item.Spec.Selector
+	case core.KubeJobs:
+		item, err := c.jobs.Jobs(ref.Namespace).Get(ref.Name)
+		if err != nil {
+			return nil, err
+		}
+		out.WorkloadInfo, out.Object, selector = jobInfo(item), item, item.Spec.Selector
+	default:
+		return nil, core.ErrUnsupportedWorkload
+	}
+	out.Pods = []*PodInfo{}
+	if selector == nil {
+		return out, nil
+	}
+	sel, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := c.pods.Pods(ref.Namespace).List(sel)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Name < pods[j].Name })
+	for _, pod := range pods {
+		out.Pods = append(out.Pods, podInfo(pod))
+	}
+	return out, nil
+}
+
+// Scale 修改 Deployment 或者 StatefulSet 的副本数量
+func (w *Workloads) Scale(ctx context.Context, ref WorkloadRef, replicas int32, operator string) error {
+	if replicas < 0 {
+		return fmt.Errorf("%w: replicas must not be negative", core.ErrUnsupportedWorkload)
+	}
+	client, err := w.registry.Client(ctx, ref.ClusterID)
+	if err != nil {
+		return err
+	}
+	patch, _ := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{"replicas": replicas},
+	})
+	switch ref.Kind {
+	case core.KubeDeployments:
+		_, err = client.AppsV1().Deployments(ref.Namespace).Patch(ctx, ref.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	case core.KubeStatefulSets:
+		_, err = client.AppsV1().StatefulSets(ref.Namespace).Patch(ctx, ref.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	default:
+		return core.ErrUnsupportedWorkload
+	}
+	w.record(ctx, ref, core.KubeActionScale, fmt.Sprintf("replicas=%d", replicas), operator, err)
+	return err
+}
+
+// Restart 修改 Pod 模板中的注解触发滚动重启, 与 kubectl rollout restart 的行为一致
+func (w *Workloads) Restart(ctx context.Context, ref WorkloadRef, operator string) error {
+	client, err := w.registry.Client(ctx, ref.ClusterID)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Format(time.RFC3339)
+	patch, _ := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{annotationRestartedAt: now},
+				},
+			},
+		},
+	})
+	switch ref.Kind {
+	case core.KubeDeployments:
+		_, err = client.AppsV1().Deployments(ref.Namespace).Patch(ctx, ref.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	case core.KubeStatefulSets:
+		_, err = client.AppsV1().StatefulSets(ref.Namespace).Patch(ctx, ref.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	case core.KubeDaemonSets:
+		_, err = client.AppsV1().DaemonSets(ref.Namespace).Patch(ctx, ref.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	default:
+		return core.ErrUnsupportedWorkload
+	}
+	w.record(ctx, ref, core.KubeActionRestart, "restartedAt="+now, operator, err)
+	return err
+}
+
+// Rollback 将 Deployment 的 Pod 模板回滚到某个历史版本, revision 为 0 时回滚到上一个版本
+// 返回实际回滚到的版本号
+func (w *Workloads) Rollback(ctx context.Context, ref WorkloadRef, revision int64, operator string) (int64, error) {
+	if ref.Kind != core.KubeDeployments {
+		return 0, core.ErrUnsupportedWorkload
+	}
+	client, err := w.registry.Client(ctx, ref.ClusterID)
+	if err != nil {
+		return 0, err
+	}
+	var target *appsv1.ReplicaSet
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		deployments := client.AppsV1().Deployments(ref.Namespace)
+		deployment, err := deployments.Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		list, err := client.AppsV1().ReplicaSets(ref.Namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return err
+		}
+		replicaSets := make([]*appsv1.ReplicaSet, 0, len(list.Items))
+		for i := range list.Items {
+			replicaSets = append(replicaSets, &list.Items[i])
+		}
+		if target, err = rollbackTarget(deployment, ownedReplicaSets(deployment, replicaSets), revision); err != nil {
+			return err
+		}
+		template := target.Spec.Template.DeepCopy()
+		delete(template.Labels, appsv1.DefaultDeploymentUniqueLabelKey)
+		deployment.Spec.Template = *template
+		_, err = deployments.Update(ctx, deployment, metav1.UpdateOptions{})
+		return err
+	})
+	if target != nil {
+		revision = revisionOf(target)
+	}
+	w.record(ctx, ref, core.KubeActionRollback, fmt.Sprintf("revision=%d", revision), operator, err)
+	if err != nil {
+		return 0, err
+	}
+	return revision, nil
+}
+
+// Run 定期释放闲置的 informer 缓存, ctx 结束时释放所有缓存
+func (w *Workloads) Run(ctx context.Context) error {
+	ticker := time.NewTicker(w.idle / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			w.mu.Lock()
+			for id, c := range w.caches {
+				c.close()
+				delete(w.caches, id)
+			}
+			w.mu.Unlock()
+			return nil
+		case <-ticker.C:
+			w.mu.Lock()
+			for id, c := range w.caches {
+				if time.Since(c.lastUsed) > w.idle {
+					c.close()
+					delete(w.caches, id)
+					logger.WithLabels("cluster_id", id).Debug("workload cache released")
+				}
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+// cache 返回集群的 informer 缓存, 第一次访问时创建并等待同步完成
+func (w *Workloads) cache(ctx context.Context, clusterID int64) (*informerCache, error) {
+	client, err := w.registry.Client(ctx, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	w.mu.Lock()
+	c, ok := w.caches[clusterID]
+	if ok && c.client != client {
+		c.close()
+		ok = false
+	}
+	if !ok {
+		c = newInformerCache(client)
+		w.caches[clusterID] = c
+	}
+	c.lastUsed = time.Now()
+	w.mu.Unlock()
+
+	timer := time.NewTimer(cacheSyncTimeout)
+	defer timer.Stop()
+	select {
+	case <-c.synced:
+		return c, nil
+	case <-timer.C:
+		return nil, ErrCacheNotSynced
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (w *Workloads) record(ctx context.Context, ref WorkloadRef, action, detail, operator string, err error) {
+	record := &core.KubeWorkloadAction{
+		ClusterID: ref.ClusterID,
+		Namespace: ref.Namespace,
+		Kind:      ref.Kind,
+		Name:      ref.Name,
+		Action:    action,
+		Detail:    detail,
+		Operator:  operator,
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	if _, err := w.actions.Create(ctx, record); err != nil {
+		logger.WithLabels("cluster_id", ref.ClusterID, "namespace", ref.Namespace, "name", ref.Name,
+			"action", action, "error", err).Error("cannot record workload action")
+	}
+}
+
+// ownedReplicaSets 返回属于 Deployment 的 ReplicaSet, 按版本号从新到旧排序
+func ownedReplicaSets(deployment *appsv1.Deployment, replicaSets []*appsv1.ReplicaSet) []*appsv1.ReplicaSet {
+	var out []*appsv1.ReplicaSet
+	for _, rs := range replicaSets {
+		if owner := metav1.GetControllerOf(rs); owner != nil && owner.UID == deployment.UID {
+			out = append(out, rs)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return revisionOf(out[i]) > revisionOf(out[j]) })
+	return out
+}
+
+// rollbackTarget 查找要回滚到的 ReplicaSet, revision 为 0 时选择当前版本之前最新的版本
+func rollbackTarget(deployment *appsv1.Deployment, owned []*appsv1.ReplicaSet, revision int64) (*appsv1.ReplicaSet, error) {
+	current, _ := strconv.ParseInt(deployment.Annotations[annotationRevision], 10, 64)
+	for _, rs := range owned {
+		r := revisionOf(rs)
+		if revision == 0 && r < current || revision != 0 && r == revision {
+			return rs, nil
+		}
+	}
+	return nil, ErrRevisionNotFound
+}
+
+func revisionOf(obj metav1.Object) int64 {
+	r, _ := strconv.ParseInt(obj.GetAnnotations()[annotationRevision], 10, 64)
+	return r
+}
+
+func deploymentInfo(item *appsv1.Deployment) *WorkloadInfo {
+	out := workloadInfo(core.KubeDeployments, &item.ObjectMeta, &item.Spec.Template.Spec, item.Spec.Selector)
+	out.Desired = replicasOf(item.Spec.Replicas)
+	out.Ready = item.Status.ReadyReplicas
+	out.Updated = item.Status.UpdatedReplicas
+	out.Available = item.Status.AvailableReplicas
+	out.Revision = revisionOf(item)
+	return out
+}
+
+func statefulSetInfo(item *appsv1.StatefulSet) *WorkloadInfo {
+	out := workloadInfo(core.KubeStatefulSets, &item.ObjectMeta, &item.Spec.Template.Spec, item.Spec.Selector)
+	out.Desired = replicasOf(item.Spec.Replicas)
+	out.Ready = item.Status.ReadyReplicas
+	out.Updated = item.Status.UpdatedReplicas
+	out.Available = item.Status.AvailableReplicas
+	return out
+}
+
+func daemonSetInfo(item *appsv1.DaemonSet) *WorkloadInfo {
+	out := workloadInfo(core.KubeDaemonSets, &item.ObjectMeta, &item.Spec.Template.Spec, item.Spec.Selector)
+	out.Desired = item.Status.DesiredNumberScheduled
+	out.Ready = item.Status.NumberReady
+	out.Updated = item.Status.UpdatedNumberScheduled
+	out.Available = item.Status.NumberAvailable
+	return out
+}
+
+func jobInfo(item *batchv1.Job) *WorkloadInfo {
+	out := workloadInfo(core.KubeJobs, &item.ObjectMeta, &item.Spec.Template.Spec, item.Spec.Selector)
+	out.Desired = replicasOf(item.Spec.Completions)
+	out.Ready = item.Status.Succeeded
+	out.Available = item.Status.Active
+	return out
+}
+
+func workloadInfo(kind string, meta *metav1.ObjectMeta, spec *corev1.PodSpec, selector *metav1.LabelSelector) *WorkloadInfo {
+	return &WorkloadInfo{
+		Kind:       kind,
+		Namespace:  meta.Namespace,
+		Name:       meta.Name,
+		Images:     images(spec),
+		Labels:     meta.Labels,
+		Selector:   metav1.FormatLabelSelector(selector),
+		CreateTime: meta.CreationTimestamp.Time,
+	}
+}
+
+func podInfo(pod *corev1.Pod) *PodInfo {
+	var ready int
+	var restarts int32
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Ready {
+			ready++
+		}
+		restarts += status.RestartCount
+	}
+	return &PodInfo{
+		Name:       pod.Name,
+		Phase:      string(pod.Status.Phase),
+		Ready:      fmt.Sprintf("%d/%d", ready, len(pod.Spec.Containers)),
+		Restarts:   restarts,
+		NodeName:   pod.Spec.NodeName,
+		PodIP:      pod.Status.PodIP,
+		CreateTime: pod.CreationTimestamp.Time,
+	}
+}
+
+func images(spec *corev1.PodSpec) []string {
+	out := make([]string, 0, len(spec.Containers))
+	for _, container := range spec.Containers {
+		out = append(out, container.Image)
+	}
+	return out
+}
+
+// replicasOf 未设置副本数时默认为 1
+func replicasOf(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
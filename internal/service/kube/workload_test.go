@@ -0,0 +1,177 @@
+package kube
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeActionDao 内存中的 KubeWorkloadActionDao
+type fakeActionDao struct {
+	core.KubeWorkloadActionDao
+	items []*core.KubeWorkloadAction
+}
+
+func (f *fakeActionDao) Create(_ context.Context, in *core.KubeWorkloadAction) (int64, error) {
+	f.items = append(f.items, in)
+	in.ID = int64(len(f.items))
+	return in.ID, nil
+}
+
+func podTemplate(image string) corev1.PodTemplateSpec {
+	return corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "web"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Image: image}}},
+	}
+}
+
+func replicaSet(name, revision, image string, owner *appsv1.Deployment) *appsv1.ReplicaSet {
+	template := podTemplate(image)
+	template.Labels[appsv1.DefaultDeploymentUniqueLabelKey] = name
+	return &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       "default",
+			Annotations:     map[string]string{annotationRevision: revision},
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(owner, appsv1.SchemeGroupVersion.WithKind("Deployment"))},
+		},
+		Spec: appsv1.ReplicaSetSpec{Template: template},
+	}
+}
+
+func TestWorkloads(t *testing.T) {
+	ctx := context.Background()
+	replicas := int32(2)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Namespace:   "default",
+			UID:         "web-uid",
+			Annotations: map[string]string{annotationRevision: "2"},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			Template: podTemplate("nginx:1.25"),
+		},
+	}
+	client := fake.NewSimpleClientset(
+		deployment,
+		replicaSet("web-v1", "1", "nginx:1.24", deployment),
+		replicaSet("web-v2", "2", "nginx:1.25", deployment),
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default", Labels: map[string]string{"app": "web"}},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "web"}}},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning, ContainerStatuses: []corev1.ContainerStatus{{Ready: true, RestartCount: 3}}},
+		},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default", Labels: map[string]string{"app": "other"}}},
+	)
+	r, _ := newTestRegistry(t, client)
+	cluster, err := r.Create(ctx,
+		&core.KubeCluster{Name: "test", AuthType: core.KubeAuthKubeconfig},
+		&core.KubeCredential{Kubeconfig: testKubeconfig},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions := &fakeActionDao{}
+	w := &Workloads{registry: r, actions: actions, idle: time.Minute, caches: make(map[int64]*informerCache)}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() { _ = w.Run(ctx) }()
+
+	list, err := w.List(ctx, cluster.ID, core.KubeDeployments, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Desired != 2 || list[0].Revision != 2 {
+		t.Fatalf("list = %+v", list)
+	}
+	if _, err := w.List(ctx, cluster.ID, "pods", ""); err != core.ErrUnsupportedWorkload {
+		t.Errorf("list pods: err = %v", err)
+	}
+
+	ref := WorkloadRef{ClusterID: cluster.ID, Kind: core.KubeDeployments, Namespace: "default", Name: "web"}
+	detail, err := w.Get(ctx, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(detail.Pods) != 1 || detail.Pods[0].Ready != "1/1" || detail.Pods[0].Restarts != 3 {
+		t.Errorf("pods = %+v", detail.Pods)
+	}
+	if len(detail.Revisions) != 2 || detail.Revisions[0].Revision != 2 {
+		t.Errorf("revisions = %+v", detail.Revisions)
+	}
+
+	if err := w.Scale(ctx, ref, 5, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Restart(ctx, ref, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	live, _ := client.AppsV1().Deployments("default").Get(ctx, "web", metav1.GetOptions{})
+	if *live.Spec.Replicas != 5 || live.Spec.Template.Annotations[annotationRestartedAt] == "" {
+		t.Errorf("deployment after scale and restart = %+v", live.Spec)
+	}
+
+	revision, err := w.Rollback(ctx, ref, 0, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	live, _ = client.AppsV1().Deployments("default").Get(ctx, "web", metav1.GetOptions{})
+	if revision != 1 || live.Spec.Template.Spec.Containers[0].Image != "nginx:1.24" {
+		t.Errorf("rollback to %d, image = %s", revision, live.Spec.Template.Spec.Containers[0].Image)
+	}
+	if _, ok := live.Spec.Template.Labels[appsv1.DefaultDeploymentUniqueLabelKey]; ok {
+		t.Error("pod-template-hash label should be removed")
+	}
+	if _, err := w.Rollback(ctx, ref, 9, "bob"); err != ErrRevisionNotFound {
+		t.Errorf("rollback to missing revision: err = %v", err)
+	}
+
+	if len(actions.items) != 4 {
+		t.Fatalf("recorded %d actions, want 4", len(actions.items))
+	}
+	last := actions.items[3]
+	if last.Operator != "bob" || last.Action != core.KubeActionRollback || last.Error == "" {
+		t.Errorf("last action = %+v", last)
+	}
+}
+
+func TestRollbackTarget(t *testing.T) {
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{
+		UID:         "uid",
+		Annotations: map[string]string{annotationRevision: "3"},
+	}}
+	owned := []*appsv1.ReplicaSet{
+		replicaSet("v3", "3", "", deployment),
+		replicaSet("v2", "2", "", deployment),
+		replicaSet("v1", "1", "", deployment),
+	}
+	cases := []struct {
+		revision int64
+		want     string
+	}{
+		{0, "v2"},
+		{1, "v1"},
+		{3, "v3"},
+	}
+	for _, c := range cases {
+		rs, err := rollbackTarget(deployment, owned, c.revision)
+		if err != nil || rs.Name != c.want {
+			t.Errorf("rollbackTarget(%d) = %v, %v, want %s", c.revision, rs, err, c.want)
+		}
+	}
+	if _, err := rollbackTarget(deployment, owned[2:], 0); err != nil {
+		t.Errorf("rollback from 3 to 1: %v", err)
+	}
+	if _, err := rollbackTarget(deployment, owned[:1], 0); err != ErrRevisionNotFound {
+		t.Errorf("no previous revision: err = %v", err)
+	}
+}
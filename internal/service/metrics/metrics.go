@@ -0,0 +1,160 @@
+// Package metrics 接收 agent 上报的主机监控指标, 定期降采样并按保留时间清理过期数据
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/agent/proto"
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/agenthub"
+	"github.com/bloodsteel/easynetes/pkg/config"
+	"github.com/bloodsteel/easynetes/pkg/log"
+)
+
+var logger = log.RegisterScope("metrics", "host metrics ingestion and rollup", 0)
+
+// maxPoints 单次查询返回的最大数据点数量, step 过小时会自动放大
+const maxPoints = 1000
+
+// 降采样任务每次回看的时间窗口, 覆盖 agent 断线重连后补报的数据
+const (
+	minuteLookback = 10 * time.Minute
+	hourLookback   = 2 * time.Hour
+)
+
+// Service 主机监控指标服务
+type Service struct {
+	metrics   core.MetricDao
+	retention map[string]time.Duration
+}
+
+// ProvideService is a Wire provider
+func ProvideService(hub *agenthub.Hub, metrics core.MetricDao, cfg *config.Config) *Service {
+	s := &Service{
+		metrics: metrics,
+		retention: map[string]time.Duration{
+			core.ResolutionRaw:    cfg.Metrics.RawRetention * time.Hour,
+			core.ResolutionMinute: cfg.Metrics.MinuteRetention * time.Hour,
+			core.ResolutionHour:   cfg.Metrics.HourRetention * time.Hour,
+		},
+	}
+	hub.Handle(proto.TypeMetrics, s.handleMetrics)
+	return s
+}
+
+// Query 查询 agent 在 [from, to) 范围内的某个指标, step 为0时根据时间范围自动选择
+func (s *Service) Query(ctx context.Context, agentID int64, metric string, from, to time.Time, step int64) (*core.MetricSeries, error) {
+	resolution, step := s.resolve(time.Now(), from, to, step)
+	points, err := s.metrics.Query(ctx, resolution, agentID, metric, from, to, step)
+	if err != nil {
+		return nil, err
+	}
+	return &core.MetricSeries{
+		Metric:     metric,
+		Resolution: resolution,
+		Step:       step,
+		From:       from,
+		To:         to,
+		Points:     points,
+	}, nil
+}
+
+// resolve 选择能覆盖查询起始时间并且精度不高于 step 的存储精度, 同时限制返回的数据点数量
+func (s *Service) resolve(now, from, to time.Time, step int64) (string, int64) {
+	if min := int64(to.Sub(from)/time.Second) / maxPoints; step < min {
+		step = min
+	}
+	var resolution string
+	switch {
+	case step < 60 && now.Sub(from) <= s.retention[core.ResolutionRaw]:
+		resolution = core.ResolutionRaw
+	case step < 3600 && now.Sub(from) <= s.retention[core.ResolutionMinute]:
+		resolution = core.ResolutionMinute
+	default:
+		resolution = core.ResolutionHour
+	}
+	// step 不能小于存储精度, 并且需要是存储精度的整数倍
+	switch resolution {
+	case core.ResolutionMinute:
+		step = roundUp(step, 60)
+	case core.ResolutionHour:
+		step = roundUp(step, 3600)
+	}
+	if step <= 0 {
+		step = 1
+	}
+	return resolution, step
+}
+
+func roundUp(n, unit int64) int64 {
+	if n < unit {
+		return unit
+	}
+	return (n + unit - 1) / unit * unit
+}
+
+// Run 定期降采样并清理过期数据, 直到 ctx 结束
+func (s *Service) Run(ctx context.Context) error {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	var lastHour time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			s.rollup(ctx, core.ResolutionRaw, core.ResolutionMinute, now.Truncate(time.Minute), minuteLookback)
+			if hour := now.Truncate(time.Hour); !hour.Equal(lastHour) {
+				lastHour = hour
+				s.rollup(ctx, core.ResolutionMinute, core.ResolutionHour, hour, hourLookback)
+				s.prune(ctx, now)
+			}
+		}
+	}
+}
+
+// rollup 只聚合已经结束的时间桶, 回看窗口内的数据会被重新聚合
+func (s *Service) rollup(ctx context.Context, src, dst string, end time.Time, lookback time.Duration) {
+	if err := s.metrics.Rollup(ctx, src, dst, end.Add(-lookback), end); err != nil {
+		logger.WithLabels("src", src, "dst", dst, "error", err).Error("cannot rollup metrics")
+	}
+}
+
+func (s *Service) prune(ctx context.Context, now time.Time) {
+	for resolution, retention := range s.retention {
+		n, err := s.metrics.Prune(ctx, resolution, now.Add(-retention))
+		if err != nil {
+			logger.WithLabels("resolution", resolution, "error", err).Error("cannot prune metrics")
+			continue
+		}
+		if n > 0 {
+			logger.WithLabels("resolution", resolution, "rows", n).Info("pruned expired metrics")
+		}
+	}
+}
+
+func (s *Service) handleMetrics(ctx context.Context, agentID int64, msg *proto.Message) error {
+	in := new(proto.MetricsBatch)
+	if err := msg.Decode(in); err != nil {
+		return err
+	}
+	points := make([]*core.MetricPoint, 0, len(in.Samples)*len(core.Metrics))
+	for _, sample := range in.Samples {
+		for name, value := range sample.Values {
+			if !core.IsMetric(name) {
+				continue
+			}
+			points = append(points, &core.MetricPoint{
+				AgentID: agentID,
+				Metric:  name,
+				Time:    sample.Time,
+				Value:   value,
+				Min:     value,
+				Max:     value,
+				Count:   1,
+			})
+		}
+	}
+	return s.metrics.Insert(ctx, points)
+}
@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+)
+
+func TestResolve(t *testing.T) {
+	s := &Service{retention: map[string]time.Duration{
+		core.ResolutionRaw:    24 * time.Hour,
+		core.ResolutionMinute: 7 * 24 * time.Hour,
+		core.ResolutionHour:   90 * 24 * time.Hour,
+	}}
+	now := time.Now()
+	cases := []struct {
+		from       time.Duration
+		step       int64
+		resolution string
+		wantStep   int64
+	}{
+		{time.Hour, 0, core.ResolutionRaw, 3},
+		{time.Hour, 15, core.ResolutionRaw, 15},
+		{time.Hour, 90, core.ResolutionMinute, 120},
+		{48 * time.Hour, 0, core.ResolutionMinute, 180},
+		{30 * 24 * time.Hour, 0, core.ResolutionHour, 3600},
+		{6 * time.Hour, 7200, core.ResolutionHour, 7200},
+	}
+	for _, c := range cases {
+		resolution, step := s.resolve(now, now.Add(-c.from), now, c.step)
+		if resolution != c.resolution || step != c.wantStep {
+			t.Errorf("resolve(%v, %d) = %s, %d; want %s, %d", c.from, c.step, resolution, step, c.resolution, c.wantStep)
+		}
+	}
+}
@@ -0,0 +1,466 @@
+// Package notify 将事件总线上的事件按用户的订阅发送到邮件、webhook 和群机器人
+// 每条通知先保存为发送记录再异步发送, 失败时按指数退避重试, 发送历史可以查询
+package notify
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/eventbus"
+	"github.com/bloodsteel/easynetes/pkg/config"
+	"github.com/bloodsteel/easynetes/pkg/encrypt"
+	"github.com/bloodsteel/easynetes/pkg/log"
+)
+
+var logger = log.RegisterScope("notify", "notifications", 0)
+
+// errPermanent 重试也不会成功的错误, 例如渠道已经被删除或者禁用
+var errPermanent = errors.New("permanent failure")
+
+const (
+	// TestEvent 测试渠道时发送的事件
+	TestEvent = "notify.test"
+	// maxBackoff 两次重试之间的最长间隔
+	maxBackoff = time.Hour
+	// batchSize 每次最多发送的通知数量
+	batchSize = 100
+	// defaultSubject/defaultBody 没有匹配的模板时使用的模板
+	defaultSubject = "[easynetes] {{.Event}}"
+	defaultBody    = "{{json .Data}}"
+)
+
+// funcs 模板中可以使用的函数
+var funcs = template.FuncMap{
+	"json": func(v interface{}) string {
+		data, _ := json.MarshalIndent(v, "", "  ")
+		return string(data)
+	},
+}
+
+// templateData 渲染模板时使用的数据
+type templateData struct {
+	Event string
+	Time  time.Time
+	Data  interface{}
+}
+
+// Service 管理通知渠道、模板和订阅, 并发送通知
+type Service struct {
+	channels      core.NotifyChannelDao
+	subscriptions core.NotifySubscriptionDao
+	templates     core.NotifyTemplateDao
+	deliveries    core.NotifyDeliveryDao
+	users         core.UserDao
+	bus           *eventbus.Bus
+	encrypter     *encrypt.Encrypter
+	senders       map[string]Sender
+	interval      time.Duration
+	backoff       time.Duration
+	maxAttempts   int
+	timeout       time.Duration
+	worker        string
+}
+
+// ProvideService is a Wire provider
+func ProvideService(
+	channels core.NotifyChannelDao,
+	subscriptions core.NotifySubscriptionDao,
+	templates core.NotifyTemplateDao,
+	deliveries core.NotifyDeliveryDao,
+	users core.UserDao,
+	bus *eventbus.Bus,
+	encrypter *encrypt.Encrypter,
+	cfg *config.Config,
+) *Service {
+	timeout := cfg.Notify.Timeout * time.Second
+	client := &http.Client{Timeout: timeout}
+	hostname, _ := os.Hostname()
+	return &Service{
+		channels:      channels,
+		subscriptions: subscriptions,
+		templates:     templates,
+		deliveries:    deliveries,
+		users:         users,
+		bus:           bus,
+		encrypter:     encrypter,
+		senders: map[string]Sender{
+			core.NotifyEmail:    &emailSender{cfg: cfg.Notify.SMTP, timeout: timeout},
+			core.NotifyWebhook:  &webhookSender{http: client},
+			core.NotifyDingtalk: &robotSender{http: client, kind: core.NotifyDingtalk},
+			core.NotifyFeishu:   &robotSender{http: client, kind: core.NotifyFeishu},
+			core.NotifyWecom:    &robotSender{http: client, kind: core.NotifyWecom},
+		},
+		interval:    cfg.Notify.Interval * time.Second,
+		backoff:     cfg.Notify.Backoff * time.Second,
+		maxAttempts: cfg.Notify.MaxAttempts,
+		timeout:     timeout,
+		worker:      fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+	}
+}
+
+// CreateChannel 校验后创建通知渠道, secret 是签名密钥, 加密后保存
+func (s *Service) CreateChannel(ctx context.Context, channel *core.NotifyChannel, secret string) (*core.NotifyChannel, error) {
+	if err := s.setChannel(channel, secret); err != nil {
+		return nil, err
+	}
+	if _, err := s.channels.Create(ctx, channel); err != nil {
+		return nil, err
+	}
+	return channel, nil
+}
+
+// UpdateChannel 更新通知渠道, secret 为空时保留原有的密钥
+func (s *Service) UpdateChannel(ctx context.Context, channel *core.NotifyChannel, secret string) (*core.NotifyChannel, error) {
+	old, err := s.channels.Get(ctx, channel.ID)
+	if err != nil {
+		return nil, err
+	}
+	old.Name = channel.Name
+	old.Type = channel.Type
+	old.URL = channel.URL
+	old.Enabled = channel.Enabled
+	if secret == "" {
+		if secret, err = s.secret(old); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.setChannel(old, secret); err != nil {
+		return nil, err
+	}
+	if err := s.channels.Update(ctx, old); err != nil {
+		return nil, err
+	}
+	return old, nil
+}
+
+// SaveTemplate 校验模板可以解析后保存
+func (s *Service) SaveTemplate(ctx context.Context, tpl *core.NotifyTemplate) error {
+	if tpl.Event == "" {
+		return fmt.Errorf("%w: event is required", core.ErrInvalidNotifyTemplate)
+	}
+	for _, text := range []string{tpl.Subject, tpl.Body} {
+		if _, err := template.New("").Funcs(funcs).Parse(text); err != nil {
+			return fmt.Errorf("%w: %v", core.ErrInvalidNotifyTemplate, err)
+		}
+	}
+	return s.templates.Save(ctx, tpl)
+}
+
+// Subscribe 订阅事件, 渠道必须存在
+func (s *Service) Subscribe(ctx context.Context, sub *core.NotifySubscription) (*core.NotifySubscription, error) {
+	if sub.Event == "" {
+		return nil, fmt.Errorf("%w: event is required", core.ErrInvalidNotifyChannel)
+	}
+	if _, err := s.channels.Get(ctx, sub.ChannelID); err != nil {
+		return nil, err
+	}
+	if _, err := s.subscriptions.Create(ctx, sub); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// Test 通过渠道给当前用户发送一条测试通知, 立即发送并返回发送记录
+func (s *Service) Test(ctx context.Context, channelID int64, user *core.User) (*core.NotifyDelivery, error) {
+	channel, err := s.channels.Get(ctx, channelID)
+	if err != nil {
+		return nil, err
+	}
+	event := eventbus.Event{Topic: TestEvent, Time: time.Now(), Data: map[string]string{"channel": channel.Name}}
+	delivery, err := s.prepare(ctx, event, channel, user)
+	if err != nil {
+		return nil, err
+	}
+	s.send(ctx, delivery, channel)
+	return delivery, nil
+}
+
+// Run 订阅所有事件生成通知, 并定期发送到期的通知
+// 事件总线在缓冲区满时丢弃事件, 被丢弃的事件不会生成通知, 定期记录丢弃的数量
+func (s *Service) Run(ctx context.Context) error {
+	sub := s.bus.Subscribe("notify", 256, "*")
+	defer sub.Close()
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	var dropped uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event := <-sub.C:
+			if err := s.Dispatch(ctx, event); err != nil {
+				logger.WithLabels("event", event.Topic, "error", err).Warn("cannot dispatch notifications")
+			}
+		case <-ticker.C:
+			if n := sub.Dropped(); n > dropped {
+				logger.WithLabels("dropped", n-dropped, "total", n).Error("events dropped before notifications were created")
+				dropped = n
+			}
+			if err := s.Flush(ctx); err != nil {
+				logger.WithLabels("error", err).Warn("cannot send notifications")
+			}
+		}
+	}
+}
+
+// Dispatch 为订阅了事件的用户生成发送记录
+// 邮件发送给每个订阅的用户; webhook 和群机器人发送到同一个地址, 多个用户订阅同一个渠道时只发送一次
+func (s *Service) Dispatch(ctx context.Context, event eventbus.Event) error {
+	subs, err := s.subscriptions.List(ctx, map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+	channels := map[int64]*core.NotifyChannel{}
+	seen := map[string]bool{}
+	for _, sub := range subs {
+		if !eventbus.Match(sub.Event, event.Topic) {
+			continue
+		}
+		channel, ok := channels[sub.ChannelID]
+		if !ok {
+			if channel, err = s.channels.Get(ctx, sub.ChannelID); err != nil {
+				logger.WithLabels("channel_id", sub.ChannelID, "error", err).Warn("cannot load notification channel")
+				continue
+			}
+			channels[sub.ChannelID] = channel
+		}
+		key := fmt.Sprint(channel.ID)
+		if channel.Type == core.NotifyEmail {
+			key += fmt.Sprintf("/%d", sub.UserID)
+		}
+		if !channel.Enabled || seen[key] {
+			continue
+		}
+		seen[key] = true
+		user, err := s.users.Get(ctx, sub.UserID)
+		if err != nil {
+			logger.WithLabels("user_id", sub.UserID, "error", err).Warn("cannot load subscriber")
+			continue
+		}
+		if _, err := s.prepare(ctx, event, channel, user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush 发送所有到期的通知
+func (s *Service) Flush(ctx context.Context) error {
+	due, err := s.deliveries.ListDue(ctx, time.Now(), batchSize)
+	if err != nil {
+		return err
+	}
+	channels := map[int64]*core.NotifyChannel{}
+	for _, delivery := range due {
+		channel, ok := channels[delivery.ChannelID]
+		if !ok {
+			channel, err = s.channels.Get(ctx, delivery.ChannelID)
+			if errors.Is(err, sql.ErrNoRows) {
+				channel, err = nil, nil
+			}
+			if err != nil {
+				return err
+			}
+			channels[delivery.ChannelID] = channel
+		}
+		s.send(ctx, delivery, channel)
+	}
+	return nil
+}
+
+// prepare 渲染模板并创建待发送的记录
+func (s *Service) prepare(ctx context.Context, event eventbus.Event, channel *core.NotifyChannel,
+	user *core.User) (*core.NotifyDelivery, error) {
+	subject, body, err := s.render(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"event":   event.Topic,
+		"time":    event.Time,
+		"subject": subject,
+		"body":    body,
+		"data":    event.Data,
+	})
+	if err != nil {
+		return nil, err
+	}
+	delivery := &core.NotifyDelivery{
+		Event:       event.Topic,
+		ChannelID:   channel.ID,
+		ChannelType: channel.Type,
+		UserID:      user.ID,
+		Recipient:   channel.URL,
+		Subject:     subject,
+		Body:        body,
+		Payload:     string(payload),
+		Status:      core.NotifyPending,
+		NextTime:    time.Now(),
+	}
+	if channel.Type == core.NotifyEmail {
+		delivery.Recipient = user.UserEmail
+	}
+	if _, err := s.deliveries.Create(ctx, delivery); err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// render 使用匹配事件的 Event 最长的模板渲染标题和内容, 模板执行失败时使用默认模板
+func (s *Service) render(ctx context.Context, event eventbus.Event) (string, string, error) {
+	templates, err := s.templates.List(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	subject, body := defaultSubject, defaultBody
+	best := -1
+	for _, tpl := range templates {
+		if eventbus.Match(tpl.Event, event.Topic) && len(tpl.Event) > best {
+			subject, body, best = tpl.Subject, tpl.Body, len(tpl.Event)
+		}
+	}
+	data := &templateData{Event: event.Topic, Time: event.Time, Data: event.Data}
+	outSubject, err1 := execute(subject, data)
+	outBody, err2 := execute(body, data)
+	if err := errors.Join(err1, err2); err != nil {
+		logger.WithLabels("event", event.Topic, "error", err).Warn("cannot render notification template")
+		outSubject, _ = execute(defaultSubject, data)
+		outBody, _ = execute(defaultBody, data)
+	}
+	return outSubject, outBody, nil
+}
+
+// send 领取并发送一条通知, 保存结果, 失败时按指数退避安排下一次发送, 次数用完后标记为失败
+// 多个实例同时发送时只有领取成功的实例发送, 租约在发送超时之后到期, 实例退出后由其他实例重新发送
+func (s *Service) send(ctx context.Context, delivery *core.NotifyDelivery, channel *core.NotifyChannel) {
+	now := time.Now()
+	ok, err := s.deliveries.Claim(ctx, delivery.ID, s.worker, now, now.Add(2*s.timeout))
+	if err != nil {
+		logger.WithLabels("delivery_id", delivery.ID, "error", err).Warn("cannot claim notification delivery")
+		return
+	}
+	if !ok {
+		return
+	}
+	delivery.Worker = s.worker
+	delivery.Attempts++
+	err = s.deliver(ctx, delivery, channel)
+	now = time.Now()
+	switch {
+	case err == nil:
+		delivery.Status = core.NotifySent
+		delivery.LastError = ""
+		delivery.SentTime = &now
+	case delivery.Attempts >= s.maxAttempts || errors.Is(err, errPermanent):
+		delivery.Status = core.NotifyFailed
+		delivery.LastError = err.Error()
+	default:
+		delivery.LastError = err.Error()
+		delivery.NextTime = now.Add(s.retryAfter(delivery.Attempts))
+	}
+	if err != nil {
+		logger.WithLabels("delivery_id", delivery.ID, "event", delivery.Event, "channel_id", delivery.ChannelID,
+			"attempts", delivery.Attempts, "error", err).Warn("cannot send notification")
+	}
+	if ok, err := s.deliveries.Update(ctx, delivery); err != nil {
+		logger.WithLabels("delivery_id", delivery.ID, "error", err).Warn("cannot save notification delivery")
+	} else if !ok {
+		logger.WithLabels("delivery_id", delivery.ID).Warn("notification delivery was claimed by another worker, result discarded")
+	}
+}
+
+func (s *Service) deliver(ctx context.Context, delivery *core.NotifyDelivery, channel *core.NotifyChannel) error {
+	if channel == nil {
+		return fmt.Errorf("%w: channel has been deleted", errPermanent)
+	}
+	if !channel.Enabled {
+		return fmt.Errorf("%w: channel is disabled", errPermanent)
+	}
+	sender, ok := s.senders[channel.Type]
+	if !ok {
+		return fmt.Errorf("%w: unknown channel type %s", errPermanent, channel.Type)
+	}
+	secret, err := s.secret(channel)
+	if err != nil {
+		return fmt.Errorf("%w: cannot decrypt secret: %v", errPermanent, err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return sender.Send(ctx, channel, secret, &Message{
+		ID:        delivery.ID,
+		Event:     delivery.Event,
+		Subject:   delivery.Subject,
+		Body:      delivery.Body,
+		Payload:   []byte(delivery.Payload),
+		Recipient: delivery.Recipient,
+	})
+}
+
+// retryAfter 第 n 次发送失败后等待的时间
+func (s *Service) retryAfter(n int) time.Duration {
+	d := s.backoff
+	for i := 1; i < n && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
+
+// setChannel 校验渠道的类型和地址后加密保存密钥
+func (s *Service) setChannel(channel *core.NotifyChannel, secret string) error {
+	if channel.Name == "" {
+		return fmt.Errorf("%w: name is required", core.ErrInvalidNotifyChannel)
+	}
+	if _, ok := s.senders[channel.Type]; !ok {
+		return fmt.Errorf("%w: unknown type %q", core.ErrInvalidNotifyChannel, channel.Type)
+	}
+	if channel.Type != core.NotifyEmail {
+		u, err := url.Parse(channel.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w: url must be an http(s) address", core.ErrInvalidNotifyChannel)
+		}
+	}
+	channel.Secret = nil
+	if secret == "" {
+		return nil
+	}
+	var err error
+	channel.Secret, err = s.encrypter.Encrypt([]byte(secret))
+	return err
+}
+
+func (s *Service) secret(channel *core.NotifyChannel) (string, error) {
+	if len(channel.Secret) == 0 {
+		return "", nil
+	}
+	data, err := s.encrypter.Decrypt(channel.Secret)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func execute(text string, data *templateData) (string, error) {
+	tpl, err := template.New("").Funcs(funcs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
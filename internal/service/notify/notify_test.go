@@ -0,0 +1,367 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/eventbus"
+	"github.com/bloodsteel/easynetes/pkg/config"
+	"github.com/bloodsteel/easynetes/pkg/encrypt"
+)
+
+type fakeChannelDao struct {
+	core.NotifyChannelDao
+	items map[int64]*core.NotifyChannel
+}
+
+func (f *fakeChannelDao) Get(_ context.Context, id int64) (*core.NotifyChannel, error) {
+	channel, ok := f.items[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	out := *channel
+	return &out, nil
+}
+
+func (f *fakeChannelDao) Create(_ context.Context, channel *core.NotifyChannel) (int64, error) {
+	channel.ID = int64(len(f.items) + 1)
+	f.items[channel.ID] = channel
+	return channel.ID, nil
+}
+
+type fakeSubscriptionDao struct {
+	core.NotifySubscriptionDao
+	items []*core.NotifySubscription
+}
+
+func (f *fakeSubscriptionDao) List(context.Context, map[string]interface{}) ([]*core.NotifySubscription, error) {
+	return f.items, nil
+}
+
+type fakeTemplateDao struct {
+	core.NotifyTemplateDao
+	items []*core.NotifyTemplate
+}
+
+func (f *fakeTemplateDao) List(context.Context) ([]*core.NotifyTemplate, error) { return f.items, nil }
+
+type fakeDeliveryDao struct {
+	core.NotifyDeliveryDao
+	mu    sync.Mutex
+	items []core.NotifyDelivery
+}
+
+func (f *fakeDeliveryDao) ListDue(_ context.Context, now time.Time, _ int) ([]*core.NotifyDelivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []*core.NotifyDelivery
+	for _, item := range f.items {
+		item := item
+		if item.Status == core.NotifyPending && !item.NextTime.After(now) {
+			out = append(out, &item)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeDeliveryDao) Create(_ context.Context, delivery *core.NotifyDelivery) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delivery.ID = int64(len(f.items) + 1)
+	f.items = append(f.items, *delivery)
+	return delivery.ID, nil
+}
+
+func (f *fakeDeliveryDao) Claim(_ context.Context, id int64, worker string, now, until time.Time) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	item := &f.items[id-1]
+	if item.Status != core.NotifyPending || item.NextTime.After(now) {
+		return false, nil
+	}
+	item.Worker, item.NextTime = worker, until
+	return true, nil
+}
+
+func (f *fakeDeliveryDao) Update(_ context.Context, delivery *core.NotifyDelivery) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.items[delivery.ID-1].Worker != delivery.Worker {
+		return false, nil
+	}
+	f.items[delivery.ID-1] = *delivery
+	return true, nil
+}
+
+// due 将所有待发送的记录改为立即发送, 跳过退避等待
+func (f *fakeDeliveryDao) due() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.items {
+		f.items[i].NextTime = time.Now()
+	}
+}
+
+type fakeUserDao struct {
+	core.UserDao
+}
+
+func (fakeUserDao) Get(_ context.Context, id int64) (*core.User, error) {
+	return &core.User{ID: id, UserName: "u" + strconv.FormatInt(id, 10), UserEmail: "u" + strconv.FormatInt(id, 10) + "@example.com"}, nil
+}
+
+func newTestService(t *testing.T, smtpAddr string) (*Service, *fakeDeliveryDao, *fakeSubscriptionDao, *fakeTemplateDao) {
+	t.Helper()
+	encrypter, err := encrypt.New("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{}
+	cfg.SetDefault()
+	cfg.Notify.Timeout = 2
+	if smtpAddr != "" {
+		host, port, _ := net.SplitHostPort(smtpAddr)
+		cfg.Notify.SMTP.Host = host
+		cfg.Notify.SMTP.Port, _ = strconv.Atoi(port)
+		cfg.Notify.SMTP.From = "easynetes@example.com"
+	}
+	deliveries, subs, templates := &fakeDeliveryDao{}, &fakeSubscriptionDao{}, &fakeTemplateDao{}
+	s := ProvideService(&fakeChannelDao{items: map[int64]*core.NotifyChannel{}}, subs, templates, deliveries,
+		fakeUserDao{}, eventbus.ProvideBus(), encrypter, cfg)
+	return s, deliveries, subs, templates
+}
+
+func TestWebhookRetry(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests int
+		body     []byte
+		header   http.Header
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		body, _ = io.ReadAll(r.Body)
+		header = r.Header
+		// 第一次请求失败, 验证重试
+		if requests == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	s, deliveries, subs, templates := newTestService(t, "")
+	channel, err := s.CreateChannel(ctx, &core.NotifyChannel{Name: "ops", Type: core.NotifyWebhook, URL: server.URL, Enabled: true}, "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(channel.Secret) == "s3cret" {
+		t.Fatal("secret must be stored encrypted")
+	}
+	// 两个用户订阅同一个 webhook 渠道只发送一次
+	subs.items = []*core.NotifySubscription{
+		{UserID: 1, ChannelID: channel.ID, Event: "approval.*"},
+		{UserID: 2, ChannelID: channel.ID, Event: "*"},
+		{UserID: 3, ChannelID: channel.ID, Event: "pipeline.*"},
+	}
+	templates.items = []*core.NotifyTemplate{
+		{Event: "*", Subject: "generic", Body: "generic"},
+		{Event: "approval.created", Subject: "approval #{{.Data.ID}}", Body: "{{.Data.Requester}} wants to {{.Data.Action}}"},
+	}
+	event := eventbus.Event{Topic: "approval.created", Time: time.Now(),
+		Data: &core.ApprovalRequest{ID: 7, Requester: "dev", Action: core.ApprovalHostDelete}}
+	if err := s.Dispatch(ctx, event); err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries.items) != 1 {
+		t.Fatalf("deliveries = %d, want 1", len(deliveries.items))
+	}
+	if d := deliveries.items[0]; d.Subject != "approval #7" || d.Body != "dev wants to host.delete" {
+		t.Fatalf("rendered %q / %q", d.Subject, d.Body)
+	}
+
+	if err := s.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	d := deliveries.items[0]
+	if d.Status != core.NotifyPending || d.Attempts != 1 || !d.NextTime.After(time.Now()) || d.LastError == "" {
+		t.Fatalf("after failure: %+v", d)
+	}
+	deliveries.due()
+	if err := s.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if d = deliveries.items[0]; d.Status != core.NotifySent || d.Attempts != 2 || d.SentTime == nil {
+		t.Fatalf("after retry: %+v", d)
+	}
+	timestamp, _ := strconv.ParseInt(header.Get(HeaderTimestamp), 10, 64)
+	if header.Get(HeaderSignature) != Sign("s3cret", timestamp, body) || header.Get(HeaderEvent) != "approval.created" {
+		t.Fatalf("bad signature headers %v", header)
+	}
+	var payload struct {
+		Event string `json:"event"`
+		Data  struct {
+			ID int64 `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil || payload.Event != "approval.created" || payload.Data.ID != 7 {
+		t.Fatalf("payload %s: %v", body, err)
+	}
+}
+
+func TestFlushClaim(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	s, deliveries, subs, _ := newTestService(t, "")
+	channel, err := s.CreateChannel(ctx, &core.NotifyChannel{Name: "ops", Type: core.NotifyWebhook, URL: server.URL, Enabled: true}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subs.items = []*core.NotifySubscription{{UserID: 1, ChannelID: channel.ID, Event: "*"}}
+	if err := s.Dispatch(ctx, eventbus.Event{Topic: "host.created", Time: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	// 两个实例同时发送同一条通知, 只有领取成功的实例发送
+	other := *s
+	other.worker = "other"
+	var wg sync.WaitGroup
+	for _, svc := range []*Service{s, &other} {
+		wg.Add(1)
+		go func(svc *Service) {
+			defer wg.Done()
+			if err := svc.Flush(ctx); err != nil {
+				t.Error(err)
+			}
+		}(svc)
+	}
+	wg.Wait()
+	if d := deliveries.items[0]; requests != 1 || d.Status != core.NotifySent || d.Attempts != 1 {
+		t.Fatalf("requests = %d, delivery = %+v", requests, d)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	s := &Service{backoff: 30 * time.Second}
+	for n, want := range map[int]time.Duration{1: 30 * time.Second, 2: time.Minute, 4: 4 * time.Minute, 20: time.Hour} {
+		if got := s.retryAfter(n); got != want {
+			t.Errorf("retryAfter(%d) = %s, want %s", n, got, want)
+		}
+	}
+}
+
+func TestEmail(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan string, 1)
+	go serveSMTP(ln, received)
+
+	ctx := context.Background()
+	s, deliveries, _, _ := newTestService(t, ln.Addr().String())
+	channel, err := s.CreateChannel(ctx, &core.NotifyChannel{Name: "mail", Type: core.NotifyEmail, Enabled: true}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivery, err := s.Test(ctx, channel.ID, &core.User{ID: 4, UserName: "ops", UserEmail: "ops@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivery.Status != core.NotifySent {
+		t.Fatalf("delivery = %s %q", delivery.Status, delivery.LastError)
+	}
+	select {
+	case data := <-received:
+		if !strings.Contains(data, "RCPT TO:<ops@example.com>") || !strings.Contains(data, "Subject: [easynetes] notify.test") {
+			t.Fatalf("unexpected mail:\n%s", data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("mail not received")
+	}
+	if len(deliveries.items) != 1 || deliveries.items[0].Recipient != "ops@example.com" {
+		t.Fatalf("deliveries = %+v", deliveries.items)
+	}
+}
+
+// serveSMTP 一个只接收一封邮件的 SMTP 服务器, 将收到的命令和邮件内容发送到 received
+func serveSMTP(ln net.Listener, received chan<- string) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	var log strings.Builder
+	_ = tp.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		log.WriteString(line + "\n")
+		switch cmd := strings.ToUpper(strings.Fields(line + " x")[0]); cmd {
+		case "EHLO", "HELO":
+			_ = tp.PrintfLine("250 localhost")
+		case "DATA":
+			_ = tp.PrintfLine("354 go ahead")
+			data, _ := io.ReadAll(bufio.NewReader(tp.DotReader()))
+			log.Write(data)
+			_ = tp.PrintfLine("250 queued")
+		case "QUIT":
+			_ = tp.PrintfLine("221 bye")
+			received <- log.String()
+			return
+		default:
+			_ = tp.PrintfLine("250 ok")
+		}
+	}
+}
+
+func TestRobotBuild(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	msg := &Message{Subject: "deploy", Body: "done"}
+	ding := &robotSender{kind: core.NotifyDingtalk}
+	target, _, err := ding.build("https://oapi.dingtalk.com/robot/send?access_token=x", "SEC", msg, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(target, "https://oapi.dingtalk.com/robot/send?access_token=x&timestamp=1700000000000&sign=") {
+		t.Fatalf("dingtalk target = %s", target)
+	}
+	feishu := &robotSender{kind: core.NotifyFeishu}
+	_, body, err := feishu.build("https://open.feishu.cn/hook/x", "SEC", msg, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out map[string]interface{}
+	_ = json.Unmarshal(body, &out)
+	if out["timestamp"] != "1700000000" || out["sign"] == "" || out["msg_type"] != "text" {
+		t.Fatalf("feishu body = %s", body)
+	}
+}
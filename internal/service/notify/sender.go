@@ -0,0 +1,233 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/pkg/config"
+)
+
+// webhook 渠道请求头
+const (
+	HeaderEvent     = "X-Easynetes-Event"
+	HeaderDelivery  = "X-Easynetes-Delivery"
+	HeaderTimestamp = "X-Easynetes-Timestamp"
+	HeaderSignature = "X-Easynetes-Signature"
+)
+
+// Message 渲染后的一条通知, Payload 是 webhook 渠道发送的 JSON
+type Message struct {
+	ID        int64
+	Event     string
+	Subject   string
+	Body      string
+	Payload   []byte
+	Recipient string
+}
+
+// Sender 通过一种类型的渠道发送通知, secret 是解密后的签名密钥
+type Sender interface {
+	Send(ctx context.Context, channel *core.NotifyChannel, secret string, msg *Message) error
+}
+
+// Sign 计算 webhook 的签名: sha256=hex(HMAC-SHA256(secret, "<timestamp>.<body>"))
+// 接收方使用相同的方式计算签名并比较, 同时检查时间戳防止重放
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// emailSender 通过 SMTP 发送纯文本邮件, 服务器支持 STARTTLS 时使用加密连接
+type emailSender struct {
+	cfg     config.SMTP
+	timeout time.Duration
+}
+
+func (e *emailSender) Send(ctx context.Context, _ *core.NotifyChannel, _ string, msg *Message) error {
+	if e.cfg.Host == "" {
+		return errors.New("smtp server is not configured")
+	}
+	if msg.Recipient == "" {
+		return errors.New("user has no email address")
+	}
+	dialer := &net.Dialer{Timeout: e.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(e.cfg.Host, strconv.Itoa(e.cfg.Port)))
+	if err != nil {
+		return err
+	}
+	// net/smtp 不支持 ctx, 使用连接的 deadline 限制整个会话的时间
+	_ = conn.SetDeadline(time.Now().Add(e.timeout))
+	client, err := smtp.NewClient(conn, e.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: e.cfg.Host}); err != nil {
+			return err
+		}
+	}
+	if e.cfg.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", e.cfg.Username, e.cfg.Password, e.cfg.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(e.cfg.From); err != nil {
+		return err
+	}
+	if err := client.Rcpt(msg.Recipient); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	header := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\n"+
+		"Content-Type: text/plain; charset=UTF-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\n",
+		e.cfg.From, msg.Recipient, mime.QEncoding.Encode("utf-8", msg.Subject), time.Now().Format(time.RFC1123Z))
+	if _, err := io.WriteString(w, header+msg.Body+"\n"); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// webhookSender 将 Payload POST 到渠道的地址, 配置了密钥时带上签名, 返回 2xx 表示成功
+type webhookSender struct {
+	http *http.Client
+}
+
+func (w *webhookSender) Send(ctx context.Context, channel *core.NotifyChannel, secret string, msg *Message) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, channel.URL, bytes.NewReader(msg.Payload))
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, msg.Event)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(msg.ID, 10))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	if secret != "" {
+		req.Header.Set(HeaderSignature, Sign(secret, timestamp, msg.Payload))
+	}
+	resp, err := w.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// robotSender 发送到钉钉、飞书和企业微信的群机器人, 三者的请求格式和签名方式不同, 响应中的错误码为0表示成功
+type robotSender struct {
+	http *http.Client
+	kind string
+}
+
+func (r *robotSender) Send(ctx context.Context, channel *core.NotifyChannel, secret string, msg *Message) error {
+	target, body, err := r.build(channel.URL, secret, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s robot returned %s", r.kind, resp.Status)
+	}
+	var out struct {
+		ErrCode *int   `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+		Code    *int   `json:"code"`
+		Msg     string `json:"msg"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&out); err != nil {
+		return fmt.Errorf("cannot decode %s robot response: %w", r.kind, err)
+	}
+	if out.ErrCode != nil && *out.ErrCode != 0 {
+		return fmt.Errorf("%s robot error %d: %s", r.kind, *out.ErrCode, out.ErrMsg)
+	}
+	if out.Code != nil && *out.Code != 0 {
+		return fmt.Errorf("%s robot error %d: %s", r.kind, *out.Code, out.Msg)
+	}
+	return nil
+}
+
+// build 返回请求的地址和请求体, 钉钉的签名放在地址的查询参数中, 飞书的签名放在请求体中
+func (r *robotSender) build(target, secret string, msg *Message, now time.Time) (string, []byte, error) {
+	var body interface{}
+	switch r.kind {
+	case core.NotifyDingtalk:
+		if secret != "" {
+			timestamp := strconv.FormatInt(now.UnixMilli(), 10)
+			mac := hmac.New(sha256.New, []byte(secret))
+			mac.Write([]byte(timestamp + "\n" + secret))
+			sep := "?"
+			if strings.Contains(target, "?") {
+				sep = "&"
+			}
+			target += sep + "timestamp=" + timestamp + "&sign=" +
+				url.QueryEscape(base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+		}
+		body = map[string]interface{}{
+			"msgtype":  "markdown",
+			"markdown": map[string]string{"title": msg.Subject, "text": "### " + msg.Subject + "\n\n" + msg.Body},
+		}
+	case core.NotifyFeishu:
+		content := map[string]interface{}{
+			"msg_type": "text",
+			"content":  map[string]string{"text": msg.Subject + "\n" + msg.Body},
+		}
+		if secret != "" {
+			timestamp := strconv.FormatInt(now.Unix(), 10)
+			mac := hmac.New(sha256.New, []byte(timestamp+"\n"+secret))
+			content["timestamp"] = timestamp
+			content["sign"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+		}
+		body = content
+	case core.NotifyWecom:
+		body = map[string]interface{}{
+			"msgtype":  "markdown",
+			"markdown": map[string]string{"content": "**" + msg.Subject + "**\n" + msg.Body},
+		}
+	default:
+		return "", nil, fmt.Errorf("%w: unknown robot %s", core.ErrInvalidNotifyChannel, r.kind)
+	}
+	data, err := json.Marshal(body)
+	return target, data, err
+}
@@ -0,0 +1,91 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+)
+
+const (
+	// logFlushInterval 阶段输出写入数据库的间隔
+	logFlushInterval = time.Second
+	// logLimit 单次执行保存的输出上限, 超出的部分丢弃
+	logLimit = 1 << 20
+)
+
+// stageLog 将阶段的输出缓存在内存中, 定期追加到数据库
+type stageLog struct {
+	runs core.PipelineRunDao
+	id   int64
+
+	mu   sync.Mutex
+	buf  bytes.Buffer
+	size int
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func newStageLog(runs core.PipelineRunDao, id int64) *stageLog {
+	l := &stageLog{
+		runs:    runs,
+		id:      id,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go l.loop()
+	return l
+}
+
+// Write 实现 io.Writer 接口, 总是返回成功, 避免日志问题中断脚本的执行
+func (l *stageLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.size >= logLimit {
+		return len(p), nil
+	}
+	data := p
+	if l.size+len(data) > logLimit {
+		data = append(data[:logLimit-l.size:logLimit-l.size], "\n... output truncated\n"...)
+	}
+	l.buf.Write(data)
+	l.size += len(p)
+	return len(p), nil
+}
+
+// Close 停止定期写入, 并写入剩余的输出
+func (l *stageLog) Close() {
+	close(l.done)
+	<-l.stopped
+	l.flush()
+}
+
+func (l *stageLog) loop() {
+	defer close(l.stopped)
+	ticker := time.NewTicker(logFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			l.flush()
+		}
+	}
+}
+
+func (l *stageLog) flush() {
+	l.mu.Lock()
+	data := l.buf.String()
+	l.buf.Reset()
+	l.mu.Unlock()
+	if data == "" {
+		return
+	}
+	if err := l.runs.AppendLog(context.Background(), l.id, data); err != nil {
+		logger.WithLabels("stage_run_id", l.id, "error", err).Warn("cannot append stage log")
+	}
+}
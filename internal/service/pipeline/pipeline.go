@@ -0,0 +1,836 @@
+// Package pipeline 按顺序执行流水线的构建、审批、部署和验证阶段
+// 运行和阶段的状态都保存在数据库中, apiserver 重启后从数据库中的状态继续推进
+// 部署阶段的发布与手动发布一样经过审批规则, 匹配到规则时阶段等待审批请求执行完成
+// 运行等待审批和结束时以 pipeline.run.<status> 为主题发布到事件总线
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/approval"
+	"github.com/bloodsteel/easynetes/internal/service/eventbus"
+	"github.com/bloodsteel/easynetes/internal/service/hostexec"
+	"github.com/bloodsteel/easynetes/internal/service/jenkins"
+	"github.com/bloodsteel/easynetes/internal/service/release"
+	"github.com/bloodsteel/easynetes/pkg/config"
+	"github.com/bloodsteel/easynetes/pkg/log"
+)
+
+var logger = log.RegisterScope("pipeline", "deployment pipelines", 0)
+
+const (
+	// probeInterval 验证阶段两次 HTTP 探测之间的间隔
+	probeInterval = 5 * time.Second
+	// probeTimeout 单次 HTTP 探测的超时时间
+	probeTimeout = 10 * time.Second
+)
+
+// ErrInvalidTransition 运行或者阶段当前的状态不允许该操作
+var ErrInvalidTransition = errors.New("operation not allowed in current pipeline run status")
+
+// errNotClaimed 阶段已经被其他副本认领或者状态已经改变, 本副本不再推进该阶段
+var errNotClaimed = errors.New("pipeline stage is not claimed by this apiserver")
+
+// Builder 以用户的身份触发 Jenkins 构建
+type Builder interface {
+	Trigger(ctx context.Context, jobID int64, params map[string]string, user *core.User) (*core.JenkinsBuild, error)
+}
+
+// Releaser 发布应用到环境
+type Releaser interface {
+	Deploy(ctx context.Context, appID, envID int64, image string, user *core.User) (*core.AppRelease, error)
+}
+
+// Approver 为需要审批的操作创建审批请求, 没有匹配的审批规则时返回 nil
+type Approver interface {
+	Submit(ctx context.Context, action string, payload interface{}, reason string,
+		user *core.User) (*core.ApprovalRequest, error)
+	Cancel(ctx context.Context, id int64, user *core.User) (*core.ApprovalRequest, error)
+}
+
+// Executor 在主机上执行脚本
+type Executor interface {
+	Exec(ctx context.Context, hostID int64, script string, env map[string]string,
+		timeout time.Duration, out io.Writer) (int, error)
+}
+
+// Service 管理流水线并推进进行中的运行
+// 构建和审批阶段的进度完全由数据库中的状态决定; 部署和验证阶段在 apiserver 进程内执行,
+// 执行过程中 apiserver 重启时阶段会被标记为失败, 需要手动重试
+// 多个副本推进阶段前先通过租约认领, 执行阶段的副本定期续期, 其他副本只在租约过期后接管
+type Service struct {
+	pipelines  core.PipelineDao
+	runs       core.PipelineRunDao
+	builds     core.JenkinsBuildDao
+	users      core.UserDao
+	requests   core.ApprovalRequestDao
+	authorizer core.Authorizer
+	bus        *eventbus.Bus
+	builder    Builder
+	releaser   Releaser
+	approvals  Approver
+	executor   Executor
+	http       *http.Client
+	interval   time.Duration
+	timeout    time.Duration
+	lease      time.Duration
+	owner      string
+	kick       chan struct{}
+
+	// mu 保证同一时间只有一次推进, 并保护 workers
+	mu      sync.Mutex
+	workers map[int64]*worker
+}
+
+// worker 进程内执行中的部署或者验证阶段, key 为阶段状态的ID
+type worker struct {
+	cancel context.CancelFunc
+}
+
+// ProvideService is a Wire provider
+func ProvideService(
+	pipelines core.PipelineDao,
+	runs core.PipelineRunDao,
+	builds core.JenkinsBuildDao,
+	users core.UserDao,
+	requests core.ApprovalRequestDao,
+	authorizer core.Authorizer,
+	bus *eventbus.Bus,
+	builder *jenkins.Service,
+	releaser *release.Service,
+	approvals *approval.Service,
+	executor *hostexec.Executor,
+	cfg *config.Config,
+) *Service {
+	hostname, _ := os.Hostname()
+	return &Service{
+		pipelines:  pipelines,
+		runs:       runs,
+		builds:     builds,
+		users:      users,
+		requests:   requests,
+		authorizer: authorizer,
+		bus:        bus,
+		builder:    builder,
+		releaser:   releaser,
+		approvals:  approvals,
+		executor:   executor,
+		http:       &http.Client{Timeout: probeTimeout},
+		interval:   cfg.Pipeline.Interval * time.Second,
+		timeout:    cfg.Pipeline.StageTimeout * time.Second,
+		lease:      cfg.Pipeline.Lease * time.Second,
+		owner:      fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		kick:       make(chan struct{}, 1),
+		workers:    make(map[int64]*worker),
+	}
+}
+
+// Save 校验阶段配置后创建(ID 为0时)或者更新流水线, 更新时创建人不变
+func (s *Service) Save(ctx context.Context, in *core.Pipeline) (*core.Pipeline, error) {
+	if in.Name == "" || in.AppID == 0 {
+		return nil, fmt.Errorf("%w: name and app_id are required", core.ErrInvalidPipeline)
+	}
+	if err := in.Stages.Validate(); err != nil {
+		return nil, err
+	}
+	if in.ID == 0 {
+		if _, err := s.pipelines.Create(ctx, in); err != nil {
+			return nil, err
+		}
+		return in, nil
+	}
+	current, err := s.pipelines.Get(ctx, in.ID)
+	if err != nil {
+		return nil, err
+	}
+	current.Name = in.Name
+	current.AppID = in.AppID
+	current.ServiceNodeID = in.ServiceNodeID
+	current.Stages = in.Stages
+	if err := s.pipelines.Update(ctx, current); err != nil {
+		return nil, err
+	}
+	return current, nil
+}
+
+// Start 使用流水线当前的阶段配置启动一次运行, params 是运行参数
+// 用户需要有流水线所属应用或者服务树节点的构建权限
+func (s *Service) Start(ctx context.Context, pipelineID int64, params map[string]string, user *core.User) (*core.PipelineRun, error) {
+	p, err := s.pipelines.Get(ctx, pipelineID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, p, user); err != nil {
+		return nil, err
+	}
+	run := &core.PipelineRun{
+		PipelineID: p.ID,
+		AppID:      p.AppID,
+		Stages:     p.Stages,
+		Parameters: core.StringMap(params),
+		Status:     core.PipelineRunning,
+		Creator:    user.UserName,
+		CreatorID:  user.ID,
+	}
+	if run.Parameters == nil {
+		run.Parameters = core.StringMap{}
+	}
+	stages := make([]*core.PipelineStageRun, 0, len(p.Stages))
+	for i, stage := range p.Stages {
+		stages = append(stages, &core.PipelineStageRun{
+			Stage:   i,
+			Name:    stage.Name,
+			Type:    stage.Type,
+			Status:  core.PipelinePending,
+			Attempt: 1,
+		})
+	}
+	if _, err := s.runs.Create(ctx, run, stages); err != nil {
+		return nil, err
+	}
+	logger.WithLabels("pipeline", p.Name, "run_id", run.ID, "user", user.UserName).Info("pipeline run started")
+	s.notify()
+	return run, nil
+}
+
+// Cancel 取消进行中或者等待审批的运行, 当前阶段标记为已取消, 只有运行的创建人和管理员可以取消
+// 进程内执行的部署和验证阶段会被终止, 已经触发的 Jenkins 构建不受影响
+func (s *Service) Cancel(ctx context.Context, runID int64, user *core.User) (*core.PipelineRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run, err := s.runs.Get(ctx, runID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.IsAdmin && user.ID != run.CreatorID {
+		return nil, core.ErrForbidden
+	}
+	if run.Status != core.PipelineRunning && run.Status != core.PipelineWaiting {
+		return nil, ErrInvalidTransition
+	}
+	stage, err := s.runs.GetStage(ctx, run.ID, run.Current)
+	if err != nil {
+		return nil, err
+	}
+	if w, ok := s.workers[stage.ID]; ok {
+		w.cancel()
+		delete(s.workers, stage.ID)
+	}
+	// 部署阶段等待的审批请求一起取消, 避免运行取消后审批通过仍然发布
+	if stage.Type == core.PipelineStageDeploy && stage.Status == core.PipelineWaiting && stage.Ref > 0 {
+		if _, err := s.approvals.Cancel(ctx, stage.Ref, user); err != nil && !errors.Is(err, approval.ErrClosed) {
+			return nil, err
+		}
+	}
+	stage.Operator = user.UserName
+	message := "canceled by " + user.UserName
+	if err := s.finishStage(ctx, stage, core.PipelineCanceled, message); err != nil {
+		return nil, err
+	}
+	return run, s.finishRun(ctx, run, core.PipelineCanceled, fmt.Sprintf("stage %s %s", stage.Name, message))
+}
+
+// Retry 重试失败或者被取消的运行的当前阶段, 之前已经成功的阶段不会重新执行
+// 只有运行的创建人和管理员可以重试
+func (s *Service) Retry(ctx context.Context, runID int64, user *core.User) (*core.PipelineRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run, err := s.runs.Get(ctx, runID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.IsAdmin && user.ID != run.CreatorID {
+		return nil, core.ErrForbidden
+	}
+	if run.Status != core.PipelineFailed && run.Status != core.PipelineCanceled {
+		return nil, ErrInvalidTransition
+	}
+	stage, err := s.runs.GetStage(ctx, run.ID, run.Current)
+	if err != nil {
+		return nil, err
+	}
+	if stage.Status != core.PipelineFailed && stage.Status != core.PipelineCanceled {
+		return nil, ErrInvalidTransition
+	}
+	stage.Status = core.PipelinePending
+	stage.Attempt++
+	stage.Ref = 0
+	stage.Operator = user.UserName
+	stage.Message = ""
+	stage.Owner = ""
+	stage.LeaseTime = nil
+	stage.StartTime = nil
+	stage.FinishTime = nil
+	if err := s.runs.UpdateStage(ctx, stage); err != nil {
+		return nil, err
+	}
+	run.Status = core.PipelineRunning
+	run.Message = ""
+	run.FinishTime = nil
+	if err := s.runs.Update(ctx, run); err != nil {
+		return nil, err
+	}
+	logger.WithLabels("run_id", run.ID, "stage", stage.Name, "attempt", stage.Attempt, "user", user.UserName).
+		Info("pipeline stage retried")
+	s.notify()
+	return run, nil
+}
+
+// Approve 审批等待中的审批阶段, approved 为 false 时拒绝, 拒绝后运行失败
+// 阶段没有配置审批人时只有管理员可以审批, 运行的创建人不能审批
+func (s *Service) Approve(ctx context.Context, runID int64, user *core.User, approved bool, comment string) (*core.PipelineRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run, err := s.runs.Get(ctx, runID)
+	if err != nil {
+		return nil, err
+	}
+	if run.Status != core.PipelineWaiting {
+		return nil, ErrInvalidTransition
+	}
+	stage, err := s.runs.GetStage(ctx, run.ID, run.Current)
+	if err != nil {
+		return nil, err
+	}
+	if stage.Type != core.PipelineStageApproval || stage.Status != core.PipelineWaiting {
+		return nil, ErrInvalidTransition
+	}
+	if !canApprove(run, user) {
+		return nil, core.ErrForbidden
+	}
+	status, message := core.PipelineSucceeded, "approved by "+user.UserName
+	if !approved {
+		status, message = core.PipelineFailed, "rejected by "+user.UserName
+	}
+	if comment != "" {
+		message += ": " + comment
+	}
+	s.appendLog(ctx, stage.ID, message+"\n")
+	stage.Operator = user.UserName
+	if err := s.finishStage(ctx, stage, status, message); err != nil {
+		return nil, err
+	}
+	run.Status = core.PipelineRunning
+	if err := s.runs.Update(ctx, run); err != nil {
+		return nil, err
+	}
+	s.notify()
+	return run, nil
+}
+
+// Run 定期推进所有进行中的运行, 启动、重试和审批后立即推进一次, 直到 ctx 结束
+func (s *Service) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-s.kick:
+		}
+		if err := s.Sync(ctx); err != nil {
+			logger.WithLabels("error", err).Error("cannot sync pipeline runs")
+		}
+	}
+}
+
+// Sync 续期本副本执行中的阶段, 然后推进一次所有进行中和等待审批的运行
+func (s *Service) Sync(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.renew(ctx)
+	runs, err := s.runs.ListActive(ctx)
+	if err != nil {
+		return err
+	}
+	for _, run := range runs {
+		if err := s.syncRun(ctx, run); err != nil && !errors.Is(err, errNotClaimed) {
+			logger.WithLabels("run_id", run.ID, "error", err).Error("cannot sync pipeline run")
+		}
+	}
+	return nil
+}
+
+// syncRun 推进运行的当前阶段, 阶段成功后继续推进下一个阶段, 直到某个阶段需要等待
+func (s *Service) syncRun(ctx context.Context, run *core.PipelineRun) error {
+	stages, err := s.runs.ListStages(ctx, run.ID)
+	if err != nil {
+		return err
+	}
+	if len(stages) != len(run.Stages) {
+		return s.finishRun(ctx, run, core.PipelineFailed, "stage records do not match pipeline stages")
+	}
+	for {
+		stage, spec := stages[run.Current], run.Stages[run.Current]
+		switch stage.Status {
+		case core.PipelinePending:
+			if ok, err := s.claim(ctx, stage); err != nil || !ok {
+				return err
+			}
+			if err := s.startStage(ctx, run, stage, spec); err != nil {
+				return err
+			}
+		case core.PipelineRunning:
+			changed, err := s.checkStage(ctx, stage, spec)
+			if err != nil || !changed {
+				return err
+			}
+		case core.PipelineWaiting:
+			if stage.Type == core.PipelineStageDeploy {
+				changed, err := s.checkApproval(ctx, run, stage, spec)
+				if err != nil {
+					return err
+				}
+				if changed {
+					if stage.Status == core.PipelineRunning {
+						return s.resume(ctx, run)
+					}
+					continue
+				}
+			}
+			if run.Status == core.PipelineWaiting {
+				return nil
+			}
+			run.Status = core.PipelineWaiting
+			run.Message = fmt.Sprintf("stage %s is waiting for approval", stage.Name)
+			if err := s.runs.Update(ctx, run); err != nil {
+				return err
+			}
+			s.publish(run)
+			return nil
+		case core.PipelineSucceeded:
+			if run.Current == len(stages)-1 {
+				return s.finishRun(ctx, run, core.PipelineSucceeded, "")
+			}
+			run.Current++
+			run.Status = core.PipelineRunning
+			run.Message = ""
+			if err := s.runs.Update(ctx, run); err != nil {
+				return err
+			}
+		default:
+			message := fmt.Sprintf("stage %s %s", stage.Name, stage.Status)
+			if stage.Message != "" {
+				message += ": " + stage.Message
+			}
+			return s.finishRun(ctx, run, stage.Status, message)
+		}
+	}
+}
+
+// renew 续期本副本执行中的阶段, 阶段已经被取消或者被其他副本接管时终止执行, 调用方需要持有 s.mu
+func (s *Service) renew(ctx context.Context) {
+	now := time.Now()
+	for id, w := range s.workers {
+		ok, err := s.runs.ClaimStage(ctx, id, core.PipelineRunning, s.owner, now, now.Add(s.lease))
+		if err != nil {
+			logger.WithLabels("stage_run_id", id, "error", err).Warn("cannot renew pipeline stage lease")
+			continue
+		}
+		if !ok {
+			logger.WithLabels("stage_run_id", id).Warn("pipeline stage is no longer claimed, stopping it")
+			w.cancel()
+			delete(s.workers, id)
+		}
+	}
+}
+
+// claim 认领阶段, 返回是否认领成功; 阶段由其他副本认领并且租约未过期时不能认领
+func (s *Service) claim(ctx context.Context, stage *core.PipelineStageRun) (bool, error) {
+	now := time.Now()
+	until := now.Add(s.lease)
+	ok, err := s.runs.ClaimStage(ctx, stage.ID, stage.Status, s.owner, now, until)
+	if err != nil || !ok {
+		return false, err
+	}
+	stage.Owner = s.owner
+	stage.LeaseTime = &until
+	return true, nil
+}
+
+// startStage 开始执行认领的阶段, 无法开始时阶段直接失败
+func (s *Service) startStage(ctx context.Context, run *core.PipelineRun, stage *core.PipelineStageRun, spec core.PipelineStage) error {
+	now := time.Now()
+	stage.StartTime = &now
+	stage.FinishTime = nil
+	s.appendLog(ctx, stage.ID, fmt.Sprintf("==> %s stage %s, attempt %d, %s\n",
+		spec.Type, spec.Name, stage.Attempt, now.Format(time.RFC3339)))
+	switch spec.Type {
+	case core.PipelineStageBuild:
+		// 构建以运行创建人的身份触发, 创建人需要拥有任务的构建授权
+		user, err := s.users.Get(ctx, run.CreatorID)
+		if err != nil {
+			return fmt.Errorf("cannot load run creator: %w", err)
+		}
+		build, err := s.builder.Trigger(ctx, spec.JobID, expandMap(spec.Parameters, run.Parameters), user)
+		if err != nil {
+			return s.finishClaimed(ctx, stage, core.PipelineFailed, "cannot trigger build: "+err.Error())
+		}
+		stage.Ref = build.ID
+		stage.Status = core.PipelineRunning
+	case core.PipelineStageApproval:
+		stage.Status = core.PipelineWaiting
+	case core.PipelineStageDeploy:
+		req, err := s.submitDeploy(ctx, run, spec)
+		if err != nil {
+			return s.finishClaimed(ctx, stage, core.PipelineFailed, "cannot submit release: "+err.Error())
+		}
+		stage.Status = core.PipelineRunning
+		if req != nil {
+			stage.Ref = req.ID
+			stage.Status = core.PipelineWaiting
+			s.appendLog(ctx, stage.ID, fmt.Sprintf("release is waiting for approval request %d\n", req.ID))
+		}
+	default:
+		stage.Status = core.PipelineRunning
+	}
+	if err := s.saveStage(ctx, stage, core.PipelinePending); err != nil {
+		return err
+	}
+	if stage.Status == core.PipelineRunning && spec.Type != core.PipelineStageBuild {
+		s.spawn(run, stage, spec)
+	}
+	return nil
+}
+
+// checkStage 检查执行中的阶段是否结束, 返回阶段状态是否发生了变化
+func (s *Service) checkStage(ctx context.Context, stage *core.PipelineStageRun, spec core.PipelineStage) (bool, error) {
+	if spec.Type != core.PipelineStageBuild {
+		if _, ok := s.workers[stage.ID]; ok {
+			return false, nil
+		}
+	}
+	if ok, err := s.claim(ctx, stage); err != nil || !ok {
+		return false, err
+	}
+	if spec.Type != core.PipelineStageBuild {
+		// 部署和验证阶段只在认领阶段的副本进程内执行, 租约过期说明执行过程中该副本重启或者退出了
+		return true, s.finishClaimed(ctx, stage, core.PipelineFailed, "interrupted by apiserver restart")
+	}
+	build, err := s.builds.Get(ctx, stage.Ref)
+	if err != nil {
+		return false, err
+	}
+	if !build.Finished() {
+		if stage.StartTime != nil && time.Since(*stage.StartTime) > s.stageTimeout(spec) {
+			return true, s.finishClaimed(ctx, stage, core.PipelineFailed, "build did not finish before timeout")
+		}
+		return false, nil
+	}
+	s.appendLog(ctx, stage.ID, build.Console)
+	message := fmt.Sprintf("jenkins build #%d finished with status %s", build.Number, build.Status)
+	if build.Message != "" {
+		message += ": " + build.Message
+	}
+	status := core.PipelineFailed
+	if build.Status == core.JenkinsBuildSuccess {
+		status = core.PipelineSucceeded
+	}
+	return true, s.finishClaimed(ctx, stage, status, message)
+}
+
+// submitDeploy 以运行创建人的身份为部署阶段的发布提交审批, 阶段没有发布或者没有匹配的审批规则时返回 nil
+func (s *Service) submitDeploy(ctx context.Context, run *core.PipelineRun, spec core.PipelineStage) (*core.ApprovalRequest, error) {
+	if spec.EnvID <= 0 {
+		return nil, nil
+	}
+	user, err := s.users.Get(ctx, run.CreatorID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load run creator: %w", err)
+	}
+	payload := approval.AppDeployPayload{AppID: run.AppID, EnvID: spec.EnvID, Image: expand(spec.Image, run.Parameters)}
+	return s.approvals.Submit(ctx, core.ApprovalAppDeploy, payload, fmt.Sprintf("pipeline run %d stage %s", run.ID, spec.Name), user)
+}
+
+// checkApproval 检查部署阶段等待的审批请求, 返回阶段状态是否发生了变化
+// 审批通过后发布由审批服务以运行创建人的身份执行, 阶段配置了主机脚本时继续在后台执行脚本
+func (s *Service) checkApproval(ctx context.Context, run *core.PipelineRun, stage *core.PipelineStageRun,
+	spec core.PipelineStage) (bool, error) {
+	if ok, err := s.claim(ctx, stage); err != nil || !ok {
+		return false, err
+	}
+	req, err := s.requests.Get(ctx, stage.Ref)
+	if err != nil {
+		return false, err
+	}
+	switch req.Status {
+	case core.ApprovalPending, core.ApprovalExecuting:
+		return false, nil
+	case core.ApprovalExecuted:
+		s.appendLog(ctx, stage.ID, fmt.Sprintf("approval request %d executed: %s\n", req.ID, req.Result))
+		if len(spec.HostIDs) == 0 {
+			return true, s.finishClaimed(ctx, stage, core.PipelineSucceeded, req.Result)
+		}
+		stage.Status = core.PipelineRunning
+		if err := s.saveStage(ctx, stage, core.PipelineWaiting); err != nil {
+			return false, err
+		}
+		spec.EnvID = 0
+		s.spawn(run, stage, spec)
+		return true, nil
+	}
+	return true, s.finishClaimed(ctx, stage, core.PipelineFailed,
+		fmt.Sprintf("approval request %d %s: %s", req.ID, req.Status, req.Result))
+}
+
+// resume 审批通过后运行回到进行中
+func (s *Service) resume(ctx context.Context, run *core.PipelineRun) error {
+	if run.Status != core.PipelineWaiting {
+		return nil
+	}
+	run.Status = core.PipelineRunning
+	run.Message = ""
+	return s.runs.Update(ctx, run)
+}
+
+// spawn 在后台执行部署或者验证阶段, 调用方需要持有 s.mu
+func (s *Service) spawn(run *core.PipelineRun, stage *core.PipelineStageRun, spec core.PipelineStage) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.stageTimeout(spec))
+	w := &worker{cancel: cancel}
+	s.workers[stage.ID] = w
+	record := *stage
+	go func() {
+		defer cancel()
+		out := newStageLog(s.runs, record.ID)
+		err := s.execute(ctx, run, &record, spec, out)
+		out.Close()
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = errors.New("stage did not finish before timeout")
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		// 阶段已经被取消, 状态由 Cancel 更新
+		if s.workers[record.ID] != w {
+			return
+		}
+		delete(s.workers, record.ID)
+		status, message := core.PipelineSucceeded, ""
+		if err != nil {
+			status, message = core.PipelineFailed, err.Error()
+		}
+		err = s.finishClaimed(context.Background(), &record, status, message)
+		if errors.Is(err, errNotClaimed) {
+			logger.WithLabels("run_id", record.RunID, "stage", record.Name).Warn("stage was claimed by another apiserver, result discarded")
+		} else if err != nil {
+			logger.WithLabels("run_id", record.RunID, "stage", record.Name, "error", err).Error("cannot save stage result")
+		}
+		s.notify()
+	}()
+}
+
+// execute 以运行创建人的身份执行部署或者验证阶段, 输出写入 out
+func (s *Service) execute(ctx context.Context, run *core.PipelineRun, stage *core.PipelineStageRun,
+	spec core.PipelineStage, out io.Writer) error {
+	user, err := s.users.Get(ctx, run.CreatorID)
+	if err != nil {
+		return fmt.Errorf("cannot load run creator: %w", err)
+	}
+	if spec.Type == core.PipelineStageDeploy && spec.EnvID > 0 {
+		image := expand(spec.Image, run.Parameters)
+		fmt.Fprintf(out, "deploying application %d to environment %d, image %q\n", run.AppID, spec.EnvID, image)
+		release, err := s.releaser.Deploy(ctx, run.AppID, spec.EnvID, image, user)
+		if err != nil {
+			return err
+		}
+		stage.Ref = release.ID
+		fmt.Fprintf(out, "release revision %d %s\n", release.Revision, release.Status)
+		if release.Status != core.AppReleaseApplied {
+			return fmt.Errorf("release revision %d failed: %s", release.Revision, release.Message)
+		}
+	}
+	if spec.Type == core.PipelineStageVerify && spec.URL != "" {
+		if err := s.probe(ctx, expand(spec.URL, run.Parameters), spec.ExpectStatus, out); err != nil {
+			return err
+		}
+	}
+	for _, hostID := range spec.HostIDs {
+		err := s.authorizer.Authorize(ctx, user, core.ResourceHost, strconv.FormatInt(hostID, 10), core.ActionCommand)
+		if err != nil {
+			return fmt.Errorf("host %d: %w", hostID, err)
+		}
+		fmt.Fprintf(out, "==> host %d\n", hostID)
+		var timeout time.Duration
+		if deadline, ok := ctx.Deadline(); ok {
+			timeout = time.Until(deadline)
+		}
+		code, err := s.executor.Exec(ctx, hostID, spec.Script, run.Parameters, timeout, out)
+		if err != nil {
+			return fmt.Errorf("host %d: %w", hostID, err)
+		}
+		if code != 0 {
+			return fmt.Errorf("host %d: script exited with code %d", hostID, code)
+		}
+	}
+	return nil
+}
+
+// probe 定期请求 url, 直到返回期望的状态码(默认 200)或者 ctx 结束
+func (s *Service) probe(ctx context.Context, url string, expect int, out io.Writer) error {
+	if expect == 0 {
+		expect = http.StatusOK
+	}
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := s.http.Do(req)
+		if err == nil {
+			_ = resp.Body.Close()
+			fmt.Fprintf(out, "GET %s: %d\n", url, resp.StatusCode)
+			if resp.StatusCode == expect {
+				return nil
+			}
+		} else if ctx.Err() == nil {
+			fmt.Fprintf(out, "GET %s: %v\n", url, err)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s did not return status %d", url, expect)
+		case <-time.After(probeInterval):
+		}
+	}
+}
+
+// finishStage 结束阶段, 用于用户的取消和审批, 不检查阶段由哪个副本认领
+func (s *Service) finishStage(ctx context.Context, stage *core.PipelineStageRun, status, message string) error {
+	now := time.Now()
+	stage.Status = status
+	stage.Message = message
+	stage.FinishTime = &now
+	return s.runs.UpdateStage(ctx, stage)
+}
+
+// finishClaimed 结束本副本认领的阶段, 阶段状态已经改变或者被其他副本接管时返回 errNotClaimed
+func (s *Service) finishClaimed(ctx context.Context, stage *core.PipelineStageRun, status, message string) error {
+	from := stage.Status
+	now := time.Now()
+	stage.Status = status
+	stage.Message = message
+	stage.FinishTime = &now
+	return s.saveStage(ctx, stage, from)
+}
+
+// saveStage 只有阶段仍然为 from 并且由本副本认领时才保存, 否则返回 errNotClaimed
+func (s *Service) saveStage(ctx context.Context, stage *core.PipelineStageRun, from string) error {
+	ok, err := s.runs.TransitionStage(ctx, stage, from)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errNotClaimed
+	}
+	return nil
+}
+
+func (s *Service) finishRun(ctx context.Context, run *core.PipelineRun, status, message string) error {
+	now := time.Now()
+	run.Status = status
+	run.Message = message
+	run.FinishTime = &now
+	logger.WithLabels("run_id", run.ID, "status", status, "message", message).Info("pipeline run finished")
+	if err := s.runs.Update(ctx, run); err != nil {
+		return err
+	}
+	s.publish(run)
+	return nil
+}
+
+// publish 以运行的状态发布事件, 订阅者异步处理事件, 发布副本避免与后续的修改竞争
+func (s *Service) publish(run *core.PipelineRun) {
+	published := *run
+	s.bus.Publish("pipeline.run."+run.Status, &published)
+}
+
+// appendLog 追加一段阶段日志, 日志写入失败不影响阶段的执行
+func (s *Service) appendLog(ctx context.Context, id int64, data string) {
+	if data == "" {
+		return
+	}
+	if err := s.runs.AppendLog(ctx, id, data); err != nil {
+		logger.WithLabels("stage_run_id", id, "error", err).Warn("cannot append stage log")
+	}
+}
+
+func (s *Service) stageTimeout(spec core.PipelineStage) time.Duration {
+	if spec.Timeout > 0 {
+		return time.Duration(spec.Timeout) * time.Second
+	}
+	return s.timeout
+}
+
+func (s *Service) notify() {
+	select {
+	case s.kick <- struct{}{}:
+	default:
+	}
+}
+
+// authorize 检查用户是否可以启动流水线, 管理员总是可以启动
+// 先检查应用的构建权限, 没有时再检查服务树节点的构建权限, 与触发 Jenkins 任务的检查一致
+func (s *Service) authorize(ctx context.Context, p *core.Pipeline, user *core.User) error {
+	if user == nil {
+		return core.ErrForbidden
+	}
+	if user.IsAdmin {
+		return nil
+	}
+	err := core.ErrForbidden
+	if p.AppID > 0 {
+		err = s.authorizer.Authorize(ctx, user, core.ResourceApplication, strconv.FormatInt(p.AppID, 10), core.ActionBuild)
+	}
+	if errors.Is(err, core.ErrForbidden) && p.ServiceNodeID > 0 {
+		err = s.authorizer.Authorize(ctx, user, core.ResourceServiceNode,
+			strconv.FormatInt(p.ServiceNodeID, 10), core.ActionBuild)
+	}
+	return err
+}
+
+// canApprove 判断用户是否是运行当前阶段的审批人, 管理员总是可以审批
+// 与审批请求一致, 运行的创建人不能审批自己的运行, 管理员也不例外
+func canApprove(run *core.PipelineRun, user *core.User) bool {
+	if user.ID == run.CreatorID {
+		return false
+	}
+	if user.IsAdmin {
+		return true
+	}
+	for _, name := range run.Stages[run.Current].Approvers {
+		if name == user.UserName {
+			return true
+		}
+	}
+	return false
+}
+
+var paramPattern = regexp.MustCompile(`\$\{(\w+)\}`)
+
+// expand 将 s 中的 ${KEY} 替换为运行参数, 没有对应参数时保持原样
+func expand(s string, params core.StringMap) string {
+	return paramPattern.ReplaceAllStringFunc(s, func(match string) string {
+		if v, ok := params[match[2:len(match)-1]]; ok {
+			return v
+		}
+		return match
+	})
+}
+
+func expandMap(in, params core.StringMap) map[string]string {
+	out := make(map[string]string, len(in))
+	for k, v := range in {
+		out[k] = expand(v, params)
+	}
+	return out
+}
@@ -0,0 +1,491 @@
+package pipeline
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/eventbus"
+)
+
+type fakePipelineDao struct {
+	core.PipelineDao
+	pipeline *core.Pipeline
+}
+
+func (f *fakePipelineDao) Get(_ context.Context, id int64) (*core.Pipeline, error) {
+	if f.pipeline.ID != id {
+		return nil, sql.ErrNoRows
+	}
+	return f.pipeline, nil
+}
+
+type fakeRunDao struct {
+	mu     sync.Mutex
+	run    core.PipelineRun
+	stages []core.PipelineStageRun
+	logs   map[int64]string
+}
+
+func (f *fakeRunDao) Get(_ context.Context, id int64) (*core.PipelineRun, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	run := f.run
+	return &run, nil
+}
+
+func (f *fakeRunDao) List(context.Context, map[string]interface{}) ([]*core.PipelineRun, error) {
+	return nil, nil
+}
+
+func (f *fakeRunDao) Count(context.Context, map[string]interface{}) (int64, error) { return 0, nil }
+
+func (f *fakeRunDao) ListActive(ctx context.Context) ([]*core.PipelineRun, error) {
+	run, _ := f.Get(ctx, f.run.ID)
+	if run.Status != core.PipelineRunning && run.Status != core.PipelineWaiting {
+		return nil, nil
+	}
+	return []*core.PipelineRun{run}, nil
+}
+
+func (f *fakeRunDao) Create(_ context.Context, run *core.PipelineRun, stages []*core.PipelineStageRun) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	run.ID = 1
+	f.run = *run
+	f.logs = map[int64]string{}
+	for i, stage := range stages {
+		stage.ID = int64(i + 1)
+		stage.RunID = run.ID
+		f.stages = append(f.stages, *stage)
+	}
+	return run.ID, nil
+}
+
+func (f *fakeRunDao) Update(_ context.Context, run *core.PipelineRun) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.run = *run
+	return nil
+}
+
+func (f *fakeRunDao) ListStages(context.Context, int64) ([]*core.PipelineStageRun, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]*core.PipelineStageRun, 0, len(f.stages))
+	for _, stage := range f.stages {
+		stage := stage
+		out = append(out, &stage)
+	}
+	return out, nil
+}
+
+func (f *fakeRunDao) GetStage(_ context.Context, _ int64, index int) (*core.PipelineStageRun, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stage := f.stages[index]
+	stage.Log = f.logs[stage.ID]
+	return &stage, nil
+}
+
+func (f *fakeRunDao) UpdateStage(_ context.Context, stage *core.PipelineStageRun) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stages[stage.Stage] = *stage
+	return nil
+}
+
+func (f *fakeRunDao) ClaimStage(_ context.Context, id int64, status, owner string, now, until time.Time) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.stages {
+		stage := &f.stages[i]
+		if stage.ID != id || stage.Status != status {
+			continue
+		}
+		if stage.Owner != owner && stage.LeaseTime != nil && !stage.LeaseTime.Before(now) {
+			return false, nil
+		}
+		stage.Owner, stage.LeaseTime = owner, &until
+		return true, nil
+	}
+	return false, nil
+}
+
+func (f *fakeRunDao) TransitionStage(_ context.Context, stage *core.PipelineStageRun, from string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	current := f.stages[stage.Stage]
+	if current.Status != from || current.Owner != stage.Owner {
+		return false, nil
+	}
+	f.stages[stage.Stage] = *stage
+	return true, nil
+}
+
+func (f *fakeRunDao) AppendLog(_ context.Context, id int64, data string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.logs[id] += data
+	return nil
+}
+
+func (f *fakeRunDao) stage(index int) core.PipelineStageRun {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.stages[index]
+}
+
+type fakeBuildDao struct {
+	core.JenkinsBuildDao
+	mu    sync.Mutex
+	build core.JenkinsBuild
+}
+
+func (f *fakeBuildDao) Get(context.Context, int64) (*core.JenkinsBuild, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	build := f.build
+	return &build, nil
+}
+
+func (f *fakeBuildDao) Trigger(_ context.Context, jobID int64, params map[string]string, user *core.User) (*core.JenkinsBuild, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.build = core.JenkinsBuild{ID: 7, JobID: jobID, Parameters: params, Status: core.JenkinsBuildQueued, Creator: user.UserName}
+	build := f.build
+	return &build, nil
+}
+
+// countingBuilder 统计触发构建的次数, 触发时稍作等待以便并发的副本同时推进
+type countingBuilder struct {
+	*fakeBuildDao
+	mu     sync.Mutex
+	builds int
+}
+
+func (b *countingBuilder) Trigger(ctx context.Context, jobID int64, params map[string]string, user *core.User) (*core.JenkinsBuild, error) {
+	time.Sleep(10 * time.Millisecond)
+	b.mu.Lock()
+	b.builds++
+	b.mu.Unlock()
+	return b.fakeBuildDao.Trigger(ctx, jobID, params, user)
+}
+
+func (b *countingBuilder) count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.builds
+}
+
+type fakeUserDao struct {
+	core.UserDao
+}
+
+func (fakeUserDao) Get(_ context.Context, id int64) (*core.User, error) {
+	return &core.User{ID: id, UserName: "dev"}, nil
+}
+
+type allowAll struct{}
+
+func (allowAll) Authorize(context.Context, *core.User, string, string, string) error { return nil }
+
+// grants 只允许 map 中的资源类型/资源ID/操作
+type grants map[string]bool
+
+func (g grants) Authorize(_ context.Context, _ *core.User, resourceType, resourceID, action string) error {
+	if g[resourceType+"/"+resourceID+"/"+action] {
+		return nil
+	}
+	return core.ErrForbidden
+}
+
+type fakeExecutor struct {
+	mu    sync.Mutex
+	calls []int64
+	code  int
+}
+
+func (f *fakeExecutor) Exec(_ context.Context, hostID int64, script string, env map[string]string,
+	_ time.Duration, out io.Writer) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, hostID)
+	_, _ = io.WriteString(out, "deploying "+env["VERSION"]+"\n")
+	return f.code, nil
+}
+
+// fakeApprover 对所有发布创建审批请求, 同时作为审批请求的 DAO
+type fakeApprover struct {
+	core.ApprovalRequestDao
+	mu  sync.Mutex
+	req core.ApprovalRequest
+}
+
+func (f *fakeApprover) Submit(_ context.Context, action string, payload interface{}, reason string,
+	user *core.User) (*core.ApprovalRequest, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.req = core.ApprovalRequest{ID: 21, Action: action, Reason: reason, Requester: user.UserName, Status: core.ApprovalPending}
+	req := f.req
+	return &req, nil
+}
+
+func (f *fakeApprover) Cancel(_ context.Context, id int64, _ *core.User) (*core.ApprovalRequest, error) {
+	return f.finish(core.ApprovalCanceled, ""), nil
+}
+
+func (f *fakeApprover) Get(context.Context, int64) (*core.ApprovalRequest, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	req := f.req
+	return &req, nil
+}
+
+func (f *fakeApprover) finish(status, result string) *core.ApprovalRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.req.Status, f.req.Result = status, result
+	req := f.req
+	return &req
+}
+
+func newTestService(runs *fakeRunDao, builds *fakeBuildDao, executor *fakeExecutor, url string) *Service {
+	pipeline := &core.Pipeline{ID: 3, Name: "api", AppID: 5, Stages: core.PipelineStages{
+		{Name: "build", Type: core.PipelineStageBuild, JobID: 2, Parameters: core.StringMap{"TAG": "${VERSION}"}},
+		{Name: "approve", Type: core.PipelineStageApproval, Approvers: []string{"lead"}},
+		{Name: "deploy", Type: core.PipelineStageDeploy, HostIDs: []int64{11, 12}, Script: "deploy.sh"},
+		{Name: "verify", Type: core.PipelineStageVerify, URL: url + "/health", Timeout: 5},
+	}}
+	approvals := &fakeApprover{}
+	return &Service{
+		pipelines:  &fakePipelineDao{pipeline: pipeline},
+		runs:       runs,
+		builds:     builds,
+		users:      fakeUserDao{},
+		requests:   approvals,
+		authorizer: allowAll{},
+		bus:        eventbus.ProvideBus(),
+		builder:    builds,
+		approvals:  approvals,
+		executor:   executor,
+		http:       &http.Client{Timeout: time.Second},
+		interval:   time.Second,
+		timeout:    time.Minute,
+		lease:      time.Minute,
+		owner:      "apiserver-1",
+		kick:       make(chan struct{}, 1),
+		workers:    make(map[int64]*worker),
+	}
+}
+
+// waitStage 推进运行直到阶段进入期望的状态
+func waitStage(t *testing.T, s *Service, runs *fakeRunDao, index int, status string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if err := s.Sync(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if runs.stage(index).Status == status {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("stage %d is %s, want %s", index, runs.stage(index).Status, status)
+}
+
+func TestPipelineRun(t *testing.T) {
+	health := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer health.Close()
+
+	ctx := context.Background()
+	runs, builds, executor := &fakeRunDao{}, &fakeBuildDao{}, &fakeExecutor{code: 1}
+	s := newTestService(runs, builds, executor, health.URL)
+	dev := &core.User{ID: 9, UserName: "dev"}
+	run, err := s.Start(ctx, 3, map[string]string{"VERSION": "v1.2.0"}, dev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitStage(t, s, runs, 0, core.PipelineRunning)
+	if builds.build.Parameters["TAG"] != "v1.2.0" {
+		t.Fatalf("build parameters = %v", builds.build.Parameters)
+	}
+	builds.mu.Lock()
+	builds.build.Status, builds.build.Number, builds.build.Console = core.JenkinsBuildSuccess, 42, "BUILD OK\n"
+	builds.mu.Unlock()
+	waitStage(t, s, runs, 1, core.PipelineWaiting)
+	if run, _ = runs.Get(ctx, run.ID); run.Status != core.PipelineWaiting {
+		t.Fatalf("run status = %s, want waiting", run.Status)
+	}
+	if _, err := s.Approve(ctx, run.ID, dev, true, ""); err != core.ErrForbidden {
+		t.Fatalf("approve by non-approver: %v", err)
+	}
+	// 创建人即使是审批人或者管理员也不能审批自己的运行
+	runs.mu.Lock()
+	runs.run.Stages[1].Approvers = []string{"lead", "dev"}
+	runs.mu.Unlock()
+	for _, creator := range []*core.User{dev, {ID: 9, UserName: "dev", IsAdmin: true}} {
+		if _, err := s.Approve(ctx, run.ID, creator, true, ""); err != core.ErrForbidden {
+			t.Fatalf("approve by creator: %v", err)
+		}
+	}
+	if _, err := s.Approve(ctx, run.ID, &core.User{UserName: "lead"}, true, "ship it"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 第一台主机上的脚本失败, 运行失败后重试部署阶段
+	waitStage(t, s, runs, 2, core.PipelineFailed)
+	if run, _ = runs.Get(ctx, run.ID); run.Status != core.PipelineFailed || run.Current != 2 {
+		t.Fatalf("run = %s at stage %d, want failed at stage 2", run.Status, run.Current)
+	}
+	executor.mu.Lock()
+	executor.code = 0
+	executor.mu.Unlock()
+	if _, err := s.Retry(ctx, run.ID, dev); err != nil {
+		t.Fatal(err)
+	}
+	waitStage(t, s, runs, 3, core.PipelineSucceeded)
+	if err := s.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if run, _ = runs.Get(ctx, run.ID); run.Status != core.PipelineSucceeded || run.FinishTime == nil {
+		t.Fatalf("run status = %s, want succeeded", run.Status)
+	}
+	if got := executor.calls; len(got) != 3 || got[0] != 11 || got[1] != 11 || got[2] != 12 {
+		t.Errorf("exec calls = %v", got)
+	}
+	deploy, _ := runs.GetStage(ctx, run.ID, 2)
+	if deploy.Attempt != 2 || strings.Count(deploy.Log, "deploying v1.2.0") != 3 {
+		t.Errorf("deploy stage attempt %d, log %q", deploy.Attempt, deploy.Log)
+	}
+	if build, _ := runs.GetStage(ctx, run.ID, 0); !strings.Contains(build.Log, "BUILD OK") {
+		t.Errorf("build log %q does not contain console", build.Log)
+	}
+}
+
+func TestInterruptedStage(t *testing.T) {
+	ctx := context.Background()
+	runs := &fakeRunDao{}
+	s := newTestService(runs, &fakeBuildDao{}, &fakeExecutor{}, "http://127.0.0.1:1")
+	run, err := s.Start(ctx, 3, nil, &core.User{ID: 9, UserName: "dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 模拟执行部署阶段的副本退出: 阶段在数据库中是 running, 但是没有副本持有对应的 worker
+	for i := 0; i < 2; i++ {
+		stage := runs.stage(i)
+		stage.Status = core.PipelineSucceeded
+		_ = runs.UpdateStage(ctx, &stage)
+	}
+	lease := time.Now().Add(time.Minute)
+	stage := runs.stage(2)
+	stage.Status, stage.Owner, stage.LeaseTime = core.PipelineRunning, "apiserver-0", &lease
+	_ = runs.UpdateStage(ctx, &stage)
+	run.Current = 2
+	_ = runs.Update(ctx, run)
+
+	// 租约过期之前其他副本不能接管
+	other := newTestService(runs, &fakeBuildDao{}, &fakeExecutor{}, "http://127.0.0.1:1")
+	other.owner = "apiserver-2"
+	if err := other.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := runs.stage(2); got.Status != core.PipelineRunning || got.Owner != "apiserver-0" {
+		t.Fatalf("stage = %s owned by %s, want running owned by apiserver-0", got.Status, got.Owner)
+	}
+
+	expired := time.Now().Add(-time.Second)
+	stage = runs.stage(2)
+	stage.LeaseTime = &expired
+	_ = runs.UpdateStage(ctx, &stage)
+	if err := other.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := runs.stage(2); got.Status != core.PipelineFailed || got.Message != "interrupted by apiserver restart" {
+		t.Fatalf("stage = %s %q, want failed after lease expired", got.Status, got.Message)
+	}
+	if run, _ = runs.Get(ctx, run.ID); run.Status != core.PipelineFailed {
+		t.Fatalf("run status = %s, want failed", run.Status)
+	}
+}
+
+func TestClaimStage(t *testing.T) {
+	ctx := context.Background()
+	runs, builds := &fakeRunDao{}, &countingBuilder{fakeBuildDao: &fakeBuildDao{}}
+	first := newTestService(runs, builds.fakeBuildDao, &fakeExecutor{}, "http://127.0.0.1:1")
+	second := newTestService(runs, builds.fakeBuildDao, &fakeExecutor{}, "http://127.0.0.1:1")
+	second.owner = "apiserver-2"
+	first.builder, second.builder = builds, builds
+	if _, err := first.Start(ctx, 3, nil, &core.User{ID: 9, UserName: "dev"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 两个副本同时推进同一个运行, 只有认领到阶段的副本触发构建
+	var wg sync.WaitGroup
+	for _, s := range []*Service{first, second} {
+		wg.Add(1)
+		go func(s *Service) {
+			defer wg.Done()
+			_ = s.Sync(ctx)
+		}(s)
+	}
+	wg.Wait()
+	if builds.count() != 1 {
+		t.Fatalf("build triggered %d times, want once", builds.count())
+	}
+	if got := runs.stage(0); got.Status != core.PipelineRunning || got.Ref != 7 {
+		t.Fatalf("stage = %s ref %d, want running build 7", got.Status, got.Ref)
+	}
+}
+
+func TestStartPermission(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(&fakeRunDao{}, &fakeBuildDao{}, &fakeExecutor{}, "")
+	s.pipelines.(*fakePipelineDao).pipeline.ServiceNodeID = 30
+	dev := &core.User{ID: 9, UserName: "dev"}
+	cases := []struct {
+		name   string
+		grants grants
+		user   *core.User
+		err    error
+	}{
+		{"no grant", grants{}, dev, core.ErrForbidden},
+		{"other action", grants{"application/5/terminal": true}, dev, core.ErrForbidden},
+		{"app grant", grants{"application/5/build": true}, dev, nil},
+		{"service node grant", grants{"service_node/30/build": true}, dev, nil},
+		{"admin", grants{}, &core.User{ID: 1, UserName: "admin", IsAdmin: true}, nil},
+	}
+	for _, c := range cases {
+		s.authorizer = c.grants
+		if _, err := s.Start(ctx, 3, nil, c.user); !errors.Is(err, c.err) {
+			t.Errorf("%s: err = %v, want %v", c.name, err, c.err)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	params := core.StringMap{"VERSION": "v1", "ENV": "prod"}
+	cases := map[string]string{
+		"registry/api:${VERSION}":   "registry/api:v1",
+		"${ENV}-${VERSION}":         "prod-v1",
+		"${MISSING}/$VERSION":       "${MISSING}/$VERSION",
+		"http://api.${ENV}.local/x": "http://api.prod.local/x",
+	}
+	for in, want := range cases {
+		if got := expand(in, params); got != want {
+			t.Errorf("expand(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/kube"
+)
+
+// 内置的后台任务类型
+const (
+	// TaskKubeNodeSync 将一个 kubernetes 集群的节点同步为 CMDB 中的主机
+	TaskKubeNodeSync = "kube_node_sync"
+	// TaskHostImport 批量导入主机, 实例ID已经存在的主机跳过
+	TaskHostImport = "host_import"
+	// TaskFilePush 通过 agent 将文件写入一组主机
+	TaskFilePush = "file_push"
+)
+
+const (
+	// maxImportHosts 一次最多导入的主机数量
+	maxImportHosts = 5000
+	// maxFileSize 分发的文件大小上限, 文件内容通过环境变量传给 agent 执行的脚本
+	maxFileSize = 64 << 10
+)
+
+// Executor 在主机上执行脚本
+type Executor interface {
+	Exec(ctx context.Context, hostID int64, script string, env map[string]string,
+		timeout time.Duration, out io.Writer) (int, error)
+}
+
+// NodeSyncer 同步一个集群的节点
+type NodeSyncer interface {
+	Sync(ctx context.Context, clusterID int64) (*kube.NodeSyncResult, error)
+}
+
+// NodeSyncPayload kube_node_sync 任务的参数
+type NodeSyncPayload struct {
+	ClusterID int64 `json:"cluster_id"`
+}
+
+type nodeSyncHandler struct {
+	nodes NodeSyncer
+}
+
+func (h *nodeSyncHandler) Validate(payload json.RawMessage) error {
+	in := new(NodeSyncPayload)
+	if err := json.Unmarshal(payload, in); err != nil {
+		return err
+	}
+	if in.ClusterID <= 0 {
+		return errors.New("cluster_id is required")
+	}
+	return nil
+}
+
+func (h *nodeSyncHandler) Handle(ctx context.Context, payload json.RawMessage, progress Progress) (interface{}, error) {
+	in := new(NodeSyncPayload)
+	if err := json.Unmarshal(payload, in); err != nil {
+		return nil, fmt.Errorf("%w: %v", core.ErrInvalidTask, err)
+	}
+	progress(0, fmt.Sprintf("syncing nodes of cluster %d", in.ClusterID))
+	result, err := h.nodes.Sync(ctx, in.ClusterID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: cluster %d not found", core.ErrInvalidTask, in.ClusterID)
+	}
+	return result, err
+}
+
+// HostImportPayload host_import 任务的参数
+type HostImportPayload struct {
+	Hosts []core.HostInstance `json:"hosts"`
+}
+
+// HostImportResult host_import 任务的结果, Failed 的键是主机在参数中的下标
+type HostImportResult struct {
+	Created int               `json:"created"`
+	Skipped int               `json:"skipped"`
+	Failed  map[string]string `json:"failed"`
+}
+
+type hostImportHandler struct {
+	hosts core.HostInstanceDao
+}
+
+func (h *hostImportHandler) Validate(payload json.RawMessage) error {
+	in := new(HostImportPayload)
+	if err := json.Unmarshal(payload, in); err != nil {
+		return err
+	}
+	if len(in.Hosts) == 0 || len(in.Hosts) > maxImportHosts {
+		return fmt.Errorf("hosts must contain 1 to %d hosts", maxImportHosts)
+	}
+	for i, host := range in.Hosts {
+		if host.InstanceID == "" || host.HostName == "" {
+			return fmt.Errorf("hosts[%d]: instance_id and host_name are required", i)
+		}
+	}
+	return nil
+}
+
+// Handle 依次导入每台主机, 实例ID已经存在时跳过, 重试时已经导入的主机也会被跳过
+func (h *hostImportHandler) Handle(ctx context.Context, payload json.RawMessage, progress Progress) (interface{}, error) {
+	in := new(HostImportPayload)
+	if err := json.Unmarshal(payload, in); err != nil {
+		return nil, fmt.Errorf("%w: %v", core.ErrInvalidTask, err)
+	}
+	result := &HostImportResult{Failed: map[string]string{}}
+	for i := range in.Hosts {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		host := &in.Hosts[i]
+		progress(i*100/len(in.Hosts), fmt.Sprintf("importing %d/%d hosts", i+1, len(in.Hosts)))
+		_, err := h.hosts.GetByInstance(ctx, host.InstanceID)
+		if err == nil {
+			result.Skipped++
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return result, err
+		}
+		host.ID = 0
+		if _, err := h.hosts.Create(ctx, host); err != nil {
+			result.Failed[strconv.Itoa(i)] = err.Error()
+			continue
+		}
+		result.Created++
+	}
+	if len(result.Failed) > 0 {
+		return result, fmt.Errorf("%d of %d hosts failed to import", len(result.Failed), len(in.Hosts))
+	}
+	return result, nil
+}
+
+// FilePushPayload file_push 任务的参数, Content 在 JSON 中为 base64 编码, Mode 为八进制的文件权限, 默认 0644
+// 目标主机由 HostIDs 和 KubeClusterID 共同决定: 指定的主机加上集群中的所有节点
+type FilePushPayload struct {
+	HostIDs       []int64 `json:"host_ids"`
+	KubeClusterID int64   `json:"kube_cluster_id"`
+	Path          string  `json:"path"`
+	Content       []byte  `json:"content"`
+	Mode          string  `json:"mode"`
+}
+
+// FilePushResult file_push 任务的结果, Failed 的键是主机ID
+type FilePushResult struct {
+	Succeeded []int64          `json:"succeeded"`
+	Failed    map[int64]string `json:"failed"`
+}
+
+// filePushScript 先写入临时文件再改名, 避免目标文件只写了一半
+const filePushScript = `set -e
+mkdir -p "$(dirname "$EASYNETES_FILE_PATH")"
+tmp="$EASYNETES_FILE_PATH.easynetes-tmp"
+printf '%s' "$EASYNETES_FILE_CONTENT" | base64 -d > "$tmp"
+chmod "$EASYNETES_FILE_MODE" "$tmp"
+mv -f "$tmp" "$EASYNETES_FILE_PATH"
+`
+
+type filePushHandler struct {
+	hosts    core.HostInstanceDao
+	executor Executor
+}
+
+func (h *filePushHandler) Validate(payload json.RawMessage) error {
+	in := new(FilePushPayload)
+	if err := json.Unmarshal(payload, in); err != nil {
+		return err
+	}
+	if len(in.HostIDs) == 0 && in.KubeClusterID == 0 {
+		return errors.New("host_ids or kube_cluster_id is required")
+	}
+	if !path.IsAbs(in.Path) || path.Clean(in.Path) != in.Path || in.Path == "/" {
+		return fmt.Errorf("path %q must be a clean absolute file path", in.Path)
+	}
+	if len(in.Content) > maxFileSize {
+		return fmt.Errorf("content must not exceed %d bytes", maxFileSize)
+	}
+	if in.Mode != "" {
+		if mode, err := strconv.ParseUint(in.Mode, 8, 32); err != nil || mode > 0o7777 {
+			return fmt.Errorf("mode %q must be an octal file mode", in.Mode)
+		}
+	}
+	return nil
+}
+
+// Handle 依次将文件写入每台主机, 单台主机失败不影响其他主机, 有主机失败时任务失败并按重试策略重新写入所有主机
+func (h *filePushHandler) Handle(ctx context.Context, payload json.RawMessage, progress Progress) (interface{}, error) {
+	in := new(FilePushPayload)
+	if err := json.Unmarshal(payload, in); err != nil {
+		return nil, fmt.Errorf("%w: %v", core.ErrInvalidTask, err)
+	}
+	ids := append([]int64(nil), in.HostIDs...)
+	if in.KubeClusterID > 0 {
+		nodes, err := h.hosts.List(ctx, map[string]interface{}{"kube_cluster_id": in.KubeClusterID})
+		if err != nil {
+			return nil, err
+		}
+		for _, node := range nodes {
+			ids = append(ids, node.ID)
+		}
+	}
+	ids = unique(ids)
+	mode := in.Mode
+	if mode == "" {
+		mode = "0644"
+	}
+	env := map[string]string{
+		"EASYNETES_FILE_PATH":    in.Path,
+		"EASYNETES_FILE_CONTENT": base64.StdEncoding.EncodeToString(in.Content),
+		"EASYNETES_FILE_MODE":    mode,
+	}
+	result := &FilePushResult{Succeeded: []int64{}, Failed: map[int64]string{}}
+	for i, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		progress(i*100/len(ids), fmt.Sprintf("pushing %s to host %d (%d/%d)", in.Path, id, i+1, len(ids)))
+		out := &limitWriter{limit: 1 << 10}
+		code, err := h.executor.Exec(ctx, id, filePushScript, env, 0, out)
+		switch {
+		case err != nil:
+			result.Failed[id] = err.Error()
+		case code != 0:
+			result.Failed[id] = fmt.Sprintf("exited with code %d: %s", code, out)
+		default:
+			result.Succeeded = append(result.Succeeded, id)
+		}
+	}
+	if len(result.Failed) > 0 {
+		return result, fmt.Errorf("%d of %d hosts failed", len(result.Failed), len(ids))
+	}
+	return result, nil
+}
+
+// unique 去掉重复的ID, 保持原有的顺序
+func unique(ids []int64) []int64 {
+	seen := make(map[int64]bool, len(ids))
+	out := ids[:0]
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// limitWriter 只保留最先写入的 limit 个字节, 用于记录脚本的错误输出, 可以被多个 goroutine 同时写入
+type limitWriter struct {
+	mu    sync.Mutex
+	limit int
+	buf   []byte
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if n := w.limit - len(w.buf); n > 0 {
+		w.buf = append(w.buf, p[:min(n, len(p))]...)
+	}
+	return len(p), nil
+}
+
+func (w *limitWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return string(w.buf)
+}
@@ -0,0 +1,344 @@
+// Package queue 基于数据库的后台任务队列, 所有副本共享同一张任务表
+// 耗时的 API 操作创建任务后立即返回, 由各个副本的 worker 认领并执行, 失败时按指数退避重试
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/eventbus"
+	"github.com/bloodsteel/easynetes/internal/service/hostexec"
+	"github.com/bloodsteel/easynetes/internal/service/kube"
+	"github.com/bloodsteel/easynetes/pkg/config"
+	"github.com/bloodsteel/easynetes/pkg/log"
+)
+
+var logger = log.RegisterScope("queue", "background task queue", 0)
+
+const (
+	// maxBackoff 重试间隔的上限
+	maxBackoff = time.Hour
+	// flushInterval 执行中的任务保存进度的间隔
+	flushInterval = time.Second
+)
+
+// Progress 报告任务的进度, percent 为 0-100
+type Progress func(percent int, message string)
+
+// Handler 一种后台任务的类型, payload 是任务的 JSON 参数
+type Handler interface {
+	// Validate 创建任务时校验参数
+	Validate(payload json.RawMessage) error
+	// Handle 执行任务, 返回的结果保存在任务中, 失败时也可以返回部分结果
+	// 返回 core.ErrInvalidTask 时不再重试
+	Handle(ctx context.Context, payload json.RawMessage, progress Progress) (interface{}, error)
+}
+
+// Queue 创建后台任务, 并在后台认领和执行任务
+type Queue struct {
+	tasks       core.TaskDao
+	bus         *eventbus.Bus
+	handlers    map[string]Handler
+	worker      string
+	workers     int
+	interval    time.Duration
+	visibility  time.Duration
+	backoff     time.Duration
+	maxAttempts int
+	running     atomic.Int32
+	kick        chan struct{}
+	wg          sync.WaitGroup
+}
+
+// ProvideQueue is a Wire provider
+func ProvideQueue(
+	tasks core.TaskDao,
+	hosts core.HostInstanceDao,
+	bus *eventbus.Bus,
+	executor *hostexec.Executor,
+	nodes *kube.NodeSync,
+	cfg *config.Config,
+) *Queue {
+	hostname, _ := os.Hostname()
+	return &Queue{
+		tasks: tasks,
+		bus:   bus,
+		handlers: map[string]Handler{
+			TaskKubeNodeSync: &nodeSyncHandler{nodes: nodes},
+			TaskHostImport:   &hostImportHandler{hosts: hosts},
+			TaskFilePush:     &filePushHandler{hosts: hosts, executor: executor},
+		},
+		worker:      fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		workers:     cfg.Queue.Workers,
+		interval:    cfg.Queue.Interval * time.Second,
+		visibility:  cfg.Queue.Visibility * time.Second,
+		backoff:     cfg.Queue.Backoff * time.Second,
+		maxAttempts: cfg.Queue.MaxAttempts,
+		kick:        make(chan struct{}, 1),
+	}
+}
+
+// Handle 注册一种后台任务类型, 需要在 Run 之前调用
+// 依赖队列的服务在创建时注册自己的任务类型, 避免队列依赖这些服务
+func (q *Queue) Handle(taskType string, handler Handler) {
+	q.handlers[taskType] = handler
+}
+
+// Types 返回所有可用的任务类型
+func (q *Queue) Types() []string {
+	out := make([]string, 0, len(q.handlers))
+	for name := range q.handlers {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Enqueue 校验参数后创建一个后台任务, 返回 pending 状态的任务
+func (q *Queue) Enqueue(ctx context.Context, taskType string, payload interface{}, priority int, creator string) (*core.Task, error) {
+	handler, ok := q.handlers[taskType]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown task type %q", core.ErrInvalidTask, taskType)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", core.ErrInvalidTask, err)
+	}
+	if err := handler.Validate(data); err != nil {
+		return nil, fmt.Errorf("%w: %v", core.ErrInvalidTask, err)
+	}
+	task := &core.Task{
+		Type:        taskType,
+		Payload:     data,
+		Priority:    priority,
+		Status:      core.TaskPending,
+		MaxAttempts: q.maxAttempts,
+		Creator:     creator,
+		VisibleTime: time.Now(),
+	}
+	if _, err := q.tasks.Create(ctx, task); err != nil {
+		return nil, err
+	}
+	q.wake()
+	return task, nil
+}
+
+// Run 定期认领可以执行的任务, 同时执行的任务不超过配置的 worker 数量, ctx 结束时等待进行中的任务退出
+func (q *Queue) Run(ctx context.Context) error {
+	ticker := time.NewTicker(q.interval)
+	defer ticker.Stop()
+	defer q.wg.Wait()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-q.kick:
+		}
+		q.Poll(ctx)
+	}
+}
+
+// Poll 按优先级认领最多空闲 worker 数量的任务并在后台执行
+func (q *Queue) Poll(ctx context.Context) {
+	free := q.workers - int(q.running.Load())
+	if free <= 0 {
+		return
+	}
+	now := time.Now()
+	claimable, err := q.tasks.ListClaimable(ctx, now, free)
+	if err != nil {
+		logger.WithLabels("error", err).Error("cannot list claimable tasks")
+		return
+	}
+	for _, task := range claimable {
+		ok, err := q.tasks.Claim(ctx, task.ID, q.worker, now, now.Add(q.visibility))
+		if err != nil {
+			logger.WithLabels("task_id", task.ID, "error", err).Error("cannot claim task")
+			continue
+		}
+		if !ok {
+			continue
+		}
+		q.running.Add(1)
+		q.wg.Add(1)
+		go func(id int64) {
+			defer q.wg.Done()
+			defer q.wake()
+			defer q.running.Add(-1)
+			q.execute(ctx, id)
+		}(task.ID)
+	}
+}
+
+// execute 执行一个已经认领的任务, 执行过程中定期保存进度并续期, 续期失败说明任务已经被其他副本认领, 停止执行
+func (q *Queue) execute(ctx context.Context, id int64) {
+	// 服务退出时 ctx 已经结束, 仍然需要保存执行结果
+	saveCtx := context.WithoutCancel(ctx)
+	task, err := q.tasks.Get(saveCtx, id)
+	if err != nil {
+		logger.WithLabels("task_id", id, "error", err).Error("cannot load task")
+		return
+	}
+	if task.Worker != q.worker {
+		return
+	}
+	if task.Attempts > task.MaxAttempts {
+		// 最后一次执行的副本在可见性超时之前退出了
+		task.Attempts = task.MaxAttempts
+		q.finish(saveCtx, task, nil, errors.New("task was interrupted and has no attempts left"))
+		return
+	}
+	handler, ok := q.handlers[task.Type]
+	if !ok {
+		q.finish(saveCtx, task, nil, fmt.Errorf("%w: unknown task type %q", core.ErrInvalidTask, task.Type))
+		return
+	}
+	logger.WithLabels("task_id", task.ID, "type", task.Type, "attempt", task.Attempts).Info("task started")
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu    sync.Mutex
+		dirty bool
+		lost  bool
+	)
+	progress := func(percent int, message string) {
+		mu.Lock()
+		defer mu.Unlock()
+		task.Progress = min(max(percent, 0), 100)
+		task.Message = message
+		dirty = true
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		touched := time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			mu.Lock()
+			if !dirty && time.Since(touched) < q.visibility/3 {
+				mu.Unlock()
+				continue
+			}
+			touched = time.Now()
+			task.VisibleTime = touched.Add(q.visibility)
+			dirty = false
+			current := *task
+			mu.Unlock()
+			ok, err := q.tasks.Touch(saveCtx, &current)
+			if err != nil {
+				logger.WithLabels("task_id", task.ID, "error", err).Warn("cannot save task progress")
+				continue
+			}
+			if !ok {
+				mu.Lock()
+				lost = true
+				mu.Unlock()
+				cancel()
+				return
+			}
+		}
+	}()
+
+	result, err := handler.Handle(runCtx, task.Payload, progress)
+	close(done)
+	<-stopped
+	if lost {
+		logger.WithLabels("task_id", task.ID, "type", task.Type).Warn("task was claimed by another worker, result discarded")
+		return
+	}
+	if ctx.Err() != nil {
+		q.release(saveCtx, task)
+		return
+	}
+	q.finish(saveCtx, task, result, err)
+}
+
+// finish 保存执行结果, 失败并且还有剩余次数时等待重试, 任务结束时发布 task.<status> 事件
+func (q *Queue) finish(ctx context.Context, task *core.Task, result interface{}, err error) {
+	now := time.Now()
+	task.Result = nil
+	if result != nil {
+		data, marshalErr := json.Marshal(result)
+		if marshalErr != nil {
+			logger.WithLabels("task_id", task.ID, "error", marshalErr).Error("cannot marshal task result")
+		}
+		task.Result = data
+	}
+	task.Error = ""
+	task.VisibleTime = now
+	switch {
+	case err == nil:
+		task.Status = core.TaskSucceeded
+		task.Progress = 100
+		task.FinishTime = &now
+	case errors.Is(err, core.ErrInvalidTask) || task.Attempts >= task.MaxAttempts:
+		task.Status = core.TaskFailed
+		task.Error = err.Error()
+		task.FinishTime = &now
+	default:
+		task.Status = core.TaskPending
+		task.Error = err.Error()
+		task.VisibleTime = now.Add(q.retryAfter(task.Attempts))
+	}
+	ok, saveErr := q.tasks.Finish(ctx, task)
+	if saveErr != nil {
+		logger.WithLabels("task_id", task.ID, "error", saveErr).Error("cannot save task")
+		return
+	}
+	if !ok {
+		return
+	}
+	logger.WithLabels("task_id", task.ID, "type", task.Type, "status", task.Status, "attempt", task.Attempts,
+		"error", task.Error).Info("task finished")
+	if task.Status == core.TaskPending {
+		return
+	}
+	published := *task
+	q.bus.Publish("task."+task.Status, &published)
+}
+
+// release 服务退出时立即释放执行中的任务, 由其他副本重新执行, 这一次不计入执行次数
+func (q *Queue) release(ctx context.Context, task *core.Task) {
+	task.Status = core.TaskPending
+	task.Attempts--
+	task.VisibleTime = time.Now()
+	if _, err := q.tasks.Finish(ctx, task); err != nil {
+		logger.WithLabels("task_id", task.ID, "error", err).Error("cannot release task")
+	}
+}
+
+// retryAfter 返回第 n 次执行失败后的等待时间, 每次翻倍, 最长一小时
+func (q *Queue) retryAfter(n int) time.Duration {
+	d := q.backoff
+	for i := 1; i < n && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
+
+func (q *Queue) wake() {
+	select {
+	case q.kick <- struct{}{}:
+	default:
+	}
+}
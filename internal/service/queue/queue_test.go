@@ -0,0 +1,285 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/eventbus"
+)
+
+// fakeTaskDao 模拟多个副本共享的任务表
+type fakeTaskDao struct {
+	core.TaskDao
+	mu    sync.Mutex
+	tasks []core.Task
+}
+
+func (f *fakeTaskDao) get(id int64) core.Task {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.tasks[id-1]
+}
+
+func (f *fakeTaskDao) set(id int64, update func(*core.Task)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	update(&f.tasks[id-1])
+}
+
+func (f *fakeTaskDao) Get(_ context.Context, id int64) (*core.Task, error) {
+	task := f.get(id)
+	return &task, nil
+}
+
+func (f *fakeTaskDao) Create(_ context.Context, task *core.Task) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	task.ID = int64(len(f.tasks) + 1)
+	f.tasks = append(f.tasks, *task)
+	return task.ID, nil
+}
+
+func (f *fakeTaskDao) claimable(task *core.Task, now time.Time) bool {
+	return (task.Status == core.TaskPending || task.Status == core.TaskRunning) && !task.VisibleTime.After(now)
+}
+
+func (f *fakeTaskDao) ListClaimable(_ context.Context, now time.Time, limit int) ([]*core.Task, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []*core.Task
+	for _, task := range f.tasks {
+		task := task
+		if f.claimable(&task, now) {
+			out = append(out, &task)
+		}
+	}
+	// 按优先级倒序, 相同优先级按ID
+	for i := 1; i < len(out); i++ {
+		for j := i; j > 0 && out[j].Priority > out[j-1].Priority; j-- {
+			out[j], out[j-1] = out[j-1], out[j]
+		}
+	}
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+func (f *fakeTaskDao) Claim(_ context.Context, id int64, worker string, now, until time.Time) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	task := &f.tasks[id-1]
+	if !f.claimable(task, now) {
+		return false, nil
+	}
+	task.Status = core.TaskRunning
+	task.Worker = worker
+	task.Attempts++
+	task.VisibleTime = until
+	return true, nil
+}
+
+func (f *fakeTaskDao) Touch(_ context.Context, in *core.Task) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	task := &f.tasks[in.ID-1]
+	if task.Worker != in.Worker || task.Status != core.TaskRunning {
+		return false, nil
+	}
+	task.Progress, task.Message, task.VisibleTime = in.Progress, in.Message, in.VisibleTime
+	return true, nil
+}
+
+func (f *fakeTaskDao) Finish(_ context.Context, in *core.Task) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	task := &f.tasks[in.ID-1]
+	if task.Worker != in.Worker || task.Status != core.TaskRunning {
+		return false, nil
+	}
+	*task = *in
+	return true, nil
+}
+
+// fakeHandler 按顺序记录执行的任务, 前 fail 次执行失败
+type fakeHandler struct {
+	mu    sync.Mutex
+	fail  int
+	err   error
+	names []string
+}
+
+func (h *fakeHandler) Validate(payload json.RawMessage) error {
+	var in struct{ Name string }
+	if err := json.Unmarshal(payload, &in); err != nil || in.Name == "" {
+		return errors.New("name is required")
+	}
+	return nil
+}
+
+func (h *fakeHandler) Handle(_ context.Context, payload json.RawMessage, progress Progress) (interface{}, error) {
+	var in struct{ Name string }
+	_ = json.Unmarshal(payload, &in)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.names = append(h.names, in.Name)
+	progress(50, "half way")
+	if h.fail > 0 {
+		h.fail--
+		return map[string]int{"done": 0}, h.err
+	}
+	return map[string]string{"hello": in.Name}, nil
+}
+
+func newTestQueue(tasks core.TaskDao, handler Handler, worker string, workers int) *Queue {
+	return &Queue{
+		tasks:       tasks,
+		bus:         eventbus.ProvideBus(),
+		handlers:    map[string]Handler{"fake": handler},
+		worker:      worker,
+		workers:     workers,
+		interval:    time.Second,
+		visibility:  time.Minute,
+		backoff:     10 * time.Second,
+		maxAttempts: 3,
+		kick:        make(chan struct{}, 1),
+	}
+}
+
+func TestPriorityAndRetry(t *testing.T) {
+	ctx := context.Background()
+	dao := &fakeTaskDao{}
+	handler := &fakeHandler{fail: 1, err: fmt.Errorf("temporary failure")}
+	q := newTestQueue(dao, handler, "a", 1)
+	sub := q.bus.Subscribe("test", 4, "task.*")
+	defer sub.Close()
+
+	if _, err := q.Enqueue(ctx, "fake", map[string]string{}, 0, "admin"); !errors.Is(err, core.ErrInvalidTask) {
+		t.Fatalf("invalid payload: %v", err)
+	}
+	if _, err := q.Enqueue(ctx, "unknown", nil, 0, "admin"); !errors.Is(err, core.ErrInvalidTask) {
+		t.Fatalf("unknown type: %v", err)
+	}
+	low, err := q.Enqueue(ctx, "fake", map[string]string{"name": "low"}, 0, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	high, err := q.Enqueue(ctx, "fake", map[string]string{"name": "high"}, 10, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 只有一个 worker, 优先级高的任务先执行, 第一次失败后等待重试
+	q.Poll(ctx)
+	q.wg.Wait()
+	task := dao.get(high.ID)
+	if task.Status != core.TaskPending || task.Attempts != 1 || task.Error != "temporary failure" ||
+		task.VisibleTime.Before(time.Now().Add(9*time.Second)) || string(task.Result) != `{"done":0}` {
+		t.Fatalf("failed task = %+v", task)
+	}
+
+	// 重试时间未到, 先执行优先级低的任务
+	q.Poll(ctx)
+	q.wg.Wait()
+	if task := dao.get(low.ID); task.Status != core.TaskSucceeded || task.Progress != 100 ||
+		string(task.Result) != `{"hello":"low"}` {
+		t.Fatalf("low task = %+v", task)
+	}
+	dao.set(high.ID, func(task *core.Task) { task.VisibleTime = time.Now() })
+	q.Poll(ctx)
+	q.wg.Wait()
+	if task := dao.get(high.ID); task.Status != core.TaskSucceeded || task.Attempts != 2 || task.Error != "" ||
+		task.FinishTime == nil {
+		t.Fatalf("retried task = %+v", task)
+	}
+	if fmt.Sprint(handler.names) != "[high low high]" {
+		t.Fatalf("execution order = %v", handler.names)
+	}
+	for _, id := range []int64{low.ID, high.ID} {
+		event := <-sub.C
+		if event.Topic != "task.succeeded" || event.Data.(*core.Task).ID != id {
+			t.Fatalf("unexpected event %s %+v", event.Topic, event.Data)
+		}
+	}
+}
+
+func TestFailures(t *testing.T) {
+	ctx := context.Background()
+	dao := &fakeTaskDao{}
+
+	// 参数错误不重试
+	invalid := newTestQueue(dao, &fakeHandler{fail: 1, err: fmt.Errorf("%w: bad", core.ErrInvalidTask)}, "a", 2)
+	task, err := invalid.Enqueue(ctx, "fake", map[string]string{"name": "invalid"}, 0, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalid.Poll(ctx)
+	invalid.wg.Wait()
+	if got := dao.get(task.ID); got.Status != core.TaskFailed || got.Attempts != 1 {
+		t.Fatalf("invalid task = %+v", got)
+	}
+
+	// 用完执行次数后失败
+	exhausted := newTestQueue(dao, &fakeHandler{fail: 5, err: errors.New("boom")}, "a", 2)
+	task, err = exhausted.Enqueue(ctx, "fake", map[string]string{"name": "exhausted"}, 0, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		dao.set(task.ID, func(task *core.Task) { task.VisibleTime = time.Now() })
+		exhausted.Poll(ctx)
+		exhausted.wg.Wait()
+	}
+	if got := dao.get(task.ID); got.Status != core.TaskFailed || got.Attempts != 3 || got.Error != "boom" {
+		t.Fatalf("exhausted task = %+v", got)
+	}
+}
+
+func TestVisibilityTimeout(t *testing.T) {
+	ctx := context.Background()
+	dao := &fakeTaskDao{}
+	handler := &fakeHandler{}
+	a, b := newTestQueue(dao, handler, "a", 2), newTestQueue(dao, handler, "b", 2)
+	task, err := a.Enqueue(ctx, "fake", map[string]string{"name": "once"}, 0, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 副本 a 认领后退出, 可见性超时之前其他副本不能认领
+	ok, err := dao.Claim(ctx, task.ID, "a", time.Now(), time.Now().Add(time.Minute))
+	if err != nil || !ok {
+		t.Fatalf("claim: %v, %v", ok, err)
+	}
+	b.Poll(ctx)
+	b.wg.Wait()
+	if len(handler.names) != 0 {
+		t.Fatalf("task executed before visibility timeout")
+	}
+	dao.set(task.ID, func(task *core.Task) { task.VisibleTime = time.Now().Add(-time.Second) })
+	a.Poll(ctx)
+	b.Poll(ctx)
+	a.wg.Wait()
+	b.wg.Wait()
+	if got := dao.get(task.ID); len(handler.names) != 1 || got.Status != core.TaskSucceeded || got.Attempts != 2 {
+		t.Fatalf("calls = %d, task = %+v", len(handler.names), got)
+	}
+
+	// 最后一次执行的副本退出后不再执行
+	task, err = a.Enqueue(ctx, "fake", map[string]string{"name": "interrupted"}, 0, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dao.set(task.ID, func(task *core.Task) {
+		task.Status, task.Worker, task.Attempts = core.TaskRunning, "c", task.MaxAttempts
+	})
+	b.Poll(ctx)
+	b.wg.Wait()
+	if got := dao.get(task.ID); len(handler.names) != 1 || got.Status != core.TaskFailed || got.Attempts != got.MaxAttempts {
+		t.Fatalf("interrupted task = %+v", got)
+	}
+}
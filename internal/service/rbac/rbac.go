@@ -0,0 +1,35 @@
+// Package rbac 根据授权记录判断用户是否可以对资源执行某个操作
+package rbac
+
+import (
+	"context"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+)
+
+// ProvideAuthorizer is a Wire provider
+func ProvideAuthorizer(grantDao core.GrantDao) core.Authorizer {
+	return &authorizer{grants: grantDao}
+}
+
+type authorizer struct {
+	grants core.GrantDao
+}
+
+// Authorize 管理员拥有所有权限, 普通用户需要拥有对应的授权记录
+func (a *authorizer) Authorize(ctx context.Context, user *core.User, resource, resourceID, action string) error {
+	if user == nil {
+		return core.ErrForbidden
+	}
+	if user.IsAdmin {
+		return nil
+	}
+	ok, err := a.grants.Exists(ctx, user.ID, resource, resourceID, action)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return core.ErrForbidden
+	}
+	return nil
+}
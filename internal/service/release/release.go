@@ -0,0 +1,163 @@
+// Package release 负责应用的清单渲染、按环境发布以及回滚到历史版本
+package release
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/kube"
+	"github.com/bloodsteel/easynetes/pkg/log"
+)
+
+var logger = log.RegisterScope("release", "application release", 0)
+
+// ErrRollbackTarget 只能回滚到执行成功的发布
+var ErrRollbackTarget = errors.New("only applied releases can be rolled back to")
+
+// Deployer 将清单提交到集群
+type Deployer interface {
+	Deploy(ctx context.Context, clusterID int64, manifest []byte, user *core.User) error
+}
+
+// Service 渲染应用在各个环境的清单并发布, 每次发布和回滚都生成一个新的版本
+type Service struct {
+	apps       core.ApplicationDao
+	envs       core.AppEnvironmentDao
+	releases   core.AppReleaseDao
+	authorizer core.Authorizer
+	deployer   Deployer
+}
+
+// ProvideService is a Wire provider
+func ProvideService(
+	apps core.ApplicationDao,
+	envs core.AppEnvironmentDao,
+	releases core.AppReleaseDao,
+	authorizer core.Authorizer,
+	applier *kube.Applier,
+) *Service {
+	return &Service{
+		apps:       apps,
+		envs:       envs,
+		releases:   releases,
+		authorizer: authorizer,
+		deployer:   applier,
+	}
+}
+
+// Preview 渲染应用在环境中的清单但不发布, image 不为空时覆盖镜像
+func (s *Service) Preview(ctx context.Context, appID, envID int64, image string) (*core.AppRelease, error) {
+	app, env, err := s.load(ctx, appID, envID)
+	if err != nil {
+		return nil, err
+	}
+	spec := Merge(app.Spec, env.Overrides)
+	if image != "" {
+		spec.Image = image
+	}
+	manifest, err := Render(app, env, spec, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &core.AppRelease{AppID: app.ID, EnvID: env.ID, Image: spec.Image, Spec: spec, Manifest: string(manifest)}, nil
+}
+
+// Deploy 使用应用当前的配置发布到环境中, image 不为空时覆盖镜像
+func (s *Service) Deploy(ctx context.Context, appID, envID int64, image string, user *core.User) (*core.AppRelease, error) {
+	app, env, err := s.load(ctx, appID, envID)
+	if err != nil {
+		return nil, err
+	}
+	spec := Merge(app.Spec, env.Overrides)
+	if image != "" {
+		spec.Image = image
+	}
+	return s.release(ctx, app, env, spec, 0, user)
+}
+
+// Rollback 使用历史发布的部署参数在同一个环境中重新发布, 生成一个新的版本
+// 清单按照环境当前的集群和 namespace 重新渲染
+func (s *Service) Rollback(ctx context.Context, appID, releaseID int64, user *core.User) (*core.AppRelease, error) {
+	target, err := s.releases.Get(ctx, releaseID)
+	if err != nil {
+		return nil, err
+	}
+	if target.AppID != appID {
+		return nil, sql.ErrNoRows
+	}
+	if target.Status != core.AppReleaseApplied {
+		return nil, ErrRollbackTarget
+	}
+	app, env, err := s.load(ctx, appID, target.EnvID)
+	if err != nil {
+		return nil, err
+	}
+	return s.release(ctx, app, env, target.Spec, target.Revision, user)
+}
+
+// load 获取应用和环境, 环境不属于应用时按不存在处理
+func (s *Service) load(ctx context.Context, appID, envID int64) (*core.Application, *core.AppEnvironment, error) {
+	env, err := s.envs.Get(ctx, envID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if env.AppID != appID {
+		return nil, nil, sql.ErrNoRows
+	}
+	app, err := s.apps.Get(ctx, appID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return app, env, nil
+}
+
+// release 检查权限后创建发布记录并提交清单, 提交失败时发布记录的状态为 failed
+func (s *Service) release(
+	ctx context.Context,
+	app *core.Application,
+	env *core.AppEnvironment,
+	spec core.AppSpec,
+	rollbackFrom int64,
+	user *core.User,
+) (*core.AppRelease, error) {
+	err := s.authorizer.Authorize(ctx, user, core.ResourceKubeNamespace,
+		core.KubeNamespaceResource(env.ClusterID, env.Namespace), core.ActionKubeApply)
+	if err != nil {
+		return nil, err
+	}
+	// 先校验一次参数, 避免为无法渲染的配置分配版本号
+	if _, err := Render(app, env, spec, 0); err != nil {
+		return nil, err
+	}
+	release := &core.AppRelease{
+		AppID:        app.ID,
+		EnvID:        env.ID,
+		Image:        spec.Image,
+		Spec:         spec,
+		Status:       core.AppReleaseDeploying,
+		RollbackFrom: rollbackFrom,
+		Creator:      user.UserName,
+	}
+	if _, err := s.releases.Create(ctx, release); err != nil {
+		return nil, err
+	}
+	manifest, err := Render(app, env, spec, release.Revision)
+	if err == nil {
+		release.Manifest = string(manifest)
+		err = s.deployer.Deploy(ctx, env.ClusterID, manifest, user)
+	}
+	release.Status = core.AppReleaseApplied
+	if err != nil {
+		release.Status = core.AppReleaseFailed
+		release.Message = err.Error()
+	}
+	logger.WithLabels("app", app.Name, "env", env.Name, "revision", release.Revision, "operator", user.UserName,
+		"status", release.Status).Info("application released")
+	if err := s.releases.UpdateStatus(ctx, release); err != nil {
+		return nil, fmt.Errorf("cannot save release result: %w", err)
+	}
+	return release, nil
+}
@@ -0,0 +1,138 @@
+package release
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+)
+
+// fakeReleaseDao 内存中的 AppReleaseDao
+type fakeReleaseDao struct {
+	core.AppReleaseDao
+	items []*core.AppRelease
+}
+
+func (f *fakeReleaseDao) Get(_ context.Context, id int64) (*core.AppRelease, error) {
+	if id < 1 || int(id) > len(f.items) {
+		return nil, sql.ErrNoRows
+	}
+	out := *f.items[id-1]
+	return &out, nil
+}
+
+func (f *fakeReleaseDao) Create(_ context.Context, in *core.AppRelease) (int64, error) {
+	for _, item := range f.items {
+		if item.EnvID == in.EnvID && item.Revision >= in.Revision {
+			in.Revision = item.Revision
+		}
+	}
+	in.Revision++
+	in.ID = int64(len(f.items) + 1)
+	out := *in
+	f.items = append(f.items, &out)
+	return in.ID, nil
+}
+
+func (f *fakeReleaseDao) UpdateStatus(_ context.Context, in *core.AppRelease) error {
+	out := *in
+	f.items[in.ID-1] = &out
+	return nil
+}
+
+type fakeApplicationDao struct {
+	core.ApplicationDao
+	app *core.Application
+}
+
+func (f *fakeApplicationDao) Get(_ context.Context, id int64) (*core.Application, error) {
+	if id != f.app.ID {
+		return nil, sql.ErrNoRows
+	}
+	return f.app, nil
+}
+
+type fakeEnvironmentDao struct {
+	core.AppEnvironmentDao
+	env *core.AppEnvironment
+}
+
+func (f *fakeEnvironmentDao) Get(_ context.Context, id int64) (*core.AppEnvironment, error) {
+	if id != f.env.ID {
+		return nil, sql.ErrNoRows
+	}
+	return f.env, nil
+}
+
+// fakeDeployer 记录提交的清单, err 不为空时模拟提交失败
+type fakeDeployer struct {
+	manifests []string
+	err       error
+}
+
+func (f *fakeDeployer) Deploy(_ context.Context, _ int64, manifest []byte, _ *core.User) error {
+	f.manifests = append(f.manifests, string(manifest))
+	return f.err
+}
+
+type allowAll struct{}
+
+func (allowAll) Authorize(context.Context, *core.User, string, string, string) error { return nil }
+
+func TestDeployAndRollback(t *testing.T) {
+	ctx := context.Background()
+	user := &core.User{ID: 1, UserName: "alice"}
+	app := testApplication()
+	env := &core.AppEnvironment{ID: 2, AppID: app.ID, Name: "test", ClusterID: 1, Namespace: "web-test",
+		Overrides: core.AppSpec{Replicas: 1}}
+	releases := &fakeReleaseDao{}
+	deployer := &fakeDeployer{}
+	s := &Service{
+		apps:       &fakeApplicationDao{app: app},
+		envs:       &fakeEnvironmentDao{env: env},
+		releases:   releases,
+		authorizer: allowAll{},
+		deployer:   deployer,
+	}
+
+	first, err := s.Deploy(ctx, app.ID, env.ID, "", user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Revision != 1 || first.Status != core.AppReleaseApplied || first.Spec.Replicas != 1 ||
+		!strings.Contains(releases.items[0].Manifest, "registry.local/web:1.0") {
+		t.Errorf("first release = %+v", first)
+	}
+
+	deployer.err = errors.New("admission webhook denied the request")
+	failed, err := s.Deploy(ctx, app.ID, env.ID, "registry.local/web:2.0", user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed.Revision != 2 || failed.Status != core.AppReleaseFailed || failed.Message == "" {
+		t.Errorf("failed release = %+v", failed)
+	}
+	if _, err := s.Rollback(ctx, app.ID, failed.ID, user); !errors.Is(err, ErrRollbackTarget) {
+		t.Errorf("rollback to failed release error = %v", err)
+	}
+
+	deployer.err = nil
+	rollback, err := s.Rollback(ctx, app.ID, first.ID, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rollback.Revision != 3 || rollback.RollbackFrom != 1 || rollback.Image != "registry.local/web:1.0" {
+		t.Errorf("rollback release = %+v", rollback)
+	}
+	if !strings.Contains(deployer.manifests[2], `easynetes.io/release: "3"`) {
+		t.Errorf("rollback manifest:\n%s", deployer.manifests[2])
+	}
+
+	// 环境不属于应用时按不存在处理
+	if _, err := s.Deploy(ctx, 99, env.ID, "", user); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deploy with wrong application error = %v", err)
+	}
+}
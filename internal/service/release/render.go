@@ -0,0 +1,335 @@
+package release
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/kube"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// 渲染出的对象上的标签和注解
+const (
+	LabelApplication   = "easynetes.io/app"
+	LabelEnvironment   = "easynetes.io/environment"
+	AnnotationRevision = "easynetes.io/release"
+)
+
+// Merge 将环境的覆盖配置合并到应用的部署参数中
+// 镜像、副本数、端口和访问入口整体覆盖; 环境变量和资源按键合并, 覆盖配置中值为空的键会删除应用中的同名配置
+func Merge(base, override core.AppSpec) core.AppSpec {
+	out := core.AppSpec{
+		Image:    base.Image,
+		Replicas: base.Replicas,
+		Ports:    append([]core.AppPort(nil), base.Ports...),
+		Env:      mergeMap(base.Env, override.Env),
+		Resources: core.AppResources{
+			Requests: mergeMap(base.Resources.Requests, override.Resources.Requests),
+			Limits:   mergeMap(base.Resources.Limits, override.Resources.Limits),
+		},
+		Ingress: base.Ingress,
+	}
+	if override.Image != "" {
+		out.Image = override.Image
+	}
+	if override.Replicas > 0 {
+		out.Replicas = override.Replicas
+	}
+	if len(override.Ports) > 0 {
+		out.Ports = append([]core.AppPort(nil), override.Ports...)
+	}
+	if override.Ingress != nil {
+		out.Ingress = override.Ingress
+	}
+	if out.Ingress != nil {
+		ingress := *out.Ingress
+		out.Ingress = &ingress
+	}
+	return out
+}
+
+func mergeMap(base, override core.StringMap) core.StringMap {
+	out := make(core.StringMap, len(base)+len(override))
+	for k, v := range base {
+		out[k] = v
+	}
+	for k, v := range override {
+		if v == "" {
+			delete(out, k)
+			continue
+		}
+		out[k] = v
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// Validate 校验应用的名称和部署参数, 应用的镜像可以为空, 由各个环境分别指定
+func Validate(app *core.Application) error {
+	if errs := validation.IsDNS1035Label(app.Name); len(errs) > 0 {
+		return fmt.Errorf("%w: name: %s", core.ErrInvalidApplication, strings.Join(errs, "; "))
+	}
+	return validateSpec(app.Spec)
+}
+
+// ValidateEnvironment 校验环境的名称、部署位置和覆盖配置
+func ValidateEnvironment(env *core.AppEnvironment) error {
+	if errs := validation.IsDNS1123Label(env.Name); len(errs) > 0 {
+		return fmt.Errorf("%w: environment: %s", core.ErrInvalidApplication, strings.Join(errs, "; "))
+	}
+	if errs := validation.IsDNS1123Label(env.Namespace); len(errs) > 0 || env.ClusterID == 0 {
+		return fmt.Errorf("%w: environment must be deployed to a cluster namespace", core.ErrInvalidApplication)
+	}
+	return validateSpec(env.Overrides)
+}
+
+// validateSpec 只校验已经设置的字段, 应用和环境的配置合并后才能确定是否完整
+func validateSpec(spec core.AppSpec) error {
+	if spec.Replicas < 0 {
+		return fmt.Errorf("%w: replicas must not be negative", core.ErrInvalidApplication)
+	}
+	for _, port := range spec.Ports {
+		if errs := validation.IsValidPortNum(int(port.Port)); len(errs) > 0 {
+			return fmt.Errorf("%w: port %d: %s", core.ErrInvalidApplication, port.Port, strings.Join(errs, "; "))
+		}
+		if port.Name != "" {
+			if errs := validation.IsValidPortName(port.Name); len(errs) > 0 {
+				return fmt.Errorf("%w: port %s: %s", core.ErrInvalidApplication, port.Name, strings.Join(errs, "; "))
+			}
+		}
+		switch corev1.Protocol(strings.ToUpper(port.Protocol)) {
+		case "", corev1.ProtocolTCP, corev1.ProtocolUDP, corev1.ProtocolSCTP:
+		default:
+			return fmt.Errorf("%w: port %d: unsupported protocol %s", core.ErrInvalidApplication, port.Port, port.Protocol)
+		}
+	}
+	for k := range spec.Env {
+		if errs := validation.IsEnvVarName(k); len(errs) > 0 {
+			return fmt.Errorf("%w: env %s: %s", core.ErrInvalidApplication, k, strings.Join(errs, "; "))
+		}
+	}
+	for _, list := range []core.StringMap{spec.Resources.Requests, spec.Resources.Limits} {
+		for name, value := range list {
+			// 值为空表示删除应用中的同名配置
+			if value == "" {
+				continue
+			}
+			if _, err := resource.ParseQuantity(value); err != nil {
+				return fmt.Errorf("%w: resource %s: %v", core.ErrInvalidApplication, name, err)
+			}
+		}
+	}
+	if ingress := spec.Ingress; ingress != nil {
+		if errs := validation.IsDNS1123Subdomain(ingress.Host); len(errs) > 0 {
+			return fmt.Errorf("%w: ingress host: %s", core.ErrInvalidApplication, strings.Join(errs, "; "))
+		}
+		if ingress.Path != "" && !strings.HasPrefix(ingress.Path, "/") {
+			return fmt.Errorf("%w: ingress path must start with /", core.ErrInvalidApplication)
+		}
+	}
+	return nil
+}
+
+// Render 使用合并后的部署参数渲染 Deployment/Service/Ingress 清单
+// 没有端口时不生成 Service, 没有访问入口时不生成 Ingress; 每次发布的版本号写入 Pod 模板, 重新发布相同的参数也会触发滚动更新
+func Render(app *core.Application, env *core.AppEnvironment, spec core.AppSpec, revision int64) ([]byte, error) {
+	if err := validateSpec(spec); err != nil {
+		return nil, err
+	}
+	if spec.Image == "" {
+		return nil, fmt.Errorf("%w: image is required", core.ErrInvalidApplication)
+	}
+	if spec.Replicas == 0 {
+		spec.Replicas = 1
+	}
+	selector := map[string]string{LabelApplication: app.Name}
+	labels := map[string]string{
+		LabelApplication:  app.Name,
+		LabelEnvironment:  env.Name,
+		kube.LabelManaged: "true",
+	}
+	if app.ServiceNodeID != 0 {
+		labels[kube.LabelServiceNode] = strconv.FormatInt(app.ServiceNodeID, 10)
+	}
+	meta := func(kind, apiVersion string) (metav1.TypeMeta, metav1.ObjectMeta) {
+		return metav1.TypeMeta{Kind: kind, APIVersion: apiVersion}, metav1.ObjectMeta{
+			Name:        app.Name,
+			Namespace:   env.Namespace,
+			Labels:      labels,
+			Annotations: map[string]string{AnnotationRevision: strconv.FormatInt(revision, 10)},
+		}
+	}
+
+	container := corev1.Container{Name: app.Name, Image: spec.Image}
+	for _, port := range spec.Ports {
+		container.Ports = append(container.Ports, corev1.ContainerPort{
+			Name:          portName(port),
+			ContainerPort: port.Port,
+			Protocol:      portProtocol(port),
+		})
+	}
+	keys := make([]string, 0, len(spec.Env))
+	for k := range spec.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		container.Env = append(container.Env, corev1.EnvVar{Name: k, Value: spec.Env[k]})
+	}
+	var err error
+	if container.Resources.Requests, err = resourceList(spec.Resources.Requests); err != nil {
+		return nil, err
+	}
+	if container.Resources.Limits, err = resourceList(spec.Resources.Limits); err != nil {
+		return nil, err
+	}
+
+	deployment := &appsv1.Deployment{}
+	deployment.TypeMeta, deployment.ObjectMeta = meta("Deployment", "apps/v1")
+	deployment.Spec = appsv1.DeploymentSpec{
+		Replicas: &spec.Replicas,
+		Selector: &metav1.LabelSelector{MatchLabels: selector},
+		Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Labels: labels, Annotations: deployment.Annotations},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{container}},
+		},
+	}
+	objects := []interface{}{deployment}
+
+	if len(spec.Ports) > 0 {
+		service := &corev1.Service{}
+		service.TypeMeta, service.ObjectMeta = meta("Service", "v1")
+		service.Spec.Selector = selector
+		for _, port := range spec.Ports {
+			service.Spec.Ports = append(service.Spec.Ports, corev1.ServicePort{
+				Name:       portName(port),
+				Port:       port.Port,
+				TargetPort: intstr.FromInt32(port.Port),
+				Protocol:   portProtocol(port),
+			})
+		}
+		objects = append(objects, service)
+	}
+
+	if spec.Ingress != nil {
+		if len(spec.Ports) == 0 {
+			return nil, fmt.Errorf("%w: ingress requires at least one port", core.ErrInvalidApplication)
+		}
+		port := spec.Ingress.Port
+		if port == 0 {
+			port = spec.Ports[0].Port
+		}
+		path := spec.Ingress.Path
+		if path == "" {
+			path = "/"
+		}
+		pathType := networkingv1.PathTypePrefix
+		ingress := &networkingv1.Ingress{}
+		ingress.TypeMeta, ingress.ObjectMeta = meta("Ingress", "networking.k8s.io/v1")
+		if spec.Ingress.ClassName != "" {
+			ingress.Spec.IngressClassName = &spec.Ingress.ClassName
+		}
+		ingress.Spec.Rules = []networkingv1.IngressRule{{
+			Host: spec.Ingress.Host,
+			IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{
+				Paths: []networkingv1.HTTPIngressPath{{
+					Path:     path,
+					PathType: &pathType,
+					Backend: networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{
+						Name: app.Name,
+						Port: networkingv1.ServiceBackendPort{Number: port},
+					}},
+				}},
+			}},
+		}}
+		objects = append(objects, ingress)
+	}
+
+	var buf bytes.Buffer
+	for i, obj := range objects {
+		data, err := marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		buf.Write(data)
+	}
+	return buf.Bytes(), nil
+}
+
+// marshal 将对象转换为 YAML, 去掉 status 和值为 null 的字段(例如 creationTimestamp)
+func marshal(obj interface{}) ([]byte, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var out map[string]interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	delete(out, "status")
+	prune(out)
+	return yaml.Marshal(out)
+}
+
+func prune(obj map[string]interface{}) {
+	for k, v := range obj {
+		switch v := v.(type) {
+		case nil:
+			delete(obj, k)
+		case map[string]interface{}:
+			prune(v)
+		case []interface{}:
+			for _, item := range v {
+				if m, ok := item.(map[string]interface{}); ok {
+					prune(m)
+				}
+			}
+		}
+	}
+}
+
+func portName(port core.AppPort) string {
+	if port.Name != "" {
+		return port.Name
+	}
+	return fmt.Sprintf("%s-%d", strings.ToLower(string(portProtocol(port))), port.Port)
+}
+
+func portProtocol(port core.AppPort) corev1.Protocol {
+	if port.Protocol == "" {
+		return corev1.ProtocolTCP
+	}
+	return corev1.Protocol(strings.ToUpper(port.Protocol))
+}
+
+func resourceList(in core.StringMap) (corev1.ResourceList, error) {
+	if len(in) == 0 {
+		return nil, nil
+	}
+	out := make(corev1.ResourceList, len(in))
+	for name, value := range in {
+		q, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: resource %s: %v", core.ErrInvalidApplication, name, err)
+		}
+		out[corev1.ResourceName(name)] = q
+	}
+	return out, nil
+}
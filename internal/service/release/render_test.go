@@ -0,0 +1,118 @@
+package release
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func testApplication() *core.Application {
+	return &core.Application{
+		ID:            1,
+		Name:          "web",
+		ServiceNodeID: 7,
+		Spec: core.AppSpec{
+			Image:    "registry.local/web:1.0",
+			Replicas: 2,
+			Ports:    []core.AppPort{{Port: 8080}},
+			Env:      core.StringMap{"LOG_LEVEL": "info", "DEBUG": "1"},
+			Resources: core.AppResources{
+				Requests: core.StringMap{"cpu": "100m", "memory": "128Mi"},
+			},
+		},
+	}
+}
+
+func TestMerge(t *testing.T) {
+	base := testApplication().Spec
+	spec := Merge(base, core.AppSpec{
+		Replicas:  5,
+		Env:       core.StringMap{"LOG_LEVEL": "warn", "DEBUG": ""},
+		Resources: core.AppResources{Limits: core.StringMap{"memory": "512Mi"}},
+		Ingress:   &core.AppIngress{Host: "web.example.com"},
+	})
+	if spec.Image != base.Image || spec.Replicas != 5 || len(spec.Ports) != 1 {
+		t.Errorf("spec = %+v", spec)
+	}
+	if len(spec.Env) != 1 || spec.Env["LOG_LEVEL"] != "warn" {
+		t.Errorf("env = %v, want LOG_LEVEL overridden and DEBUG removed", spec.Env)
+	}
+	if spec.Resources.Requests["cpu"] != "100m" || spec.Resources.Limits["memory"] != "512Mi" {
+		t.Errorf("resources = %+v", spec.Resources)
+	}
+	if spec.Ingress == nil || spec.Ingress.Host != "web.example.com" {
+		t.Errorf("ingress = %+v", spec.Ingress)
+	}
+	// 合并结果不能修改应用的配置
+	spec.Env["NEW"] = "1"
+	if _, ok := base.Env["NEW"]; ok || base.Env["DEBUG"] != "1" {
+		t.Errorf("base env modified: %v", base.Env)
+	}
+}
+
+func TestRender(t *testing.T) {
+	app := testApplication()
+	env := &core.AppEnvironment{Name: "prod", ClusterID: 1, Namespace: "payment"}
+	spec := app.Spec
+	spec.Ingress = &core.AppIngress{Host: "web.example.com", ClassName: "nginx"}
+	manifest, err := Render(app, env, spec, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := bytes.Split(manifest, []byte("---\n"))
+	if len(docs) != 3 {
+		t.Fatalf("got %d documents, want Deployment/Service/Ingress", len(docs))
+	}
+	if bytes.Contains(manifest, []byte("creationTimestamp")) || bytes.Contains(manifest, []byte("status")) {
+		t.Errorf("manifest contains server fields:\n%s", manifest)
+	}
+
+	deployment := &appsv1.Deployment{}
+	if err := yaml.Unmarshal(docs[0], deployment); err != nil {
+		t.Fatal(err)
+	}
+	container := deployment.Spec.Template.Spec.Containers[0]
+	if deployment.Namespace != "payment" || *deployment.Spec.Replicas != 2 || container.Image != spec.Image ||
+		container.Ports[0].Name != "tcp-8080" || container.Resources.Requests.Cpu().String() != "100m" {
+		t.Errorf("deployment = %+v", deployment)
+	}
+	if len(container.Env) != 2 || container.Env[0].Name != "DEBUG" {
+		t.Errorf("env = %v, want sorted by name", container.Env)
+	}
+	if deployment.Spec.Template.Annotations[AnnotationRevision] != "3" ||
+		deployment.Labels[LabelEnvironment] != "prod" || deployment.Labels["easynetes.io/service-node"] != "7" {
+		t.Errorf("metadata = %+v", deployment.ObjectMeta)
+	}
+
+	service := &corev1.Service{}
+	if err := yaml.Unmarshal(docs[1], service); err != nil {
+		t.Fatal(err)
+	}
+	if service.Spec.Selector[LabelApplication] != "web" || service.Spec.Ports[0].TargetPort.IntVal != 8080 {
+		t.Errorf("service = %+v", service.Spec)
+	}
+
+	ingress := &networkingv1.Ingress{}
+	if err := yaml.Unmarshal(docs[2], ingress); err != nil {
+		t.Fatal(err)
+	}
+	backend := ingress.Spec.Rules[0].HTTP.Paths[0].Backend.Service
+	if *ingress.Spec.IngressClassName != "nginx" || backend.Name != "web" || backend.Port.Number != 8080 {
+		t.Errorf("ingress = %+v", ingress.Spec)
+	}
+
+	// 没有端口时只生成 Deployment, 缺少镜像时无法渲染
+	spec = core.AppSpec{Image: "busybox"}
+	if manifest, err = Render(app, env, spec, 1); err != nil || bytes.Contains(manifest, []byte("---")) {
+		t.Errorf("manifest without ports = %s, %v", manifest, err)
+	}
+	if _, err := Render(app, env, core.AppSpec{}, 1); !errors.Is(err, core.ErrInvalidApplication) {
+		t.Errorf("render without image error = %v", err)
+	}
+}
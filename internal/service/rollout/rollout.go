@@ -0,0 +1,431 @@
+// Package rollout 负责 agent 的分阶段灰度升级, 以及升级后主机失联时的自动回滚
+package rollout
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/agent/proto"
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/agenthub"
+	"github.com/bloodsteel/easynetes/pkg/config"
+	"github.com/bloodsteel/easynetes/pkg/log"
+)
+
+var logger = log.RegisterScope("rollout", "agent rollout orchestrator", 0)
+
+// 默认值
+const (
+	defaultInterval = 10 * time.Second
+	// defaultLease 推进任务的租约, 持有者每次推进时续期, 副本退出后其他副本在租约过期后接管
+	defaultLease         = 3 * defaultInterval
+	defaultHealthTimeout = 300
+	defaultSoakTime      = 300
+)
+
+var (
+	// ErrRolloutInProgress 同一时间只允许存在一个进行中的灰度升级任务
+	ErrRolloutInProgress = errors.New("another rollout is in progress")
+	// ErrNoUpgradableAgent 没有可以升级到目标版本的在线 agent
+	ErrNoUpgradableAgent = errors.New("no online agent can be upgraded to the target version")
+	// ErrInvalidTransition 灰度升级任务当前状态不允许该操作
+	ErrInvalidTransition = errors.New("operation not allowed in current rollout status")
+)
+
+// Sender 向 agent 下发消息
+type Sender interface {
+	Send(agentID int64, msg *proto.Message) error
+}
+
+// Orchestrator 按阶段推进灰度升级任务
+type Orchestrator struct {
+	agents           core.AgentDao
+	binaries         core.AgentBinaryDao
+	rollouts         core.AgentRolloutDao
+	sender           Sender
+	heartbeatTimeout time.Duration
+	interval         time.Duration
+	lease            time.Duration
+	owner            string
+}
+
+// ProvideOrchestrator is a Wire provider
+func ProvideOrchestrator(
+	agents core.AgentDao,
+	binaries core.AgentBinaryDao,
+	rollouts core.AgentRolloutDao,
+	hub *agenthub.Hub,
+	cfg *config.Config,
+) *Orchestrator {
+	hostname, _ := os.Hostname()
+	return &Orchestrator{
+		agents:           agents,
+		binaries:         binaries,
+		rollouts:         rollouts,
+		sender:           hub,
+		heartbeatTimeout: cfg.Agent.HeartbeatTimeout * time.Second,
+		interval:         defaultInterval,
+		lease:            defaultLease,
+		owner:            fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+	}
+}
+
+// Create 创建一个灰度升级任务
+// 所有在线且版本不等于目标版本的 agent 按照ID顺序分配到各个阶段, 没有对应平台二进制文件的 agent 会被跳过
+func (o *Orchestrator) Create(ctx context.Context, in *core.AgentRollout) (*core.AgentRollout, error) {
+	if err := in.Stages.Validate(); err != nil {
+		return nil, err
+	}
+	active, err := o.active(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(active) > 0 {
+		return nil, ErrRolloutInProgress
+	}
+	agents, err := o.agents.List(ctx, map[string]interface{}{"status": core.AgentStatusOnline})
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []*core.AgentRolloutHost
+	for _, agent := range agents {
+		if agent.Version == in.Version {
+			continue
+		}
+		to, err := o.binaries.Find(ctx, in.Version, agent.OS, agent.Arch)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		host := &core.AgentRolloutHost{
+			AgentID:     agent.ID,
+			FromVersion: agent.Version,
+			ToBinaryID:  to.ID,
+			Status:      core.RolloutHostPending,
+		}
+		// 升级前版本的二进制文件不存在时, 该主机无法自动回滚
+		if from, err := o.binaries.Find(ctx, agent.Version, agent.OS, agent.Arch); err == nil {
+			host.FromBinaryID = from.ID
+		}
+		hosts = append(hosts, host)
+	}
+	if len(hosts) == 0 {
+		return nil, ErrNoUpgradableAgent
+	}
+	for i, stage := range assignStages(len(hosts), in.Stages) {
+		hosts[i].Stage = stage
+	}
+
+	if in.HealthTimeout <= 0 {
+		in.HealthTimeout = defaultHealthTimeout
+	}
+	if in.SoakTime <= 0 {
+		in.SoakTime = defaultSoakTime
+	}
+	in.CurrentStage = 0
+	in.Status = core.RolloutStatusRunning
+	if _, err := o.rollouts.Create(ctx, in, hosts); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+// Pause 暂停一个进行中的灰度升级任务, 已经下发的升级不受影响
+func (o *Orchestrator) Pause(ctx context.Context, id int64) (*core.AgentRollout, error) {
+	return o.transition(ctx, id, core.RolloutStatusPaused, "paused manually", core.RolloutStatusRunning)
+}
+
+// Resume 继续一个暂停的灰度升级任务
+func (o *Orchestrator) Resume(ctx context.Context, id int64) (*core.AgentRollout, error) {
+	return o.transition(ctx, id, core.RolloutStatusRunning, "", core.RolloutStatusPaused)
+}
+
+// Rollback 手动回滚一个灰度升级任务, 所有已经升级的主机都会回到升级前的版本
+func (o *Orchestrator) Rollback(ctx context.Context, id int64) (*core.AgentRollout, error) {
+	return o.transition(ctx, id, core.RolloutStatusRollingBack, "rollback manually",
+		core.RolloutStatusRunning, core.RolloutStatusPaused, core.RolloutStatusSucceeded)
+}
+
+// Abort 终止一个灰度升级任务, 不再下发任何升级或者回滚
+func (o *Orchestrator) Abort(ctx context.Context, id int64) (*core.AgentRollout, error) {
+	return o.transition(ctx, id, core.RolloutStatusAborted, "aborted manually",
+		core.RolloutStatusRunning, core.RolloutStatusPaused, core.RolloutStatusRollingBack)
+}
+
+// Run 定期推进所有进行中的灰度升级任务, 直到 ctx 结束
+func (o *Orchestrator) Run(ctx context.Context) error {
+	ticker := time.NewTicker(o.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := o.Sync(ctx); err != nil {
+				logger.WithLabels("error", err).Error("cannot sync rollouts")
+			}
+		}
+	}
+}
+
+// Sync 推进一次所有进行中或者回滚中的灰度升级任务
+// 多个 apiserver 副本同时运行时, 每个任务只由认领了它的副本推进
+func (o *Orchestrator) Sync(ctx context.Context) error {
+	rollouts, err := o.active(ctx)
+	if err != nil {
+		return err
+	}
+	for _, rollout := range rollouts {
+		if rollout.Status == core.RolloutStatusPaused {
+			continue
+		}
+		now := time.Now()
+		ok, err := o.rollouts.Claim(ctx, rollout.ID, o.owner, now, now.Add(o.lease))
+		if err != nil {
+			logger.WithLabels("rollout_id", rollout.ID, "error", err).Error("cannot claim rollout")
+			continue
+		} else if !ok {
+			continue
+		}
+		switch rollout.Status {
+		case core.RolloutStatusRunning:
+			err = o.syncRunning(ctx, rollout)
+		case core.RolloutStatusRollingBack:
+			err = o.syncRollingBack(ctx, rollout)
+		}
+		if err != nil {
+			logger.WithLabels("rollout_id", rollout.ID, "error", err).Error("cannot sync rollout")
+		}
+	}
+	return nil
+}
+
+func (o *Orchestrator) syncRunning(ctx context.Context, rollout *core.AgentRollout) error {
+	hosts, agents, err := o.load(ctx, rollout.ID)
+	if err != nil {
+		return err
+	}
+	var (
+		now          = time.Now()
+		failure      string
+		stageDone    = true
+		lastUpgraded time.Time
+	)
+	for _, host := range hosts {
+		if host.Stage > rollout.CurrentStage {
+			continue
+		}
+		agent := agents[host.AgentID]
+		switch host.Status {
+		case core.RolloutHostPending:
+			host.Status = core.RolloutHostUpgrading
+			host.UpgradeTime = &now
+			o.deliver(ctx, rollout.ID, host, host.ToBinaryID)
+			if err := o.rollouts.UpdateHost(ctx, host); err != nil {
+				return err
+			}
+			stageDone = false
+		case core.RolloutHostUpgrading:
+			switch {
+			case agent != nil && agent.Version == rollout.Version && agent.LastHeartbeat.After(*host.UpgradeTime):
+				host.Status = core.RolloutHostUpgraded
+				if err := o.rollouts.UpdateHost(ctx, host); err != nil {
+					return err
+				}
+				lastUpgraded = now
+			case now.Sub(*host.UpgradeTime) > time.Duration(rollout.HealthTimeout)*time.Second:
+				failure = fmt.Sprintf("agent %d did not report version %s within health timeout", host.AgentID, rollout.Version)
+				host.Status = core.RolloutHostFailed
+				if err := o.rollouts.UpdateHost(ctx, host); err != nil {
+					return err
+				}
+			default:
+				// 只有 agent 离线导致没有送达时才重新下发, 已经送达的升级不重复下发
+				if host.SentBinaryID != host.ToBinaryID && o.deliver(ctx, rollout.ID, host, host.ToBinaryID) {
+					if err := o.rollouts.UpdateHost(ctx, host); err != nil {
+						return err
+					}
+				}
+				stageDone = false
+			}
+		case core.RolloutHostUpgraded:
+			if agent == nil || now.Sub(agent.LastHeartbeat) > o.heartbeatTimeout {
+				failure = fmt.Sprintf("agent %d stopped heartbeating after upgrade", host.AgentID)
+				host.Status = core.RolloutHostFailed
+				if err := o.rollouts.UpdateHost(ctx, host); err != nil {
+					return err
+				}
+			} else if host.UpdateTime.After(lastUpgraded) {
+				lastUpgraded = host.UpdateTime
+			}
+		case core.RolloutHostFailed:
+			failure = fmt.Sprintf("agent %d failed to upgrade", host.AgentID)
+		}
+	}
+
+	switch {
+	case failure != "":
+		rollout.Status = core.RolloutStatusRollingBack
+		rollout.Message = failure
+		logger.WithLabels("rollout_id", rollout.ID, "reason", failure).Warn("rolling back rollout")
+	case !stageDone || now.Sub(lastUpgraded) < time.Duration(rollout.SoakTime)*time.Second:
+		return nil
+	case rollout.CurrentStage == len(rollout.Stages)-1:
+		rollout.Status = core.RolloutStatusSucceeded
+		rollout.Message = ""
+	default:
+		rollout.CurrentStage++
+		rollout.Message = fmt.Sprintf("stage %d%% started", rollout.Stages[rollout.CurrentStage])
+	}
+	return o.rollouts.Update(ctx, rollout)
+}
+
+func (o *Orchestrator) syncRollingBack(ctx context.Context, rollout *core.AgentRollout) error {
+	hosts, agents, err := o.load(ctx, rollout.ID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	remaining := 0
+	for _, host := range hosts {
+		switch host.Status {
+		case core.RolloutHostPending, core.RolloutHostRolledBack:
+			continue
+		}
+		agent := agents[host.AgentID]
+		switch {
+		case host.Status == core.RolloutHostRollingBack && agent != nil &&
+			agent.Version == host.FromVersion && agent.LastHeartbeat.After(*host.UpgradeTime):
+			host.Status = core.RolloutHostRolledBack
+		case host.FromBinaryID == 0:
+			// 没有升级前版本的二进制文件, 只能人工处理
+			if host.Status == core.RolloutHostFailed {
+				continue
+			}
+			host.Status = core.RolloutHostFailed
+		case host.Status == core.RolloutHostRollingBack:
+			remaining++
+			if host.SentBinaryID == host.FromBinaryID || !o.deliver(ctx, rollout.ID, host, host.FromBinaryID) {
+				continue
+			}
+		default:
+			remaining++
+			if !o.deliver(ctx, rollout.ID, host, host.FromBinaryID) {
+				// agent 离线, 等它重新连上之后再回滚
+				continue
+			}
+			host.Status = core.RolloutHostRollingBack
+			host.UpgradeTime = &now
+		}
+		if err := o.rollouts.UpdateHost(ctx, host); err != nil {
+			return err
+		}
+	}
+	if remaining > 0 {
+		return nil
+	}
+	rollout.Status = core.RolloutStatusRolledBack
+	return o.rollouts.Update(ctx, rollout)
+}
+
+// deliver 向主机下发升级到 binaryID 的消息, 下发成功后记录在 SentBinaryID 中, 由调用方保存
+// 同一个二进制文件已经下发过时不再下发
+func (o *Orchestrator) deliver(ctx context.Context, rolloutID int64, host *core.AgentRolloutHost, binaryID int64) bool {
+	if host.SentBinaryID == binaryID {
+		return true
+	}
+	if !o.sendUpgrade(ctx, rolloutID, host.AgentID, binaryID) {
+		return false
+	}
+	host.SentBinaryID = binaryID
+	return true
+}
+
+// sendUpgrade 向 agent 下发升级消息, 返回是否下发成功
+func (o *Orchestrator) sendUpgrade(ctx context.Context, rolloutID, agentID, binaryID int64) bool {
+	binary, err := o.binaries.Get(ctx, binaryID)
+	if err != nil {
+		logger.WithLabels("binary_id", binaryID, "error", err).Error("cannot find agent binary")
+		return false
+	}
+	msg, err := proto.NewMessage(proto.TypeUpgrade, &proto.Upgrade{
+		RolloutID: rolloutID,
+		BinaryID:  binary.ID,
+		Version:   binary.Version,
+		Checksum:  binary.Checksum,
+		Size:      binary.Size,
+	})
+	if err != nil {
+		return false
+	}
+	if err := o.sender.Send(agentID, msg); err != nil {
+		logger.WithLabels("agent_id", agentID, "error", err).Debug("cannot send upgrade to agent")
+		return false
+	}
+	return true
+}
+
+func (o *Orchestrator) load(ctx context.Context, rolloutID int64) ([]*core.AgentRolloutHost, map[int64]*core.Agent, error) {
+	hosts, err := o.rollouts.ListHosts(ctx, rolloutID)
+	if err != nil {
+		return nil, nil, err
+	}
+	agents, err := o.agents.List(ctx, map[string]interface{}{})
+	if err != nil {
+		return nil, nil, err
+	}
+	byID := make(map[int64]*core.Agent, len(agents))
+	for _, agent := range agents {
+		byID[agent.ID] = agent
+	}
+	return hosts, byID, nil
+}
+
+func (o *Orchestrator) active(ctx context.Context) ([]*core.AgentRollout, error) {
+	var out []*core.AgentRollout
+	for _, status := range []string{core.RolloutStatusRunning, core.RolloutStatusPaused, core.RolloutStatusRollingBack} {
+		rollouts, err := o.rollouts.List(ctx, map[string]interface{}{"status": status})
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rollouts...)
+	}
+	return out, nil
+}
+
+func (o *Orchestrator) transition(ctx context.Context, id int64, to, message string, from ...string) (*core.AgentRollout, error) {
+	rollout, err := o.rollouts.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range from {
+		if rollout.Status == status {
+			rollout.Status = to
+			rollout.Message = message
+			return rollout, o.rollouts.Update(ctx, rollout)
+		}
+	}
+	return nil, ErrInvalidTransition
+}
+
+// assignStages 将 n 个主机分配到各个阶段, 返回每个主机所在阶段的下标
+// 每个阶段结束时累计升级 ceil(n * percent / 100) 个主机, 第一个阶段至少升级一个主机
+func assignStages(n int, stages core.RolloutStages) []int {
+	out := make([]int, 0, n)
+	for i, percent := range stages {
+		total := (n*percent + 99) / 100
+		if total < 1 {
+			total = 1
+		}
+		for len(out) < total && len(out) < n {
+			out = append(out, i)
+		}
+	}
+	return out
+}
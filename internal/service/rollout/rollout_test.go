@@ -0,0 +1,190 @@
+package rollout
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/agent/proto"
+	"github.com/bloodsteel/easynetes/internal/core"
+)
+
+func TestAssignStages(t *testing.T) {
+	cases := []struct {
+		n      int
+		stages core.RolloutStages
+		counts []int
+	}{
+		{n: 20, stages: core.RolloutStages{5, 50, 100}, counts: []int{1, 9, 10}},
+		{n: 3, stages: core.RolloutStages{5, 50, 100}, counts: []int{1, 1, 1}},
+		{n: 1, stages: core.RolloutStages{5, 50, 100}, counts: []int{1, 0, 0}},
+		{n: 10, stages: core.RolloutStages{100}, counts: []int{10}},
+	}
+	for _, c := range cases {
+		got := assignStages(c.n, c.stages)
+		if len(got) != c.n {
+			t.Fatalf("assignStages(%d, %v) assigned %d hosts", c.n, c.stages, len(got))
+		}
+		counts := make([]int, len(c.stages))
+		for _, stage := range got {
+			counts[stage]++
+		}
+		if !reflect.DeepEqual(counts, c.counts) {
+			t.Errorf("assignStages(%d, %v) = %v, want %v", c.n, c.stages, counts, c.counts)
+		}
+	}
+}
+
+func TestRolloutStagesValidate(t *testing.T) {
+	valid := []core.RolloutStages{{100}, {5, 50, 100}, {1, 2, 100}}
+	for _, stages := range valid {
+		if err := stages.Validate(); err != nil {
+			t.Errorf("%v: unexpected error %v", stages, err)
+		}
+	}
+	invalid := []core.RolloutStages{{}, {50}, {50, 50, 100}, {0, 100}, {60, 30, 100}, {5, 120}}
+	for _, stages := range invalid {
+		if err := stages.Validate(); err == nil {
+			t.Errorf("%v: expected error", stages)
+		}
+	}
+}
+
+type fakeAgentDao struct {
+	core.AgentDao
+	agents []*core.Agent
+}
+
+func (f *fakeAgentDao) List(context.Context, map[string]interface{}) ([]*core.Agent, error) {
+	return f.agents, nil
+}
+
+type fakeBinaryDao struct {
+	core.AgentBinaryDao
+}
+
+func (f *fakeBinaryDao) Get(_ context.Context, id int64) (*core.AgentBinary, error) {
+	return &core.AgentBinary{ID: id, Version: "v2"}, nil
+}
+
+type fakeRolloutDao struct {
+	core.AgentRolloutDao
+	mu      sync.Mutex
+	rollout *core.AgentRollout
+	hosts   []*core.AgentRolloutHost
+}
+
+func (f *fakeRolloutDao) List(_ context.Context, in map[string]interface{}) ([]*core.AgentRollout, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if in["status"] != f.rollout.Status {
+		return nil, nil
+	}
+	out := *f.rollout
+	return []*core.AgentRollout{&out}, nil
+}
+
+func (f *fakeRolloutDao) Claim(_ context.Context, _ int64, owner string, now, until time.Time) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.rollout.Owner != owner && f.rollout.LeaseTime != nil && !f.rollout.LeaseTime.Before(now) {
+		return false, nil
+	}
+	f.rollout.Owner, f.rollout.LeaseTime = owner, &until
+	return true, nil
+}
+
+func (f *fakeRolloutDao) Update(_ context.Context, in *core.AgentRollout) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rollout.CurrentStage, f.rollout.Status, f.rollout.Message = in.CurrentStage, in.Status, in.Message
+	return nil
+}
+
+func (f *fakeRolloutDao) ListHosts(context.Context, int64) ([]*core.AgentRolloutHost, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]*core.AgentRolloutHost, 0, len(f.hosts))
+	for _, host := range f.hosts {
+		copied := *host
+		out = append(out, &copied)
+	}
+	return out, nil
+}
+
+func (f *fakeRolloutDao) UpdateHost(_ context.Context, in *core.AgentRolloutHost) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, host := range f.hosts {
+		if host.ID == in.ID {
+			copied := *in
+			f.hosts[i] = &copied
+		}
+	}
+	return nil
+}
+
+type countingSender struct {
+	mu    sync.Mutex
+	sent  map[int64]int
+	fails map[int64]bool
+}
+
+func (s *countingSender) Send(agentID int64, _ *proto.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fails[agentID] {
+		return context.DeadlineExceeded
+	}
+	s.sent[agentID]++
+	return nil
+}
+
+func TestSyncSendsUpgradeOnce(t *testing.T) {
+	rollouts := &fakeRolloutDao{
+		rollout: &core.AgentRollout{ID: 1, Version: "v2", Stages: core.RolloutStages{100},
+			Status: core.RolloutStatusRunning, HealthTimeout: 300, SoakTime: 300},
+		hosts: []*core.AgentRolloutHost{
+			{ID: 1, RolloutID: 1, AgentID: 1, FromVersion: "v1", FromBinaryID: 10, ToBinaryID: 20, Status: core.RolloutHostPending},
+			{ID: 2, RolloutID: 1, AgentID: 2, FromVersion: "v1", FromBinaryID: 10, ToBinaryID: 20, Status: core.RolloutHostPending},
+		},
+	}
+	agents := &fakeAgentDao{agents: []*core.Agent{
+		{ID: 1, Version: "v1", LastHeartbeat: time.Now()},
+		{ID: 2, Version: "v1", LastHeartbeat: time.Now()},
+	}}
+	sender := &countingSender{sent: map[int64]int{}, fails: map[int64]bool{2: true}}
+	replicas := []*Orchestrator{
+		{agents: agents, binaries: &fakeBinaryDao{}, rollouts: rollouts, sender: sender, lease: time.Minute, owner: "a"},
+		{agents: agents, binaries: &fakeBinaryDao{}, rollouts: rollouts, sender: sender, lease: time.Minute, owner: "b"},
+	}
+	for i := 0; i < 3; i++ {
+		for _, o := range replicas {
+			if err := o.Sync(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// agent 2 离线, 重新连上之后才会送达一次
+	if sender.sent[1] != 1 || sender.sent[2] != 0 {
+		t.Fatalf("sent = %v, want agent 1 once and agent 2 never", sender.sent)
+	}
+	sender.mu.Lock()
+	sender.fails[2] = false
+	sender.mu.Unlock()
+	for i := 0; i < 3; i++ {
+		for _, o := range replicas {
+			if err := o.Sync(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if sender.sent[1] != 1 || sender.sent[2] != 1 {
+		t.Fatalf("sent = %v, want each agent once", sender.sent)
+	}
+	if rollouts.rollout.Owner != "a" {
+		t.Errorf("owner = %q, want a", rollouts.rollout.Owner)
+	}
+}
@@ -0,0 +1,173 @@
+// Package stream 将 DAO 发布到事件总线的资源变更推送给 SSE 客户端, 前端不需要轮询列表页
+// 最近的变更保存在有界的缓冲区中, 客户端断线重连时通过 Last-Event-ID 补发错过的变更
+package stream
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/eventbus"
+	"github.com/bloodsteel/easynetes/pkg/config"
+	"github.com/bloodsteel/easynetes/pkg/log"
+)
+
+var logger = log.RegisterScope("stream", "server-sent resource changes", 0)
+
+// clientBuffer 每个客户端待发送的事件数量, 客户端跟不上时断开连接, 由客户端带着 Last-Event-ID 重连补发
+const clientBuffer = 256
+
+// Event 推送给客户端的一条资源变更
+// ID 的格式为 <启动时间>-<序号>, 服务重启后旧的 ID 无法补发, 客户端会收到 reset
+type Event struct {
+	ID     string
+	Change *core.ResourceChange
+	seq    uint64
+}
+
+// Filter 客户端订阅的资源, 每一项是资源类型(例如 host)或者资源类型和ID(例如 host:12), 为空时订阅所有资源
+type Filter []string
+
+// Match 判断变更是否匹配订阅的资源
+func (f Filter) Match(change *core.ResourceChange) bool {
+	if len(f) == 0 {
+		return true
+	}
+	id := change.Resource + ":" + strconv.FormatInt(change.ID, 10)
+	for _, item := range f {
+		if item == change.Resource || item == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Broker 从事件总线接收资源变更, 编号后保存在缓冲区中并分发给订阅的客户端
+type Broker struct {
+	bus   *eventbus.Bus
+	epoch string
+	size  int
+
+	mu      sync.Mutex
+	seq     uint64
+	buffer  []*Event
+	clients map[*Client]struct{}
+}
+
+// Client 一个 SSE 连接的订阅, 从 C 中读取事件, C 被关闭表示客户端太慢或者服务正在退出
+type Client struct {
+	C      <-chan *Event
+	ch     chan *Event
+	filter Filter
+	broker *Broker
+}
+
+// ProvideBroker is a Wire provider
+func ProvideBroker(bus *eventbus.Bus, cfg *config.Config) *Broker {
+	return &Broker{
+		bus:     bus,
+		epoch:   strconv.FormatInt(time.Now().UnixMilli(), 36),
+		size:    cfg.Stream.Buffer,
+		clients: make(map[*Client]struct{}),
+	}
+}
+
+// Run 订阅事件总线上的所有事件, 只转发 DAO 发布的资源变更, ctx 结束时断开所有客户端
+func (b *Broker) Run(ctx context.Context) error {
+	sub := b.bus.Subscribe("stream", 1024, "*")
+	defer sub.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			b.mu.Lock()
+			for client := range b.clients {
+				b.remove(client)
+			}
+			b.mu.Unlock()
+			return nil
+		case event := <-sub.C:
+			if change, ok := event.Data.(*core.ResourceChange); ok {
+				b.Publish(change)
+			}
+		}
+	}
+}
+
+// Publish 为变更分配 ID, 保存到缓冲区并发送给所有匹配的客户端
+func (b *Broker) Publish(change *core.ResourceChange) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	event := &Event{ID: b.epoch + "-" + strconv.FormatUint(b.seq, 10), Change: change, seq: b.seq}
+	b.buffer = append(b.buffer, event)
+	if len(b.buffer) > b.size {
+		// 复制到新的切片, 避免底层数组一直增长
+		b.buffer = append([]*Event(nil), b.buffer[len(b.buffer)-b.size:]...)
+	}
+	for client := range b.clients {
+		if !client.filter.Match(change) {
+			continue
+		}
+		select {
+		case client.ch <- event:
+		default:
+			logger.WithLabels("event_id", event.ID).Warn("stream client is too slow, disconnected")
+			b.remove(client)
+		}
+	}
+}
+
+// Subscribe 订阅匹配 filter 的变更, lastID 是客户端收到的最后一个事件的 ID
+// 返回缓冲区中 lastID 之后匹配的事件; lastID 之后的事件已经不在缓冲区中时 reset 为 true, 客户端需要重新加载全部数据
+func (b *Broker) Subscribe(filter Filter, lastID string) (client *Client, backlog []*Event, reset bool) {
+	ch := make(chan *Event, clientBuffer)
+	client = &Client{C: ch, ch: ch, filter: filter, broker: b}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clients[client] = struct{}{}
+	if lastID == "" {
+		return client, nil, false
+	}
+	seq, ok := b.parse(lastID)
+	if !ok || seq > b.seq {
+		return client, nil, true
+	}
+	// 缓冲区中最早的事件之前还有事件被丢弃了, 无法完整补发
+	if len(b.buffer) > 0 && seq+1 < b.buffer[0].seq {
+		return client, nil, true
+	}
+	for _, event := range b.buffer {
+		if event.seq > seq && filter.Match(event.Change) {
+			backlog = append(backlog, event)
+		}
+	}
+	return client, backlog, false
+}
+
+// Close 取消订阅并关闭 C
+func (c *Client) Close() {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	c.broker.remove(c)
+}
+
+// remove 调用方需要持有 mu
+func (b *Broker) remove(client *Client) {
+	if _, ok := b.clients[client]; ok {
+		delete(b.clients, client)
+		close(client.ch)
+	}
+}
+
+// parse 解析事件 ID 中的序号, 其他进程生成的 ID 返回 false
+func (b *Broker) parse(id string) (uint64, bool) {
+	epoch, seq, ok := strings.Cut(id, "-")
+	if !ok || epoch != b.epoch {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	return n, err == nil
+}
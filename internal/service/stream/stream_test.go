@@ -0,0 +1,84 @@
+package stream
+
+import (
+	"testing"
+
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/eventbus"
+	"github.com/bloodsteel/easynetes/pkg/config"
+)
+
+func newTestBroker(size int) *Broker {
+	cfg := &config.Config{}
+	cfg.Stream.Buffer = size
+	return ProvideBroker(eventbus.ProvideBus(), cfg)
+}
+
+func change(resource string, id int64) *core.ResourceChange {
+	return &core.ResourceChange{Resource: resource, ID: id, Op: core.ChangeUpdated}
+}
+
+func TestFilter(t *testing.T) {
+	cases := []struct {
+		filter Filter
+		want   bool
+	}{
+		{nil, true},
+		{Filter{"host"}, true},
+		{Filter{"host:7"}, true},
+		{Filter{"host:8", "agent"}, false},
+		{Filter{"agent:7"}, false},
+	}
+	for _, c := range cases {
+		if got := c.filter.Match(change(core.ResourceHost, 7)); got != c.want {
+			t.Errorf("%v.Match(host:7) = %v, want %v", c.filter, got, c.want)
+		}
+	}
+}
+
+func TestResume(t *testing.T) {
+	b := newTestBroker(3)
+	client, _, _ := b.Subscribe(Filter{"host"}, "")
+	b.Publish(change(core.ResourceHost, 1))
+	b.Publish(change(core.ResourceAgent, 1))
+	b.Publish(change(core.ResourceHost, 2))
+	first := <-client.C
+	if first.Change.ID != 1 {
+		t.Fatalf("unexpected event %+v", first)
+	}
+	client.Close()
+	client.Close()
+
+	// 重连后只补发 first 之后匹配的事件
+	client, backlog, reset := b.Subscribe(Filter{"host"}, first.ID)
+	defer client.Close()
+	if reset || len(backlog) != 1 || backlog[0].Change.ID != 2 {
+		t.Fatalf("backlog = %+v, reset = %v", backlog, reset)
+	}
+
+	// first 之后的事件已经被挤出缓冲区, 无法补发
+	b.Publish(change(core.ResourceHost, 3))
+	b.Publish(change(core.ResourceHost, 4))
+	if _, backlog, reset := b.Subscribe(nil, first.ID); !reset || backlog != nil {
+		t.Fatalf("backlog = %+v, reset = %v, want reset", backlog, reset)
+	}
+	// 其他进程生成的 ID
+	if _, _, reset := b.Subscribe(nil, "other-1"); !reset {
+		t.Fatal("id from another process should reset")
+	}
+}
+
+func TestSlowClient(t *testing.T) {
+	b := newTestBroker(clientBuffer * 2)
+	client, _, _ := b.Subscribe(nil, "")
+	for i := 0; i <= clientBuffer; i++ {
+		b.Publish(change(core.ResourceHost, int64(i)))
+	}
+	n := 0
+	for range client.C {
+		n++
+	}
+	if n != clientBuffer {
+		t.Fatalf("received %d events before disconnect, want %d", n, clientBuffer)
+	}
+}
@@ -0,0 +1,119 @@
+package terminal
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// asciicast v2 事件类型, 参考 https://docs.asciinema.org/manual/asciicast/v2/
+const (
+	eventOutput = "o"
+	eventInput  = "i"
+	eventResize = "r"
+)
+
+// header asciicast v2 文件的第一行
+type header struct {
+	Version   int               `json:"version"`
+	Width     uint16            `json:"width"`
+	Height    uint16            `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// recorder 将终端会话以 asciicast v2 格式写入文件
+// 每个事件一行: [相对开始时间的秒数, 事件类型, 数据]
+type recorder struct {
+	mu    sync.Mutex
+	file  *os.File
+	buf   *bufio.Writer
+	start time.Time
+	// 输出可能在 UTF-8 字符中间被截断, 未完整的字节留到下一次输出时再写
+	pending map[string][]byte
+}
+
+func newRecorder(path string, cols, rows uint16, title string) (*recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	r := &recorder{
+		file:    file,
+		buf:     bufio.NewWriter(file),
+		start:   time.Now(),
+		pending: make(map[string][]byte),
+	}
+	data, _ := json.Marshal(&header{
+		Version:   2,
+		Width:     cols,
+		Height:    rows,
+		Timestamp: r.start.Unix(),
+		Title:     title,
+		Env:       map[string]string{"TERM": "xterm-256color"},
+	})
+	if _, err := r.buf.Write(append(data, '\n')); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *recorder) output(data []byte) error {
+	return r.write(eventOutput, data)
+}
+
+func (r *recorder) input(data []byte) error {
+	return r.write(eventInput, data)
+}
+
+func (r *recorder) resize(cols, rows uint16) error {
+	return r.write(eventResize, []byte(strconv.Itoa(int(cols))+"x"+strconv.Itoa(int(rows))))
+}
+
+func (r *recorder) write(kind string, data []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data = append(r.pending[kind], data...)
+	valid := validPrefix(data)
+	r.pending[kind] = append([]byte(nil), data[valid:]...)
+	if valid == 0 {
+		return nil
+	}
+	elapsed := time.Since(r.start).Seconds()
+	line, err := json.Marshal([]interface{}{elapsed, kind, string(data[:valid])})
+	if err != nil {
+		return err
+	}
+	_, err = r.buf.Write(append(line, '\n'))
+	return err
+}
+
+func (r *recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.buf.Flush(); err != nil {
+		_ = r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+// validPrefix 返回 data 中可以安全编码的前缀长度, 只有末尾不完整的 UTF-8 字符会被留下
+// 末尾超过 utf8.UTFMax 个字节仍然无法解码的数据直接视为非法字节写入
+func validPrefix(data []byte) int {
+	for i := len(data); i > 0 && i > len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i-1]) {
+			if !utf8.FullRune(data[i-1:]) {
+				return i - 1
+			}
+			break
+		}
+	}
+	return len(data)
+}
@@ -0,0 +1,67 @@
+package terminal
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.cast")
+	r, err := newRecorder(path, 80, 24, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	euro := []byte("€")
+	_ = r.output([]byte("hello "))
+	// 一个多字节字符被截断在两次输出中
+	_ = r.output(euro[:1])
+	_ = r.output(euro[1:])
+	_ = r.input([]byte("ls\r"))
+	_ = r.resize(120, 40)
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		t.Fatal("missing header")
+	}
+	h := new(header)
+	if err := json.Unmarshal(scanner.Bytes(), h); err != nil {
+		t.Fatal(err)
+	}
+	if h.Version != 2 || h.Width != 80 || h.Height != 24 {
+		t.Errorf("unexpected header %+v", h)
+	}
+
+	want := [][2]string{
+		{"o", "hello "},
+		{"o", "€"},
+		{"i", "ls\r"},
+		{"r", "120x40"},
+	}
+	var got [][2]string
+	for scanner.Scan() {
+		var event []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, [2]string{event[1].(string), event[2].(string)})
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d events, want %d: %v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("event %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+}
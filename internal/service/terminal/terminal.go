@@ -0,0 +1,265 @@
+// Package terminal 通过 agent stream 在主机上打开 PTY, 为浏览器提供 web 终端, 并录制会话
+package terminal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bloodsteel/easynetes/internal/agent/proto"
+	"github.com/bloodsteel/easynetes/internal/core"
+	"github.com/bloodsteel/easynetes/internal/service/agenthub"
+	"github.com/bloodsteel/easynetes/pkg/config"
+	"github.com/bloodsteel/easynetes/pkg/log"
+	"github.com/google/uuid"
+)
+
+var logger = log.RegisterScope("terminal", "web terminal sessions", 0)
+
+// outputBuffer 每个会话缓存的输出消息数量, 缓冲区满时说明浏览器消费过慢, 关闭会话
+// 输出在 agent stream 的读循环中处理, 不能阻塞, 否则该 agent 的其他会话和消息都会被卡住
+const outputBuffer = 256
+
+// 会话关闭原因
+const (
+	ReasonExited       = "exited"
+	ReasonClientClosed = "client closed"
+	ReasonIdleTimeout  = "idle timeout"
+	ReasonSlowClient   = "client too slow"
+	ReasonAgentLost    = "agent disconnected"
+	ReasonShutdown     = "server shutdown"
+)
+
+// Manager 管理所有进行中的终端会话
+type Manager struct {
+	hub           *agenthub.Hub
+	sessions      core.TerminalSessionDao
+	recordingPath string
+	idleTimeout   time.Duration
+
+	mu     sync.Mutex
+	active map[string]*Session
+}
+
+// ProvideManager is a Wire provider
+func ProvideManager(hub *agenthub.Hub, sessions core.TerminalSessionDao, cfg *config.Config) *Manager {
+	m := &Manager{
+		hub:           hub,
+		sessions:      sessions,
+		recordingPath: cfg.Terminal.RecordingPath,
+		idleTimeout:   cfg.Terminal.IdleTimeout * time.Second,
+		active:        make(map[string]*Session),
+	}
+	hub.Handle(proto.TypePTYOutput, m.handleOutput)
+	hub.Handle(proto.TypePTYExit, m.handleExit)
+	return m
+}
+
+// Session 一个进行中的终端会话
+type Session struct {
+	*core.TerminalSession
+
+	mgr       *Manager
+	recorder  *recorder
+	output    chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	lastInput atomic.Int64
+}
+
+// Open 在 agent 所在的主机上打开一个终端会话
+func (m *Manager) Open(ctx context.Context, hostID int64, agent *core.Agent, user *core.User, cols, rows uint16) (*Session, error) {
+	if !m.hub.Connected(agent.ID) {
+		return nil, agenthub.ErrAgentOffline
+	}
+	if err := os.MkdirAll(m.recordingPath, 0o750); err != nil {
+		return nil, err
+	}
+	record := &core.TerminalSession{
+		ID:        uuid.New().String(),
+		HostID:    hostID,
+		AgentID:   agent.ID,
+		UserID:    user.ID,
+		UserName:  user.UserName,
+		Cols:      cols,
+		Rows:      rows,
+		Status:    core.TerminalStatusActive,
+		StartTime: time.Now(),
+	}
+	record.Recording = filepath.Join(m.recordingPath, record.ID+".cast")
+	rec, err := newRecorder(record.Recording, cols, rows, fmt.Sprintf("%s@%s", user.UserName, agent.HostName))
+	if err != nil {
+		return nil, err
+	}
+	if err := m.sessions.Create(ctx, record); err != nil {
+		_ = rec.Close()
+		return nil, err
+	}
+	s := &Session{
+		TerminalSession: record,
+		mgr:             m,
+		recorder:        rec,
+		output:          make(chan []byte, outputBuffer),
+		done:            make(chan struct{}),
+	}
+	s.lastInput.Store(time.Now().UnixNano())
+
+	m.mu.Lock()
+	m.active[s.ID] = s
+	m.mu.Unlock()
+
+	if err := m.send(agent.ID, proto.TypePTYOpen, &proto.PTYOpen{SessionID: s.ID, Cols: cols, Rows: rows}); err != nil {
+		s.finish(ReasonAgentLost, -1)
+		return nil, err
+	}
+	go s.watchIdle()
+	logger.WithLabels("session_id", s.ID, "host_id", hostID, "user", user.UserName).Info("terminal session opened")
+	return s, nil
+}
+
+// Run 在 ctx 结束时关闭所有终端会话, 保证录像文件被完整写入
+func (m *Manager) Run(ctx context.Context) error {
+	<-ctx.Done()
+	m.mu.Lock()
+	sessions := make([]*Session, 0, len(m.active))
+	for _, s := range m.active {
+		sessions = append(sessions, s)
+	}
+	m.mu.Unlock()
+	for _, s := range sessions {
+		s.Close(ReasonShutdown)
+	}
+	return nil
+}
+
+// Output 返回终端输出, 会话结束后不再有新的输出
+func (s *Session) Output() <-chan []byte {
+	return s.output
+}
+
+// Done 会话结束时关闭
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Input 将用户输入发送给 agent
+func (s *Session) Input(data []byte) error {
+	s.lastInput.Store(time.Now().UnixNano())
+	_ = s.recorder.input(data)
+	return s.sendOrClose(proto.TypePTYInput, &proto.PTYData{SessionID: s.ID, Data: data})
+}
+
+// Resize 调整终端窗口大小
+func (s *Session) Resize(cols, rows uint16) error {
+	_ = s.recorder.resize(cols, rows)
+	return s.sendOrClose(proto.TypePTYResize, &proto.PTYResize{SessionID: s.ID, Cols: cols, Rows: rows})
+}
+
+// Close 关闭会话, 通知 agent 结束 shell 进程
+func (s *Session) Close(reason string) {
+	_ = s.mgr.send(s.AgentID, proto.TypePTYClose, &proto.PTYClose{SessionID: s.ID})
+	s.finish(reason, -1)
+}
+
+func (s *Session) sendOrClose(typ string, payload interface{}) error {
+	err := s.mgr.send(s.AgentID, typ, payload)
+	if errors.Is(err, agenthub.ErrAgentOffline) {
+		s.finish(ReasonAgentLost, -1)
+	}
+	return err
+}
+
+// finish 结束会话, 保存录像并更新数据库记录, 只会执行一次
+func (s *Session) finish(reason string, code int) {
+	s.closeOnce.Do(func() {
+		s.mgr.mu.Lock()
+		delete(s.mgr.active, s.ID)
+		s.mgr.mu.Unlock()
+
+		if err := s.recorder.Close(); err != nil {
+			logger.WithLabels("session_id", s.ID, "error", err).Error("cannot save terminal recording")
+		}
+		now := time.Now()
+		s.Status = core.TerminalStatusClosed
+		s.CloseReason = reason
+		s.ExitCode = code
+		s.EndTime = &now
+		if err := s.mgr.sessions.Update(context.Background(), s.TerminalSession); err != nil {
+			logger.WithLabels("session_id", s.ID, "error", err).Error("cannot update terminal session")
+		}
+		logger.WithLabels("session_id", s.ID, "reason", reason).Info("terminal session closed")
+		// 最后才关闭 done, 等待 done 的一方可以读到结束状态
+		close(s.done)
+	})
+}
+
+func (s *Session) watchIdle() {
+	ticker := time.NewTicker(s.mgr.idleTimeout / 10)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if time.Since(time.Unix(0, s.lastInput.Load())) > s.mgr.idleTimeout {
+				s.Close(ReasonIdleTimeout)
+				return
+			}
+		}
+	}
+}
+
+func (m *Manager) handleOutput(ctx context.Context, agentID int64, msg *proto.Message) error {
+	in := new(proto.PTYData)
+	if err := msg.Decode(in); err != nil {
+		return err
+	}
+	s := m.lookup(agentID, in.SessionID)
+	if s == nil {
+		return nil
+	}
+	_ = s.recorder.output(in.Data)
+	select {
+	case s.output <- in.Data:
+	case <-s.done:
+	default:
+		logger.WithLabels("session_id", s.ID, "buffer", outputBuffer).Warn("terminal client is too slow, session closed")
+		go s.Close(ReasonSlowClient)
+	}
+	return nil
+}
+
+func (m *Manager) handleExit(ctx context.Context, agentID int64, msg *proto.Message) error {
+	in := new(proto.PTYExit)
+	if err := msg.Decode(in); err != nil {
+		return err
+	}
+	if s := m.lookup(agentID, in.SessionID); s != nil {
+		s.finish(ReasonExited, in.Code)
+	}
+	return nil
+}
+
+// lookup 查找属于该 agent 的会话, 防止 agent 伪造其他主机的会话输出
+func (m *Manager) lookup(agentID int64, sessionID string) *Session {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.active[sessionID]
+	if !ok || s.AgentID != agentID {
+		return nil
+	}
+	return s
+}
+
+func (m *Manager) send(agentID int64, typ string, payload interface{}) error {
+	msg, err := proto.NewMessage(typ, payload)
+	if err != nil {
+		return err
+	}
+	return m.hub.Send(agentID, msg)
+}
@@ -18,6 +18,7 @@ const (
 1. 所有请求正常的API响应
 	http_code都为200; code都为10000;
 	不使用201(创建成功)、204(无响应内容,通常用来表示删除成功)此类的2xx的响应码,一律使用200;
+	唯一的例外是操作被接受但没有立即执行(例如需要审批), 此时 http_code 为202, code 为10001;
 	message字段在正常情况没有
 2. 所有请求失败的API响应
 	http_code都为4xx; code都为2000x;
@@ -28,6 +29,7 @@ const (
 */
 const (
 	SCodeOK                                 string = "200-10000"
+	SCodeAccepted                           string = "202-10001"
 	SCodeNotFoundWithDao                    string = "404-20001"
 	SCodeForbidden                          string = "403-20002"
 	SCodeUnauthenticateWithLogin            string = "401-20003"
@@ -54,16 +56,56 @@ const (
 	SCodeBadRequestWithArrayEmpty           string = "400-20024"
 	SCodeBadRequestWithDomainRe             string = "400-20025"
 	SCodeBadRequestWithDNSRecordNotEmpty    string = "400-20026"
+	SCodeUnauthenticateWithToken            string = "401-20027"
+	SCodeUnauthenticateWithAgentToken       string = "401-20028"
+	SCodeBadRequestWithAgentBinary          string = "400-20029"
+	SCodeBadRequestWithRolloutStages        string = "400-20030"
+	SCodeBadRequestWithRolloutConflict      string = "400-20031"
+	SCodeBadRequestWithNoUpgradableAgent    string = "400-20032"
+	SCodeBadRequestWithAgentOffline         string = "400-20033"
+	SCodeBadRequestWithGrant                string = "400-20034"
+	SCodeBadRequestWithMetricQuery          string = "400-20035"
+	SCodeBadRequestWithKubeCredential       string = "400-20036"
+	SCodeBadRequestWithKubeNamespace        string = "400-20037"
+	SCodeBadRequestWithQuotaTemplate        string = "400-20038"
+	SCodeBadRequestWithKubeWorkload         string = "400-20039"
+	SCodeNotFoundWithKubeObject             string = "404-20002"
+	SCodeBadRequestWithManifest             string = "400-20040"
+	SCodeBadRequestWithApplyPlan            string = "400-20041"
+	SCodeBadRequestWithApplication          string = "400-20042"
+	SCodeBadRequestWithRollback             string = "400-20043"
+	SCodeBadRequestWithKubeAccess           string = "400-20044"
+	SCodeBadRequestWithGitlab               string = "400-20045"
+	SCodeBadRequestWithJenkins              string = "400-20046"
+	SCodeUnauthenticateWithHookToken        string = "401-20047"
+	SCodeBadRequestWithHookPayload          string = "400-20048"
+	SCodeBadRequestWithPipeline             string = "400-20049"
+	SCodeBadRequestWithPipelineConflict     string = "400-20050"
+	SCodeBadRequestWithApprovalRule         string = "400-20051"
+	SCodeBadRequestWithApprovalClosed       string = "400-20052"
+	SCodeBadRequestWithApprovalPayload      string = "400-20053"
+	SCodeBadRequestWithNotifyChannel        string = "400-20054"
+	SCodeBadRequestWithNotifyTemplate       string = "400-20055"
+	SCodeBadRequestWithCMDBHook             string = "400-20056"
+	SCodeBadRequestWithDeliveryNotDead      string = "400-20057"
+	SCodeBadRequestWithCronJob              string = "400-20058"
+	SCodeBadRequestWithTask                 string = "400-20059"
 	SCodeInternalServerErrorWithDao         string = "500-30001"
 	SCodeInternalServerErrorWithPayloadBind string = "500-30002"
 	SCodeInternalServerErrorWithGenJwtToken string = "500-30004"
 	SCodeInternalServerErrorWithIPNetParse  string = "500-30003"
+	SCodeInternalServerErrorWithStorage     string = "500-30005"
+	SCodeInternalServerErrorWithWebsocket   string = "500-30006"
+	SCodeInternalServerErrorWithKubernetes  string = "500-30007"
+	SCodeInternalServerErrorWithGitlab      string = "500-30008"
+	SCodeInternalServerErrorWithJenkins     string = "500-30009"
 	SCodeUnknow                             string = "500-40001"
 )
 
 // Msg code 对应的 message
 var Msg = map[string]string{
 	SCodeOK:                                 "请求处理成功",
+	SCodeAccepted:                           "请求已接受, 等待审批后执行",
 	SCodeNotFoundWithDao:                    "数据库记录没找到",
 	SCodeForbidden:                          "没权限, 访问被拒绝",
 	SCodeUnauthenticateWithLogin:            "登录失败",
@@ -90,9 +132,48 @@ var Msg = map[string]string{
 	SCodeBadRequestWithSvcChildNodeEmpty:    "服务树子节点不为空",
 	SCodeBadRequestWithParentIDEmpty:        "父ID不能为空",
 	SCodeBadRequestWithArrayEmpty:           "数组为空, 或者数组中无可用对象",
+	SCodeUnauthenticateWithToken:            "未登录或者Token无效",
+	SCodeUnauthenticateWithAgentToken:       "agent令牌无效",
+	SCodeBadRequestWithAgentBinary:          "agent二进制文件无效, 必须提供version/os/arch和file",
+	SCodeBadRequestWithRolloutStages:        "灰度阶段不合法, 必须是递增并且以100结尾的百分比",
+	SCodeBadRequestWithRolloutConflict:      "已经存在进行中的灰度升级任务, 或者当前状态不允许该操作",
+	SCodeBadRequestWithNoUpgradableAgent:    "没有可以升级到目标版本的在线agent",
+	SCodeBadRequestWithAgentOffline:         "主机上没有在线的agent",
+	SCodeBadRequestWithGrant:                "授权记录不合法, 必须提供user_id/resource/resource_id/action",
+	SCodeBadRequestWithMetricQuery:          "指标查询参数错误",
+	SCodeBadRequestWithKubeCredential:       "集群认证信息错误",
+	SCodeBadRequestWithKubeNamespace:        "namespace 参数错误",
+	SCodeBadRequestWithQuotaTemplate:        "配额模板参数错误",
+	SCodeBadRequestWithKubeWorkload:         "不支持的工作负载类型或者操作",
+	SCodeNotFoundWithKubeObject:             "kubernetes 资源没找到",
+	SCodeBadRequestWithManifest:             "YAML 清单无法解析或者包含不支持的资源类型",
+	SCodeBadRequestWithApplyPlan:            "变更计划已过期、已执行或者集群中的对象已被修改, 请重新生成",
+	SCodeBadRequestWithApplication:          "应用或者环境的配置不合法",
+	SCodeBadRequestWithRollback:             "只能回滚到发布成功的版本",
+	SCodeBadRequestWithKubeAccess:           "集群授权的对象、角色或者方式不合法",
+	SCodeBadRequestWithGitlab:               "GitLab 连接的名称、地址或者令牌不合法, 或者连接下还有导入的项目",
+	SCodeBadRequestWithJenkins:              "Jenkins 服务器或者任务的配置不合法, 或者服务器下还有关联的任务",
+	SCodeUnauthenticateWithHookToken:        "webhook 令牌无效",
+	SCodeBadRequestWithHookPayload:          "webhook 请求体无法解析",
+	SCodeBadRequestWithPipeline:             "流水线配置不合法",
+	SCodeBadRequestWithPipelineConflict:     "流水线运行当前的状态不允许该操作",
+	SCodeBadRequestWithApprovalRule:         "审批规则不合法",
+	SCodeBadRequestWithApprovalClosed:       "审批请求已经结束",
+	SCodeBadRequestWithApprovalPayload:      "审批操作的参数不合法",
+	SCodeBadRequestWithNotifyChannel:        "通知渠道的类型或者地址不合法",
+	SCodeBadRequestWithNotifyTemplate:       "通知模板无法解析",
+	SCodeBadRequestWithCMDBHook:             "CMDB webhook 订阅不合法",
+	SCodeBadRequestWithDeliveryNotDead:      "只有死信列表中的投递可以重新投递",
+	SCodeBadRequestWithCronJob:              "定时任务不合法",
+	SCodeBadRequestWithTask:                 "后台任务参数不合法",
 	SCodeInternalServerErrorWithDao:         "dao操作失败, 请联系管理员",
 	SCodeInternalServerErrorWithPayloadBind: "JSON数据解析失败",
 	SCodeInternalServerErrorWithGenJwtToken: "生成JWT或者Token失败",
 	SCodeInternalServerErrorWithIPNetParse:  "CIDR解析错误",
+	SCodeInternalServerErrorWithStorage:     "文件存储失败",
+	SCodeInternalServerErrorWithWebsocket:   "websocket连接建立失败",
+	SCodeInternalServerErrorWithKubernetes:  "kubernetes 集群请求失败",
+	SCodeInternalServerErrorWithGitlab:      "GitLab 请求失败",
+	SCodeInternalServerErrorWithJenkins:     "Jenkins 请求失败",
 	SCodeUnknow:                             "未知错误, 请稍后重试",
 }
@@ -58,6 +58,17 @@ func RenderSuccess(writer http.ResponseWriter, request *http.Request, payload in
 	renderJSON(writer, request, StatusSuccess, SCodeOK, payload)
 }
 
+// RenderAccepted 返回202, 表示操作已经被接受但是没有立即执行, payload 通常是审批请求或者后台任务
+func RenderAccepted(writer http.ResponseWriter, request *http.Request, payload interface{}) {
+	ctx := request.Context()
+	requestID := middleware.GetRequestIDFromCtx(ctx)
+	zap.L().Named("default").WithOptions(zap.AddCallerSkip(0)).Info("",
+		zap.String("request_id", requestID),
+		zap.Any("payload", payload),
+	)
+	renderJSON(writer, request, StatusSuccess, SCodeAccepted, payload)
+}
+
 // RenderFail 返回失败数据
 func RenderFail(writer http.ResponseWriter, request *http.Request, code string) {
 	ctx := request.Context()
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// easynetes-agent 配置的默认值
+const (
+	DefaultAgentConfigFile      string        = "/etc/easynetes/agent.yaml"
+	DefaultAgentServer          string        = "http://127.0.0.1:8080"
+	DefaultAgentHeartbeatPeriod time.Duration = 30
+	DefaultAgentMetricsInterval time.Duration = 15
+	DefaultAgentMetricsBatch    int           = 4
+	DefaultAgentQueueDir        string        = "/var/lib/easynetes-agent/queue"
+	DefaultAgentQueueSize       int           = 10000
+	DefaultAgentStatusSocket    string        = "/run/easynetes-agent/agent.sock"
+)
+
+// AgentConfig easynetes-agent 的配置文件
+// HeartbeatInterval 和 MetricsInterval 单位为秒
+type AgentConfig struct {
+	Server            string        `yaml:"server" mapstructure:"server"`
+	Token             string        `yaml:"token" mapstructure:"token"`
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval" mapstructure:"heartbeat_interval"`
+	MetricsInterval   time.Duration `yaml:"metrics_interval" mapstructure:"metrics_interval"`
+	MetricsBatch      int           `yaml:"metrics_batch" mapstructure:"metrics_batch"`
+	QueueDir          string        `yaml:"queue_dir" mapstructure:"queue_dir"`
+	QueueSize         int           `yaml:"queue_size" mapstructure:"queue_size"`
+	StatusSocket      string        `yaml:"status_socket" mapstructure:"status_socket"`
+}
+
+// Load 加载配置文件, 文件不存在时只使用默认值
+func (config *AgentConfig) Load(configFile string) error {
+	data, err := os.ReadFile(configFile)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("config read error: %v", err)
+	}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return fmt.Errorf("config yaml unmarshal error: %s", err)
+	}
+	config.SetDefault()
+	return nil
+}
+
+// Save 将配置写入文件, 文件中包含令牌, 所以只允许所有者读写
+func (config *AgentConfig) Save(configFile string) error {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(configFile, data, 0o600)
+}
+
+// SetDefault 设置默认值
+func (config *AgentConfig) SetDefault() {
+	if config.Server == "" {
+		config.Server = DefaultAgentServer
+	}
+	if config.HeartbeatInterval == 0 {
+		config.HeartbeatInterval = DefaultAgentHeartbeatPeriod
+	}
+	if config.MetricsInterval == 0 {
+		config.MetricsInterval = DefaultAgentMetricsInterval
+	}
+	if config.MetricsBatch == 0 {
+		config.MetricsBatch = DefaultAgentMetricsBatch
+	}
+	if config.QueueDir == "" {
+		config.QueueDir = DefaultAgentQueueDir
+	}
+	if config.QueueSize == 0 {
+		config.QueueSize = DefaultAgentQueueSize
+	}
+	if config.StatusSocket == "" {
+		config.StatusSocket = DefaultAgentStatusSocket
+	}
+}
@@ -13,17 +13,71 @@ const (
 	DefaultTokenExpire     time.Duration = 3600
 	DefaultTokenToleration time.Duration = 1200
 	DefaultSecret          string        = "2YejrzYBZzr1An5QSkbB3vKiGQYmRGZyUSGugAub0a39QFdFg1DyFdtMbbIEAY94"
+	DefaultAgentHeartbeat  time.Duration = 90
+	DefaultAgentBinaryPath string        = "/var/lib/easynetes/agent"
+	DefaultRecordingPath   string        = "/var/lib/easynetes/recordings"
+	DefaultTerminalIdle    time.Duration = 900
+	DefaultMetricsRaw      time.Duration = 24
+	DefaultMetricsMinute   time.Duration = 24 * 7
+	DefaultMetricsHour     time.Duration = 24 * 90
+	DefaultKubeProbe       time.Duration = 60
+	DefaultKubeProbeTime   time.Duration = 10
+	DefaultKubeSync        time.Duration = 300
+	DefaultKubeCacheIdle   time.Duration = 600
+	DefaultKubePlanTTL     time.Duration = 900
+	DefaultKubeEvents      time.Duration = 24 * 30
+	DefaultKubeconfigTTL   time.Duration = 3600
+	DefaultGitlabRefresh   time.Duration = 600
+	DefaultGitlabTimeout   time.Duration = 15
+	DefaultJenkinsPoll     time.Duration = 5
+	DefaultJenkinsTimeout  time.Duration = 15
+	DefaultHookRetention   time.Duration = 24 * 30
+	DefaultPipelineSync    time.Duration = 5
+	DefaultStageTimeout    time.Duration = 1800
+	DefaultStageLease      time.Duration = 60
+	DefaultApprovalSync    time.Duration = 60
+	DefaultApprovalExpire  time.Duration = 24
+	DefaultNotifyInterval  time.Duration = 10
+	DefaultNotifyBackoff   time.Duration = 30
+	DefaultNotifyTimeout   time.Duration = 10
+	DefaultNotifyAttempts                = 6
+	DefaultCMDBHookSync    time.Duration = 10
+	DefaultCMDBHookBackoff time.Duration = 30
+	DefaultCMDBHookTimeout time.Duration = 10
+	DefaultCMDBHookTries                 = 8
+	DefaultStreamBuffer                  = 1000
+	DefaultCronInterval    time.Duration = 5
+	DefaultCronTimeout     time.Duration = 3600
+	DefaultQueueWorkers                  = 4
+	DefaultQueueInterval   time.Duration = 2
+	DefaultQueueVisibility time.Duration = 60
+	DefaultQueueBackoff    time.Duration = 10
+	DefaultQueueAttempts                 = 3
 )
 
 type (
 	// Config 配置文件
 	Config struct {
-		Logging  Logging
-		Server   Server
-		Database Database
-		Cache    Cache
-		Security Security
-		LDAP     LDAP
+		Logging    Logging
+		Server     Server
+		Database   Database
+		Cache      Cache
+		Security   Security
+		LDAP       LDAP
+		Agent      Agent
+		Terminal   Terminal
+		Metrics    Metrics
+		Kubernetes Kubernetes
+		Gitlab     Gitlab
+		Jenkins    Jenkins
+		Webhook    Webhook
+		Pipeline   Pipeline
+		Approval   Approval
+		Notify     Notify
+		CMDBHook   CMDBHook `yaml:"cmdb_hook" mapstructure:"cmdb_hook"`
+		Stream     Stream
+		Cron       Cron
+		Queue      Queue
 	}
 
 	// Logging 日志配置
@@ -72,12 +126,14 @@ type (
 	}
 
 	// Security 安全相关的配置
+	// SecretKey 用于 jwt 签名; EncryptionKey 用于加密保存在数据库中的凭证, 没有默认值, 未配置时 apiserver 拒绝启动
 	Security struct {
 		AdminUser           string        `yaml:"admin_user" mapstructure:"admin_user"`
 		AdminPassword       string        `yaml:"admin_password" mapstructure:"admin_password"`
 		AdminEmail          string        `yaml:"admin_email" mapstructure:"admin_email"`
 		AdminPhone          string        `yaml:"admin_phone" mapstructure:"admin_phone"`
 		SecretKey           string        `yaml:"secret_key" mapstructure:"secret_key"`
+		EncryptionKey       string        `yaml:"encryption_key" mapstructure:"encryption_key"`
 		TokenExpireTime     time.Duration `yaml:"token_expire_time" mapstructure:"token_expire_time"`
 		TokenTolerationTime time.Duration `yaml:"token_toleration_time" mapstructure:"token_toleration_time"`
 	}
@@ -95,6 +151,139 @@ type (
 		GroupSearchBaseDNS             string `yaml:"group_search_base_dns" mapstructure:"group_search_base_dns"`
 		GroupSearchFilterUserAttribute string `yaml:"group_search_filter_user_attribute" mapstructure:"group_search_filter_user_attribute"`
 	}
+
+	// Agent easynetes-agent 相关的配置
+	// Token 是 agent 连接 apiserver 使用的共享令牌; HeartbeatTimeout 单位为秒; BinaryPath 是上传的 agent 二进制文件存放目录
+	Agent struct {
+		Token            string        `yaml:"token" mapstructure:"token"`
+		HeartbeatTimeout time.Duration `yaml:"heartbeat_timeout" mapstructure:"heartbeat_timeout"`
+		BinaryPath       string        `yaml:"binary_path" mapstructure:"binary_path"`
+	}
+
+	// Terminal web 终端相关的配置
+	// RecordingPath 是终端录像的存放目录; IdleTimeout 单位为秒, 超过该时间没有输入的会话会被关闭
+	Terminal struct {
+		RecordingPath string        `yaml:"recording_path" mapstructure:"recording_path"`
+		IdleTimeout   time.Duration `yaml:"idle_timeout" mapstructure:"idle_timeout"`
+	}
+
+	// Metrics 主机监控指标相关的配置, 各精度数据的保留时间单位为小时
+	Metrics struct {
+		RawRetention    time.Duration `yaml:"raw_retention" mapstructure:"raw_retention"`
+		MinuteRetention time.Duration `yaml:"minute_retention" mapstructure:"minute_retention"`
+		HourRetention   time.Duration `yaml:"hour_retention" mapstructure:"hour_retention"`
+	}
+
+	// Kubernetes 纳管集群相关的配置, 时间单位均为秒
+	// SyncInterval 是将 easynetes 的标签同步到纳管 namespace 的间隔
+	// CacheIdle 是工作负载 informer 缓存在没有访问之后保留的时间
+	// PlanTTL 是 YAML 变更计划的有效期, 过期后需要重新生成
+	// EventRetention 是 Warning 事件的保留时间, 单位为小时
+	// KubeconfigTTL 是用户下载的 kubeconfig 中令牌的有效期, 不能小于 600
+	Kubernetes struct {
+		ProbeInterval  time.Duration `yaml:"probe_interval" mapstructure:"probe_interval"`
+		ProbeTimeout   time.Duration `yaml:"probe_timeout" mapstructure:"probe_timeout"`
+		SyncInterval   time.Duration `yaml:"sync_interval" mapstructure:"sync_interval"`
+		CacheIdle      time.Duration `yaml:"cache_idle" mapstructure:"cache_idle"`
+		PlanTTL        time.Duration `yaml:"plan_ttl" mapstructure:"plan_ttl"`
+		EventRetention time.Duration `yaml:"event_retention" mapstructure:"event_retention"`
+		KubeconfigTTL  time.Duration `yaml:"kubeconfig_ttl" mapstructure:"kubeconfig_ttl"`
+	}
+
+	// Gitlab GitLab 集成相关的配置, 时间单位均为秒
+	// RefreshInterval 是刷新已导入项目的分支和标签缓存的间隔
+	Gitlab struct {
+		RefreshInterval time.Duration `yaml:"refresh_interval" mapstructure:"refresh_interval"`
+		Timeout         time.Duration `yaml:"timeout" mapstructure:"timeout"`
+	}
+
+	// Jenkins Jenkins 集成相关的配置, 时间单位均为秒
+	// PollInterval 是轮询排队中和构建中的构建状态的间隔
+	Jenkins struct {
+		PollInterval time.Duration `yaml:"poll_interval" mapstructure:"poll_interval"`
+		Timeout      time.Duration `yaml:"timeout" mapstructure:"timeout"`
+	}
+
+	// Webhook 接收 GitLab 和 Jenkins 事件的配置
+	// GitlabToken/JenkinsToken 是校验请求的共享令牌, 为空时拒绝对应来源的所有请求; Retention 单位为小时
+	Webhook struct {
+		GitlabToken  string        `yaml:"gitlab_token" mapstructure:"gitlab_token"`
+		JenkinsToken string        `yaml:"jenkins_token" mapstructure:"jenkins_token"`
+		Retention    time.Duration `yaml:"retention" mapstructure:"retention"`
+	}
+
+	// Pipeline 流水线相关的配置, 时间单位均为秒
+	// Interval 是推进进行中的运行的间隔, StageTimeout 是阶段没有配置超时时间时的默认值
+	// Lease 是副本认领阶段的租约时长, 副本每个 Interval 续期一次, 需要大于 Interval
+	Pipeline struct {
+		Interval     time.Duration `yaml:"interval" mapstructure:"interval"`
+		StageTimeout time.Duration `yaml:"stage_timeout" mapstructure:"stage_timeout"`
+		Lease        time.Duration `yaml:"lease" mapstructure:"lease"`
+	}
+
+	// Approval 审批相关的配置
+	// Interval 是检查过期审批请求的间隔, 单位为秒; Expire 是规则没有配置过期时间时的默认值, 单位为小时
+	Approval struct {
+		Interval time.Duration `yaml:"interval" mapstructure:"interval"`
+		Expire   time.Duration `yaml:"expire" mapstructure:"expire"`
+	}
+
+	// Notify 通知相关的配置, 时间单位均为秒
+	// Interval 是发送到期通知的间隔; Backoff 是第一次重试前的等待时间, 之后每次翻倍, 最长一小时
+	// MaxAttempts 是每条通知最多尝试发送的次数; Timeout 是单次发送的超时时间
+	// SMTP 是邮件渠道使用的服务器, Host 为空时邮件渠道不可用
+	Notify struct {
+		Interval    time.Duration `yaml:"interval" mapstructure:"interval"`
+		Backoff     time.Duration `yaml:"backoff" mapstructure:"backoff"`
+		MaxAttempts int           `yaml:"max_attempts" mapstructure:"max_attempts"`
+		Timeout     time.Duration `yaml:"timeout" mapstructure:"timeout"`
+		SMTP        SMTP          `yaml:"smtp" mapstructure:"smtp"`
+	}
+
+	// CMDBHook CMDB 变更 webhook 相关的配置, 时间单位均为秒
+	// Interval 是投递到期事件的间隔; Backoff 是第一次重试前的等待时间, 之后每次翻倍, 最长一小时
+	// MaxAttempts 是每个事件最多投递的次数, 用完后进入死信列表; Timeout 是单次投递的超时时间
+	CMDBHook struct {
+		Interval    time.Duration `yaml:"interval" mapstructure:"interval"`
+		Backoff     time.Duration `yaml:"backoff" mapstructure:"backoff"`
+		MaxAttempts int           `yaml:"max_attempts" mapstructure:"max_attempts"`
+		Timeout     time.Duration `yaml:"timeout" mapstructure:"timeout"`
+	}
+
+	// Stream 资源变更 SSE 推送的配置, Buffer 是保存最近变更的数量, 客户端重连时只能补发缓冲区中的变更
+	Stream struct {
+		Buffer int `yaml:"buffer" mapstructure:"buffer"`
+	}
+
+	// Cron 定时任务相关的配置, 时间单位均为秒
+	// Interval 是检查到期任务的间隔; Timeout 是任务没有配置超时时间时的默认值
+	// Timezone 是任务没有配置时区时使用的时区, 例如 Asia/Shanghai, 为空时使用 UTC
+	Cron struct {
+		Interval time.Duration `yaml:"interval" mapstructure:"interval"`
+		Timeout  time.Duration `yaml:"timeout" mapstructure:"timeout"`
+		Timezone string        `yaml:"timezone" mapstructure:"timezone"`
+	}
+
+	// Queue 后台任务队列的配置, 时间单位均为秒
+	// Workers 是每个副本同时执行的任务数量; Interval 是没有空闲任务时检查新任务的间隔
+	// Visibility 是认领任务后的可见性超时, 执行中的任务会定期续期, 副本退出后超时的任务由其他副本重新认领
+	// Backoff 是第一次重试前的等待时间, 之后每次翻倍; MaxAttempts 是任务没有指定时最多执行的次数
+	Queue struct {
+		Workers     int           `yaml:"workers" mapstructure:"workers"`
+		Interval    time.Duration `yaml:"interval" mapstructure:"interval"`
+		Visibility  time.Duration `yaml:"visibility" mapstructure:"visibility"`
+		Backoff     time.Duration `yaml:"backoff" mapstructure:"backoff"`
+		MaxAttempts int           `yaml:"max_attempts" mapstructure:"max_attempts"`
+	}
+
+	// SMTP 发送邮件的服务器配置, Username 为空时不认证
+	SMTP struct {
+		Host     string `yaml:"host" mapstructure:"host"`
+		Port     int    `yaml:"port" mapstructure:"port"`
+		Username string `yaml:"username" mapstructure:"username"`
+		Password string `yaml:"password" mapstructure:"password"`
+		From     string `yaml:"from" mapstructure:"from"`
+	}
 )
 
 // String 将配置文件输出为字符串
@@ -124,6 +313,20 @@ func (config *Config) SetDefault() {
 	defaultTokenExpireTime(config)
 	defaultTokenTolerationTime(config)
 	defaultSecretKey(config)
+	defaultAgent(config)
+	defaultTerminal(config)
+	defaultMetrics(config)
+	defaultKubernetes(config)
+	defaultGitlab(config)
+	defaultJenkins(config)
+	defaultWebhook(config)
+	defaultPipeline(config)
+	defaultApproval(config)
+	defaultNotify(config)
+	defaultCMDBHook(config)
+	defaultStream(config)
+	defaultCron(config)
+	defaultQueue(config)
 }
 
 func defaultTokenExpireTime(cfg *Config) {
@@ -142,3 +345,168 @@ func defaultSecretKey(cfg *Config) {
 		cfg.Security.SecretKey = DefaultSecret
 	}
 }
+
+func defaultAgent(cfg *Config) {
+	if cfg.Agent.HeartbeatTimeout == 0 {
+		cfg.Agent.HeartbeatTimeout = DefaultAgentHeartbeat
+	}
+	if cfg.Agent.BinaryPath == "" {
+		cfg.Agent.BinaryPath = DefaultAgentBinaryPath
+	}
+}
+
+func defaultTerminal(cfg *Config) {
+	if cfg.Terminal.RecordingPath == "" {
+		cfg.Terminal.RecordingPath = DefaultRecordingPath
+	}
+	if cfg.Terminal.IdleTimeout == 0 {
+		cfg.Terminal.IdleTimeout = DefaultTerminalIdle
+	}
+}
+
+func defaultMetrics(cfg *Config) {
+	if cfg.Metrics.RawRetention == 0 {
+		cfg.Metrics.RawRetention = DefaultMetricsRaw
+	}
+	if cfg.Metrics.MinuteRetention == 0 {
+		cfg.Metrics.MinuteRetention = DefaultMetricsMinute
+	}
+	if cfg.Metrics.HourRetention == 0 {
+		cfg.Metrics.HourRetention = DefaultMetricsHour
+	}
+}
+
+func defaultKubernetes(cfg *Config) {
+	if cfg.Kubernetes.ProbeInterval == 0 {
+		cfg.Kubernetes.ProbeInterval = DefaultKubeProbe
+	}
+	if cfg.Kubernetes.ProbeTimeout == 0 {
+		cfg.Kubernetes.ProbeTimeout = DefaultKubeProbeTime
+	}
+	if cfg.Kubernetes.SyncInterval == 0 {
+		cfg.Kubernetes.SyncInterval = DefaultKubeSync
+	}
+	if cfg.Kubernetes.CacheIdle == 0 {
+		cfg.Kubernetes.CacheIdle = DefaultKubeCacheIdle
+	}
+	if cfg.Kubernetes.PlanTTL == 0 {
+		cfg.Kubernetes.PlanTTL = DefaultKubePlanTTL
+	}
+	if cfg.Kubernetes.EventRetention == 0 {
+		cfg.Kubernetes.EventRetention = DefaultKubeEvents
+	}
+	if cfg.Kubernetes.KubeconfigTTL == 0 {
+		cfg.Kubernetes.KubeconfigTTL = DefaultKubeconfigTTL
+	}
+}
+
+func defaultGitlab(cfg *Config) {
+	if cfg.Gitlab.RefreshInterval == 0 {
+		cfg.Gitlab.RefreshInterval = DefaultGitlabRefresh
+	}
+	if cfg.Gitlab.Timeout == 0 {
+		cfg.Gitlab.Timeout = DefaultGitlabTimeout
+	}
+}
+
+func defaultJenkins(cfg *Config) {
+	if cfg.Jenkins.PollInterval == 0 {
+		cfg.Jenkins.PollInterval = DefaultJenkinsPoll
+	}
+	if cfg.Jenkins.Timeout == 0 {
+		cfg.Jenkins.Timeout = DefaultJenkinsTimeout
+	}
+}
+
+func defaultWebhook(cfg *Config) {
+	if cfg.Webhook.Retention == 0 {
+		cfg.Webhook.Retention = DefaultHookRetention
+	}
+}
+
+func defaultPipeline(cfg *Config) {
+	if cfg.Pipeline.Interval == 0 {
+		cfg.Pipeline.Interval = DefaultPipelineSync
+	}
+	if cfg.Pipeline.StageTimeout == 0 {
+		cfg.Pipeline.StageTimeout = DefaultStageTimeout
+	}
+	if cfg.Pipeline.Lease == 0 {
+		cfg.Pipeline.Lease = DefaultStageLease
+	}
+}
+
+func defaultApproval(cfg *Config) {
+	if cfg.Approval.Interval == 0 {
+		cfg.Approval.Interval = DefaultApprovalSync
+	}
+	if cfg.Approval.Expire == 0 {
+		cfg.Approval.Expire = DefaultApprovalExpire
+	}
+}
+
+func defaultNotify(cfg *Config) {
+	if cfg.Notify.Interval == 0 {
+		cfg.Notify.Interval = DefaultNotifyInterval
+	}
+	if cfg.Notify.Backoff == 0 {
+		cfg.Notify.Backoff = DefaultNotifyBackoff
+	}
+	if cfg.Notify.MaxAttempts == 0 {
+		cfg.Notify.MaxAttempts = DefaultNotifyAttempts
+	}
+	if cfg.Notify.Timeout == 0 {
+		cfg.Notify.Timeout = DefaultNotifyTimeout
+	}
+	if cfg.Notify.SMTP.Port == 0 {
+		cfg.Notify.SMTP.Port = 25
+	}
+}
+
+func defaultCMDBHook(cfg *Config) {
+	if cfg.CMDBHook.Interval == 0 {
+		cfg.CMDBHook.Interval = DefaultCMDBHookSync
+	}
+	if cfg.CMDBHook.Backoff == 0 {
+		cfg.CMDBHook.Backoff = DefaultCMDBHookBackoff
+	}
+	if cfg.CMDBHook.MaxAttempts == 0 {
+		cfg.CMDBHook.MaxAttempts = DefaultCMDBHookTries
+	}
+	if cfg.CMDBHook.Timeout == 0 {
+		cfg.CMDBHook.Timeout = DefaultCMDBHookTimeout
+	}
+}
+
+func defaultStream(cfg *Config) {
+	if cfg.Stream.Buffer == 0 {
+		cfg.Stream.Buffer = DefaultStreamBuffer
+	}
+}
+
+func defaultCron(cfg *Config) {
+	if cfg.Cron.Interval == 0 {
+		cfg.Cron.Interval = DefaultCronInterval
+	}
+	if cfg.Cron.Timeout == 0 {
+		cfg.Cron.Timeout = DefaultCronTimeout
+	}
+}
+
+func defaultQueue(cfg *Config) {
+	if cfg.Queue.Workers == 0 {
+		cfg.Queue.Workers = DefaultQueueWorkers
+	}
+	if cfg.Queue.Interval == 0 {
+		cfg.Queue.Interval = DefaultQueueInterval
+	}
+	if cfg.Queue.Visibility == 0 {
+		cfg.Queue.Visibility = DefaultQueueVisibility
+	}
+	if cfg.Queue.Backoff == 0 {
+		cfg.Queue.Backoff = DefaultQueueBackoff
+	}
+	if cfg.Queue.MaxAttempts == 0 {
+		cfg.Queue.MaxAttempts = DefaultQueueAttempts
+	}
+}
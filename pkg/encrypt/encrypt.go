@@ -0,0 +1,62 @@
+// Package encrypt 使用 AES-GCM 加密保存在数据库中的敏感数据, 例如 kubeconfig 和访问令牌
+package encrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+)
+
+var (
+	// ErrCiphertext 密文被篡改或者使用了不同的密钥
+	ErrCiphertext = errors.New("encrypt: invalid ciphertext")
+	// ErrEmptySecret 没有配置密钥
+	ErrEmptySecret = errors.New("encrypt: secret is required")
+)
+
+// Encrypter 对称加密器, 密文格式为 nonce + ciphertext
+type Encrypter struct {
+	aead cipher.AEAD
+}
+
+// New 使用 secret 的 sha256 摘要作为 AES-256 密钥创建加密器, secret 不能为空
+func New(secret string) (*Encrypter, error) {
+	if secret == "" {
+		return nil, ErrEmptySecret
+	}
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Encrypter{aead: aead}, nil
+}
+
+// Encrypt 加密明文, 每次加密使用随机的 nonce
+func (e *Encrypter) Encrypt(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, e.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return e.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Decrypt 解密 Encrypt 生成的密文
+func (e *Encrypter) Decrypt(ciphertext []byte) ([]byte, error) {
+	size := e.aead.NonceSize()
+	if len(ciphertext) < size {
+		return nil, ErrCiphertext
+	}
+	out, err := e.aead.Open(nil, ciphertext[:size], ciphertext[size:], nil)
+	if err != nil {
+		return nil, ErrCiphertext
+	}
+	return out, nil
+}
@@ -0,0 +1,30 @@
+package encrypt
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncrypt(t *testing.T) {
+	e, _ := New("secret")
+	plaintext := []byte("apiVersion: v1\nkind: Config\n")
+	ciphertext, err := e.Encrypt(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(ciphertext, plaintext) {
+		t.Fatal("ciphertext contains plaintext")
+	}
+	out, err := e.Decrypt(ciphertext)
+	if err != nil || !bytes.Equal(out, plaintext) {
+		t.Fatalf("Decrypt() = %q, %v", out, err)
+	}
+
+	other, _ := New("other")
+	if _, err := other.Decrypt(ciphertext); err != ErrCiphertext {
+		t.Fatalf("Decrypt() with another key = %v, want ErrCiphertext", err)
+	}
+	if _, err := New(""); err != ErrEmptySecret {
+		t.Fatalf("New(\"\") = %v, want ErrEmptySecret", err)
+	}
+}